@@ -0,0 +1,32 @@
+//go:build linux
+
+package zerousb
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// nodeOwner returns the permissions of a device node and the names of the user
+// and group owning it, falling back to the numeric ids if they aren't known.
+func nodeOwner(node string) (os.FileMode, string) {
+	info, err := os.Stat(node)
+	if err != nil {
+		return 0, ""
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.Mode(), ""
+	}
+	owner := strconv.FormatUint(uint64(stat.Uid), 10)
+	if u, err := user.LookupId(owner); err == nil {
+		owner = u.Username
+	}
+	group := strconv.FormatUint(uint64(stat.Gid), 10)
+	if g, err := user.LookupGroupId(group); err == nil {
+		group = g.Name
+	}
+	return info.Mode(), owner + ":" + group
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package zerousb
+
+import "os"
+
+// nodeOwner is only implemented on Linux, the only platform device nodes are
+// reported on.
+func nodeOwner(node string) (os.FileMode, string) {
+	return 0, ""
+}
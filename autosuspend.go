@@ -0,0 +1,32 @@
+package zerousb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// setAutoSuspend configures the runtime power management of the Linux kernel
+// through the power attributes of a USB device in sysfs. Enabling writes the
+// idle delay before handing control to the kernel, so the device isn't
+// suspended early with a stale delay.
+func setAutoSuspend(path string, enable bool, delay time.Duration) error {
+	if delay < 0 {
+		return fmt.Errorf("failed to set autosuspend: negative delay %v: %w", delay, ErrInvalidParam)
+	}
+	control := "on"
+	if enable {
+		control = "auto"
+
+		ms := strconv.FormatInt(delay.Milliseconds(), 10)
+		if err := os.WriteFile(filepath.Join(path, "power", "autosuspend_delay_ms"), []byte(ms), 0); err != nil {
+			return fmt.Errorf("failed to set autosuspend delay: %w", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(path, "power", "control"), []byte(control), 0); err != nil {
+		return fmt.Errorf("failed to set autosuspend: %w", err)
+	}
+	return nil
+}
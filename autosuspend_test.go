@@ -0,0 +1,49 @@
+package zerousb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Tests that autosuspend is configured via the sysfs power attributes, with the
+// delay only touched when enabling.
+func TestSetAutoSuspend(t *testing.T) {
+	tests := []struct {
+		enable  bool
+		delay   time.Duration
+		control string
+		ms      string
+		fail    bool
+	}{
+		{true, 2 * time.Second, "auto", "2000", false},
+		{true, 0, "auto", "0", false},
+		{false, time.Second, "on", "", false},
+		{true, -time.Second, "", "", true},
+	}
+	for i, tt := range tests {
+		dir := t.TempDir()
+		if err := os.Mkdir(filepath.Join(dir, "power"), 0o755); err != nil {
+			t.Fatalf("failed to create power dir: %v", err)
+		}
+		for _, attr := range []string{"control", "autosuspend_delay_ms"} {
+			if err := os.WriteFile(filepath.Join(dir, "power", attr), nil, 0o644); err != nil {
+				t.Fatalf("failed to create %s: %v", attr, err)
+			}
+		}
+		err := setAutoSuspend(dir, tt.enable, tt.delay)
+		if (err != nil) != tt.fail {
+			t.Errorf("test %d: failure mismatch: have %v, want failure %v", i, err, tt.fail)
+			continue
+		}
+		control, _ := os.ReadFile(filepath.Join(dir, "power", "control"))
+		if string(control) != tt.control {
+			t.Errorf("test %d: control mismatch: have %q, want %q", i, control, tt.control)
+		}
+		ms, _ := os.ReadFile(filepath.Join(dir, "power", "autosuspend_delay_ms"))
+		if string(ms) != tt.ms {
+			t.Errorf("test %d: delay mismatch: have %q, want %q", i, ms, tt.ms)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package zerousb
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Backend is a transport devices are reached through in place of the one the
+// package was built with, e.g. a simulator, a test fixture or a bridge to
+// devices attached elsewhere. Backends are registered by RegisterBackend and
+// put in use by UseBackend, after which the package level functions work the
+// same as with the built-in transports. Its methods are never called
+// concurrently.
+type Backend interface {
+	// Enumerate returns the device interfaces accepted by match, HID ones only
+	// if hid is set. Paths identify the device before the first colon, the
+	// interface after it, as the ones of the built-in backends.
+	Enumerate(match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error)
+
+	// Open connects to an interface returned by Enumerate, claiming it. The
+	// transfers go through the returned device.
+	Open(info DeviceInfo) (Device, error)
+
+	// Hotplug registers a handler to be notified of device arrivals and
+	// departures, returning the function unregistering it. Transports
+	// without notifications return an error matching ErrNotSupported.
+	Hotplug(handler HotplugHandler) (func(), error)
+
+	// Close releases the resources of the backend when switching away from it
+	// or on Exit.
+	Close() error
+}
+
+var (
+	backendFactories = make(map[string]func() (Backend, error)) // Registered backends by name
+	backendName      string                                     // Registered backend in use, empty for the built-in one
+	backendInstance  Backend                                    // Backend in use, created on demand
+)
+
+// RegisterBackend makes a backend available to UseBackend under a name, created
+// by the factory when put in use. Registering a name again replaces the
+// factory. The empty name is reserved for the built-in backend.
+func RegisterBackend(name string, factory func() (Backend, error)) {
+	if name == "" {
+		panic("usb: backend registered without a name")
+	}
+	lock.Lock()
+	defer lock.Unlock()
+
+	backendFactories[name] = factory
+}
+
+// Backends returns the names of the registered backends, sorted.
+func Backends() []string {
+	lock.Lock()
+	defer lock.Unlock()
+
+	names := make([]string, 0, len(backendFactories))
+	for name := range backendFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UseBackend routes the enumeration, opening and hotplug notifications through
+// the backend registered under a name, the empty name switching back to the
+// built-in one. The previous backend is closed, devices opened through it
+// remain bound to it though. Functions specific to the built-in backends
+// (OpenPath, OpenFromFD, Topology and the hub port power control) fail with
+// ErrNotSupported meanwhile.
+func UseBackend(name string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, ok := backendFactories[name]; !ok && name != "" {
+		return fmt.Errorf("failed to use backend %s: %w", name, ErrNotFound)
+	}
+	if name == backendName {
+		return nil
+	}
+	if err := closeBackend(); err != nil {
+		return err
+	}
+	backendName = name
+	return nil
+}
+
+// registered returns the registered backend in use, creating it if needed, or
+// nil if the built-in one is in use.
+func registered() (Backend, error) {
+	if backendName == "" {
+		return nil, nil
+	}
+	if backendInstance == nil {
+		b, err := backendFactories[backendName]()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create backend %s: %w", backendName, err)
+		}
+		backendInstance = b
+	}
+	return backendInstance, nil
+}
+
+// closeBackend closes the registered backend in use, if it was created, to be
+// created again on demand.
+func closeBackend() error {
+	if backendInstance == nil {
+		return nil
+	}
+	b := backendInstance
+	backendInstance = nil
+	if err := b.Close(); err != nil {
+		return fmt.Errorf("failed to close backend %s: %w", backendName, err)
+	}
+	return nil
+}
+
+// builtinOnly fails operations only the built-in backends support while a
+// registered one is in use.
+func builtinOnly(op string) error {
+	if backendName != "" {
+		return fmt.Errorf("failed to %s: %w", op, ErrNotSupported)
+	}
+	return nil
+}
+
+// enumerate lists the interfaces accepted by match through the backend in use.
+// Infos of registered backends are tagged with its name, so they're only ever
+// matched with infos of the same backend.
+func enumerate(match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	b, err := registered()
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return getAllDevices(match, hid)
+	}
+	name := backendName
+	infos, err := b.Enumerate(func(info DeviceInfo) bool {
+		info.backend = name
+		return match(info)
+	}, hid)
+	if err != nil {
+		return nil, err
+	}
+	for i := range infos {
+		infos[i].backend = name
+	}
+	return infos, nil
+}
+
+// find lists the interfaces of the devices with the given vendor and product
+// IDs through the backend in use, zero matching any.
+func find(vendorID ID, productID ID, hid bool) ([]DeviceInfo, error) {
+	if backendName == "" {
+		return findDevices(vendorID, productID, hid)
+	}
+	return enumerate(matchIDs(vendorID, productID), hid)
+}
+
+// list lists the devices accepted by match through the backend in use.
+func list(match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	if backendName == "" {
+		return listDevices(match, hid)
+	}
+	return listByInterface(match, hid)
+}
+
+// interfaces resolves the interfaces of a listed device through the backend it
+// was listed by.
+func interfaces(info DeviceInfo, hid bool) ([]DeviceInfo, error) {
+	if info.backend == "" {
+		return describeInterfaces(info, hid)
+	}
+	return interfacesOf(info, hid)
+}
+
+// openInfo opens the interface of a resolved info through the backend in use.
+func openInfo(info DeviceInfo, options *openOptions) (Device, error) {
+	if info.backend != backendName {
+		return nil, fmt.Errorf("failed to open device: %s not enumerated by the backend in use: %w", info.Path, ErrNoDevice)
+	}
+	b, err := registered()
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		if err := checkDriver(info); err != nil {
+			return nil, err
+		}
+		return open(info, options)
+	}
+	return b.Open(info)
+}
+
+// hotplug registers a hotplug handler with the backend in use.
+func hotplug(handler HotplugHandler) (func(), error) {
+	b, err := registered()
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return onHotplug(handler)
+	}
+	name := backendName
+	return b.Hotplug(func(event HotplugEvent, info DeviceInfo) {
+		info.backend = name
+		handler(event, info)
+	})
+}
@@ -0,0 +1,94 @@
+package zerousb
+
+import (
+	"errors"
+	"testing"
+)
+
+// fakeBackend serves a fixed set of interfaces, recording the opened ones.
+type fakeBackend struct {
+	ifaces []DeviceInfo
+	opened []string
+	closed bool
+}
+
+func (b *fakeBackend) Enumerate(match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	var infos []DeviceInfo
+	for _, info := range b.ifaces {
+		if match(info) {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+func (b *fakeBackend) Open(info DeviceInfo) (Device, error) {
+	b.opened = append(b.opened, info.Path)
+	return &optionDevice{}, nil
+}
+
+func (b *fakeBackend) Hotplug(handler HotplugHandler) (func(), error) {
+	return nil, ErrNotSupported
+}
+
+func (b *fakeBackend) Close() error {
+	b.closed = true
+	return nil
+}
+
+// Tests that a registered backend in use serves the enumeration and opening of
+// devices, and is closed when switching back to the built-in one.
+func TestRegisterBackend(t *testing.T) {
+	backend := &fakeBackend{ifaces: []DeviceInfo{
+		{Path: "1-2:0", VendorID: 0x1d6b, ProductID: 0x0104, Bus: 1, Interface: 0},
+		{Path: "1-2:1", VendorID: 0x1d6b, ProductID: 0x0104, Bus: 1, Interface: 1},
+		{Path: "1-3:0", VendorID: 0x1d6b, ProductID: 0x0105, Bus: 1, Interface: 0},
+	}}
+	RegisterBackend("fake", func() (Backend, error) { return backend, nil })
+	t.Cleanup(func() {
+		UseBackend("")
+		lock.Lock()
+		delete(backendFactories, "fake")
+		lock.Unlock()
+	})
+	if err := UseBackend("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unregistered backend error mismatch: have %v, want %v", err, ErrNotFound)
+	}
+	if err := UseBackend("fake"); err != nil {
+		t.Fatalf("failed to use backend: %v", err)
+	}
+	if v := Version(); v.Backend != "fake" || v.Libusb != "" {
+		t.Errorf("version mismatch: have %+v", v)
+	}
+	if _, err := Topology(); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("topology error mismatch: have %v, want %v", err, ErrNotSupported)
+	}
+	infos, err := Find(0x1d6b, 0x0104)
+	if err != nil || len(infos) != 2 {
+		t.Fatalf("enumeration mismatch: have %v, %v", infos, err)
+	}
+	devices, err := ListDevices(func(DeviceInfo) bool { return true })
+	if err != nil || len(devices) != 2 || devices[0].Path != "1-2" {
+		t.Fatalf("listing mismatch: have %v, %v", devices, err)
+	}
+	if ifaces, err := devices[0].Interfaces(); err != nil || len(ifaces) != 2 {
+		t.Errorf("interfaces mismatch: have %v, %v", ifaces, err)
+	}
+	for _, info := range []DeviceInfo{devices[1], infos[1]} {
+		if _, err := Open(info, WithControlTimeout(0)); err != nil {
+			t.Fatalf("failed to open %s: %v", info.Path, err)
+		}
+	}
+	if want := []string{"1-3:0", "1-2:1"}; len(backend.opened) != 2 || backend.opened[0] != want[0] || backend.opened[1] != want[1] {
+		t.Errorf("opened interfaces mismatch: have %v, want %v", backend.opened, want)
+	}
+	if err := UseBackend(""); err != nil {
+		t.Fatalf("failed to switch back: %v", err)
+	}
+	if !backend.closed {
+		t.Errorf("backend not closed")
+	}
+	if _, err := Open(infos[0]); !errors.Is(err, ErrNoDevice) {
+		t.Errorf("foreign info error mismatch: have %v, want %v", err, ErrNoDevice)
+	}
+}
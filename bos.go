@@ -0,0 +1,82 @@
+package zerousb
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Device capability types found in a Binary Object Store, see USB 3.x spec
+// section 9.6.2.
+const (
+	CapabilityWirelessUSB   = 0x01
+	CapabilityUSB2Extension = 0x02
+	CapabilitySuperSpeed    = 0x03
+	CapabilityContainerID   = 0x04
+)
+
+// BOSCapability is a single raw device capability of a Binary Object Store.
+type BOSCapability struct {
+	Type uint8  // bDevCapabilityType
+	Data []byte // Capability specific payload following the type byte
+}
+
+// USB2Extension is the decoded USB 2.0 Extension capability.
+type USB2Extension struct {
+	Attributes   uint32 // Raw bmAttributes bitmap
+	LPMSupported bool   // Link Power Management support
+}
+
+// SuperSpeedCapability is the decoded SuperSpeed USB device capability.
+type SuperSpeedCapability struct {
+	Attributes           uint8  // Raw bmAttributes bitmap
+	SpeedsSupported      uint16 // Bitmap of supported speeds (low, full, high, super)
+	FunctionalitySupport uint8  // Lowest speed at which all functionality is available
+	U1ExitLatency        uint8  // U1 device exit latency in microseconds
+	U2ExitLatency        uint16 // U2 device exit latency in microseconds
+}
+
+// BOSDescriptor contains the Binary Object Store of a USB 2.1+ device along
+// with the decoded well-known capabilities.
+type BOSDescriptor struct {
+	Capabilities []BOSCapability
+
+	USB2Extension *USB2Extension        // Nil if the device doesn't advertise one
+	SuperSpeed    *SuperSpeedCapability // Nil if the device doesn't advertise one
+	ContainerID   []byte                // 16 byte UUID, nil if not advertised
+}
+
+// parseBOS decodes the well-known capabilities out of the raw ones.
+func parseBOS(caps []BOSCapability) (*BOSDescriptor, error) {
+	bos := &BOSDescriptor{Capabilities: caps}
+	for _, c := range caps {
+		switch c.Type {
+		case CapabilityUSB2Extension:
+			if len(c.Data) < 4 {
+				return nil, fmt.Errorf("short usb 2.0 extension capability: %d bytes", len(c.Data))
+			}
+			attrs := binary.LittleEndian.Uint32(c.Data)
+			bos.USB2Extension = &USB2Extension{
+				Attributes:   attrs,
+				LPMSupported: attrs&0x02 != 0,
+			}
+		case CapabilitySuperSpeed:
+			if len(c.Data) < 7 {
+				return nil, fmt.Errorf("short superspeed capability: %d bytes", len(c.Data))
+			}
+			bos.SuperSpeed = &SuperSpeedCapability{
+				Attributes:           c.Data[0],
+				SpeedsSupported:      binary.LittleEndian.Uint16(c.Data[1:]),
+				FunctionalitySupport: c.Data[3],
+				U1ExitLatency:        c.Data[4],
+				U2ExitLatency:        binary.LittleEndian.Uint16(c.Data[5:]),
+			}
+		case CapabilityContainerID:
+			// First byte is bReserved, followed by the UUID
+			if len(c.Data) < 17 {
+				return nil, fmt.Errorf("short container id capability: %d bytes", len(c.Data))
+			}
+			bos.ContainerID = append([]byte(nil), c.Data[1:17]...)
+		}
+	}
+	return bos, nil
+}
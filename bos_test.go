@@ -0,0 +1,32 @@
+package zerousb
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Tests that the well-known BOS capabilities are decoded from raw ones.
+func TestParseBOS(t *testing.T) {
+	uuid := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	caps := []BOSCapability{
+		{Type: CapabilityUSB2Extension, Data: []byte{0x06, 0, 0, 0}},
+		{Type: CapabilitySuperSpeed, Data: []byte{0x00, 0x0e, 0x00, 0x01, 0x0a, 0xff, 0x07}},
+		{Type: CapabilityContainerID, Data: append([]byte{0}, uuid...)},
+	}
+	bos, err := parseBOS(caps)
+	if err != nil {
+		t.Fatalf("failed to parse bos: %v", err)
+	}
+	if bos.USB2Extension == nil || !bos.USB2Extension.LPMSupported {
+		t.Errorf("usb 2.0 extension mismatch: %+v", bos.USB2Extension)
+	}
+	if bos.SuperSpeed == nil || bos.SuperSpeed.SpeedsSupported != 0x0e || bos.SuperSpeed.U2ExitLatency != 0x07ff {
+		t.Errorf("superspeed capability mismatch: %+v", bos.SuperSpeed)
+	}
+	if !bytes.Equal(bos.ContainerID, uuid) {
+		t.Errorf("container id mismatch: have %x, want %x", bos.ContainerID, uuid)
+	}
+	if _, err := parseBOS([]BOSCapability{{Type: CapabilitySuperSpeed, Data: []byte{0}}}); err == nil {
+		t.Errorf("short capability accepted")
+	}
+}
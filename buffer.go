@@ -0,0 +1,33 @@
+package zerousb
+
+// Buffer is a transfer buffer acquired from a device through AcquireBuffer.
+// Where the backend supports it, the buffer is allocated from memory the kernel
+// transfers from directly (usbfs device memory on Linux), so reading into or
+// writing from its Bytes avoids copying the data at all.
+//
+// Buffers must be released before the device is closed, and not be used after
+// being released.
+type Buffer struct {
+	data    []byte
+	release func()
+}
+
+// Bytes returns the memory of the buffer.
+func (b *Buffer) Bytes() []byte {
+	return b.data
+}
+
+// Release hands the buffer back to the device for reuse.
+func (b *Buffer) Release() {
+	if b.release != nil {
+		b.release()
+		b.release = nil
+	}
+	b.data = nil
+}
+
+// NewBuffer allocates a buffer in Go memory, for Device implementations without
+// a buffer pool of their own.
+func NewBuffer(size int) *Buffer {
+	return &Buffer{data: make([]byte, size)}
+}
@@ -0,0 +1,163 @@
+//go:build (freebsd && cgo) || (linux && cgo) || (openbsd && cgo) || (netbsd && cgo) || (darwin && !ios && cgo && !iokit) || (windows && cgo && !winusb)
+
+package zerousb
+
+/*
+	#include <stdlib.h>
+	#include <libusb.h>
+*/
+import "C"
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// minBufferSize is the smallest buffer handed out by the pool, buffers being
+// rounded up to powers of two from it so they can be recycled across sizes.
+const minBufferSize = 4096
+
+// maxPoolCache is the most memory a pool keeps cached in released blocks. Device
+// memory counts against usbfs_memory_mb, 16 MiB shared by all devices of the
+// system by default, so blocks beyond it are freed as soon as they're released.
+const maxPoolCache = 1 << 20
+
+// maxStageSize is the largest transfer staged through device memory. Larger
+// ones are left to the kernel, which would otherwise see its usbfs memory
+// budget pinned by the pools of a few devices.
+const maxStageSize = 64 << 10
+
+// poolBlock is a buffer allocated by a pool.
+type poolBlock struct {
+	ptr  *C.uchar
+	size int
+	dma  bool // Allocated from device memory rather than the C heap
+}
+
+// bytes returns the memory of the block as a slice.
+func (b *poolBlock) bytes() []byte {
+	return unsafe.Slice((*byte)(unsafe.Pointer(b.ptr)), b.size)
+}
+
+// bufferPool recycles the transfer buffers of a device. Buffers are allocated
+// with libusb_dev_mem_alloc when the platform supports it, which maps memory
+// the kernel can transfer from without copying, otherwise from the C heap so
+// they may stay queued in asynchronous transfers.
+type bufferPool struct {
+	handle *C.struct_libusb_device_handle
+	free   map[int][]*poolBlock // Released blocks by size
+	used   map[uintptr]*poolBlock
+	cached int  // Total size of the released blocks
+	nodma  bool // Whether device memory allocation failed before
+	lock   sync.Mutex
+}
+
+// newBufferPool creates a pool allocating for the given device handle, probing
+// device memory support with a first block.
+func newBufferPool(handle *C.struct_libusb_device_handle) *bufferPool {
+	p := &bufferPool{
+		handle: handle,
+		free:   make(map[int][]*poolBlock),
+		used:   make(map[uintptr]*poolBlock),
+	}
+	p.put(p.get(minBufferSize))
+	return p
+}
+
+// get returns a block of at least the given size, reusing a released one if
+// possible.
+func (p *bufferPool) get(size int) *poolBlock {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	rounded := minBufferSize
+	for rounded < size {
+		rounded <<= 1
+	}
+	var block *poolBlock
+	if blocks := p.free[rounded]; len(blocks) > 0 {
+		block, p.free[rounded] = blocks[len(blocks)-1], blocks[:len(blocks)-1]
+		p.cached -= rounded
+	} else {
+		block = &poolBlock{size: rounded}
+		if !p.nodma {
+			if block.ptr = C.libusb_dev_mem_alloc(p.handle, C.size_t(rounded)); block.ptr == nil {
+				p.nodma = true
+			}
+		}
+		if block.dma = block.ptr != nil; !block.dma {
+			block.ptr = (*C.uchar)(C.malloc(C.size_t(rounded)))
+		}
+	}
+	p.used[uintptr(unsafe.Pointer(block.ptr))] = block
+	return block
+}
+
+// put hands a block back to the pool. Blocks returned after the pool was
+// drained or which would grow the cache beyond its limit are freed right away.
+func (p *bufferPool) put(block *poolBlock) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.used, uintptr(unsafe.Pointer(block.ptr)))
+	if p.handle == nil || p.cached+block.size > maxPoolCache {
+		block.release(p.handle)
+		return
+	}
+	p.free[block.size] = append(p.free[block.size], block)
+	p.cached += block.size
+}
+
+// owns reports whether b lies within device memory handed out by the pool, in
+// which case it can be transferred from directly.
+func (p *bufferPool) owns(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	start := uintptr(unsafe.Pointer(&b[0]))
+	for base, block := range p.used {
+		if block.dma && start >= base && start+uintptr(len(b)) <= base+uintptr(block.size) {
+			return true
+		}
+	}
+	return false
+}
+
+// dma reports whether the pool allocates from device memory.
+func (p *bufferPool) dma() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return !p.nodma
+}
+
+// drain frees the released blocks, must be called before closing the handle.
+// Blocks still in use are freed when put back, but device memory can't be
+// unmapped anymore by then.
+func (p *bufferPool) drain() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, blocks := range p.free {
+		for _, block := range blocks {
+			block.release(p.handle)
+		}
+	}
+	p.free = make(map[int][]*poolBlock)
+	p.cached = 0
+	p.handle = nil
+}
+
+// release frees the memory of a block, device memory only if the handle it was
+// allocated for is still open.
+func (b *poolBlock) release(handle *C.struct_libusb_device_handle) {
+	switch {
+	case !b.dma:
+		C.free(unsafe.Pointer(b.ptr))
+	case handle != nil:
+		C.libusb_dev_mem_free(handle, b.ptr, C.size_t(b.size))
+	}
+}
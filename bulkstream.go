@@ -0,0 +1,39 @@
+package zerousb
+
+import "fmt"
+
+// BulkStream transfers through one of the USB 3.0 bulk streams multiplexed
+// over a bulk endpoint, as used by UASP storage devices to tag the data of
+// each queued command. Transfers on different streams may run concurrently.
+type BulkStream struct {
+	Endpoint uint8  // Address of the bulk endpoint, direction bit included
+	ID       uint32 // Stream id, starting from 1
+
+	transfer func(b []byte) (int, error) // Transfers through the stream in the direction of the endpoint
+}
+
+// newBulkStream validates the stream id and wraps a backend transfer function
+// into a BulkStream.
+func newBulkStream(endpoint uint8, id uint32, transfer func([]byte) (int, error)) (*BulkStream, error) {
+	// Stream id 0 is reserved by the specification
+	if id == 0 {
+		return nil, fmt.Errorf("failed to open bulk stream: stream id 0 reserved: %w", ErrInvalidParam)
+	}
+	return &BulkStream{Endpoint: endpoint, ID: id, transfer: transfer}, nil
+}
+
+// Read retrieves data from the stream of an IN endpoint.
+func (s *BulkStream) Read(b []byte) (int, error) {
+	if s.Endpoint&endpointDirectionMask == 0 {
+		return 0, fmt.Errorf("failed to read from bulk stream: endpoint %#02x is OUT: %w", s.Endpoint, ErrInvalidParam)
+	}
+	return s.transfer(b)
+}
+
+// Write sends data through the stream of an OUT endpoint.
+func (s *BulkStream) Write(b []byte) (int, error) {
+	if s.Endpoint&endpointDirectionMask != 0 {
+		return 0, fmt.Errorf("failed to write to bulk stream: endpoint %#02x is IN: %w", s.Endpoint, ErrInvalidParam)
+	}
+	return s.transfer(b)
+}
@@ -0,0 +1,103 @@
+//go:build (freebsd && cgo) || (linux && cgo) || (openbsd && cgo) || (netbsd && cgo) || (darwin && !ios && cgo && !iokit) || (windows && cgo && !winusb)
+
+package zerousb
+
+/*
+	#include <libusb.h>
+
+	void fill_stream_transfer(struct libusb_transfer* transfer, libusb_device_handle* handle, unsigned char endpoint,
+		uint32_t stream_id, unsigned char* buffer, int length, intptr_t id, unsigned int timeout);
+*/
+import "C"
+
+import (
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+// AllocBulkStreams allocates bulk streams on the endpoints, which needs both
+// the device and the host controller to support USB 3.0 streams. Fewer streams
+// than requested may be allocated, their count is returned.
+func (dev *libusbDevice) AllocBulkStreams(count int, endpoints ...uint8) (int, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+
+	if dev.handle == nil {
+		return 0, dev.closedErr()
+	}
+	if count <= 0 || len(endpoints) == 0 {
+		return 0, fmt.Errorf("failed to allocate bulk streams: %w", ErrInvalidParam)
+	}
+	n := C.libusb_alloc_streams(dev.handle, C.uint32_t(count), (*C.uchar)(unsafe.Pointer(&endpoints[0])), C.int(len(endpoints)))
+	if n < 0 {
+		return 0, fmt.Errorf("failed to allocate bulk streams: %w", fromLibusbErrno(n))
+	}
+	return int(n), nil
+}
+
+// FreeBulkStreams releases the bulk streams allocated on the endpoints.
+func (dev *libusbDevice) FreeBulkStreams(endpoints ...uint8) error {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+
+	if dev.handle == nil {
+		return dev.closedErr()
+	}
+	if len(endpoints) == 0 {
+		return fmt.Errorf("failed to free bulk streams: %w", ErrInvalidParam)
+	}
+	if err := fromLibusbErrno(C.libusb_free_streams(dev.handle, (*C.uchar)(unsafe.Pointer(&endpoints[0])), C.int(len(endpoints)))); err != nil {
+		return fmt.Errorf("failed to free bulk streams: %w", err)
+	}
+	return nil
+}
+
+// OpenBulkStream returns a reader and writer transferring through a bulk
+// stream allocated on the endpoint. Transfers use the read or write timeout of
+// the device, depending on the direction of the endpoint.
+func (dev *libusbDevice) OpenBulkStream(endpoint uint8, streamID uint32) (*BulkStream, error) {
+	return newBulkStream(endpoint, streamID, func(b []byte) (int, error) {
+		return dev.streamTransfer(endpoint, streamID, b)
+	})
+}
+
+// streamTransfer moves b through a bulk stream of an endpoint, waiting for the
+// asynchronous transfer to complete.
+func (dev *libusbDevice) streamTransfer(endpoint uint8, id uint32, b []byte) (int, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+
+	if dev.handle == nil {
+		return 0, dev.closedErr()
+	}
+	in := endpoint&endpointDirectionMask != 0
+	timeout := dev.writeTimeout
+	if in {
+		timeout = dev.readTimeout
+	}
+	n, err := splitTransfer(b, maxTransferSize(math.MaxInt32), func(chunk []byte) (int, error) {
+		t, err := dev.allocTransfer(0, len(chunk))
+		if err != nil {
+			return 0, err
+		}
+		defer t.free()
+
+		C.fill_stream_transfer(t.xfer, dev.handle, C.uchar(endpoint), C.uint32_t(id), t.buf, C.int(len(chunk)), C.intptr_t(t.id), C.uint(timeout))
+		if !in {
+			copy(unsafe.Slice((*byte)(unsafe.Pointer(t.buf)), t.size), chunk)
+		}
+		if err := t.submit(); err != nil {
+			return 0, err
+		}
+		data, err := t.wait()
+		if in {
+			copy(chunk, data)
+		}
+		return len(data), err
+	})
+	if err != nil {
+		return n, dev.check(newTransferError(endpoint, n, err))
+	}
+	return n, nil
+}
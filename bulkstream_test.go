@@ -0,0 +1,36 @@
+package zerousb
+
+import (
+	"errors"
+	"testing"
+)
+
+// Tests that bulk streams reject the reserved stream id and transfers against
+// the direction of their endpoint.
+func TestBulkStream(t *testing.T) {
+	transfer := func(b []byte) (int, error) { return len(b), nil }
+
+	if _, err := newBulkStream(0x81, 0, transfer); !errors.Is(err, ErrInvalidParam) {
+		t.Errorf("reserved stream id mismatch: have %v, want %v", err, ErrInvalidParam)
+	}
+	in, err := newBulkStream(0x81, 1, transfer)
+	if err != nil {
+		t.Fatalf("failed to open IN stream: %v", err)
+	}
+	if n, err := in.Read(make([]byte, 4)); n != 4 || err != nil {
+		t.Errorf("IN stream read mismatch: have %d, %v, want 4, nil", n, err)
+	}
+	if _, err := in.Write(make([]byte, 4)); !errors.Is(err, ErrInvalidParam) {
+		t.Errorf("IN stream write mismatch: have %v, want %v", err, ErrInvalidParam)
+	}
+	out, err := newBulkStream(0x02, 3, transfer)
+	if err != nil {
+		t.Fatalf("failed to open OUT stream: %v", err)
+	}
+	if n, err := out.Write(make([]byte, 4)); n != 4 || err != nil {
+		t.Errorf("OUT stream write mismatch: have %d, %v, want 4, nil", n, err)
+	}
+	if _, err := out.Read(make([]byte, 4)); !errors.Is(err, ErrInvalidParam) {
+		t.Errorf("OUT stream read mismatch: have %v, want %v", err, ErrInvalidParam)
+	}
+}
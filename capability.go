@@ -0,0 +1,47 @@
+package zerousb
+
+import "fmt"
+
+// Capability is an optional feature of the backend in use, which may depend on
+// the platform and the version of the operating system.
+type Capability int
+
+const (
+	// CapabilityHotplug is reported if the backend notifies OnHotplug of device
+	// arrivals and departures. Without it, devices are polled for.
+	CapabilityHotplug Capability = iota + 1
+
+	// CapabilityHIDAccess is reported if HID devices can be accessed without
+	// detaching the kernel driver first.
+	CapabilityHIDAccess
+
+	// CapabilityDetachKernelDriver is reported if kernel drivers bound to an
+	// interface are detached when claiming it.
+	CapabilityDetachKernelDriver
+)
+
+// String returns a human readable name of the capability.
+func (c Capability) String() string {
+	switch c {
+	case CapabilityHotplug:
+		return "hotplug"
+	case CapabilityHIDAccess:
+		return "hid access"
+	case CapabilityDetachKernelDriver:
+		return "detach kernel driver"
+	}
+	return fmt.Sprintf("Capability(%d)", int(c))
+}
+
+// HasCapability reports whether the backend in use supports a capability, so
+// applications can pick between alternatives (e.g. hotplug handlers or polling)
+// without trial and error. Backends registered by RegisterBackend report none.
+func HasCapability(c Capability) bool {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if backendName != "" {
+		return false
+	}
+	return hasCapability(c)
+}
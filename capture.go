@@ -0,0 +1,305 @@
+package zerousb
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// pcapng block types and the usbmon link type captures are recorded with.
+const (
+	pcapngSectionHeader    = 0x0a0d0d0a
+	pcapngInterface        = 0x00000001
+	pcapngEnhancedPacket   = 0x00000006
+	pcapngByteOrderMagic   = 0x1a2b3c4d
+	linktypeUSBLinuxMmap   = 220 // LINKTYPE_USB_LINUX_MMAPPED, 64 byte usbmon headers
+	usbmonHeaderSize       = 64
+	usbmonStatusInProgress = -115 // -EINPROGRESS, status of submissions
+)
+
+// usbmon transfer types, which differ from the ones of endpoint descriptors.
+const (
+	usbmonIsochronous = 0
+	usbmonInterrupt   = 1
+	usbmonControl     = 2
+	usbmonBulk        = 3
+)
+
+// Capture records the transfers of devices into a pcapng stream with the Linux
+// usbmon link type, so captures made through the package open in Wireshark
+// like kernel captures do. Each Read, Write and Control call is recorded as a
+// submission and a completion event.
+//
+// The address of the device isn't known to all backends, so the port number
+// it's attached to stands in for it. Failures to write the stream stop the
+// capture, they are reported by Err.
+type Capture struct {
+	w    io.Writer
+	id   uint64 // Id of the last recorded transfer, tying completions to submissions
+	err  error  // Sticky failure to write the stream
+	lock sync.Mutex
+}
+
+// NewCapture starts a capture, writing the pcapng section and interface headers
+// to w.
+func NewCapture(w io.Writer) (*Capture, error) {
+	// Section header: byte order magic, version 1.0 and unknown section length
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb, pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:], 1)
+	binary.LittleEndian.PutUint64(shb[8:], ^uint64(0))
+
+	// Interface description: link type, reserved and unlimited snapshot length.
+	// Timestamps default to microsecond resolution.
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb, linktypeUSBLinuxMmap)
+
+	c := &Capture{w: w}
+	if err := c.writeBlock(pcapngSectionHeader, shb); err != nil {
+		return nil, err
+	}
+	if err := c.writeBlock(pcapngInterface, idb); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Err returns the failure which stopped the capture, nil if it's running.
+func (c *Capture) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.err
+}
+
+// Wrap returns a device recording the Read, Write and Control calls of a
+// device opened with the info into the capture.
+func (c *Capture) Wrap(dev Device, info DeviceInfo) Device {
+	captured := &capturedDevice{
+		wrappedDevice: wrappedDevice{dev},
+		capture:       c,
+		bus:           uint16(info.Bus),
+		addr:          info.Port,
+	}
+	if end, ok := readEndpoint(info); ok {
+		captured.reader, captured.readerType = end.Address, usbmonTransferType(end.TransferType())
+	}
+	if end, ok := writeEndpoint(info); ok {
+		captured.writer, captured.writerType = end.Address, usbmonTransferType(end.TransferType())
+	}
+	return captured
+}
+
+// usbmonTransferType converts a transfer type into the one of usbmon headers.
+func usbmonTransferType(kind TransferType) uint8 {
+	switch kind {
+	case TransferTypeIsochronous:
+		return usbmonIsochronous
+	case TransferTypeInterrupt:
+		return usbmonInterrupt
+	case TransferTypeControl:
+		return usbmonControl
+	}
+	return usbmonBulk
+}
+
+// usbmonStatus converts the error of a completed transfer into the negated
+// errno usbmon reports as its status.
+func usbmonStatus(err error) int32 {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrPipe):
+		return -32 // EPIPE
+	case errors.Is(err, ErrNoDevice):
+		return -19 // ENODEV
+	case errors.Is(err, ErrOverflow):
+		return -75 // EOVERFLOW
+	case errors.Is(err, ErrTimeout):
+		return -110 // ETIMEDOUT
+	case errors.Is(err, ErrInterrupted):
+		return -2 // ENOENT, the status of cancelled transfers
+	}
+	return -71 // EPROTO
+}
+
+// usbmonEvent is a submission or completion event of a transfer.
+type usbmonEvent struct {
+	id       uint64
+	kind     byte // 'S' for submissions, 'C' for completions
+	xfer     uint8
+	endpoint uint8 // Endpoint address, direction bit included
+	bus      uint16
+	addr     uint8
+	setup    []byte // Setup packet of control submissions, nil otherwise
+	time     time.Time
+	status   int32
+	length   int    // Length of the transfer, requested or actual
+	data     []byte // Data carried by the event, nil if none
+}
+
+// nextID returns a fresh id tying the events of a transfer together.
+func (c *Capture) nextID() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.id++
+	return c.id
+}
+
+// record writes an event into the capture, unless it was stopped.
+func (c *Capture) record(ev *usbmonEvent) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.err != nil {
+		return
+	}
+	packet := make([]byte, usbmonHeaderSize+len(ev.data))
+	binary.LittleEndian.PutUint64(packet, ev.id)
+	packet[8], packet[9], packet[10], packet[11] = ev.kind, ev.xfer, ev.endpoint, ev.addr
+	binary.LittleEndian.PutUint16(packet[12:], ev.bus)
+
+	packet[14] = '-'
+	if ev.setup != nil {
+		packet[14] = 0
+		copy(packet[40:48], ev.setup)
+	}
+	switch {
+	case ev.data != nil:
+		packet[15] = 0
+	case ev.length == 0:
+		packet[15] = '='
+	case ev.endpoint&endpointDirectionMask != 0:
+		packet[15] = '<'
+	default:
+		packet[15] = '>'
+	}
+	binary.LittleEndian.PutUint64(packet[16:], uint64(ev.time.Unix()))
+	binary.LittleEndian.PutUint32(packet[24:], uint32(ev.time.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(packet[28:], uint32(ev.status))
+	binary.LittleEndian.PutUint32(packet[32:], uint32(ev.length))
+	binary.LittleEndian.PutUint32(packet[36:], uint32(len(ev.data)))
+	copy(packet[usbmonHeaderSize:], ev.data)
+
+	// Enhanced packet: interface, timestamp, captured and original length
+	usec := uint64(ev.time.UnixNano() / 1000)
+	epb := make([]byte, 20, 20+len(packet)+3)
+	binary.LittleEndian.PutUint32(epb[4:], uint32(usec>>32))
+	binary.LittleEndian.PutUint32(epb[8:], uint32(usec))
+	binary.LittleEndian.PutUint32(epb[12:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(epb[16:], uint32(len(packet)))
+	epb = append(epb, packet...)
+
+	c.err = c.writeBlock(pcapngEnhancedPacket, epb)
+}
+
+// writeBlock writes a pcapng block of the given type, padding the body to 32
+// bits and framing it with the total block length.
+func (c *Capture) writeBlock(kind uint32, body []byte) error {
+	padded := (len(body) + 3) &^ 3
+	block := make([]byte, 12+padded)
+	binary.LittleEndian.PutUint32(block, kind)
+	binary.LittleEndian.PutUint32(block[4:], uint32(len(block)))
+	copy(block[8:], body)
+	binary.LittleEndian.PutUint32(block[len(block)-4:], uint32(len(block)))
+
+	_, err := c.w.Write(block)
+	return err
+}
+
+// capturedDevice is a device whose transfers are recorded into a capture.
+// Methods other than the transfers are passed through unrecorded.
+type capturedDevice struct {
+	wrappedDevice
+	capture    *Capture
+	bus        uint16
+	addr       uint8
+	reader     uint8 // Endpoint Read transfers through
+	readerType uint8 // usbmon transfer type of the reader endpoint
+	writer     uint8 // Endpoint Write transfers through
+	writerType uint8 // usbmon transfer type of the writer endpoint
+}
+
+// transfer records the submission of a transfer through buf, runs it and
+// records its completion. Data of OUT transfers is recorded on submission, data
+// of IN transfers on completion.
+func (dev *capturedDevice) transfer(xfer uint8, endpoint uint8, setup []byte, buf []byte, transfer func() (int, error)) (int, error) {
+	in := endpoint&endpointDirectionMask != 0
+
+	ev := &usbmonEvent{
+		id:       dev.capture.nextID(),
+		kind:     'S',
+		xfer:     xfer,
+		endpoint: endpoint,
+		bus:      dev.bus,
+		addr:     dev.addr,
+		setup:    setup,
+		time:     time.Now(),
+		status:   usbmonStatusInProgress,
+		length:   len(buf),
+	}
+	if !in && len(buf) > 0 {
+		ev.data = buf
+	}
+	dev.capture.record(ev)
+
+	n, err := transfer()
+
+	ev.kind, ev.setup, ev.time, ev.status, ev.length, ev.data = 'C', nil, time.Now(), usbmonStatus(err), n, nil
+	if in && n > 0 {
+		ev.data = buf[:n]
+	}
+	dev.capture.record(ev)
+	return n, err
+}
+
+// Read retrieves a binary blob from the device, recording the transfer.
+func (dev *capturedDevice) Read(b []byte) (int, error) {
+	return dev.transfer(dev.readerType, dev.reader, nil, b, func() (int, error) { return dev.Device.Read(b) })
+}
+
+// ReadWithTimeout retrieves a binary blob from the device with a timeout for
+// this call only, recording the transfer.
+func (dev *capturedDevice) ReadWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	return dev.transfer(dev.readerType, dev.reader, nil, b, func() (int, error) { return dev.Device.ReadWithTimeout(b, timeout) })
+}
+
+// Write sends a binary blob to the device, recording the transfer.
+func (dev *capturedDevice) Write(b []byte) (int, error) {
+	return dev.transfer(dev.writerType, dev.writer, nil, b, func() (int, error) { return dev.Device.Write(b) })
+}
+
+// WriteWithTimeout sends a binary blob to the device with a timeout for this
+// call only, recording the transfer.
+func (dev *capturedDevice) WriteWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	return dev.transfer(dev.writerType, dev.writer, nil, b, func() (int, error) { return dev.Device.WriteWithTimeout(b, timeout) })
+}
+
+// WriteV sends the buffers to the device, recording them as one transfer.
+func (dev *capturedDevice) WriteV(bufs net.Buffers) (int, error) {
+	var data []byte
+	for _, b := range bufs {
+		data = append(data, b...)
+	}
+	return dev.transfer(dev.writerType, dev.writer, nil, data, func() (int, error) { return dev.Device.WriteV(bufs) })
+}
+
+// Control sends a control request to the device, recording the transfer.
+func (dev *capturedDevice) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	setup := []byte{rType, request, 0, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint16(setup[2:], val)
+	binary.LittleEndian.PutUint16(setup[4:], idx)
+	binary.LittleEndian.PutUint16(setup[6:], uint16(len(data)))
+
+	endpoint := uint8(0)
+	if rType&ControlIn != 0 {
+		endpoint = endpointDirectionMask
+	}
+	return dev.transfer(usbmonControl, endpoint, setup, data, func() (int, error) {
+		return dev.Device.Control(rType, request, val, idx, data)
+	})
+}
@@ -0,0 +1,66 @@
+package zerousb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// Tests that captures are framed as pcapng with the usbmon link type, and that
+// transfers are recorded as submission and completion events carrying the data
+// in the direction of the transfer.
+func TestCapture(t *testing.T) {
+	var out bytes.Buffer
+	capture, err := NewCapture(&out)
+	if err != nil {
+		t.Fatalf("failed to start capture: %v", err)
+	}
+	info := DeviceInfo{Bus: 3, Port: 7, Endpoints: []EndpointInfo{{Address: 0x01, Attributes: 0x02}}}
+	dev := capture.Wrap(&recordDevice{limit: 1}, info)
+	if _, err := dev.Write([]byte("hello")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if capture.Err() != nil {
+		t.Fatalf("capture failed: %v", capture.Err())
+	}
+	// Walk the blocks, checking the framing of each
+	var blocks [][]byte
+	for rest := out.Bytes(); len(rest) > 0; {
+		size := int(binary.LittleEndian.Uint32(rest[4:]))
+		if size%4 != 0 || size > len(rest) || binary.LittleEndian.Uint32(rest[size-4:]) != uint32(size) {
+			t.Fatalf("malformed block of %d bytes", size)
+		}
+		blocks, rest = append(blocks, rest[:size]), rest[size:]
+	}
+	if len(blocks) != 4 {
+		t.Fatalf("block count mismatch: have %d, want 4", len(blocks))
+	}
+	if kind := binary.LittleEndian.Uint32(blocks[1]); kind != pcapngInterface || binary.LittleEndian.Uint16(blocks[1][8:]) != linktypeUSBLinuxMmap {
+		t.Fatalf("interface block mismatch: %x", blocks[1])
+	}
+	tests := []struct {
+		kind   byte
+		status int32
+		length uint32
+		data   string
+	}{
+		{'S', usbmonStatusInProgress, 5, "hello"},
+		{'C', 0, 5, ""},
+	}
+	for i, tt := range tests {
+		packet := blocks[2+i][28:]
+		if packet[8] != tt.kind || packet[10] != 0x01 || packet[11] != 7 || binary.LittleEndian.Uint16(packet[12:]) != 3 {
+			t.Errorf("event %d: header mismatch: %x", i, packet[:16])
+		}
+		if status := int32(binary.LittleEndian.Uint32(packet[28:])); status != tt.status {
+			t.Errorf("event %d: status mismatch: have %d, want %d", i, status, tt.status)
+		}
+		if length := binary.LittleEndian.Uint32(packet[32:]); length != tt.length {
+			t.Errorf("event %d: length mismatch: have %d, want %d", i, length, tt.length)
+		}
+		captured := binary.LittleEndian.Uint32(packet[36:])
+		if data := packet[usbmonHeaderSize : usbmonHeaderSize+captured]; string(data) != tt.data {
+			t.Errorf("event %d: data mismatch: have %q, want %q", i, data, tt.data)
+		}
+	}
+}
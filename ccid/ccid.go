@@ -0,0 +1,173 @@
+// Package ccid implements the USB Chip/Smart Card Interface Devices message
+// protocol over zerousb bulk transfers, allowing APDUs to be exchanged with
+// smart card readers directly, without going through pcscd.
+package ccid
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Bulk-OUT message types defined by the CCID 1.1 spec, section 6.1.
+const (
+	msgIccPowerOn    = 0x62
+	msgIccPowerOff   = 0x63
+	msgGetSlotStatus = 0x65
+	msgXfrBlock      = 0x6f
+)
+
+// Bulk-IN message types defined by the CCID 1.1 spec, section 6.2.
+const (
+	msgDataBlock  = 0x80
+	msgSlotStatus = 0x81
+)
+
+// headerLength is the size of the common message header.
+const headerLength = 10
+
+// maxMessage is the largest message accepted from the reader, enough for an
+// extended length APDU response.
+const maxMessage = headerLength + 65538
+
+// Command status values, bits 6-7 of bStatus.
+const (
+	commandOK        = 0
+	commandFailed    = 1
+	commandExtension = 2
+)
+
+// ICCStatus is the presence and activation state of the card in a slot.
+type ICCStatus uint8
+
+// Card states, bits 0-1 of bStatus.
+const (
+	ICCActive   ICCStatus = 0
+	ICCInactive ICCStatus = 1
+	ICCAbsent   ICCStatus = 2
+)
+
+// String returns a human readable form of the card state.
+func (s ICCStatus) String() string {
+	switch s {
+	case ICCActive:
+		return "active"
+	case ICCInactive:
+		return "inactive"
+	case ICCAbsent:
+		return "absent"
+	}
+	return fmt.Sprintf("ICCStatus(%d)", uint8(s))
+}
+
+// SlotError is returned when the reader reports a failed command.
+type SlotError struct {
+	ICC  ICCStatus // State of the card when the command failed
+	Code uint8     // bError slot error register
+}
+
+// Error implements the error interface.
+func (e *SlotError) Error() string {
+	return fmt.Sprintf("ccid: command failed with error 0x%02x (card %s)", e.Code, e.ICC)
+}
+
+// ErrSequence is returned if a response doesn't match the outstanding command.
+var ErrSequence = errors.New("ccid: response sequence mismatch")
+
+// Transport is the subset of zerousb.Device needed to speak CCID.
+type Transport interface {
+	Read(b []byte) (int, error)
+	Write(b []byte) (int, error)
+}
+
+// Reader is a handle to a single slot of a CCID smart card reader.
+type Reader struct {
+	dev  Transport
+	slot uint8
+
+	lock sync.Mutex // Serializes commands, one may be outstanding per slot
+	seq  uint8      // Sequence number of the last command
+}
+
+// New creates a reader handle for the given slot of an opened CCID device.
+func New(dev Transport, slot uint8) *Reader {
+	return &Reader{dev: dev, slot: slot}
+}
+
+// PowerOn activates the card in the slot, returning its Answer To Reset.
+func (r *Reader) PowerOn() ([]byte, error) {
+	// bPowerSelect 0 lets the reader pick the voltage automatically
+	_, atr, err := r.exchange(msgIccPowerOn, [3]byte{0, 0, 0}, nil, msgDataBlock)
+	return atr, err
+}
+
+// PowerOff deactivates the card in the slot.
+func (r *Reader) PowerOff() error {
+	_, _, err := r.exchange(msgIccPowerOff, [3]byte{}, nil, msgSlotStatus)
+	return err
+}
+
+// Status retrieves the state of the card in the slot.
+func (r *Reader) Status() (ICCStatus, error) {
+	icc, _, err := r.exchange(msgGetSlotStatus, [3]byte{}, nil, msgSlotStatus)
+	return icc, err
+}
+
+// Transmit sends a command APDU to the card and returns the response APDU,
+// including the trailing status words. It relies on the reader handling the
+// TPDU level itself, as readers with short or extended APDU exchange do.
+func (r *Reader) Transmit(apdu []byte) ([]byte, error) {
+	_, resp, err := r.exchange(msgXfrBlock, [3]byte{}, apdu, msgDataBlock)
+	return resp, err
+}
+
+// exchange sends a single command message and waits for its response, which
+// may be preceded by any number of time extension requests.
+func (r *Reader) exchange(kind uint8, params [3]byte, data []byte, expect uint8) (ICCStatus, []byte, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.seq++
+	msg := make([]byte, headerLength+len(data))
+	msg[0] = kind
+	binary.LittleEndian.PutUint32(msg[1:], uint32(len(data)))
+	msg[5] = r.slot
+	msg[6] = r.seq
+	copy(msg[7:headerLength], params[:])
+	copy(msg[headerLength:], data)
+
+	if _, err := r.dev.Write(msg); err != nil {
+		return 0, nil, fmt.Errorf("ccid: failed to send command: %w", err)
+	}
+	buf := make([]byte, maxMessage)
+	for {
+		n, err := r.dev.Read(buf)
+		if err != nil {
+			return 0, nil, fmt.Errorf("ccid: failed to read response: %w", err)
+		}
+		if n < headerLength {
+			return 0, nil, fmt.Errorf("ccid: short response: %d bytes", n)
+		}
+		if buf[5] != r.slot || buf[6] != r.seq {
+			return 0, nil, ErrSequence
+		}
+		status := buf[7]
+		icc := ICCStatus(status & 0x03)
+
+		switch status >> 6 {
+		case commandExtension:
+			continue // The card asked for more time, wait for the real response
+		case commandFailed:
+			return icc, nil, &SlotError{ICC: icc, Code: buf[8]}
+		}
+		if buf[0] != expect {
+			return icc, nil, fmt.Errorf("ccid: unexpected response type 0x%02x", buf[0])
+		}
+		length := int(binary.LittleEndian.Uint32(buf[1:]))
+		if length > n-headerLength {
+			return icc, nil, fmt.Errorf("ccid: truncated response: %d of %d bytes", n-headerLength, length)
+		}
+		return icc, append([]byte(nil), buf[headerLength:headerLength+length]...), nil
+	}
+}
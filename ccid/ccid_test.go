@@ -0,0 +1,136 @@
+package ccid
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// fakeReader answers every command with the queued responses, stamping them
+// with the slot and sequence number of the command unless they carry their
+// own, and records the commands sent to it.
+type fakeReader struct {
+	writes    [][]byte
+	responses [][]byte
+}
+
+func (f *fakeReader) Write(b []byte) (int, error) {
+	f.writes = append(f.writes, append([]byte{}, b...))
+	return len(b), nil
+}
+
+func (f *fakeReader) Read(b []byte) (int, error) {
+	req := f.writes[len(f.writes)-1]
+
+	msg := f.responses[0]
+	f.responses = f.responses[1:]
+	if msg[5] == 0 && msg[6] == 0 {
+		msg[5], msg[6] = req[5], req[6]
+	}
+	return copy(b, msg), nil
+}
+
+// response assembles a raw Bulk-IN message with the given status and data.
+func response(kind, status, code uint8, data []byte) []byte {
+	msg := make([]byte, headerLength, headerLength+len(data))
+	msg[0] = kind
+	binary.LittleEndian.PutUint32(msg[1:], uint32(len(data)))
+	msg[7], msg[8] = status, code
+	return append(msg, data...)
+}
+
+// Tests that APDUs are framed with the CCID header and that time extension
+// requests are waited out.
+func TestTransmit(t *testing.T) {
+	dev := &fakeReader{responses: [][]byte{
+		response(msgDataBlock, commandExtension<<6, 1, nil),
+		response(msgDataBlock, commandExtension<<6, 1, nil),
+		response(msgDataBlock, commandOK, 0, []byte{0x90, 0x00}),
+	}}
+	reader := New(dev, 1)
+
+	resp, err := reader.Transmit([]byte{0x00, 0xa4, 0x04, 0x00})
+	if err != nil {
+		t.Fatalf("failed to transmit: %v", err)
+	}
+	if !bytes.Equal(resp, []byte{0x90, 0x00}) {
+		t.Errorf("response mismatch: have %x, want 9000", resp)
+	}
+	if len(dev.writes) != 1 {
+		t.Fatalf("command count mismatch: have %d, want 1", len(dev.writes))
+	}
+	want := []byte{msgXfrBlock, 4, 0, 0, 0, 1, 1, 0, 0, 0, 0x00, 0xa4, 0x04, 0x00}
+	if !bytes.Equal(dev.writes[0], want) {
+		t.Errorf("command mismatch: have %x, want %x", dev.writes[0], want)
+	}
+	// Sequence numbers advance with every command
+	dev.responses = [][]byte{response(msgSlotStatus, uint8(ICCInactive), 0, nil)}
+	icc, err := reader.Status()
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	if icc != ICCInactive {
+		t.Errorf("card state mismatch: have %v, want %v", icc, ICCInactive)
+	}
+	if cmd := dev.writes[1]; cmd[0] != msgGetSlotStatus || cmd[6] != 2 {
+		t.Errorf("status command mismatch: have %x", cmd)
+	}
+}
+
+// Tests that responses to other commands or slots are rejected and that failed
+// commands report the slot error.
+func TestExchangeErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		response []byte
+		check    func(error) bool
+	}{
+		{
+			name: "sequence",
+			response: func() []byte {
+				msg := response(msgDataBlock, commandOK, 0, nil)
+				msg[5], msg[6] = 1, 7
+				return msg
+			}(),
+			check: func(err error) bool { return err == ErrSequence },
+		},
+		{
+			name: "slot",
+			response: func() []byte {
+				msg := response(msgDataBlock, commandOK, 0, nil)
+				msg[5], msg[6] = 2, 1
+				return msg
+			}(),
+			check: func(err error) bool { return err == ErrSequence },
+		},
+		{
+			name:     "failed",
+			response: response(msgDataBlock, commandFailed<<6|uint8(ICCAbsent), 0xfe, nil),
+			check: func(err error) bool {
+				var slotErr *SlotError
+				return errors.As(err, &slotErr) && slotErr.ICC == ICCAbsent && slotErr.Code == 0xfe
+			},
+		},
+		{
+			name:     "type",
+			response: response(msgSlotStatus, commandOK, 0, nil),
+			check:    func(err error) bool { return err != nil },
+		},
+		{
+			name: "truncated",
+			response: func() []byte {
+				msg := response(msgDataBlock, commandOK, 0, []byte{0x90, 0x00})
+				binary.LittleEndian.PutUint32(msg[1:], 4)
+				return msg
+			}(),
+			check: func(err error) bool { return err != nil },
+		},
+	}
+	for _, tt := range tests {
+		dev := &fakeReader{responses: [][]byte{tt.response}}
+		if _, err := New(dev, 1).PowerOn(); !tt.check(err) {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+	}
+}
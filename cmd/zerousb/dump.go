@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/chay22/zerousb"
+	"github.com/chay22/zerousb/usbid"
+)
+
+// Standard descriptor types decoded by the dump command.
+const (
+	descDevice    = 0x01
+	descConfig    = 0x02
+	descInterface = 0x04
+	descEndpoint  = 0x05
+	descIAD       = 0x0b
+)
+
+// runDump opens a device and prints its full descriptor hierarchy, similar to
+// `lsusb -v`. Class specific descriptors are printed as hex.
+func runDump(args []string) error {
+	flags := newFlagSet("dump")
+	filter := flags.String("d", "", "device to dump as `vid:pid` (hex, either may be empty)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *filter == "" {
+		return errors.New("dump: missing device, use -d vid:pid")
+	}
+	vid, pid, err := parseIDPair(*filter)
+	if err != nil {
+		return err
+	}
+	infos, err := zerousb.Find(vid, pid)
+	if err != nil {
+		return err
+	}
+	if len(infos) == 0 {
+		return fmt.Errorf("dump: no device matching %s", *filter)
+	}
+	dev, err := infos[0].Open()
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+
+	raw, err := dev.RawDescriptors()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Bus %03d Port %03d: ID %04x:%04x %s\n", infos[0].Bus, infos[0].Port, infos[0].VendorID, infos[0].ProductID, usbid.Describe(infos[0]))
+	dumpDescriptors(raw.Device, 0)
+	for _, config := range raw.Configs {
+		dumpDescriptors(config, 1)
+	}
+	if bos, err := dev.BOS(); err == nil {
+		fmt.Println("Binary Object Store Descriptor:")
+		for _, c := range bos.Capabilities {
+			fmt.Printf("  Capability 0x%02x: %s\n", c.Type, hex.EncodeToString(c.Data))
+		}
+		if bos.ContainerID != nil {
+			fmt.Printf("  ContainerID: %x\n", bos.ContainerID)
+		}
+	}
+	return nil
+}
+
+// dumpDescriptors walks a blob of concatenated descriptors, printing each at an
+// indentation depending on its place in the hierarchy.
+func dumpDescriptors(blob []byte, depth int) {
+	for len(blob) >= 2 {
+		length := int(blob[0])
+		if length < 2 || length > len(blob) {
+			fmt.Printf("%sTruncated descriptor: %s\n", indent(depth), hex.EncodeToString(blob))
+			return
+		}
+		desc := blob[:length]
+		blob = blob[length:]
+
+		switch desc[1] {
+		case descDevice:
+			dumpFields(0, "Device Descriptor", desc, []field{
+				{"bcdUSB", 2, 2}, {"bDeviceClass", 4, 1}, {"bDeviceSubClass", 5, 1}, {"bDeviceProtocol", 6, 1},
+				{"bMaxPacketSize0", 7, 1}, {"idVendor", 8, 2}, {"idProduct", 10, 2}, {"bcdDevice", 12, 2},
+				{"iManufacturer", 14, 1}, {"iProduct", 15, 1}, {"iSerial", 16, 1}, {"bNumConfigurations", 17, 1},
+			})
+		case descConfig:
+			depth = 1
+			dumpFields(depth, "Configuration Descriptor", desc, []field{
+				{"wTotalLength", 2, 2}, {"bNumInterfaces", 4, 1}, {"bConfigurationValue", 5, 1},
+				{"iConfiguration", 6, 1}, {"bmAttributes", 7, 1}, {"MaxPower", 8, 1},
+			})
+		case descIAD:
+			dumpFields(2, "Interface Association", desc, []field{
+				{"bFirstInterface", 2, 1}, {"bInterfaceCount", 3, 1}, {"bFunctionClass", 4, 1},
+				{"bFunctionSubClass", 5, 1}, {"bFunctionProtocol", 6, 1}, {"iFunction", 7, 1},
+			})
+		case descInterface:
+			depth = 2
+			dumpFields(depth, "Interface Descriptor", desc, []field{
+				{"bInterfaceNumber", 2, 1}, {"bAlternateSetting", 3, 1}, {"bNumEndpoints", 4, 1},
+				{"bInterfaceClass", 5, 1}, {"bInterfaceSubClass", 6, 1}, {"bInterfaceProtocol", 7, 1},
+				{"iInterface", 8, 1},
+			})
+			if len(desc) >= 8 {
+				fmt.Printf("%s  (%s)\n", indent(depth), usbid.ClassifyInterface(zerousb.DeviceInfo{
+					InterfaceClass: desc[5], InterfaceSubClass: desc[6], InterfaceProtocol: desc[7],
+				}))
+			}
+		case descEndpoint:
+			depth = 3
+			dumpFields(depth, "Endpoint Descriptor", desc, []field{
+				{"bEndpointAddress", 2, 1}, {"bmAttributes", 3, 1}, {"wMaxPacketSize", 4, 2}, {"bInterval", 6, 1},
+			})
+		default:
+			fmt.Printf("%sDescriptor 0x%02x (%d bytes): %s\n", indent(depth+1), desc[1], length, hex.EncodeToString(desc[2:]))
+		}
+	}
+}
+
+// field is a single fixed position field of a standard descriptor.
+type field struct {
+	name   string
+	offset int
+	size   int
+}
+
+// dumpFields prints the named fields of a standard descriptor.
+func dumpFields(depth int, title string, desc []byte, fields []field) {
+	fmt.Printf("%s%s:\n", indent(depth), title)
+	for _, f := range fields {
+		if f.offset+f.size > len(desc) {
+			fmt.Printf("%s  %-20s <truncated>\n", indent(depth), f.name)
+			continue
+		}
+		switch f.size {
+		case 1:
+			fmt.Printf("%s  %-20s 0x%02x\n", indent(depth), f.name, desc[f.offset])
+		case 2:
+			fmt.Printf("%s  %-20s 0x%04x\n", indent(depth), f.name, binary.LittleEndian.Uint16(desc[f.offset:]))
+		}
+	}
+}
+
+// indent returns the leading whitespace for a hierarchy depth.
+func indent(depth int) string {
+	return strings.Repeat("  ", depth)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/chay22/zerousb"
+	"github.com/chay22/zerousb/usbid"
+)
+
+// runList lists the attached devices and prints them lsusb-style, hubs and HID
+// devices included. Only the interfaces usable for bulk or interrupt transfers
+// in both directions are shown below each device.
+func runList(args []string) error {
+	flags := newFlagSet("list")
+	filter := flags.String("d", "", "only show devices matching `vid:pid` (hex, either may be empty)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	vid, pid, err := parseIDPair(*filter)
+	if err != nil {
+		return err
+	}
+	devices, err := zerousb.ListDevicesHID(func(info zerousb.DeviceInfo) bool {
+		return (vid == 0 || zerousb.ID(info.VendorID) == vid) && (pid == 0 || zerousb.ID(info.ProductID) == pid)
+	})
+	if err != nil {
+		return err
+	}
+	for _, dev := range devices {
+		fmt.Printf("Bus %03d Port %03d: ID %04x:%04x %s\n", dev.Bus, dev.Port, dev.VendorID, dev.ProductID, usbid.Describe(dev))
+		fmt.Printf("  Class: %s\n", usbid.Classify(dev))
+
+		ifaces, err := dev.Interfaces()
+		if err != nil {
+			fmt.Printf("  Interfaces: %v\n", err)
+			continue
+		}
+		for _, info := range ifaces {
+			fmt.Printf("  Interface %d.%d: %s\n", info.Interface, info.InterfaceAlternate, usbid.ClassifyInterface(info))
+			for _, end := range info.Endpoints {
+				fmt.Printf("    Endpoint 0x%02x %-3s %-11s max packet %d interval %d\n", end.Address, end.Direction(), end.TransferType(), end.MaxPacketSize, end.Interval)
+				if c := end.Companion; c != nil {
+					fmt.Printf("      Companion max burst %d max streams %d bytes per interval %d\n", c.MaxBurst+1, c.MaxStreams(), c.BytesPerInterval)
+				}
+			}
+		}
+	}
+	return nil
+}
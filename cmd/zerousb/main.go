@@ -0,0 +1,108 @@
+// Command zerousb inspects the USB devices attached to the system through the
+// zerousb library. Besides being a debugging aid, it doubles as a smoke test
+// that the cgo build of libusb works on a given machine.
+//
+// Usage:
+//
+//	zerousb <command> [flags]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chay22/zerousb"
+)
+
+// command is a single subcommand of the tool.
+type command struct {
+	help string                    // Short description for the usage screen
+	run  func(args []string) error // Entry point receiving the arguments after the command name
+}
+
+// commands is the set of subcommands supported by the tool.
+var commands = map[string]command{
+	"dump":  {"print the full descriptor hierarchy of a device", runDump},
+	"list":  {"list attached devices and their interfaces", runList},
+	"udev":  {"print udev rules granting access to devices", runUdev},
+	"watch": {"print device attach and detach events", runWatch},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "zerousb: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	if err := zerousb.Init(); err != nil {
+		fmt.Fprintf(os.Stderr, "zerousb: %v\n", err)
+		os.Exit(1)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "zerousb: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// usage prints the available subcommands.
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "Usage: zerousb <command> [flags]\n\nCommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", name, commands[name].help)
+	}
+}
+
+// idFlag is a flag.Value parsing hexadecimal vendor or product IDs.
+type idFlag zerousb.ID
+
+func (id *idFlag) String() string {
+	return zerousb.ID(*id).String()
+}
+
+func (id *idFlag) Set(s string) error {
+	v, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 16)
+	if err != nil {
+		return fmt.Errorf("invalid id %q", s)
+	}
+	*id = idFlag(v)
+	return nil
+}
+
+// parseIDPair parses a "vid:pid" device filter, where either side may be empty.
+func parseIDPair(s string) (zerousb.ID, zerousb.ID, error) {
+	var vid, pid idFlag
+
+	parts := strings.SplitN(s, ":", 2)
+	if parts[0] != "" {
+		if err := vid.Set(parts[0]); err != nil {
+			return 0, 0, err
+		}
+	}
+	if len(parts) == 2 && parts[1] != "" {
+		if err := pid.Set(parts[1]); err != nil {
+			return 0, 0, err
+		}
+	}
+	return zerousb.ID(vid), zerousb.ID(pid), nil
+}
+
+// newFlagSet creates a flag set for a subcommand which returns parse errors
+// instead of exiting.
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet("zerousb "+name, flag.ContinueOnError)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/chay22/zerousb"
+)
+
+// runUdev prints the udev rule granting access to a device, or to all devices
+// of a vendor if no product id is given. The device doesn't need to be attached.
+func runUdev(args []string) error {
+	flags := newFlagSet("udev")
+	filter := flags.String("d", "", "generate rules for devices matching `vid:pid` (hex, the product may be empty)")
+	opts := new(zerousb.UdevOptions)
+	flags.StringVar(&opts.Group, "group", "", "`group` owning the device nodes")
+	flags.StringVar(&opts.Owner, "owner", "", "`user` owning the device nodes")
+	flags.StringVar(&opts.Mode, "mode", "", "permissions of the device nodes (default 0660 with -group, -owner or -uaccess, 0666 otherwise)")
+	flags.BoolVar(&opts.Uaccess, "uaccess", false, "grant access to the user logged in at the local seat")
+	flags.BoolVar(&opts.HIDRaw, "hidraw", false, "also grant access to the hidraw nodes of the devices")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	vid, pid, err := parseIDPair(*filter)
+	if err != nil {
+		return err
+	}
+	if vid == 0 {
+		return errors.New("udev: a vendor id is required (-d vid:pid)")
+	}
+	// Without a product id, a single rule covers the whole vendor
+	opts.AnyProduct = pid == 0
+
+	// Attached devices only lend their names to the rules, so enumeration
+	// failures (e.g. no usbfs in a container) fall back to the bare ids
+	info := zerousb.DeviceInfo{VendorID: uint16(vid), ProductID: uint16(pid)}
+	if !opts.AnyProduct {
+		if attached, err := zerousb.ListDevices(func(info zerousb.DeviceInfo) bool {
+			return zerousb.ID(info.VendorID) == vid && zerousb.ID(info.ProductID) == pid
+		}); err == nil && len(attached) > 0 {
+			info = attached[0]
+		}
+	}
+	rule, err := zerousb.UdevRule(info, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Print(rule)
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/chay22/zerousb"
+	"github.com/chay22/zerousb/usbid"
+)
+
+// runWatch prints a line for every device attach and detach until interrupted.
+func runWatch(args []string) error {
+	flags := newFlagSet("watch")
+	filter := flags.String("d", "", "only report devices matching `vid:pid` (hex, either may be empty)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	vid, pid, err := parseIDPair(*filter)
+	if err != nil {
+		return err
+	}
+	// Hotplug handlers must not block, so print from a separate goroutine
+	type change struct {
+		event zerousb.HotplugEvent
+		info  zerousb.DeviceInfo
+		time  time.Time
+	}
+	changes := make(chan change, 64)
+
+	cancel, err := zerousb.OnHotplug(func(event zerousb.HotplugEvent, info zerousb.DeviceInfo) {
+		if (vid != 0 && zerousb.ID(info.VendorID) != vid) || (pid != 0 && zerousb.ID(info.ProductID) != pid) {
+			return
+		}
+		select {
+		case changes <- change{event, info, time.Now()}:
+		default:
+			fmt.Fprintln(os.Stderr, "zerousb: event queue full, dropping event")
+		}
+	})
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	for {
+		select {
+		case c := <-changes:
+			fmt.Printf("%s %-7s Bus %03d Port %03d: ID %04x:%04x %s\n", c.time.Format("15:04:05.000"), c.event,
+				c.info.Bus, c.info.Port, c.info.VendorID, c.info.ProductID, usbid.Describe(c.info))
+		case <-interrupt:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,67 @@
+//go:build (freebsd && cgo) || (linux && cgo) || (openbsd && cgo) || (netbsd && cgo) || (darwin && !ios && cgo && !iokit) || (windows && cgo && !winusb)
+
+package zerousb
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Tests that transfers, settings and Close can be called concurrently from
+// many goroutines, meant to run under the race detector. The device has no
+// handle, so transfers fail with ErrDeviceClosed without reaching libusb.
+func TestConcurrentUse(t *testing.T) {
+	reader, writer := uint8(0x81), uint8(0x02)
+	kind := uint8(TransferTypeBulk)
+	dev := newLibusbDevice(DeviceInfo{
+		libusbReader:       &reader,
+		libusbWriter:       &writer,
+		readerTransferType: &kind,
+		writerTransferType: &kind,
+	}, nil, false)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			buf := make([]byte, 64)
+			for j := 0; j < 100; j++ {
+				var err error
+				switch (i + j) % 6 {
+				case 0:
+					_, err = dev.Read(buf)
+				case 1:
+					_, err = dev.Write(buf)
+				case 2:
+					_, err = dev.Control(ControlIn, 0, 0, 0, buf[:2])
+				case 3:
+					dev.SetTimeouts(time.Duration(j)*time.Millisecond, time.Duration(j)*time.Millisecond)
+					dev.SetControlTimeout(time.Duration(j) * time.Millisecond)
+				case 4:
+					dev.SetReadTimeout(j)
+					dev.SetWriteTimeout(j)
+					dev.SetRetryPolicy(&RetryPolicy{MaxAttempts: j})
+				case 5:
+					err = dev.Close()
+				}
+				if err != nil && !errors.Is(err, ErrDeviceClosed) {
+					t.Errorf("goroutine %d: unexpected error: %v", i, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	select {
+	case <-dev.Done():
+	default:
+		t.Fatalf("device not done after close")
+	}
+	if err := dev.Err(); !errors.Is(err, ErrDeviceClosed) {
+		t.Errorf("lifetime error mismatch: have %v, want %v", err, ErrDeviceClosed)
+	}
+}
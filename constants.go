@@ -101,6 +101,8 @@ const (
 	DescriptorTypeReport    DescriptorType = 0x22
 	DescriptorTypePhysical  DescriptorType = 0x23
 	DescriptorTypeHub       DescriptorType = 0x29
+
+	DescriptorTypeSSEndpointCompanion DescriptorType = 0x30
 )
 
 var descriptorTypeDescription = map[DescriptorType]string{
@@ -113,6 +115,8 @@ var descriptorTypeDescription = map[DescriptorType]string{
 	DescriptorTypeReport:    "HID report",
 	DescriptorTypePhysical:  "physical",
 	DescriptorTypeHub:       "hub",
+
+	DescriptorTypeSSEndpointCompanion: "SuperSpeed endpoint companion",
 }
 
 func (dt DescriptorType) String() string {
@@ -245,19 +249,21 @@ type Speed int
 
 // Device speeds as defined in the USB spec.
 const (
-	SpeedUnknown Speed = 0x0
-	SpeedLow     Speed = 0x1
-	SpeedFull    Speed = 0x2
-	SpeedHigh    Speed = 0x3
-	SpeedSuper   Speed = 0x4
+	SpeedUnknown   Speed = 0x0
+	SpeedLow       Speed = 0x1
+	SpeedFull      Speed = 0x2
+	SpeedHigh      Speed = 0x3
+	SpeedSuper     Speed = 0x4
+	SpeedSuperPlus Speed = 0x5
 )
 
 var deviceSpeedDescription = map[Speed]string{
-	SpeedUnknown: "unknown",
-	SpeedLow:     "low",
-	SpeedFull:    "full",
-	SpeedHigh:    "high",
-	SpeedSuper:   "super",
+	SpeedUnknown:   "unknown",
+	SpeedLow:       "low",
+	SpeedFull:      "full",
+	SpeedHigh:      "high",
+	SpeedSuper:     "super",
+	SpeedSuperPlus: "super plus",
 }
 
 // String returns a human-readable name of the device speed.
@@ -0,0 +1,126 @@
+package zerousb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotInitialized is returned when enumerating, watching or wrapping devices
+// through libusb before Init set it up, or after Exit.
+var ErrNotInitialized = errors.New("usb: backend not initialized, see Init")
+
+// LibusbLogLevel is the verbosity of the messages libusb logs to stderr.
+type LibusbLogLevel int
+
+// Log levels of libusb, from quietest to most verbose.
+const (
+	LibusbLogNone    LibusbLogLevel = 0
+	LibusbLogError   LibusbLogLevel = 1
+	LibusbLogWarning LibusbLogLevel = 2
+	LibusbLogInfo    LibusbLogLevel = 3
+	LibusbLogDebug   LibusbLogLevel = 4
+)
+
+// ContextOption customizes how the libusb context is initialized, see
+// Configure.
+type ContextOption func(*contextOptions)
+
+// contextOptions collects the settings of the options passed to Configure.
+type contextOptions struct {
+	usbdk       bool            // Access devices through the UsbDk driver on Windows
+	noDiscovery bool            // Skip scanning for devices, only wrapped fds are used
+	logLevel    *LibusbLogLevel // Verbosity of libusb, its default if nil
+}
+
+// WithUsbDk accesses devices through the UsbDk backend of libusb instead of
+// WinUSB, so devices bound to other drivers can be opened. Initialization
+// fails if UsbDk isn't installed, or on platforms other than Windows.
+func WithUsbDk() ContextOption {
+	return func(o *contextOptions) { o.usbdk = true }
+}
+
+// WithNoDeviceDiscovery stops libusb from scanning for devices, which fails
+// where usbfs can't be accessed (e.g. sandboxes handing over device fds).
+// Devices are only reachable through OpenFromFD then. Always set on Android.
+// libusb keeps the option for the rest of the process, even if it's left out
+// when configuring again.
+func WithNoDeviceDiscovery() ContextOption {
+	return func(o *contextOptions) { o.noDiscovery = true }
+}
+
+// WithLibusbLogLevel sets the verbosity of libusb, which otherwise follows the
+// LIBUSB_DEBUG environment variable.
+func WithLibusbLogLevel(level LibusbLogLevel) ContextOption {
+	return func(o *contextOptions) { o.logLevel = &level }
+}
+
+// Configure sets the options the libusb context is initialized with by Init,
+// replacing the ones configured before. As libusb only takes them at
+// initialization, it fails once the context is in use, unless the options are
+// the same; call it before Init, or after Exit. The other backends have
+// nothing to configure and ignore the options.
+func Configure(opts ...ContextOption) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	var o contextOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return configure(o)
+}
+
+// Init configures the backend with the options, if any, and initializes it.
+// The libusb backends must be initialized before devices are enumerated,
+// watched or wrapped, failing with ErrNotInitialized otherwise, so processes
+// decide when it happens, e.g. after dropping privileges. Failures are
+// returned with the libusb version and options involved. Once initialized,
+// calling it again does nothing, while different options fail as with
+// Configure. Backends other than libusb have nothing to initialize, the ones
+// registered by RegisterBackend are created if in use.
+func Init(opts ...ContextOption) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if len(opts) > 0 {
+		var o contextOptions
+		for _, opt := range opts {
+			opt(&o)
+		}
+		if err := configure(o); err != nil {
+			return err
+		}
+	}
+	if backendName != "" {
+		_, err := registered()
+		return err
+	}
+	return initContext()
+}
+
+// equal reports whether two sets of options configure the same context.
+func (o contextOptions) equal(other contextOptions) bool {
+	if (o.logLevel == nil) != (other.logLevel == nil) || (o.logLevel != nil && *o.logLevel != *other.logLevel) {
+		return false
+	}
+	return o.usbdk == other.usbdk && o.noDiscovery == other.noDiscovery
+}
+
+// String lists the options which are set, for initialization errors.
+func (o contextOptions) String() string {
+	var opts []string
+	if o.usbdk {
+		opts = append(opts, "usbdk")
+	}
+	if o.noDiscovery {
+		opts = append(opts, "no device discovery")
+	}
+	if o.logLevel != nil {
+		opts = append(opts, fmt.Sprintf("log level %d", *o.logLevel))
+	}
+	if len(opts) == 0 {
+		return "default options"
+	}
+	return strings.Join(opts, ", ")
+}
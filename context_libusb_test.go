@@ -0,0 +1,99 @@
+//go:build (freebsd && cgo) || (linux && cgo) || (openbsd && cgo) || (netbsd && cgo) || (darwin && !ios && cgo && !iokit) || (windows && cgo && !winusb)
+
+package zerousb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Tests that options are only taken while the context isn't initialized.
+func TestConfigure(t *testing.T) {
+	if err := Exit(); err != nil {
+		t.Fatalf("failed to exit: %v", err)
+	}
+	t.Cleanup(func() {
+		Exit()
+		Configure()
+	})
+	// Device discovery stays disabled once done, leave it be for other tests
+	if err := Configure(WithLibusbLogLevel(LibusbLogWarning)); err != nil {
+		t.Fatalf("failed to configure: %v", err)
+	}
+	if contextOpts.logLevel == nil || *contextOpts.logLevel != LibusbLogWarning {
+		t.Errorf("options mismatch: have %+v", contextOpts)
+	}
+	if err := Init(); err != nil {
+		t.Skipf("libusb not available: %v", err)
+	}
+	if err := Configure(); err == nil {
+		t.Errorf("initialized context configured")
+	}
+}
+
+// Tests that the backend is unusable until initialized, that initializing
+// again with the same options does nothing, while failures report the options
+// involved.
+func TestInit(t *testing.T) {
+	if err := Exit(); err != nil {
+		t.Fatalf("failed to exit: %v", err)
+	}
+	t.Cleanup(func() {
+		Exit()
+		Configure()
+	})
+	if _, err := ListDevices(func(DeviceInfo) bool { return true }); !errors.Is(err, ErrNotInitialized) {
+		t.Errorf("uninitialized listing error mismatch: have %v, want %v", err, ErrNotInitialized)
+	}
+	if _, err := OnHotplug(func(HotplugEvent, DeviceInfo) {}); !errors.Is(err, ErrNotInitialized) {
+		t.Errorf("uninitialized hotplug error mismatch: have %v, want %v", err, ErrNotInitialized)
+	}
+	if err := Init(WithLibusbLogLevel(LibusbLogWarning)); err != nil {
+		if !strings.Contains(err.Error(), "log level 2") {
+			t.Errorf("initialization error lacks the options: %v", err)
+		}
+		t.Skipf("libusb not available: %v", err)
+	}
+	if err := Init(WithLibusbLogLevel(LibusbLogWarning)); err != nil {
+		t.Errorf("failed to initialize again: %v", err)
+	}
+	if err := Init(WithUsbDk()); err == nil {
+		t.Errorf("initialized context reconfigured")
+	}
+	if ctx, err := NewContext(); err != nil || ctx != &libusbCtx {
+		t.Errorf("context mismatch: have %p, %v", ctx, err)
+	}
+}
+
+// Tests that handles of something other than a device are refused, and left
+// open for the caller.
+func TestWrapSysDevice(t *testing.T) {
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", os.DevNull, err)
+	}
+	defer f.Close()
+
+	if dev, err := libusbCtx.WrapSysDevice(f.Fd()); err == nil {
+		dev.Close()
+		t.Fatalf("non-device handle wrapped")
+	}
+	if _, err := f.Stat(); err != nil {
+		t.Errorf("handle closed: %v", err)
+	}
+}
+
+// Tests that device nodes missing or of something other than a device fail to
+// open.
+func TestOpenPath(t *testing.T) {
+	if _, err := OpenPath(filepath.Join(t.TempDir(), "007")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing node error mismatch: have %v, want %v", err, os.ErrNotExist)
+	}
+	if dev, err := OpenPath(os.DevNull); err == nil {
+		dev.Close()
+		t.Errorf("non-device node opened")
+	}
+}
@@ -0,0 +1,269 @@
+// Package ctaphid implements the CTAP-HID transport framing of FIDO security
+// keys (FIDO2 CTAP 2.1 spec, section 11.2) over zerousb interrupt transfers.
+//
+// Security keys are HID devices, so they need to be discovered through
+// zerousb.EnumerateHID, e.g. by matching the FIDO usage page interface.
+package ctaphid
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Command identifiers defined by the CTAP-HID spec.
+const (
+	CmdPing      = 0x01
+	CmdMsg       = 0x03
+	CmdLock      = 0x04
+	CmdInit      = 0x06
+	CmdWink      = 0x08
+	CmdCBOR      = 0x10
+	CmdCancel    = 0x11
+	CmdKeepAlive = 0x3b
+	CmdError     = 0x3f
+)
+
+const (
+	reportSize      = 64         // Size of every HID report exchanged
+	initPayload     = 57         // Payload bytes of an initialization packet
+	contPayload     = 59         // Payload bytes of a continuation packet
+	maxMessage      = 7609       // Largest message: 57 + 128 * 59 bytes
+	broadcastCID    = 0xffffffff // Channel used to allocate a channel
+	commandBit      = 0x80       // Marks an initialization packet
+	nonceLength     = 8          // Length of the CTAPHID_INIT nonce
+	initResponseLen = 17         // Length of the CTAPHID_INIT response
+)
+
+// Capability flags reported by CTAPHID_INIT.
+const (
+	CapabilityWink = 0x01
+	CapabilityCBOR = 0x04
+	CapabilityNMSG = 0x08
+)
+
+// Error is a CTAPHID_ERROR code returned by the authenticator.
+type Error uint8
+
+// Error codes defined by the CTAP-HID spec.
+const (
+	ErrInvalidCmd     Error = 0x01
+	ErrInvalidPar     Error = 0x02
+	ErrInvalidLen     Error = 0x03
+	ErrInvalidSeq     Error = 0x04
+	ErrMsgTimeout     Error = 0x05
+	ErrChannelBusy    Error = 0x06
+	ErrLockRequired   Error = 0x0a
+	ErrInvalidChannel Error = 0x0b
+	ErrOther          Error = 0x7f
+)
+
+// Error implements the error interface.
+func (e Error) Error() string {
+	return fmt.Sprintf("ctaphid: authenticator error 0x%02x", uint8(e))
+}
+
+// Transport is the subset of zerousb.Device needed to speak CTAP-HID.
+type Transport interface {
+	Read(b []byte) (int, error)
+	Write(b []byte) (int, error)
+}
+
+// Device is a channel to a CTAP-HID authenticator.
+type Device struct {
+	dev Transport
+
+	lock sync.Mutex // Serializes transactions, one may be outstanding per channel
+	cid  uint32     // Channel allocated via CTAPHID_INIT
+
+	ProtocolVersion uint8    // CTAP-HID protocol version of the device
+	Version         [3]uint8 // Major, minor and build version of the device
+	Capabilities    uint8    // Capability flags of the device
+}
+
+// New allocates a channel on an opened authenticator.
+func New(dev Transport) (*Device, error) {
+	d := &Device{dev: dev, cid: broadcastCID}
+
+	nonce := make([]byte, nonceLength)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	// Responses to the broadcast channel may belong to other hosts, filter
+	// them by the nonce
+	for {
+		cmd, res, err := d.transact(CmdInit, nonce)
+		if err != nil {
+			return nil, err
+		}
+		if cmd != CmdInit || len(res) < initResponseLen || !bytes.Equal(res[:nonceLength], nonce) {
+			continue
+		}
+		d.cid = binary.BigEndian.Uint32(res[8:])
+		d.ProtocolVersion = res[12]
+		copy(d.Version[:], res[13:16])
+		d.Capabilities = res[16]
+		return d, nil
+	}
+}
+
+// Ping sends data to the authenticator, which echoes it back.
+func (d *Device) Ping(data []byte) ([]byte, error) {
+	return d.Call(CmdPing, data)
+}
+
+// Wink asks the authenticator to identify itself, e.g. by blinking.
+func (d *Device) Wink() error {
+	_, err := d.Call(CmdWink, nil)
+	return err
+}
+
+// CBOR sends a CTAP2 command with its CBOR encoded parameters, returning the
+// status byte and the CBOR encoded response.
+func (d *Device) CBOR(command uint8, params []byte) (uint8, []byte, error) {
+	res, err := d.Call(CmdCBOR, append([]byte{command}, params...))
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(res) == 0 {
+		return 0, nil, errors.New("ctaphid: empty cbor response")
+	}
+	return res[0], res[1:], nil
+}
+
+// Msg sends a raw CTAP1/U2F APDU, returning the response APDU.
+func (d *Device) Msg(apdu []byte) ([]byte, error) {
+	return d.Call(CmdMsg, apdu)
+}
+
+// Call runs a single command on the channel, returning the response payload.
+// Keep-alive messages sent while the authenticator waits for user presence
+// are skipped transparently.
+func (d *Device) Call(cmd uint8, data []byte) ([]byte, error) {
+	res, data, err := d.transact(cmd, data)
+	if err != nil {
+		return nil, err
+	}
+	if res != cmd {
+		return nil, fmt.Errorf("ctaphid: unexpected response command 0x%02x", res)
+	}
+	return data, nil
+}
+
+// transact sends a message and reads the response on the channel.
+func (d *Device) transact(cmd uint8, data []byte) (uint8, []byte, error) {
+	if len(data) > maxMessage {
+		return 0, nil, fmt.Errorf("ctaphid: message too long: %d bytes", len(data))
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if err := d.send(cmd, data); err != nil {
+		return 0, nil, err
+	}
+	for {
+		res, payload, err := d.receive()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch res {
+		case CmdKeepAlive:
+			continue
+		case CmdError:
+			if len(payload) == 0 {
+				return 0, nil, ErrOther
+			}
+			return 0, nil, Error(payload[0])
+		}
+		return res, payload, nil
+	}
+}
+
+// send fragments a message into an initialization packet followed by as many
+// continuation packets as needed.
+func (d *Device) send(cmd uint8, data []byte) error {
+	report := make([]byte, reportSize)
+	binary.BigEndian.PutUint32(report, d.cid)
+	report[4] = cmd | commandBit
+	binary.BigEndian.PutUint16(report[5:], uint16(len(data)))
+	n := copy(report[7:], data)
+	data = data[n:]
+
+	if _, err := d.dev.Write(report); err != nil {
+		return fmt.Errorf("ctaphid: failed to send: %w", err)
+	}
+	for seq := uint8(0); len(data) > 0; seq++ {
+		report = make([]byte, reportSize)
+		binary.BigEndian.PutUint32(report, d.cid)
+		report[4] = seq
+		n := copy(report[5:], data)
+		data = data[n:]
+
+		if _, err := d.dev.Write(report); err != nil {
+			return fmt.Errorf("ctaphid: failed to send: %w", err)
+		}
+	}
+	return nil
+}
+
+// receive reassembles a single message sent on the channel, ignoring packets
+// destined to other channels.
+func (d *Device) receive() (uint8, []byte, error) {
+	report := make([]byte, reportSize)
+	for {
+		if err := d.read(report); err != nil {
+			return 0, nil, err
+		}
+		if binary.BigEndian.Uint32(report) != d.cid {
+			continue
+		}
+		if report[4]&commandBit == 0 {
+			continue // Stray continuation packet, wait for the next message
+		}
+		cmd := report[4] &^ commandBit
+		length := int(binary.BigEndian.Uint16(report[5:]))
+		if length > maxMessage {
+			return 0, nil, fmt.Errorf("ctaphid: message too long: %d bytes", length)
+		}
+		data := make([]byte, 0, length)
+		data = append(data, report[7:7+min(length, initPayload)]...)
+
+		for seq := uint8(0); len(data) < length; {
+			if err := d.read(report); err != nil {
+				return 0, nil, err
+			}
+			if binary.BigEndian.Uint32(report) != d.cid {
+				continue
+			}
+			if report[4] != seq {
+				return 0, nil, ErrInvalidSeq
+			}
+			data = append(data, report[5:5+min(length-len(data), contPayload)]...)
+			seq++
+		}
+		return cmd, data, nil
+	}
+}
+
+// read receives a single report from the device.
+func (d *Device) read(report []byte) error {
+	n, err := d.dev.Read(report)
+	if err != nil {
+		return fmt.Errorf("ctaphid: failed to receive: %w", err)
+	}
+	if n != reportSize {
+		return fmt.Errorf("ctaphid: short report: %d bytes", n)
+	}
+	return nil
+}
+
+// min returns the smaller of two ints.
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
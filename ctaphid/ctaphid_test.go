@@ -0,0 +1,81 @@
+package ctaphid
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// fakeKey is an authenticator allocating channel 0x01020304 and echoing every
+// other message back verbatim, interleaving keep-alives and foreign reports.
+type fakeKey struct {
+	out     [][]byte // Reports written by the host
+	pending [][]byte // Reports queued for the host
+}
+
+func (f *fakeKey) Write(b []byte) (int, error) {
+	f.out = append(f.out, append([]byte(nil), b...))
+
+	// Reassemble the message to know when to reply
+	first := f.out[0]
+	length := int(binary.BigEndian.Uint16(first[5:]))
+	if length > initPayload+(len(f.out)-1)*contPayload {
+		return len(b), nil
+	}
+	var msg []byte
+	msg = append(msg, first[7:]...)
+	for _, cont := range f.out[1:] {
+		msg = append(msg, cont[5:]...)
+	}
+	msg = msg[:length]
+	f.out = nil
+
+	cid := binary.BigEndian.Uint32(first)
+	cmd := first[4] &^ commandBit
+	if cmd == CmdInit {
+		msg = append(msg, 0x01, 0x02, 0x03, 0x04, 2, 1, 0, 0, CapabilityCBOR)
+	} else {
+		// Interleave noise the client has to skip
+		f.pending = append(f.pending, report(0xdeadbeef, CmdPing|commandBit, []byte{0, 1, 0}))
+		f.pending = append(f.pending, report(cid, CmdKeepAlive|commandBit, []byte{0, 1, 1}))
+	}
+	f.pending = append(f.pending, report(cid, cmd|commandBit, append([]byte{uint8(len(msg) >> 8), uint8(len(msg))}, msg...)))
+	for seq := 0; len(msg) > initPayload+seq*contPayload; seq++ {
+		f.pending = append(f.pending, report(cid, uint8(seq), msg[initPayload+seq*contPayload:]))
+	}
+	return len(b), nil
+}
+
+func (f *fakeKey) Read(b []byte) (int, error) {
+	n := copy(b, f.pending[0])
+	f.pending = f.pending[1:]
+	return n, nil
+}
+
+// report assembles a raw HID report.
+func report(cid uint32, cmd uint8, payload []byte) []byte {
+	buf := make([]byte, reportSize)
+	binary.BigEndian.PutUint32(buf, cid)
+	buf[4] = cmd
+	copy(buf[5:], payload)
+	return buf
+}
+
+// Tests channel allocation and fragmentation of long messages.
+func TestPing(t *testing.T) {
+	dev, err := New(&fakeKey{})
+	if err != nil {
+		t.Fatalf("failed to allocate channel: %v", err)
+	}
+	if dev.cid != 0x01020304 || dev.Capabilities != CapabilityCBOR {
+		t.Fatalf("init response mismatch: cid %#x, caps %#x", dev.cid, dev.Capabilities)
+	}
+	data := bytes.Repeat([]byte("fido"), 100)
+	echo, err := dev.Ping(data)
+	if err != nil {
+		t.Fatalf("failed to ping: %v", err)
+	}
+	if !bytes.Equal(echo, data) {
+		t.Errorf("echo mismatch: have %d bytes, want %d", len(echo), len(data))
+	}
+}
@@ -0,0 +1,289 @@
+package zerousb
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Standard descriptor constants for backends without libusb, which have to
+// retrieve and split the descriptors themselves.
+const (
+	descriptorTypeBOS    = 0x0f
+	deviceDescriptorSize = 18
+	configDescriptorSize = 9
+	bosDescriptorSize    = 5
+	requestGetDescriptor = 0x06
+)
+
+// descriptorGetter issues a standard GET_DESCRIPTOR request on a device.
+type descriptorGetter func(kind uint8, index uint8, length int) ([]byte, error)
+
+// readRawDescriptors retrieves the device and all configuration descriptors
+// through a GET_DESCRIPTOR primitive.
+func readRawDescriptors(get descriptorGetter) (*RawDescriptors, error) {
+	devDesc, err := get(uint8(DescriptorTypeDevice), 0, deviceDescriptorSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device descriptor: %w", err)
+	}
+	if len(devDesc) < deviceDescriptorSize {
+		return nil, fmt.Errorf("short device descriptor: %d bytes", len(devDesc))
+	}
+	raw := &RawDescriptors{Device: devDesc}
+
+	// The number of configurations is the last field of the device descriptor
+	for cfgnum := 0; cfgnum < int(devDesc[deviceDescriptorSize-1]); cfgnum++ {
+		// Fetch the header first to learn the total length of the hierarchy
+		header, err := get(uint8(DescriptorTypeConfig), uint8(cfgnum), configDescriptorSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get config %d descriptor: %w", cfgnum, err)
+		}
+		if len(header) < 4 {
+			return nil, fmt.Errorf("short config %d descriptor: %d bytes", cfgnum, len(header))
+		}
+		// A hierarchy shorter than its own header is malformed, and would have
+		// the request issued with an empty buffer
+		total := int(binary.LittleEndian.Uint16(header[2:]))
+		if total < configDescriptorSize {
+			return nil, fmt.Errorf("invalid config %d descriptor length: %d bytes", cfgnum, total)
+		}
+		config, err := get(uint8(DescriptorTypeConfig), uint8(cfgnum), total)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get config %d descriptor: %w", cfgnum, err)
+		}
+		raw.Configs = append(raw.Configs, config)
+	}
+	return raw, nil
+}
+
+// readBOS retrieves the Binary Object Store through a GET_DESCRIPTOR primitive
+// and splits it into its device capabilities.
+func readBOS(get descriptorGetter) (*BOSDescriptor, error) {
+	header, err := get(descriptorTypeBOS, 0, bosDescriptorSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bos descriptor: %w", err)
+	}
+	if len(header) < bosDescriptorSize {
+		return nil, fmt.Errorf("short bos descriptor: %d bytes", len(header))
+	}
+	raw, err := get(descriptorTypeBOS, 0, int(binary.LittleEndian.Uint16(header[2:])))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bos descriptor: %w", err)
+	}
+	var caps []BOSCapability
+	for rest := raw[bosDescriptorSize:]; len(rest) >= 3; rest = rest[rest[0]:] {
+		if size := int(rest[0]); size < 3 || size > len(rest) {
+			return nil, fmt.Errorf("malformed bos capability of %d bytes", size)
+		}
+		caps = append(caps, BOSCapability{Type: rest[2], Data: append([]byte{}, rest[3:rest[0]]...)})
+	}
+	return parseBOS(caps)
+}
+
+// ConfigInfo describes the power attributes of a device configuration.
+type ConfigInfo struct {
+	Value        uint8        // bConfigurationValue, as passed to SetConfiguration
+	SelfPowered  bool         // Whether the device is powered by its own supply
+	RemoteWakeup bool         // Whether the device can wake the host from suspend
+	MaxPower     Milliamperes // Maximum current drawn from the bus while configured
+}
+
+// maxPower converts bMaxPower into milliamperes. It's counted in 2mA units, or
+// in 8mA units when operating at SuperSpeed, which devices signal by reporting
+// a USB version of 3.0 or later.
+func maxPower(raw uint8, usbVersion uint16) Milliamperes {
+	if usbVersion >= 0x0300 {
+		return Milliamperes(raw) * 8
+	}
+	return Milliamperes(raw) * 2
+}
+
+// parseConfigInfo decodes the header of a configuration descriptor.
+func parseConfigInfo(config []byte, usbVersion uint16) (ConfigInfo, error) {
+	if len(config) < configDescriptorSize {
+		return ConfigInfo{}, fmt.Errorf("short config descriptor: %d bytes", len(config))
+	}
+	return ConfigInfo{
+		Value:        config[5],
+		SelfPowered:  config[7]&selfPoweredMask != 0,
+		RemoteWakeup: config[7]&remoteWakeupMask != 0,
+		MaxPower:     maxPower(config[8], usbVersion),
+	}, nil
+}
+
+// Configurations decodes the power attributes of all the configurations, in the
+// order of the raw configuration descriptors.
+func (raw *RawDescriptors) Configurations() ([]ConfigInfo, error) {
+	if len(raw.Device) < deviceDescriptorSize {
+		return nil, fmt.Errorf("short device descriptor: %d bytes", len(raw.Device))
+	}
+	version := binary.LittleEndian.Uint16(raw.Device[2:])
+
+	configs := make([]ConfigInfo, 0, len(raw.Configs))
+	for cfgnum, config := range raw.Configs {
+		info, err := parseConfigInfo(config, version)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse config %d: %w", cfgnum, err)
+		}
+		configs = append(configs, info)
+	}
+	return configs, nil
+}
+
+// describeConfig converts the interface alternate settings of a configuration
+// descriptor hierarchy into infos, on top of the device level fields of the
+// base info. Only interfaces with interrupt or bulk endpoints in both
+// directions are described, HID ones only if hid is set.
+func describeConfig(base DeviceInfo, config []byte, hid bool) ([]DeviceInfo, error) {
+	alts, err := parseAltSettings(config)
+	if err != nil {
+		return nil, err
+	}
+	cfginfo, err := parseConfigInfo(config, base.USBVersion)
+	if err != nil {
+		return nil, err
+	}
+	var infos []DeviceInfo
+	for _, alt := range alts {
+		// Skip HID interfaces, they are handled directly by OS libraries
+		if !hid && Class(alt.Class) == ClassHID {
+			continue
+		}
+		var reader, writer *uint8
+		var readerTransferType, writerTransferType uint8
+		for _, end := range alt.Endpoints {
+			// Skip any non-interrupt and bulk endpoints
+			if kind := end.TransferType(); kind != TransferTypeInterrupt && kind != TransferTypeBulk {
+				continue
+			}
+			if end.Direction() == EndpointDirectionIn {
+				reader, readerTransferType = new(uint8), uint8(end.TransferType())
+				*reader = end.Address
+			} else {
+				writer, writerTransferType = new(uint8), uint8(end.TransferType())
+				*writer = end.Address
+			}
+		}
+		// If both in and out endpoints are available, match the device
+		if reader == nil || writer == nil {
+			continue
+		}
+		info := base
+		if base.Path != "" {
+			info.Path = pathWithInterface(base.Path, int(alt.Number))
+		}
+		info.Interface = int(alt.Number)
+		info.InterfaceNumber = int(alt.Number)
+		info.InterfaceAlternate = int(alt.Alternate)
+		info.InterfaceClass = alt.Class
+		info.InterfaceSubClass = alt.SubClass
+		info.InterfaceProtocol = alt.Protocol
+		info.Endpoints = alt.Endpoints
+		info.Config = cfginfo
+		info.libusbReader = reader
+		info.libusbWriter = writer
+		info.readerTransferType = &readerTransferType
+		info.writerTransferType = &writerTransferType
+
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Interfaces describes the device interfaces enumeration reports for a device
+// with these descriptors, with the device level fields decoded from the device
+// descriptor. It runs the enumeration logic of the backends parsing raw
+// descriptors, so matching code can be tested against synthetic descriptors.
+// HID devices and interfaces are only described if hid is set.
+func (raw *RawDescriptors) Interfaces(hid bool) ([]DeviceInfo, error) {
+	if len(raw.Device) < deviceDescriptorSize {
+		return nil, fmt.Errorf("short device descriptor: %d bytes", len(raw.Device))
+	}
+	desc := raw.Device
+	base := DeviceInfo{
+		VendorID:          binary.LittleEndian.Uint16(desc[8:]),
+		ProductID:         binary.LittleEndian.Uint16(desc[10:]),
+		Release:           binary.LittleEndian.Uint16(desc[12:]),
+		USBVersion:        binary.LittleEndian.Uint16(desc[2:]),
+		Class:             desc[4],
+		SubClass:          desc[5],
+		Protocol:          desc[6],
+		ManufacturerIndex: desc[14],
+		ProductIndex:      desc[15],
+		SerialIndex:       desc[16],
+	}
+	// Skip HID devices, they are handled directly by OS libraries
+	if !hid && Class(base.Class) == ClassHID {
+		return nil, nil
+	}
+	var infos []DeviceInfo
+	for cfgnum, config := range raw.Configs {
+		ifaces, err := describeConfig(base, config, hid)
+		if err != nil {
+			return infos, fmt.Errorf("failed to parse config %d: %w", cfgnum, err)
+		}
+		infos = append(infos, ifaces...)
+	}
+	return infos, nil
+}
+
+// altSetting is an interface alternate setting parsed out of a configuration
+// descriptor hierarchy.
+type altSetting struct {
+	Number    uint8 // bInterfaceNumber
+	Alternate uint8 // bAlternateSetting
+	Class     uint8
+	SubClass  uint8
+	Protocol  uint8
+	Endpoints []EndpointInfo
+}
+
+// parseAltSettings walks a full configuration descriptor hierarchy, collecting
+// the interface alternate settings along with their endpoints. Class specific
+// descriptors are skipped.
+func parseAltSettings(config []byte) ([]altSetting, error) {
+	if len(config) < configDescriptorSize {
+		return nil, fmt.Errorf("short config descriptor: %d bytes", len(config))
+	}
+	var alts []altSetting
+	for rest := config[config[0]:]; len(rest) >= 2; rest = rest[rest[0]:] {
+		size := int(rest[0])
+		if size < 2 || size > len(rest) {
+			return nil, fmt.Errorf("malformed descriptor of %d bytes", size)
+		}
+		switch DescriptorType(rest[1]) {
+		case DescriptorTypeInterface:
+			if size < 9 {
+				return nil, fmt.Errorf("short interface descriptor: %d bytes", size)
+			}
+			alts = append(alts, altSetting{
+				Number:    rest[2],
+				Alternate: rest[3],
+				Class:     rest[5],
+				SubClass:  rest[6],
+				Protocol:  rest[7],
+			})
+		case DescriptorTypeEndpoint:
+			if size < 7 || len(alts) == 0 {
+				continue
+			}
+			alt := &alts[len(alts)-1]
+			alt.Endpoints = append(alt.Endpoints, EndpointInfo{
+				Address:       rest[2],
+				Attributes:    rest[3],
+				MaxPacketSize: binary.LittleEndian.Uint16(rest[4:]),
+				Interval:      rest[6],
+			})
+		case DescriptorTypeSSEndpointCompanion:
+			if size < 6 || len(alts) == 0 || len(alts[len(alts)-1].Endpoints) == 0 {
+				continue
+			}
+			ends := alts[len(alts)-1].Endpoints
+			ends[len(ends)-1].Companion = &EndpointCompanion{
+				MaxBurst:         rest[2],
+				Attributes:       rest[3],
+				BytesPerInterval: binary.LittleEndian.Uint16(rest[4:]),
+			}
+		}
+	}
+	return alts, nil
+}
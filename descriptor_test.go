@@ -0,0 +1,119 @@
+package zerousb
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that the power attributes of configurations are decoded, with the
+// current unit depending on the USB version of the device.
+func TestConfigurations(t *testing.T) {
+	tests := []struct {
+		version  uint16
+		attrs    uint8
+		power    uint8
+		selfPow  bool
+		wakeup   bool
+		maxPower Milliamperes
+	}{
+		{0x0200, 0x80, 50, false, false, 100},
+		{0x0210, 0xe0, 250, true, true, 500},
+		{0x0300, 0xa0, 112, false, true, 896},
+	}
+	for i, tt := range tests {
+		raw := &RawDescriptors{
+			Device:  []byte{18, 1, byte(tt.version), byte(tt.version >> 8), 0, 0, 0, 64, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1},
+			Configs: [][]byte{{9, 2, 9, 0, 0, 1, 0, tt.attrs, tt.power}},
+		}
+		configs, err := raw.Configurations()
+		if err != nil {
+			t.Fatalf("test %d: failed to decode configurations: %v", i, err)
+		}
+		if len(configs) != 1 {
+			t.Fatalf("test %d: configuration count mismatch: have %d, want 1", i, len(configs))
+		}
+		want := ConfigInfo{Value: 1, SelfPowered: tt.selfPow, RemoteWakeup: tt.wakeup, MaxPower: tt.maxPower}
+		if configs[0] != want {
+			t.Errorf("test %d: configuration mismatch: have %+v, want %+v", i, configs[0], want)
+		}
+	}
+	if _, err := (&RawDescriptors{Device: make([]byte, 18), Configs: [][]byte{{9, 2}}}).Configurations(); err == nil {
+		t.Errorf("short config descriptor accepted")
+	}
+}
+
+// Tests that configuration hierarchies claiming to be shorter than their header
+// are rejected without requesting them.
+func TestReadRawDescriptorsLength(t *testing.T) {
+	device := []byte{18, 1, 0x00, 0x02, 0, 0, 0, 64, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+	get := func(kind uint8, index uint8, length int) ([]byte, error) {
+		if length < configDescriptorSize {
+			t.Fatalf("descriptor %d requested with %d bytes", kind, length)
+		}
+		if DescriptorType(kind) == DescriptorTypeDevice {
+			return device, nil
+		}
+		return []byte{9, 2, 0, 0, 1, 1, 0, 0x80, 50}, nil
+	}
+	if _, err := readRawDescriptors(get); err == nil {
+		t.Errorf("zero total length accepted")
+	}
+}
+
+// Tests that endpoint polling intervals and SuperSpeed companions are parsed
+// out of configuration descriptors, and that the companion is attached to the
+// endpoint preceding it.
+func TestParseEndpointCompanion(t *testing.T) {
+	config := []byte{
+		9, 2, 0, 0, 1, 1, 0, 0x80, 50,
+		9, 4, 0, 0, 2, 8, 6, 0x62, 0,
+		7, 5, 0x81, 0x02, 0x00, 0x04, 0,
+		6, 0x30, 15, 0x05, 0, 0,
+		7, 5, 0x83, 0x03, 0x40, 0x00, 4,
+		6, 0x30, 0, 0, 0x40, 0,
+	}
+	alts, err := parseAltSettings(config)
+	if err != nil {
+		t.Fatalf("failed to parse config: %v", err)
+	}
+	if len(alts) != 1 || len(alts[0].Endpoints) != 2 {
+		t.Fatalf("alternate settings mismatch: have %+v", alts)
+	}
+	bulk, intr := alts[0].Endpoints[0], alts[0].Endpoints[1]
+	if bulk.Companion == nil || *bulk.Companion != (EndpointCompanion{MaxBurst: 15, Attributes: 0x05}) {
+		t.Errorf("bulk companion mismatch: have %+v", bulk.Companion)
+	}
+	if have := bulk.Companion.MaxStreams(); have != 32 {
+		t.Errorf("bulk max streams mismatch: have %d, want 32", have)
+	}
+	if intr.Interval != 4 || intr.Companion == nil || intr.Companion.BytesPerInterval != 64 {
+		t.Errorf("interrupt endpoint mismatch: have %+v, companion %+v", intr, intr.Companion)
+	}
+	if have := intr.Companion.MaxStreams(); have != 0 {
+		t.Errorf("interrupt max streams mismatch: have %d, want 0", have)
+	}
+}
+
+// Tests that bInterval is decoded according to the speed and transfer type.
+func TestPollInterval(t *testing.T) {
+	tests := []struct {
+		attrs    uint8
+		interval uint8
+		speed    Speed
+		want     time.Duration
+	}{
+		{0x03, 10, SpeedFull, 10 * time.Millisecond},
+		{0x03, 10, SpeedLow, 10 * time.Millisecond},
+		{0x01, 4, SpeedFull, 8 * time.Millisecond},
+		{0x03, 4, SpeedHigh, time.Millisecond},
+		{0x01, 1, SpeedSuper, 125 * time.Microsecond},
+		{0x03, 0, SpeedHigh, 0},
+		{0x03, 4, SpeedUnknown, 0},
+	}
+	for i, tt := range tests {
+		end := EndpointInfo{Attributes: tt.attrs, Interval: tt.interval}
+		if have := end.PollInterval(tt.speed); have != tt.want {
+			t.Errorf("test %d: interval mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
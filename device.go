@@ -4,7 +4,9 @@ package zerousb
 import (
 	"errors"
 	"fmt"
+	"net"
 	"sync"
+	"time"
 )
 
 // lock is a mutex for locking access to the device.
@@ -22,17 +24,35 @@ func (id ID) String() string {
 // during the execution.
 var ErrDeviceClosed = errors.New("usb: device closed")
 
+// ErrDeviceGone is returned for operations on a device after it was
+// disconnected, whichever error the backend reported, and by Err. It matches
+// ErrNoDevice.
+var ErrDeviceGone error = goneError{}
+
+// goneError is the type of ErrDeviceGone, matching ErrNoDevice too.
+type goneError struct{}
+
+// Error implements the error interface.
+func (goneError) Error() string { return "usb: device gone" }
+
+// Is reports whether the target is ErrNoDevice.
+func (goneError) Is(target error) bool { return target == ErrNoDevice }
+
 // ErrUnsupportedPlatform is returned for all operations where the underlying
 // operating system is not supported by the library.
 var ErrUnsupportedPlatform = errors.New("usb: unsupported platform")
 
 // DeviceInfo contains all the information we know about a USB device. In case of
 // HID devices, that might be a lot more extensive (empty fields for raw USB).
+// The exported fields fully describe the device, so infos may be serialized
+// and opened again later or elsewhere through OpenSnapshot.
 type DeviceInfo struct {
-	Path         string // Platform-specific device path
+	Path         string // Position of the device interface on the bus, see DevicePath
+	SysPath      string // OS path of the device: sysfs directory on Linux, IORegistry path on macOS, instance ID on Windows
 	VendorID     uint16 // Device Vendor ID
 	ProductID    uint16 // Device Product ID
 	Release      uint16 // Device Release Number in binary-coded decimal, also known as Device Version Number
+	USBVersion   uint16 // USB Specification Release Number in binary-coded decimal, e.g. 0x0200 for USB 2.0
 	Serial       string // Serial Number
 	Manufacturer string // Manufacturer String
 	Product      string // Product string
@@ -41,6 +61,15 @@ type DeviceInfo struct {
 	Class        uint8
 	SubClass     uint8
 	Protocol     uint8
+	Bus          uint8 // Bus number the device is connected to
+	Port         uint8 // Port number on the parent hub
+	Speed        Speed // Negotiated speed of the device, unknown on WinUSB and WebUSB
+
+	// Indexes of the manufacturer, product and serial number string descriptors,
+	// zero if the device has none. Unset on WebUSB, which only exposes the strings.
+	ManufacturerIndex uint8
+	ProductIndex      uint8
+	SerialIndex       uint8
 
 	// The USB interface which this logical device
 	// represents. Valid on both Linux implementations
@@ -53,6 +82,24 @@ type DeviceInfo struct {
 	InterfaceSubClass  uint8
 	InterfaceProtocol  uint8
 
+	// Endpoints of the interface alternate setting.
+	Endpoints []EndpointInfo
+
+	// Configuration the interface belongs to. Only the value is known on WebUSB.
+	Config ConfigInfo
+
+	// Whether the info was listed per device and its interface, alternate
+	// setting and endpoint fields are not resolved yet (ListDevices)
+	unresolved bool
+
+	// Whether the info was listed with HID devices and interfaces included
+	// (ListDevicesHID), which are then resolved by Interfaces too
+	hid bool
+
+	// Name of the registered backend the info was enumerated by, empty for the
+	// built-in one
+	backend string
+
 	// Raw low level libusb endpoint data for simplified communication
 	libusbDevice       interface{}
 	libusbPort         *uint8 // Pointer to differentiate between unset and port 0
@@ -62,16 +109,322 @@ type DeviceInfo struct {
 	writerTransferType *uint8
 }
 
+// EndpointInfo describes a single endpoint of an interface.
+type EndpointInfo struct {
+	Address       uint8  // Endpoint address, direction in the top bit
+	Attributes    uint8  // Raw bmAttributes, transfer type in the lowest two bits
+	MaxPacketSize uint16 // Maximum packet size the endpoint can send or receive
+	Interval      uint8  // Raw bInterval, polling interval of interrupt endpoints, zero if unknown (WebUSB)
+
+	// SuperSpeed endpoint companion, nil below SuperSpeed or if unknown
+	// (WinUSB, WebUSB).
+	Companion *EndpointCompanion
+}
+
+// EndpointCompanion describes the SuperSpeed specifics of an endpoint.
+type EndpointCompanion struct {
+	MaxBurst         uint8  // bMaxBurst, packets per burst minus one
+	Attributes       uint8  // Raw bmAttributes, max streams of bulk endpoints, Mult of isochronous ones
+	BytesPerInterval uint16 // wBytesPerInterval, bytes per service interval of periodic endpoints
+}
+
+// MaxStreams returns the number of bulk streams the endpoint supports, zero if
+// none. Only meaningful for bulk endpoints.
+func (c EndpointCompanion) MaxStreams() int {
+	if exp := c.Attributes & 0x1f; exp != 0 {
+		return 1 << exp
+	}
+	return 0
+}
+
+// PollInterval decodes the bInterval of a periodic endpoint into the service
+// interval at the given speed: frames of a millisecond for low and full speed
+// interrupt endpoints, exponents of 125us microframes from high speed up (and
+// of frames for full speed isochronous ones). Zero is returned if unknown.
+func (e EndpointInfo) PollInterval(speed Speed) time.Duration {
+	if e.Interval == 0 {
+		return 0
+	}
+	exp := e.Interval
+	if exp > 16 {
+		exp = 16
+	}
+	switch {
+	case speed == SpeedLow || speed == SpeedFull:
+		if e.TransferType() == TransferTypeIsochronous {
+			return time.Millisecond << (exp - 1)
+		}
+		return time.Duration(e.Interval) * time.Millisecond
+	case speed >= SpeedHigh:
+		return 125 * time.Microsecond << (exp - 1)
+	}
+	return 0
+}
+
+// Direction returns the direction of data flow through the endpoint.
+func (e EndpointInfo) Direction() EndpointDirection {
+	return e.Address&endpointDirectionMask != 0
+}
+
+// TransferType returns the transfer type of the endpoint.
+func (e EndpointInfo) TransferType() TransferType {
+	return TransferType(e.Attributes & transferTypeMask)
+}
+
+// RawDescriptors contains the unparsed descriptors as returned by the device,
+// useful for decoding class specific descriptors (CDC, UVC, DFU, etc).
+type RawDescriptors struct {
+	Device  []byte   // Standard device descriptor
+	Configs [][]byte // Full configuration hierarchies, indexed by configuration
+}
+
 // Device is a generic USB device interface. It currently only a libusb device.
+//
+// Devices are safe for concurrent use by multiple goroutines. Transfers are
+// serialized per endpoint: reads exclude each other, as do writes and control
+// requests, but a read may run alongside a write and a control request, so a
+// goroutine can block reading while another one writes. Settings such as the
+// timeouts apply to the transfers started after they are changed.
 type Device interface {
-	// Close releases the USB device.
+	// Close releases the USB device. It waits for the transfers in flight to
+	// complete or time out, so it may be called while other goroutines use
+	// the device, after which their calls fail with ErrDeviceClosed. Closing
+	// more than once is a no-op.
 	Close() error
 
 	// Write sends a binary blob to a USB device. Uses interrupt or bulk transfers.
+	// Failed transfers return a *TransferError along with the bytes written.
 	Write(b []byte) (int, error)
 
+	// WriteV sends the buffers as if they were concatenated into one Write,
+	// without the caller having to. Whole packets are transferred straight
+	// from the buffers, only the pieces straddling their boundaries are copied.
+	WriteV(bufs net.Buffers) (int, error)
+
 	// Read retrieves a binary blob from a USB device. Uses interrupt or bulk transfers.
+	// Failed transfers return a *TransferError along with the bytes read, so
+	// a read timing out midway returns the partial data with an error matching
+	// ErrTimeout.
 	Read(b []byte) (int, error)
+
+	// SetTimeouts sets the timeouts of Read and Write. Zero waits forever, a
+	// negative timeout polls, failing with ErrTimeout unless the transfer
+	// completes right away.
+	SetTimeouts(read, write time.Duration)
+
+	// ReadWithTimeout reads like Read, with a timeout for this call only.
+	ReadWithTimeout(b []byte, timeout time.Duration) (int, error)
+
+	// WriteWithTimeout writes like Write, with a timeout for this call only.
+	WriteWithTimeout(b []byte, timeout time.Duration) (int, error)
+
+	// TryRead collects the data of a read queued by an earlier call without
+	// blocking. If no read is in flight, one of len(b) bytes is queued. Either
+	// way ErrWouldBlock is returned until the read completes.
+	TryRead(b []byte) (int, error)
+
+	// TryWrite queues a write of b without blocking, failing with ErrWouldBlock
+	// while the previous one is in flight. If the previous write failed, its
+	// error is returned instead, without queuing b.
+	TryWrite(b []byte) (int, error)
+
+	// Control sends a control request to the device, with data being sent to
+	// or filled from the device depending on the direction bit of rType.
+	Control(rType, request uint8, val, idx uint16, data []byte) (int, error)
+
+	// AllocBulkStreams allocates USB 3.0 bulk streams on the endpoints, returning
+	// the number of streams allocated, which may be fewer than requested. Stream
+	// ids range from 1 to the returned count.
+	AllocBulkStreams(count int, endpoints ...uint8) (int, error)
+
+	// FreeBulkStreams releases the bulk streams allocated on the endpoints.
+	FreeBulkStreams(endpoints ...uint8) error
+
+	// OpenBulkStream returns a reader and writer transferring through a bulk
+	// stream allocated on the endpoint.
+	OpenBulkStream(endpoint uint8, streamID uint32) (*BulkStream, error)
+
+	// SetAltSetting activates an alternate setting of a claimed interface.
+	SetAltSetting(iface int, alt int) error
+
+	// ClearHalt clears the halt/stall condition of an endpoint.
+	ClearHalt(endpoint uint8) error
+
+	// Reset performs a USB port reset of the device, then reclaims the interfaces
+	// and restores the alternate settings active before, failing with a
+	// *RestoreError if that's not possible. Devices re-enumerated by the reset
+	// are gone and must be opened again.
+	Reset() error
+
+	// SetConfiguration activates a configuration of the device, then reclaims
+	// the interfaces and restores the alternate settings active before, failing
+	// with a *RestoreError if the configuration lacks them.
+	SetConfiguration(config int) error
+
+	// Configuration returns the value of the active configuration, failing with
+	// ErrNotConfigured if the device has none activated.
+	Configuration() (int, error)
+
+	// Status retrieves the power and remote wakeup status of the device.
+	Status() (DeviceStatus, error)
+
+	// InterfaceStatus retrieves the function remote wake status of an interface.
+	InterfaceStatus(iface int) (InterfaceStatus, error)
+
+	// EndpointStatus retrieves the halt status of an endpoint.
+	EndpointStatus(endpoint uint8) (EndpointStatus, error)
+
+	// SetRemoteWakeup arms or disarms the device to wake the host from suspend,
+	// which needs the remote wakeup attribute in the active configuration.
+	SetRemoteWakeup(enable bool) error
+
+	// SetAutoSuspend lets the Linux kernel suspend the device after being idle
+	// for the delay, or keeps it powered. It's unsupported on other platforms.
+	SetAutoSuspend(enable bool, delay time.Duration) error
+
+	// SetControlTimeout sets the timeout of control requests, zero for none.
+	// It defaults to 5 seconds, the time the USB 2.0 spec gives devices to
+	// complete requests with a data stage.
+	SetControlTimeout(timeout time.Duration)
+
+	// SetRetryPolicy configures retries of Read and Write transfers failing
+	// with transient errors. A nil policy disables retries.
+	SetRetryPolicy(policy *RetryPolicy)
+
+	// SetMetrics reports the Read and Write transfers of the device, along with
+	// their retries, to m. A nil m disables the reporting.
+	SetMetrics(m Metrics)
+
+	// AcquireBuffer returns a transfer buffer of the given size, which Read and
+	// Write can transfer through without copying where the backend supports it.
+	AcquireBuffer(size int) (*Buffer, error)
+
+	// AttachKernelDriver gives the interface back to its kernel driver.
+	AttachKernelDriver() error
+
+	// SetReattachOnClose configures whether Close reattaches the kernel driver
+	// which was detached when the device was opened. Enabled by default.
+	SetReattachOnClose(reattach bool)
+
+	// ClaimInterface claims an additional interface of a composite device.
+	ClaimInterface(iface int) error
+
+	// ReleaseInterface releases an interface claimed through ClaimInterface.
+	ReleaseInterface(iface int) error
+
+	// BOS retrieves and decodes the Binary Object Store descriptor.
+	BOS() (*BOSDescriptor, error)
+
+	// RawDescriptors retrieves the raw device and configuration descriptors.
+	RawDescriptors() (*RawDescriptors, error)
+
+	// Done returns a channel closed once the device is closed or disconnected,
+	// so goroutines serving several devices can select on their loss.
+	Done() <-chan struct{}
+
+	// Err returns nil until Done is closed. Afterwards it returns ErrDeviceClosed
+	// if the device was closed, or an error matching ErrNoDevice if it was
+	// disconnected, ErrDeviceGone for the backends of the package.
+	Err() error
+
+	// OnDisconnect registers fn to be called once the device is disconnected,
+	// so sessions can be torn down without waiting for a transfer to fail. The
+	// callbacks run in registration order on a goroutine of their own. They're
+	// not called if the device is closed first, and right away if it's gone
+	// already. The backends of the package release the device beforehand, so
+	// calls fail with ErrDeviceGone.
+	OnDisconnect(fn func())
+}
+
+// lifetime tracks whether a device is still usable, implementing Done and Err
+// for the backends embedding it.
+type lifetime struct {
+	setup  sync.Once
+	signal chan struct{} // Closed when the lifetime ends
+	cause  error         // Reason the lifetime ended, nil while usable
+	lost   []func()      // Callbacks to run on disconnection
+	mu     sync.Mutex
+}
+
+// Done returns a channel closed once the device is closed or disconnected.
+func (l *lifetime) Done() <-chan struct{} {
+	l.setup.Do(func() { l.signal = make(chan struct{}) })
+	return l.signal
+}
+
+// Err returns the reason the device became unusable, nil until then.
+func (l *lifetime) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.cause
+}
+
+// end terminates the lifetime for the given reason, unless already ended.
+func (l *lifetime) end(err error) {
+	l.Done()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.cause == nil {
+		l.cause = err
+		close(l.signal)
+
+		if errors.Is(err, ErrNoDevice) && len(l.lost) > 0 {
+			go runAll(l.lost)
+		}
+		l.lost = nil
+	}
+}
+
+// OnDisconnect registers fn to be called once the device is disconnected.
+func (l *lifetime) OnDisconnect(fn func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case l.cause == nil:
+		l.lost = append(l.lost, fn)
+	case errors.Is(l.cause, ErrNoDevice):
+		go fn()
+	}
+}
+
+// runAll calls the functions in order.
+func runAll(fns []func()) {
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// check ends the lifetime if err reports the device gone. Once it's gone, any
+// failure is reported as ErrDeviceGone instead of the error of the backend,
+// which may be anything while the device is being removed.
+func (l *lifetime) check(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, ErrNoDevice) {
+		l.end(ErrDeviceGone)
+	}
+	if l.Err() != ErrDeviceGone {
+		return err
+	}
+	var terr *TransferError
+	if errors.As(err, &terr) {
+		return newTransferError(terr.Endpoint, terr.Transferred, ErrDeviceGone)
+	}
+	return ErrDeviceGone
+}
+
+// closedErr returns the error of calls on a released device: ErrDeviceGone if
+// it was released after disconnecting, ErrDeviceClosed otherwise.
+func (l *lifetime) closedErr() error {
+	if err := l.Err(); err == ErrDeviceGone {
+		return err
+	}
+	return ErrDeviceClosed
 }
 
 // Find returns a list of all the USB devices attached to the system and
@@ -83,13 +436,203 @@ func Find(vendorID ID, productID ID) ([]DeviceInfo, error) {
 	lock.Lock()
 	defer lock.Unlock()
 
-	return getAllDevices(vendorID, productID)
+	return find(vendorID, productID, false)
 }
 
-// Open connects to a previsouly discovered USB device.
-func (info DeviceInfo) Open() (Device, error) {
+// FindByInterfaceClass returns the USB device interfaces attached to the system
+// with the given class, subclass and protocol, whichever device exposes them,
+// e.g. class 0xff subclass 0x42 protocol 0x01 for ADB. HID interfaces are only
+// returned when looking for the HID class.
+func FindByInterfaceClass(class Class, subClass, protocol uint8) ([]DeviceInfo, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	return enumerate(matchInterfaceClass(class, subClass, protocol), class == ClassHID)
+}
+
+// Enumerate returns all the USB device interfaces attached to the system which
+// are accepted by the match function. Unlike filtering the results of Find,
+// the predicate runs during enumeration, so devices are never retained for
+// interfaces the caller isn't interested in.
+//
+// The predicate runs with the package lock held, so it must not call into the
+// package (e.g. Find or Open), which would deadlock.
+func Enumerate(match func(DeviceInfo) bool) ([]DeviceInfo, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	return enumerate(match, false)
+}
+
+// EnumerateHID is like Enumerate, but also returns HID class devices and
+// interfaces, which are skipped by default as they're usually driven through
+// the OS HID libraries. Opening them detaches the kernel HID driver. Like with
+// Enumerate, the predicate must not call into the package.
+func EnumerateHID(match func(DeviceInfo) bool) ([]DeviceInfo, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	return enumerate(match, true)
+}
+
+// ListDevices returns the USB devices attached to the system which are accepted
+// by the match function, one per device instead of one per interface. Only the
+// device descriptors are read, leaving the interface and endpoint fields unset
+// until resolved by Interfaces or Open, which makes listing a lot faster than
+// Enumerate on busy buses. Like with Enumerate, the predicate must not call
+// into the package.
+func ListDevices(match func(DeviceInfo) bool) ([]DeviceInfo, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	return list(match, false)
+}
+
+// ListDevicesHID is like ListDevices, but also returns HID class devices, whose
+// HID interfaces are then resolved by Interfaces too, the way EnumerateHID
+// returns them.
+func ListDevicesHID(match func(DeviceInfo) bool) ([]DeviceInfo, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	infos, err := list(match, true)
+	for i := range infos {
+		infos[i].hid = true
+	}
+	return infos, err
+}
+
+// Interfaces resolves the interfaces of a device returned by ListDevices or
+// ListDevicesHID, the same way Enumerate or EnumerateHID would have returned
+// them. Infos of interfaces resolve to themselves.
+func (info DeviceInfo) Interfaces() ([]DeviceInfo, error) {
+	if !info.unresolved {
+		return []DeviceInfo{info}, nil
+	}
+	lock.Lock()
+	defer lock.Unlock()
+
+	return interfaces(info, info.hid)
+}
+
+// listByInterface is the device lister of backends discovering interfaces
+// directly, collapsing the enumerated interfaces of each device into one info.
+func listByInterface(match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	ifaces, err := enumerate(func(DeviceInfo) bool { return true }, hid)
+	if err != nil {
+		return nil, err
+	}
+	var seen, infos []DeviceInfo
+	for _, iface := range ifaces {
+		known := false
+		for _, info := range seen {
+			if sameDevice(info, iface) {
+				known = true
+				break
+			}
+		}
+		if known {
+			continue
+		}
+		info := DeviceInfo{
+			Path:         devicePath(iface.Path),
+			SysPath:      iface.SysPath,
+			VendorID:     iface.VendorID,
+			ProductID:    iface.ProductID,
+			Release:      iface.Release,
+			USBVersion:   iface.USBVersion,
+			Serial:       iface.Serial,
+			Manufacturer: iface.Manufacturer,
+			Product:      iface.Product,
+			Class:        iface.Class,
+			SubClass:     iface.SubClass,
+			Protocol:     iface.Protocol,
+			Bus:          iface.Bus,
+			Port:         iface.Port,
+			unresolved:   true,
+			backend:      iface.backend,
+			libusbPort:   iface.libusbPort,
+
+			ManufacturerIndex: iface.ManufacturerIndex,
+			ProductIndex:      iface.ProductIndex,
+			SerialIndex:       iface.SerialIndex,
+		}
+		seen = append(seen, info)
+		if match(info) {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+// interfacesOf enumerates the interfaces belonging to the device of an info.
+func interfacesOf(info DeviceInfo, hid bool) ([]DeviceInfo, error) {
+	return enumerate(func(iface DeviceInfo) bool { return sameDevice(info, iface) }, hid)
+}
+
+// sameDevice reports whether two infos were enumerated from the same device.
+// Devices are told apart by their whole port chain, as identical devices may
+// sit on the same port number of different hubs.
+func sameDevice(a, b DeviceInfo) bool {
+	if a.backend != b.backend || a.VendorID != b.VendorID || a.ProductID != b.ProductID || a.Bus != b.Bus {
+		return false
+	}
+	if a.backend == "" && (a.libusbPort == nil || b.libusbPort == nil) {
+		return false
+	}
+	return devicePath(a.Path) == devicePath(b.Path)
+}
+
+// matchIDs creates an enumeration predicate filtering on the vendor and product
+// id, where a zero id matches anything.
+func matchIDs(vendorID ID, productID ID) func(DeviceInfo) bool {
+	return func(info DeviceInfo) bool {
+		return (vendorID == 0 || ID(info.VendorID) == vendorID) && (productID == 0 || ID(info.ProductID) == productID)
+	}
+}
+
+// matchInterfaceClass creates an enumeration predicate filtering on the class,
+// subclass and protocol of the interface.
+func matchInterfaceClass(class Class, subClass, protocol uint8) func(DeviceInfo) bool {
+	return func(info DeviceInfo) bool {
+		return Class(info.InterfaceClass) == class && info.InterfaceSubClass == subClass && info.InterfaceProtocol == protocol
+	}
+}
+
+// OpenFromFD connects to a device through an already opened file descriptor of
+// its usbfs node, claiming the first interface with endpoints in both
+// directions. This is the only way to reach devices on Android, where apps
+// get the descriptor from UsbManager and cannot enumerate devices themselves.
+// The descriptor remains owned by the caller and is not closed by Close.
+//
+// Only supported on Linux and Android.
+func OpenFromFD(fd int) (Device, error) {
 	lock.Lock()
 	defer lock.Unlock()
 
-	return open(info)
+	if err := builtinOnly("open device"); err != nil {
+		return nil, err
+	}
+	return openFD(fd)
+}
+
+// Exit releases the global resources of the backend, closing the devices still
+// open through it and cancelling the hotplug subscriptions. The libusb backends
+// must be set up by Init again before further use. Backends without global
+// state (IOKit, WinUSB and WebUSB) have nothing to release.
+func Exit() error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if backendName != "" {
+		return closeBackend()
+	}
+	return exit()
+}
+
+// Open connects to a previously discovered USB device with the default options.
+// Devices returned by ListDevices are opened on their first interface with
+// endpoints in both directions.
+func (info DeviceInfo) Open() (Device, error) {
+	return Open(info)
 }
@@ -1,18 +1,33 @@
 package zerousb
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"runtime"
 	"sync"
 	"testing"
+	"time"
 )
 
+// Ensure the wrappers implement the generic device interface.
+var _ Device = (*ReconnectingDevice)(nil)
+
 // Tests that generic enumeration can be called concurrently from multiple threads.
 func TestThreadedFind(t *testing.T) {
 	// Travis does not have usbfs enabled in the Linux kernel
 	if os.Getenv("TRAVIS") != "" && runtime.GOOS == "linux" {
 		t.Skip("Linux on Travis doesn't have usbfs, skipping test")
 	}
+	// Platforms without a backend build, but can't enumerate anything, neither
+	// can builds loading libusb at runtime without it installed
+	if err := Init(); err == ErrUnsupportedPlatform {
+		t.Skip("Platform unsupported, skipping test")
+	} else if errors.Is(err, ErrNotSupported) {
+		t.Skipf("Backend unavailable, skipping test: %v", err)
+	} else if err != nil {
+		t.Fatalf("failed to initialize: %v", err)
+	}
 	var pend sync.WaitGroup
 	for i := 0; i < 8; i++ {
 		pend.Add(1)
@@ -28,3 +43,160 @@ func TestThreadedFind(t *testing.T) {
 	}
 	pend.Wait()
 }
+
+// Tests that the vendor and product id predicate treats zero as a wildcard.
+func TestMatchIDs(t *testing.T) {
+	info := DeviceInfo{VendorID: 0x0483, ProductID: 0xa27e}
+
+	tests := []struct {
+		vendor, product ID
+		match           bool
+	}{
+		{0, 0, true},
+		{0x0483, 0, true},
+		{0, 0xa27e, true},
+		{0x0483, 0xa27e, true},
+		{0x0484, 0, false},
+		{0x0483, 0xa27f, false},
+	}
+	for i, tt := range tests {
+		if have := matchIDs(tt.vendor, tt.product)(info); have != tt.match {
+			t.Errorf("test %d: match mismatch: have %v, want %v", i, have, tt.match)
+		}
+	}
+}
+
+// Tests that the interface class predicate matches the whole triple of the
+// interface, regardless of the device class.
+func TestMatchInterfaceClass(t *testing.T) {
+	adb := DeviceInfo{Class: uint8(ClassMiscellaneous), InterfaceClass: uint8(ClassVendorSpec), InterfaceSubClass: 0x42, InterfaceProtocol: 0x01}
+
+	tests := []struct {
+		class    Class
+		sub      uint8
+		protocol uint8
+		match    bool
+	}{
+		{ClassVendorSpec, 0x42, 0x01, true},
+		{ClassVendorSpec, 0x42, 0x03, false}, // Fastboot
+		{ClassVendorSpec, 0x43, 0x01, false},
+		{ClassMiscellaneous, 0x42, 0x01, false},
+	}
+	for i, tt := range tests {
+		if have := matchInterfaceClass(tt.class, tt.sub, tt.protocol)(adb); have != tt.match {
+			t.Errorf("test %d: match mismatch: have %v, want %v", i, have, tt.match)
+		}
+	}
+}
+
+// Tests that identical devices plugged into the same port number of different
+// hubs aren't taken for each other.
+func TestSameDevice(t *testing.T) {
+	port := uint8(2)
+	first := DeviceInfo{Path: "1-1.2:0", VendorID: 0x0483, ProductID: 0xa27e, Bus: 1, Port: port, libusbPort: &port}
+	second := DeviceInfo{Path: "1-3.2:0", VendorID: 0x0483, ProductID: 0xa27e, Bus: 1, Port: port, libusbPort: &port}
+
+	tests := []struct {
+		a, b DeviceInfo
+		same bool
+	}{
+		{first, first, true},
+		{first, DeviceInfo{Path: "1-1.2", VendorID: 0x0483, ProductID: 0xa27e, Bus: 1, Port: port, libusbPort: &port}, true},
+		{first, second, false},
+		{first, DeviceInfo{Path: "1-1.2:0", VendorID: 0x0483, ProductID: 0xa27e, Bus: 1, Port: port}, false},
+		{DeviceInfo{Path: "1-1.2:0", Bus: 1, backend: "fake"}, DeviceInfo{Path: "1-1.2:1", Bus: 1, backend: "fake"}, true},
+		{DeviceInfo{Path: "1-1.2:0", Bus: 1, backend: "fake"}, DeviceInfo{Path: "1-3.2:0", Bus: 1, backend: "fake"}, false},
+	}
+	for i, tt := range tests {
+		if have := sameDevice(tt.a, tt.b); have != tt.same {
+			t.Errorf("test %d: match mismatch: have %v, want %v", i, have, tt.same)
+		}
+	}
+}
+
+// Tests that a device lifetime ends on the first disconnection or close, and
+// ignores errors unrelated to the device being gone.
+func TestLifetime(t *testing.T) {
+	var l lifetime
+
+	l.check(ErrTimeout)
+	select {
+	case <-l.Done():
+		t.Fatalf("lifetime ended on timeout")
+	default:
+	}
+	if err := l.Err(); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	l.check(newTransferError(0x81, 0, ErrNoDevice))
+	l.end(ErrDeviceClosed)
+
+	select {
+	case <-l.Done():
+	default:
+		t.Fatalf("lifetime not ended on disconnect")
+	}
+	if err := l.Err(); !errors.Is(err, ErrNoDevice) {
+		t.Fatalf("error mismatch: have %v, want %v", err, ErrNoDevice)
+	}
+}
+
+// Tests that failures of devices gone are reported as ErrDeviceGone, whichever
+// error the backend returned, keeping the transferred counts.
+func TestDeviceGone(t *testing.T) {
+	var l lifetime
+
+	if err := l.check(ErrIO); err != ErrIO {
+		t.Errorf("error of present device mismatch: have %v, want %v", err, ErrIO)
+	}
+	if err := l.closedErr(); err != ErrDeviceClosed {
+		t.Errorf("closed error mismatch: have %v, want %v", err, ErrDeviceClosed)
+	}
+	l.check(fmt.Errorf("failed to send control request: %w", ErrNoDevice))
+
+	if err := l.Err(); err != ErrDeviceGone || !errors.Is(err, ErrNoDevice) {
+		t.Errorf("lifetime error mismatch: have %v, want %v", err, ErrDeviceGone)
+	}
+	if err := l.check(ErrIO); err != ErrDeviceGone {
+		t.Errorf("error of gone device mismatch: have %v, want %v", err, ErrDeviceGone)
+	}
+	var terr *TransferError
+	if err := l.check(newTransferError(0x81, 12, ErrPipe)); !errors.As(err, &terr) || terr.Err != ErrDeviceGone || terr.Transferred != 12 {
+		t.Errorf("transfer error of gone device mismatch: have %v", err)
+	}
+	if err := l.closedErr(); err != ErrDeviceGone {
+		t.Errorf("released error mismatch: have %v, want %v", err, ErrDeviceGone)
+	}
+}
+
+// Tests that disconnect callbacks run in order once the device is gone, right
+// away if registered afterwards, and never if it was closed instead.
+func TestOnDisconnect(t *testing.T) {
+	calls := make(chan int, 3)
+
+	var l lifetime
+	l.OnDisconnect(func() { calls <- 1 })
+	l.OnDisconnect(func() { calls <- 2 })
+	l.check(newTransferError(0x81, 0, ErrNoDevice))
+
+	for _, want := range []int{1, 2} {
+		if have := <-calls; have != want {
+			t.Errorf("callback order mismatch: have %d, want %d", have, want)
+		}
+	}
+	l.OnDisconnect(func() { calls <- 3 })
+	if have := <-calls; have != 3 {
+		t.Errorf("late callback mismatch: have %d, want 3", have)
+	}
+
+	var closed lifetime
+	closed.OnDisconnect(func() { calls <- 4 })
+	closed.end(ErrDeviceClosed)
+	closed.OnDisconnect(func() { calls <- 5 })
+
+	select {
+	case have := <-calls:
+		t.Errorf("callback %d called on close", have)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,392 @@
+// Package dfu implements the host side of the USB Device Firmware Upgrade 1.1
+// protocol on top of zerousb control transfers, allowing firmware flashing
+// tools to be written without shelling out to dfu-util.
+package dfu
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chay22/zerousb"
+)
+
+// Class specific requests defined by the DFU 1.1 spec, section 3.
+const (
+	requestDetach    = 0x00
+	requestDnload    = 0x01
+	requestUpload    = 0x02
+	requestGetStatus = 0x03
+	requestClrStatus = 0x04
+	requestGetState  = 0x05
+	requestAbort     = 0x06
+)
+
+// DescriptorTypeFunctional is the type of the DFU functional descriptor.
+const DescriptorTypeFunctional = 0x21
+
+// State is the state of the DFU state machine on the device.
+type State uint8
+
+// Device states defined by the DFU 1.1 spec, section 6.1.2.
+const (
+	StateAppIdle           State = 0
+	StateAppDetach         State = 1
+	StateIdle              State = 2
+	StateDnloadSync        State = 3
+	StateDnloadBusy        State = 4
+	StateDnloadIdle        State = 5
+	StateManifestSync      State = 6
+	StateManifest          State = 7
+	StateManifestWaitReset State = 8
+	StateUploadIdle        State = 9
+	StateError             State = 10
+	stateCount                   = 11
+)
+
+var stateDescription = [stateCount]string{
+	"appIDLE", "appDETACH", "dfuIDLE", "dfuDNLOAD-SYNC", "dfuDNBUSY", "dfuDNLOAD-IDLE",
+	"dfuMANIFEST-SYNC", "dfuMANIFEST", "dfuMANIFEST-WAIT-RESET", "dfuUPLOAD-IDLE", "dfuERROR",
+}
+
+// String returns the spec name of the state.
+func (s State) String() string {
+	if s < stateCount {
+		return stateDescription[s]
+	}
+	return fmt.Sprintf("state %d", uint8(s))
+}
+
+// Status is the result of the most recent request, as reported by the device.
+type Status uint8
+
+// Status codes defined by the DFU 1.1 spec, section 6.1.2.
+const (
+	StatusOK             Status = 0x00
+	StatusErrTarget      Status = 0x01
+	StatusErrFile        Status = 0x02
+	StatusErrWrite       Status = 0x03
+	StatusErrErase       Status = 0x04
+	StatusErrCheckErased Status = 0x05
+	StatusErrProg        Status = 0x06
+	StatusErrVerify      Status = 0x07
+	StatusErrAddress     Status = 0x08
+	StatusErrNotDone     Status = 0x09
+	StatusErrFirmware    Status = 0x0a
+	StatusErrVendor      Status = 0x0b
+	StatusErrUSBR        Status = 0x0c
+	StatusErrPOR         Status = 0x0d
+	StatusErrUnknown     Status = 0x0e
+	StatusErrStalledPkt  Status = 0x0f
+)
+
+var statusDescription = map[Status]string{
+	StatusOK:             "no error",
+	StatusErrTarget:      "file is not targeted for this device",
+	StatusErrFile:        "file fails a vendor-specific verification",
+	StatusErrWrite:       "unable to write memory",
+	StatusErrErase:       "memory erase failed",
+	StatusErrCheckErased: "memory erase check failed",
+	StatusErrProg:        "program memory failed",
+	StatusErrVerify:      "programmed memory failed verification",
+	StatusErrAddress:     "address out of range",
+	StatusErrNotDone:     "unexpected end of data",
+	StatusErrFirmware:    "firmware is corrupt",
+	StatusErrVendor:      "vendor-specific error",
+	StatusErrUSBR:        "unexpected USB reset",
+	StatusErrPOR:         "unexpected power on reset",
+	StatusErrUnknown:     "unknown error",
+	StatusErrStalledPkt:  "unexpected request stalled",
+}
+
+// String returns a human-readable description of the status.
+func (s Status) String() string {
+	if d, ok := statusDescription[s]; ok {
+		return d
+	}
+	return fmt.Sprintf("status 0x%02x", uint8(s))
+}
+
+// DeviceStatus is the response to a DFU_GETSTATUS request.
+type DeviceStatus struct {
+	Status      Status        // Result of the most recent request
+	PollTimeout time.Duration // Minimum time to wait before the next GETSTATUS
+	State       State         // State the device transitions into
+	String      uint8         // Index of a vendor status string descriptor
+}
+
+// StatusError is returned when the device reports a failure status.
+type StatusError struct {
+	Status Status
+	State  State
+}
+
+// Error implements the error interface.
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("dfu: %s (state %s)", e.Status, e.State)
+}
+
+// ErrUnexpectedState is returned if the device ends up in a state which the
+// requested operation can't proceed from.
+var ErrUnexpectedState = errors.New("dfu: unexpected device state")
+
+// Controller is the subset of zerousb.Device needed to speak DFU.
+type Controller interface {
+	Control(rType, request uint8, val, idx uint16, data []byte) (int, error)
+}
+
+// DFU is a handle to the DFU interface of a device.
+type DFU struct {
+	dev          Controller
+	iface        uint16
+	transferSize int
+}
+
+// New creates a DFU handle for the given interface of an opened device. The
+// transfer size is the wTransferSize of the functional descriptor.
+func New(dev Controller, iface int, transferSize int) *DFU {
+	return &DFU{
+		dev:          dev,
+		iface:        uint16(iface),
+		transferSize: transferSize,
+	}
+}
+
+// out sends a class request to the DFU interface.
+func (d *DFU) out(request uint8, val uint16, data []byte) error {
+	_, err := d.dev.Control(zerousb.ControlOut|zerousb.ControlClass|zerousb.ControlInterface, request, val, d.iface, data)
+	return err
+}
+
+// in requests data from the DFU interface.
+func (d *DFU) in(request uint8, val uint16, data []byte) (int, error) {
+	return d.dev.Control(zerousb.ControlIn|zerousb.ControlClass|zerousb.ControlInterface, request, val, d.iface, data)
+}
+
+// Detach requests the device running its application firmware to switch into
+// DFU mode, within the given timeout. Devices not advertising the will-detach
+// attribute need to be reset by the host afterwards.
+func (d *DFU) Detach(timeout time.Duration) error {
+	return d.out(requestDetach, uint16(timeout/time.Millisecond), nil)
+}
+
+// Download sends a single firmware block to the device. An empty block marks
+// the end of the firmware and starts the manifestation phase.
+func (d *DFU) Download(block uint16, data []byte) error {
+	return d.out(requestDnload, block, data)
+}
+
+// Upload reads a single firmware block from the device into buf, returning
+// the number of bytes read. A short read marks the end of the firmware.
+func (d *DFU) Upload(block uint16, buf []byte) (int, error) {
+	return d.in(requestUpload, block, buf)
+}
+
+// GetStatus retrieves the status of the device.
+func (d *DFU) GetStatus() (*DeviceStatus, error) {
+	buf := make([]byte, 6)
+	n, err := d.in(requestGetStatus, 0, buf)
+	if err != nil {
+		return nil, err
+	}
+	if n < len(buf) {
+		return nil, fmt.Errorf("dfu: short status response: %d bytes", n)
+	}
+	// The poll timeout is a 24 bit little endian value
+	poll := uint32(buf[1]) | uint32(buf[2])<<8 | uint32(buf[3])<<16
+	return &DeviceStatus{
+		Status:      Status(buf[0]),
+		PollTimeout: time.Duration(poll) * time.Millisecond,
+		State:       State(buf[4]),
+		String:      buf[5],
+	}, nil
+}
+
+// ClearStatus clears an error status, moving the device back to dfuIDLE.
+func (d *DFU) ClearStatus() error {
+	return d.out(requestClrStatus, 0, nil)
+}
+
+// GetState retrieves the state of the device without altering it.
+func (d *DFU) GetState() (State, error) {
+	buf := make([]byte, 1)
+	n, err := d.in(requestGetState, 0, buf)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 {
+		return 0, errors.New("dfu: empty state response")
+	}
+	return State(buf[0]), nil
+}
+
+// Abort terminates an ongoing download or upload, moving to dfuIDLE.
+func (d *DFU) Abort() error {
+	return d.out(requestAbort, 0, nil)
+}
+
+// waitStatus polls the device status, honouring the requested poll timeouts,
+// for as long as it reports the busy state.
+func (d *DFU) waitStatus(busy State) (*DeviceStatus, error) {
+	for {
+		status, err := d.GetStatus()
+		if err != nil {
+			return nil, err
+		}
+		if status.Status != StatusOK {
+			return nil, &StatusError{Status: status.Status, State: status.State}
+		}
+		if status.State != busy {
+			return status, nil
+		}
+		time.Sleep(status.PollTimeout)
+	}
+}
+
+// ensureIdle brings the device into the dfuIDLE state, clearing any previous
+// error and aborting any unfinished transfer.
+func (d *DFU) ensureIdle() error {
+	status, err := d.GetStatus()
+	if err != nil {
+		return err
+	}
+	switch status.State {
+	case StateIdle:
+		return nil
+	case StateError:
+		if err := d.ClearStatus(); err != nil {
+			return err
+		}
+	case StateDnloadIdle, StateUploadIdle:
+		if err := d.Abort(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnexpectedState, status.State)
+	}
+	if state, err := d.GetState(); err != nil {
+		return err
+	} else if state != StateIdle {
+		return fmt.Errorf("%w: %s", ErrUnexpectedState, state)
+	}
+	return nil
+}
+
+// Flash downloads a complete firmware image into the device, block by block,
+// and waits for the manifestation phase to complete. Devices which aren't
+// manifestation tolerant end up in dfuMANIFEST-WAIT-RESET and need a reset.
+func (d *DFU) Flash(firmware []byte) error {
+	if d.transferSize <= 0 {
+		return errors.New("dfu: invalid transfer size")
+	}
+	if err := d.ensureIdle(); err != nil {
+		return err
+	}
+	var block uint16
+	for len(firmware) > 0 {
+		chunk := firmware
+		if len(chunk) > d.transferSize {
+			chunk = chunk[:d.transferSize]
+		}
+		if err := d.Download(block, chunk); err != nil {
+			return fmt.Errorf("dfu: block %d: %w", block, err)
+		}
+		status, err := d.waitStatus(StateDnloadBusy)
+		if err != nil {
+			return fmt.Errorf("dfu: block %d: %w", block, err)
+		}
+		if status.State != StateDnloadIdle {
+			return fmt.Errorf("%w: block %d: %s", ErrUnexpectedState, block, status.State)
+		}
+		firmware = firmware[len(chunk):]
+		block++
+	}
+	// Signal the end of the firmware and wait for the device to manifest it
+	if err := d.Download(block, nil); err != nil {
+		return fmt.Errorf("dfu: manifestation: %w", err)
+	}
+	for {
+		status, err := d.waitStatus(StateManifest)
+		if err != nil {
+			// Devices resetting right after manifestation may stop responding
+			return fmt.Errorf("dfu: manifestation: %w", err)
+		}
+		switch status.State {
+		case StateManifestSync:
+			continue
+		case StateIdle, StateManifestWaitReset:
+			return nil
+		default:
+			return fmt.Errorf("%w: manifestation: %s", ErrUnexpectedState, status.State)
+		}
+	}
+}
+
+// ReadFirmware uploads the complete firmware image from the device.
+func (d *DFU) ReadFirmware() ([]byte, error) {
+	if d.transferSize <= 0 {
+		return nil, errors.New("dfu: invalid transfer size")
+	}
+	if err := d.ensureIdle(); err != nil {
+		return nil, err
+	}
+	var (
+		firmware []byte
+		buf      = make([]byte, d.transferSize)
+	)
+	for block := uint16(0); ; block++ {
+		n, err := d.Upload(block, buf)
+		if err != nil {
+			return nil, fmt.Errorf("dfu: block %d: %w", block, err)
+		}
+		firmware = append(firmware, buf[:n]...)
+		if n < len(buf) {
+			return firmware, nil
+		}
+	}
+}
+
+// FunctionalDescriptor is the DFU functional descriptor advertised alongside
+// the DFU interface.
+type FunctionalDescriptor struct {
+	CanDownload           bool          // bitCanDnload
+	CanUpload             bool          // bitCanUpload
+	ManifestationTolerant bool          // bitManifestationTolerant
+	WillDetach            bool          // bitWillDetach
+	DetachTimeout         time.Duration // wDetachTimeOut
+	TransferSize          int           // wTransferSize
+	Version               uint16        // bcdDFUVersion, BCD encoded
+}
+
+// ParseFunctionalDescriptor finds and decodes the DFU functional descriptor in
+// a raw configuration descriptor, as returned by Device.RawDescriptors.
+func ParseFunctionalDescriptor(config []byte) (*FunctionalDescriptor, error) {
+	for len(config) >= 2 {
+		length := int(config[0])
+		if length < 2 || length > len(config) {
+			break
+		}
+		desc := config[:length]
+		config = config[length:]
+
+		if desc[1] != DescriptorTypeFunctional || length < 7 {
+			continue
+		}
+		fd := &FunctionalDescriptor{
+			CanDownload:           desc[2]&0x01 != 0,
+			CanUpload:             desc[2]&0x02 != 0,
+			ManifestationTolerant: desc[2]&0x04 != 0,
+			WillDetach:            desc[2]&0x08 != 0,
+			DetachTimeout:         time.Duration(binary.LittleEndian.Uint16(desc[3:])) * time.Millisecond,
+			TransferSize:          int(binary.LittleEndian.Uint16(desc[5:])),
+			Version:               0x0100,
+		}
+		// DFU 1.0 descriptors lack the version field
+		if length >= 9 {
+			fd.Version = binary.LittleEndian.Uint16(desc[7:])
+		}
+		return fd, nil
+	}
+	return nil, errors.New("dfu: functional descriptor not found")
+}
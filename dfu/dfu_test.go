@@ -0,0 +1,86 @@
+package dfu
+
+import (
+	"bytes"
+	"testing"
+)
+
+// fakeDevice simulates the DFU state machine of a manifestation tolerant
+// device, collecting the downloaded firmware.
+type fakeDevice struct {
+	state    State
+	firmware []byte
+	blocks   []uint16
+}
+
+func (f *fakeDevice) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	switch request {
+	case requestDnload:
+		if len(data) == 0 {
+			f.state = StateManifestSync
+			return 0, nil
+		}
+		f.blocks = append(f.blocks, val)
+		f.firmware = append(f.firmware, data...)
+		f.state = StateDnloadSync
+		return len(data), nil
+
+	case requestGetStatus:
+		switch f.state {
+		case StateDnloadSync:
+			f.state = StateDnloadBusy
+		case StateDnloadBusy:
+			f.state = StateDnloadIdle
+		case StateManifestSync:
+			f.state = StateManifest
+		case StateManifest:
+			f.state = StateIdle
+		}
+		copy(data, []byte{byte(StatusOK), 0, 0, 0, byte(f.state), 0})
+		return 6, nil
+
+	case requestGetState:
+		data[0] = byte(f.state)
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Tests that firmware is split into blocks and the device driven through the
+// download and manifestation phases.
+func TestFlash(t *testing.T) {
+	dev := &fakeDevice{state: StateIdle}
+	firmware := bytes.Repeat([]byte{0xaa, 0x55}, 5)
+
+	if err := New(dev, 0, 4).Flash(firmware); err != nil {
+		t.Fatalf("failed to flash: %v", err)
+	}
+	if !bytes.Equal(dev.firmware, firmware) {
+		t.Errorf("firmware mismatch: have %x, want %x", dev.firmware, firmware)
+	}
+	if len(dev.blocks) != 3 || dev.blocks[2] != 2 {
+		t.Errorf("block numbering mismatch: have %v", dev.blocks)
+	}
+	if dev.state != StateIdle {
+		t.Errorf("final state mismatch: have %s, want %s", dev.state, StateIdle)
+	}
+}
+
+// Tests that the functional descriptor is found within a configuration.
+func TestParseFunctionalDescriptor(t *testing.T) {
+	config := []byte{
+		0x09, 0x02, 0x1b, 0x00, 0x01, 0x01, 0x00, 0x80, 0x32, // configuration
+		0x09, 0x04, 0x00, 0x00, 0x00, 0xfe, 0x01, 0x02, 0x00, // interface
+		0x09, 0x21, 0x0b, 0xff, 0x00, 0x00, 0x08, 0x1a, 0x01, // functional
+	}
+	fd, err := ParseFunctionalDescriptor(config)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if !fd.CanDownload || !fd.CanUpload || fd.ManifestationTolerant || !fd.WillDetach {
+		t.Errorf("attribute mismatch: %+v", fd)
+	}
+	if fd.TransferSize != 2048 || fd.Version != 0x011a {
+		t.Errorf("field mismatch: %+v", fd)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package zerousb
+
+// checkDriver has nothing to check outside of Windows, where drivers are bound
+// to devices on demand or detached by the backends.
+func checkDriver(info DeviceInfo) error {
+	return nil
+}
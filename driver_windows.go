@@ -0,0 +1,100 @@
+//go:build windows
+
+package zerousb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// supportedDrivers are the services of the drivers user space can talk to
+// devices through, HID being reachable via the HID backend of libusb.
+var supportedDrivers = map[string]bool{
+	"winusb":  true,
+	"libusbk": true,
+	"libusb0": true,
+	"hidusb":  true,
+	"usbccgp": true, // Composite parent, the interfaces carry their own drivers
+}
+
+// checkDriver looks up the driver bound to the interface of a device, or to
+// the device itself if it's not composite, failing with a *DriverError if the
+// driver is unsupported. Devices not found are left for the open to report.
+func checkDriver(info DeviceInfo) error {
+	devs, err := windows.SetupDiGetClassDevsEx(nil, "USB", 0, windows.DIGCF_ALLCLASSES|windows.DIGCF_PRESENT, 0, "")
+	if err != nil {
+		return nil
+	}
+	defer devs.Close()
+
+	device := fmt.Sprintf(`USB\VID_%04X&PID_%04X`, info.VendorID, info.ProductID)
+	function := fmt.Sprintf(`%s&MI_%02X`, device, info.Interface)
+
+	var (
+		matched  bool  // Whether the device node was found
+		fallback error // Verdict on the device node, if no interface node is found
+	)
+	for i := 0; ; i++ {
+		data, err := devs.EnumDeviceInfo(i)
+		if err == windows.ERROR_NO_MORE_ITEMS {
+			break
+		}
+		if err != nil {
+			continue
+		}
+		id, err := devs.DeviceInstanceID(data)
+		if err != nil {
+			continue
+		}
+		// Instance ids are the hardware id followed by an instance suffix
+		hwid := id
+		if sep := strings.LastIndexByte(id, '\\'); sep > 0 {
+			hwid = id[:sep]
+		}
+		switch strings.ToUpper(hwid) {
+		case function:
+			// The interface node of a composite device decides on its own
+			return driverError(devs, data, id)
+		case device:
+			if matched || (info.Port != 0 && locationPort(devs, data) != info.Port) {
+				continue
+			}
+			matched, fallback = true, driverError(devs, data, id)
+		}
+	}
+	return fallback
+}
+
+// driverError reads the service bound to a device node, returning a
+// *DriverError if it's unsupported.
+func driverError(devs windows.DevInfo, data *windows.DevInfoData, id string) error {
+	var driver string
+	if service, err := devs.DeviceRegistryProperty(data, windows.SPDRP_SERVICE); err == nil {
+		driver, _ = service.(string)
+	}
+	if supportedDrivers[strings.ToLower(driver)] {
+		return nil
+	}
+	return &DriverError{Driver: driver, InstanceID: id}
+}
+
+// locationPort extracts the hub port number out of the "Port_#0001.Hub_#0002"
+// location information of a device, or zero if unavailable.
+func locationPort(devs windows.DevInfo, data *windows.DevInfoData) uint8 {
+	location, err := devs.DeviceRegistryProperty(data, windows.SPDRP_LOCATION_INFORMATION)
+	if err != nil {
+		return 0
+	}
+	str, _ := location.(string)
+	if !strings.HasPrefix(str, "Port_#") {
+		return 0
+	}
+	port, err := strconv.Atoi(strings.SplitN(strings.TrimPrefix(str, "Port_#"), ".", 2)[0])
+	if err != nil {
+		return 0
+	}
+	return uint8(port)
+}
@@ -1,74 +1,177 @@
-// Copyright 2013 Google Inc.  All rights reserved.
-// Copyright 2016 the gousb Authors.  All rights reserved.
-//
-// Licensed under the Apache License, Version 2.0 (the "License");
-// you may not use this file except in compliance with the License.
-// You may obtain a copy of the License at
-//
-//     http://www.apache.org/licenses/LICENSE-2.0
-//
-// Unless required by applicable law or agreed to in writing, software
-// distributed under the License is distributed on an "AS IS" BASIS,
-// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
-// See the License for the specific language governing permissions and
-// limitations under the License.
-
 package zerousb
 
 import (
+	"errors"
 	"fmt"
+	"os"
+)
+
+// Errors reported by device operations regardless of the backend in use. The
+// native errors of the backends match them via errors.Is, while retaining
+// their platform specific codes and messages.
+var (
+	ErrIO           = errors.New("usb: input/output error")
+	ErrInvalidParam = errors.New("usb: invalid parameter")
+	ErrAccess       = errors.New("usb: access denied")
+	ErrNoDevice     = errors.New("usb: no such device")
+	ErrNotFound     = errors.New("usb: entity not found")
+	ErrBusy         = errors.New("usb: resource busy")
+	ErrTimeout      = errors.New("usb: operation timed out")
+	ErrOverflow     = errors.New("usb: overflow")
+	ErrPipe         = errors.New("usb: pipe error")
+	ErrInterrupted  = errors.New("usb: operation interrupted")
+	ErrNoMem        = errors.New("usb: insufficient memory")
+	ErrNotSupported = errors.New("usb: operation not supported")
 )
 
-// #include "./libusb/libusb/libusb.h"
-import "C"
+// ErrNotConfigured is returned by Configuration for devices in the address
+// state, which have no configuration activated.
+var ErrNotConfigured = errors.New("usb: device not configured")
 
-// libusbError is an Error code from libusb.
-type libusbError C.int
+// ErrWrongDriver is matched by a *DriverError, returned by Open on Windows for
+// devices bound to a driver user space can't talk through.
+var ErrWrongDriver = errors.New("usb: device bound to unsupported driver")
 
-// Error implements the Error interface.
-func (e libusbError) Error() string {
-	return fmt.Sprintf("libusb: %s [code %d]", libusbErrorString[e], e)
+// ErrIntErrupted is the former name of ErrInterrupted.
+//
+// Deprecated: use ErrInterrupted.
+var ErrIntErrupted = ErrInterrupted
+
+// TransferError is returned by Read and Write when a transfer fails. Transfers
+// may move part of the data before failing (e.g. a bulk transfer timing out),
+// in which case the partial count is reported here and returned by the failed
+// call too.
+type TransferError struct {
+	Endpoint    uint8             // Address of the endpoint, direction bit included
+	Direction   EndpointDirection // Direction of the failed transfer
+	Transferred int               // Number of bytes moved before the failure
+	Code        int               // Native error code of the backend, zero if none
+	Err         error             // Native error of the backend
 }
 
-// fromLibusbErrno converts a raw libusb Error into a Go type.
-func fromLibusbErrno(Errno C.int) error {
-	err := libusbError(Errno)
-	if err == ErrSuccess {
-		return nil
+// Error implements the error interface.
+func (e *TransferError) Error() string {
+	op := "write to"
+	if e.Direction == EndpointDirectionIn {
+		op = "read from"
 	}
-	return err
-}
-
-const (
-	ErrSuccess      libusbError = C.LIBUSB_SUCCESS
-	ErrIO           libusbError = C.LIBUSB_ERROR_IO
-	ErrInvalidParam libusbError = C.LIBUSB_ERROR_INVALID_PARAM
-	ErrAccess       libusbError = C.LIBUSB_ERROR_ACCESS
-	ErrNoDevice     libusbError = C.LIBUSB_ERROR_NO_DEVICE
-	ErrNotFound     libusbError = C.LIBUSB_ERROR_NOT_FOUND
-	ErrBusy         libusbError = C.LIBUSB_ERROR_BUSY
-	ErrTimeout      libusbError = C.LIBUSB_ERROR_TIMEOUT
-	ErrOverflow     libusbError = C.LIBUSB_ERROR_OVERFLOW
-	ErrPipe         libusbError = C.LIBUSB_ERROR_PIPE
-	ErrIntErrupted  libusbError = C.LIBUSB_ERROR_INTERRUPTED
-	ErrNoMem        libusbError = C.LIBUSB_ERROR_NO_MEM
-	ErrNotSupported libusbError = C.LIBUSB_ERROR_NOT_SUPPORTED
-	ErrOther        libusbError = C.LIBUSB_ERROR_OTHER
-)
+	return fmt.Sprintf("failed to %s device: endpoint %#02x, %d bytes transferred: %v", op, e.Endpoint, e.Transferred, e.Err)
+}
+
+// Unwrap returns the native error of the backend, which matches the portable
+// sentinel errors via errors.Is.
+func (e *TransferError) Unwrap() error {
+	return e.Err
+}
+
+// RestoreError is returned by Reset and SetConfiguration when an interface claim
+// or alternate setting active before couldn't be restored afterwards. The
+// device remains open, with the interfaces restored up to the failure.
+type RestoreError struct {
+	Interface int   // Number of the interface failing to be restored
+	Alternate int   // Alternate setting failing to be restored, zero if the claim failed
+	Err       error // Native error of the backend
+}
+
+// Error implements the error interface.
+func (e *RestoreError) Error() string {
+	if e.Alternate != 0 {
+		return fmt.Sprintf("failed to restore alternate setting %d of interface %d: %v", e.Alternate, e.Interface, e.Err)
+	}
+	return fmt.Sprintf("failed to reclaim interface %d: %v", e.Interface, e.Err)
+}
 
-var libusbErrorString = map[libusbError]string{
-	ErrSuccess:      "success",
-	ErrIO:           "i/o Error",
-	ErrInvalidParam: "invalid param",
-	ErrAccess:       "bad access",
-	ErrNoDevice:     "no device",
-	ErrNotFound:     "not found",
-	ErrBusy:         "device or resource busy",
-	ErrTimeout:      "timeout",
-	ErrOverflow:     "overflow",
-	ErrPipe:         "pipe Error",
-	ErrIntErrupted:  "intErrupted",
-	ErrNoMem:        "out of memory",
-	ErrNotSupported: "not supported",
-	ErrOther:        "unknown Error",
+// Unwrap returns the native error of the backend.
+func (e *RestoreError) Unwrap() error {
+	return e.Err
+}
+
+// AccessError is returned by Open when the operating system denies access to a
+// device, detailing the device node and how access could be granted.
+type AccessError struct {
+	Node  string      // Device node access was denied to, empty if the platform has none
+	Mode  os.FileMode // Permissions of the node, zero if unknown
+	Owner string      // User and group owning the node, empty if unknown
+	Hint  string      // Platform specific advice on gaining access
+	Err   error       // Native error of the backend
+}
+
+// Error implements the error interface.
+func (e *AccessError) Error() string {
+	msg := "failed to open device: access denied"
+	if e.Node != "" {
+		msg += " to " + e.Node
+		if e.Owner != "" {
+			msg += fmt.Sprintf(" (%v %s)", e.Mode, e.Owner)
+		}
+	}
+	if e.Hint != "" {
+		msg += ", " + e.Hint
+	}
+	return fmt.Sprintf("%s: %v", msg, e.Err)
+}
+
+// Unwrap returns the native error of the backend, which matches ErrAccess.
+func (e *AccessError) Unwrap() error {
+	return e.Err
+}
+
+// newAccessError wraps a denied open into an AccessError, inspecting the
+// permissions of the device node if there's one.
+func newAccessError(node string, hint string, err error) *AccessError {
+	e := &AccessError{Node: node, Hint: hint, Err: err}
+	if node != "" {
+		e.Mode, e.Owner = nodeOwner(node)
+	}
+	return e
+}
+
+// DriverError is returned by Open on Windows when the device, or the interface
+// being opened, is bound to a driver other than WinUSB, libusbK or libusb0.
+// Tools can direct users to Zadig to replace the driver.
+type DriverError struct {
+	Driver     string // Service name of the bound driver, empty if none is installed
+	InstanceID string // Device instance id of the device or interface node
+}
+
+// Error implements the error interface.
+func (e *DriverError) Error() string {
+	if e.Driver == "" {
+		return fmt.Sprintf("failed to open device: no driver installed for %s, install WinUSB (e.g. with Zadig)", e.InstanceID)
+	}
+	return fmt.Sprintf("failed to open device: %s bound to driver %s, replace it with WinUSB (e.g. with Zadig)", e.InstanceID, e.Driver)
+}
+
+// Unwrap returns ErrWrongDriver.
+func (e *DriverError) Unwrap() error {
+	return ErrWrongDriver
+}
+
+// newTransferError wraps a failed transfer on an endpoint into a TransferError,
+// deriving the direction from the endpoint address.
+func newTransferError(endpoint uint8, transferred int, err error) *TransferError {
+	return &TransferError{
+		Endpoint:    endpoint,
+		Direction:   endpoint&endpointDirectionMask != 0,
+		Transferred: transferred,
+		Code:        errorCode(err),
+		Err:         err,
+	}
+}
+
+// nativeError is implemented by the error types of the backends carrying a
+// platform specific error code.
+type nativeError interface {
+	error
+	code() int
+}
+
+// errorCode extracts the native error code from an error, zero if it carries
+// none.
+func errorCode(err error) int {
+	var native nativeError
+	if errors.As(err, &native) {
+		return native.code()
+	}
+	return 0
 }
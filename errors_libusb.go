@@ -0,0 +1,99 @@
+//go:build (freebsd && cgo) || (linux && cgo) || (openbsd && cgo) || (netbsd && cgo) || (darwin && !ios && cgo && !iokit) || (windows && cgo && !winusb) || (!cgo && (amd64 || arm64) && (linux || (darwin && !ios && !iokit)))
+
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zerousb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// libusbError is an Error code from libusb.
+type libusbError int
+
+// Error implements the Error interface.
+func (e libusbError) Error() string {
+	return fmt.Sprintf("libusb: %s [code %d]", libusbErrorString[e], e)
+}
+
+// Is reports whether the libusb error corresponds to a portable sentinel error.
+func (e libusbError) Is(target error) bool {
+	sentinel, ok := libusbErrorSentinel[e]
+	return ok && sentinel == target
+}
+
+// code returns the raw libusb error code.
+func (e libusbError) code() int {
+	return int(e)
+}
+
+// Error codes of libusb, spelled out as the runtime loaded backend has no cgo
+// to take them from the header.
+const (
+	libusbSuccess         libusbError = 0   // LIBUSB_SUCCESS
+	libusbErrIO           libusbError = -1  // LIBUSB_ERROR_IO
+	libusbErrInvalidParam libusbError = -2  // LIBUSB_ERROR_INVALID_PARAM
+	libusbErrAccess       libusbError = -3  // LIBUSB_ERROR_ACCESS
+	libusbErrNoDevice     libusbError = -4  // LIBUSB_ERROR_NO_DEVICE
+	libusbErrNotFound     libusbError = -5  // LIBUSB_ERROR_NOT_FOUND
+	libusbErrBusy         libusbError = -6  // LIBUSB_ERROR_BUSY
+	libusbErrTimeout      libusbError = -7  // LIBUSB_ERROR_TIMEOUT
+	libusbErrOverflow     libusbError = -8  // LIBUSB_ERROR_OVERFLOW
+	libusbErrPipe         libusbError = -9  // LIBUSB_ERROR_PIPE
+	libusbErrInterrupted  libusbError = -10 // LIBUSB_ERROR_INTERRUPTED
+	libusbErrNoMem        libusbError = -11 // LIBUSB_ERROR_NO_MEM
+	libusbErrNotSupported libusbError = -12 // LIBUSB_ERROR_NOT_SUPPORTED
+	libusbErrOther        libusbError = -99 // LIBUSB_ERROR_OTHER
+)
+
+var libusbErrorString = map[libusbError]string{
+	libusbSuccess:         "success",
+	libusbErrIO:           "i/o Error",
+	libusbErrInvalidParam: "invalid param",
+	libusbErrAccess:       "bad access",
+	libusbErrNoDevice:     "no device",
+	libusbErrNotFound:     "not found",
+	libusbErrBusy:         "device or resource busy",
+	libusbErrTimeout:      "timeout",
+	libusbErrOverflow:     "overflow",
+	libusbErrPipe:         "pipe Error",
+	libusbErrInterrupted:  "intErrupted",
+	libusbErrNoMem:        "out of memory",
+	libusbErrNotSupported: "not supported",
+	libusbErrOther:        "unknown Error",
+}
+
+var libusbErrorSentinel = map[libusbError]error{
+	libusbErrIO:           ErrIO,
+	libusbErrInvalidParam: ErrInvalidParam,
+	libusbErrAccess:       ErrAccess,
+	libusbErrNoDevice:     ErrNoDevice,
+	libusbErrNotFound:     ErrNotFound,
+	libusbErrBusy:         ErrBusy,
+	libusbErrTimeout:      ErrTimeout,
+	libusbErrOverflow:     ErrOverflow,
+	libusbErrPipe:         ErrPipe,
+	libusbErrInterrupted:  ErrInterrupted,
+	libusbErrNoMem:        ErrNoMem,
+	libusbErrNotSupported: ErrNotSupported,
+}
+
+// transientError reports whether a transfer error is worth retrying under the
+// default retry policy.
+func transientError(err error) bool {
+	return errors.Is(err, ErrTimeout) || errors.Is(err, ErrPipe) || errors.Is(err, ErrInterrupted)
+}
@@ -0,0 +1,57 @@
+//go:build (freebsd && cgo) || (linux && cgo) || (openbsd && cgo) || (netbsd && cgo) || (darwin && !ios && cgo && !iokit) || (windows && cgo && !winusb) || (!cgo && (amd64 || arm64) && (linux || (darwin && !ios && !iokit)))
+
+package zerousb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// Tests that wrapped libusb errors match their portable sentinels.
+func TestLibusbErrorIs(t *testing.T) {
+	err := fmt.Errorf("failed to read from device: %w", libusbErrTimeout)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("timeout not matched: %v", err)
+	}
+	if errors.Is(err, ErrPipe) {
+		t.Errorf("timeout matched as pipe error: %v", err)
+	}
+	if errors.Is(libusbErrOther, ErrIO) {
+		t.Errorf("unmapped error matched a sentinel")
+	}
+}
+
+// Tests that transfer errors report the endpoint, direction and native code,
+// while still matching the sentinel of the wrapped error.
+func TestTransferError(t *testing.T) {
+	var err error = newTransferError(0x81, 12, libusbErrTimeout)
+
+	var terr *TransferError
+	if !errors.As(err, &terr) {
+		t.Fatalf("transfer error not found: %v", err)
+	}
+	if terr.Direction != EndpointDirectionIn || terr.Transferred != 12 || terr.Code != int(libusbErrTimeout) {
+		t.Errorf("transfer error mismatch: %+v", terr)
+	}
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("timeout not matched: %v", err)
+	}
+	if dir := newTransferError(0x02, 0, libusbErrPipe).Direction; dir != EndpointDirectionOut {
+		t.Errorf("direction mismatch: have %v, want %v", dir, EndpointDirectionOut)
+	}
+}
+
+// Tests that access errors carry the device node and hint in their message,
+// while still matching the access sentinel.
+func TestAccessError(t *testing.T) {
+	var err error = &AccessError{Node: "/dev/bus/usb/001/004", Mode: 0o664, Owner: "root:root", Hint: "run as root", Err: libusbErrAccess}
+
+	want := "failed to open device: access denied to /dev/bus/usb/001/004 (-rw-rw-r-- root:root), run as root: libusb: bad access [code -3]"
+	if err.Error() != want {
+		t.Errorf("message mismatch: have %q, want %q", err.Error(), want)
+	}
+	if !errors.Is(err, ErrAccess) {
+		t.Errorf("access denial not matched: %v", err)
+	}
+}
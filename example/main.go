@@ -13,6 +13,12 @@ const ExampleVendorId = zerousb.ID(0x0483)
 const ExampleProductId = zerousb.ID(0xa27e)
 
 func main() {
+	// Set up the backend before using it
+	if err := zerousb.Init(); err != nil {
+		panic(err)
+	}
+	defer zerousb.Exit()
+
 	// Enumerate over all connected devices
 	devices, err := zerousb.Find(ExampleVendorId, 0)
 	if err != nil {
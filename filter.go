@@ -0,0 +1,78 @@
+package zerousb
+
+// DeviceID is a vendor and product id pair.
+type DeviceID struct {
+	VendorID  ID
+	ProductID ID // Zero for any product of the vendor
+}
+
+// Filter selects device interfaces during enumeration. Zero fields match any
+// interface, lists match if any of their entries does.
+type Filter struct {
+	IDs []DeviceID // Vendor and product ids of the device
+
+	// Class of the device, or of the interface for devices declaring their
+	// class per interface, zero for any. The subclass and protocol are only
+	// compared if set.
+	Class    Class
+	SubClass *uint8
+	Protocol *uint8
+
+	MinSpeed Speed   // Minimum negotiated speed, devices of unknown speed never match
+	Buses    []uint8 // Bus numbers the device may be connected to
+	Ports    []uint8 // Port numbers on the parent hub the device may be connected to
+
+	HID bool // Whether to include HID class devices and interfaces, see EnumerateHID
+}
+
+// Match reports whether the filter accepts an interface.
+func (f *Filter) Match(info DeviceInfo) bool {
+	if len(f.IDs) > 0 {
+		found := false
+		for _, id := range f.IDs {
+			if ID(info.VendorID) == id.VendorID && (id.ProductID == 0 || ID(info.ProductID) == id.ProductID) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.Class != 0 {
+		class, sub, proto := info.Class, info.SubClass, info.Protocol
+		if Class(class) == ClassPerInterface {
+			class, sub, proto = info.InterfaceClass, info.InterfaceSubClass, info.InterfaceProtocol
+		}
+		if Class(class) != f.Class || (f.SubClass != nil && sub != *f.SubClass) || (f.Protocol != nil && proto != *f.Protocol) {
+			return false
+		}
+	}
+	if f.MinSpeed != SpeedUnknown && (info.Speed == SpeedUnknown || info.Speed < f.MinSpeed) {
+		return false
+	}
+	return matchAny(f.Buses, info.Bus) && matchAny(f.Ports, info.Port)
+}
+
+// matchAny reports whether v is in the list, or the list is empty.
+func matchAny(list []uint8, v uint8) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// EnumerateAll returns all the USB device interfaces attached to the system
+// which are accepted by the filter. Like with Enumerate, the filter runs during
+// enumeration, so devices are never retained for interfaces it rejects.
+func EnumerateAll(filter Filter) ([]DeviceInfo, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	return enumerate(filter.Match, filter.HID)
+}
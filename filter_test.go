@@ -0,0 +1,51 @@
+package zerousb
+
+import "testing"
+
+// Tests that filters match interfaces on all of their set fields.
+func TestFilterMatch(t *testing.T) {
+	sub, proto := uint8(0x42), uint8(0x01)
+	adb := DeviceInfo{
+		VendorID:          0x18d1,
+		ProductID:         0x4ee7,
+		InterfaceClass:    uint8(ClassVendorSpec),
+		InterfaceSubClass: 0x42,
+		InterfaceProtocol: 0x01,
+		Bus:               1,
+		Port:              3,
+		Speed:             SpeedHigh,
+	}
+	tests := []struct {
+		filter Filter
+		match  bool
+	}{
+		{Filter{}, true},
+		{Filter{IDs: []DeviceID{{0x1d6b, 0}, {0x18d1, 0}}}, true},
+		{Filter{IDs: []DeviceID{{0x18d1, 0x4ee7}}}, true},
+		{Filter{IDs: []DeviceID{{0x18d1, 0x4ee0}}}, false},
+		{Filter{Class: ClassVendorSpec, SubClass: &sub, Protocol: &proto}, true},
+		{Filter{Class: ClassVendorSpec, SubClass: &proto}, false},
+		{Filter{Class: ClassMassStorage}, false},
+		{Filter{MinSpeed: SpeedHigh}, true},
+		{Filter{MinSpeed: SpeedSuper}, false},
+		{Filter{Buses: []uint8{1, 2}, Ports: []uint8{3}}, true},
+		{Filter{Buses: []uint8{2}}, false},
+		{Filter{Ports: []uint8{1, 2}}, false},
+	}
+	for i, tt := range tests {
+		if have := tt.filter.Match(adb); have != tt.match {
+			t.Errorf("test %d: match mismatch: have %v, want %v", i, have, tt.match)
+		}
+	}
+	// Devices of unknown speed never satisfy a minimum
+	unknown := adb
+	unknown.Speed = SpeedUnknown
+	if (&Filter{MinSpeed: SpeedLow}).Match(unknown) {
+		t.Errorf("unknown speed matched minimum speed")
+	}
+	// Device level classes take precedence over the interface ones
+	hub := DeviceInfo{Class: uint8(ClassHub), InterfaceClass: uint8(ClassHub)}
+	if !(&Filter{Class: ClassHub}).Match(hub) {
+		t.Errorf("device class not matched")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !((freebsd && cgo) || (linux && cgo) || (openbsd && cgo) || (netbsd && cgo) || (darwin && !ios && cgo && !iokit) || (windows && cgo && !winusb) || (!cgo && (amd64 || arm64) && (linux || (darwin && !ios && !iokit))))
+
+package zerousb
+
+// findDevices is the internal device enumerator of Find, returning every
+// interface of the devices with the given vendor and product IDs, zero
+// matching any. The backend discovers interfaces directly, so there's no
+// cheaper way than matching them one by one.
+func findDevices(vendorID ID, productID ID, hid bool) ([]DeviceInfo, error) {
+	return getAllDevices(matchIDs(vendorID, productID), hid)
+}
@@ -0,0 +1,349 @@
+// Package ftdi implements the vendor specific requests of FTDI USB UART and
+// FIFO chips (FT232, FT2232, FT4232 and friends) on top of zerousb, along with
+// the stripping of the modem status bytes prefixed to every received packet.
+package ftdi
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/chay22/zerousb"
+)
+
+// Vendor requests understood by FTDI chips.
+const (
+	requestReset          = 0x00
+	requestSetModemCtrl   = 0x01
+	requestSetFlowCtrl    = 0x02
+	requestSetBaudRate    = 0x03
+	requestSetData        = 0x04
+	requestGetModemStatus = 0x05
+	requestSetLatency     = 0x09
+	requestGetLatency     = 0x0a
+	requestSetBitMode     = 0x0b
+	requestReadPins       = 0x0c
+)
+
+// Request values of the reset and modem control requests.
+const (
+	resetSIO       = 0x00
+	resetPurgeRX   = 0x01
+	resetPurgeTX   = 0x02
+	modemCtrlDTR   = 0x0101
+	modemCtrlNoDTR = 0x0100
+	modemCtrlRTS   = 0x0202
+	modemCtrlNoRTS = 0x0200
+)
+
+const (
+	statusLength      = 2       // Modem status bytes prefixed to every IN packet
+	defaultPacketSize = 64      // Max packet size of full speed chips
+	baseClock         = 3000000 // Baud generator clock of the UART
+	minBaudRate       = 183     // Largest 14 bit divisor at the base clock
+)
+
+// BitMode selects the function of the chip's I/O pins.
+type BitMode uint8
+
+// Bit modes supported by the FTDI chips, not all of them by every chip.
+const (
+	BitModeReset       BitMode = 0x00 // Back to the UART/FIFO mode set in the EEPROM
+	BitModeBitbang     BitMode = 0x01 // Asynchronous bitbang
+	BitModeMPSSE       BitMode = 0x02 // Multi-Protocol Synchronous Serial Engine
+	BitModeSyncBitbang BitMode = 0x04 // Synchronous bitbang
+	BitModeMCU         BitMode = 0x08 // MCU host bus emulation
+	BitModeOpto        BitMode = 0x10 // Fast opto-isolated serial
+	BitModeCBUS        BitMode = 0x20 // CBUS pin bitbang
+	BitModeSyncFIFO    BitMode = 0x40 // Single channel synchronous FIFO
+	BitModeFT1284      BitMode = 0x80 // FT1284 mode
+)
+
+// Parity is the parity mode of the UART.
+type Parity uint16
+
+// Parity modes, pre-shifted into the SetData request value.
+const (
+	ParityNone  Parity = 0 << 8
+	ParityOdd   Parity = 1 << 8
+	ParityEven  Parity = 2 << 8
+	ParityMark  Parity = 3 << 8
+	ParitySpace Parity = 4 << 8
+)
+
+// StopBits is the number of stop bits of the UART.
+type StopBits uint16
+
+// Stop bit modes, pre-shifted into the SetData request value.
+const (
+	StopBits1  StopBits = 0 << 11
+	StopBits15 StopBits = 1 << 11
+	StopBits2  StopBits = 2 << 11
+)
+
+// FlowControl is the hardware handshake mode of the UART.
+type FlowControl uint16
+
+// Flow control modes, used as the high byte of the SetFlowCtrl index.
+const (
+	FlowNone    FlowControl = 0x0000
+	FlowRTSCTS  FlowControl = 0x0100
+	FlowDTRDSR  FlowControl = 0x0200
+	FlowXONXOFF FlowControl = 0x0400
+)
+
+// ModemStatus is the pair of status bytes reported by the chip.
+type ModemStatus [2]byte
+
+// CTS reports the state of the Clear To Send line.
+func (s ModemStatus) CTS() bool { return s[0]&0x10 != 0 }
+
+// DSR reports the state of the Data Set Ready line.
+func (s ModemStatus) DSR() bool { return s[0]&0x20 != 0 }
+
+// RI reports the state of the Ring Indicator line.
+func (s ModemStatus) RI() bool { return s[0]&0x40 != 0 }
+
+// DCD reports the state of the Data Carrier Detect line.
+func (s ModemStatus) DCD() bool { return s[0]&0x80 != 0 }
+
+// ErrBaudRate is returned for baud rates the chip can't generate.
+var ErrBaudRate = errors.New("ftdi: unsupported baud rate")
+
+// Transport is the subset of zerousb.Device needed to drive an FTDI chip.
+type Transport interface {
+	Read(b []byte) (int, error)
+	Write(b []byte) (int, error)
+	Control(rType, request uint8, val, idx uint16, data []byte) (int, error)
+}
+
+// Device is a single channel of an FTDI chip.
+type Device struct {
+	dev        Transport
+	index      uint16 // Channel index used in requests, 1 based (A = 1)
+	channelled bool   // Whether the baud rate index carries the channel, see channelledBaud
+	packetSize int    // Max packet size of the IN endpoint, each prefixed by status
+
+	lock    sync.Mutex  // Protects the receive buffer below
+	pending []byte      // Payload received but not yet consumed by Read
+	status  ModemStatus // Modem status from the most recent packet
+}
+
+// New wraps an opened device enumerated on the given interface, which selects
+// the channel on multi-channel chips.
+func New(dev Transport, info zerousb.DeviceInfo) *Device {
+	d := &Device{
+		dev:        dev,
+		index:      uint16(info.Interface) + 1,
+		channelled: channelledBaud(info.Release),
+		packetSize: defaultPacketSize,
+	}
+	for _, end := range info.Endpoints {
+		if end.Direction() == zerousb.EndpointDirectionIn && end.TransferType() == zerousb.TransferTypeBulk {
+			d.packetSize = int(end.MaxPacketSize)
+			break
+		}
+	}
+	return d
+}
+
+// control issues a vendor request towards the channel of the device.
+func (d *Device) control(request uint8, val uint16, idx uint16) error {
+	if _, err := d.dev.Control(zerousb.ControlOut|zerousb.ControlVendor|zerousb.ControlDevice, request, val, idx, nil); err != nil {
+		return fmt.Errorf("ftdi: request 0x%02x failed: %w", request, err)
+	}
+	return nil
+}
+
+// Reset resets the UART of the channel.
+func (d *Device) Reset() error {
+	return d.control(requestReset, resetSIO, d.index)
+}
+
+// Purge discards the data buffered in the chip's receive and/or transmit FIFO.
+func (d *Device) Purge(rx, tx bool) error {
+	if rx {
+		if err := d.control(requestReset, resetPurgeRX, d.index); err != nil {
+			return err
+		}
+		d.lock.Lock()
+		d.pending = nil
+		d.lock.Unlock()
+	}
+	if tx {
+		return d.control(requestReset, resetPurgeTX, d.index)
+	}
+	return nil
+}
+
+// channelledBaud reports whether a chip, identified by its bcdDevice, expects
+// the channel in the low byte of the baud rate index and the high divisor bits
+// in the high byte, as the multi-channel and H series chips do. Single channel
+// chips such as the FT232R and FT232BM take the high divisor bits unshifted.
+func channelledBaud(release uint16) bool {
+	switch release {
+	case 0x0500, 0x0700, 0x0800, 0x0900: // FT2232C/D, FT2232H, FT4232H, FT232H
+		return true
+	}
+	return false
+}
+
+// SetBaudRate configures the UART baud rate, rounded to the nearest rate the
+// chip's 3MHz baud generator supports.
+func (d *Device) SetBaudRate(baud int) error {
+	val, idx, err := baudDivisor(baud)
+	if err != nil {
+		return err
+	}
+	if d.channelled {
+		idx = idx<<8 | d.index
+	}
+	return d.control(requestSetBaudRate, val, idx)
+}
+
+// fracCode maps eighths of the divisor onto their encoding in the request.
+var fracCode = [8]uint32{0, 3, 2, 4, 1, 5, 6, 7}
+
+// baudDivisor calculates the encoded divisor for a baud rate, split into the
+// request value and the upper bits destined for the index.
+func baudDivisor(baud int) (uint16, uint16, error) {
+	if baud < minBaudRate || baud > baseClock {
+		return 0, 0, fmt.Errorf("%w: %d", ErrBaudRate, baud)
+	}
+	// Divisor in eighths, rounded to the nearest one
+	div := (baseClock*8 + baud/2) / baud
+
+	var encoded uint32
+	switch div {
+	case 8:
+		encoded = 0 // Special case for the full 3MHz
+	case 12:
+		encoded = 1 // Special case for 2MHz
+	default:
+		encoded = uint32(div>>3) | fracCode[div&7]<<14
+	}
+	return uint16(encoded), uint16(encoded >> 16), nil
+}
+
+// SetLineProperty configures the data bits, stop bits and parity of the UART.
+func (d *Device) SetLineProperty(bits int, stop StopBits, parity Parity) error {
+	if bits < 7 || bits > 8 {
+		return fmt.Errorf("ftdi: unsupported data bits: %d", bits)
+	}
+	return d.control(requestSetData, uint16(bits)|uint16(stop)|uint16(parity), d.index)
+}
+
+// SetFlowControl configures the hardware handshake of the UART.
+func (d *Device) SetFlowControl(flow FlowControl) error {
+	return d.control(requestSetFlowCtrl, 0, uint16(flow)|d.index)
+}
+
+// SetDTR drives the Data Terminal Ready line.
+func (d *Device) SetDTR(on bool) error {
+	if on {
+		return d.control(requestSetModemCtrl, modemCtrlDTR, d.index)
+	}
+	return d.control(requestSetModemCtrl, modemCtrlNoDTR, d.index)
+}
+
+// SetRTS drives the Request To Send line.
+func (d *Device) SetRTS(on bool) error {
+	if on {
+		return d.control(requestSetModemCtrl, modemCtrlRTS, d.index)
+	}
+	return d.control(requestSetModemCtrl, modemCtrlNoRTS, d.index)
+}
+
+// SetLatencyTimer sets the time in milliseconds (1-255) the chip waits before
+// flushing a partially filled packet to the host.
+func (d *Device) SetLatencyTimer(ms uint8) error {
+	if ms == 0 {
+		return errors.New("ftdi: latency timer must be at least 1ms")
+	}
+	return d.control(requestSetLatency, uint16(ms), d.index)
+}
+
+// LatencyTimer retrieves the latency timer in milliseconds.
+func (d *Device) LatencyTimer() (uint8, error) {
+	buf := make([]byte, 1)
+	if _, err := d.dev.Control(zerousb.ControlIn|zerousb.ControlVendor|zerousb.ControlDevice, requestGetLatency, 0, d.index, buf); err != nil {
+		return 0, fmt.Errorf("ftdi: failed to get latency timer: %w", err)
+	}
+	return buf[0], nil
+}
+
+// SetBitMode switches the channel into a bitbang or MPSSE mode, with mask
+// selecting which pins are outputs in the bitbang modes.
+func (d *Device) SetBitMode(mask uint8, mode BitMode) error {
+	return d.control(requestSetBitMode, uint16(mode)<<8|uint16(mask), d.index)
+}
+
+// ReadPins reads the instantaneous state of the data bus pins.
+func (d *Device) ReadPins() (uint8, error) {
+	buf := make([]byte, 1)
+	if _, err := d.dev.Control(zerousb.ControlIn|zerousb.ControlVendor|zerousb.ControlDevice, requestReadPins, 0, d.index, buf); err != nil {
+		return 0, fmt.Errorf("ftdi: failed to read pins: %w", err)
+	}
+	return buf[0], nil
+}
+
+// ModemStatus polls the modem status of the channel from the device.
+func (d *Device) ModemStatus() (ModemStatus, error) {
+	var status ModemStatus
+	if _, err := d.dev.Control(zerousb.ControlIn|zerousb.ControlVendor|zerousb.ControlDevice, requestGetModemStatus, 0, d.index, status[:]); err != nil {
+		return status, fmt.Errorf("ftdi: failed to get modem status: %w", err)
+	}
+	return status, nil
+}
+
+// LastStatus returns the modem status received along the most recent data.
+func (d *Device) LastStatus() ModemStatus {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.status
+}
+
+// Write sends data to the channel.
+func (d *Device) Write(b []byte) (int, error) {
+	return d.dev.Write(b)
+}
+
+// Read receives data from the channel, stripping the modem status bytes the
+// chip prepends to every packet. Packets carrying only status are skipped, so
+// Read blocks (subject to the device read timeout) until payload arrives.
+func (d *Device) Read(b []byte) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for len(d.pending) == 0 {
+		// Read whole packets, anything else risks an overflow
+		size := (len(b)/(d.packetSize-statusLength) + 1) * d.packetSize
+		buf := make([]byte, size)
+
+		n, err := d.dev.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		d.pending = d.strip(buf[:n])
+	}
+	n := copy(b, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+// strip removes the status bytes from each packet of a bulk transfer.
+func (d *Device) strip(buf []byte) []byte {
+	var payload []byte
+	for len(buf) >= statusLength {
+		copy(d.status[:], buf)
+
+		end := d.packetSize
+		if end > len(buf) {
+			end = len(buf)
+		}
+		payload = append(payload, buf[statusLength:end]...)
+		buf = buf[end:]
+	}
+	return payload
+}
@@ -0,0 +1,87 @@
+package ftdi
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/chay22/zerousb"
+)
+
+// controlRecorder is a transport recording the control requests issued.
+type controlRecorder struct {
+	Transport
+	val, idx uint16
+}
+
+func (r *controlRecorder) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	r.val, r.idx = val, idx
+	return len(data), nil
+}
+
+// Tests that baud rates are encoded into the chip's fractional divisors.
+func TestBaudDivisor(t *testing.T) {
+	tests := []struct {
+		baud     int
+		val, idx uint16
+	}{
+		{3000000, 0x0000, 0},
+		{2000000, 0x0001, 0},
+		{115200, 0x001a, 0},
+		{9600, 0x4138, 0},
+		{921600, 0x8003, 0},
+	}
+	for _, tt := range tests {
+		val, idx, err := baudDivisor(tt.baud)
+		if err != nil {
+			t.Errorf("baud %d: failed to encode: %v", tt.baud, err)
+			continue
+		}
+		if val != tt.val || idx != tt.idx {
+			t.Errorf("baud %d: divisor mismatch: have %#04x/%d, want %#04x/%d", tt.baud, val, idx, tt.val, tt.idx)
+		}
+	}
+	if _, _, err := baudDivisor(50); err == nil {
+		t.Errorf("too low baud rate accepted")
+	}
+}
+
+// Tests that the high divisor bits are sent unshifted to single channel chips,
+// and next to the channel on multi-channel and H series ones.
+func TestSetBaudRate(t *testing.T) {
+	tests := []struct {
+		release  uint16
+		iface    int
+		val, idx uint16
+	}{
+		{0x0600, 0, 0x001a, 0x0001}, // FT232R
+		{0x0400, 0, 0x001a, 0x0001}, // FT232BM
+		{0x0500, 0, 0x001a, 0x0101}, // FT2232C, channel A
+		{0x0700, 1, 0x001a, 0x0102}, // FT2232H, channel B
+		{0x0800, 3, 0x001a, 0x0104}, // FT4232H, channel D
+	}
+	for _, tt := range tests {
+		rec := new(controlRecorder)
+		d := New(rec, zerousb.DeviceInfo{Release: tt.release, Interface: tt.iface})
+
+		// 113744 baud divides the clock by 26 3/8, setting the high divisor bit
+		if err := d.SetBaudRate(113744); err != nil {
+			t.Fatalf("bcdDevice %#04x: failed to set baud rate: %v", tt.release, err)
+		}
+		if rec.val != tt.val || rec.idx != tt.idx {
+			t.Errorf("bcdDevice %#04x: request mismatch: have %#04x/%#04x, want %#04x/%#04x", tt.release, rec.val, rec.idx, tt.val, tt.idx)
+		}
+	}
+}
+
+// Tests that the modem status bytes are stripped from every packet.
+func TestStrip(t *testing.T) {
+	d := &Device{packetSize: 4}
+
+	payload := d.strip([]byte{0x11, 0x60, 'a', 'b', 0x31, 0x60, 'c', 'd', 0x31, 0x60, 'e'})
+	if !bytes.Equal(payload, []byte("abcde")) {
+		t.Errorf("payload mismatch: have %q, want %q", payload, "abcde")
+	}
+	if !d.status.CTS() || !d.status.DSR() {
+		t.Errorf("status mismatch: have %x", d.status)
+	}
+}
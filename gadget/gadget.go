@@ -0,0 +1,367 @@
+//go:build linux
+
+// Package gadget implements the device side of USB on Linux through
+// FunctionFS: it writes the function descriptors and strings to ep0, decodes
+// the control events the kernel queues there and exposes the bulk and
+// interrupt endpoint files as plain readers and writers. Together with the
+// host side of zerousb, both ends of a link can be implemented in Go.
+//
+// The FunctionFS instance needs to be mounted and linked into a ConfigFS
+// gadget beforehand, e.g. with:
+//
+//	mount -t functionfs mygadget /dev/ffs
+//
+// The gadget can only be bound to a UDC after Open returns.
+package gadget
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/chay22/zerousb"
+)
+
+// FunctionFS blob magics and flags, from linux/usb/functionfs.h.
+const (
+	descriptorsMagicV2 = 3
+	stringsMagic       = 2
+
+	hasFullSpeedDesc = 1
+	hasHighSpeedDesc = 2
+)
+
+// eventSize is the size of struct usb_functionfs_event.
+const eventSize = 12
+
+// EventType is the kind of event queued by FunctionFS on ep0.
+type EventType uint8
+
+// Event types, in the order of enum usb_functionfs_event_type.
+const (
+	EventBind EventType = iota
+	EventUnbind
+	EventEnable
+	EventDisable
+	EventSetup
+	EventSuspend
+	EventResume
+)
+
+// String returns a human readable name of the event type.
+func (t EventType) String() string {
+	switch t {
+	case EventBind:
+		return "bind"
+	case EventUnbind:
+		return "unbind"
+	case EventEnable:
+		return "enable"
+	case EventDisable:
+		return "disable"
+	case EventSetup:
+		return "setup"
+	case EventSuspend:
+		return "suspend"
+	case EventResume:
+		return "resume"
+	}
+	return fmt.Sprintf("EventType(%d)", uint8(t))
+}
+
+// Setup is a control request addressed to the function.
+type Setup struct {
+	RequestType uint8
+	Request     uint8
+	Value       uint16
+	Index       uint16
+	Length      uint16
+}
+
+// In reports whether the request has a device to host data stage.
+func (s Setup) In() bool {
+	return s.RequestType&zerousb.ControlIn != 0
+}
+
+// Event is a single event read from ep0. Setup is only valid for EventSetup.
+type Event struct {
+	Type  EventType
+	Setup Setup
+}
+
+// Endpoint describes a bulk or interrupt endpoint of the function.
+type Endpoint struct {
+	Address       uint8                // Endpoint number, direction in the top bit
+	Type          zerousb.TransferType // Bulk or interrupt
+	MaxPacketSize uint16               // High speed packet size, zero for the maximum
+	Interval      uint8                // Polling interval of interrupt endpoints in milliseconds
+}
+
+// Interface describes an interface of the function with its endpoints.
+type Interface struct {
+	Class     uint8
+	SubClass  uint8
+	Protocol  uint8
+	Name      string // Optional interface string
+	Endpoints []Endpoint
+}
+
+// Function is an opened FunctionFS instance.
+type Function struct {
+	ep0       *os.File
+	endpoints map[uint8]*os.File // Endpoint files keyed by descriptor address
+
+	lock    sync.Mutex
+	pending []Event // Events read from ep0 in one go, not yet returned
+}
+
+// Open writes the descriptors of the interfaces to the ep0 file of the
+// FunctionFS instance mounted at dir, and opens the endpoint files the kernel
+// creates in response.
+func Open(dir string, ifaces []Interface) (*Function, error) {
+	descs, strs, err := encode(ifaces)
+	if err != nil {
+		return nil, err
+	}
+	ep0, err := os.OpenFile(filepath.Join(dir, "ep0"), os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ep0: %w", err)
+	}
+	if _, err := ep0.Write(descs); err != nil {
+		ep0.Close()
+		return nil, fmt.Errorf("failed to write descriptors: %w", err)
+	}
+	if _, err := ep0.Write(strs); err != nil {
+		ep0.Close()
+		return nil, fmt.Errorf("failed to write strings: %w", err)
+	}
+	fn := &Function{
+		ep0:       ep0,
+		endpoints: make(map[uint8]*os.File),
+	}
+	// Endpoint files are numbered in the order of the descriptors
+	num := 1
+	for _, iface := range ifaces {
+		for _, ep := range iface.Endpoints {
+			file, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("ep%d", num)), os.O_RDWR, 0)
+			if err != nil {
+				fn.Close()
+				return nil, fmt.Errorf("failed to open endpoint %#02x: %w", ep.Address, err)
+			}
+			fn.endpoints[ep.Address] = file
+			num++
+		}
+	}
+	return fn, nil
+}
+
+// Close closes the endpoint files and ep0, which removes the function from
+// the bound gadget.
+func (fn *Function) Close() error {
+	for addr, file := range fn.endpoints {
+		file.Close()
+		delete(fn.endpoints, addr)
+	}
+	return fn.ep0.Close()
+}
+
+// Reader returns the file of an OUT endpoint, receiving data from the host.
+func (fn *Function) Reader(address uint8) (io.Reader, error) {
+	if address&zerousb.ControlIn != 0 {
+		return nil, fmt.Errorf("endpoint %#02x is not an out endpoint", address)
+	}
+	file, ok := fn.endpoints[address]
+	if !ok {
+		return nil, fmt.Errorf("endpoint %#02x not found", address)
+	}
+	return file, nil
+}
+
+// Writer returns the file of an IN endpoint, sending data to the host.
+func (fn *Function) Writer(address uint8) (io.Writer, error) {
+	if address&zerousb.ControlIn == 0 {
+		return nil, fmt.Errorf("endpoint %#02x is not an in endpoint", address)
+	}
+	file, ok := fn.endpoints[address]
+	if !ok {
+		return nil, fmt.Errorf("endpoint %#02x not found", address)
+	}
+	return file, nil
+}
+
+// ReadEvent blocks until the next event is queued on ep0. Setup events must
+// be answered with Reply, ReadData or Stall before reading the next event.
+func (fn *Function) ReadEvent() (Event, error) {
+	fn.lock.Lock()
+	defer fn.lock.Unlock()
+
+	if len(fn.pending) == 0 {
+		// The kernel hands out up to four events per read
+		buf := make([]byte, 4*eventSize)
+		n, err := fn.ep0.Read(buf)
+		if err != nil {
+			return Event{}, fmt.Errorf("failed to read event: %w", err)
+		}
+		fn.pending = decodeEvents(buf[:n])
+		if len(fn.pending) == 0 {
+			return Event{}, errors.New("short event read")
+		}
+	}
+	event := fn.pending[0]
+	fn.pending = fn.pending[1:]
+	return event, nil
+}
+
+// Reply completes the data stage of a device to host setup request.
+func (fn *Function) Reply(data []byte) error {
+	if _, err := fn.ep0.Write(data); err != nil {
+		return fmt.Errorf("failed to reply to setup: %w", err)
+	}
+	return nil
+}
+
+// ReadData retrieves the data stage of a host to device setup request,
+// acknowledging it.
+func (fn *Function) ReadData(b []byte) (int, error) {
+	n, err := fn.ep0.Read(b)
+	if err != nil {
+		return n, fmt.Errorf("failed to read setup data: %w", err)
+	}
+	return n, nil
+}
+
+// Stall rejects a setup request by doing I/O on ep0 in the direction opposite
+// to its data stage.
+func (fn *Function) Stall(setup Setup) error {
+	conn, err := fn.ep0.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("failed to stall setup: %w", err)
+	}
+	// Zero length I/O is short circuited by os.File, issue the syscall directly
+	var ioerr error
+	if err := conn.Control(func(fd uintptr) {
+		if setup.In() {
+			_, ioerr = syscall.Read(int(fd), nil)
+		} else {
+			_, ioerr = syscall.Write(int(fd), nil)
+		}
+	}); err != nil {
+		return fmt.Errorf("failed to stall setup: %w", err)
+	}
+	// FunctionFS reports the successfully stalled request with EL2HLT
+	if ioerr != nil && ioerr != syscall.EL2HLT {
+		return fmt.Errorf("failed to stall setup: %w", ioerr)
+	}
+	return nil
+}
+
+// decodeEvents splits a buffer read from ep0 into events.
+func decodeEvents(buf []byte) []Event {
+	var events []Event
+	for ; len(buf) >= eventSize; buf = buf[eventSize:] {
+		events = append(events, Event{
+			Type: EventType(buf[8]),
+			Setup: Setup{
+				RequestType: buf[0],
+				Request:     buf[1],
+				Value:       binary.LittleEndian.Uint16(buf[2:]),
+				Index:       binary.LittleEndian.Uint16(buf[4:]),
+				Length:      binary.LittleEndian.Uint16(buf[6:]),
+			},
+		})
+	}
+	return events
+}
+
+// encode builds the descriptors and strings blobs expected on ep0. Full and
+// high speed descriptors are generated from the same interface list, with the
+// packet sizes and intervals adjusted to each speed.
+func encode(ifaces []Interface) ([]byte, []byte, error) {
+	var (
+		fs, hs []byte
+		count  uint32
+		names  []string
+	)
+	for num, iface := range ifaces {
+		var str uint8
+		if iface.Name != "" {
+			names = append(names, iface.Name)
+			str = uint8(len(names))
+		}
+		desc := []byte{9, byte(zerousb.DescriptorTypeInterface), uint8(num), 0, uint8(len(iface.Endpoints)), iface.Class, iface.SubClass, iface.Protocol, str}
+		fs, hs = append(fs, desc...), append(hs, desc...)
+		count++
+
+		for _, ep := range iface.Endpoints {
+			var fsSize, hsSize uint16
+			var fsInterval, hsInterval uint8
+
+			switch ep.Type {
+			case zerousb.TransferTypeBulk:
+				fsSize, hsSize = 64, 512
+				if ep.MaxPacketSize != 0 && ep.MaxPacketSize < 64 {
+					fsSize, hsSize = ep.MaxPacketSize, ep.MaxPacketSize
+				}
+			case zerousb.TransferTypeInterrupt:
+				fsSize, hsSize = 64, 1024
+				if ep.MaxPacketSize != 0 {
+					hsSize = ep.MaxPacketSize
+					if hsSize < fsSize {
+						fsSize = hsSize
+					}
+				}
+				fsInterval, hsInterval = interval(ep.Interval)
+			default:
+				return nil, nil, fmt.Errorf("endpoint %#02x: unsupported transfer type %v", ep.Address, ep.Type)
+			}
+			fs = appendEndpoint(fs, ep, fsSize, fsInterval)
+			hs = appendEndpoint(hs, ep, hsSize, hsInterval)
+			count++
+		}
+	}
+	descs := make([]byte, 20, 20+len(fs)+len(hs))
+	binary.LittleEndian.PutUint32(descs[0:], descriptorsMagicV2)
+	binary.LittleEndian.PutUint32(descs[4:], uint32(20+len(fs)+len(hs)))
+	binary.LittleEndian.PutUint32(descs[8:], hasFullSpeedDesc|hasHighSpeedDesc)
+	binary.LittleEndian.PutUint32(descs[12:], count)
+	binary.LittleEndian.PutUint32(descs[16:], count)
+	descs = append(append(descs, fs...), hs...)
+
+	strs := make([]byte, 16)
+	binary.LittleEndian.PutUint32(strs[0:], stringsMagic)
+	binary.LittleEndian.PutUint32(strs[8:], uint32(len(names)))
+	if len(names) > 0 {
+		binary.LittleEndian.PutUint32(strs[12:], 1)
+		strs = append(strs, 0x09, 0x04) // en-US
+		for _, name := range names {
+			strs = append(append(strs, name...), 0)
+		}
+	}
+	binary.LittleEndian.PutUint32(strs[4:], uint32(len(strs)))
+
+	return descs, strs, nil
+}
+
+// appendEndpoint appends an endpoint descriptor for a given speed.
+func appendEndpoint(buf []byte, ep Endpoint, size uint16, interval uint8) []byte {
+	buf = append(buf, 7, byte(zerousb.DescriptorTypeEndpoint), ep.Address, uint8(ep.Type))
+	return append(buf, uint8(size), uint8(size>>8), interval)
+}
+
+// interval converts a polling interval in milliseconds into the full speed
+// frame count and the high speed exponent of 125us microframes.
+func interval(ms uint8) (uint8, uint8) {
+	if ms == 0 {
+		ms = 1
+	}
+	exp := uint8(4) // 2^(4-1) microframes = 1ms
+	for limit := 2; limit <= int(ms) && exp < 16; limit *= 2 {
+		exp++
+	}
+	return ms, exp
+}
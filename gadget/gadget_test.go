@@ -0,0 +1,91 @@
+//go:build linux
+
+package gadget
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chay22/zerousb"
+)
+
+// Tests that opening a function writes well formed descriptor and string
+// blobs to ep0 and maps the endpoint files in descriptor order.
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"ep0", "ep1", "ep2"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+	}
+	fn, err := Open(dir, []Interface{{
+		Class: 0xff,
+		Name:  "loopback",
+		Endpoints: []Endpoint{
+			{Address: 0x81, Type: zerousb.TransferTypeBulk},
+			{Address: 0x02, Type: zerousb.TransferTypeBulk},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("failed to open function: %v", err)
+	}
+	defer fn.Close()
+
+	if _, err := fn.Writer(0x81); err != nil {
+		t.Errorf("failed to get in endpoint: %v", err)
+	}
+	if _, err := fn.Reader(0x02); err != nil {
+		t.Errorf("failed to get out endpoint: %v", err)
+	}
+	if _, err := fn.Reader(0x81); err == nil {
+		t.Errorf("reader on in endpoint succeeded")
+	}
+	blob, err := os.ReadFile(filepath.Join(dir, "ep0"))
+	if err != nil {
+		t.Fatalf("failed to read ep0: %v", err)
+	}
+	// Descriptors: header, then an interface and two endpoints per speed
+	size := binary.LittleEndian.Uint32(blob[4:])
+	if have, want := size, uint32(20+2*(9+7+7)); have != want {
+		t.Fatalf("descriptors length mismatch: have %d, want %d", have, want)
+	}
+	if have := binary.LittleEndian.Uint32(blob[12:]); have != 3 {
+		t.Errorf("full speed count mismatch: have %d, want %d", have, 3)
+	}
+	if have := binary.LittleEndian.Uint16(blob[20+9+4:]); have != 64 {
+		t.Errorf("full speed packet size mismatch: have %d, want %d", have, 64)
+	}
+	if have := binary.LittleEndian.Uint16(blob[20+23+9+4:]); have != 512 {
+		t.Errorf("high speed packet size mismatch: have %d, want %d", have, 512)
+	}
+	// Strings: header, language and the interface name
+	strs := blob[size:]
+	if want := append([]byte{0x09, 0x04}, "loopback\x00"...); !bytes.Equal(strs[16:], want) {
+		t.Errorf("strings mismatch: have %x, want %x", strs[16:], want)
+	}
+}
+
+// Tests that multiple events read from ep0 at once are split correctly.
+func TestDecodeEvents(t *testing.T) {
+	buf := make([]byte, 2*eventSize)
+	buf[8] = byte(EventEnable)
+	copy(buf[eventSize:], []byte{0xc0, 0x01, 0x34, 0x12, 0x00, 0x00, 0x40, 0x00, byte(EventSetup)})
+
+	events := decodeEvents(buf)
+	if len(events) != 2 {
+		t.Fatalf("event count mismatch: have %d, want %d", len(events), 2)
+	}
+	if events[0].Type != EventEnable {
+		t.Errorf("event 0 type mismatch: have %v, want %v", events[0].Type, EventEnable)
+	}
+	want := Setup{RequestType: 0xc0, Request: 0x01, Value: 0x1234, Length: 0x40}
+	if events[1].Type != EventSetup || events[1].Setup != want {
+		t.Errorf("event 1 mismatch: have %v %+v, want %v %+v", events[1].Type, events[1].Setup, EventSetup, want)
+	}
+	if !events[1].Setup.In() {
+		t.Errorf("setup direction mismatch: have out, want in")
+	}
+}
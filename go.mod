@@ -1,3 +1,8 @@
 module github.com/chay22/zerousb
 
 go 1.18
+
+require (
+	github.com/ebitengine/purego v0.6.1
+	golang.org/x/sys v0.15.0
+)
@@ -0,0 +1,75 @@
+package zerousb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	traceWriter io.Writer  // Writer transfers are dumped to, nil if tracing is off
+	traceLock   sync.Mutex // Guards the writer, keeping concurrent dumps apart
+)
+
+// SetTraceWriter dumps every Read, Write and Control transfer to w as it
+// completes: its direction, endpoint or setup, timing and a hex/ASCII dump of
+// the data moved, which is handy when reverse engineering protocols. It can be
+// toggled at any time, a nil w turns tracing off.
+func SetTraceWriter(w io.Writer) {
+	traceLock.Lock()
+	defer traceLock.Unlock()
+
+	traceWriter = w
+}
+
+// traceTransfer dumps a finished Read or Write on an endpoint. The data moved
+// is the first n bytes of the buffers.
+func traceTransfer(endpoint uint8, start time.Time, bufs net.Buffers, n int, err error) {
+	traceLock.Lock()
+	defer traceLock.Unlock()
+
+	if traceWriter == nil {
+		return
+	}
+	data := bytes.Join(bufs, nil)
+	dir := "OUT"
+	if endpoint&endpointDirectionMask != 0 {
+		dir = "IN"
+	}
+	line := fmt.Sprintf("%-4s ep %#02x", dir, endpoint)
+	writeTrace(line, start, data, n, err)
+}
+
+// traceControl dumps a finished control request. The data moved is the first
+// n bytes of data.
+func traceControl(rType, request uint8, val, idx uint16, start time.Time, data []byte, n int, err error) {
+	traceLock.Lock()
+	defer traceLock.Unlock()
+
+	if traceWriter == nil {
+		return
+	}
+	dir := "OUT"
+	if rType&ControlIn != 0 {
+		dir = "IN"
+	}
+	line := fmt.Sprintf("CTRL %s type %#02x req %#02x val %#04x idx %#04x", dir, rType, request, val, idx)
+	writeTrace(line, start, data, n, err)
+}
+
+// writeTrace writes the summary line of a transfer to the trace writer, then
+// dumps the data moved. Write errors are ignored, tracing is best effort.
+func writeTrace(line string, start time.Time, data []byte, n int, err error) {
+	if n > len(data) {
+		n = len(data)
+	}
+	line = fmt.Sprintf("%s %s %d/%d bytes in %v", start.Format("15:04:05.000000"), line, n, len(data), time.Since(start))
+	if err != nil {
+		line += fmt.Sprintf(": %v", err)
+	}
+	io.WriteString(traceWriter, line+"\n"+hex.Dump(data[:n]))
+}
@@ -0,0 +1,45 @@
+package zerousb
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests that transfers are dumped with their direction, endpoint or setup and
+// the data actually moved, and that nothing is dumped once tracing is off.
+func TestTraceWriter(t *testing.T) {
+	defer SetTraceWriter(nil)
+
+	var out strings.Builder
+	SetTraceWriter(&out)
+
+	traceTransfer(0x02, time.Now(), net.Buffers{[]byte("hel"), []byte("lo")}, 5, nil)
+	traceTransfer(0x81, time.Now(), net.Buffers{make([]byte, 64)}, 0, ErrTimeout)
+	traceControl(ControlIn|ControlDevice, 0x06, 0x0100, 0, time.Now(), []byte{18, 1, 0, 2}, 2, nil)
+
+	SetTraceWriter(nil)
+	traceTransfer(0x02, time.Now(), net.Buffers{[]byte("discarded")}, 9, errors.New("failed"))
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	want := []string{
+		"OUT  ep 0x02 5/5 bytes in",
+		"00000000  68 65 6c 6c 6f                                    |hello|",
+		"IN   ep 0x81 0/64 bytes in",
+		"CTRL IN type 0x80 req 0x06 val 0x0100 idx 0x0000 2/4 bytes in",
+		"00000000  12 01                                             |..|",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("trace line count mismatch: have %q, want %d lines", lines, len(want))
+	}
+	for i, line := range lines {
+		if !strings.Contains(line, want[i]) {
+			t.Errorf("line %d mismatch: have %q, want %q", i, line, want[i])
+		}
+	}
+	if !strings.HasSuffix(lines[2], ErrTimeout.Error()) {
+		t.Errorf("failure not traced: have %q", lines[2])
+	}
+}
@@ -0,0 +1,165 @@
+package zerousb
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// hotplugPollInterval is the period devices are listed at to emulate hotplug
+// notifications on backends without them, zero if disabled.
+var hotplugPollInterval = time.Second
+
+// HotplugEvent is the kind of change reported to hotplug handlers.
+type HotplugEvent int
+
+const (
+	// DeviceArrived is reported when a device is plugged into the system.
+	DeviceArrived HotplugEvent = iota + 1
+
+	// DeviceLeft is reported when a device is unplugged from the system.
+	DeviceLeft
+)
+
+// String returns a human readable name of the event.
+func (e HotplugEvent) String() string {
+	switch e {
+	case DeviceArrived:
+		return "arrived"
+	case DeviceLeft:
+		return "left"
+	}
+	return fmt.Sprintf("HotplugEvent(%d)", int(e))
+}
+
+// HotplugHandler is invoked on device arrival and departure. The reported info
+// only contains device level fields, interfaces are not enumerated.
+type HotplugHandler func(event HotplugEvent, info DeviceInfo)
+
+// OnHotplug registers a handler to be notified when devices are attached to or
+// detached from the system. The returned function unregisters the handler.
+//
+// Backends without hotplug notifications (WinUSB, IOKit, or libusb on older
+// Windows) are polled instead, see SetHotplugPollInterval.
+//
+// Handlers are run on the libusb event handling goroutine, or the polling one,
+// and must not block, nor open devices directly; hand the work off to another
+// goroutine instead.
+func OnHotplug(handler HotplugHandler) (func(), error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	notify := func(event HotplugEvent, info DeviceInfo) {
+		logger().Debug("usb: hotplug event", "event", event, "vendor", ID(info.VendorID), "product", ID(info.ProductID), "device", info.Path)
+		handler(event, info)
+	}
+	cancel, err := hotplug(notify)
+	if (errors.Is(err, ErrNotSupported) || errors.Is(err, ErrUnsupportedPlatform)) && hotplugPollInterval > 0 {
+		logger().Debug("usb: hotplug unavailable, polling for devices", "interval", hotplugPollInterval, "err", err)
+		return pollHotplug(hotplugPollInterval, notify)
+	}
+	return cancel, err
+}
+
+// SetHotplugPollInterval sets the period devices are listed at to emulate
+// hotplug notifications on backends without them, one second by default. Zero
+// disables the emulation, OnHotplug and Watch failing on those backends then.
+// Handlers registered before keep polling at the previous interval.
+func SetHotplugPollInterval(interval time.Duration) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	hotplugPollInterval = interval
+}
+
+// pollHotplug lists the devices at every interval, notifying the handler of
+// the differences from the previous listing. Devices attached already are not
+// reported. The caller holds the package mutex.
+func pollHotplug(interval time.Duration, handler HotplugHandler) (func(), error) {
+	infos, err := list(func(DeviceInfo) bool { return true }, true)
+	if err != nil {
+		return nil, err
+	}
+	_, _, known := diffDevices(nil, infos)
+
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			lock.Lock()
+			infos, err := list(func(DeviceInfo) bool { return true }, true)
+			lock.Unlock()
+
+			if err != nil {
+				logger().Debug("usb: failed to poll devices", "err", err)
+				continue
+			}
+			var arrived, left []DeviceInfo
+			arrived, left, known = diffDevices(known, infos)
+
+			for _, event := range []struct {
+				event HotplugEvent
+				infos []DeviceInfo
+			}{{DeviceLeft, left}, {DeviceArrived, arrived}} {
+				for _, info := range event.infos {
+					select {
+					case <-stop:
+						return
+					default:
+						handler(event.event, info)
+					}
+				}
+			}
+		}
+	}()
+	// Handlers may cancel themselves, so the poller isn't waited for
+	var once sync.Once
+	return func() { once.Do(func() { close(stop) }) }, nil
+}
+
+// hotplugKey identifies a device across polls by its position on the bus and
+// its serial number, so a device swapped for another between two polls is
+// reported as such.
+func hotplugKey(info DeviceInfo) string {
+	return fmt.Sprintf("%d %d %s %s:%s %s", info.Bus, info.Port, devicePath(info.Path), ID(info.VendorID), ID(info.ProductID), info.Serial)
+}
+
+// diffDevices compares a listing of devices with the ones known from the
+// previous one, returning the arrived devices in the order listed, the ones
+// which left ordered by key, and the devices known now.
+func diffDevices(known map[string]DeviceInfo, infos []DeviceInfo) ([]DeviceInfo, []DeviceInfo, map[string]DeviceInfo) {
+	current := make(map[string]DeviceInfo, len(infos))
+	var arrived []DeviceInfo
+	for _, info := range infos {
+		key := hotplugKey(info)
+		if _, ok := current[key]; ok {
+			continue
+		}
+		current[key] = info
+		if _, ok := known[key]; !ok {
+			arrived = append(arrived, info)
+		}
+	}
+	var keys []string
+	for key := range known {
+		if _, ok := current[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	left := make([]DeviceInfo, len(keys))
+	for i, key := range keys {
+		left[i] = known[key]
+	}
+	return arrived, left, current
+}
@@ -0,0 +1,118 @@
+//go:build (freebsd && cgo) || (linux && cgo) || (openbsd && cgo) || (netbsd && cgo) || (darwin && !ios && cgo && !iokit) || (windows && cgo && !winusb)
+
+package zerousb
+
+/*
+	#include <libusb.h>
+
+	extern libusb_context* ctx;
+
+	int register_hotplug(intptr_t id, libusb_hotplug_callback_handle* handle);
+*/
+import "C"
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+var (
+	hotplugLock     sync.Mutex                     // Protects the hotplug handler registry
+	hotplugHandlers = make(map[int]HotplugHandler) // Active handlers by callback id
+	hotplugCancels  = make(map[int]func())         // Unsubscribers of the active handlers by callback id
+	hotplugNextID   int                            // Next callback id to hand out
+)
+
+// WaitForDevice blocks until a device interface accepted by the filter is
+// attached through the context, see the package level WaitForDevice.
+func (c *Context) WaitForDevice(ctx context.Context, filter Filter) (DeviceInfo, error) {
+	return WaitForDevice(ctx, filter)
+}
+
+// Watch reports the attachment and detachment of devices accepted by the
+// filter through the context, see the package level Watch.
+func (c *Context) Watch(filter Filter) (<-chan DeviceEvent, func(), error) {
+	return Watch(filter)
+}
+
+// onHotplug subscribes a handler to libusb hotplug notifications.
+func onHotplug(handler HotplugHandler) (func(), error) {
+	if err := checkContext(); err != nil {
+		return nil, err
+	}
+	if C.libusb_has_capability(C.LIBUSB_CAP_HAS_HOTPLUG) == 0 {
+		return nil, fmt.Errorf("failed to register hotplug handler: %w", ErrNotSupported)
+	}
+	hotplugLock.Lock()
+	id := hotplugNextID
+	hotplugNextID++
+	hotplugHandlers[id] = handler
+	hotplugLock.Unlock()
+
+	var handle C.libusb_hotplug_callback_handle
+	if err := fromLibusbErrno(C.register_hotplug(C.intptr_t(id), &handle)); err != nil {
+		hotplugLock.Lock()
+		delete(hotplugHandlers, id)
+		hotplugLock.Unlock()
+		return nil, fmt.Errorf("failed to register hotplug handler: %w", err)
+	}
+	libusbCtx.acquire()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			C.libusb_hotplug_deregister_callback(C.ctx, handle)
+
+			hotplugLock.Lock()
+			delete(hotplugHandlers, id)
+			delete(hotplugCancels, id)
+			hotplugLock.Unlock()
+
+			libusbCtx.release()
+		})
+	}
+	hotplugLock.Lock()
+	hotplugCancels[id] = cancel
+	hotplugLock.Unlock()
+
+	return cancel, nil
+}
+
+// cancelHotplug unsubscribes all the active hotplug handlers.
+func cancelHotplug() {
+	hotplugLock.Lock()
+	cancels := make([]func(), 0, len(hotplugCancels))
+	for _, cancel := range hotplugCancels {
+		cancels = append(cancels, cancel)
+	}
+	hotplugLock.Unlock()
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
+
+//export goHotplugCallback
+func goHotplugCallback(ctx *C.libusb_context, dev *C.libusb_device, event C.libusb_hotplug_event, id C.intptr_t) C.int {
+	hotplugLock.Lock()
+	handler, ok := hotplugHandlers[int(id)]
+	hotplugLock.Unlock()
+
+	if !ok {
+		return 1 // Deregistered meanwhile, tell libusb to drop the callback
+	}
+	var desc C.struct_libusb_device_descriptor
+	if err := fromLibusbErrno(C.libusb_get_device_descriptor(dev, &desc)); err != nil {
+		return 0
+	}
+	info := deviceInfo(dev, &desc)
+
+	switch event {
+	case C.LIBUSB_HOTPLUG_EVENT_DEVICE_ARRIVED:
+		handler(DeviceArrived, info)
+	case C.LIBUSB_HOTPLUG_EVENT_DEVICE_LEFT:
+		handler(DeviceLeft, info)
+	}
+	return 0
+}
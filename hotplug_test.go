@@ -0,0 +1,81 @@
+package zerousb
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that polled listings are diffed by bus position and serial number, so
+// devices swapped at the same port are reported as leaving and arriving.
+func TestDiffDevices(t *testing.T) {
+	first := []DeviceInfo{
+		{Path: "1-1", Bus: 1, Port: 1, VendorID: 0x1209, Serial: "A"},
+		{Path: "1-2", Bus: 1, Port: 2, VendorID: 0x1209, Serial: "B"},
+		{Path: "2-1", Bus: 2, Port: 1, VendorID: 0x1d6b},
+	}
+	second := []DeviceInfo{
+		{Path: "2-1", Bus: 2, Port: 1, VendorID: 0x1d6b},
+		{Path: "1-2", Bus: 1, Port: 2, VendorID: 0x1209, Serial: "C"},
+		{Path: "1-3", Bus: 1, Port: 3, VendorID: 0x1209, Serial: "A"},
+	}
+	arrived, left, known := diffDevices(nil, first)
+	if len(arrived) != 3 || len(left) != 0 || len(known) != 3 {
+		t.Fatalf("first diff mismatch: arrived %v, left %v", arrived, left)
+	}
+	arrived, left, known = diffDevices(known, second)
+	if len(arrived) != 2 || arrived[0].Serial != "C" || arrived[1].Serial != "A" {
+		t.Errorf("arrivals mismatch: have %v", arrived)
+	}
+	if len(left) != 2 || left[0].Serial != "A" || left[1].Serial != "B" {
+		t.Errorf("departures mismatch: have %v", left)
+	}
+	if len(known) != 3 {
+		t.Errorf("known devices mismatch: have %v", known)
+	}
+}
+
+// Tests that hotplug notifications are emulated by polling on backends without
+// them.
+func TestPollHotplug(t *testing.T) {
+	backend := &fakeBackend{ifaces: []DeviceInfo{
+		{Path: "1-2:0", VendorID: 0x1d6b, ProductID: 0x0104, Bus: 1, Port: 2},
+	}}
+	RegisterBackend("polled", func() (Backend, error) { return backend, nil })
+	SetHotplugPollInterval(10 * time.Millisecond)
+	t.Cleanup(func() {
+		UseBackend("")
+		SetHotplugPollInterval(time.Second)
+		lock.Lock()
+		delete(backendFactories, "polled")
+		lock.Unlock()
+	})
+	if err := UseBackend("polled"); err != nil {
+		t.Fatalf("failed to use backend: %v", err)
+	}
+	events := make(chan DeviceEvent, 4)
+	cancel, err := OnHotplug(func(event HotplugEvent, info DeviceInfo) {
+		events <- DeviceEvent{Event: event, Info: info}
+	})
+	if err != nil {
+		t.Fatalf("failed to register hotplug handler: %v", err)
+	}
+	defer cancel()
+
+	lock.Lock()
+	backend.ifaces = []DeviceInfo{{Path: "1-3:0", VendorID: 0x1d6b, ProductID: 0x0105, Bus: 1, Port: 3}}
+	lock.Unlock()
+
+	for _, want := range []DeviceEvent{
+		{Event: DeviceLeft, Info: DeviceInfo{Path: "1-2"}},
+		{Event: DeviceArrived, Info: DeviceInfo{Path: "1-3"}},
+	} {
+		select {
+		case have := <-events:
+			if have.Event != want.Event || have.Info.Path != want.Info.Path {
+				t.Errorf("event mismatch: have %v %s, want %v %s", have.Event, have.Info.Path, want.Event, want.Info.Path)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%v event not delivered", want.Event)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package zerousb
+
+import (
+	"fmt"
+	"time"
+)
+
+// Hub class feature selectors addressed to ports, from the USB 2.0 spec,
+// section 11.24.2.
+const featurePortPower = 0x08
+
+// hubTimeout is the timeout of the class requests to hubs in milliseconds.
+const hubTimeout = 1000
+
+// parentHub is the hub a device is attached to, opened for class requests
+// addressed to its ports without claiming any of its interfaces, so the
+// kernel hub driver stays bound.
+type parentHub struct {
+	control controlFunc
+	port    uint8  // Port of the hub the device is attached to
+	close   func() // Releases the hub handle
+}
+
+// setPortPower switches the power of a hub port via SET_FEATURE or
+// CLEAR_FEATURE(PORT_POWER).
+func setPortPower(control controlFunc, port uint8, on bool) error {
+	request := uint8(requestClearFeature)
+	if on {
+		request = requestSetFeature
+	}
+	if _, err := control(ControlOut|ControlClass|ControlOther, request, featurePortPower, uint16(port), nil); err != nil {
+		return fmt.Errorf("failed to switch power of hub port %d: %w", port, err)
+	}
+	return nil
+}
+
+// SetPortPower switches the power of the hub port a device is attached to.
+// Hubs without per port power switching accept the request but keep the
+// power on. Only supported by the libusb backend.
+func SetPortPower(info DeviceInfo, on bool) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := builtinOnly("switch port power"); err != nil {
+		return err
+	}
+	hub, err := openParentHub(info)
+	if err != nil {
+		return err
+	}
+	defer hub.close()
+
+	return setPortPower(hub.control, hub.port, on)
+}
+
+// PowerCycle switches off the hub port a device is attached to and powers it
+// on again after the given duration, the software equivalent of replugging a
+// stuck device. The device re-enumerates afterwards and must be opened again.
+//
+// USB 3 hubs expose their SuperSpeed and USB 2 halves as two hubs, the port
+// is only cycled on the half the device was enumerated on. Other calls into the
+// package proceed while the port is off, the hub handle kept open meanwhile
+// makes Exit fail though.
+func PowerCycle(info DeviceInfo, off time.Duration) error {
+	lock.Lock()
+	if err := builtinOnly("switch port power"); err != nil {
+		lock.Unlock()
+		return err
+	}
+	hub, err := openParentHub(info)
+	if err == nil {
+		if err = setPortPower(hub.control, hub.port, false); err != nil {
+			hub.close()
+		}
+	}
+	lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+	time.Sleep(off)
+
+	lock.Lock()
+	defer lock.Unlock()
+	defer hub.close()
+
+	return setPortPower(hub.control, hub.port, true)
+}
@@ -0,0 +1,23 @@
+package zerousb
+
+import "testing"
+
+// Tests that hub port power is switched via class SET_FEATURE and CLEAR_FEATURE
+// requests addressed to the port.
+func TestSetPortPower(t *testing.T) {
+	for _, on := range []bool{true, false} {
+		want := uint8(requestClearFeature)
+		if on {
+			want = requestSetFeature
+		}
+		err := setPortPower(func(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+			if rType != ControlOut|ControlClass|ControlOther || request != want || val != featurePortPower || idx != 3 {
+				t.Errorf("on %v: request mismatch: have %#x/%#x/%d/%d", on, rType, request, val, idx)
+			}
+			return 0, nil
+		}, 3, on)
+		if err != nil {
+			t.Errorf("on %v: failed to switch port power: %v", on, err)
+		}
+	}
+}
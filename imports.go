@@ -1,7 +1,10 @@
-// go:build (freebsd && cgo) || (linux && cgo) || (darwin && !ios && cgo) || (windows && cgo)
+//go:build ((freebsd && cgo) || (linux && cgo) || (openbsd && cgo) || (netbsd && cgo) || (darwin && !ios && cgo && !iokit) || (windows && cgo && !winusb)) && !systemlibusb
 
 package zerousb
 
+// The bundled libusb sources are compiled into the package, unless built with
+// the systemlibusb tag linking the libusb of the system instead (imports_system.go).
+
 /*
 #cgo CFLAGS: -I./libusb/libusb
 #cgo CFLAGS: -DDEFAULT_VISIBILITY=""
@@ -15,9 +18,12 @@ package zerousb
 #cgo windows LDFLAGS: -lsetupapi
 #cgo freebsd CFLAGS: -DOS_FREEBSD
 #cgo freebsd LDFLAGS: -lusb
-#cgo openbsd CFLAGS: -DOS_OPENBSD
+#cgo openbsd CFLAGS: -DOS_OPENBSD -DHAVE_SYS_TIME_H
+#cgo openbsd LDFLAGS: -lpthread
+#cgo netbsd CFLAGS: -DOS_NETBSD -DHAVE_SYS_TIME_H
+#cgo netbsd LDFLAGS: -lpthread
 
-#if defined(OS_LINUX) || defined(OS_DARWIN) || defined(DOS_FREEBSD) || defined(OS_OPENBSD)
+#if defined(OS_LINUX) || defined(OS_DARWIN) || defined(DOS_FREEBSD) || defined(OS_OPENBSD) || defined(OS_NETBSD)
 	#include <poll.h>
 	#include "os/threads_posix.c"
 	#include "os/poll_posix.c"
@@ -37,8 +43,10 @@ package zerousb
 	#include "os/windows_winusb.c"
 #elif OS_FREEBSD
 	#include <libusb.h>
-#elif DOS_OPENBSD
+#elif OS_OPENBSD
 	#include "os/openbsd_usb.c"
+#elif OS_NETBSD
+	#include "os/netbsd_usb.c"
 #endif
 
 #ifndef OS_FREEBSD
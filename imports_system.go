@@ -0,0 +1,9 @@
+//go:build ((freebsd && cgo) || (linux && cgo) || (openbsd && cgo) || (netbsd && cgo) || (darwin && !ios && cgo && !iokit) || (windows && cgo && !winusb)) && systemlibusb
+
+package zerousb
+
+// The libusb of the system is linked as found by pkg-config, so distributions
+// can update it independently of the binaries. It needs libusb 1.0.23 or newer.
+
+// #cgo pkg-config: libusb-1.0
+import "C"
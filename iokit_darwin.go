@@ -0,0 +1,1260 @@
+//go:build darwin && !ios && iokit
+
+package zerousb
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// IOKit and CoreFoundation entry points, bound on first use through purego so
+// that no C toolchain is needed to build the package.
+var (
+	ioServiceMatching                 func(name string) uintptr
+	ioRegistryEntryIDMatching         func(id uint64) uintptr
+	ioServiceGetMatchingServices      func(port uint32, matching uintptr, iter *uint32) int32
+	ioServiceGetMatchingService       func(port uint32, matching uintptr) uint32
+	ioIteratorNext                    func(iter uint32) uint32
+	ioObjectRelease                   func(object uint32) int32
+	ioRegistryEntryGetRegistryEntryID func(entry uint32, id *uint64) int32
+	ioRegistryEntryCreateCFProperty   func(entry uint32, key uintptr, allocator uintptr, options uint32) uintptr
+	ioRegistryEntryGetPath            func(entry uint32, plane string, path *byte) int32
+	ioCreatePlugInInterfaceForService func(service uint32, pluginType uintptr, interfaceType uintptr, plugin *iokitObject, score *int32) int32
+	cfStringCreateWithCString         func(allocator uintptr, str string, encoding uint32) uintptr
+	cfStringGetCString                func(str uintptr, buf *byte, size int, encoding uint32) bool
+	cfNumberGetValue                  func(number uintptr, kind int, value *int64) bool
+	cfGetTypeID                       func(ref uintptr) uintptr
+	cfStringGetTypeID                 func() uintptr
+	cfNumberGetTypeID                 func() uintptr
+	cfUUIDCreateFromString            func(allocator uintptr, str uintptr) uintptr
+	cfRelease                         func(ref uintptr)
+	iokitOnce                         sync.Once
+	iokitErr                          error
+	deviceUserClientType, pluginIID   uintptr
+	interfaceUserClientType           uintptr
+	deviceInterfaceIID, interfaceIID  [2]uintptr
+)
+
+// CoreFoundation constants used by the bindings.
+const (
+	cfStringEncodingUTF8 = 0x08000100
+	cfNumberSInt64Type   = 4
+)
+
+// IOUSBLib class and interface identifiers.
+const (
+	uuidDeviceUserClientType    = "9DC7B780-9EC0-11D4-A54F-000A27052861"
+	uuidInterfaceUserClientType = "2D9786C6-9EF3-11D4-AD51-000A27052861"
+	uuidCFPlugInInterface       = "C244E858-109C-11D4-91D4-0050E4C6426F"
+	uuidDeviceInterface182      = "152FC496-4891-11D5-9D52-000A27801E86"
+	uuidInterfaceInterface182   = "4923AC4C-4896-11D5-9208-000A27801E86"
+)
+
+// Method slots of the IOUSBDeviceInterface182 and IOUSBInterfaceInterface182
+// function tables, both starting with the IUnknown methods.
+const (
+	methodQueryInterface = 1
+	methodRelease        = 3
+
+	deviceClose                 = 9
+	deviceGetConfigurationDesc  = 21
+	deviceGetConfiguration      = 22
+	deviceSetConfiguration      = 23
+	deviceResetDevice           = 25
+	deviceCreateInterfaceIter   = 28
+	deviceOpenSeize             = 29
+	deviceRequestTO             = 30
+	interfaceOpen               = 8
+	interfaceClose              = 9
+	interfaceGetInterfaceNumber = 17
+	interfaceGetNumEndpoints    = 19
+	interfaceSetAlternate       = 22
+	interfaceGetPipeProperties  = 26
+	interfaceClearPipeStall     = 30
+	interfaceReadPipe           = 31
+	interfaceWritePipe          = 32
+	interfaceControlRequestTO   = 37
+	interfaceReadPipeTO         = 39
+	interfaceWritePipeTO        = 40
+)
+
+// findInterfaceDontCare is the IOUSBFindInterfaceRequest wildcard.
+const findInterfaceDontCare uint16 = 0xffff
+
+// ioReturn is an IOKit status code.
+type ioReturn uint32
+
+// IOKit status codes with special meaning to the backend.
+const (
+	ioReturnNoDevice        ioReturn = 0xe00002c0
+	ioReturnExclusiveAccess ioReturn = 0xe00002c5
+	ioReturnAborted         ioReturn = 0xe00002eb
+	ioReturnNotResponding   ioReturn = 0xe00002ed
+	ioReturnTimeout         ioReturn = 0xe00002d6
+	ioUSBPipeStalled        ioReturn = 0xe000404f
+	ioUSBTransactionTimeout ioReturn = 0xe0004051
+)
+
+// Error implements the error interface.
+func (r ioReturn) Error() string {
+	switch r {
+	case ioReturnNoDevice:
+		return "no such device"
+	case ioReturnExclusiveAccess:
+		return "exclusive access, claimed by another driver"
+	case ioReturnAborted:
+		return "transfer aborted"
+	case ioReturnNotResponding:
+		return "device not responding"
+	case ioReturnTimeout, ioUSBTransactionTimeout:
+		return "timeout"
+	case ioUSBPipeStalled:
+		return "pipe stalled"
+	}
+	return fmt.Sprintf("iokit error %#08x", uint32(r))
+}
+
+// Is reports whether the status code corresponds to a portable sentinel error.
+func (r ioReturn) Is(target error) bool {
+	switch r {
+	case ioReturnNoDevice:
+		return target == ErrNoDevice
+	case ioReturnExclusiveAccess:
+		return target == ErrBusy
+	case ioReturnAborted:
+		return target == ErrInterrupted
+	case ioReturnNotResponding:
+		return target == ErrIO
+	case ioReturnTimeout, ioUSBTransactionTimeout:
+		return target == ErrTimeout
+	case ioUSBPipeStalled:
+		return target == ErrPipe
+	}
+	return false
+}
+
+// code returns the raw IOKit status code.
+func (r ioReturn) code() int {
+	return int(r)
+}
+
+// fromIOReturn converts an IOKit status code into an error, nil on success.
+func fromIOReturn(r uintptr) error {
+	if code := ioReturn(r); code != 0 {
+		return code
+	}
+	return nil
+}
+
+// iokitObject is a COM style IOKit plugin interface, a pointer to a pointer to
+// its table of methods.
+type iokitObject **[64]uintptr
+
+// call invokes a method of a plugin interface, passing the interface itself as
+// the first argument.
+func call(obj iokitObject, method int, args ...uintptr) uintptr {
+	r, _, _ := purego.SyscallN((**obj)[method], append([]uintptr{uintptr(unsafe.Pointer(obj))}, args...)...)
+	return r
+}
+
+// initIOKit loads the frameworks and binds the used functions. All callers are
+// protected by the package mutex, but the once keeps it simple.
+func initIOKit() error {
+	iokitOnce.Do(func() {
+		iokit, err := purego.Dlopen("/System/Library/Frameworks/IOKit.framework/IOKit", purego.RTLD_NOW|purego.RTLD_GLOBAL)
+		if err != nil {
+			iokitErr = fmt.Errorf("failed to load IOKit: %w", err)
+			return
+		}
+		cf, err := purego.Dlopen("/System/Library/Frameworks/CoreFoundation.framework/CoreFoundation", purego.RTLD_NOW|purego.RTLD_GLOBAL)
+		if err != nil {
+			iokitErr = fmt.Errorf("failed to load CoreFoundation: %w", err)
+			return
+		}
+		purego.RegisterLibFunc(&ioServiceMatching, iokit, "IOServiceMatching")
+		purego.RegisterLibFunc(&ioRegistryEntryIDMatching, iokit, "IORegistryEntryIDMatching")
+		purego.RegisterLibFunc(&ioServiceGetMatchingServices, iokit, "IOServiceGetMatchingServices")
+		purego.RegisterLibFunc(&ioServiceGetMatchingService, iokit, "IOServiceGetMatchingService")
+		purego.RegisterLibFunc(&ioIteratorNext, iokit, "IOIteratorNext")
+		purego.RegisterLibFunc(&ioObjectRelease, iokit, "IOObjectRelease")
+		purego.RegisterLibFunc(&ioRegistryEntryGetRegistryEntryID, iokit, "IORegistryEntryGetRegistryEntryID")
+		purego.RegisterLibFunc(&ioRegistryEntryCreateCFProperty, iokit, "IORegistryEntryCreateCFProperty")
+		purego.RegisterLibFunc(&ioRegistryEntryGetPath, iokit, "IORegistryEntryGetPath")
+		purego.RegisterLibFunc(&ioCreatePlugInInterfaceForService, iokit, "IOCreatePlugInInterfaceForService")
+		purego.RegisterLibFunc(&cfStringCreateWithCString, cf, "CFStringCreateWithCString")
+		purego.RegisterLibFunc(&cfStringGetCString, cf, "CFStringGetCString")
+		purego.RegisterLibFunc(&cfNumberGetValue, cf, "CFNumberGetValue")
+		purego.RegisterLibFunc(&cfGetTypeID, cf, "CFGetTypeID")
+		purego.RegisterLibFunc(&cfStringGetTypeID, cf, "CFStringGetTypeID")
+		purego.RegisterLibFunc(&cfNumberGetTypeID, cf, "CFNumberGetTypeID")
+		purego.RegisterLibFunc(&cfUUIDCreateFromString, cf, "CFUUIDCreateFromString")
+		purego.RegisterLibFunc(&cfRelease, cf, "CFRelease")
+
+		// The plugin types are passed as CFUUIDRefs, the interfaces by value
+		deviceUserClientType = cfUUID(uuidDeviceUserClientType)
+		interfaceUserClientType = cfUUID(uuidInterfaceUserClientType)
+		pluginIID = cfUUID(uuidCFPlugInInterface)
+		deviceInterfaceIID = uuidWords(uuidDeviceInterface182)
+		interfaceIID = uuidWords(uuidInterfaceInterface182)
+	})
+	return iokitErr
+}
+
+// cfString creates a CFString, which needs to be released by the caller.
+func cfString(str string) uintptr {
+	return cfStringCreateWithCString(0, str, cfStringEncodingUTF8)
+}
+
+// cfUUID creates a CFUUID which is never released.
+func cfUUID(uuid string) uintptr {
+	str := cfString(uuid)
+	defer cfRelease(str)
+
+	return cfUUIDCreateFromString(0, str)
+}
+
+// uuidWords converts a UUID into the two machine words a CFUUIDBytes structure
+// is passed by value in.
+func uuidWords(uuid string) [2]uintptr {
+	raw, err := hex.DecodeString(strings.ReplaceAll(uuid, "-", ""))
+	if err != nil || len(raw) != 16 {
+		panic("invalid uuid " + uuid)
+	}
+	return [2]uintptr{uintptr(binary.LittleEndian.Uint64(raw)), uintptr(binary.LittleEndian.Uint64(raw[8:]))}
+}
+
+// registryNumber reads a numeric property of an IORegistry entry.
+func registryNumber(entry uint32, key string) (int64, bool) {
+	name := cfString(key)
+	defer cfRelease(name)
+
+	prop := ioRegistryEntryCreateCFProperty(entry, name, 0, 0)
+	if prop == 0 {
+		return 0, false
+	}
+	defer cfRelease(prop)
+
+	var value int64
+	if cfGetTypeID(prop) != cfNumberGetTypeID() || !cfNumberGetValue(prop, cfNumberSInt64Type, &value) {
+		return 0, false
+	}
+	return value, true
+}
+
+// registryString reads a string property of an IORegistry entry, returning an
+// empty string if it's missing.
+func registryString(entry uint32, key string) string {
+	name := cfString(key)
+	defer cfRelease(name)
+
+	prop := ioRegistryEntryCreateCFProperty(entry, name, 0, 0)
+	if prop == 0 {
+		return ""
+	}
+	defer cfRelease(prop)
+
+	if cfGetTypeID(prop) != cfStringGetTypeID() {
+		return ""
+	}
+	buf := make([]byte, 256)
+	if !cfStringGetCString(prop, &buf[0], len(buf), cfStringEncodingUTF8) {
+		return ""
+	}
+	return strings.TrimRight(string(buf), "\x00")
+}
+
+// registryPath returns the path of an IORegistry entry in the service plane,
+// or an empty string if it can't be retrieved.
+func registryPath(entry uint32) string {
+	buf := make([]byte, 512) // io_string_t
+	if ioRegistryEntryGetPath(entry, "IOService", &buf[0]) != 0 {
+		return ""
+	}
+	return strings.TrimRight(string(buf), "\x00")
+}
+
+// createInterface instantiates a plugin for an IOKit service and queries the
+// requested USB interface out of it.
+func createInterface(service uint32, clientType uintptr, iid [2]uintptr) (iokitObject, error) {
+	var (
+		plugin iokitObject
+		score  int32
+	)
+	if err := fromIOReturn(uintptr(uint32(ioCreatePlugInInterfaceForService(service, clientType, pluginIID, &plugin, &score)))); err != nil {
+		return nil, err
+	}
+	defer call(plugin, methodRelease)
+
+	var obj iokitObject
+	if r := uint32(call(plugin, methodQueryInterface, iid[0], iid[1], uintptr(unsafe.Pointer(&obj)))); r != 0 || obj == nil {
+		return nil, fmt.Errorf("interface not supported: %#x", r)
+	}
+	return obj, nil
+}
+
+// getAllDevices is the internal device enumerator returning every device
+// interface accepted by the match predicate. HID devices and interfaces are
+// only included if requested.
+func getAllDevices(match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	if err := initIOKit(); err != nil {
+		return nil, err
+	}
+	// Devices are published as IOUSBHostDevice since 10.11, IOUSBDevice before
+	var infos []DeviceInfo
+	for _, class := range []string{"IOUSBHostDevice", "IOUSBDevice"} {
+		var iter uint32
+		if err := fromIOReturn(uintptr(uint32(ioServiceGetMatchingServices(0, ioServiceMatching(class), &iter)))); err != nil {
+			return nil, fmt.Errorf("failed to list devices: %w", err)
+		}
+		found := false
+		for service := ioIteratorNext(iter); service != 0; service = ioIteratorNext(iter) {
+			found = true
+			devInfos, err := describeDevice(service, match, hid)
+			ioObjectRelease(service)
+			if err != nil {
+				continue
+			}
+			infos = append(infos, devInfos...)
+		}
+		ioObjectRelease(iter)
+
+		if found {
+			break
+		}
+	}
+	return infos, nil
+}
+
+// iokitSpeed converts the "Device Speed" registry property, counting from low
+// speed as zero, into a speed.
+func iokitSpeed(speed int64, ok bool) Speed {
+	if !ok || speed < 0 || speed > int64(SpeedSuperPlus-SpeedLow) {
+		return SpeedUnknown
+	}
+	return SpeedLow + Speed(speed)
+}
+
+// describeDevice converts the interfaces of an IOKit device service accepted
+// by the match predicate into device infos. The infos only carry the registry
+// id of the device, so nothing needs to be retained.
+func describeDevice(service uint32, match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	class, _ := registryNumber(service, "bDeviceClass")
+
+	// Skip HID devices, they are handled directly by OS libraries
+	if !hid && Class(class) == ClassHID {
+		return nil, nil
+	}
+	var id uint64
+	if err := fromIOReturn(uintptr(uint32(ioRegistryEntryGetRegistryEntryID(service, &id)))); err != nil {
+		return nil, err
+	}
+	vid, _ := registryNumber(service, "idVendor")
+	pid, _ := registryNumber(service, "idProduct")
+	release, _ := registryNumber(service, "bcdDevice")
+	version, _ := registryNumber(service, "bcdUSB")
+	imanufacturer, _ := registryNumber(service, "iManufacturer")
+	iproduct, _ := registryNumber(service, "iProduct")
+	iserial, _ := registryNumber(service, "iSerialNumber")
+	subclass, _ := registryNumber(service, "bDeviceSubClass")
+	protocol, _ := registryNumber(service, "bDeviceProtocol")
+	configs, _ := registryNumber(service, "bNumConfigurations")
+	location, _ := registryNumber(service, "locationID")
+	speed, hasSpeed := registryNumber(service, "Device Speed")
+	syspath := registryPath(service)
+
+	// The location id holds the bus in the top byte, followed by a nibble per
+	// hub port on the path to the device
+	bus, port := uint8(location>>24), uint8(0)
+	var chain []uint8
+	for shift := 20; shift >= 0; shift -= 4 {
+		if nibble := uint8(location>>shift) & 0xf; nibble != 0 {
+			port = nibble
+			chain = append(chain, nibble)
+		}
+	}
+	dev, err := createInterface(service, deviceUserClientType, deviceInterfaceIID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access device %#x: %w", id, err)
+	}
+	defer call(dev, methodRelease)
+
+	base := DeviceInfo{
+		Path:         DevicePath{Bus: bus, Ports: chain, Interface: -1}.String(),
+		SysPath:      syspath,
+		VendorID:     uint16(vid),
+		ProductID:    uint16(pid),
+		Release:      uint16(release),
+		USBVersion:   uint16(version),
+		Manufacturer: registryString(service, "USB Vendor Name"),
+		Product:      registryString(service, "USB Product Name"),
+		Serial:       registryString(service, "USB Serial Number"),
+		Class:        uint8(class),
+		SubClass:     uint8(subclass),
+		Protocol:     uint8(protocol),
+		Bus:          bus,
+		Port:         port,
+		Speed:        iokitSpeed(speed, hasSpeed),
+
+		ManufacturerIndex: uint8(imanufacturer),
+		ProductIndex:      uint8(iproduct),
+		SerialIndex:       uint8(iserial),
+
+		libusbDevice: id,
+		libusbPort:   &port,
+	}
+	var infos []DeviceInfo
+	for cfgnum := 0; cfgnum < int(configs); cfgnum++ {
+		config, err := configDescriptor(dev, cfgnum)
+		if err != nil {
+			return infos, fmt.Errorf("failed to get device %#x config %d: %w", id, cfgnum, err)
+		}
+		ifaces, err := describeConfig(base, config, hid)
+		if err != nil {
+			return infos, fmt.Errorf("failed to parse device %#x config %d: %w", id, cfgnum, err)
+		}
+		for _, info := range ifaces {
+			if match(info) {
+				infos = append(infos, info)
+			}
+		}
+	}
+	return infos, nil
+}
+
+// configDescriptor copies out a full configuration descriptor hierarchy cached
+// by the device interface.
+func configDescriptor(dev iokitObject, index int) ([]byte, error) {
+	var desc *[1 << 16]byte
+	if err := fromIOReturn(call(dev, deviceGetConfigurationDesc, uintptr(index), uintptr(unsafe.Pointer(&desc)))); err != nil {
+		return nil, err
+	}
+	if desc == nil {
+		return nil, fmt.Errorf("missing descriptor")
+	}
+	return append([]byte{}, desc[:binary.LittleEndian.Uint16(desc[2:])]...), nil
+}
+
+// iokitDevice is a USB device interface opened through IOKit.
+type iokitDevice struct {
+	DeviceInfo // The device info that was used to open the device
+	lifetime   // Signals the device being closed or disconnected
+
+	dev     iokitObject         // Device interface, nil when closed
+	opened  bool                // Whether the device could be opened for exclusive access
+	iface   iokitObject         // Opened primary interface
+	pipes   map[uint8]uint8     // Endpoint addresses of the primary interface mapped to pipe refs
+	claimed map[int]iokitObject // Additionally claimed interfaces
+	alts    map[int]int         // Alternate settings activated on the interfaces, restored after resets
+	retry   *RetryPolicy        // Retry policy of Read and Write, nil for none
+	metrics Metrics             // Metrics of Read and Write, nil for none
+	try     tryEmulation        // Transfers of TryRead and TryWrite
+
+	lock        sync.RWMutex // Guards the interfaces and pipe map, held shared by transfers
+	readLock    sync.Mutex   // Serializes transfers on the IN endpoint
+	writeLock   sync.Mutex   // Serializes transfers on the OUT endpoint
+	controlLock sync.Mutex   // Serializes control requests
+
+	writeTimeout   int
+	readTimeout    int
+	controlTimeout int
+}
+
+// listDevices lists the devices by collapsing their enumerated interfaces, as
+// the interfaces are what the backend discovers.
+func listDevices(match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	return listByInterface(match, hid)
+}
+
+// describeInterfaces enumerates the interfaces of a listed device.
+func describeInterfaces(info DeviceInfo, hid bool) ([]DeviceInfo, error) {
+	return interfacesOf(info, hid)
+}
+
+// configure ignores the options, which only apply to libusb.
+func configure(opts contextOptions) error {
+	return nil
+}
+
+// initContext has nothing to initialize, IOKit is reached without a context.
+func initContext() error {
+	return nil
+}
+
+// backendVersion reports IOKit as the backend, libusb isn't involved.
+func backendVersion() (string, string) {
+	return "iokit", ""
+}
+
+// exit has no global state to release, devices are closed individually.
+func exit() error {
+	return nil
+}
+
+// open connects to a previously enumerated device and claims the interface.
+func open(info DeviceInfo, opts *openOptions) (*iokitDevice, error) {
+	if err := initIOKit(); err != nil {
+		return nil, err
+	}
+	id, ok := info.libusbDevice.(uint64)
+	if !ok {
+		return nil, fmt.Errorf("failed to open device: %w", ErrNoDevice)
+	}
+	service := ioServiceGetMatchingService(0, ioRegistryEntryIDMatching(id))
+	if service == 0 {
+		return nil, fmt.Errorf("failed to open device: %w", ioReturnNoDevice)
+	}
+	defer ioObjectRelease(service)
+
+	obj, err := createInterface(service, deviceUserClientType, deviceInterfaceIID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open device: %w", err)
+	}
+	dev := &iokitDevice{DeviceInfo: info, dev: obj, controlTimeout: timeoutMillis(defaultControlTimeout)}
+
+	// Opening the device is only needed to configure it, a kernel driver holding
+	// it doesn't prevent claiming interfaces
+	dev.opened = fromIOReturn(call(obj, deviceOpenSeize)) == nil
+	if dev.opened {
+		var config uint8
+		if fromIOReturn(call(obj, deviceGetConfiguration, uintptr(unsafe.Pointer(&config)))) == nil && config == 0 {
+			if desc, err := configDescriptor(obj, 0); err == nil {
+				call(obj, deviceSetConfiguration, uintptr(desc[5]))
+			}
+		}
+	}
+	if dev.iface, err = dev.openInterface(info.Interface); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("failed to claim interface %d: %w", info.Interface, err)
+	}
+	if info.InterfaceAlternate != 0 {
+		if err := fromIOReturn(call(dev.iface, interfaceSetAlternate, uintptr(info.InterfaceAlternate))); err != nil {
+			dev.Close()
+			return nil, fmt.Errorf("failed to select alternate setting: %w", err)
+		}
+		dev.alts = map[int]int{info.Interface: info.InterfaceAlternate}
+	}
+	dev.mapPipes()
+
+	// Release the interface and the device once a call finds it gone
+	dev.OnDisconnect(func() { dev.Close() })
+	return dev, nil
+}
+
+// openInterface finds an interface of the device by number and opens it for
+// exclusive access.
+func (dev *iokitDevice) openInterface(number int) (iokitObject, error) {
+	req := [4]uint16{findInterfaceDontCare, findInterfaceDontCare, findInterfaceDontCare, findInterfaceDontCare}
+
+	var iter uint32
+	if err := fromIOReturn(call(dev.dev, deviceCreateInterfaceIter, uintptr(unsafe.Pointer(&req)), uintptr(unsafe.Pointer(&iter)))); err != nil {
+		return nil, err
+	}
+	defer ioObjectRelease(iter)
+
+	for service := ioIteratorNext(iter); service != 0; service = ioIteratorNext(iter) {
+		iface, err := createInterface(service, interfaceUserClientType, interfaceIID)
+		ioObjectRelease(service)
+		if err != nil {
+			continue
+		}
+		var num uint8
+		if fromIOReturn(call(iface, interfaceGetInterfaceNumber, uintptr(unsafe.Pointer(&num)))) != nil || int(num) != number {
+			call(iface, methodRelease)
+			continue
+		}
+		if err := fromIOReturn(call(iface, interfaceOpen)); err != nil {
+			call(iface, methodRelease)
+			return nil, err
+		}
+		return iface, nil
+	}
+	return nil, fmt.Errorf("interface not found")
+}
+
+// mapPipes rebuilds the endpoint address to pipe ref mapping of the primary
+// interface, which changes with the alternate setting.
+func (dev *iokitDevice) mapPipes() {
+	dev.pipes = make(map[uint8]uint8)
+
+	var count uint8
+	if fromIOReturn(call(dev.iface, interfaceGetNumEndpoints, uintptr(unsafe.Pointer(&count)))) != nil {
+		return
+	}
+	for pipe := uint8(1); pipe <= count; pipe++ {
+		var (
+			direction, number, kind, interval uint8
+			size                              uint16
+		)
+		if fromIOReturn(call(dev.iface, interfaceGetPipeProperties, uintptr(pipe), uintptr(unsafe.Pointer(&direction)), uintptr(unsafe.Pointer(&number)),
+			uintptr(unsafe.Pointer(&kind)), uintptr(unsafe.Pointer(&size)), uintptr(unsafe.Pointer(&interval)))) != nil {
+			continue
+		}
+		dev.pipes[number|direction<<7] = pipe
+	}
+}
+
+// openPath is unsupported, there are no usbfs nodes.
+func openPath(path string, opts *openOptions) (Device, DeviceInfo, error) {
+	return nil, DeviceInfo{}, fmt.Errorf("failed to open device: %w", ErrNotSupported)
+}
+
+// openParentHub is unsupported, IOKit keeps hubs to its own driver.
+func openParentHub(info DeviceInfo) (*parentHub, error) {
+	return nil, fmt.Errorf("failed to open parent hub: %w", ErrNotSupported)
+}
+
+// topology is unsupported, IOKit keeps hubs to its own driver.
+func topology() ([]*TopologyNode, error) {
+	return nil, fmt.Errorf("failed to list topology: %w", ErrNotSupported)
+}
+
+// openFD is unsupported, macOS has no usbfs file descriptors.
+func openFD(fd int) (*iokitDevice, error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+// hasCapability reports false, the IOKit backend has none of the optional capabilities, neither hotplug
+// notifications nor access to HID devices, which stay with their drivers.
+func hasCapability(capability Capability) bool {
+	return false
+}
+
+// onHotplug is unsupported by the IOKit backend.
+func onHotplug(handler HotplugHandler) (func(), error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+// Close releases the claimed interfaces and the device.
+func (dev *iokitDevice) Close() error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.dev == nil {
+		return nil
+	}
+	for iface, obj := range dev.claimed {
+		call(obj, interfaceClose)
+		call(obj, methodRelease)
+		delete(dev.claimed, iface)
+	}
+	if dev.iface != nil {
+		call(dev.iface, interfaceClose)
+		call(dev.iface, methodRelease)
+		dev.iface = nil
+	}
+	if dev.opened {
+		call(dev.dev, deviceClose)
+	}
+	call(dev.dev, methodRelease)
+	dev.dev = nil
+	dev.end(ErrDeviceClosed)
+
+	return nil
+}
+
+// SetWriteTimeout sets the timeout of writes in milliseconds.
+//
+// Deprecated: use SetTimeouts.
+func (dev *iokitDevice) SetWriteTimeout(timeout int) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.writeTimeout = timeout
+}
+
+// SetReadTimeout sets the timeout of reads in milliseconds.
+//
+// Deprecated: use SetTimeouts.
+func (dev *iokitDevice) SetReadTimeout(timeout int) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.readTimeout = timeout
+}
+
+// SetTimeouts sets the timeouts of Read and Write. IOKit only times out bulk
+// transfers, interrupt transfers always wait.
+func (dev *iokitDevice) SetTimeouts(read, write time.Duration) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.readTimeout, dev.writeTimeout = timeoutMillis(read), timeoutMillis(write)
+}
+
+// SetControlTimeout sets the timeout of control requests, zero for none. It
+// defaults to 5 seconds.
+func (dev *iokitDevice) SetControlTimeout(timeout time.Duration) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.controlTimeout = timeoutMillis(timeout)
+}
+
+// devRequestTO mirrors IOUSBDevRequestTO, with the fields in host byte order.
+type devRequestTO struct {
+	RequestType       uint8
+	Request           uint8
+	Value             uint16
+	Index             uint16
+	Length            uint16
+	Data              unsafe.Pointer
+	LenDone           uint32
+	NoDataTimeout     uint32
+	CompletionTimeout uint32
+}
+
+// Control sends a control request to the device. The direction of the data
+// stage is determined by the ControlIn bit of rType.
+func (dev *iokitDevice) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+	dev.controlLock.Lock()
+	defer dev.controlLock.Unlock()
+
+	if dev.dev == nil {
+		return 0, dev.closedErr()
+	}
+	if len(data) > math.MaxUint16 {
+		return 0, fmt.Errorf("failed to send control request: %w", ErrInvalidParam)
+	}
+	start := time.Now()
+	n, err := dev.control(rType, request, val, idx, data)
+	if err != nil {
+		n, err = 0, dev.check(fmt.Errorf("failed to send control request: %w", err))
+	}
+	traceControl(rType, request, val, idx, start, data, n, err)
+	return n, err
+}
+
+// control is the lock-free variant of Control. Requests go through the device
+// if it could be opened, otherwise through the claimed interface.
+func (dev *iokitDevice) control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	req := devRequestTO{
+		RequestType:       rType,
+		Request:           request,
+		Value:             val,
+		Index:             idx,
+		Length:            uint16(len(data)),
+		Data:              unsafe.Pointer(bufferPtr(data)),
+		NoDataTimeout:     uint32(dev.controlTimeout),
+		CompletionTimeout: uint32(dev.controlTimeout),
+	}
+	var r uintptr
+	if dev.opened {
+		r = call(dev.dev, deviceRequestTO, uintptr(unsafe.Pointer(&req)))
+	} else {
+		r = call(dev.iface, interfaceControlRequestTO, 0, uintptr(unsafe.Pointer(&req)))
+	}
+	runtime.KeepAlive(data)
+
+	if err := fromIOReturn(r); err != nil {
+		return 0, err
+	}
+	return int(req.LenDone), nil
+}
+
+// Write sends a binary blob to an USB device. Writes only exclude each other,
+// so they may run concurrently with reads and control requests.
+func (dev *iokitDevice) Write(b []byte) (int, error) {
+	return dev.write(net.Buffers{b}, nil)
+}
+
+// WriteV sends the buffers to an USB device as if they were concatenated,
+// transferring whole packets straight from the buffers.
+func (dev *iokitDevice) WriteV(bufs net.Buffers) (int, error) {
+	return dev.write(bufs, nil)
+}
+
+// WriteWithTimeout sends a binary blob to an USB device, with a timeout for
+// this call only.
+func (dev *iokitDevice) WriteWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	millis := timeoutMillis(timeout)
+	return dev.write(net.Buffers{b}, &millis)
+}
+
+// write sends the buffers with the given timeout in milliseconds, or the one of
+// the device if nil.
+func (dev *iokitDevice) write(bufs net.Buffers, override *int) (int, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+	dev.writeLock.Lock()
+	defer dev.writeLock.Unlock()
+
+	if dev.dev == nil {
+		return 0, dev.closedErr()
+	}
+	timeout := dev.writeTimeout
+	if override != nil {
+		timeout = *override
+	}
+	pipe, ok := dev.pipes[*dev.libusbWriter]
+	if !ok {
+		return 0, fmt.Errorf("failed to write to device: endpoint %#02x not found", *dev.libusbWriter)
+	}
+	start := time.Now()
+	n, err := writeVectored(bufs, dev.writePacket(), func(b []byte) (int, error) {
+		// Lengths are described as 32 bit integers, split what can't be described
+		return splitTransfer(b, maxTransferSize(math.MaxUint32), func(chunk []byte) (int, error) {
+			return dev.retry.run(transientError, retryObserver(dev.metrics, EndpointDirectionOut), func() (int, error) {
+				// Timeouts are only supported on bulk pipes
+				var r uintptr
+				if timeout == 0 || TransferType(*dev.writerTransferType) != TransferTypeBulk {
+					r = call(dev.iface, interfaceWritePipe, uintptr(pipe), uintptr(unsafe.Pointer(bufferPtr(chunk))), uintptr(len(chunk)))
+				} else {
+					r = call(dev.iface, interfaceWritePipeTO, uintptr(pipe), uintptr(unsafe.Pointer(bufferPtr(chunk))), uintptr(len(chunk)), uintptr(timeout), uintptr(timeout))
+				}
+				runtime.KeepAlive(chunk)
+
+				if err := dev.recoverStall(pipe, *dev.libusbWriter, fromIOReturn(r)); err != nil {
+					return 0, err
+				}
+				return len(chunk), nil
+			})
+		})
+	})
+	if err != nil {
+		err = dev.check(newTransferError(*dev.libusbWriter, n, err))
+		logger().Debug("usb: write failed", "endpoint", *dev.libusbWriter, "transferred", n, "err", err)
+	}
+	observeTransfer(dev.metrics, EndpointDirectionOut, start, n, err)
+	traceTransfer(*dev.libusbWriter, start, bufs, n, err)
+	return n, err
+}
+
+// Read retrieves a binary blob from an USB device. Reads only exclude each other,
+// so they may run concurrently with writes and control requests.
+func (dev *iokitDevice) Read(b []byte) (int, error) {
+	return dev.read(b, nil)
+}
+
+// ReadWithTimeout retrieves a binary blob from an USB device, with a timeout
+// for this call only.
+func (dev *iokitDevice) ReadWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	millis := timeoutMillis(timeout)
+	return dev.read(b, &millis)
+}
+
+// read retrieves a binary blob with the given timeout in milliseconds, or the
+// one of the device if nil.
+func (dev *iokitDevice) read(b []byte, override *int) (int, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+	dev.readLock.Lock()
+	defer dev.readLock.Unlock()
+
+	if dev.dev == nil {
+		return 0, dev.closedErr()
+	}
+	timeout := dev.readTimeout
+	if override != nil {
+		timeout = *override
+	}
+	pipe, ok := dev.pipes[*dev.libusbReader]
+	if !ok {
+		return 0, fmt.Errorf("failed to read from device: endpoint %#02x not found", *dev.libusbReader)
+	}
+	start := time.Now()
+	// Lengths are described as 32 bit integers, split what can't be described
+	n, err := splitTransfer(b, maxTransferSize(math.MaxUint32), func(chunk []byte) (int, error) {
+		return dev.retry.run(transientError, retryObserver(dev.metrics, EndpointDirectionIn), func() (int, error) {
+			// Timeouts are only supported on bulk pipes
+			size := uint32(len(chunk))
+			var r uintptr
+			if timeout == 0 || TransferType(*dev.readerTransferType) != TransferTypeBulk {
+				r = call(dev.iface, interfaceReadPipe, uintptr(pipe), uintptr(unsafe.Pointer(bufferPtr(chunk))), uintptr(unsafe.Pointer(&size)))
+			} else {
+				r = call(dev.iface, interfaceReadPipeTO, uintptr(pipe), uintptr(unsafe.Pointer(bufferPtr(chunk))), uintptr(unsafe.Pointer(&size)), uintptr(timeout), uintptr(timeout))
+			}
+			runtime.KeepAlive(chunk)
+
+			if err := dev.recoverStall(pipe, *dev.libusbReader, fromIOReturn(r)); err != nil {
+				return 0, err
+			}
+			return int(size), nil
+		})
+	})
+	if err != nil {
+		err = dev.check(newTransferError(*dev.libusbReader, n, err))
+		logger().Debug("usb: read failed", "endpoint", *dev.libusbReader, "transferred", n, "err", err)
+	}
+	observeTransfer(dev.metrics, EndpointDirectionIn, start, n, err)
+	traceTransfer(*dev.libusbReader, start, net.Buffers{b}, n, err)
+	return n, err
+}
+
+// TryRead collects the data of a read queued by an earlier call without
+// blocking, queuing one if there's none in flight. The backend has no
+// asynchronous transfers, so the read runs on a goroutine.
+func (dev *iokitDevice) TryRead(b []byte) (int, error) {
+	return dev.try.tryRead(b, dev.Read)
+}
+
+// TryWrite queues a write of b without blocking, unless the previous one is in
+// flight. The backend has no asynchronous transfers, so the write runs on a
+// goroutine.
+func (dev *iokitDevice) TryWrite(b []byte) (int, error) {
+	return dev.try.tryWrite(b, dev.Write)
+}
+
+// AcquireBuffer returns a transfer buffer of the given size. There's no device
+// memory to allocate from, so it's a plain Go buffer.
+func (dev *iokitDevice) AcquireBuffer(size int) (*Buffer, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+
+	if dev.dev == nil {
+		return nil, dev.closedErr()
+	}
+	return NewBuffer(size), nil
+}
+
+// SetRetryPolicy configures retries of Read and Write transfers failing with
+// transient errors. A nil policy disables retries.
+func (dev *iokitDevice) SetRetryPolicy(policy *RetryPolicy) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.retry = policy
+}
+
+// SetMetrics reports the Read and Write transfers of the device to m. A nil m
+// disables the reporting.
+func (dev *iokitDevice) SetMetrics(m Metrics) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.metrics = m
+}
+
+// recoverStall clears both ends of a pipe which failed a transfer with a stall,
+// so a retry has a chance to succeed. The transfer error is passed through.
+func (dev *iokitDevice) recoverStall(pipe uint8, endpoint uint8, err error) error {
+	if err == ioUSBPipeStalled && dev.retry != nil {
+		call(dev.iface, interfaceClearPipeStall, uintptr(pipe))
+
+		dev.controlLock.Lock()
+		dev.control(ControlOut|ControlEndpoint, requestClearFeature, featureEndpointHalt, uint16(endpoint), nil)
+		dev.controlLock.Unlock()
+	}
+	return err
+}
+
+// transientError reports whether a transfer error is worth retrying under the
+// default retry policy.
+func transientError(err error) bool {
+	return errors.Is(err, ErrTimeout) || errors.Is(err, ErrPipe) || errors.Is(err, ErrInterrupted)
+}
+
+// AttachKernelDriver is a no-op, kernel drivers can't be detached on macOS, so
+// there's nothing to give back.
+func (dev *iokitDevice) AttachKernelDriver() error {
+	return nil
+}
+
+// SetReattachOnClose is a no-op, kernel drivers are never detached on macOS.
+func (dev *iokitDevice) SetReattachOnClose(reattach bool) {}
+
+// ClaimInterface claims an additional interface of a composite device.
+func (dev *iokitDevice) ClaimInterface(iface int) error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.dev == nil {
+		return dev.closedErr()
+	}
+	if iface == dev.Interface {
+		return nil
+	}
+	if _, ok := dev.claimed[iface]; ok {
+		return nil
+	}
+	obj, err := dev.openInterface(iface)
+	if err != nil {
+		return fmt.Errorf("failed to claim interface %d: %w", iface, err)
+	}
+	if dev.claimed == nil {
+		dev.claimed = make(map[int]iokitObject)
+	}
+	dev.claimed[iface] = obj
+	return nil
+}
+
+// ReleaseInterface releases an interface claimed through ClaimInterface.
+func (dev *iokitDevice) ReleaseInterface(iface int) error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.dev == nil {
+		return dev.closedErr()
+	}
+	obj, ok := dev.claimed[iface]
+	if !ok {
+		return fmt.Errorf("interface %d not claimed", iface)
+	}
+	delete(dev.claimed, iface)
+	delete(dev.alts, iface)
+
+	err := fromIOReturn(call(obj, interfaceClose))
+	call(obj, methodRelease)
+	return err
+}
+
+// SetAltSetting activates an alternate setting of a claimed interface.
+func (dev *iokitDevice) SetAltSetting(iface int, alt int) error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.dev == nil {
+		return dev.closedErr()
+	}
+	obj := dev.iface
+	if iface != dev.Interface {
+		obj = dev.claimed[iface]
+	}
+	if obj == nil {
+		return fmt.Errorf("interface %d not claimed", iface)
+	}
+	if err := fromIOReturn(call(obj, interfaceSetAlternate, uintptr(alt))); err != nil {
+		return fmt.Errorf("failed to set alternate setting %d of interface %d: %w", alt, iface, err)
+	}
+	if iface == dev.Interface {
+		dev.mapPipes()
+	}
+	if dev.alts == nil {
+		dev.alts = make(map[int]int)
+	}
+	dev.alts[iface] = alt
+	return nil
+}
+
+// Reset performs a USB port reset of the device, then restores the active
+// configuration, interface claims and alternate settings. Resetting requires
+// exclusive access to the device.
+func (dev *iokitDevice) Reset() error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.dev == nil {
+		return dev.closedErr()
+	}
+	if !dev.opened {
+		return fmt.Errorf("failed to reset device: %w", ErrAccess)
+	}
+	var config uint8
+	if err := fromIOReturn(call(dev.dev, deviceGetConfiguration, uintptr(unsafe.Pointer(&config)))); err != nil {
+		return dev.check(fmt.Errorf("failed to reset device: %w", err))
+	}
+	if err := fromIOReturn(call(dev.dev, deviceResetDevice)); err != nil {
+		return dev.check(fmt.Errorf("failed to reset device: %w", err))
+	}
+	// The reset leaves the device unconfigured, along with the interfaces
+	return dev.reconfigure(config)
+}
+
+// SetConfiguration activates a configuration of the device, then restores the
+// interface claims and alternate settings. Configuring requires exclusive
+// access to the device.
+func (dev *iokitDevice) SetConfiguration(config int) error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.dev == nil {
+		return dev.closedErr()
+	}
+	if !dev.opened {
+		return fmt.Errorf("failed to set configuration %d: %w", config, ErrAccess)
+	}
+	return dev.reconfigure(uint8(config))
+}
+
+// Status retrieves the power and remote wakeup status of the device.
+func (dev *iokitDevice) Status() (DeviceStatus, error) {
+	return readDeviceStatus(dev.Control)
+}
+
+// InterfaceStatus retrieves the function remote wake status of an interface.
+func (dev *iokitDevice) InterfaceStatus(iface int) (InterfaceStatus, error) {
+	return readInterfaceStatus(dev.Control, iface)
+}
+
+// EndpointStatus retrieves the halt status of an endpoint.
+func (dev *iokitDevice) EndpointStatus(endpoint uint8) (EndpointStatus, error) {
+	return readEndpointStatus(dev.Control, endpoint)
+}
+
+// SetRemoteWakeup arms or disarms the device to wake the host from suspend.
+func (dev *iokitDevice) SetRemoteWakeup(enable bool) error {
+	return setRemoteWakeup(dev.Control, enable)
+}
+
+// AllocBulkStreams is unsupported, IOKit streams need an interface version not bound here.
+func (dev *iokitDevice) AllocBulkStreams(count int, endpoints ...uint8) (int, error) {
+	return 0, fmt.Errorf("failed to allocate bulk streams: %w", ErrNotSupported)
+}
+
+// FreeBulkStreams is unsupported, IOKit streams need an interface version not bound here.
+func (dev *iokitDevice) FreeBulkStreams(endpoints ...uint8) error {
+	return fmt.Errorf("failed to free bulk streams: %w", ErrNotSupported)
+}
+
+// OpenBulkStream is unsupported, IOKit streams need an interface version not bound here.
+func (dev *iokitDevice) OpenBulkStream(endpoint uint8, streamID uint32) (*BulkStream, error) {
+	return nil, fmt.Errorf("failed to open bulk stream: %w", ErrNotSupported)
+}
+
+// SetAutoSuspend is unsupported, IOKit leaves idle suspend to the drivers of the device.
+func (dev *iokitDevice) SetAutoSuspend(enable bool, delay time.Duration) error {
+	return fmt.Errorf("failed to set autosuspend: %w", ErrNotSupported)
+}
+
+// Configuration returns the value of the active configuration, as cached by
+// IOKit.
+func (dev *iokitDevice) Configuration() (int, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+
+	if dev.dev == nil {
+		return 0, dev.closedErr()
+	}
+	var config uint8
+	if err := fromIOReturn(call(dev.dev, deviceGetConfiguration, uintptr(unsafe.Pointer(&config)))); err != nil {
+		return 0, dev.check(fmt.Errorf("failed to get configuration: %w", err))
+	}
+	if config == 0 {
+		return 0, ErrNotConfigured
+	}
+	return int(config), nil
+}
+
+// reconfigure closes the interfaces, activates a configuration and reopens the
+// interfaces with their alternate settings. IOKit invalidates the interface
+// objects on configuration changes, so they are reopened even if it fails.
+func (dev *iokitDevice) reconfigure(config uint8) error {
+	claimed := make([]int, 0, len(dev.claimed))
+	for iface, obj := range dev.claimed {
+		call(obj, interfaceClose)
+		call(obj, methodRelease)
+		claimed = append(claimed, iface)
+	}
+	sort.Ints(claimed)
+	dev.claimed = nil
+
+	if dev.iface != nil {
+		call(dev.iface, interfaceClose)
+		call(dev.iface, methodRelease)
+		dev.iface, dev.pipes = nil, nil
+	}
+	if err := fromIOReturn(call(dev.dev, deviceSetConfiguration, uintptr(config))); err != nil {
+		// Reopen the interfaces of the configuration left active
+		dev.restore(claimed)
+		return dev.check(fmt.Errorf("failed to set configuration %d: %w", config, err))
+	}
+	return dev.restore(claimed)
+}
+
+// restore reopens the primary and the given additional interfaces, reapplying
+// their alternate settings.
+func (dev *iokitDevice) restore(claimed []int) error {
+	for _, iface := range append([]int{dev.Interface}, claimed...) {
+		obj, err := dev.openInterface(iface)
+		if err != nil {
+			return &RestoreError{Interface: iface, Err: err}
+		}
+		if iface == dev.Interface {
+			dev.iface = obj
+		} else {
+			if dev.claimed == nil {
+				dev.claimed = make(map[int]iokitObject)
+			}
+			dev.claimed[iface] = obj
+		}
+		if alt := dev.alts[iface]; alt != 0 {
+			if err := fromIOReturn(call(obj, interfaceSetAlternate, uintptr(alt))); err != nil {
+				return &RestoreError{Interface: iface, Alternate: alt, Err: err}
+			}
+		}
+		if iface == dev.Interface {
+			dev.mapPipes()
+		}
+	}
+	return nil
+}
+
+// ClearHalt clears the halt/stall condition of an endpoint of the primary
+// interface.
+func (dev *iokitDevice) ClearHalt(endpoint uint8) error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.dev == nil {
+		return dev.closedErr()
+	}
+	pipe, ok := dev.pipes[endpoint]
+	if !ok {
+		return fmt.Errorf("failed to clear halt on endpoint %#02x: not found", endpoint)
+	}
+	// ClearPipeStall only resets the host side, the device needs to be told too
+	if err := fromIOReturn(call(dev.iface, interfaceClearPipeStall, uintptr(pipe))); err != nil {
+		return fmt.Errorf("failed to clear halt on endpoint %#02x: %w", endpoint, err)
+	}
+	if _, err := dev.control(ControlOut|ControlEndpoint, requestClearFeature, featureEndpointHalt, uint16(endpoint), nil); err != nil {
+		return fmt.Errorf("failed to clear halt on endpoint %#02x: %w", endpoint, err)
+	}
+	return nil
+}
+
+// BOS retrieves the Binary Object Store descriptor of the device, exposing its
+// USB 2.0 extension, SuperSpeed and container ID capabilities.
+func (dev *iokitDevice) BOS() (*BOSDescriptor, error) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.dev == nil {
+		return nil, dev.closedErr()
+	}
+	return readBOS(dev.getDescriptor)
+}
+
+// RawDescriptors retrieves the raw device and configuration descriptors from
+// the device via GET_DESCRIPTOR requests, allowing applications to parse class
+// specific descriptors not modelled by this package.
+func (dev *iokitDevice) RawDescriptors() (*RawDescriptors, error) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.dev == nil {
+		return nil, dev.closedErr()
+	}
+	return readRawDescriptors(dev.getDescriptor)
+}
+
+// getDescriptor issues a standard GET_DESCRIPTOR request on the device.
+func (dev *iokitDevice) getDescriptor(kind uint8, index uint8, length int) ([]byte, error) {
+	buf := make([]byte, length)
+	n, err := dev.control(ControlIn|ControlDevice, requestGetDescriptor, uint16(kind)<<8|uint16(index), 0, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// bufferPtr returns the pointer of a transfer buffer, which is nil for zero
+// length transfers (e.g. zero length packets terminating a bulk transfer).
+func bufferPtr(b []byte) *byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return &b[0]
+}
@@ -0,0 +1,114 @@
+package zerousb
+
+import (
+	"errors"
+	"sync"
+)
+
+// IsoPacket is a single packet of an isochronous transfer. Isochronous packets
+// fail individually (e.g. a corrupted packet on a noisy bus) without failing
+// the transfer, so consumers can conceal the loss and carry on.
+type IsoPacket struct {
+	Data         []byte // Data received, ActualLength bytes
+	Status       error  // Failure of the packet, nil if received fine
+	ActualLength int    // Number of bytes received
+}
+
+// isoSource delivers the completed transfers of an isochronous stream in order.
+type isoSource interface {
+	// next blocks until the oldest queued transfer completes and returns its
+	// packets, whose data remains valid until done is called. Errors are only
+	// returned for transfers failing as a whole.
+	next() ([]IsoPacket, error)
+
+	// done requeues the transfer last returned by next.
+	done() error
+
+	// close cancels the queued transfers and releases their buffers.
+	close()
+}
+
+// isoReader is implemented by devices whose backend supports isochronous
+// transfers.
+type isoReader interface {
+	newIsoStream(endpoint uint8, packets int, packetSize int, count int) (isoSource, error)
+}
+
+// IsoStream continuously reads from an isochronous IN endpoint, keeping a
+// number of transfers queued so no service interval is missed. Unlike the
+// byte oriented ReadStream, it preserves the packet framing along with the
+// status of each packet.
+//
+// The interface of the endpoint must be claimed, with an alternate setting
+// reserving bandwidth for it activated. The stream must be closed before the
+// device itself. Once a transfer fails as a whole, the stream stops and keeps
+// returning the error.
+type IsoStream struct {
+	src     isoSource
+	started bool  // Whether the current transfer came from the source
+	err     error // Sticky error of a failed transfer
+	closed  bool
+	lock    sync.Mutex
+}
+
+// NewIsoStream starts a stream of count transfers on an isochronous IN
+// endpoint of the device, each made of the given number of packets of up to
+// packetSize bytes. Only the libusb backend supports isochronous transfers.
+func NewIsoStream(dev Device, endpoint uint8, packets int, packetSize int, count int) (*IsoStream, error) {
+	if packets <= 0 || packetSize <= 0 || count <= 0 {
+		return nil, errors.New("usb: iso stream packets, packet size and count must be positive")
+	}
+	if endpoint&endpointDirectionMask == 0 {
+		return nil, errors.New("usb: iso stream endpoint must be an IN endpoint")
+	}
+	reader, ok := dev.(isoReader)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	src, err := reader.newIsoStream(endpoint, packets, packetSize, count)
+	if err != nil {
+		return nil, err
+	}
+	return &IsoStream{src: src}, nil
+}
+
+// Next returns the packets of the oldest completed transfer, blocking until
+// one completes. The packets alias the buffers of the stream, remaining valid
+// until the next call.
+func (s *IsoStream) Next() ([]IsoPacket, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return nil, ErrStreamClosed
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.started {
+		s.started = false
+		if s.err = s.src.done(); s.err != nil {
+			return nil, s.err
+		}
+	}
+	packets, err := s.src.next()
+	if err != nil {
+		s.err = err
+		return nil, err
+	}
+	s.started = true
+	return packets, nil
+}
+
+// Close cancels the queued transfers and releases their buffers.
+func (s *IsoStream) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.src.close()
+	return nil
+}
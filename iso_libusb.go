@@ -0,0 +1,114 @@
+//go:build (freebsd && cgo) || (linux && cgo) || (openbsd && cgo) || (netbsd && cgo) || (darwin && !ios && cgo && !iokit) || (windows && cgo && !winusb)
+
+package zerousb
+
+/*
+	#include <libusb.h>
+
+	void fill_iso_transfer(struct libusb_transfer* transfer, libusb_device_handle* handle, unsigned char endpoint,
+		unsigned char* buffer, int packets, int packet_size, intptr_t id, unsigned int timeout);
+
+	// iso_packet returns the i-th packet descriptor of an isochronous transfer,
+	// working around cgo not being able to index flexible array members.
+	static struct libusb_iso_packet_descriptor* iso_packet(struct libusb_transfer* transfer, int i) {
+		return &transfer->iso_packet_desc[i];
+	}
+*/
+import "C"
+
+import "unsafe"
+
+// libusbIsoStream keeps a ring of isochronous transfers queued on an endpoint.
+type libusbIsoStream struct {
+	dev        *libusbDevice
+	endpoint   uint8
+	packetSize int
+	transfers  []*libusbTransfer // Ring in submission order
+	packets    []IsoPacket       // Packets of the last completed transfer, reused across transfers
+	head       int               // Oldest queued transfer
+}
+
+// newIsoStream queues count isochronous transfers of the given number of
+// packets on an IN endpoint.
+func (dev *libusbDevice) newIsoStream(endpoint uint8, packets int, packetSize int, count int) (isoSource, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+
+	if dev.handle == nil {
+		return nil, dev.closedErr()
+	}
+	s := &libusbIsoStream{
+		dev:        dev,
+		endpoint:   endpoint,
+		packetSize: packetSize,
+		packets:    make([]IsoPacket, packets),
+	}
+	if err := dev.startStream(s); err != nil {
+		return nil, err
+	}
+	for i := 0; i < count; i++ {
+		t, err := dev.allocTransfer(packets, packets*packetSize)
+		if err == nil {
+			C.fill_iso_transfer(t.xfer, dev.handle, C.uchar(endpoint), t.buf, C.int(packets), C.int(packetSize), C.intptr_t(t.id), C.uint(dev.readTimeout))
+			s.transfers = append(s.transfers, t)
+			err = t.submit()
+		}
+		if err != nil {
+			s.close()
+			return nil, newTransferError(endpoint, 0, err)
+		}
+	}
+	return s, nil
+}
+
+// next waits for the oldest queued transfer to complete, splitting it into its
+// packets. Packets are laid out at fixed offsets in the buffer, whatever their
+// actual length.
+func (s *libusbIsoStream) next() ([]IsoPacket, error) {
+	t := s.transfers[s.head]
+	<-t.done
+	t.inflight = false
+
+	// Packet statuses are only meaningful for completed transfers
+	if t.xfer.status != C.LIBUSB_TRANSFER_COMPLETED {
+		return nil, newTransferError(s.endpoint, 0, transferStatus(t.xfer.status))
+	}
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(t.buf)), t.size)
+	for i := range s.packets {
+		desc := C.iso_packet(t.xfer, C.int(i))
+		n := int(desc.actual_length)
+		s.packets[i] = IsoPacket{
+			Data:         buf[i*s.packetSize : i*s.packetSize+n],
+			Status:       transferStatus(desc.status),
+			ActualLength: n,
+		}
+	}
+	return s.packets, nil
+}
+
+// done requeues the oldest transfer, making the following one the oldest.
+func (s *libusbIsoStream) done() error {
+	if err := s.transfers[s.head].submit(); err != nil {
+		return newTransferError(s.endpoint, 0, err)
+	}
+	s.head = (s.head + 1) % len(s.transfers)
+	return nil
+}
+
+// cancel aborts the queued transfers, failing the pending next.
+func (s *libusbIsoStream) cancel() {
+	cancelAll(s.transfers)
+}
+
+// close cancels and reaps all queued transfers.
+func (s *libusbIsoStream) close() {
+	s.dev.stopStream(s)
+	for _, t := range s.transfers {
+		if t.inflight {
+			<-t.done
+			t.inflight = false
+		}
+		t.free()
+	}
+	s.dev.endStream(s)
+}
@@ -0,0 +1,88 @@
+package zerousb
+
+import (
+	"errors"
+	"testing"
+)
+
+// isoDevice is a device serving a fake isochronous source. Methods other than
+// the stream constructor are not implemented.
+type isoDevice struct {
+	Device
+	src *fakeIsoSource
+}
+
+func (dev *isoDevice) newIsoStream(endpoint uint8, packets int, packetSize int, count int) (isoSource, error) {
+	return dev.src, nil
+}
+
+// fakeIsoSource hands out the given transfers in order, then the given error.
+type fakeIsoSource struct {
+	transfers [][]IsoPacket
+	err       error
+	requeued  int
+	closed    bool
+}
+
+func (s *fakeIsoSource) next() ([]IsoPacket, error) {
+	if len(s.transfers) == 0 {
+		return nil, s.err
+	}
+	packets := s.transfers[0]
+	s.transfers = s.transfers[1:]
+	return packets, nil
+}
+
+func (s *fakeIsoSource) done() error { s.requeued++; return nil }
+func (s *fakeIsoSource) close()      { s.closed = true }
+
+// Tests that iso streams deliver the packets of each transfer with their own
+// statuses, requeue consumed transfers and stop at failed transfers.
+func TestIsoStream(t *testing.T) {
+	errTransfer := errors.New("transfer failed")
+	src := &fakeIsoSource{
+		transfers: [][]IsoPacket{
+			{{Data: []byte("ab"), ActualLength: 2}, {Status: ErrOverflow}},
+			{{Data: []byte("c"), ActualLength: 1}},
+		},
+		err: errTransfer,
+	}
+	dev := &isoDevice{src: src}
+
+	if _, err := NewIsoStream(dev, 0x01, 8, 192, 4); err == nil {
+		t.Fatalf("iso stream created on OUT endpoint")
+	}
+	if _, err := NewIsoStream(&chunkDevice{}, 0x81, 8, 192, 4); err != ErrNotSupported {
+		t.Fatalf("unsupported backend mismatch: have %v, want %v", err, ErrNotSupported)
+	}
+	stream, err := NewIsoStream(dev, 0x81, 8, 192, 4)
+	if err != nil {
+		t.Fatalf("failed to create iso stream: %v", err)
+	}
+	packets, err := stream.Next()
+	if err != nil {
+		t.Fatalf("failed to read first transfer: %v", err)
+	}
+	if len(packets) != 2 || string(packets[0].Data) != "ab" || packets[1].Status != ErrOverflow {
+		t.Fatalf("first transfer mismatch: have %+v", packets)
+	}
+	if packets, err = stream.Next(); err != nil || len(packets) != 1 || string(packets[0].Data) != "c" {
+		t.Fatalf("second transfer mismatch: have %+v, %v", packets, err)
+	}
+	if _, err := stream.Next(); err != errTransfer {
+		t.Fatalf("failure mismatch: have %v, want %v", err, errTransfer)
+	}
+	if _, err := stream.Next(); err != errTransfer {
+		t.Fatalf("sticky failure mismatch: have %v, want %v", err, errTransfer)
+	}
+	if src.requeued != 2 {
+		t.Errorf("requeue count mismatch: have %d, want 2", src.requeued)
+	}
+	stream.Close()
+	if !src.closed {
+		t.Errorf("source not closed")
+	}
+	if _, err := stream.Next(); err != ErrStreamClosed {
+		t.Errorf("closed stream mismatch: have %v, want %v", err, ErrStreamClosed)
+	}
+}
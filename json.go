@@ -0,0 +1,209 @@
+package zerousb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// MarshalText encodes the id as four hexadecimal digits, as String does.
+func (id ID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText decodes an id from hexadecimal digits, with or without a 0x
+// prefix.
+func (id *ID) UnmarshalText(text []byte) error {
+	v, err := parseHex16(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid id %q: %w", text, err)
+	}
+	*id = ID(v)
+	return nil
+}
+
+// MarshalText encodes the class by its name as String does, falling back to
+// its decimal value for classes without one.
+func (c Class) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText decodes a class from its name or decimal value.
+func (c *Class) UnmarshalText(text []byte) error {
+	for class, name := range classDescription {
+		if name == string(text) {
+			*c = class
+			return nil
+		}
+	}
+	v, err := strconv.ParseUint(string(text), 10, 8)
+	if err != nil {
+		return fmt.Errorf("invalid class %q", text)
+	}
+	*c = Class(v)
+	return nil
+}
+
+// MarshalText encodes the speed by its name as String does, falling back to
+// its decimal value for speeds without one (e.g. newer than the package).
+func (s Speed) MarshalText() ([]byte, error) {
+	if name, ok := deviceSpeedDescription[s]; ok {
+		return []byte(name), nil
+	}
+	return []byte(strconv.Itoa(int(s))), nil
+}
+
+// UnmarshalText decodes a speed from its name or decimal value.
+func (s *Speed) UnmarshalText(text []byte) error {
+	for speed, name := range deviceSpeedDescription {
+		if name == string(text) {
+			*s = speed
+			return nil
+		}
+	}
+	v, err := strconv.Atoi(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid speed %q", text)
+	}
+	*s = Speed(v)
+	return nil
+}
+
+// bcd is a binary-coded decimal version, encoded as its four digits.
+type bcd uint16
+
+// MarshalText encodes the version as four hexadecimal digits, e.g. 0200.
+func (v bcd) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%04x", uint16(v))), nil
+}
+
+// UnmarshalText decodes a version from its four digits.
+func (v *bcd) UnmarshalText(text []byte) error {
+	n, err := parseHex16(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid version %q: %w", text, err)
+	}
+	*v = bcd(n)
+	return nil
+}
+
+// parseHex16 parses a 16 bit hexadecimal number, with or without a 0x prefix.
+func parseHex16(s string) (uint16, error) {
+	if len(s) > 2 && (s[:2] == "0x" || s[:2] == "0X") {
+		s = s[2:]
+	}
+	v, err := strconv.ParseUint(s, 16, 16)
+	return uint16(v), err
+}
+
+// deviceInfoJSON is the JSON form of DeviceInfo: its exported fields with the
+// ids and versions in hexadecimal and the classes and speed by name.
+type deviceInfoJSON struct {
+	Path         string
+	SysPath      string
+	VendorID     ID
+	ProductID    ID
+	Release      bcd
+	USBVersion   bcd
+	Serial       string
+	Manufacturer string
+	Product      string
+	UsagePage    uint16
+	Usage        uint16
+	Class        Class
+	SubClass     uint8
+	Protocol     uint8
+	Bus          uint8
+	Port         uint8
+	Speed        Speed
+
+	ManufacturerIndex uint8
+	ProductIndex      uint8
+	SerialIndex       uint8
+
+	Interface          int
+	InterfaceNumber    int
+	InterfaceAlternate int
+	InterfaceClass     Class
+	InterfaceSubClass  uint8
+	InterfaceProtocol  uint8
+
+	Endpoints []EndpointInfo
+	Config    ConfigInfo
+}
+
+// MarshalJSON encodes the exported fields of the info, with the vendor and
+// product ids and the versions as hexadecimal strings and the classes and
+// speed by name. Backend state is left out, infos decoded from JSON are
+// opened through OpenSnapshot.
+func (info DeviceInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(deviceInfoJSON{
+		Path:               info.Path,
+		SysPath:            info.SysPath,
+		VendorID:           ID(info.VendorID),
+		ProductID:          ID(info.ProductID),
+		Release:            bcd(info.Release),
+		USBVersion:         bcd(info.USBVersion),
+		Serial:             info.Serial,
+		Manufacturer:       info.Manufacturer,
+		Product:            info.Product,
+		UsagePage:          info.UsagePage,
+		Usage:              info.Usage,
+		Class:              Class(info.Class),
+		SubClass:           info.SubClass,
+		Protocol:           info.Protocol,
+		Bus:                info.Bus,
+		Port:               info.Port,
+		Speed:              info.Speed,
+		ManufacturerIndex:  info.ManufacturerIndex,
+		ProductIndex:       info.ProductIndex,
+		SerialIndex:        info.SerialIndex,
+		Interface:          info.Interface,
+		InterfaceNumber:    info.InterfaceNumber,
+		InterfaceAlternate: info.InterfaceAlternate,
+		InterfaceClass:     Class(info.InterfaceClass),
+		InterfaceSubClass:  info.InterfaceSubClass,
+		InterfaceProtocol:  info.InterfaceProtocol,
+		Endpoints:          info.Endpoints,
+		Config:             info.Config,
+	})
+}
+
+// UnmarshalJSON decodes an info encoded by MarshalJSON.
+func (info *DeviceInfo) UnmarshalJSON(data []byte) error {
+	var v deviceInfoJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*info = DeviceInfo{
+		Path:               v.Path,
+		SysPath:            v.SysPath,
+		VendorID:           uint16(v.VendorID),
+		ProductID:          uint16(v.ProductID),
+		Release:            uint16(v.Release),
+		USBVersion:         uint16(v.USBVersion),
+		Serial:             v.Serial,
+		Manufacturer:       v.Manufacturer,
+		Product:            v.Product,
+		UsagePage:          v.UsagePage,
+		Usage:              v.Usage,
+		Class:              uint8(v.Class),
+		SubClass:           v.SubClass,
+		Protocol:           v.Protocol,
+		Bus:                v.Bus,
+		Port:               v.Port,
+		Speed:              v.Speed,
+		ManufacturerIndex:  v.ManufacturerIndex,
+		ProductIndex:       v.ProductIndex,
+		SerialIndex:        v.SerialIndex,
+		Interface:          v.Interface,
+		InterfaceNumber:    v.InterfaceNumber,
+		InterfaceAlternate: v.InterfaceAlternate,
+		InterfaceClass:     uint8(v.InterfaceClass),
+		InterfaceSubClass:  v.InterfaceSubClass,
+		InterfaceProtocol:  v.InterfaceProtocol,
+		Endpoints:          v.Endpoints,
+		Config:             v.Config,
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package zerousb
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// Tests that infos are encoded with hexadecimal ids and class names, and
+// decoded back into the same info.
+func TestDeviceInfoJSON(t *testing.T) {
+	info := DeviceInfo{
+		Path:              "1-2:0",
+		VendorID:          0x1209,
+		ProductID:         0x53c1,
+		Release:           0x0102,
+		USBVersion:        0x0210,
+		Class:             0xef,
+		Bus:               1,
+		Port:              2,
+		Speed:             SpeedHigh,
+		InterfaceNumber:   1,
+		InterfaceClass:    uint8(ClassVendorSpec),
+		InterfaceProtocol: 1,
+		Endpoints: []EndpointInfo{
+			{Address: 0x81, Attributes: uint8(TransferTypeBulk), MaxPacketSize: 1024, Companion: &EndpointCompanion{MaxBurst: 15}},
+		},
+		Config: ConfigInfo{Value: 1, RemoteWakeup: true, MaxPower: 500},
+	}
+	info.libusbPort = &info.Port // Backend state isn't encoded
+
+	blob, err := json.Marshal(info)
+	if err != nil {
+		t.Fatalf("failed to marshal info: %v", err)
+	}
+	for _, want := range []string{`"VendorID":"1209"`, `"ProductID":"53c1"`, `"USBVersion":"0210"`, `"Class":"miscellaneous"`, `"InterfaceClass":"vendor-specific"`, `"Speed":"high"`} {
+		if !bytes.Contains(blob, []byte(want)) {
+			t.Errorf("encoding %s missing %s", blob, want)
+		}
+	}
+	var decoded DeviceInfo
+	if err := json.Unmarshal(blob, &decoded); err != nil {
+		t.Fatalf("failed to unmarshal info: %v", err)
+	}
+	info.libusbPort = nil
+	if !reflect.DeepEqual(decoded, info) {
+		t.Errorf("decoded info mismatch: have %+v, want %+v", decoded, info)
+	}
+}
+
+// Tests that classes without a name and prefixed ids are decoded.
+func TestDeviceInfoJSONLenient(t *testing.T) {
+	var info DeviceInfo
+	if err := json.Unmarshal([]byte(`{"VendorID":"0x1d6B","ProductID":"2","Class":"66"}`), &info); err != nil {
+		t.Fatalf("failed to unmarshal info: %v", err)
+	}
+	if info.VendorID != 0x1d6b || info.ProductID != 2 || info.Class != 66 {
+		t.Errorf("decoded info mismatch: have %+v", info)
+	}
+	for _, blob := range []string{`{"VendorID":"12345"}`, `{"Class":"unknown"}`, `{"Release":"x"}`} {
+		if err := json.Unmarshal([]byte(blob), &info); err == nil {
+			t.Errorf("invalid info %s decoded", blob)
+		}
+	}
+}
+
+// Tests that speeds without a name, e.g. newer than the package, are encoded by
+// their value and decoded back.
+func TestDeviceInfoJSONUnknownSpeed(t *testing.T) {
+	info := DeviceInfo{Speed: Speed(42)}
+
+	blob, err := json.Marshal(info)
+	if err != nil {
+		t.Fatalf("failed to marshal info: %v", err)
+	}
+	if !bytes.Contains(blob, []byte(`"Speed":"42"`)) {
+		t.Errorf("encoding %s missing the speed value", blob)
+	}
+	var decoded DeviceInfo
+	if err := json.Unmarshal(blob, &decoded); err != nil {
+		t.Fatalf("failed to unmarshal info: %v", err)
+	}
+	if decoded.Speed != info.Speed {
+		t.Errorf("decoded speed mismatch: have %d, want %d", decoded.Speed, info.Speed)
+	}
+	if err := json.Unmarshal([]byte(`{"Speed":"warp"}`), &decoded); err == nil {
+		t.Errorf("invalid speed decoded")
+	}
+}
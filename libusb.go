@@ -1,330 +1,1019 @@
-// go:build (freebsd && cgo) || (linux && cgo) || (darwin && !ios && cgo) || (windows && cgo)
+//go:build (freebsd && cgo) || (linux && cgo) || (openbsd && cgo) || (netbsd && cgo) || (darwin && !ios && cgo && !iokit) || (windows && cgo && !winusb)
 
 package zerousb
 
 /*
-	#include "./libusb/libusb/libusb.h"
+	#include <libusb.h>
 	// ctx is a global libusb context to interact with devices through.
 	libusb_context* ctx;
+
+	// bos_capability returns the i-th device capability of a BOS descriptor,
+	// working around cgo not being able to index flexible array members.
+	static struct libusb_bos_dev_capability_descriptor* bos_capability(struct libusb_bos_descriptor* bos, int i) {
+		return bos->dev_capability[i];
+	}
+
+	// goHotplugCallback is the Go side of the hotplug notifications (hotplug.go).
+	extern int goHotplugCallback(libusb_context* ctx, libusb_device* dev, libusb_hotplug_event event, intptr_t id);
+
+	// hotplug_callback forwards libusb hotplug notifications into Go, unpacking
+	// the callback id smuggled through the user data pointer.
+	static int LIBUSB_CALL hotplug_callback(libusb_context* ctx, libusb_device* dev, libusb_hotplug_event event, void* user_data) {
+		return goHotplugCallback(ctx, dev, event, (intptr_t)user_data);
+	}
+
+	// register_hotplug subscribes the Go callback with the given id to device
+	// arrival and departure notifications of any device.
+	int register_hotplug(intptr_t id, libusb_hotplug_callback_handle* handle) {
+		return libusb_hotplug_register_callback(ctx,
+			LIBUSB_HOTPLUG_EVENT_DEVICE_ARRIVED | LIBUSB_HOTPLUG_EVENT_DEVICE_LEFT, 0,
+			LIBUSB_HOTPLUG_MATCH_ANY, LIBUSB_HOTPLUG_MATCH_ANY, LIBUSB_HOTPLUG_MATCH_ANY,
+			hotplug_callback, (void*)id, handle);
+	}
+
+	// goTransferCallback is the Go side of asynchronous transfer completions
+	// (stream_libusb.go).
+	extern void goTransferCallback(intptr_t id);
+
+	// transfer_callback forwards the completion of an asynchronous transfer into
+	// Go, unpacking the transfer id smuggled through the user data pointer.
+	static void LIBUSB_CALL transfer_callback(struct libusb_transfer* transfer) {
+		goTransferCallback((intptr_t)transfer->user_data);
+	}
+
+	// fill_transfer prepares a bulk or interrupt transfer reporting its
+	// completion to Go under the given id.
+	void fill_transfer(struct libusb_transfer* transfer, libusb_device_handle* handle, unsigned char endpoint,
+		unsigned char type, unsigned char* buffer, int length, intptr_t id, unsigned int timeout) {
+		libusb_fill_bulk_transfer(transfer, handle, endpoint, buffer, length, transfer_callback, (void*)id, timeout);
+		transfer->type = type;
+	}
+
+	// fill_iso_transfer prepares an isochronous transfer of equally sized packets
+	// reporting its completion to Go under the given id.
+	void fill_iso_transfer(struct libusb_transfer* transfer, libusb_device_handle* handle, unsigned char endpoint,
+		unsigned char* buffer, int packets, int packet_size, intptr_t id, unsigned int timeout) {
+		libusb_fill_iso_transfer(transfer, handle, endpoint, buffer, packets * packet_size, packets, transfer_callback, (void*)id, timeout);
+		libusb_set_iso_packet_lengths(transfer, packet_size);
+	}
+
+	// fill_stream_transfer prepares a transfer on a bulk stream of an endpoint
+	// reporting its completion to Go under the given id.
+	void fill_stream_transfer(struct libusb_transfer* transfer, libusb_device_handle* handle, unsigned char endpoint,
+		uint32_t stream_id, unsigned char* buffer, int length, intptr_t id, unsigned int timeout) {
+		libusb_fill_bulk_stream_transfer(transfer, handle, endpoint, stream_id, buffer, length, transfer_callback, (void*)id, timeout);
+	}
+
+	// set_option enables an option without arguments, working around cgo not
+	// being able to call the variadic libusb_set_option.
+	static int set_option(libusb_context* ctx, enum libusb_option option) {
+		return libusb_set_option(ctx, option);
+	}
+
+	// set_option_int sets an option taking an integer argument.
+	static int set_option_int(libusb_context* ctx, enum libusb_option option, int value) {
+		return libusb_set_option(ctx, option, value);
+	}
 */
 import "C"
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"reflect"
+	"os"
+	"runtime"
 	"sync"
 	"unsafe"
 )
 
 type libusbContext C.libusb_context
 
+// Context is a libusb context along with the goroutine handling its events,
+// which completes asynchronous transfers and delivers hotplug notifications.
+// The goroutine runs while the context has users: open devices and hotplug
+// subscriptions.
 type Context struct {
-	ctx    *libusbContext
-	done   chan struct{}
-	libusb libusbDevice
+	ctx     *libusbContext
+	epoch   int                    // Number of times the context was closed, invalidating device references
+	devices map[*libusbDevice]bool // Devices opened through the context
+	users   int                    // Number of open devices, hub handles and hotplug subscriptions
+	stop    chan struct{}          // Closed to terminate the event loop
+	done    chan struct{}          // Closed when the event loop terminated
+	lock    sync.Mutex
+}
+
+// libusbCtx is the global context devices are accessed through.
+var libusbCtx Context
 
-	mu      sync.Mutex
-	devices map[*Device]bool
+// acquire registers a user of the context, starting the event loop for the
+// first one.
+func (c *Context) acquire() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.users++; c.users == 1 {
+		c.stop, c.done = make(chan struct{}), make(chan struct{})
+		go c.loop(c.stop, c.done)
+	}
+}
+
+// release unregisters a user of the context, stopping the event loop after
+// the last one. It doesn't wait for the loop to terminate, as it may be called
+// from an event callback running on the loop itself.
+func (c *Context) release() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.users--; c.users == 0 {
+		close(c.stop)
+		C.libusb_interrupt_event_handler((*C.libusb_context)(c.ctx))
+	}
+}
+
+// track registers an opened device as a user of the context.
+func (c *Context) track(dev *libusbDevice) {
+	c.lock.Lock()
+	if c.devices == nil {
+		c.devices = make(map[*libusbDevice]bool)
+	}
+	c.devices[dev] = true
+	c.lock.Unlock()
+
+	c.acquire()
+}
+
+// untrack unregisters a closed device.
+func (c *Context) untrack(dev *libusbDevice) {
+	c.lock.Lock()
+	delete(c.devices, dev)
+	c.lock.Unlock()
+
+	c.release()
+}
+
+// loop handles libusb events until stopped.
+func (c *Context) loop(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	tv := C.struct_timeval{tv_usec: 100000}
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+			C.libusb_handle_events_timeout_completed((*C.libusb_context)(c.ctx), &tv, nil)
+		}
+	}
+}
+
+// Close closes the devices still open through the context and cancels its
+// hotplug subscriptions, then waits for the event loop to stop and exits
+// libusb. Infos enumerated before are no longer tied to libusb devices, so
+// opening them enumerates again in a fresh context.
+//
+// Devices with streams still open have the transfers of the streams canceled,
+// but are only released once the streams are closed. Close fails until then,
+// leaving the event loop running to complete the canceled transfers.
+//
+// Close must not be called from a hotplug handler, as it waits for the event
+// loop delivering the notification.
+func (c *Context) Close() error {
+	c.lock.Lock()
+	devices := make([]*libusbDevice, 0, len(c.devices))
+	for dev := range c.devices {
+		devices = append(devices, dev)
+	}
+	c.lock.Unlock()
+
+	for _, dev := range devices {
+		dev.Close()
+	}
+	cancelHotplug()
+
+	c.lock.Lock()
+	users, done := c.users, c.done
+	c.lock.Unlock()
+
+	if users > 0 {
+		return fmt.Errorf("failed to close context: %d users remaining", users)
+	}
+	if done != nil {
+		<-done
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.stop, c.done = nil, nil
+	if c.ctx != nil {
+		C.libusb_exit((*C.libusb_context)(c.ctx))
+		c.ctx, C.ctx = nil, nil
+		c.epoch++
+	}
+	return nil
+}
+
+// HasCapability reports whether libusb supports a capability on the platform,
+// which is fixed by the backend libusb was built with.
+func (c *Context) HasCapability(capability Capability) bool {
+	var libusbCap C.uint32_t
+	switch capability {
+	case CapabilityHotplug:
+		libusbCap = C.LIBUSB_CAP_HAS_HOTPLUG
+	case CapabilityHIDAccess:
+		libusbCap = C.LIBUSB_CAP_HAS_HID_ACCESS
+	case CapabilityDetachKernelDriver:
+		libusbCap = C.LIBUSB_CAP_SUPPORTS_DETACH_KERNEL_DRIVER
+	default:
+		return false
+	}
+	return C.libusb_has_capability(libusbCap) != 0
+}
+
+// OpenSnapshot opens the device interface described by a stored or transferred
+// info, enumerating the devices of the context again to find it.
+func (c *Context) OpenSnapshot(info DeviceInfo, opts ...OpenOption) (Device, error) {
+	return OpenSnapshot(info, opts...)
+}
+
+// hasCapability queries the capabilities of the global context.
+func hasCapability(capability Capability) bool {
+	return libusbCtx.HasCapability(capability)
+}
+
+// backendVersion reports libusb as the backend, along with the version of the
+// library linked in.
+func backendVersion() (string, string) {
+	v := C.libusb_get_version()
+	return "libusb", fmt.Sprintf("%d.%d.%d.%d%s", int(v.major), int(v.minor), int(v.micro), int(v.nano), C.GoString(v.rc))
+}
+
+// exit closes the global context.
+func exit() error {
+	return libusbCtx.Close()
+}
+
+// fromLibusbErrno converts a raw libusb Error into a Go type.
+func fromLibusbErrno(Errno C.int) error {
+	err := libusbError(Errno)
+	if err == libusbSuccess {
+		return nil
+	}
+	return err
 }
 
 // libusbDevice is a USB connected device handle.
 type libusbDevice struct {
-	DeviceInfo // Embed the infos for easier access
+	libusbHandle // Claims, timeouts and transfers shared with the runtime loaded binding
+
+	handle *C.struct_libusb_device_handle // Low level USB device to communicate through
+	pool   *bufferPool                    // Transfer buffers, allocated from device memory if supported
+	tryIn  *libusbTransfer                // Read queued by TryRead, nil if none
+	tryOut *libusbTransfer                // Write queued by TryWrite, nil if none
+
+	streams    map[canceler]bool // Streams with transfers referencing the handle, mapped to whether Close may cancel them
+	closing    bool              // Whether Close was deferred until the streams are closed
+	streamLock sync.Mutex        // Guards the streams and the deferred Close
+
+	unwatch func() // Unsubscribes from the departure of the device, nil if hotplug is unsupported
+}
+
+// contextOpts are the options the global context is initialized with.
+var contextOpts contextOptions
 
-	handle       *C.struct_libusb_device_handle // Low level USB device to communicate through
-	lock         sync.Mutex
-	writeTimeout int
-	readTimeout  int
+// configure replaces the options of the global context, which must not be
+// initialized yet with different ones.
+func configure(opts contextOptions) error {
+	if C.ctx != nil {
+		if opts.equal(contextOpts) {
+			return nil
+		}
+		return errors.New("failed to configure libusb: context already initialized")
+	}
+	contextOpts = opts
+	return nil
+}
+
+// NewContext initializes the libusb context with the options like Init and
+// returns it. libusb is driven through a single context per process, so every
+// call returns the same one.
+func NewContext(opts ...ContextOption) (*Context, error) {
+	if err := Init(opts...); err != nil {
+		return nil, err
+	}
+	return &libusbCtx, nil
 }
 
-// enumerateRawWithRef is the internal device enumerator that retains 1 reference
-// to every matched device so they may selectively be opened on request.
-func getAllDevices(vendorID ID, productID ID) ([]DeviceInfo, error) {
-	// Ensure we have a libusb context to interact through. The enumerate call is
-	// protected by a mutex outside, so it's fine to do the below check and init.
+// checkContext fails with ErrNotInitialized unless Init set up the global
+// libusb context.
+func checkContext() error {
 	if C.ctx == nil {
-		if err := fromLibusbErrno(C.libusb_init((**C.libusb_context)(&C.ctx))); err != nil {
-			return nil, fmt.Errorf("failed to initialize libusb: %v", err)
+		return ErrNotInitialized
+	}
+	return nil
+}
+
+// initContext initializes the global libusb context for Init, unless it is
+// already, reporting the version of libusb and the options along failures.
+// Init holds the package mutex, so it's fine to do the check and init.
+func initContext() error {
+	if C.ctx != nil {
+		return nil
+	}
+	if err := newContext(); err != nil {
+		_, version := backendVersion()
+		return fmt.Errorf("failed to initialize libusb %s (%s): %w", version, contextOpts, err)
+	}
+	return nil
+}
+
+// newContext initializes the global libusb context with the configured options.
+func newContext() error {
+	if runtime.GOOS == "android" || contextOpts.noDiscovery {
+		// Apps can't access usbfs, devices are handed over as fds (OpenFromFD)
+		C.set_option(nil, C.LIBUSB_OPTION_NO_DEVICE_DISCOVERY)
+	}
+	if err := fromLibusbErrno(C.libusb_init((**C.libusb_context)(&C.ctx))); err != nil {
+		return err
+	}
+	if err := applyContextOptions(); err != nil {
+		C.libusb_exit(C.ctx)
+		C.ctx = nil
+		return err
+	}
+	libusbCtx.lock.Lock()
+	libusbCtx.ctx = (*libusbContext)(C.ctx)
+	libusbCtx.lock.Unlock()
+
+	return nil
+}
+
+// applyContextOptions sets the configured options taking effect after init on
+// the freshly initialized global context.
+func applyContextOptions() error {
+	if contextOpts.usbdk {
+		if err := fromLibusbErrno(C.set_option(C.ctx, C.LIBUSB_OPTION_USE_USBDK)); err != nil {
+			return fmt.Errorf("failed to enable UsbDk: %w", err)
+		}
+	}
+	if level := contextOpts.logLevel; level != nil {
+		if err := fromLibusbErrno(C.set_option_int(C.ctx, C.LIBUSB_OPTION_LOG_LEVEL, C.int(*level))); err != nil {
+			return fmt.Errorf("failed to set libusb log level: %w", err)
 		}
 	}
+	return nil
+}
+
+// getAllDevices is the internal device enumerator returning every device
+// interface accepted by the match predicate. HID devices and interfaces are
+// only included if requested.
+func getAllDevices(match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	return enumerateDevices(0, 0, match, hid)
+}
+
+// findDevices is the internal device enumerator of Find, returning every
+// interface of the devices with the given vendor and product IDs, zero
+// matching any.
+func findDevices(vendorID ID, productID ID, hid bool) ([]DeviceInfo, error) {
+	return enumerateDevices(vendorID, productID, matchIDs(vendorID, productID), hid)
+}
+
+// enumerateDevices returns the interfaces accepted by the match predicate of
+// the devices with the given vendor and product IDs, zero matching any. Other
+// devices are skipped on their device descriptor, which libusb has cached,
+// without parsing their configurations.
+func enumerateDevices(vendorID ID, productID ID, match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	// Ensure Init set up a libusb context to interact through
+	if err := checkContext(); err != nil {
+		return nil, err
+	}
 
 	// Retrieve all the available USB devices and wrap them in Go
 	var deviceList **C.libusb_device
-	defer C.libusb_free_device_list(deviceList, 1)
 
 	count := C.libusb_get_device_list(C.ctx, &deviceList)
-
 	if count < 0 {
 		return nil, libusbError(count)
 	}
+	defer C.libusb_free_device_list(deviceList, 1)
 
-	var devices []*C.libusb_device
-	*(*reflect.SliceHeader)(unsafe.Pointer(&devices)) = reflect.SliceHeader{
-		Data: uintptr(unsafe.Pointer(deviceList)),
-		Len:  int(count),
-		Cap:  int(count),
+	var infos []DeviceInfo
+	for devnum, dev := range unsafe.Slice(deviceList, int(count)) {
+		if vendorID != 0 || productID != 0 {
+			// Descriptors failing to be read are reported by describeDevice
+			var desc C.struct_libusb_device_descriptor
+			if C.libusb_get_device_descriptor(dev, &desc) == 0 && !matchIDs(vendorID, productID)(DeviceInfo{VendorID: uint16(desc.idVendor), ProductID: uint16(desc.idProduct)}) {
+				continue
+			}
+		}
+		devInfos, err := describeDevice(dev, devnum, match, hid)
+		infos = append(infos, devInfos...)
+		if err != nil {
+			return infos, err
+		}
 	}
 
+	return infos, nil
+}
+
+// libusbRef is a reference to a libusb device shared by the infos enumerated
+// from it, keeping the device alive so it can be opened without enumerating
+// the bus again. The reference is dropped once no info holds it anymore.
+type libusbRef struct {
+	dev   *C.libusb_device
+	epoch int // Epoch of the context the device belongs to
+}
+
+// newLibusbRef references a libusb device until the returned wrapper is garbage
+// collected.
+func newLibusbRef(dev *C.libusb_device) *libusbRef {
+	C.libusb_ref_device(dev)
+
+	libusbCtx.lock.Lock()
+	ref := &libusbRef{dev: dev, epoch: libusbCtx.epoch}
+	libusbCtx.lock.Unlock()
+
+	runtime.SetFinalizer(ref, func(ref *libusbRef) {
+		// Devices of a closed context are gone with it, leave them be
+		if ref.valid() {
+			C.libusb_unref_device(ref.dev)
+		}
+	})
+	return ref
+}
+
+// valid reports whether the referenced device belongs to the current context.
+func (ref *libusbRef) valid() bool {
+	libusbCtx.lock.Lock()
+	defer libusbCtx.lock.Unlock()
+
+	return ref.epoch == libusbCtx.epoch
+}
+
+// describeDevice converts the interfaces of a libusb device accepted by the
+// match predicate into device infos. Matched devices are referenced by the
+// returned infos.
+func describeDevice(dev *C.libusb_device, devnum int, match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	// Retrieve the libusb device descriptor and skip non-queried ones
+	var desc C.struct_libusb_device_descriptor
+	if err := fromLibusbErrno(C.libusb_get_device_descriptor(dev, &desc)); err != nil {
+		return nil, fmt.Errorf("failed to get device %d descriptor: %w", devnum, err)
+	}
+	// Skip HID devices, they are handled directly by OS libraries
+	if !hid && desc.bDeviceClass == C.LIBUSB_CLASS_HID {
+		logger().Debug("usb: skipped HID device", "vendor", ID(desc.idVendor), "product", ID(desc.idProduct))
+		return nil, nil
+	}
+	base := deviceInfo(dev, &desc)
+
+	config := func(cfgnum int) ([]byte, error) {
+		var cfg *C.struct_libusb_config_descriptor
+		if err := fromLibusbErrno(C.libusb_get_config_descriptor(dev, C.uint8_t(cfgnum), &cfg)); err != nil {
+			return nil, err
+		}
+		defer C.libusb_free_config_descriptor(cfg)
+
+		return rawConfig(cfg), nil
+	}
+	// Enumeration matched, reference the device to avoid cleaning it up
+	ref := func() interface{} { return newLibusbRef(dev) }
+
+	return describeConfigs(base, devnum, int(desc.bNumConfigurations), config, ref, match, hid)
+}
+
+// rawConfig rebuilds the raw configuration descriptor hierarchy from its form
+// parsed by libusb, which retains the descriptors it doesn't parse itself (e.g.
+// class specific ones and SuperSpeed endpoint companions) as extra bytes.
+func rawConfig(cfg *C.struct_libusb_config_descriptor) []byte {
+	raw := []byte{
+		C.LIBUSB_DT_CONFIG_SIZE, C.LIBUSB_DT_CONFIG, 0, 0, // Total length filled in last
+		byte(cfg.bNumInterfaces), byte(cfg.bConfigurationValue), byte(cfg.iConfiguration), byte(cfg.bmAttributes), byte(cfg.MaxPower),
+	}
+	raw = append(raw, C.GoBytes(unsafe.Pointer(cfg.extra), cfg.extra_length)...)
+
+	for _, iface := range unsafe.Slice(cfg._interface, int(cfg.bNumInterfaces)) {
+		for _, alt := range unsafe.Slice(iface.altsetting, int(iface.num_altsetting)) {
+			raw = append(raw,
+				C.LIBUSB_DT_INTERFACE_SIZE, C.LIBUSB_DT_INTERFACE, byte(alt.bInterfaceNumber), byte(alt.bAlternateSetting), byte(alt.bNumEndpoints),
+				byte(alt.bInterfaceClass), byte(alt.bInterfaceSubClass), byte(alt.bInterfaceProtocol), byte(alt.iInterface),
+			)
+			raw = append(raw, C.GoBytes(unsafe.Pointer(alt.extra), alt.extra_length)...)
+
+			for _, end := range unsafe.Slice(alt.endpoint, int(alt.bNumEndpoints)) {
+				if end.bLength >= C.LIBUSB_DT_ENDPOINT_AUDIO_SIZE {
+					raw = append(raw, C.LIBUSB_DT_ENDPOINT_AUDIO_SIZE, C.LIBUSB_DT_ENDPOINT, byte(end.bEndpointAddress), byte(end.bmAttributes),
+						byte(end.wMaxPacketSize), byte(end.wMaxPacketSize>>8), byte(end.bInterval), byte(end.bRefresh), byte(end.bSynchAddress))
+				} else {
+					raw = append(raw, C.LIBUSB_DT_ENDPOINT_SIZE, C.LIBUSB_DT_ENDPOINT, byte(end.bEndpointAddress), byte(end.bmAttributes),
+						byte(end.wMaxPacketSize), byte(end.wMaxPacketSize>>8), byte(end.bInterval))
+				}
+				raw = append(raw, C.GoBytes(unsafe.Pointer(end.extra), end.extra_length)...)
+			}
+		}
+	}
+	binary.LittleEndian.PutUint16(raw[2:], uint16(len(raw)))
+	return raw
+}
+
+// deviceInfo converts the device descriptor of a libusb device into an info,
+// leaving the interface fields unset.
+func deviceInfo(dev *C.libusb_device, desc *C.struct_libusb_device_descriptor) DeviceInfo {
+	port := uint8(C.libusb_get_port_number(dev))
+
+	// The ugen backends of OpenBSD and NetBSD don't report ports, fall back to
+	// the bus address to keep identical devices apart
+	slot := port
+	if slot == 0 {
+		slot = uint8(C.libusb_get_device_address(dev))
+	}
+	chain := portChain(dev)
+	if len(chain) == 0 && (runtime.GOOS == "openbsd" || runtime.GOOS == "netbsd") {
+		chain = []uint8{slot}
+	}
+	bus := uint8(C.libusb_get_bus_number(dev))
+
+	// Only Linux names devices after their place in the topology in sysfs
+	var syspath string
+	if runtime.GOOS == "linux" || runtime.GOOS == "android" {
+		syspath = sysfsPath(bus, chain)
+	}
+	return DeviceInfo{
+		Path:       DevicePath{Bus: bus, Ports: chain, Interface: -1}.String(),
+		SysPath:    syspath,
+		VendorID:   uint16(desc.idVendor),
+		ProductID:  uint16(desc.idProduct),
+		Release:    uint16(desc.bcdDevice),
+		USBVersion: uint16(desc.bcdUSB),
+		Class:      uint8(desc.bDeviceClass),
+		SubClass:   uint8(desc.bDeviceSubClass),
+		Protocol:   uint8(desc.bDeviceProtocol),
+		Bus:        bus,
+		Port:       port,
+		Speed:      Speed(C.libusb_get_device_speed(dev)),
+		libusbPort: &slot,
+
+		ManufacturerIndex: uint8(desc.iManufacturer),
+		ProductIndex:      uint8(desc.iProduct),
+		SerialIndex:       uint8(desc.iSerialNumber),
+	}
+}
+
+// portChain returns the hub ports leading from the root hub to a device, empty
+// for root hubs and on backends not reporting ports.
+func portChain(dev *C.libusb_device) []uint8 {
+	var ports [7]C.uint8_t // USB 3.0 limits the depth of hub chains to 7
+
+	n := int(C.libusb_get_port_numbers(dev, &ports[0], C.int(len(ports))))
+	if n <= 0 {
+		return nil
+	}
+	chain := make([]uint8, n)
+	for i := range chain {
+		chain[i] = uint8(ports[i])
+	}
+	return chain
+}
+
+// sysfsPath returns the directory of a device in the Linux sysfs, named after
+// the bus and the chain of hub ports leading to it (e.g. 1-4.2), or usbN for
+// the root hub of bus N.
+func sysfsPath(bus uint8, chain []uint8) string {
+	if len(chain) == 0 {
+		return fmt.Sprintf("/sys/bus/usb/devices/usb%d", bus)
+	}
+	return "/sys/bus/usb/devices/" + DevicePath{Bus: bus, Ports: chain, Interface: -1}.String()
+}
+
+// listDevices is the internal device lister returning every device accepted by
+// the match predicate. Only the device descriptors are read, which libusb has
+// cached, the configurations are parsed when the interfaces are resolved.
+func listDevices(match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	// Ensure Init set up a libusb context to interact through
+	if err := checkContext(); err != nil {
+		return nil, err
+	}
+	var deviceList **C.libusb_device
+
+	count := C.libusb_get_device_list(C.ctx, &deviceList)
+	if count < 0 {
+		return nil, libusbError(count)
+	}
+	defer C.libusb_free_device_list(deviceList, 1)
+
 	var infos []DeviceInfo
-	for devnum, dev := range devices {
-		// Retrieve the libusb device descriptor and skip non-queried ones
+	for devnum, dev := range unsafe.Slice(deviceList, int(count)) {
 		var desc C.struct_libusb_device_descriptor
 		if err := fromLibusbErrno(C.libusb_get_device_descriptor(dev, &desc)); err != nil {
-			return infos, fmt.Errorf("failed to get device %d descriptor: %v", devnum, err)
-		}
-		if (vendorID > 0 && ID(desc.idVendor) != vendorID) || (productID > 0 && ID(desc.idProduct) != productID) {
-			continue
+			return infos, fmt.Errorf("failed to get device %d descriptor: %w", devnum, err)
 		}
 		// Skip HID devices, they are handled directly by OS libraries
-		if desc.bDeviceClass == C.LIBUSB_CLASS_HID {
+		if !hid && desc.bDeviceClass == C.LIBUSB_CLASS_HID {
+			logger().Debug("usb: skipped HID device", "vendor", ID(desc.idVendor), "product", ID(desc.idProduct))
 			continue
 		}
-		// Iterate over all the configurations and find raw interfaces
-		for cfgnum := 0; cfgnum < int(desc.bNumConfigurations); cfgnum++ {
-			// Retrieve the all the possible USB configurations of the device
-			var cfg *C.struct_libusb_config_descriptor
-			if err := fromLibusbErrno(C.libusb_get_config_descriptor(dev, C.uint8_t(cfgnum), &cfg)); err != nil {
-				return infos, fmt.Errorf("failed to get device %d config %d: %v", devnum, cfgnum, err)
-			}
-			var ifaces []C.struct_libusb_interface
-			*(*reflect.SliceHeader)(unsafe.Pointer(&ifaces)) = reflect.SliceHeader{
-				Data: uintptr(unsafe.Pointer(cfg._interface)),
-				Len:  int(cfg.bNumInterfaces),
-				Cap:  int(cfg.bNumInterfaces),
-			}
-			// Drill down into each advertised interface
-			for ifacenum, iface := range ifaces {
-				if iface.num_altsetting == 0 {
-					continue
-				}
-				var alts []C.struct_libusb_interface_descriptor
-				*(*reflect.SliceHeader)(unsafe.Pointer(&alts)) = reflect.SliceHeader{
-					Data: uintptr(unsafe.Pointer(iface.altsetting)),
-					Len:  int(iface.num_altsetting),
-					Cap:  int(iface.num_altsetting),
-				}
-				for _, alt := range alts {
-					// Skip HID interfaces, they are handled directly by OS libraries
-					if alt.bInterfaceClass == C.LIBUSB_CLASS_HID {
-						continue
-					}
-					// Find the endpoints that can speak libusb interrupts
-					var ends []C.struct_libusb_endpoint_descriptor
-					*(*reflect.SliceHeader)(unsafe.Pointer(&ends)) = reflect.SliceHeader{
-						Data: uintptr(unsafe.Pointer(alt.endpoint)),
-						Len:  int(alt.bNumEndpoints),
-						Cap:  int(alt.bNumEndpoints),
-					}
-					var reader, writer *uint8
-					var readerTransferType, writerTransferType uint8
-					for _, end := range ends {
-						// Skip any non-interrupt and bulk endpoints
-						if end.bmAttributes != C.LIBUSB_TRANSFER_TYPE_INTERRUPT && end.bmAttributes != C.LIBUSB_TRANSFER_TYPE_BULK {
-							continue
-						}
-						if end.bEndpointAddress&C.LIBUSB_ENDPOINT_IN == C.LIBUSB_ENDPOINT_IN {
-							reader = new(uint8)
-							*reader = uint8(end.bEndpointAddress)
-							readerTransferType = uint8(end.bmAttributes)
-						} else {
-							writer = new(uint8)
-							*writer = uint8(end.bEndpointAddress)
-							writerTransferType = uint8(end.bmAttributes)
-						}
-					}
-					// If both in and out interrupts are available, match the device
-					if reader != nil && writer != nil {
-						// Enumeration matched, bump the device refcount to avoid cleaning it up
-						C.libusb_ref_device(dev)
-
-						port := uint8(C.libusb_get_port_number(dev))
-						info := DeviceInfo{
-							Path:               fmt.Sprintf("%04x:%04x:%02d", vendorID, uint16(desc.idProduct), port),
-							VendorID:           uint16(desc.idVendor),
-							ProductID:          uint16(desc.idProduct),
-							Class:              uint8(desc.bDeviceClass),
-							SubClass:           uint8(desc.bDeviceSubClass),
-							Protocol:           uint8(desc.bDeviceProtocol),
-							Interface:          ifacenum,
-							libusbDevice:       dev,
-							libusbPort:         &port,
-							libusbReader:       reader,
-							libusbWriter:       writer,
-							readerTransferType: &readerTransferType,
-							writerTransferType: &writerTransferType,
-
-							InterfaceAlternate: int(alt.bAlternateSetting),
-							InterfaceClass:     uint8(alt.bInterfaceClass),
-							InterfaceSubClass:  uint8(alt.bInterfaceSubClass),
-							InterfaceProtocol:  uint8(alt.bInterfaceProtocol),
-						}
-						infos = append(infos, info)
-					}
-				}
-			}
+		info := deviceInfo(dev, &desc)
+		info.unresolved = true
+
+		if !match(info) {
+			continue
 		}
+		info.libusbDevice = newLibusbRef(dev)
+		infos = append(infos, info)
 	}
+	return infos, nil
+}
 
-	for _, info := range infos {
-		C.libusb_unref_device(info.libusbDevice.(*C.libusb_device))
+// describeInterfaces parses the configurations of a listed device into the
+// infos of its interfaces. Devices not listed by libusb are enumerated again.
+func describeInterfaces(info DeviceInfo, hid bool) ([]DeviceInfo, error) {
+	ref, ok := info.libusbDevice.(*libusbRef)
+	if !ok || !ref.valid() {
+		return interfacesOf(info, hid)
 	}
-
-	return infos, nil
+	return describeDevice(ref.dev, 0, func(DeviceInfo) bool { return true }, hid)
 }
 
-// open connects to a libusb device by its path name.
-func open(info DeviceInfo) (*libusbDevice, error) {
-	matches, err := getAllDevices(ID(info.VendorID), ID(info.ProductID))
-	if err != nil {
-		for _, match := range matches {
-			C.libusb_unref_device(match.libusbDevice.(*C.libusb_device))
+// open connects to a libusb device by its path name. The device referenced by
+// the enumeration is opened directly, the bus is only enumerated again if it
+// disappeared meanwhile (e.g. replugged) or the info wasn't enumerated here.
+func open(info DeviceInfo, opts *openOptions) (*libusbDevice, error) {
+	if ref, ok := info.libusbDevice.(*libusbRef); ok && ref.valid() {
+		var handle *C.struct_libusb_device_handle
+		err := fromLibusbErrno(C.libusb_open(ref.dev, &handle))
+		if err == nil {
+			return claimDevice(info, handle, opts.noDetach)
+		}
+		if err == libusbErrAccess {
+			return nil, accessError(ref.dev, err)
 		}
+		if !errors.Is(err, ErrNoDevice) {
+			return nil, fmt.Errorf("failed to open device: %w", err)
+		}
+	}
+	matches, err := findDevices(ID(info.VendorID), ID(info.ProductID), true)
+	if err != nil {
 		return nil, err
 	}
-
-	var device *C.libusb_device
 	for _, match := range matches {
-		// Keep the matching device reference, release anything else
-		if device == nil && *match.libusbPort == *info.libusbPort && match.Interface == info.Interface {
-			device = match.libusbDevice.(*C.libusb_device)
-		} else {
-			C.libusb_unref_device(match.libusbDevice.(*C.libusb_device))
+		if devicePath(match.Path) != devicePath(info.Path) || match.Interface != info.Interface {
+			continue
+		}
+		info.libusbDevice = match.libusbDevice
+
+		dev := match.libusbDevice.(*libusbRef).dev
+
+		var handle *C.struct_libusb_device_handle
+		if err := fromLibusbErrno(C.libusb_open(dev, &handle)); err != nil {
+			if err == libusbErrAccess {
+				return nil, accessError(dev, err)
+			}
+			return nil, fmt.Errorf("failed to open device: %w", err)
 		}
+		return claimDevice(info, handle, opts.noDetach)
 	}
+	return nil, fmt.Errorf("failed to open device: %w", ErrNoDevice)
+}
 
-	if device == nil {
-		return nil, fmt.Errorf("failed to open device: not found")
+// accessError details a denied libusb_open with the usbfs node of the device
+// on Linux and a hint on granting access on the platform.
+func accessError(dev *C.libusb_device, err error) error {
+	var node, hint string
+	switch runtime.GOOS {
+	case "linux":
+		node = fmt.Sprintf("/dev/bus/usb/%03d/%03d", int(C.libusb_get_bus_number(dev)), int(C.libusb_get_device_address(dev)))
+		hint = "add a udev rule granting access (see zerousb udev) or run as root"
+	case "android":
+		hint = "request the permission through UsbManager and use OpenFromFD"
+	case "windows":
+		hint = "install the WinUSB driver for the device (e.g. with Zadig)"
+	case "darwin":
+		hint = "run as root or unload the kernel extension holding the device"
+	default:
+		hint = "grant access to the usb device nodes via devfs rules or run as root"
 	}
+	return newAccessError(node, hint, err)
+}
 
-	info.libusbDevice = device
+// openFD wraps an usbfs file descriptor into a libusb device handle and claims
+// the first interface suitable for reading and writing.
+func openFD(fd int) (*libusbDevice, error) {
+	return wrapSysDevice(uintptr(fd), new(openOptions))
+}
 
-	var handle *C.struct_libusb_device_handle
-	if err := fromLibusbErrno(C.libusb_open(info.libusbDevice.(*C.libusb_device), (**C.struct_libusb_device_handle)(&handle))); err != nil {
-		return nil, fmt.Errorf("failed to open device: %v", err)
+// WrapSysDevice connects to a device through a system handle opened by someone
+// else, claiming the first interface with endpoints in both directions and
+// applying the open options. On Linux and Android the handle is a file
+// descriptor of the usbfs node, as handed over by UsbManager, sandbox portals
+// (snap, Flatpak) or systemd socket activation. The handle remains owned by
+// the caller and is not closed by Close. Other platforms fail with an error
+// matching ErrNotSupported.
+func (c *Context) WrapSysDevice(fd uintptr, opts ...OpenOption) (Device, error) {
+	options := new(openOptions)
+	for _, opt := range opts {
+		opt(options)
 	}
+	lock.Lock()
+	defer lock.Unlock()
 
-	libusbDvc := &libusbDevice{
-		DeviceInfo: info,
-		handle:     handle,
+	dev, err := wrapSysDevice(fd, options)
+	if err != nil {
+		return nil, err
 	}
+	return options.finish(dev, dev.DeviceInfo)
+}
 
-	libusbDvc.SetAutoDetach(1)
-	libusbDvc.DetachKernelDriver()
+// openPath opens a usbfs device node and wraps it like WrapSysDevice, closing
+// the node along with the device.
+func openPath(path string, opts *openOptions) (Device, DeviceInfo, error) {
+	node, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrPermission) {
+		return nil, DeviceInfo{}, newAccessError(path, "add a udev rule granting access (see zerousb udev) or run as root", err)
+	}
+	if err != nil {
+		return nil, DeviceInfo{}, fmt.Errorf("failed to open device: %w", err)
+	}
+	dev, err := wrapSysDevice(node.Fd(), opts)
+	if err != nil {
+		node.Close()
+		return nil, DeviceInfo{}, err
+	}
+	dev.node = node
+	return dev, dev.DeviceInfo, nil
+}
 
-	if err := fromLibusbErrno(C.libusb_claim_interface(handle, (C.int)(info.Interface))); err != nil {
+// wrapSysDevice wraps a system device handle into a libusb device handle and
+// claims the first interface suitable for reading and writing.
+func wrapSysDevice(fd uintptr, opts *openOptions) (*libusbDevice, error) {
+	if err := checkContext(); err != nil {
+		return nil, err
+	}
+	var handle *C.struct_libusb_device_handle
+	if err := fromLibusbErrno(C.libusb_wrap_sys_device(C.ctx, C.intptr_t(fd), &handle)); err != nil {
+		return nil, fmt.Errorf("failed to wrap device: %w", err)
+	}
+	first := true
+	infos, err := describeDevice(C.libusb_get_device(handle), 0, func(DeviceInfo) bool {
+		defer func() { first = false }()
+		return first
+	}, true)
+	if err == nil && len(infos) == 0 {
+		err = errors.New("no interface with in and out endpoints")
+	}
+	if err != nil {
 		C.libusb_close(handle)
-		return nil, fmt.Errorf("failed to claim interface: %v", err)
+		return nil, fmt.Errorf("failed to open device: %w", err)
 	}
+	return claimDevice(infos[0], handle, opts.noDetach)
+}
 
-	return &libusbDevice{
-		DeviceInfo: info,
-		handle:     handle,
+// openParentHub opens the hub a device is attached to, leaving the interfaces
+// of the hub to its kernel driver. The device is looked up again if the info
+// wasn't enumerated by the current context.
+func openParentHub(info DeviceInfo) (*parentHub, error) {
+	ref, ok := info.libusbDevice.(*libusbRef)
+	if !ok || !ref.valid() {
+		matches, err := listDevices(func(match DeviceInfo) bool { return sameDevice(match, info) }, true)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("failed to find parent hub: %w", ErrNoDevice)
+		}
+		ref = matches[0].libusbDevice.(*libusbRef)
+	}
+	parent := C.libusb_get_parent(ref.dev)
+	if parent == nil {
+		return nil, fmt.Errorf("failed to find parent hub: device is a root hub: %w", ErrNotFound)
+	}
+	var handle *C.struct_libusb_device_handle
+	if err := fromLibusbErrno(C.libusb_open(parent, &handle)); err != nil {
+		return nil, fmt.Errorf("failed to open parent hub: %w", err)
+	}
+	// The handle may outlive the package lock while power cycling, keep the
+	// context from being closed under it
+	libusbCtx.acquire()
+
+	return &parentHub{
+		control: func(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+			n := C.libusb_control_transfer(handle, C.uint8_t(rType), C.uint8_t(request), C.uint16_t(val), C.uint16_t(idx), bufferPtr(data), C.uint16_t(len(data)), hubTimeout)
+			if n < 0 {
+				return 0, fromLibusbErrno(n)
+			}
+			return int(n), nil
+		},
+		port: uint8(C.libusb_get_port_number(ref.dev)),
+		close: func() {
+			C.libusb_close(handle)
+			libusbCtx.release()
+		},
 	}, nil
 }
 
-// Close releases the raw USB device handle.
-func (dev *libusbDevice) Close() error {
-	dev.lock.Lock()
-	defer dev.lock.Unlock()
-
-	if dev.handle != nil {
-		C.libusb_release_interface(dev.handle, (C.int)(dev.Interface))
-		C.libusb_close(dev.handle)
-		dev.handle = nil
+// claimDevice wraps an opened libusb handle, detaching any kernel driver unless
+// asked not to and claiming the interface the device was enumerated on.
+func claimDevice(info DeviceInfo, handle *C.struct_libusb_device_handle, noDetach bool) (*libusbDevice, error) {
+	libusbDvc := newLibusbDevice(info, handle, noDetach)
+	if err := libusbDvc.claimDevice(); err != nil {
+		libusbDvc.pool.drain()
+		C.libusb_close(handle)
+		return nil, err
 	}
-	C.libusb_unref_device(dev.libusbDevice.(*C.libusb_device))
+	libusbCtx.track(libusbDvc)
 
-	return nil
-}
+	// Watch for the device leaving, so Done fires without a transfer failing
+	if unwatch, err := onHotplug(func(event HotplugEvent, left DeviceInfo) {
+		if event == DeviceLeft && sameDevice(info, left) {
+			libusbDvc.end(ErrDeviceGone)
+		}
+	}); err == nil {
+		libusbDvc.unwatch = unwatch
+	}
+	// Release the claims and the handle of devices gone, once the transfers in
+	// flight failed, instead of leaving it to the user
+	libusbDvc.OnDisconnect(func() { libusbDvc.Close() })
 
-func (dev *libusbDevice) SetWriteTimeout(timeout int) {
-	dev.writeTimeout = timeout
+	return libusbDvc, nil
 }
 
-func (dev *libusbDevice) SetReadTimeout(timeout int) {
-	dev.readTimeout = timeout
+// newLibusbDevice wraps a libusb handle, binding the primitives the shared
+// handle logic runs on to the libusb API.
+func newLibusbDevice(info DeviceInfo, handle *C.struct_libusb_device_handle, noDetach bool) *libusbDevice {
+	dev := &libusbDevice{handle: handle}
+	if handle != nil {
+		dev.pool = newBufferPool(handle)
+	}
+	dev.libusbHandle = libusbHandle{
+		DeviceInfo:     info,
+		controlTimeout: timeoutMillis(defaultControlTimeout),
+		reattach:       true,
+		noDetach:       noDetach,
+	}
+	dev.ops = libusbOps{
+		closed: func() bool { return dev.handle == nil },
+		claim: func(iface int) error {
+			return fromLibusbErrno(C.libusb_claim_interface(dev.handle, C.int(iface)))
+		},
+		release: func(iface int) error {
+			return fromLibusbErrno(C.libusb_release_interface(dev.handle, C.int(iface)))
+		},
+		detach: func(iface int) error {
+			return fromLibusbErrno(C.libusb_detach_kernel_driver(dev.handle, C.int(iface)))
+		},
+		attach: func(iface int) error {
+			return fromLibusbErrno(C.libusb_attach_kernel_driver(dev.handle, C.int(iface)))
+		},
+		setAutoDetach: func(enable bool) error {
+			var val C.int
+			if enable {
+				val = 1
+			}
+			return fromLibusbErrno(C.libusb_set_auto_detach_kernel_driver(dev.handle, val))
+		},
+		setAlt: func(iface int, alt int) error {
+			return fromLibusbErrno(C.libusb_set_interface_alt_setting(dev.handle, C.int(iface), C.int(alt)))
+		},
+		reset: func() error {
+			return fromLibusbErrno(C.libusb_reset_device(dev.handle))
+		},
+		setConfig: func(config int) error {
+			return fromLibusbErrno(C.libusb_set_configuration(dev.handle, C.int(config)))
+		},
+		getConfig: func() (int, error) {
+			var config C.int
+			err := fromLibusbErrno(C.libusb_get_configuration(dev.handle, &config))
+			return int(config), err
+		},
+		clearHalt: func(endpoint uint8) error {
+			return fromLibusbErrno(C.libusb_clear_halt(dev.handle, C.uchar(endpoint)))
+		},
+		control: func(rType, request uint8, val, idx uint16, data []byte, timeout int) (int, error) {
+			n := C.libusb_control_transfer(dev.handle, C.uint8_t(rType), C.uint8_t(request), C.uint16_t(val), C.uint16_t(idx), bufferPtr(data), C.uint16_t(len(data)), C.uint(timeout))
+			if n < 0 {
+				return 0, fromLibusbErrno(n)
+			}
+			return int(n), nil
+		},
+		transfer: dev.syncTransfer,
+	}
+	return dev
 }
 
-// Write sends a binary blob to an USB device.
-func (dev *libusbDevice) Write(b []byte) (int, error) {
-	dev.lock.Lock()
-	defer dev.lock.Unlock()
-
-	timeout := dev.writeTimeout
-
-	switch *dev.writerTransferType {
-	case C.LIBUSB_TRANSFER_TYPE_INTERRUPT:
-		return dev.writeInterrupt(b, timeout)
-	case C.LIBUSB_TRANSFER_TYPE_BULK:
-		return dev.writeBulk(b, timeout)
+// syncTransfer runs a synchronous bulk or interrupt transfer on an endpoint,
+// staged through device memory where supported.
+func (dev *libusbDevice) syncTransfer(endpoint uint8, kind TransferType, b []byte, timeout int) (int, error) {
+	buf, block := dev.stage(b)
+	if block != nil {
+		defer dev.pool.put(block)
+		if endpoint&endpointDirectionMask == 0 {
+			copy(buf, b)
+		}
 	}
-
-	return 0, fmt.Errorf("device transfer type unsupported %v", dev.readerTransferType)
+	var (
+		transferred C.int
+		err         error
+	)
+	if kind == TransferTypeInterrupt {
+		err = fromLibusbErrno(C.libusb_interrupt_transfer(dev.handle, C.uchar(endpoint), bufferPtr(buf), C.int(len(buf)), &transferred, C.uint(timeout)))
+	} else {
+		err = fromLibusbErrno(C.libusb_bulk_transfer(dev.handle, C.uchar(endpoint), bufferPtr(buf), C.int(len(buf)), &transferred, C.uint(timeout)))
+	}
+	if block != nil && endpoint&endpointDirectionMask != 0 {
+		copy(b, buf[:transferred])
+	}
+	return int(transferred), err
 }
 
-// Read retrieves a binary blob from an USB device.
-func (dev *libusbDevice) Read(b []byte) (int, error) {
+// Close releases the raw USB device handle. The transfers of streams still
+// open are canceled instead, the handle being released once the last of them
+// is closed.
+func (dev *libusbDevice) Close() error {
 	dev.lock.Lock()
 	defer dev.lock.Unlock()
 
-	timeout := dev.readTimeout
-
-	switch *dev.readerTransferType {
-	case C.LIBUSB_TRANSFER_TYPE_INTERRUPT:
-		return dev.readInterrupt(b, timeout)
-	case C.LIBUSB_TRANSFER_TYPE_BULK:
-		return dev.readBulk(b, timeout)
+	if dev.deferClose() {
+		dev.end(ErrDeviceClosed)
+		return nil
 	}
-
-	return 0, fmt.Errorf("device transfer type unsupported %v", dev.readerTransferType)
-}
-
-func (dev *libusbDevice) SetAutoDetach(val int) error {
-	err := fromLibusbErrno(C.libusb_set_auto_detach_kernel_driver(dev.handle, C.int(val)))
-	if err != nil && err != ErrNotSupported {
-		return err
+	if dev.handle != nil {
+		dev.closeTries()
+		dev.releaseDevice()
+		if dev.unwatch != nil {
+			dev.unwatch()
+		}
+		dev.pool.drain()
+		C.libusb_close(dev.handle)
+		dev.handle = nil
+		if dev.node != nil {
+			dev.node.Close()
+			dev.node = nil
+		}
+		libusbCtx.untrack(dev)
 	}
+	dev.end(ErrDeviceClosed)
+
 	return nil
 }
 
-func (dev *libusbDevice) DetachKernelDriver() error {
-	err := fromLibusbErrno(C.libusb_detach_kernel_driver(dev.handle, C.int(dev.Interface)))
-	if err != nil && err != ErrNotSupported && err != ErrNotFound {
-		// ErrorNotSupported is returned in non linux systems
-		// ErrorNotFound is returned if libusb's driver is already attached to the device
-		return err
+// stage returns the buffer to transfer b through. Unless b is device memory
+// already, it's staged through a pooled block of device memory if supported,
+// sparing the kernel from allocating and copying a buffer for each transfer.
+// Transfers above maxStageSize aren't staged, so they don't pin device memory.
+// The returned block is nil if b is to be transferred directly.
+func (dev *libusbDevice) stage(b []byte) ([]byte, *poolBlock) {
+	if len(b) == 0 || len(b) > maxStageSize || !dev.pool.dma() || dev.pool.owns(b) {
+		return b, nil
 	}
-	return nil
+	block := dev.pool.get(len(b))
+	return block.bytes()[:len(b)], block
 }
 
-func (dev *libusbDevice) readInterrupt(b []byte, timeout int) (int, error) {
-	var transferred C.int
-	if err := fromLibusbErrno(C.libusb_interrupt_transfer(dev.handle, (C.uchar)(*dev.libusbReader), (*C.uchar)(&b[0]), (C.int)(len(b)), &transferred, (C.uint)(timeout))); err != nil {
-		return 0, fmt.Errorf("failed to read from device: %v", err)
+// AcquireBuffer returns a transfer buffer of the given size from the pool of
+// the device, allocated from device memory where supported.
+func (dev *libusbDevice) AcquireBuffer(size int) (*Buffer, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+
+	if dev.handle == nil {
+		return nil, dev.closedErr()
 	}
-	return int(transferred), nil
+	block := dev.pool.get(size)
+	return &Buffer{data: block.bytes()[:size], release: func() { dev.pool.put(block) }}, nil
 }
 
-func (dev *libusbDevice) readBulk(b []byte, timeout int) (int, error) {
-	var transferred C.int
-	if err := fromLibusbErrno(C.libusb_bulk_transfer(dev.handle, (C.uchar)(*dev.libusbReader), (*C.uchar)(&b[0]), (C.int)(len(b)), &transferred, (C.uint)(timeout))); err != nil {
-		return 0, fmt.Errorf("failed to read from device: %v", err)
+// BOS retrieves the Binary Object Store descriptor of the device, exposing its
+// USB 2.0 extension, SuperSpeed and container ID capabilities.
+func (dev *libusbDevice) BOS() (*BOSDescriptor, error) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.handle == nil {
+		return nil, dev.closedErr()
 	}
-	return int(transferred), nil
-}
+	var bos *C.struct_libusb_bos_descriptor
+	if err := fromLibusbErrno(C.libusb_get_bos_descriptor(dev.handle, &bos)); err != nil {
+		return nil, fmt.Errorf("failed to get bos descriptor: %w", err)
+	}
+	defer C.libusb_free_bos_descriptor(bos)
 
-func (dev *libusbDevice) writeBulk(b []byte, timeout int) (int, error) {
-	var transferred C.int
-	if err := fromLibusbErrno(C.libusb_bulk_transfer(dev.handle, (C.uchar)(*dev.libusbWriter), (*C.uchar)(&b[0]), (C.int)(len(b)), &transferred, (C.uint)(timeout))); err != nil {
-		return 0, fmt.Errorf("failed to write to device: %v", err)
+	caps := make([]BOSCapability, 0, int(bos.bNumDeviceCaps))
+	for i := 0; i < int(bos.bNumDeviceCaps); i++ {
+		capability := C.bos_capability(bos, C.int(i))
+		if capability.bLength < 3 {
+			continue
+		}
+		caps = append(caps, BOSCapability{
+			Type: uint8(capability.bDevCapabilityType),
+			Data: C.GoBytes(unsafe.Add(unsafe.Pointer(capability), 3), C.int(capability.bLength-3)),
+		})
 	}
-	return int(transferred), nil
+	return parseBOS(caps)
 }
 
-func (dev *libusbDevice) writeInterrupt(b []byte, timeout int) (int, error) {
-	var transferred C.int
-	if err := fromLibusbErrno(C.libusb_interrupt_transfer(dev.handle, (C.uchar)(*dev.libusbWriter), (*C.uchar)(&b[0]), (C.int)(len(b)), &transferred, (C.uint)(timeout))); err != nil {
-		return 0, fmt.Errorf("failed to write to device: %v", err)
+// bufferPtr returns the C pointer of a transfer buffer, which is nil for zero
+// length transfers (e.g. zero length packets terminating a bulk transfer).
+func bufferPtr(b []byte) *C.uchar {
+	if len(b) == 0 {
+		return nil
 	}
-	return int(transferred), nil
+	return (*C.uchar)(&b[0])
 }
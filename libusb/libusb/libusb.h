@@ -1362,6 +1362,7 @@ int LIBUSB_CALL libusb_get_max_packet_size(libusb_device *dev,
 int LIBUSB_CALL libusb_get_max_iso_packet_size(libusb_device *dev,
 	unsigned char endpoint);
 
+int LIBUSB_CALL libusb_wrap_sys_device(libusb_context *ctx, intptr_t sys_dev, libusb_device_handle **dev_handle);
 int LIBUSB_CALL libusb_open(libusb_device *dev, libusb_device_handle **dev_handle);
 void LIBUSB_CALL libusb_close(libusb_device_handle *dev_handle);
 libusb_device * LIBUSB_CALL libusb_get_device(libusb_device_handle *dev_handle);
@@ -2028,6 +2029,19 @@ enum libusb_option {
 	 * Only valid on Windows.
 	 */
 	LIBUSB_OPTION_USE_USBDK,
+
+	/** Do not scan for devices
+	 *
+	 * With this option set, libusb will skip scanning devices in
+	 * libusb_init() and won't monitor for hotplug events. Devices can only be
+	 * accessed by wrapping file descriptors obtained elsewhere (e.g. from the
+	 * Android UsbManager) with libusb_wrap_sys_device().
+	 *
+	 * This option must be set before the first call to libusb_init().
+	 *
+	 * Only valid on Linux, backported from libusb 1.0.23.
+	 */
+	LIBUSB_OPTION_NO_DEVICE_DISCOVERY,
 };
 
 int LIBUSB_CALL libusb_set_option(libusb_context *ctx, enum libusb_option option, ...);
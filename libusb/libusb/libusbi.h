@@ -286,6 +286,9 @@ static inline void usbi_dbg(const char *format, ...)
 
 extern struct libusb_context *usbi_default_context;
 
+/* Set by LIBUSB_OPTION_NO_DEVICE_DISCOVERY before the first libusb_init() */
+extern int usbi_no_device_discovery;
+
 /* Forward declaration for use in context (fully defined inside poll abstraction) */
 struct pollfd;
 
@@ -1151,6 +1154,17 @@ struct usbi_os_backend {
 	 * usbi_transfer_get_os_priv() on the appropriate usbi_transfer instance.
 	 */
 	size_t transfer_priv_size;
+
+	/* Wrap a platform-specific device handle (e.g. an usbfs file descriptor)
+	 * obtained outside of libusb into a libusb device handle. The backend
+	 * must allocate the device, attach it to dev_handle->dev and initialize
+	 * the handle as in the open path, without taking ownership of sys_dev.
+	 *
+	 * Optional, backported from libusb 1.0.23. Kept last so that the backends
+	 * using positional initializers leave it unset.
+	 */
+	int (*wrap_sys_device)(struct libusb_context *ctx,
+		struct libusb_device_handle *dev_handle, intptr_t sys_dev);
 };
 
 extern const struct usbi_os_backend usbi_backend;
@@ -0,0 +1,996 @@
+//go:build !cgo && (amd64 || arm64) && (linux || (darwin && !ios && !iokit))
+
+package zerousb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// libusb entry points, bound by Init through purego so that builds without
+// cgo still reach devices wherever the shared library is installed.
+var (
+	libusbInit                      func(ctx *uintptr) int32
+	libusbExit                      func(ctx uintptr)
+	libusbSetOption                 func(ctx uintptr, option int32) int32
+	libusbSetDebug                  func(ctx uintptr, level int32)
+	libusbGetVersion                func() *libusbVersion
+	libusbHasCapability             func(capability uint32) int32
+	libusbGetDeviceList             func(ctx uintptr, list **uintptr) int
+	libusbFreeDeviceList            func(list *uintptr, unref int32)
+	libusbRefDevice                 func(dev uintptr) uintptr
+	libusbUnrefDevice               func(dev uintptr)
+	libusbGetDeviceDescriptor       func(dev uintptr, desc *[deviceDescriptorSize]byte) int32
+	libusbGetConfigDescriptor       func(dev uintptr, index uint8, config **libusbConfigDescriptor) int32
+	libusbFreeConfigDescriptor      func(config *libusbConfigDescriptor)
+	libusbGetBusNumber              func(dev uintptr) uint8
+	libusbGetPortNumber             func(dev uintptr) uint8
+	libusbGetPortNumbers            func(dev uintptr, ports *uint8, length int32) int32
+	libusbGetDeviceAddress          func(dev uintptr) uint8
+	libusbGetDeviceSpeed            func(dev uintptr) int32
+	libusbGetParent                 func(dev uintptr) uintptr
+	libusbGetDevice                 func(handle uintptr) uintptr
+	libusbOpen                      func(dev uintptr, handle *uintptr) int32
+	libusbWrapSysDevice             func(ctx uintptr, fd uintptr, handle *uintptr) int32
+	libusbClose                     func(handle uintptr)
+	libusbSetAutoDetachKernelDriver func(handle uintptr, enable int32) int32
+	libusbDetachKernelDriver        func(handle uintptr, iface int32) int32
+	libusbAttachKernelDriver        func(handle uintptr, iface int32) int32
+	libusbClaimInterface            func(handle uintptr, iface int32) int32
+	libusbReleaseInterface          func(handle uintptr, iface int32) int32
+	libusbSetInterfaceAltSetting    func(handle uintptr, iface int32, alt int32) int32
+	libusbResetDevice               func(handle uintptr) int32
+	libusbGetConfiguration          func(handle uintptr, config *int32) int32
+	libusbSetConfiguration          func(handle uintptr, config int32) int32
+	libusbClearHalt                 func(handle uintptr, endpoint uint8) int32
+	libusbControlTransfer           func(handle uintptr, rType, request uint8, val, idx uint16, data *byte, length uint16, timeout uint32) int32
+	libusbBulkTransfer              func(handle uintptr, endpoint uint8, data *byte, length int32, transferred *int32, timeout uint32) int32
+	libusbInterruptTransfer         func(handle uintptr, endpoint uint8, data *byte, length int32, transferred *int32, timeout uint32) int32
+	libusbOnce                      sync.Once
+	libusbErr                       error
+)
+
+// libusbLibraries are the names the shared library is looked up by, in order.
+var libusbLibraries = map[string][]string{
+	"linux":  {"libusb-1.0.so.0", "libusb-1.0.so"},
+	"darwin": {"libusb-1.0.0.dylib", "/opt/homebrew/lib/libusb-1.0.0.dylib", "/usr/local/lib/libusb-1.0.0.dylib"},
+}
+
+// Options and capabilities of libusb used by the bindings.
+const (
+	libusbOptionUseUsbDk             = 1
+	libusbOptionNoDeviceDiscovery    = 2
+	libusbCapHasHIDAccess            = 0x100
+	libusbCapSupportsDetachKernelDrv = 0x101
+)
+
+// libusbVersion mirrors struct libusb_version.
+type libusbVersion struct {
+	major, minor, micro, nano uint16
+	rc, describe              *byte
+}
+
+// libusbConfigDescriptor mirrors struct libusb_config_descriptor. The fields
+// up to MaxPower are laid out as on the wire, so they're kept as raw bytes.
+type libusbConfigDescriptor struct {
+	header      [configDescriptorSize]byte
+	interfaces  *libusbInterface
+	extra       *byte
+	extraLength int32
+}
+
+// libusbInterface mirrors struct libusb_interface.
+type libusbInterface struct {
+	altsetting    *libusbInterfaceDescriptor
+	numAltsetting int32
+}
+
+// libusbInterfaceDescriptor mirrors struct libusb_interface_descriptor, with
+// the wire format fields kept as raw bytes.
+type libusbInterfaceDescriptor struct {
+	header      [9]byte
+	endpoint    *libusbEndpointDescriptor
+	extra       *byte
+	extraLength int32
+}
+
+// libusbEndpointDescriptor mirrors struct libusb_endpoint_descriptor, with the
+// wire format fields (including the audio extension) kept as raw bytes.
+type libusbEndpointDescriptor struct {
+	header      [9]byte
+	extra       *byte
+	extraLength int32
+}
+
+// loadLibusb loads the shared library and binds the used functions. All
+// callers are protected by the package mutex, but the once keeps it simple.
+// A missing library, or one predating 1.0.23, fails every call of the backend.
+func loadLibusb() error {
+	libusbOnce.Do(func() {
+		var (
+			lib uintptr
+			err error
+		)
+		names := libusbLibraries[runtime.GOOS]
+		for _, name := range names {
+			if lib, err = purego.Dlopen(name, purego.RTLD_NOW|purego.RTLD_GLOBAL); err == nil {
+				break
+			}
+		}
+		if lib == 0 {
+			libusbErr = fmt.Errorf("failed to load libusb (%s): %v: %w", strings.Join(names, ", "), err, ErrNotSupported)
+			return
+		}
+		for _, fn := range []struct {
+			fptr interface{}
+			name string
+		}{
+			{&libusbInit, "libusb_init"},
+			{&libusbExit, "libusb_exit"},
+			{&libusbSetOption, "libusb_set_option"},
+			{&libusbSetDebug, "libusb_set_debug"},
+			{&libusbGetVersion, "libusb_get_version"},
+			{&libusbHasCapability, "libusb_has_capability"},
+			{&libusbGetDeviceList, "libusb_get_device_list"},
+			{&libusbFreeDeviceList, "libusb_free_device_list"},
+			{&libusbRefDevice, "libusb_ref_device"},
+			{&libusbUnrefDevice, "libusb_unref_device"},
+			{&libusbGetDeviceDescriptor, "libusb_get_device_descriptor"},
+			{&libusbGetConfigDescriptor, "libusb_get_config_descriptor"},
+			{&libusbFreeConfigDescriptor, "libusb_free_config_descriptor"},
+			{&libusbGetBusNumber, "libusb_get_bus_number"},
+			{&libusbGetPortNumber, "libusb_get_port_number"},
+			{&libusbGetPortNumbers, "libusb_get_port_numbers"},
+			{&libusbGetDeviceAddress, "libusb_get_device_address"},
+			{&libusbGetDeviceSpeed, "libusb_get_device_speed"},
+			{&libusbGetParent, "libusb_get_parent"},
+			{&libusbGetDevice, "libusb_get_device"},
+			{&libusbOpen, "libusb_open"},
+			{&libusbWrapSysDevice, "libusb_wrap_sys_device"},
+			{&libusbClose, "libusb_close"},
+			{&libusbSetAutoDetachKernelDriver, "libusb_set_auto_detach_kernel_driver"},
+			{&libusbDetachKernelDriver, "libusb_detach_kernel_driver"},
+			{&libusbAttachKernelDriver, "libusb_attach_kernel_driver"},
+			{&libusbClaimInterface, "libusb_claim_interface"},
+			{&libusbReleaseInterface, "libusb_release_interface"},
+			{&libusbSetInterfaceAltSetting, "libusb_set_interface_alt_setting"},
+			{&libusbResetDevice, "libusb_reset_device"},
+			{&libusbGetConfiguration, "libusb_get_configuration"},
+			{&libusbSetConfiguration, "libusb_set_configuration"},
+			{&libusbClearHalt, "libusb_clear_halt"},
+			{&libusbControlTransfer, "libusb_control_transfer"},
+			{&libusbBulkTransfer, "libusb_bulk_transfer"},
+			{&libusbInterruptTransfer, "libusb_interrupt_transfer"},
+		} {
+			// Binding a missing symbol panics, look it up first
+			sym, err := purego.Dlsym(lib, fn.name)
+			if err != nil {
+				libusbErr = fmt.Errorf("failed to load libusb: missing %s, version 1.0.23 or later required: %w", fn.name, ErrNotSupported)
+				return
+			}
+			purego.RegisterFunc(fn.fptr, sym)
+		}
+	})
+	return libusbErr
+}
+
+// fromLibusbErrno converts a raw libusb Error into a Go type.
+func fromLibusbErrno(errno int32) error {
+	err := libusbError(errno)
+	if err == libusbSuccess {
+		return nil
+	}
+	return err
+}
+
+// goString copies a NUL terminated C string.
+func goString(str *byte) string {
+	if str == nil {
+		return ""
+	}
+	var n int
+	for *(*byte)(unsafe.Add(unsafe.Pointer(str), n)) != 0 {
+		n++
+	}
+	return string(unsafe.Slice(str, n))
+}
+
+// dlopenContext is the libusb context of the runtime loaded library, along with
+// the devices opened through it.
+type dlopenContext struct {
+	ctx     uintptr
+	epoch   int                    // Number of times the context was closed, invalidating device references
+	devices map[*dlopenDevice]bool // Devices opened through the context
+	hubs    int                    // Number of parent hub handles open
+	lock    sync.Mutex
+}
+
+// dlopenCtx is the global context devices are accessed through.
+var dlopenCtx dlopenContext
+
+// contextOpts are the options the global context is initialized with.
+var contextOpts contextOptions
+
+// configure replaces the options of the global context, which must not be
+// initialized yet.
+func configure(opts contextOptions) error {
+	if dlopenCtx.ctx != 0 {
+		if opts.equal(contextOpts) {
+			return nil
+		}
+		return errors.New("failed to configure libusb: context already initialized")
+	}
+	contextOpts = opts
+	return nil
+}
+
+// checkContext fails with ErrNotInitialized unless Init loaded the library and
+// set up the global libusb context.
+func checkContext() error {
+	if dlopenCtx.ctx == 0 {
+		return ErrNotInitialized
+	}
+	return nil
+}
+
+// initContext loads the library and initializes the global libusb context for
+// Init, unless it is already, reporting the version of libusb and the options
+// along failures. Init holds the package mutex, so it's fine to do the check
+// and init.
+func initContext() error {
+	if err := loadLibusb(); err != nil {
+		return err
+	}
+	if dlopenCtx.ctx != 0 {
+		return nil
+	}
+	if err := newContext(); err != nil {
+		_, version := backendVersion()
+		return fmt.Errorf("failed to initialize libusb %s (%s): %w", version, contextOpts, err)
+	}
+	return nil
+}
+
+// newContext initializes the global libusb context with the configured options.
+func newContext() error {
+	if contextOpts.noDiscovery {
+		libusbSetOption(0, libusbOptionNoDeviceDiscovery)
+	}
+	var ctx uintptr
+	if err := fromLibusbErrno(libusbInit(&ctx)); err != nil {
+		return err
+	}
+	if contextOpts.usbdk {
+		if err := fromLibusbErrno(libusbSetOption(ctx, libusbOptionUseUsbDk)); err != nil {
+			libusbExit(ctx)
+			return fmt.Errorf("failed to enable UsbDk: %w", err)
+		}
+	}
+	// libusb_set_option is variadic, which purego can't pass an argument to on
+	// every platform, so the log level is set through its predecessor
+	if level := contextOpts.logLevel; level != nil {
+		libusbSetDebug(ctx, int32(*level))
+	}
+	dlopenCtx.lock.Lock()
+	dlopenCtx.ctx = ctx
+	dlopenCtx.lock.Unlock()
+
+	return nil
+}
+
+// backendVersion reports libusb as the backend, along with the version of the
+// library loaded, which is empty if it can't be.
+func backendVersion() (string, string) {
+	if loadLibusb() != nil {
+		return "libusb", ""
+	}
+	v := libusbGetVersion()
+	return "libusb", fmt.Sprintf("%d.%d.%d.%d%s", v.major, v.minor, v.micro, v.nano, goString(v.rc))
+}
+
+// exit closes the devices still open and exits libusb.
+func exit() error {
+	dlopenCtx.lock.Lock()
+	devices := make([]*dlopenDevice, 0, len(dlopenCtx.devices))
+	for dev := range dlopenCtx.devices {
+		devices = append(devices, dev)
+	}
+	dlopenCtx.lock.Unlock()
+
+	for _, dev := range devices {
+		dev.Close()
+	}
+	dlopenCtx.lock.Lock()
+	defer dlopenCtx.lock.Unlock()
+
+	if dlopenCtx.hubs > 0 {
+		return fmt.Errorf("failed to exit libusb: %d hub handles open", dlopenCtx.hubs)
+	}
+	if dlopenCtx.ctx != 0 {
+		libusbExit(dlopenCtx.ctx)
+		dlopenCtx.ctx = 0
+		dlopenCtx.epoch++
+	}
+	return nil
+}
+
+// hasCapability queries the capabilities of the loaded library. Hotplug
+// notifications need callbacks from libusb threads, which aren't bound.
+func hasCapability(capability Capability) bool {
+	if loadLibusb() != nil {
+		return false
+	}
+	switch capability {
+	case CapabilityHIDAccess:
+		return libusbHasCapability(libusbCapHasHIDAccess) != 0
+	case CapabilityDetachKernelDriver:
+		return libusbHasCapability(libusbCapSupportsDetachKernelDrv) != 0
+	}
+	return false
+}
+
+// onHotplug is unsupported by the runtime loaded backend.
+func onHotplug(handler HotplugHandler) (func(), error) {
+	return nil, fmt.Errorf("failed to register hotplug callback: %w", ErrNotSupported)
+}
+
+// deviceList retrieves the devices known to libusb, to be freed by the caller.
+func deviceList() ([]uintptr, *uintptr, error) {
+	if err := checkContext(); err != nil {
+		return nil, nil, err
+	}
+	var list *uintptr
+	count := libusbGetDeviceList(dlopenCtx.ctx, &list)
+	if count < 0 {
+		return nil, nil, libusbError(count)
+	}
+	return unsafe.Slice(list, count), list, nil
+}
+
+// getAllDevices is the internal device enumerator returning every device
+// interface accepted by the match predicate. HID devices and interfaces are
+// only included if requested.
+func getAllDevices(match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	return enumerateDevices(0, 0, match, hid)
+}
+
+// findDevices is the internal device enumerator of Find, returning every
+// interface of the devices with the given vendor and product IDs, zero
+// matching any.
+func findDevices(vendorID ID, productID ID, hid bool) ([]DeviceInfo, error) {
+	return enumerateDevices(vendorID, productID, matchIDs(vendorID, productID), hid)
+}
+
+// enumerateDevices returns the interfaces accepted by the match predicate of
+// the devices with the given vendor and product IDs, zero matching any. Other
+// devices are skipped on their device descriptor, which libusb has cached,
+// without parsing their configurations.
+func enumerateDevices(vendorID ID, productID ID, match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	devices, list, err := deviceList()
+	if err != nil {
+		return nil, err
+	}
+	defer libusbFreeDeviceList(list, 1)
+
+	var infos []DeviceInfo
+	for devnum, dev := range devices {
+		if vendorID != 0 || productID != 0 {
+			// Descriptors failing to be read are reported by describeDevice
+			var desc [deviceDescriptorSize]byte
+			if libusbGetDeviceDescriptor(dev, &desc) == 0 && !matchIDs(vendorID, productID)(DeviceInfo{VendorID: uint16(desc[8]) | uint16(desc[9])<<8, ProductID: uint16(desc[10]) | uint16(desc[11])<<8}) {
+				continue
+			}
+		}
+		devInfos, err := describeDevice(dev, devnum, match, hid)
+		infos = append(infos, devInfos...)
+		if err != nil {
+			return infos, err
+		}
+	}
+	return infos, nil
+}
+
+// dlopenRef is a reference to a libusb device shared by the infos enumerated
+// from it, keeping the device alive so it can be opened without enumerating
+// the bus again. The reference is dropped once no info holds it anymore.
+type dlopenRef struct {
+	dev   uintptr
+	epoch int // Epoch of the context the device belongs to
+}
+
+// newDlopenRef references a libusb device until the returned wrapper is garbage
+// collected.
+func newDlopenRef(dev uintptr) *dlopenRef {
+	libusbRefDevice(dev)
+
+	dlopenCtx.lock.Lock()
+	ref := &dlopenRef{dev: dev, epoch: dlopenCtx.epoch}
+	dlopenCtx.lock.Unlock()
+
+	runtime.SetFinalizer(ref, func(ref *dlopenRef) {
+		// Devices of a closed context are gone with it, leave them be
+		if ref.valid() {
+			libusbUnrefDevice(ref.dev)
+		}
+	})
+	return ref
+}
+
+// valid reports whether the referenced device belongs to the current context.
+func (ref *dlopenRef) valid() bool {
+	dlopenCtx.lock.Lock()
+	defer dlopenCtx.lock.Unlock()
+
+	return ref.epoch == dlopenCtx.epoch
+}
+
+// describeDevice converts the interfaces of a libusb device accepted by the
+// match predicate into device infos. The configurations parsed by libusb are
+// reassembled into raw descriptors, which are parsed like on other backends.
+// Matched devices are referenced by the returned infos.
+func describeDevice(dev uintptr, devnum int, match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	var desc [deviceDescriptorSize]byte
+	if err := fromLibusbErrno(libusbGetDeviceDescriptor(dev, &desc)); err != nil {
+		return nil, fmt.Errorf("failed to get device %d descriptor: %w", devnum, err)
+	}
+	base := deviceInfo(dev, &desc)
+
+	// Skip HID devices, they are handled directly by OS libraries
+	if !hid && Class(base.Class) == ClassHID {
+		logger().Debug("usb: skipped HID device", "vendor", ID(base.VendorID), "product", ID(base.ProductID))
+		return nil, nil
+	}
+	config := func(cfgnum int) ([]byte, error) {
+		var cfg *libusbConfigDescriptor
+		if err := fromLibusbErrno(libusbGetConfigDescriptor(dev, uint8(cfgnum), &cfg)); err != nil {
+			return nil, err
+		}
+		defer libusbFreeConfigDescriptor(cfg)
+
+		return rawConfig(cfg), nil
+	}
+	ref := func() interface{} { return newDlopenRef(dev) }
+
+	return describeConfigs(base, devnum, int(desc[deviceDescriptorSize-1]), config, ref, match, hid)
+}
+
+// rawConfig reassembles the wire format of a configuration descriptor parsed
+// by libusb, which keeps the descriptors it doesn't model (class specific ones,
+// SuperSpeed companions) as extra bytes after the one they follow.
+func rawConfig(cfg *libusbConfigDescriptor) []byte {
+	raw := appendDescriptor(nil, cfg.header[:], cfg.extra, cfg.extraLength)
+	for _, iface := range unsafe.Slice(cfg.interfaces, int(cfg.header[4])) {
+		for _, alt := range unsafe.Slice(iface.altsetting, int(iface.numAltsetting)) {
+			raw = appendDescriptor(raw, alt.header[:], alt.extra, alt.extraLength)
+			for _, end := range unsafe.Slice(alt.endpoint, int(alt.header[4])) {
+				raw = appendDescriptor(raw, end.header[:], end.extra, end.extraLength)
+			}
+		}
+	}
+	raw[2], raw[3] = byte(len(raw)), byte(len(raw)>>8)
+	return raw
+}
+
+// appendDescriptor appends a descriptor of its original length, capped to the
+// fields libusb keeps, followed by its extra descriptors.
+func appendDescriptor(raw []byte, header []byte, extra *byte, extraLength int32) []byte {
+	n := int(header[0])
+	if n > len(header) {
+		n = len(header)
+	}
+	raw = append(raw, byte(n))
+	raw = append(raw, header[1:n]...)
+	if extra != nil && extraLength > 0 {
+		raw = append(raw, unsafe.Slice(extra, extraLength)...)
+	}
+	return raw
+}
+
+// deviceInfo converts the device descriptor of a libusb device into an info,
+// leaving the interface fields unset.
+func deviceInfo(dev uintptr, desc *[deviceDescriptorSize]byte) DeviceInfo {
+	port := libusbGetPortNumber(dev)
+
+	slot := port
+	if slot == 0 {
+		slot = libusbGetDeviceAddress(dev)
+	}
+	chain := portChain(dev)
+	bus := libusbGetBusNumber(dev)
+
+	// Only Linux names devices after their place in the topology in sysfs
+	var syspath string
+	if runtime.GOOS == "linux" {
+		syspath = sysfsPath(bus, chain)
+	}
+	return DeviceInfo{
+		Path:       DevicePath{Bus: bus, Ports: chain, Interface: -1}.String(),
+		SysPath:    syspath,
+		VendorID:   uint16(desc[8]) | uint16(desc[9])<<8,
+		ProductID:  uint16(desc[10]) | uint16(desc[11])<<8,
+		Release:    uint16(desc[12]) | uint16(desc[13])<<8,
+		USBVersion: uint16(desc[2]) | uint16(desc[3])<<8,
+		Class:      desc[4],
+		SubClass:   desc[5],
+		Protocol:   desc[6],
+		Bus:        bus,
+		Port:       port,
+		Speed:      Speed(libusbGetDeviceSpeed(dev)),
+		libusbPort: &slot,
+
+		ManufacturerIndex: desc[14],
+		ProductIndex:      desc[15],
+		SerialIndex:       desc[16],
+	}
+}
+
+// portChain returns the hub ports leading from the root hub to a device, empty
+// for root hubs.
+func portChain(dev uintptr) []uint8 {
+	var ports [7]uint8 // USB 3.0 limits the depth of hub chains to 7
+
+	n := int(libusbGetPortNumbers(dev, &ports[0], int32(len(ports))))
+	if n <= 0 {
+		return nil
+	}
+	return append([]uint8{}, ports[:n]...)
+}
+
+// sysfsPath returns the directory of a device in the Linux sysfs, named after
+// the bus and the chain of hub ports leading to it (e.g. 1-4.2), or usbN for
+// the root hub of bus N.
+func sysfsPath(bus uint8, chain []uint8) string {
+	if len(chain) == 0 {
+		return fmt.Sprintf("/sys/bus/usb/devices/usb%d", bus)
+	}
+	return "/sys/bus/usb/devices/" + DevicePath{Bus: bus, Ports: chain, Interface: -1}.String()
+}
+
+// listDevices is the internal device lister returning every device accepted by
+// the match predicate. Only the device descriptors are read, the configurations
+// are parsed when the interfaces are resolved.
+func listDevices(match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	devices, list, err := deviceList()
+	if err != nil {
+		return nil, err
+	}
+	defer libusbFreeDeviceList(list, 1)
+
+	var infos []DeviceInfo
+	for devnum, dev := range devices {
+		var desc [deviceDescriptorSize]byte
+		if err := fromLibusbErrno(libusbGetDeviceDescriptor(dev, &desc)); err != nil {
+			return infos, fmt.Errorf("failed to get device %d descriptor: %w", devnum, err)
+		}
+		info := deviceInfo(dev, &desc)
+		info.unresolved = true
+
+		// Skip HID devices, they are handled directly by OS libraries
+		if !hid && Class(info.Class) == ClassHID {
+			continue
+		}
+		if !match(info) {
+			continue
+		}
+		info.libusbDevice = newDlopenRef(dev)
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// describeInterfaces parses the configurations of a listed device into the
+// infos of its interfaces. Devices not listed by libusb are enumerated again.
+func describeInterfaces(info DeviceInfo, hid bool) ([]DeviceInfo, error) {
+	ref, ok := info.libusbDevice.(*dlopenRef)
+	if !ok || !ref.valid() {
+		return interfacesOf(info, hid)
+	}
+	return describeDevice(ref.dev, 0, func(DeviceInfo) bool { return true }, hid)
+}
+
+// topology lists every device and links them to their parent hubs, which
+// libusb resolves from the same device list.
+func topology() ([]*TopologyNode, error) {
+	devices, list, err := deviceList()
+	if err != nil {
+		return nil, err
+	}
+	defer libusbFreeDeviceList(list, 1)
+
+	nodes := make([]*TopologyNode, len(devices))
+	index := make(map[uintptr]int, len(devices))
+
+	for devnum, dev := range devices {
+		var desc [deviceDescriptorSize]byte
+		if err := fromLibusbErrno(libusbGetDeviceDescriptor(dev, &desc)); err != nil {
+			return nil, fmt.Errorf("failed to get device %d descriptor: %w", devnum, err)
+		}
+		info := deviceInfo(dev, &desc)
+		info.unresolved = true
+		info.libusbDevice = newDlopenRef(dev)
+
+		node := &TopologyNode{Info: info, Depth: len(portChain(dev))}
+		if node.Hub() {
+			node.Ports = make([]*TopologyNode, hubPorts(dev, info))
+		}
+		nodes[devnum], index[dev] = node, devnum
+	}
+	parents := make([]int, len(devices))
+	for devnum, dev := range devices {
+		parents[devnum] = -1
+		if parent, ok := index[libusbGetParent(dev)]; ok {
+			parents[devnum] = parent
+		}
+	}
+	return linkTopology(nodes, parents), nil
+}
+
+// hubPorts returns the number of downstream ports of a hub from its hub
+// descriptor, zero if it can't be read, e.g. for lack of access to the hub.
+func hubPorts(dev uintptr, info DeviceInfo) int {
+	var handle uintptr
+	if err := fromLibusbErrno(libusbOpen(dev, &handle)); err != nil {
+		logger().Debug("usb: failed to open hub", "bus", info.Bus, "port", info.Port, "err", err)
+		return 0
+	}
+	defer libusbClose(handle)
+
+	n, err := hubPortCount(handleControl(handle), info.USBVersion >= 0x0300)
+	if err != nil {
+		logger().Debug("usb: failed to read hub ports", "bus", info.Bus, "port", info.Port, "err", err)
+		return 0
+	}
+	return n
+}
+
+// handleControl returns a function issuing hub class requests on a handle.
+func handleControl(handle uintptr) controlFunc {
+	return func(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+		n := libusbControlTransfer(handle, rType, request, val, idx, bufferPtr(data), uint16(len(data)), hubTimeout)
+		runtime.KeepAlive(data)
+		if n < 0 {
+			return 0, fromLibusbErrno(n)
+		}
+		return int(n), nil
+	}
+}
+
+// dlopenDevice is a USB device handle opened through the runtime loaded libusb.
+// Transfers use the synchronous API of libusb, which handles the events of the
+// transfer itself, so no event loop is needed.
+type dlopenDevice struct {
+	libusbHandle // Claims, timeouts and transfers shared with the cgo binding
+
+	handle uintptr      // Low level USB device to communicate through, zero when closed
+	try    tryEmulation // Transfers of TryRead and TryWrite
+}
+
+// open connects to a libusb device by its path name. The device referenced by
+// the enumeration is opened directly, the bus is only enumerated again if it
+// disappeared meanwhile (e.g. replugged) or the info wasn't enumerated here.
+func open(info DeviceInfo, opts *openOptions) (*dlopenDevice, error) {
+	if err := checkContext(); err != nil {
+		return nil, err
+	}
+	if ref, ok := info.libusbDevice.(*dlopenRef); ok && ref.valid() {
+		var handle uintptr
+		err := fromLibusbErrno(libusbOpen(ref.dev, &handle))
+		if err == nil {
+			return claimDevice(info, handle, opts.noDetach)
+		}
+		if err == libusbErrAccess {
+			return nil, accessError(ref.dev, err)
+		}
+		if !errors.Is(err, ErrNoDevice) {
+			return nil, fmt.Errorf("failed to open device: %w", err)
+		}
+	}
+	matches, err := findDevices(ID(info.VendorID), ID(info.ProductID), true)
+	if err != nil {
+		return nil, err
+	}
+	for _, match := range matches {
+		if devicePath(match.Path) != devicePath(info.Path) || match.Interface != info.Interface {
+			continue
+		}
+		info.libusbDevice = match.libusbDevice
+
+		dev := match.libusbDevice.(*dlopenRef).dev
+
+		var handle uintptr
+		if err := fromLibusbErrno(libusbOpen(dev, &handle)); err != nil {
+			if err == libusbErrAccess {
+				return nil, accessError(dev, err)
+			}
+			return nil, fmt.Errorf("failed to open device: %w", err)
+		}
+		return claimDevice(info, handle, opts.noDetach)
+	}
+	return nil, fmt.Errorf("failed to open device: %w", ErrNoDevice)
+}
+
+// accessError details a denied libusb_open with the usbfs node of the device
+// on Linux and a hint on granting access on the platform.
+func accessError(dev uintptr, err error) error {
+	if runtime.GOOS != "linux" {
+		return newAccessError("", "run as root or unload the kernel extension holding the device", err)
+	}
+	node := fmt.Sprintf("/dev/bus/usb/%03d/%03d", libusbGetBusNumber(dev), libusbGetDeviceAddress(dev))
+	return newAccessError(node, "add a udev rule granting access (see zerousb udev) or run as root", err)
+}
+
+// openFD wraps an usbfs file descriptor into a libusb device handle and claims
+// the first interface suitable for reading and writing.
+func openFD(fd int) (*dlopenDevice, error) {
+	return wrapSysDevice(uintptr(fd), new(openOptions))
+}
+
+// openPath opens a usbfs device node and wraps it, closing the node along with
+// the device.
+func openPath(path string, opts *openOptions) (Device, DeviceInfo, error) {
+	node, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrPermission) {
+		return nil, DeviceInfo{}, newAccessError(path, "add a udev rule granting access (see zerousb udev) or run as root", err)
+	}
+	if err != nil {
+		return nil, DeviceInfo{}, fmt.Errorf("failed to open device: %w", err)
+	}
+	dev, err := wrapSysDevice(node.Fd(), opts)
+	if err != nil {
+		node.Close()
+		return nil, DeviceInfo{}, err
+	}
+	dev.node = node
+	return dev, dev.DeviceInfo, nil
+}
+
+// wrapSysDevice wraps a system device handle into a libusb device handle and
+// claims the first interface suitable for reading and writing.
+func wrapSysDevice(fd uintptr, opts *openOptions) (*dlopenDevice, error) {
+	if err := checkContext(); err != nil {
+		return nil, err
+	}
+	var handle uintptr
+	if err := fromLibusbErrno(libusbWrapSysDevice(dlopenCtx.ctx, fd, &handle)); err != nil {
+		return nil, fmt.Errorf("failed to wrap device: %w", err)
+	}
+	first := true
+	infos, err := describeDevice(libusbGetDevice(handle), 0, func(DeviceInfo) bool {
+		defer func() { first = false }()
+		return first
+	}, true)
+	if err == nil && len(infos) == 0 {
+		err = errors.New("no interface with in and out endpoints")
+	}
+	if err != nil {
+		libusbClose(handle)
+		return nil, fmt.Errorf("failed to open device: %w", err)
+	}
+	return claimDevice(infos[0], handle, opts.noDetach)
+}
+
+// openParentHub opens the hub a device is attached to, leaving the interfaces
+// of the hub to its kernel driver. The device is looked up again if the info
+// wasn't enumerated by the current context.
+func openParentHub(info DeviceInfo) (*parentHub, error) {
+	ref, ok := info.libusbDevice.(*dlopenRef)
+	if !ok || !ref.valid() {
+		matches, err := listDevices(func(match DeviceInfo) bool { return sameDevice(match, info) }, true)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("failed to find parent hub: %w", ErrNoDevice)
+		}
+		ref = matches[0].libusbDevice.(*dlopenRef)
+	}
+	parent := libusbGetParent(ref.dev)
+	if parent == 0 {
+		return nil, fmt.Errorf("failed to find parent hub: device is a root hub: %w", ErrNotFound)
+	}
+	var handle uintptr
+	if err := fromLibusbErrno(libusbOpen(parent, &handle)); err != nil {
+		return nil, fmt.Errorf("failed to open parent hub: %w", err)
+	}
+	// The handle may outlive the package lock while power cycling, keep the
+	// context from being exited under it
+	dlopenCtx.lock.Lock()
+	dlopenCtx.hubs++
+	dlopenCtx.lock.Unlock()
+
+	return &parentHub{
+		control: handleControl(handle),
+		port:    libusbGetPortNumber(ref.dev),
+		close: func() {
+			libusbClose(handle)
+
+			dlopenCtx.lock.Lock()
+			dlopenCtx.hubs--
+			dlopenCtx.lock.Unlock()
+		},
+	}, nil
+}
+
+// claimDevice wraps an opened libusb handle, detaching any kernel driver unless
+// asked not to and claiming the interface the device was enumerated on.
+func claimDevice(info DeviceInfo, handle uintptr, noDetach bool) (*dlopenDevice, error) {
+	dev := newDlopenDevice(info, handle, noDetach)
+	if err := dev.claimDevice(); err != nil {
+		libusbClose(handle)
+		return nil, err
+	}
+	dlopenCtx.lock.Lock()
+	if dlopenCtx.devices == nil {
+		dlopenCtx.devices = make(map[*dlopenDevice]bool)
+	}
+	dlopenCtx.devices[dev] = true
+	dlopenCtx.lock.Unlock()
+
+	// Release the claims and the handle of devices gone, once the transfers in
+	// flight failed, instead of leaving it to the user
+	dev.OnDisconnect(func() { dev.Close() })
+	return dev, nil
+}
+
+// newDlopenDevice wraps a libusb handle, binding the primitives the shared
+// handle logic runs on to the loaded symbols.
+func newDlopenDevice(info DeviceInfo, handle uintptr, noDetach bool) *dlopenDevice {
+	dev := &dlopenDevice{handle: handle}
+	dev.libusbHandle = libusbHandle{
+		DeviceInfo:     info,
+		controlTimeout: timeoutMillis(defaultControlTimeout),
+		reattach:       true,
+		noDetach:       noDetach,
+	}
+	dev.ops = libusbOps{
+		closed: func() bool { return dev.handle == 0 },
+		claim: func(iface int) error {
+			return fromLibusbErrno(libusbClaimInterface(dev.handle, int32(iface)))
+		},
+		release: func(iface int) error {
+			return fromLibusbErrno(libusbReleaseInterface(dev.handle, int32(iface)))
+		},
+		detach: func(iface int) error {
+			return fromLibusbErrno(libusbDetachKernelDriver(dev.handle, int32(iface)))
+		},
+		attach: func(iface int) error {
+			return fromLibusbErrno(libusbAttachKernelDriver(dev.handle, int32(iface)))
+		},
+		setAutoDetach: func(enable bool) error {
+			var val int32
+			if enable {
+				val = 1
+			}
+			return fromLibusbErrno(libusbSetAutoDetachKernelDriver(dev.handle, val))
+		},
+		setAlt: func(iface int, alt int) error {
+			return fromLibusbErrno(libusbSetInterfaceAltSetting(dev.handle, int32(iface), int32(alt)))
+		},
+		reset: func() error {
+			return fromLibusbErrno(libusbResetDevice(dev.handle))
+		},
+		setConfig: func(config int) error {
+			return fromLibusbErrno(libusbSetConfiguration(dev.handle, int32(config)))
+		},
+		getConfig: func() (int, error) {
+			var config int32
+			err := fromLibusbErrno(libusbGetConfiguration(dev.handle, &config))
+			return int(config), err
+		},
+		clearHalt: func(endpoint uint8) error {
+			return fromLibusbErrno(libusbClearHalt(dev.handle, endpoint))
+		},
+		control: func(rType, request uint8, val, idx uint16, data []byte, timeout int) (int, error) {
+			n := libusbControlTransfer(dev.handle, rType, request, val, idx, bufferPtr(data), uint16(len(data)), uint32(timeout))
+			runtime.KeepAlive(data)
+
+			if n < 0 {
+				return 0, fromLibusbErrno(n)
+			}
+			return int(n), nil
+		},
+		transfer: func(endpoint uint8, kind TransferType, b []byte, timeout int) (int, error) {
+			fn := libusbBulkTransfer
+			if kind == TransferTypeInterrupt {
+				fn = libusbInterruptTransfer
+			}
+			var transferred int32
+			err := fromLibusbErrno(fn(dev.handle, endpoint, bufferPtr(b), int32(len(b)), &transferred, uint32(timeout)))
+			runtime.KeepAlive(b)
+
+			return int(transferred), err
+		},
+	}
+	return dev
+}
+
+// Close releases the claimed interfaces and the device handle.
+func (dev *dlopenDevice) Close() error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.handle != 0 {
+		dev.releaseDevice()
+		libusbClose(dev.handle)
+		dev.handle = 0
+		if dev.node != nil {
+			dev.node.Close()
+			dev.node = nil
+		}
+		dlopenCtx.lock.Lock()
+		delete(dlopenCtx.devices, dev)
+		dlopenCtx.lock.Unlock()
+	}
+	dev.end(ErrDeviceClosed)
+
+	return nil
+}
+
+// TryRead collects the data of a read queued by an earlier call without
+// blocking, queuing one if there's none in flight. The backend has no
+// asynchronous transfers, so the read runs on a goroutine.
+func (dev *dlopenDevice) TryRead(b []byte) (int, error) {
+	return dev.try.tryRead(b, dev.Read)
+}
+
+// TryWrite queues a write of b without blocking, unless the previous one is in
+// flight. The backend has no asynchronous transfers, so the write runs on a
+// goroutine.
+func (dev *dlopenDevice) TryWrite(b []byte) (int, error) {
+	return dev.try.tryWrite(b, dev.Write)
+}
+
+// AcquireBuffer returns a transfer buffer of the given size. Device memory
+// isn't bound, so it's a plain Go buffer.
+func (dev *dlopenDevice) AcquireBuffer(size int) (*Buffer, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+
+	if dev.handle == 0 {
+		return nil, dev.closedErr()
+	}
+	return NewBuffer(size), nil
+}
+
+// AllocBulkStreams is unsupported, streams need the asynchronous API of libusb, which isn't bound.
+func (dev *dlopenDevice) AllocBulkStreams(count int, endpoints ...uint8) (int, error) {
+	return 0, fmt.Errorf("failed to allocate bulk streams: %w", ErrNotSupported)
+}
+
+// FreeBulkStreams is unsupported, streams need the asynchronous API of libusb, which isn't bound.
+func (dev *dlopenDevice) FreeBulkStreams(endpoints ...uint8) error {
+	return fmt.Errorf("failed to free bulk streams: %w", ErrNotSupported)
+}
+
+// OpenBulkStream is unsupported, streams need the asynchronous API of libusb, which isn't bound.
+func (dev *dlopenDevice) OpenBulkStream(endpoint uint8, streamID uint32) (*BulkStream, error) {
+	return nil, fmt.Errorf("failed to open bulk stream: %w", ErrNotSupported)
+}
+
+// BOS retrieves the Binary Object Store descriptor of the device, exposing its
+// USB 2.0 extension, SuperSpeed and container ID capabilities.
+func (dev *dlopenDevice) BOS() (*BOSDescriptor, error) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.handle == 0 {
+		return nil, dev.closedErr()
+	}
+	return readBOS(dev.getDescriptor)
+}
+
+// bufferPtr returns the pointer of a transfer buffer, which is nil for zero
+// length transfers (e.g. zero length packets terminating a bulk transfer).
+func bufferPtr(b []byte) *byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return &b[0]
+}
@@ -0,0 +1,45 @@
+//go:build !cgo && (amd64 || arm64) && (linux || (darwin && !ios && !iokit))
+
+package zerousb
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Tests that configurations parsed by libusb are reassembled into their wire
+// format, with the extra descriptors following the ones they were found after.
+func TestRawConfig(t *testing.T) {
+	classSpecific := []byte{0x05, 0x24, 0x00, 0x10, 0x01}
+	companion := []byte{0x06, 0x30, 0x0f, 0x00, 0x00, 0x00}
+
+	ends := []libusbEndpointDescriptor{
+		{header: [9]byte{0x07, 0x05, 0x81, 0x02, 0x00, 0x04, 0x00}, extra: &companion[0], extraLength: int32(len(companion))},
+		{header: [9]byte{0x07, 0x05, 0x02, 0x02, 0x00, 0x04, 0x00}},
+	}
+	alts := []libusbInterfaceDescriptor{
+		{header: [9]byte{0x09, 0x04, 0x00, 0x00, 0x02, 0xff, 0x42, 0x01, 0x00}, endpoint: &ends[0], extra: &classSpecific[0], extraLength: int32(len(classSpecific))},
+	}
+	ifaces := []libusbInterface{{altsetting: &alts[0], numAltsetting: 1}}
+	cfg := &libusbConfigDescriptor{header: [9]byte{0x09, 0x02, 0xff, 0xff, 0x01, 0x01, 0x00, 0x80, 0xfa}, interfaces: &ifaces[0]}
+
+	want := []byte{
+		0x09, 0x02, 0x2b, 0x00, 0x01, 0x01, 0x00, 0x80, 0xfa,
+		0x09, 0x04, 0x00, 0x00, 0x02, 0xff, 0x42, 0x01, 0x00,
+		0x05, 0x24, 0x00, 0x10, 0x01,
+		0x07, 0x05, 0x81, 0x02, 0x00, 0x04, 0x00,
+		0x06, 0x30, 0x0f, 0x00, 0x00, 0x00,
+		0x07, 0x05, 0x02, 0x02, 0x00, 0x04, 0x00,
+	}
+	raw := rawConfig(cfg)
+	if !bytes.Equal(raw, want) {
+		t.Fatalf("raw config mismatch:\nhave %x\nwant %x", raw, want)
+	}
+	infos, err := describeConfig(DeviceInfo{Path: "1-2"}, raw, false)
+	if err != nil {
+		t.Fatalf("failed to parse raw config: %v", err)
+	}
+	if len(infos) != 1 || *infos[0].libusbReader != 0x81 || *infos[0].libusbWriter != 0x02 || infos[0].Path != "1-2:0" {
+		t.Errorf("interfaces mismatch: have %+v", infos)
+	}
+}
@@ -0,0 +1,629 @@
+//go:build (freebsd && cgo) || (linux && cgo) || (openbsd && cgo) || (netbsd && cgo) || (darwin && !ios && cgo && !iokit) || (windows && cgo && !winusb) || (!cgo && (amd64 || arm64) && (linux || (darwin && !ios && !iokit)))
+
+package zerousb
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// libusbOps are the primitives a libusb binding implements on an open device
+// handle. They return the raw libusb errors, the logic shared by the bindings
+// wraps them.
+type libusbOps struct {
+	closed        func() bool // Reports whether the handle was closed
+	claim         func(iface int) error
+	release       func(iface int) error
+	detach        func(iface int) error
+	attach        func(iface int) error
+	setAutoDetach func(enable bool) error
+	setAlt        func(iface int, alt int) error
+	reset         func() error
+	setConfig     func(config int) error
+	getConfig     func() (int, error)
+	clearHalt     func(endpoint uint8) error
+	control       func(rType, request uint8, val, idx uint16, data []byte, timeout int) (int, error)
+	transfer      func(endpoint uint8, kind TransferType, b []byte, timeout int) (int, error)
+}
+
+// libusbHandle is the part of an open libusb device shared by the bindings: the
+// interface claims, the timeouts and the retried Read and Write transfers, run
+// through the primitives of the binding.
+type libusbHandle struct {
+	DeviceInfo // Embed the infos for easier access
+	lifetime   // Signals the device being closed or disconnected
+
+	ops         libusbOps    // Primitives of the binding on the handle
+	lock        sync.RWMutex // Guards the handle and interface state, held shared by transfers
+	readLock    sync.Mutex   // Serializes transfers on the IN endpoint
+	writeLock   sync.Mutex   // Serializes transfers on the OUT endpoint
+	controlLock sync.Mutex   // Serializes control requests
+
+	writeTimeout   int
+	readTimeout    int
+	controlTimeout int
+	retry          *RetryPolicy // Retry policy of Read and Write, nil for none
+	metrics        Metrics      // Metrics of Read and Write, nil for none
+
+	detached bool // Whether we detached a kernel driver from the claimed interface
+	noDetach bool // Whether kernel drivers are left bound, failing claims of their interfaces
+	reattach bool // Whether to give the interface back to the kernel driver on close
+
+	claimed map[int]bool // Additionally claimed interfaces, mapped to whether a kernel driver was detached
+	alts    map[int]int  // Alternate settings activated on the claimed interfaces, restored after resets
+	node    *os.File     // Device node opened by OpenPath, closed along with the handle
+}
+
+// claimDevice detaches any kernel driver from the interface the device was
+// opened on unless asked not to, then claims it.
+func (dev *libusbHandle) claimDevice() error {
+	if !dev.noDetach {
+		dev.SetAutoDetach(1)
+		if err := dev.DetachKernelDriver(); err != nil {
+			logger().Warn("usb: failed to detach kernel driver", "device", dev.Path, "interface", dev.Interface, "err", err)
+		} else if dev.detached {
+			logger().Debug("usb: detached kernel driver", "device", dev.Path, "interface", dev.Interface)
+		}
+	}
+	if err := dev.ops.claim(dev.Interface); err != nil {
+		return fmt.Errorf("failed to claim interface: %w", err)
+	}
+	return nil
+}
+
+// releaseDevice releases the claimed interfaces ahead of closing the handle,
+// giving the interface the device was opened on back to its kernel driver if
+// configured to.
+func (dev *libusbHandle) releaseDevice() {
+	for iface := range dev.claimed {
+		dev.releaseInterface(iface)
+	}
+	dev.releaseClaim(dev.Interface)
+	if dev.detached && dev.reattach {
+		// Best effort, the kernel driver may have been unloaded meanwhile
+		dev.attachKernelDriver()
+	}
+}
+
+// SetWriteTimeout sets the timeout of writes in milliseconds.
+//
+// Deprecated: use SetTimeouts.
+func (dev *libusbHandle) SetWriteTimeout(timeout int) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.writeTimeout = timeout
+}
+
+// SetReadTimeout sets the timeout of reads in milliseconds.
+//
+// Deprecated: use SetTimeouts.
+func (dev *libusbHandle) SetReadTimeout(timeout int) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.readTimeout = timeout
+}
+
+// SetTimeouts sets the timeouts of Read and Write.
+func (dev *libusbHandle) SetTimeouts(read, write time.Duration) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.readTimeout, dev.writeTimeout = timeoutMillis(read), timeoutMillis(write)
+}
+
+// SetControlTimeout sets the timeout of control requests, zero for none. It
+// defaults to 5 seconds.
+func (dev *libusbHandle) SetControlTimeout(timeout time.Duration) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.controlTimeout = timeoutMillis(timeout)
+}
+
+// Control sends a control request to the device. The direction of the data
+// stage is determined by the ControlIn bit of rType.
+func (dev *libusbHandle) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+	dev.controlLock.Lock()
+	defer dev.controlLock.Unlock()
+
+	if dev.ops.closed() {
+		return 0, dev.closedErr()
+	}
+	if len(data) > math.MaxUint16 {
+		return 0, fmt.Errorf("failed to send control request: %w", libusbErrInvalidParam)
+	}
+	start := time.Now()
+	n, err := dev.ops.control(rType, request, val, idx, data, dev.controlTimeout)
+	if err != nil {
+		n, err = 0, dev.check(fmt.Errorf("failed to send control request: %w", err))
+	}
+	traceControl(rType, request, val, idx, start, data, n, err)
+	return n, err
+}
+
+// Write sends a binary blob to an USB device. Writes only exclude each other,
+// so they may run concurrently with reads and control requests.
+func (dev *libusbHandle) Write(b []byte) (int, error) {
+	return dev.write(net.Buffers{b}, nil)
+}
+
+// WriteV sends the buffers to an USB device as if they were concatenated,
+// transferring whole packets straight from the buffers.
+func (dev *libusbHandle) WriteV(bufs net.Buffers) (int, error) {
+	return dev.write(bufs, nil)
+}
+
+// WriteWithTimeout sends a binary blob to an USB device, with a timeout for
+// this call only.
+func (dev *libusbHandle) WriteWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	millis := timeoutMillis(timeout)
+	return dev.write(net.Buffers{b}, &millis)
+}
+
+// write sends the buffers with the given timeout in milliseconds, or the one of
+// the device if nil.
+func (dev *libusbHandle) write(bufs net.Buffers, override *int) (int, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+	dev.writeLock.Lock()
+	defer dev.writeLock.Unlock()
+
+	if dev.ops.closed() {
+		return 0, dev.closedErr()
+	}
+	timeout := dev.writeTimeout
+	if override != nil {
+		timeout = *override
+	}
+	start := time.Now()
+	n, err := writeVectored(bufs, dev.writePacket(), func(b []byte) (int, error) {
+		// libusb describes transfer lengths as C ints, split what can't be described
+		return splitTransfer(b, maxTransferSize(math.MaxInt32), func(chunk []byte) (int, error) {
+			return dev.retry.run(transientError, retryObserver(dev.metrics, EndpointDirectionOut), func() (int, error) {
+				return dev.transfer(*dev.libusbWriter, *dev.writerTransferType, chunk, timeout)
+			})
+		})
+	})
+	if err != nil {
+		err = dev.check(newTransferError(*dev.libusbWriter, n, err))
+		logger().Debug("usb: write failed", "endpoint", *dev.libusbWriter, "transferred", n, "err", err)
+	}
+	observeTransfer(dev.metrics, EndpointDirectionOut, start, n, err)
+	traceTransfer(*dev.libusbWriter, start, bufs, n, err)
+	return n, err
+}
+
+// Read retrieves a binary blob from an USB device. Reads only exclude each other,
+// so they may run concurrently with writes and control requests.
+func (dev *libusbHandle) Read(b []byte) (int, error) {
+	return dev.read(b, nil)
+}
+
+// ReadWithTimeout retrieves a binary blob from an USB device, with a timeout
+// for this call only.
+func (dev *libusbHandle) ReadWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	millis := timeoutMillis(timeout)
+	return dev.read(b, &millis)
+}
+
+// read retrieves a binary blob with the given timeout in milliseconds, or the
+// one of the device if nil.
+func (dev *libusbHandle) read(b []byte, override *int) (int, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+	dev.readLock.Lock()
+	defer dev.readLock.Unlock()
+
+	if dev.ops.closed() {
+		return 0, dev.closedErr()
+	}
+	timeout := dev.readTimeout
+	if override != nil {
+		timeout = *override
+	}
+	start := time.Now()
+	// libusb describes transfer lengths as C ints, split what can't be described
+	n, err := splitTransfer(b, maxTransferSize(math.MaxInt32), func(chunk []byte) (int, error) {
+		return dev.retry.run(transientError, retryObserver(dev.metrics, EndpointDirectionIn), func() (int, error) {
+			return dev.transfer(*dev.libusbReader, *dev.readerTransferType, chunk, timeout)
+		})
+	})
+	if err != nil {
+		err = dev.check(newTransferError(*dev.libusbReader, n, err))
+		logger().Debug("usb: read failed", "endpoint", *dev.libusbReader, "transferred", n, "err", err)
+	}
+	observeTransfer(dev.metrics, EndpointDirectionIn, start, n, err)
+	traceTransfer(*dev.libusbReader, start, net.Buffers{b}, n, err)
+	return n, err
+}
+
+// transfer runs a synchronous bulk or interrupt transfer on an endpoint. Stalls
+// are cleared if transfers are retried, so the retry has a chance to succeed.
+func (dev *libusbHandle) transfer(endpoint uint8, kind uint8, b []byte, timeout int) (int, error) {
+	switch TransferType(kind) {
+	case TransferTypeBulk, TransferTypeInterrupt:
+	default:
+		return 0, fmt.Errorf("device transfer type unsupported %v", kind)
+	}
+	n, err := dev.ops.transfer(endpoint, TransferType(kind), b, timeout)
+	if err == libusbErrPipe && dev.retry != nil {
+		dev.ops.clearHalt(endpoint)
+	}
+	return n, err
+}
+
+// SetRetryPolicy configures retries of Read and Write transfers failing with
+// transient errors. A nil policy disables retries.
+func (dev *libusbHandle) SetRetryPolicy(policy *RetryPolicy) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.retry = policy
+}
+
+// SetMetrics reports the Read and Write transfers of the device to m. A nil m
+// disables the reporting.
+func (dev *libusbHandle) SetMetrics(m Metrics) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.metrics = m
+}
+
+func (dev *libusbHandle) SetAutoDetach(val int) error {
+	err := dev.ops.setAutoDetach(val != 0)
+	if err != nil && err != libusbErrNotSupported {
+		return err
+	}
+	return nil
+}
+
+func (dev *libusbHandle) DetachKernelDriver() error {
+	err := dev.ops.detach(dev.Interface)
+	if err != nil && err != libusbErrNotSupported && err != libusbErrNotFound {
+		// ErrorNotSupported is returned in non linux systems
+		// ErrorNotFound is returned if libusb's driver is already attached to the device
+		return err
+	}
+	if err == nil {
+		dev.detached = true
+	}
+	return nil
+}
+
+// AttachKernelDriver gives the claimed interface back to the kernel driver that
+// was bound to it before the device was opened.
+func (dev *libusbHandle) AttachKernelDriver() error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.ops.closed() {
+		return dev.closedErr()
+	}
+	return dev.attachKernelDriver()
+}
+
+// attachKernelDriver is the lock-free variant of AttachKernelDriver.
+func (dev *libusbHandle) attachKernelDriver() error {
+	err := dev.ops.attach(dev.Interface)
+	if err != nil && err != libusbErrNotSupported && err != libusbErrNotFound {
+		// ErrorNotFound is returned if no kernel driver was detached before
+		return err
+	}
+	dev.detached = false
+	return nil
+}
+
+// SetReattachOnClose configures whether Close hands the interface back to the
+// kernel driver detached during open. It is enabled by default.
+func (dev *libusbHandle) SetReattachOnClose(reattach bool) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.reattach = reattach
+}
+
+// ClaimInterface claims an additional interface of a composite device on the
+// already opened handle, detaching any kernel driver bound to it.
+func (dev *libusbHandle) ClaimInterface(iface int) error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.ops.closed() {
+		return dev.closedErr()
+	}
+	if _, ok := dev.claimed[iface]; ok || iface == dev.Interface {
+		return nil
+	}
+	detached := false
+	if !dev.noDetach {
+		err := dev.ops.detach(iface)
+		switch {
+		case err == nil:
+			detached = true
+		case err != libusbErrNotSupported && err != libusbErrNotFound:
+			return fmt.Errorf("failed to detach kernel driver: %w", err)
+		}
+	}
+	if err := dev.ops.claim(iface); err != nil {
+		if detached {
+			dev.ops.attach(iface)
+		}
+		return fmt.Errorf("failed to claim interface: %w", err)
+	}
+	if dev.claimed == nil {
+		dev.claimed = make(map[int]bool)
+	}
+	dev.claimed[iface] = detached
+	return nil
+}
+
+// ReleaseInterface releases an interface previously claimed by ClaimInterface.
+// The interface the device was opened on is only released by Close.
+func (dev *libusbHandle) ReleaseInterface(iface int) error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.ops.closed() {
+		return dev.closedErr()
+	}
+	if _, ok := dev.claimed[iface]; !ok {
+		return fmt.Errorf("interface %d not claimed", iface)
+	}
+	return dev.releaseInterface(iface)
+}
+
+// releaseInterface is the lock-free variant of ReleaseInterface.
+func (dev *libusbHandle) releaseInterface(iface int) error {
+	detached := dev.claimed[iface]
+	delete(dev.claimed, iface)
+	delete(dev.alts, iface)
+
+	if err := dev.releaseClaim(iface); err != nil {
+		return fmt.Errorf("failed to release interface: %w", err)
+	}
+	if detached && dev.reattach {
+		dev.ops.attach(iface)
+	}
+	return nil
+}
+
+// releaseClaim releases an interface with auto-detach suspended, as libusb
+// would otherwise hand it back to a kernel driver on every release, whatever
+// the reattach setting. Callers reattach the drivers they detached themselves.
+func (dev *libusbHandle) releaseClaim(iface int) error {
+	dev.SetAutoDetach(0)
+	if !dev.noDetach {
+		// Claims restored after resets rely on it to detach rebound drivers
+		defer dev.SetAutoDetach(1)
+	}
+	return dev.ops.release(iface)
+}
+
+// SetAltSetting activates an alternate setting of a claimed interface.
+func (dev *libusbHandle) SetAltSetting(iface int, alt int) error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.ops.closed() {
+		return dev.closedErr()
+	}
+	if err := dev.ops.setAlt(iface, alt); err != nil {
+		return fmt.Errorf("failed to set alternate setting: %w", err)
+	}
+	if dev.alts == nil {
+		dev.alts = make(map[int]int)
+	}
+	dev.alts[iface] = alt
+	return nil
+}
+
+// Reset performs a USB port reset of the device, then restores the interface
+// claims and alternate settings.
+func (dev *libusbHandle) Reset() error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.ops.closed() {
+		return dev.closedErr()
+	}
+	err := dev.ops.reset()
+	if err == libusbErrNotFound {
+		// The descriptors changed and the device was re-enumerated, the handle is stale
+		err = fmt.Errorf("device re-enumerated: %w", ErrNoDevice)
+	}
+	if err != nil {
+		return dev.check(fmt.Errorf("failed to reset device: %w", err))
+	}
+	return dev.restore()
+}
+
+// SetConfiguration activates a configuration of the device, then restores the
+// interface claims and alternate settings.
+func (dev *libusbHandle) SetConfiguration(config int) error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.ops.closed() {
+		return dev.closedErr()
+	}
+	// Claimed interfaces block configuration changes. Release them without
+	// giving them back to kernel drivers, which would block it just the same.
+	for _, iface := range dev.interfaces() {
+		dev.releaseClaim(iface)
+	}
+	if err := dev.ops.setConfig(config); err != nil {
+		// Reclaim the interfaces of the configuration left active
+		dev.restore()
+		return dev.check(fmt.Errorf("failed to set configuration %d: %w", config, err))
+	}
+	// The new configuration starts out with the default alternate settings,
+	// the tracked ones are applied again on top
+	return dev.restore()
+}
+
+// Configuration returns the value of the active configuration. libusb answers
+// from its cache where the OS allows, issuing GET_CONFIGURATION otherwise.
+func (dev *libusbHandle) Configuration() (int, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+	dev.controlLock.Lock()
+	defer dev.controlLock.Unlock()
+
+	if dev.ops.closed() {
+		return 0, dev.closedErr()
+	}
+	config, err := dev.ops.getConfig()
+	if err != nil {
+		return 0, dev.check(fmt.Errorf("failed to get configuration: %w", err))
+	}
+	if config == 0 {
+		return 0, ErrNotConfigured
+	}
+	return config, nil
+}
+
+// interfaces returns the primary and additionally claimed interfaces in order.
+func (dev *libusbHandle) interfaces() []int {
+	ifaces := make([]int, 0, 1+len(dev.claimed))
+	for iface := range dev.claimed {
+		ifaces = append(ifaces, iface)
+	}
+	sort.Ints(ifaces)
+	return append([]int{dev.Interface}, ifaces...)
+}
+
+// restore reclaims the interfaces and reapplies their alternate settings after
+// a reset or configuration change dropped them.
+func (dev *libusbHandle) restore() error {
+	ifaces := dev.interfaces()
+	for _, iface := range ifaces {
+		if err := dev.ops.claim(iface); err != nil {
+			return &RestoreError{Interface: iface, Err: err}
+		}
+	}
+	for _, iface := range ifaces {
+		if alt := dev.alts[iface]; alt != 0 {
+			if err := dev.ops.setAlt(iface, alt); err != nil {
+				return &RestoreError{Interface: iface, Alternate: alt, Err: err}
+			}
+		}
+	}
+	return nil
+}
+
+// Status retrieves the power and remote wakeup status of the device.
+func (dev *libusbHandle) Status() (DeviceStatus, error) {
+	return readDeviceStatus(dev.Control)
+}
+
+// InterfaceStatus retrieves the function remote wake status of an interface.
+func (dev *libusbHandle) InterfaceStatus(iface int) (InterfaceStatus, error) {
+	return readInterfaceStatus(dev.Control, iface)
+}
+
+// EndpointStatus retrieves the halt status of an endpoint.
+func (dev *libusbHandle) EndpointStatus(endpoint uint8) (EndpointStatus, error) {
+	return readEndpointStatus(dev.Control, endpoint)
+}
+
+// SetRemoteWakeup arms or disarms the device to wake the host from suspend.
+func (dev *libusbHandle) SetRemoteWakeup(enable bool) error {
+	return setRemoteWakeup(dev.Control, enable)
+}
+
+// SetAutoSuspend configures the runtime power management of the kernel through
+// the sysfs attributes of the device, which usually need root to write.
+func (dev *libusbHandle) SetAutoSuspend(enable bool, delay time.Duration) error {
+	if runtime.GOOS != "linux" && runtime.GOOS != "android" {
+		return fmt.Errorf("failed to set autosuspend: %w", ErrNotSupported)
+	}
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+
+	if dev.ops.closed() {
+		return dev.closedErr()
+	}
+	return setAutoSuspend(dev.SysPath, enable, delay)
+}
+
+// ClearHalt clears the halt/stall condition of an endpoint.
+func (dev *libusbHandle) ClearHalt(endpoint uint8) error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.ops.closed() {
+		return dev.closedErr()
+	}
+	if err := dev.ops.clearHalt(endpoint); err != nil {
+		return fmt.Errorf("failed to clear halt: %w", err)
+	}
+	return nil
+}
+
+// RawDescriptors retrieves the raw device and configuration descriptors from
+// the device via GET_DESCRIPTOR requests, allowing applications to parse class
+// specific descriptors not modelled by this package.
+func (dev *libusbHandle) RawDescriptors() (*RawDescriptors, error) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.ops.closed() {
+		return nil, dev.closedErr()
+	}
+	return readRawDescriptors(dev.getDescriptor)
+}
+
+// getDescriptor issues a standard GET_DESCRIPTOR request on the device.
+func (dev *libusbHandle) getDescriptor(kind uint8, index uint8, length int) ([]byte, error) {
+	buf := make([]byte, length)
+	n, err := dev.ops.control(ControlIn|ControlDevice, requestGetDescriptor, uint16(kind)<<8|uint16(index), 0, buf, dev.controlTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// describeConfigs parses the configurations of a libusb device into the infos
+// of the interfaces accepted by the match predicate, the same way as backends
+// reading raw descriptors. The binding reassembles the raw descriptor of each
+// configuration from its form parsed by libusb, and references the device for
+// the returned infos once the first of them matched.
+func describeConfigs(base DeviceInfo, devnum, count int, config func(cfgnum int) ([]byte, error), ref func() interface{}, match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	var (
+		infos []DeviceInfo
+		dev   interface{}
+	)
+	for cfgnum := 0; cfgnum < count; cfgnum++ {
+		raw, err := config(cfgnum)
+		if err != nil {
+			return infos, fmt.Errorf("failed to get device %d config %d: %w", devnum, cfgnum, err)
+		}
+		ifaces, err := describeConfig(base, raw, hid)
+		if err != nil {
+			return infos, fmt.Errorf("failed to parse device %d config %d: %w", devnum, cfgnum, err)
+		}
+		for _, info := range ifaces {
+			// Only retain the device if the caller is interested in it
+			if !match(info) {
+				continue
+			}
+			if dev == nil {
+				dev = ref()
+			}
+			info.libusbDevice = dev
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
@@ -0,0 +1,43 @@
+package zerousb
+
+import "sync"
+
+// Logger receives the log records of the package: enumeration decisions,
+// kernel driver detaches, failed transfers and hotplug events. Records are a
+// message followed by alternating keys and values, so a *slog.Logger can be
+// used as is.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Warn(msg string, args ...any)
+}
+
+// nopLogger is a logger discarding all records.
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...any) {}
+func (nopLogger) Warn(msg string, args ...any)  {}
+
+var (
+	pkgLogger     Logger = nopLogger{}
+	pkgLoggerLock sync.RWMutex
+)
+
+// SetLogger directs the log records of the package to l. A nil l discards
+// them, which is the default.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	pkgLoggerLock.Lock()
+	defer pkgLoggerLock.Unlock()
+
+	pkgLogger = l
+}
+
+// logger returns the logger records of the package are directed to.
+func logger() Logger {
+	pkgLoggerLock.RLock()
+	defer pkgLoggerLock.RUnlock()
+
+	return pkgLogger
+}
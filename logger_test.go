@@ -0,0 +1,29 @@
+package zerousb
+
+import "testing"
+
+// recordLogger is a logger recording the messages of its records.
+type recordLogger struct {
+	msgs []string
+}
+
+func (l *recordLogger) Debug(msg string, args ...any) { l.msgs = append(l.msgs, msg) }
+func (l *recordLogger) Warn(msg string, args ...any)  { l.msgs = append(l.msgs, msg) }
+
+// Tests that records are directed to the configured logger, and discarded
+// once it's reset.
+func TestSetLogger(t *testing.T) {
+	defer SetLogger(nil)
+
+	l := new(recordLogger)
+	SetLogger(l)
+	logger().Debug("usb: first")
+	logger().Warn("usb: second")
+
+	SetLogger(nil)
+	logger().Debug("usb: discarded")
+
+	if len(l.msgs) != 2 || l.msgs[0] != "usb: first" || l.msgs[1] != "usb: second" {
+		t.Errorf("records mismatch: have %q, want [usb: first usb: second]", l.msgs)
+	}
+}
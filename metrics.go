@@ -0,0 +1,72 @@
+package zerousb
+
+import (
+	"errors"
+	"time"
+)
+
+// Metrics receives measurements of the Read and Write transfers of a device,
+// so monitoring software can export the health of its devices (e.g. as
+// Prometheus metrics) without wrapping every call. Methods are called on the
+// transferring goroutine, so they must be cheap and safe for concurrent use.
+type Metrics interface {
+	// ObserveTransfer records a finished transfer with the number of bytes
+	// moved, the time it took including retries and the error it failed with,
+	// nil if it succeeded.
+	ObserveTransfer(dir EndpointDirection, n int, latency time.Duration, err error)
+
+	// ObserveRetry records a transfer being retried after failing with err.
+	ObserveRetry(dir EndpointDirection, err error)
+}
+
+// errorCodes maps the portable errors to the names ErrorCode reports them by.
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrTimeout, "timeout"},
+	{ErrPipe, "pipe"},
+	{ErrOverflow, "overflow"},
+	{ErrInterrupted, "interrupted"},
+	{ErrNoDevice, "no_device"},
+	{ErrDeviceClosed, "closed"},
+	{ErrAccess, "access"},
+	{ErrBusy, "busy"},
+	{ErrNotFound, "not_found"},
+	{ErrInvalidParam, "invalid_param"},
+	{ErrNoMem, "no_mem"},
+	{ErrNotSupported, "not_supported"},
+	{ErrIO, "io"},
+}
+
+// ErrorCode returns a short name of the portable error matched by err (e.g.
+// "timeout" for ErrTimeout), suitable as the label of an error counter. Errors
+// matching none of them are reported as "other", nil as an empty string.
+func ErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	for _, code := range errorCodes {
+		if errors.Is(err, code.err) {
+			return code.code
+		}
+	}
+	return "other"
+}
+
+// observeTransfer reports a transfer started at the given time to the metrics,
+// if any.
+func observeTransfer(m Metrics, dir EndpointDirection, start time.Time, n int, err error) {
+	if m != nil {
+		m.ObserveTransfer(dir, n, time.Since(start), err)
+	}
+}
+
+// retryObserver returns the callback reporting the retries of transfers in the
+// given direction to the metrics, nil if there are none.
+func retryObserver(m Metrics, dir EndpointDirection) func(error) {
+	if m == nil {
+		return nil
+	}
+	return func(err error) { m.ObserveRetry(dir, err) }
+}
@@ -0,0 +1,28 @@
+package zerousb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// Tests that errors are named after the portable error they match, however
+// deeply wrapped.
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code string
+	}{
+		{nil, ""},
+		{ErrTimeout, "timeout"},
+		{&TransferError{Endpoint: 0x81, Err: ErrPipe}, "pipe"},
+		{fmt.Errorf("failed to read: %w", &TransferError{Err: ErrNoDevice}), "no_device"},
+		{ErrDeviceClosed, "closed"},
+		{errors.New("unknown"), "other"},
+	}
+	for i, tt := range tests {
+		if have := ErrorCode(tt.err); have != tt.code {
+			t.Errorf("test %d: code mismatch: have %q, want %q", i, have, tt.code)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Package msc implements the USB Mass Storage Bulk-Only Transport protocol on
+// top of zerousb bulk transfers, exposing raw SCSI command passthrough for
+// tools which need to talk to a disk without the OS mounting it.
+package msc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/chay22/zerousb"
+)
+
+// Class specific requests defined by the BOT spec, section 3.
+const (
+	requestReset     = 0xff
+	requestGetMaxLUN = 0xfe
+)
+
+// Wrapper signatures and sizes defined by the BOT spec, section 5.
+const (
+	cbwSignature = 0x43425355
+	cswSignature = 0x53425355
+	cbwLength    = 31
+	cswLength    = 13
+)
+
+// Command status values reported in the CSW.
+const (
+	statusPassed     = 0x00
+	statusFailed     = 0x01
+	statusPhaseError = 0x02
+)
+
+// Direction is the direction of the data phase of a command.
+type Direction int
+
+const (
+	// DirectionNone is used for commands without a data phase.
+	DirectionNone Direction = iota
+
+	// DirectionIn is used for commands reading data from the device.
+	DirectionIn
+
+	// DirectionOut is used for commands writing data to the device.
+	DirectionOut
+)
+
+// ErrCommandFailed is returned when the device reports a command failure, the
+// cause of which can be retrieved via RequestSense.
+var ErrCommandFailed = errors.New("msc: command failed")
+
+// ErrPhase is returned when the device reports a phase error. The transport is
+// reset before returning it.
+var ErrPhase = errors.New("msc: phase error")
+
+// Transport is the subset of zerousb.Device needed to speak BOT.
+type Transport interface {
+	Read(b []byte) (int, error)
+	Write(b []byte) (int, error)
+	Control(rType, request uint8, val, idx uint16, data []byte) (int, error)
+	ClearHalt(endpoint uint8) error
+}
+
+// Device is a mass storage device speaking the Bulk-Only Transport.
+type Device struct {
+	dev   Transport
+	iface uint16
+	in    uint8 // Bulk IN endpoint address, needed for stall recovery
+	out   uint8 // Bulk OUT endpoint address, needed for stall recovery
+
+	lock sync.Mutex // Serializes commands, BOT has no command queueing
+	tag  uint32     // Tag of the last command block wrapper sent
+}
+
+// New wraps an opened device enumerated as the given interface, which must be
+// a mass storage BOT interface.
+func New(dev Transport, info zerousb.DeviceInfo) (*Device, error) {
+	d := &Device{dev: dev, iface: uint16(info.Interface)}
+
+	var in, out bool
+	for _, end := range info.Endpoints {
+		if end.TransferType() != zerousb.TransferTypeBulk {
+			continue
+		}
+		if end.Direction() == zerousb.EndpointDirectionIn && !in {
+			d.in, in = end.Address, true
+		}
+		if end.Direction() == zerousb.EndpointDirectionOut && !out {
+			d.out, out = end.Address, true
+		}
+	}
+	if !in || !out {
+		return nil, errors.New("msc: interface lacks bulk endpoints")
+	}
+	return d, nil
+}
+
+// MaxLUN returns the highest logical unit number of the device. Devices not
+// supporting multiple units may stall the request, which is reported as 0.
+func (d *Device) MaxLUN() (uint8, error) {
+	buf := make([]byte, 1)
+	n, err := d.dev.Control(zerousb.ControlIn|zerousb.ControlClass|zerousb.ControlInterface, requestGetMaxLUN, 0, d.iface, buf)
+	if errors.Is(err, zerousb.ErrPipe) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("msc: failed to get max lun: %w", err)
+	}
+	if n < 1 {
+		return 0, nil
+	}
+	return buf[0], nil
+}
+
+// Reset performs the BOT reset recovery: a mass storage reset followed by
+// clearing the halt condition on both bulk endpoints.
+func (d *Device) Reset() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.reset()
+}
+
+// reset is the lock-free variant of Reset.
+func (d *Device) reset() error {
+	if _, err := d.dev.Control(zerousb.ControlOut|zerousb.ControlClass|zerousb.ControlInterface, requestReset, 0, d.iface, nil); err != nil {
+		return fmt.Errorf("msc: reset failed: %w", err)
+	}
+	if err := d.dev.ClearHalt(d.in); err != nil {
+		return fmt.Errorf("msc: reset failed: %w", err)
+	}
+	if err := d.dev.ClearHalt(d.out); err != nil {
+		return fmt.Errorf("msc: reset failed: %w", err)
+	}
+	return nil
+}
+
+// Command executes a raw SCSI command on a logical unit. For DirectionIn data
+// is filled from the device, for DirectionOut it is sent to the device. The
+// number of bytes actually transferred in the data phase is returned.
+func (d *Device) Command(lun uint8, cdb []byte, dir Direction, data []byte) (int, error) {
+	if len(cdb) == 0 || len(cdb) > 16 {
+		return 0, fmt.Errorf("msc: invalid command block length %d", len(cdb))
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	// Assemble and send the command block wrapper
+	d.tag++
+	cbw := make([]byte, cbwLength)
+	binary.LittleEndian.PutUint32(cbw[0:], cbwSignature)
+	binary.LittleEndian.PutUint32(cbw[4:], d.tag)
+	if dir != DirectionNone {
+		binary.LittleEndian.PutUint32(cbw[8:], uint32(len(data)))
+	}
+	if dir == DirectionIn {
+		cbw[12] = 0x80
+	}
+	cbw[13] = lun
+	cbw[14] = uint8(len(cdb))
+	copy(cbw[15:], cdb)
+
+	if _, err := d.dev.Write(cbw); err != nil {
+		d.reset()
+		return 0, fmt.Errorf("msc: failed to send command: %w", err)
+	}
+	// Run the data phase, clearing any stall so the status can still be read
+	// (the CSW reports whether the command succeeded, not the transfer error).
+	var transferred int
+	switch dir {
+	case DirectionIn:
+		n, err := d.dev.Read(data)
+		if err != nil {
+			d.dev.ClearHalt(d.in)
+		}
+		transferred = n
+	case DirectionOut:
+		n, err := d.dev.Write(data)
+		if err != nil {
+			d.dev.ClearHalt(d.out)
+		}
+		transferred = n
+	}
+	// Retrieve and validate the command status wrapper
+	csw := make([]byte, cswLength)
+	n, err := d.dev.Read(csw)
+	if err != nil {
+		// A stall may occur here too, one retry is allowed after clearing it
+		d.dev.ClearHalt(d.in)
+		if n, err = d.dev.Read(csw); err != nil {
+			d.reset()
+			return transferred, fmt.Errorf("msc: failed to read status: %w", err)
+		}
+	}
+	if n != cswLength || binary.LittleEndian.Uint32(csw[0:]) != cswSignature || binary.LittleEndian.Uint32(csw[4:]) != d.tag {
+		d.reset()
+		return transferred, errors.New("msc: invalid command status")
+	}
+	switch csw[12] {
+	case statusPassed:
+		return transferred, nil
+	case statusFailed:
+		return transferred, ErrCommandFailed
+	default:
+		d.reset()
+		return transferred, ErrPhase
+	}
+}
@@ -0,0 +1,114 @@
+package msc
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/chay22/zerousb"
+)
+
+// fakeDisk answers every command with a canned data phase and a passing CSW.
+type fakeDisk struct {
+	cbw     []byte
+	reply   []byte
+	pending [][]byte
+}
+
+func (f *fakeDisk) Write(b []byte) (int, error) {
+	if len(b) == cbwLength {
+		f.cbw = append([]byte(nil), b...)
+
+		csw := make([]byte, cswLength)
+		binary.LittleEndian.PutUint32(csw[0:], cswSignature)
+		copy(csw[4:8], b[4:8])
+		f.pending = [][]byte{f.reply, csw}
+		if b[12]&0x80 == 0 {
+			f.pending = f.pending[1:]
+		}
+	}
+	return len(b), nil
+}
+
+func (f *fakeDisk) Read(b []byte) (int, error) {
+	n := copy(b, f.pending[0])
+	f.pending = f.pending[1:]
+	return n, nil
+}
+
+func (f *fakeDisk) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	return 0, nil
+}
+
+func (f *fakeDisk) ClearHalt(endpoint uint8) error { return nil }
+
+// Tests that commands are framed into CBWs and responses decoded.
+func TestReadCapacity(t *testing.T) {
+	disk := &fakeDisk{reply: []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00}}
+	dev, err := New(disk, zerousb.DeviceInfo{Endpoints: []zerousb.EndpointInfo{
+		{Address: 0x81, Attributes: 0x02},
+		{Address: 0x02, Attributes: 0x02},
+	}})
+	if err != nil {
+		t.Fatalf("failed to create device: %v", err)
+	}
+	last, size, err := dev.ReadCapacity(0)
+	if err != nil {
+		t.Fatalf("failed to read capacity: %v", err)
+	}
+	if last != 0x00010000 || size != 512 {
+		t.Errorf("capacity mismatch: have %d/%d, want %d/%d", last, size, 0x00010000, 512)
+	}
+	if binary.LittleEndian.Uint32(disk.cbw[8:]) != 8 || disk.cbw[12] != 0x80 || disk.cbw[14] != 10 || disk.cbw[15] != opReadCapacity10 {
+		t.Errorf("malformed cbw: %x", disk.cbw)
+	}
+}
+
+// lunDisk answers GET_MAX_LUN with a fixed outcome.
+type lunDisk struct {
+	fakeDisk
+	lun uint8
+	err error
+}
+
+func (f *lunDisk) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	data[0] = f.lun
+	return 1, nil
+}
+
+// Tests that only stalled GET_MAX_LUN requests are taken for single unit
+// devices, other failures being reported.
+func TestMaxLUN(t *testing.T) {
+	tests := []struct {
+		disk *lunDisk
+		lun  uint8
+		err  error
+	}{
+		{&lunDisk{lun: 3}, 3, nil},
+		{&lunDisk{err: zerousb.ErrPipe}, 0, nil},
+		{&lunDisk{err: zerousb.ErrTimeout}, 0, zerousb.ErrTimeout},
+		{&lunDisk{err: zerousb.ErrNoDevice}, 0, zerousb.ErrNoDevice},
+	}
+	for i, tt := range tests {
+		dev := &Device{dev: tt.disk}
+		lun, err := dev.MaxLUN()
+		if lun != tt.lun || !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+			t.Errorf("test %d: outcome mismatch: have %d/%v, want %d/%v", i, lun, err, tt.lun, tt.err)
+		}
+	}
+}
+
+// Tests that block transfers are refused for media reporting no block size,
+// instead of dividing by zero.
+func TestZeroBlockSize(t *testing.T) {
+	dev := &Device{dev: new(fakeDisk)}
+	if _, err := dev.ReadBlocks(0, 0, 0, make([]byte, 512)); err == nil {
+		t.Errorf("read with zero block size succeeded")
+	}
+	if _, err := dev.WriteBlocks(0, 0, 0, make([]byte, 512)); err == nil {
+		t.Errorf("write with zero block size succeeded")
+	}
+}
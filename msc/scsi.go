@@ -0,0 +1,117 @@
+package msc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// SCSI operation codes of the commands with helpers below.
+const (
+	opTestUnitReady  = 0x00
+	opRequestSense   = 0x03
+	opInquiry        = 0x12
+	opReadCapacity10 = 0x25
+	opRead10         = 0x28
+	opWrite10        = 0x2a
+)
+
+// InquiryData is the standard response to an INQUIRY command.
+type InquiryData struct {
+	DeviceType uint8  // Peripheral device type, 0x00 for direct access block devices
+	Removable  bool   // Whether the medium is removable
+	Vendor     string // T10 vendor identification
+	Product    string // Product identification
+	Revision   string // Product revision level
+}
+
+// Inquiry retrieves the standard inquiry data of a logical unit.
+func (d *Device) Inquiry(lun uint8) (*InquiryData, error) {
+	buf := make([]byte, 36)
+	if _, err := d.Command(lun, []byte{opInquiry, 0, 0, 0, uint8(len(buf)), 0}, DirectionIn, buf); err != nil {
+		return nil, err
+	}
+	return &InquiryData{
+		DeviceType: buf[0] & 0x1f,
+		Removable:  buf[1]&0x80 != 0,
+		Vendor:     strings.TrimSpace(string(buf[8:16])),
+		Product:    strings.TrimSpace(string(buf[16:32])),
+		Revision:   strings.TrimSpace(string(buf[32:36])),
+	}, nil
+}
+
+// TestUnitReady checks whether a logical unit is ready to accept commands.
+func (d *Device) TestUnitReady(lun uint8) error {
+	_, err := d.Command(lun, make([]byte, 6), DirectionNone, nil)
+	return err
+}
+
+// Sense is the fixed format sense data describing the last command failure.
+type Sense struct {
+	Key  uint8 // Sense key
+	ASC  uint8 // Additional sense code
+	ASCQ uint8 // Additional sense code qualifier
+	Raw  []byte
+}
+
+// RequestSense retrieves the sense data of the last failed command.
+func (d *Device) RequestSense(lun uint8) (*Sense, error) {
+	buf := make([]byte, 18)
+	n, err := d.Command(lun, []byte{opRequestSense, 0, 0, 0, uint8(len(buf)), 0}, DirectionIn, buf)
+	if err != nil {
+		return nil, err
+	}
+	sense := &Sense{Raw: buf[:n]}
+	if n >= 14 {
+		sense.Key, sense.ASC, sense.ASCQ = buf[2]&0x0f, buf[12], buf[13]
+	}
+	return sense, nil
+}
+
+// ReadCapacity retrieves the address of the last block and the block size of
+// a logical unit.
+func (d *Device) ReadCapacity(lun uint8) (lastBlock uint32, blockSize uint32, err error) {
+	buf := make([]byte, 8)
+	if _, err := d.Command(lun, []byte{opReadCapacity10, 0, 0, 0, 0, 0, 0, 0, 0, 0}, DirectionIn, buf); err != nil {
+		return 0, 0, err
+	}
+	return binary.BigEndian.Uint32(buf[0:]), binary.BigEndian.Uint32(buf[4:]), nil
+}
+
+// ReadBlocks reads whole blocks starting at lba into buf, whose length must be
+// a multiple of the block size.
+func (d *Device) ReadBlocks(lun uint8, lba uint32, blockSize int, buf []byte) (int, error) {
+	blocks, err := blockCount(buf, blockSize)
+	if err != nil {
+		return 0, err
+	}
+	return d.Command(lun, rw10(opRead10, lba, blocks), DirectionIn, buf)
+}
+
+// WriteBlocks writes whole blocks starting at lba from buf, whose length must
+// be a multiple of the block size.
+func (d *Device) WriteBlocks(lun uint8, lba uint32, blockSize int, buf []byte) (int, error) {
+	blocks, err := blockCount(buf, blockSize)
+	if err != nil {
+		return 0, err
+	}
+	return d.Command(lun, rw10(opWrite10, lba, blocks), DirectionOut, buf)
+}
+
+// blockCount returns the number of blocks of the given size in buf, rejecting
+// block sizes of unformatted media, which report 0.
+func blockCount(buf []byte, blockSize int) (uint16, error) {
+	if blockSize <= 0 {
+		return 0, fmt.Errorf("msc: invalid block size %d", blockSize)
+	}
+	return uint16(len(buf) / blockSize), nil
+}
+
+// rw10 assembles a READ(10) or WRITE(10) command block.
+func rw10(op uint8, lba uint32, blocks uint16) []byte {
+	cdb := make([]byte, 10)
+	cdb[0] = op
+	binary.BigEndian.PutUint32(cdb[2:], lba)
+	binary.BigEndian.PutUint16(cdb[7:], blocks)
+	return cdb
+}
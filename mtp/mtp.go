@@ -0,0 +1,297 @@
+// Package mtp implements the PTP/MTP transaction framing over zerousb bulk
+// transfers, enough to open sessions and browse and download objects from
+// cameras and phones.
+package mtp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Container types defined by the PTP spec (ISO 15740), section D.7.1.
+const (
+	containerCommand  = 1
+	containerData     = 2
+	containerResponse = 3
+	containerEvent    = 4
+)
+
+// headerLength is the size of the generic container header.
+const headerLength = 12
+
+// readChunk is the buffer size bulk reads are issued with. It must be a
+// multiple of the endpoint's max packet size to avoid overflows.
+const readChunk = 64 * 1024
+
+// Operation codes of the operations with helpers below.
+const (
+	OpGetDeviceInfo    = 0x1001
+	OpOpenSession      = 0x1002
+	OpCloseSession     = 0x1003
+	OpGetStorageIDs    = 0x1004
+	OpGetObjectHandles = 0x1007
+	OpGetObjectInfo    = 0x1008
+	OpGetObject        = 0x1009
+)
+
+// Response codes referenced by this package.
+const (
+	RespOK                   = 0x2001
+	RespSessionAlreadyOpened = 0x201e
+)
+
+// ResponseError is returned when an operation completes with a response code
+// other than OK.
+type ResponseError struct {
+	Op   uint16
+	Code uint16
+}
+
+// Error implements the error interface.
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("mtp: operation 0x%04x failed with response 0x%04x", e.Op, e.Code)
+}
+
+// ErrSessionClosed is returned by operations requiring an open session.
+var ErrSessionClosed = errors.New("mtp: session not open")
+
+// Transport is the subset of zerousb.Device needed to speak PTP.
+type Transport interface {
+	Read(b []byte) (int, error)
+	Write(b []byte) (int, error)
+}
+
+// Response is the response phase of a transaction.
+type Response struct {
+	Code   uint16
+	Params []uint32
+}
+
+// Client runs PTP transactions against a device.
+type Client struct {
+	dev Transport
+
+	lock    sync.Mutex // Serializes transactions, PTP has one in flight at a time
+	tid     uint32     // Id of the last transaction
+	session uint32     // Id of the open session, 0 if none
+}
+
+// New creates a client talking to a device through the given transport.
+func New(dev Transport) *Client {
+	return &Client{dev: dev}
+}
+
+// Transaction runs a single operation with up to five parameters, sending the
+// optional outbound data phase and returning the inbound one, if any.
+func (c *Client) Transaction(op uint16, params []uint32, data []byte) (*Response, []byte, error) {
+	if len(params) > 5 {
+		return nil, nil, fmt.Errorf("mtp: too many parameters: %d", len(params))
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// The session opening transaction uses id 0, all others count from 1
+	tid := uint32(0)
+	if op != OpOpenSession {
+		c.tid++
+		tid = c.tid
+	}
+	if err := c.send(containerCommand, op, tid, encodeParams(params)); err != nil {
+		return nil, nil, err
+	}
+	if data != nil {
+		if err := c.send(containerData, op, tid, data); err != nil {
+			return nil, nil, err
+		}
+	}
+	// Read the optional data phase and the response
+	var in []byte
+	for {
+		kind, code, payload, err := c.receive()
+		if err != nil {
+			return nil, nil, err
+		}
+		switch kind {
+		case containerData:
+			in = payload
+		case containerResponse:
+			res := &Response{Code: code, Params: decodeParams(payload)}
+			if code != RespOK {
+				return res, in, &ResponseError{Op: op, Code: code}
+			}
+			return res, in, nil
+		default:
+			return nil, nil, fmt.Errorf("mtp: unexpected container type %d", kind)
+		}
+	}
+}
+
+// send writes a single container to the device.
+func (c *Client) send(kind uint16, code uint16, tid uint32, payload []byte) error {
+	buf := make([]byte, headerLength+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:], uint32(len(buf)))
+	binary.LittleEndian.PutUint16(buf[4:], kind)
+	binary.LittleEndian.PutUint16(buf[6:], code)
+	binary.LittleEndian.PutUint32(buf[8:], tid)
+	copy(buf[headerLength:], payload)
+
+	if _, err := c.dev.Write(buf); err != nil {
+		return fmt.Errorf("mtp: failed to send container: %w", err)
+	}
+	return nil
+}
+
+// receive reads a single container from the device, reassembling payloads
+// spanning multiple bulk transfers.
+func (c *Client) receive() (kind uint16, code uint16, payload []byte, err error) {
+	buf := make([]byte, readChunk)
+
+	var n int
+	for n == 0 {
+		// Skip zero length packets terminating a previous data phase
+		if n, err = c.dev.Read(buf); err != nil {
+			return 0, 0, nil, fmt.Errorf("mtp: failed to read container: %w", err)
+		}
+	}
+	if n < headerLength {
+		return 0, 0, nil, fmt.Errorf("mtp: short container: %d bytes", n)
+	}
+	length := int(binary.LittleEndian.Uint32(buf[0:]))
+	kind = binary.LittleEndian.Uint16(buf[4:])
+	code = binary.LittleEndian.Uint16(buf[6:])
+	if length < headerLength {
+		return 0, 0, nil, fmt.Errorf("mtp: invalid container length %d", length)
+	}
+	payload = make([]byte, 0, length-headerLength)
+	payload = append(payload, buf[headerLength:n]...)
+
+	for len(payload) < length-headerLength {
+		if n, err = c.dev.Read(buf); err != nil {
+			return 0, 0, nil, fmt.Errorf("mtp: failed to read container: %w", err)
+		}
+		if n == 0 {
+			return 0, 0, nil, errors.New("mtp: truncated container")
+		}
+		payload = append(payload, buf[:n]...)
+	}
+	return kind, code, payload[:length-headerLength], nil
+}
+
+// OpenSession opens a session with the given non-zero id, which most other
+// operations require.
+func (c *Client) OpenSession(id uint32) error {
+	_, _, err := c.Transaction(OpOpenSession, []uint32{id}, nil)
+	if rerr := (*ResponseError)(nil); errors.As(err, &rerr) && rerr.Code == RespSessionAlreadyOpened {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	c.session, c.tid = id, 0
+	c.lock.Unlock()
+	return nil
+}
+
+// CloseSession closes the currently open session.
+func (c *Client) CloseSession() error {
+	if _, _, err := c.Transaction(OpCloseSession, nil, nil); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	c.session = 0
+	c.lock.Unlock()
+	return nil
+}
+
+// DeviceInfo retrieves the raw device info dataset. It doesn't need a session.
+func (c *Client) DeviceInfo() ([]byte, error) {
+	_, data, err := c.Transaction(OpGetDeviceInfo, nil, nil)
+	return data, err
+}
+
+// StorageIDs retrieves the ids of the storages available on the device.
+func (c *Client) StorageIDs() ([]uint32, error) {
+	if err := c.checkSession(); err != nil {
+		return nil, err
+	}
+	_, data, err := c.Transaction(OpGetStorageIDs, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeArray(data)
+}
+
+// ObjectHandles retrieves the handles of the objects in a storage (0xffffffff
+// for all), optionally filtered by format and parent object (0 for any).
+func (c *Client) ObjectHandles(storage, format, parent uint32) ([]uint32, error) {
+	if err := c.checkSession(); err != nil {
+		return nil, err
+	}
+	_, data, err := c.Transaction(OpGetObjectHandles, []uint32{storage, format, parent}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeArray(data)
+}
+
+// ObjectInfo retrieves the raw object info dataset of an object.
+func (c *Client) ObjectInfo(handle uint32) ([]byte, error) {
+	if err := c.checkSession(); err != nil {
+		return nil, err
+	}
+	_, data, err := c.Transaction(OpGetObjectInfo, []uint32{handle}, nil)
+	return data, err
+}
+
+// Object downloads the contents of an object.
+func (c *Client) Object(handle uint32) ([]byte, error) {
+	if err := c.checkSession(); err != nil {
+		return nil, err
+	}
+	_, data, err := c.Transaction(OpGetObject, []uint32{handle}, nil)
+	return data, err
+}
+
+// checkSession ensures a session is open.
+func (c *Client) checkSession() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.session == 0 {
+		return ErrSessionClosed
+	}
+	return nil
+}
+
+// encodeParams serializes operation parameters.
+func encodeParams(params []uint32) []byte {
+	buf := make([]byte, 4*len(params))
+	for i, p := range params {
+		binary.LittleEndian.PutUint32(buf[4*i:], p)
+	}
+	return buf
+}
+
+// decodeParams deserializes response parameters.
+func decodeParams(buf []byte) []uint32 {
+	params := make([]uint32, len(buf)/4)
+	for i := range params {
+		params[i] = binary.LittleEndian.Uint32(buf[4*i:])
+	}
+	return params
+}
+
+// decodeArray deserializes a PTP array of uint32 values.
+func decodeArray(buf []byte) ([]uint32, error) {
+	if len(buf) < 4 {
+		return nil, errors.New("mtp: short array")
+	}
+	count := int(binary.LittleEndian.Uint32(buf))
+	if len(buf) < 4+4*count {
+		return nil, fmt.Errorf("mtp: truncated array of %d elements", count)
+	}
+	return decodeParams(buf[4 : 4+4*count]), nil
+}
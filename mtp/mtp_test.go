@@ -0,0 +1,59 @@
+package mtp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// fakeCamera replays a data phase split across several bulk transfers followed
+// by an OK response.
+type fakeCamera struct {
+	sent    [][]byte
+	pending [][]byte
+}
+
+func (f *fakeCamera) Write(b []byte) (int, error) {
+	f.sent = append(f.sent, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (f *fakeCamera) Read(b []byte) (int, error) {
+	n := copy(b, f.pending[0])
+	f.pending = f.pending[1:]
+	return n, nil
+}
+
+// container assembles a raw container for the fake device.
+func container(kind, code uint16, tid uint32, payload []byte) []byte {
+	buf := make([]byte, headerLength+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:], uint32(len(buf)))
+	binary.LittleEndian.PutUint16(buf[4:], kind)
+	binary.LittleEndian.PutUint16(buf[6:], code)
+	binary.LittleEndian.PutUint32(buf[8:], tid)
+	copy(buf[headerLength:], payload)
+	return buf
+}
+
+// Tests that data phases spanning multiple transfers are reassembled.
+func TestTransactionReassembly(t *testing.T) {
+	object := bytes.Repeat([]byte{0xde, 0xad, 0xbe, 0xef}, 64)
+	data := container(containerData, OpGetObject, 1, object)
+
+	cam := &fakeCamera{pending: [][]byte{
+		data[:100], data[100:], {}, container(containerResponse, RespOK, 1, nil),
+	}}
+	client := New(cam)
+	client.session = 1
+
+	have, err := client.Object(42)
+	if err != nil {
+		t.Fatalf("failed to get object: %v", err)
+	}
+	if !bytes.Equal(have, object) {
+		t.Errorf("object mismatch: have %d bytes, want %d", len(have), len(object))
+	}
+	if want := container(containerCommand, OpGetObject, 1, []byte{42, 0, 0, 0}); !bytes.Equal(cam.sent[0], want) {
+		t.Errorf("command mismatch: have %x, want %x", cam.sent[0], want)
+	}
+}
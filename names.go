@@ -0,0 +1,68 @@
+package zerousb
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var (
+	nameResolver     func(vendorID, productID uint16) (vendor, product string)
+	nameResolverLock sync.RWMutex
+)
+
+// RegisterNames installs the resolver DeviceInfo.String names vendors and
+// products with, returning empty names for unknown ids. The usbid package
+// registers its database when linked into the binary, so the package itself
+// doesn't depend on it.
+func RegisterNames(resolve func(vendorID, productID uint16) (vendor, product string)) {
+	nameResolverLock.Lock()
+	defer nameResolverLock.Unlock()
+
+	nameResolver = resolve
+}
+
+// names returns the vendor and product names of the info, falling back to the
+// strings reported by the device if no resolver knows them.
+func (info DeviceInfo) names() (string, string) {
+	nameResolverLock.RLock()
+	resolve := nameResolver
+	nameResolverLock.RUnlock()
+
+	var vendor, product string
+	if resolve != nil {
+		vendor, product = resolve(info.VendorID, info.ProductID)
+	}
+	if vendor == "" {
+		vendor = info.Manufacturer
+	}
+	if product == "" {
+		product = info.Product
+	}
+	return vendor, product
+}
+
+// String returns a line identifying the device for logs, in the format of
+//
+//	Bus 003 Port 2: 1d6b:0104 FooCorp Widget (vendor-specific)
+//
+// Vendor and product names come from the resolver registered by RegisterNames,
+// or the device strings. The class is the one of the interface if the device
+// declares its class per interface.
+func (info DeviceInfo) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Bus %03d Port %d: %s:%s", info.Bus, info.Port, ID(info.VendorID), ID(info.ProductID))
+
+	vendor, product := info.names()
+	for _, name := range []string{vendor, product} {
+		if name != "" {
+			b.WriteString(" " + name)
+		}
+	}
+	class := Class(info.Class)
+	if class == ClassPerInterface {
+		class = Class(info.InterfaceClass)
+	}
+	fmt.Fprintf(&b, " (%s)", class)
+	return b.String()
+}
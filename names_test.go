@@ -0,0 +1,34 @@
+package zerousb
+
+import "testing"
+
+// Tests that infos are formatted with registered names, falling back to the
+// device strings.
+func TestDeviceInfoString(t *testing.T) {
+	defer RegisterNames(nil)
+
+	info := DeviceInfo{VendorID: 0x1d6b, ProductID: 0x0104, Bus: 3, Port: 2, Class: uint8(ClassVendorSpec)}
+	tests := []struct {
+		resolve func(vendorID, productID uint16) (string, string)
+		info    DeviceInfo
+		want    string
+	}{
+		{nil, info, "Bus 003 Port 2: 1d6b:0104 (vendor-specific)"},
+		{
+			func(uint16, uint16) (string, string) { return "FooCorp", "Widget" },
+			info,
+			"Bus 003 Port 2: 1d6b:0104 FooCorp Widget (vendor-specific)",
+		},
+		{
+			func(uint16, uint16) (string, string) { return "FooCorp", "" },
+			DeviceInfo{VendorID: 0x1d6b, ProductID: 0x0104, Bus: 3, Port: 2, Product: "Gizmo", InterfaceClass: uint8(ClassData)},
+			"Bus 003 Port 2: 1d6b:0104 FooCorp Gizmo (data)",
+		},
+	}
+	for i, tt := range tests {
+		RegisterNames(tt.resolve)
+		if have := tt.info.String(); have != tt.want {
+			t.Errorf("test %d: string mismatch: have %q, want %q", i, have, tt.want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package zerousb
+
+import (
+	"fmt"
+	"time"
+)
+
+// OpenOption customizes how Open connects to a device.
+type OpenOption func(*openOptions)
+
+// openOptions collects the settings of the options passed to Open.
+type openOptions struct {
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	controlTimeout *time.Duration
+	retry          *RetryPolicy
+	metrics        Metrics
+	tracer         Tracer
+	transferLog    *TransferLog
+	noDetach       bool  // Leave kernel drivers bound, failing claims of their interfaces
+	config         *int  // Configuration to activate, if any
+	interfaces     []int // Interfaces to claim besides the one of the info
+}
+
+// WithReadTimeout sets the timeout of reads, zero for none and negative to poll.
+func WithReadTimeout(timeout time.Duration) OpenOption {
+	return func(o *openOptions) { o.readTimeout = timeout }
+}
+
+// WithWriteTimeout sets the timeout of writes, zero for none and negative to
+// poll.
+func WithWriteTimeout(timeout time.Duration) OpenOption {
+	return func(o *openOptions) { o.writeTimeout = timeout }
+}
+
+// WithControlTimeout sets the timeout of control requests, zero for none. It
+// defaults to 5 seconds.
+func WithControlTimeout(timeout time.Duration) OpenOption {
+	return func(o *openOptions) { o.controlTimeout = &timeout }
+}
+
+// WithRetryPolicy configures retries of Read and Write transfers failing with
+// transient errors.
+func WithRetryPolicy(policy *RetryPolicy) OpenOption {
+	return func(o *openOptions) { o.retry = policy }
+}
+
+// WithMetrics reports the Read and Write transfers of the device to m.
+func WithMetrics(m Metrics) OpenOption {
+	return func(o *openOptions) { o.metrics = m }
+}
+
+// WithTracer emits spans around Open along with the Read, Write and Control
+// calls of the opened device, see Traced.
+func WithTracer(tracer Tracer) OpenOption {
+	return func(o *openOptions) { o.tracer = tracer }
+}
+
+// WithTransferLog logs the Read, Write and Control calls of the opened device
+// to l, see TransferLog.
+func WithTransferLog(l *TransferLog) OpenOption {
+	return func(o *openOptions) { o.transferLog = l }
+}
+
+// WithNoKernelDetach leaves kernel drivers bound to the claimed interfaces,
+// failing the open with an error matching ErrBusy instead of detaching them.
+// Only the libusb backend detaches kernel drivers in the first place.
+func WithNoKernelDetach() OpenOption {
+	return func(o *openOptions) { o.noDetach = true }
+}
+
+// WithConfiguration activates a configuration of the device unless it's active
+// already. The interface of the info must exist in the configuration.
+func WithConfiguration(config int) OpenOption {
+	return func(o *openOptions) { o.config = &config }
+}
+
+// WithInterfaces claims interfaces of a composite device in addition to the
+// one of the info, which Read and Write transfer through.
+func WithInterfaces(ifaces ...int) OpenOption {
+	return func(o *openOptions) { o.interfaces = append(o.interfaces, ifaces...) }
+}
+
+// Open connects to a previously discovered USB device, claiming the interface
+// of the info. Devices returned by ListDevices are opened on their first
+// interface with endpoints in both directions.
+func Open(info DeviceInfo, opts ...OpenOption) (Device, error) {
+	options := new(openOptions)
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.tracer == nil {
+		dev, _, err := openDevice(info, options)
+		return dev, err
+	}
+	span := options.tracer.Start("usb.Open", deviceAttributes(info))
+	dev, info, err := openDevice(info, options)
+	if err != nil {
+		span.End(resultAttributes(0, err), err)
+		return nil, err
+	}
+	span.End(nil, nil)
+	return Traced(dev, info, options.tracer), nil
+}
+
+// openDevice opens the device of an info resolved by resolve, configuring it
+// according to the options. The resolved info is returned along the device.
+func openDevice(info DeviceInfo, options *openOptions) (Device, DeviceInfo, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	info, err := resolve(info)
+	if err != nil {
+		return nil, info, err
+	}
+	dev, err := openInfo(info, options)
+	if err != nil {
+		return nil, info, err
+	}
+	if err := options.apply(dev); err != nil {
+		dev.Close()
+		return nil, info, err
+	}
+	if options.transferLog != nil {
+		dev = options.transferLog.Wrap(dev, info)
+	}
+	return dev, info, nil
+}
+
+// OpenPath connects to a device through its device node, bypassing the
+// enumeration, which fails where access is only granted to that node (e.g. by
+// a udev rule). The first interface with endpoints in both directions is
+// claimed. The node is closed along with the device.
+//
+// Only supported on Linux, by the usbfs nodes, e.g. /dev/bus/usb/003/007.
+func OpenPath(path string, opts ...OpenOption) (Device, error) {
+	options := new(openOptions)
+	for _, opt := range opts {
+		opt(options)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := builtinOnly("open device"); err != nil {
+		return nil, err
+	}
+	dev, info, err := openPath(path, options)
+	if err != nil {
+		return nil, err
+	}
+	return options.finish(dev, info)
+}
+
+// finish configures a device opened without going through openDevice
+// according to the options, logging and tracing it with the info it was
+// opened on.
+func (o *openOptions) finish(dev Device, info DeviceInfo) (Device, error) {
+	if err := o.apply(dev); err != nil {
+		dev.Close()
+		return nil, err
+	}
+	if o.transferLog != nil {
+		dev = o.transferLog.Wrap(dev, info)
+	}
+	if o.tracer != nil {
+		return Traced(dev, info, o.tracer), nil
+	}
+	return dev, nil
+}
+
+// OpenSnapshot opens the device interface described by an info which was
+// stored or sent between processes (e.g. as JSON), so it isn't tied to the
+// enumeration it came from. The device is looked up again by its ids and
+// interface, identified by its serial number, OS path or bus position in that
+// order of preference, so it's found again after replugs as far as possible.
+func OpenSnapshot(info DeviceInfo, opts ...OpenOption) (Device, error) {
+	// Drop any backend state, forcing the device to be enumerated again
+	info.unresolved, info.backend, info.libusbDevice = false, "", nil
+	return Open(info, opts...)
+}
+
+// resolve turns an info into one the backend can open: devices listed by
+// ListDevices are resolved to their first interface with endpoints in both
+// directions, infos without backend state (snapshots) are enumerated again.
+func resolve(info DeviceInfo) (DeviceInfo, error) {
+	switch {
+	case info.unresolved:
+		ifaces, err := interfaces(info, true)
+		if err != nil {
+			return info, err
+		}
+		if len(ifaces) == 0 {
+			return info, fmt.Errorf("failed to open device: no interface with in and out endpoints: %w", ErrNotFound)
+		}
+		return ifaces[0], nil
+
+	case info.backend != "":
+		// Registered backends keep their state to themselves
+		return info, nil
+
+	case info.libusbDevice == nil:
+		matches, err := enumerate(func(match DeviceInfo) bool { return snapshotMatch(info, match) }, true)
+		if err != nil {
+			return info, err
+		}
+		if len(matches) == 0 {
+			return info, fmt.Errorf("failed to open device: %s interface %d not found: %w", info.Path, info.Interface, ErrNoDevice)
+		}
+		return matches[0], nil
+	}
+	return info, nil
+}
+
+// snapshotMatch reports whether an enumerated interface is the one a snapshot
+// was taken of. Serial numbers and OS paths are only compared if the backend
+// reports them for both, falling back to the position on the bus.
+func snapshotMatch(snapshot, info DeviceInfo) bool {
+	if info.VendorID != snapshot.VendorID || info.ProductID != snapshot.ProductID ||
+		info.Interface != snapshot.Interface || info.InterfaceAlternate != snapshot.InterfaceAlternate {
+		return false
+	}
+	switch {
+	case snapshot.Serial != "" && info.Serial != "":
+		return info.Serial == snapshot.Serial
+	case snapshot.SysPath != "" && info.SysPath != "":
+		return info.SysPath == snapshot.SysPath
+	}
+	return info.Bus == snapshot.Bus && info.Path == snapshot.Path
+}
+
+// apply configures a freshly opened device according to the options.
+func (o *openOptions) apply(dev Device) error {
+	// Freshly opened devices have no transfer timeouts, so the defaults match
+	if o.readTimeout != 0 || o.writeTimeout != 0 {
+		dev.SetTimeouts(o.readTimeout, o.writeTimeout)
+	}
+	if o.controlTimeout != nil {
+		dev.SetControlTimeout(*o.controlTimeout)
+	}
+	if o.retry != nil {
+		dev.SetRetryPolicy(o.retry)
+	}
+	if o.metrics != nil {
+		dev.SetMetrics(o.metrics)
+	}
+	if o.config != nil {
+		// Activating the active configuration again resets the device state
+		if active, err := dev.Configuration(); err != nil || active != *o.config {
+			if err := dev.SetConfiguration(*o.config); err != nil {
+				return err
+			}
+		}
+	}
+	for _, iface := range o.interfaces {
+		if err := dev.ClaimInterface(iface); err != nil {
+			return err
+		}
+	}
+	return nil
+}
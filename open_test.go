@@ -0,0 +1,109 @@
+package zerousb
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// optionDevice records the settings applied by the open options.
+type optionDevice struct {
+	Device // Unimplemented methods panic
+
+	active   int
+	calls    []string
+	timeouts [2]time.Duration
+	control  time.Duration
+}
+
+func (d *optionDevice) SetTimeouts(read, write time.Duration) {
+	d.timeouts = [2]time.Duration{read, write}
+}
+func (d *optionDevice) SetControlTimeout(timeout time.Duration) { d.control = timeout }
+func (d *optionDevice) Configuration() (int, error)             { return d.active, nil }
+func (d *optionDevice) SetConfiguration(config int) error {
+	d.calls = append(d.calls, "config")
+	return nil
+}
+func (d *optionDevice) ClaimInterface(iface int) error {
+	d.calls = append(d.calls, "claim")
+	return nil
+}
+
+// Tests that open options are applied onto the opened device, activating the
+// configuration before claiming further interfaces, and only if needed.
+func TestOpenOptions(t *testing.T) {
+	tests := []struct {
+		opts     []OpenOption
+		active   int
+		calls    []string
+		timeouts [2]time.Duration
+		control  time.Duration
+	}{
+		{nil, 1, nil, [2]time.Duration{}, 0},
+		{[]OpenOption{WithReadTimeout(100 * time.Millisecond), WithWriteTimeout(-1), WithControlTimeout(300 * time.Millisecond)}, 1, nil, [2]time.Duration{100 * time.Millisecond, -1}, 300 * time.Millisecond},
+		{[]OpenOption{WithWriteTimeout(time.Second)}, 1, nil, [2]time.Duration{0, time.Second}, 0},
+		{[]OpenOption{WithInterfaces(1, 2), WithConfiguration(2)}, 1, []string{"config", "claim", "claim"}, [2]time.Duration{}, 0},
+		{[]OpenOption{WithConfiguration(1)}, 1, nil, [2]time.Duration{}, 0},
+	}
+	for i, tt := range tests {
+		options := new(openOptions)
+		for _, opt := range tt.opts {
+			opt(options)
+		}
+		dev := &optionDevice{active: tt.active}
+		if err := options.apply(dev); err != nil {
+			t.Errorf("test %d: failed to apply options: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(dev.calls, tt.calls) {
+			t.Errorf("test %d: call mismatch: have %v, want %v", i, dev.calls, tt.calls)
+		}
+		if dev.timeouts != tt.timeouts {
+			t.Errorf("test %d: timeout mismatch: have %v, want %v", i, dev.timeouts, tt.timeouts)
+		}
+		if dev.control != tt.control {
+			t.Errorf("test %d: control timeout mismatch: have %d, want %d", i, dev.control, tt.control)
+		}
+	}
+}
+
+// Tests that snapshots survive serialization and are matched against freshly
+// enumerated interfaces by serial, OS path or bus position.
+func TestSnapshotMatch(t *testing.T) {
+	info := DeviceInfo{Path: "1-3:1", SysPath: "/sys/bus/usb/devices/1-3", VendorID: 0x1234, ProductID: 0x5678, Serial: "A1", Bus: 1, Port: 3, Interface: 1}
+
+	blob, err := json.Marshal(info)
+	if err != nil {
+		t.Fatalf("failed to marshal info: %v", err)
+	}
+	var snapshot DeviceInfo
+	if err := json.Unmarshal(blob, &snapshot); err != nil {
+		t.Fatalf("failed to unmarshal info: %v", err)
+	}
+	if !reflect.DeepEqual(snapshot, info) {
+		t.Fatalf("snapshot mismatch: have %+v, want %+v", snapshot, info)
+	}
+	tests := []struct {
+		mutate func(*DeviceInfo)
+		match  bool
+	}{
+		{func(*DeviceInfo) {}, true},
+		{func(d *DeviceInfo) { d.Interface = 0 }, false},
+		{func(d *DeviceInfo) { d.ProductID = 0x5679 }, false},
+		{func(d *DeviceInfo) { d.Path, d.SysPath, d.Port = "1234:5678:04", "/sys/bus/usb/devices/1-4", 4 }, true}, // Replugged, same serial
+		{func(d *DeviceInfo) { d.Serial = "B2" }, false},
+		{func(d *DeviceInfo) { d.Serial = "" }, true},                                         // Serial unknown, same path
+		{func(d *DeviceInfo) { d.Serial, d.SysPath = "", "/sys/bus/usb/devices/1-4" }, false}, // Serial unknown, other path
+		{func(d *DeviceInfo) { d.Serial, d.SysPath = "", "" }, true},                          // Only the bus position is known
+		{func(d *DeviceInfo) { d.Serial, d.SysPath, d.Bus = "", "", 2 }, false},
+	}
+	for i, tt := range tests {
+		enumerated := info
+		tt.mutate(&enumerated)
+		if have := snapshotMatch(snapshot, enumerated); have != tt.match {
+			t.Errorf("test %d: match mismatch: have %v, want %v", i, have, tt.match)
+		}
+	}
+}
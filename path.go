@@ -0,0 +1,96 @@
+package zerousb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DevicePath is the position of a device, or of one of its interfaces, in the
+// USB topology. DeviceInfo.Path holds it in the form String returns, which
+// follows the sysfs naming of Linux: the bus, the hub ports leading from the
+// root hub to the device and the interface number, e.g. 1-4.2:0 for interface
+// 0 of the device on port 2 of the hub on port 4 of bus 1.
+//
+// Backends not exposing the hub chain report the bus as zero and only the last
+// port: WinUSB the port on the parent hub, WebUSB the position of the device
+// among the ones granted to the page, counting from one. The ugen backends of
+// OpenBSD and NetBSD report the device address in place of the port.
+type DevicePath struct {
+	Bus       uint8
+	Ports     []uint8 // Hub ports from the root hub down to the device, empty for root hubs
+	Interface int     // Interface number, -1 for the whole device (ListDevices)
+}
+
+// String formats the path as bus-ports:interface, with the ports separated by
+// dots, a single 0 for root hubs and the interface left out for whole devices.
+func (p DevicePath) String() string {
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(int(p.Bus)))
+	b.WriteByte('-')
+	if len(p.Ports) == 0 {
+		b.WriteByte('0')
+	}
+	for i, port := range p.Ports {
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(strconv.Itoa(int(port)))
+	}
+	if p.Interface >= 0 {
+		b.WriteByte(':')
+		b.WriteString(strconv.Itoa(p.Interface))
+	}
+	return b.String()
+}
+
+// ParsePath parses a path in the form DevicePath.String formats it.
+func ParsePath(path string) (DevicePath, error) {
+	p := DevicePath{Interface: -1}
+
+	device, iface, hasIface := strings.Cut(path, ":")
+	if hasIface {
+		n, err := strconv.ParseUint(iface, 10, 8)
+		if err != nil {
+			return DevicePath{}, fmt.Errorf("invalid path %q: bad interface: %w", path, err)
+		}
+		p.Interface = int(n)
+	}
+	bus, ports, ok := strings.Cut(device, "-")
+	if !ok {
+		return DevicePath{}, fmt.Errorf("invalid path %q: missing port chain", path)
+	}
+	n, err := strconv.ParseUint(bus, 10, 8)
+	if err != nil {
+		return DevicePath{}, fmt.Errorf("invalid path %q: bad bus: %w", path, err)
+	}
+	p.Bus = uint8(n)
+
+	if ports == "0" {
+		return p, nil
+	}
+	chain := strings.Split(ports, ".")
+	if len(chain) > 7 {
+		return DevicePath{}, fmt.Errorf("invalid path %q: port chain deeper than 7 hubs", path)
+	}
+	for _, port := range chain {
+		n, err := strconv.ParseUint(port, 10, 8)
+		if err != nil || n == 0 {
+			return DevicePath{}, fmt.Errorf("invalid path %q: bad port %q", path, port)
+		}
+		p.Ports = append(p.Ports, uint8(n))
+	}
+	return p, nil
+}
+
+// pathWithInterface appends an interface number to the path of a whole device.
+func pathWithInterface(device string, iface int) string {
+	return device + ":" + strconv.Itoa(iface)
+}
+
+// devicePath strips the interface number off a path, leaving the one of the
+// whole device.
+func devicePath(path string) string {
+	device, _, _ := strings.Cut(path, ":")
+	return device
+}
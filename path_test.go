@@ -0,0 +1,42 @@
+package zerousb
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Tests that paths are formatted after the sysfs naming and parsed back.
+func TestDevicePath(t *testing.T) {
+	tests := []struct {
+		path DevicePath
+		want string
+	}{
+		{DevicePath{Bus: 1, Ports: []uint8{4, 2}, Interface: 0}, "1-4.2:0"},
+		{DevicePath{Bus: 2, Ports: []uint8{4, 2}, Interface: 0}, "2-4.2:0"},
+		{DevicePath{Bus: 1, Ports: []uint8{4, 2}, Interface: 3}, "1-4.2:3"},
+		{DevicePath{Bus: 12, Ports: []uint8{1}, Interface: -1}, "12-1"},
+		{DevicePath{Bus: 3, Interface: -1}, "3-0"},
+		{DevicePath{Bus: 3, Interface: 0}, "3-0:0"},
+	}
+	for i, tt := range tests {
+		if have := tt.path.String(); have != tt.want {
+			t.Errorf("test %d: path mismatch: have %s, want %s", i, have, tt.want)
+		}
+		have, err := ParsePath(tt.want)
+		if err != nil {
+			t.Errorf("test %d: failed to parse path: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(have, tt.path) {
+			t.Errorf("test %d: parsed path mismatch: have %+v, want %+v", i, have, tt.path)
+		}
+	}
+	for _, path := range []string{"", "1", "1-", "a-1", "1-4..2", "1-0.2", "1-4:x", "1-4:256", "256-1", "1-1.2.3.4.5.6.7.8", "1234:5678:01"} {
+		if _, err := ParsePath(path); err == nil {
+			t.Errorf("invalid path %q parsed", path)
+		}
+	}
+	if have := devicePath("1-4.2:3"); have != "1-4.2" {
+		t.Errorf("device path mismatch: have %s, want 1-4.2", have)
+	}
+}
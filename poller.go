@@ -0,0 +1,221 @@
+package zerousb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrPollerClosed is returned by Next once the poller is closed.
+var ErrPollerClosed = errors.New("usb: poller closed")
+
+// Report is an input report read by a Poller from an interrupt IN endpoint.
+type Report struct {
+	Data    []byte // Payload of the interrupt transfer
+	Dropped int    // Reports dropped right before this one, the ring being full
+}
+
+// Poller continuously reads the interrupt IN endpoint of a device, buffering
+// the reports in a bounded ring until consumed through Next. If consumers fall
+// behind, the oldest reports are dropped and accounted for in the Dropped
+// field of the report following them.
+//
+// The host controller schedules the transfers at the bInterval of the endpoint,
+// the poller keeps a read in flight so no slot is missed. The poller owns the
+// IN endpoint until closed, and must be closed before the device itself.
+type Poller struct {
+	dev      Device
+	size     int           // Size of the reads, the max packet size of the endpoint
+	interval time.Duration // Endpoint interval, waited after empty or timed out reads
+
+	ring    []Report      // Buffered reports, oldest at head
+	head    int           // Index of the oldest buffered report
+	count   int           // Number of buffered reports
+	dropped uint64        // Number of reports dropped overall
+	err     error         // Sticky error of the failed read, or ErrPollerClosed
+	notify  chan struct{} // Closed and replaced whenever a report is buffered or the poller stops
+	lock    sync.Mutex
+
+	stop chan struct{} // Closed to terminate the goroutine
+	done chan struct{} // Closed when the goroutine terminated
+}
+
+// NewPoller starts polling the interrupt IN endpoint of a device, as described
+// by the info it was opened with. Up to capacity reports are buffered.
+func NewPoller(dev Device, info DeviceInfo, capacity int) (*Poller, error) {
+	if capacity <= 0 {
+		return nil, errors.New("usb: poller capacity must be positive")
+	}
+	end, ok := readEndpoint(info)
+	if !ok || end.TransferType() != TransferTypeInterrupt {
+		return nil, errors.New("usb: device has no interrupt IN endpoint")
+	}
+	// The interval counts frames, which last a millisecond at low and full
+	// speed. Faster devices poll more often, waiting longer is harmless though.
+	interval := time.Duration(end.Interval) * time.Millisecond
+	if interval == 0 {
+		interval = time.Millisecond
+	}
+	p := &Poller{
+		dev:      dev,
+		size:     int(end.MaxPacketSize),
+		interval: interval,
+		ring:     make([]Report, capacity),
+		notify:   make(chan struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go p.loop()
+	return p, nil
+}
+
+// readEndpoint returns the endpoint Read transfers through, the last interrupt
+// or bulk IN endpoint of the interface as picked by the backends.
+func readEndpoint(info DeviceInfo) (EndpointInfo, bool) {
+	var (
+		found EndpointInfo
+		ok    bool
+	)
+	for _, end := range info.Endpoints {
+		if kind := end.TransferType(); kind != TransferTypeInterrupt && kind != TransferTypeBulk {
+			continue
+		}
+		if end.Direction() == EndpointDirectionIn {
+			found, ok = end, true
+		}
+	}
+	return found, ok
+}
+
+// loop reads reports into the ring until stopped or a read fails. Reads timing
+// out are retried, the device just had nothing to report.
+func (p *Poller) loop() {
+	defer close(p.done)
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+		buf := make([]byte, p.size)
+		n, err := p.dev.Read(buf)
+		if n > 0 {
+			p.push(buf[:n])
+		}
+		if err != nil && !errors.Is(err, ErrTimeout) {
+			p.fail(err)
+			return
+		}
+		if n == 0 {
+			select {
+			case <-p.stop:
+				return
+			case <-time.After(p.interval):
+			}
+		}
+	}
+}
+
+// push buffers a report, dropping the oldest one if the ring is full. Reports
+// completing after the poller was closed are discarded.
+func (p *Poller) push(data []byte) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.err == ErrPollerClosed {
+		return
+	}
+
+	report := Report{Data: data}
+	if p.count == len(p.ring) {
+		report.Dropped = p.ring[p.head].Dropped + 1
+		p.head = (p.head + 1) % len(p.ring)
+		p.count--
+		p.dropped++
+
+		if p.count > 0 {
+			// Charge the drop onto the oldest report left, it follows the gap
+			p.ring[p.head].Dropped += report.Dropped
+			report.Dropped = 0
+		}
+	}
+	p.ring[(p.head+p.count)%len(p.ring)] = report
+	p.count++
+	p.signal()
+}
+
+// fail stops the poller for the given reason, unless already stopped.
+func (p *Poller) fail(err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.err == nil {
+		p.err = err
+		p.signal()
+	}
+}
+
+// signal wakes the consumers waiting in Next. The lock must be held.
+func (p *Poller) signal() {
+	close(p.notify)
+	p.notify = make(chan struct{})
+}
+
+// Next returns the oldest buffered report, blocking until one is read or the
+// context is done. Once a read fails, the buffered reports are returned first,
+// then the error.
+func (p *Poller) Next(ctx context.Context) (Report, error) {
+	for {
+		p.lock.Lock()
+		if p.count > 0 {
+			report := p.ring[p.head]
+			p.ring[p.head] = Report{}
+			p.head = (p.head + 1) % len(p.ring)
+			p.count--
+			p.lock.Unlock()
+			return report, nil
+		}
+		if p.err != nil {
+			err := p.err
+			p.lock.Unlock()
+			return Report{}, err
+		}
+		notify := p.notify
+		p.lock.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return Report{}, ctx.Err()
+		}
+	}
+}
+
+// Dropped returns the number of reports dropped so far, the ring being full.
+func (p *Poller) Dropped() uint64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.dropped
+}
+
+// Close stops polling and discards the buffered reports. A read in flight is
+// not interrupted, its result is discarded when it completes.
+func (p *Poller) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	select {
+	case <-p.stop:
+		return nil
+	default:
+	}
+	close(p.stop)
+
+	p.head, p.count = 0, 0
+	p.err = ErrPollerClosed
+	p.signal()
+	return nil
+}
@@ -0,0 +1,90 @@
+package zerousb
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// reportDevice hands out a fixed sequence of reports, then fails its reads.
+type reportDevice struct {
+	Device // Unimplemented methods panic
+
+	reports []string
+	err     error
+}
+
+func (d *reportDevice) Read(b []byte) (int, error) {
+	if len(d.reports) == 0 {
+		return 0, d.err
+	}
+	n := copy(b, d.reports[0])
+	d.reports = d.reports[1:]
+	return n, nil
+}
+
+// Tests that the poller buffers reports up to its capacity, accounting for the
+// dropped ones, and hands out the remaining reports before the read failure.
+func TestPoller(t *testing.T) {
+	info := DeviceInfo{Endpoints: []EndpointInfo{
+		{Address: 0x01, Attributes: uint8(TransferTypeInterrupt), MaxPacketSize: 8},
+		{Address: 0x81, Attributes: uint8(TransferTypeInterrupt), MaxPacketSize: 8, Interval: 1},
+	}}
+	errRead := errors.New("read failed")
+
+	tests := []struct {
+		capacity int
+		reports  []string
+		want     []Report
+		dropped  uint64
+	}{
+		{4, []string{"a", "b"}, []Report{{Data: []byte("a")}, {Data: []byte("b")}}, 0},
+		{2, []string{"a", "b", "c", "d", "e"}, []Report{{Data: []byte("d"), Dropped: 3}, {Data: []byte("e")}}, 3},
+		{1, []string{"a", "b", "c"}, []Report{{Data: []byte("c"), Dropped: 2}}, 2},
+	}
+	for i, tt := range tests {
+		p, err := NewPoller(&reportDevice{reports: tt.reports, err: errRead}, info, tt.capacity)
+		if err != nil {
+			t.Fatalf("test %d: failed to create poller: %v", i, err)
+		}
+		select {
+		case <-p.done:
+		case <-time.After(time.Second):
+			t.Fatalf("test %d: poller didn't stop on read failure", i)
+		}
+		var have []Report
+		for {
+			report, err := p.Next(context.Background())
+			if err != nil {
+				if err != errRead {
+					t.Errorf("test %d: error mismatch: have %v, want %v", i, err, errRead)
+				}
+				break
+			}
+			have = append(have, report)
+		}
+		if !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("test %d: report mismatch: have %+v, want %+v", i, have, tt.want)
+		}
+		if dropped := p.Dropped(); dropped != tt.dropped {
+			t.Errorf("test %d: dropped count mismatch: have %d, want %d", i, dropped, tt.dropped)
+		}
+		p.Close()
+	}
+}
+
+// Tests that pollers are only created on interfaces reading from an interrupt
+// endpoint.
+func TestPollerEndpoint(t *testing.T) {
+	bulk := DeviceInfo{Endpoints: []EndpointInfo{
+		{Address: 0x82, Attributes: uint8(TransferTypeBulk), MaxPacketSize: 64},
+	}}
+	if _, err := NewPoller(&reportDevice{}, bulk, 1); err == nil {
+		t.Errorf("poller created on bulk endpoint")
+	}
+	if _, err := NewPoller(&reportDevice{}, DeviceInfo{}, 1); err == nil {
+		t.Errorf("poller created without endpoints")
+	}
+}
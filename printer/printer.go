@@ -0,0 +1,171 @@
+// Package printer implements the USB Printer Class 1.1 on top of zerousb: the
+// IEEE 1284 device ID, port status and soft reset class requests along with a
+// job oriented write path, allowing label and receipt printers to be driven
+// without going through CUPS.
+package printer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/chay22/zerousb"
+)
+
+// Class requests of the USB Printer Class 1.1 spec, section 4.2.
+const (
+	requestGetDeviceID   = 0x00
+	requestGetPortStatus = 0x01
+	requestSoftReset     = 0x02
+)
+
+// maxDeviceID is the largest device ID string accepted, length prefix included.
+const maxDeviceID = 1024
+
+// Status is the port status of the printer, mirroring a parallel port.
+type Status uint8
+
+// PaperEmpty reports whether the printer is out of paper.
+func (s Status) PaperEmpty() bool { return s&0x20 != 0 }
+
+// Selected reports whether the printer is online.
+func (s Status) Selected() bool { return s&0x10 != 0 }
+
+// Error reports whether the printer signals an error condition.
+func (s Status) Error() bool { return s&0x08 == 0 }
+
+// String returns a human readable summary of the status.
+func (s Status) String() string {
+	var flags []string
+	if s.Selected() {
+		flags = append(flags, "selected")
+	}
+	if s.PaperEmpty() {
+		flags = append(flags, "paper empty")
+	}
+	if s.Error() {
+		flags = append(flags, "error")
+	}
+	if len(flags) == 0 {
+		return "idle"
+	}
+	return strings.Join(flags, ", ")
+}
+
+// Transport is the subset of zerousb.Device needed to drive a printer.
+type Transport interface {
+	Write(b []byte) (int, error)
+	Control(rType, request uint8, val, idx uint16, data []byte) (int, error)
+}
+
+// Printer is a handle to the printer interface of an opened device.
+type Printer struct {
+	dev       Transport
+	iface     uint8
+	alt       uint8
+	maxPacket int // Max packet size of the bulk OUT endpoint
+
+	written int // Bytes written in the current job
+}
+
+// New wraps an opened device enumerated on a printer class interface.
+func New(dev Transport, info zerousb.DeviceInfo) *Printer {
+	p := &Printer{
+		dev:       dev,
+		iface:     uint8(info.Interface),
+		alt:       uint8(info.InterfaceAlternate),
+		maxPacket: 64,
+	}
+	for _, end := range info.Endpoints {
+		if end.Direction() == zerousb.EndpointDirectionOut && end.TransferType() == zerousb.TransferTypeBulk {
+			p.maxPacket = int(end.MaxPacketSize)
+			break
+		}
+	}
+	return p
+}
+
+// DeviceID retrieves the raw IEEE 1284 device ID string of the printer.
+func (p *Printer) DeviceID() (string, error) {
+	buf := make([]byte, maxDeviceID)
+
+	// wValue is the configuration index, wIndex the interface and alternate
+	n, err := p.dev.Control(zerousb.ControlIn|zerousb.ControlClass|zerousb.ControlInterface, requestGetDeviceID, 0, uint16(p.iface)<<8|uint16(p.alt), buf)
+	if err != nil {
+		return "", fmt.Errorf("printer: failed to get device id: %w", err)
+	}
+	if n < 2 {
+		return "", errors.New("printer: short device id")
+	}
+	// The big endian length prefix counts itself too
+	length := int(binary.BigEndian.Uint16(buf))
+	if length < 2 || length > n {
+		length = n
+	}
+	return string(buf[2:length]), nil
+}
+
+// ParseDeviceID splits an IEEE 1284 device ID into its keys and values, e.g.
+// "MFG:ACME;MDL:Label 42;CMD:ZPL;". Keys are returned as-is, the commonly
+// abbreviated ones (MFG, MDL, CMD) included.
+func ParseDeviceID(id string) map[string]string {
+	fields := make(map[string]string)
+	for _, pair := range strings.Split(id, ";") {
+		key, value, ok := strings.Cut(pair, ":")
+		if !ok {
+			continue
+		}
+		fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return fields
+}
+
+// PortStatus retrieves the status of the printer.
+func (p *Printer) PortStatus() (Status, error) {
+	buf := make([]byte, 1)
+	if _, err := p.dev.Control(zerousb.ControlIn|zerousb.ControlClass|zerousb.ControlInterface, requestGetPortStatus, 0, uint16(p.iface), buf); err != nil {
+		return 0, fmt.Errorf("printer: failed to get port status: %w", err)
+	}
+	return Status(buf[0]), nil
+}
+
+// SoftReset flushes the printer's buffers and resets its bulk endpoints,
+// aborting the current job.
+func (p *Printer) SoftReset() error {
+	if _, err := p.dev.Control(zerousb.ControlOut|zerousb.ControlClass|zerousb.ControlInterface, requestSoftReset, 0, uint16(p.iface), nil); err != nil {
+		return fmt.Errorf("printer: failed to reset: %w", err)
+	}
+	p.written = 0
+	return nil
+}
+
+// Write sends job data to the printer.
+func (p *Printer) Write(b []byte) (int, error) {
+	n, err := p.dev.Write(b)
+	p.written += n
+	return n, err
+}
+
+// EndJob terminates the current job. If the data sent so far ended on a packet
+// boundary, a zero length packet is sent so the printer doesn't keep waiting
+// for the rest of the transfer.
+func (p *Printer) EndJob() error {
+	defer func() { p.written = 0 }()
+
+	if p.written > 0 && p.written%p.maxPacket == 0 {
+		if _, err := p.dev.Write(nil); err != nil {
+			return fmt.Errorf("printer: failed to end job: %w", err)
+		}
+	}
+	return nil
+}
+
+// Print sends a complete job read from r and terminates it.
+func (p *Printer) Print(r io.Reader) error {
+	if _, err := io.Copy(p, r); err != nil {
+		return fmt.Errorf("printer: failed to send job: %w", err)
+	}
+	return p.EndJob()
+}
@@ -0,0 +1,82 @@
+package printer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/chay22/zerousb"
+)
+
+// Tests that IEEE 1284 device IDs are split into trimmed keys and values,
+// skipping malformed pairs.
+func TestParseDeviceID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want map[string]string
+	}{
+		{"", map[string]string{}},
+		{
+			"MFG:ACME;MDL:Label 42;CMD:ZPL;",
+			map[string]string{"MFG": "ACME", "MDL": "Label 42", "CMD": "ZPL"},
+		},
+		{
+			"MANUFACTURER:Zebra Technologies;COMMAND SET:ZPL,EPL;MODEL:ZD420",
+			map[string]string{"MANUFACTURER": "Zebra Technologies", "COMMAND SET": "ZPL,EPL", "MODEL": "ZD420"},
+		},
+		{
+			" MFG : ACME ; MDL:X;;garbage;CLS:PRINTER;",
+			map[string]string{"MFG": "ACME", "MDL": "X", "CLS": "PRINTER"},
+		},
+		{
+			"DES:a:b;",
+			map[string]string{"DES": "a:b"},
+		},
+	}
+	for _, tt := range tests {
+		if have := ParseDeviceID(tt.id); !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("%q: fields mismatch: have %v, want %v", tt.id, have, tt.want)
+		}
+	}
+}
+
+// fakePrinter records the sizes of the bulk writes sent to it.
+type fakePrinter struct {
+	writes []int
+}
+
+func (f *fakePrinter) Write(b []byte) (int, error) {
+	f.writes = append(f.writes, len(b))
+	return len(b), nil
+}
+
+func (f *fakePrinter) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	return 0, nil
+}
+
+// Tests that jobs ending on a packet boundary are terminated with a zero length
+// packet, and only those.
+func TestEndJob(t *testing.T) {
+	info := zerousb.DeviceInfo{Endpoints: []zerousb.EndpointInfo{{Address: 0x01, Attributes: 0x02, MaxPacketSize: 512}}}
+	tests := []struct {
+		written int
+		writes  []int
+	}{
+		{0, nil},
+		{100, []int{100}},
+		{512, []int{512, 0}},
+		{1024, []int{1024, 0}},
+	}
+	for _, tt := range tests {
+		dev := new(fakePrinter)
+		p := New(dev, info)
+		if tt.written > 0 {
+			p.Write(make([]byte, tt.written))
+		}
+		if err := p.EndJob(); err != nil {
+			t.Fatalf("%d bytes: failed to end job: %v", tt.written, err)
+		}
+		if !reflect.DeepEqual(dev.writes, tt.writes) {
+			t.Errorf("%d bytes: writes mismatch: have %v, want %v", tt.written, dev.writes, tt.writes)
+		}
+	}
+}
@@ -0,0 +1,631 @@
+package zerousb
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrDisconnected is returned by a ReconnectingDevice while the underlying
+// device is unplugged and not yet reopened.
+var ErrDisconnected = errors.New("usb: device disconnected")
+
+// ConnState is the connection state of a ReconnectingDevice.
+type ConnState int
+
+const (
+	// StateConnected is reported when the device was (re)opened.
+	StateConnected ConnState = iota + 1
+
+	// StateDisconnected is reported when the device was unplugged.
+	StateDisconnected
+)
+
+// String returns a human readable name of the state.
+func (s ConnState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	}
+	return fmt.Sprintf("ConnState(%d)", int(s))
+}
+
+// ReconnectOptions tunes a ReconnectingDevice. The zero value is usable.
+type ReconnectOptions struct {
+	HID      bool          // Enumerate HID devices and interfaces too
+	Attempts int           // Open attempts after an arrival, defaults to 10
+	Delay    time.Duration // Delay between open attempts, defaults to 200ms
+}
+
+// ReconnectingDevice is a Device which reopens itself after being unplugged
+// and plugged back in, replaying the interface claims, alternate settings and
+// settings applied through it. Calls made while the device is away fail with
+// ErrDisconnected.
+type ReconnectingDevice struct {
+	lifetime // Signals the device being closed, disconnects are hidden
+
+	match  func(DeviceInfo) bool
+	opts   ReconnectOptions
+	states chan ConnState
+	unsub  func()
+
+	lock     sync.Mutex
+	dev      Device     // Currently opened device, nil while disconnected
+	info     DeviceInfo // Info the current device was opened with
+	closed   bool
+	claimed  map[int]bool
+	alts     map[int]int
+	config   *int              // Configuration, if ever set
+	wakeup   *bool             // Remote wakeup, if ever set
+	suspend  *autoSuspend      // Autosuspend, if ever set
+	timeout  *time.Duration    // Control timeout, if ever set
+	timeouts *[2]time.Duration // Read and write timeouts, if ever set
+	reattach *bool             // Reattach on close, if ever set
+	retry    *RetryPolicy      // Retry policy, if ever set
+	metrics  Metrics           // Transfer metrics, if ever set
+}
+
+// Reconnecting opens the first device interface accepted by the match function
+// and keeps it open across unplug and replug cycles, driven by hotplug events.
+// The match function is reused to find the device again after it returns. It
+// runs during enumeration like with Enumerate, so it must not call into the
+// package.
+func Reconnecting(match func(DeviceInfo) bool, opts *ReconnectOptions) (*ReconnectingDevice, error) {
+	dev := &ReconnectingDevice{
+		match:   match,
+		states:  make(chan ConnState, 16),
+		claimed: make(map[int]bool),
+		alts:    make(map[int]int),
+	}
+	if opts != nil {
+		dev.opts = *opts
+	}
+	if dev.opts.Attempts == 0 {
+		dev.opts.Attempts = 10
+	}
+	if dev.opts.Delay == 0 {
+		dev.opts.Delay = 200 * time.Millisecond
+	}
+	// Subscribe before opening so an unplug in between isn't missed
+	unsub, err := OnHotplug(func(event HotplugEvent, info DeviceInfo) {
+		// Handlers must not block, move the work off the event goroutine
+		go dev.hotplug(event, info)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to hotplug events: %w", err)
+	}
+	dev.unsub = unsub
+
+	dev.lock.Lock()
+	err = dev.reopen()
+	dev.lock.Unlock()
+
+	if err != nil {
+		unsub()
+		return nil, err
+	}
+	return dev, nil
+}
+
+// States returns the channel connection state changes are reported on. Changes
+// are dropped if the channel isn't drained. It is closed by Close.
+func (dev *ReconnectingDevice) States() <-chan ConnState {
+	return dev.states
+}
+
+// hotplug tracks the departure and return of the device.
+func (dev *ReconnectingDevice) hotplug(event HotplugEvent, info DeviceInfo) {
+	switch event {
+	case DeviceLeft:
+		dev.lock.Lock()
+		defer dev.lock.Unlock()
+
+		if dev.dev == nil || info.VendorID != dev.info.VendorID || info.ProductID != dev.info.ProductID ||
+			info.Bus != dev.info.Bus || info.Port != dev.info.Port {
+			return
+		}
+		dev.dev.Close()
+		dev.dev = nil
+		dev.notify(StateDisconnected)
+
+	case DeviceArrived:
+		// Freshly arrived devices may not be accessible yet (e.g. udev still
+		// applying permissions), so retry a few times
+		for i := 0; i < dev.opts.Attempts; i++ {
+			dev.lock.Lock()
+			if dev.closed || dev.dev != nil {
+				dev.lock.Unlock()
+				return
+			}
+			err := dev.reopen()
+			dev.lock.Unlock()
+
+			if err == nil {
+				return
+			}
+			time.Sleep(dev.opts.Delay)
+		}
+	}
+}
+
+// reopen finds and opens the device, replaying the recorded state onto it.
+// The lock must be held.
+func (dev *ReconnectingDevice) reopen() error {
+	var (
+		infos []DeviceInfo
+		err   error
+	)
+	if dev.opts.HID {
+		infos, err = EnumerateHID(dev.match)
+	} else {
+		infos, err = Enumerate(dev.match)
+	}
+	if err != nil {
+		return err
+	}
+	if len(infos) == 0 {
+		return errors.New("no matching device")
+	}
+	opened, err := infos[0].Open()
+	if err != nil {
+		return err
+	}
+	if err := dev.replay(opened); err != nil {
+		opened.Close()
+		return fmt.Errorf("failed to restore device state: %w", err)
+	}
+	dev.dev, dev.info = opened, infos[0]
+	dev.notify(StateConnected)
+	return nil
+}
+
+// replay applies the recorded settings, claims and alternate settings onto a
+// freshly opened device.
+func (dev *ReconnectingDevice) replay(opened Device) error {
+	if dev.timeout != nil {
+		opened.SetControlTimeout(*dev.timeout)
+	}
+	if dev.timeouts != nil {
+		opened.SetTimeouts(dev.timeouts[0], dev.timeouts[1])
+	}
+	if dev.reattach != nil {
+		opened.SetReattachOnClose(*dev.reattach)
+	}
+	if dev.retry != nil {
+		opened.SetRetryPolicy(dev.retry)
+	}
+	if dev.metrics != nil {
+		opened.SetMetrics(dev.metrics)
+	}
+	if dev.config != nil {
+		if err := opened.SetConfiguration(*dev.config); err != nil {
+			return err
+		}
+	}
+	for iface := range dev.claimed {
+		if err := opened.ClaimInterface(iface); err != nil {
+			return err
+		}
+	}
+	for iface, alt := range dev.alts {
+		if err := opened.SetAltSetting(iface, alt); err != nil {
+			return err
+		}
+	}
+	if dev.wakeup != nil {
+		if err := opened.SetRemoteWakeup(*dev.wakeup); err != nil {
+			return err
+		}
+	}
+	if dev.suspend != nil {
+		if err := opened.SetAutoSuspend(dev.suspend.enable, dev.suspend.delay); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notify reports a state change without blocking.
+func (dev *ReconnectingDevice) notify(state ConnState) {
+	select {
+	case dev.states <- state:
+	default:
+	}
+}
+
+// current returns the currently opened device, or the reason there is none.
+func (dev *ReconnectingDevice) current() (Device, error) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.closed {
+		return nil, ErrDeviceClosed
+	}
+	if dev.dev == nil {
+		return nil, ErrDisconnected
+	}
+	return dev.dev, nil
+}
+
+// Close stops tracking the device and closes it if connected.
+func (dev *ReconnectingDevice) Close() error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.closed {
+		return nil
+	}
+	dev.closed = true
+	dev.unsub()
+	close(dev.states)
+	dev.end(ErrDeviceClosed)
+
+	if dev.dev == nil {
+		return nil
+	}
+	err := dev.dev.Close()
+	dev.dev = nil
+	return err
+}
+
+// Write sends a binary blob to the device.
+func (dev *ReconnectingDevice) Write(b []byte) (int, error) {
+	d, err := dev.current()
+	if err != nil {
+		return 0, err
+	}
+	return d.Write(b)
+}
+
+// WriteV sends the buffers to the device as if they were concatenated.
+func (dev *ReconnectingDevice) WriteV(bufs net.Buffers) (int, error) {
+	d, err := dev.current()
+	if err != nil {
+		return 0, err
+	}
+	return d.WriteV(bufs)
+}
+
+// Read retrieves a binary blob from the device.
+func (dev *ReconnectingDevice) Read(b []byte) (int, error) {
+	d, err := dev.current()
+	if err != nil {
+		return 0, err
+	}
+	return d.Read(b)
+}
+
+// SetTimeouts sets the timeouts of Read and Write, including on future
+// reconnections.
+func (dev *ReconnectingDevice) SetTimeouts(read, write time.Duration) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.timeouts = &[2]time.Duration{read, write}
+	if dev.dev != nil {
+		dev.dev.SetTimeouts(read, write)
+	}
+}
+
+// ReadWithTimeout retrieves a binary blob from the device, with a timeout for
+// this call only.
+func (dev *ReconnectingDevice) ReadWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	d, err := dev.current()
+	if err != nil {
+		return 0, err
+	}
+	return d.ReadWithTimeout(b, timeout)
+}
+
+// WriteWithTimeout sends a binary blob to the device, with a timeout for this
+// call only.
+func (dev *ReconnectingDevice) WriteWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	d, err := dev.current()
+	if err != nil {
+		return 0, err
+	}
+	return d.WriteWithTimeout(b, timeout)
+}
+
+// TryRead collects the data of a read queued by an earlier call without
+// blocking. Reads in flight are lost on reconnects.
+func (dev *ReconnectingDevice) TryRead(b []byte) (int, error) {
+	d, err := dev.current()
+	if err != nil {
+		return 0, err
+	}
+	return d.TryRead(b)
+}
+
+// TryWrite queues a write of b without blocking.
+func (dev *ReconnectingDevice) TryWrite(b []byte) (int, error) {
+	d, err := dev.current()
+	if err != nil {
+		return 0, err
+	}
+	return d.TryWrite(b)
+}
+
+// Control sends a control request to the device.
+func (dev *ReconnectingDevice) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	d, err := dev.current()
+	if err != nil {
+		return 0, err
+	}
+	return d.Control(rType, request, val, idx, data)
+}
+
+// AllocBulkStreams allocates bulk streams on the endpoints. Streams are not
+// reallocated after reconnects.
+func (dev *ReconnectingDevice) AllocBulkStreams(count int, endpoints ...uint8) (int, error) {
+	d, err := dev.current()
+	if err != nil {
+		return 0, err
+	}
+	return d.AllocBulkStreams(count, endpoints...)
+}
+
+// FreeBulkStreams releases the bulk streams allocated on the endpoints.
+func (dev *ReconnectingDevice) FreeBulkStreams(endpoints ...uint8) error {
+	d, err := dev.current()
+	if err != nil {
+		return err
+	}
+	return d.FreeBulkStreams(endpoints...)
+}
+
+// OpenBulkStream returns a reader and writer transferring through a bulk
+// stream of the current device, which fails once it's disconnected.
+func (dev *ReconnectingDevice) OpenBulkStream(endpoint uint8, streamID uint32) (*BulkStream, error) {
+	d, err := dev.current()
+	if err != nil {
+		return nil, err
+	}
+	return d.OpenBulkStream(endpoint, streamID)
+}
+
+// SetAltSetting activates an alternate setting of a claimed interface and
+// records it for replay.
+func (dev *ReconnectingDevice) SetAltSetting(iface int, alt int) error {
+	d, err := dev.current()
+	if err != nil {
+		return err
+	}
+	if err := d.SetAltSetting(iface, alt); err != nil {
+		return err
+	}
+	dev.lock.Lock()
+	dev.alts[iface] = alt
+	dev.lock.Unlock()
+	return nil
+}
+
+// ClearHalt clears the halt/stall condition of an endpoint.
+func (dev *ReconnectingDevice) ClearHalt(endpoint uint8) error {
+	d, err := dev.current()
+	if err != nil {
+		return err
+	}
+	return d.ClearHalt(endpoint)
+}
+
+// Reset performs a USB port reset of the device. Devices re-enumerated by the
+// reset are reopened once they return.
+func (dev *ReconnectingDevice) Reset() error {
+	d, err := dev.current()
+	if err != nil {
+		return err
+	}
+	return d.Reset()
+}
+
+// Configuration returns the value of the active configuration.
+func (dev *ReconnectingDevice) Configuration() (int, error) {
+	d, err := dev.current()
+	if err != nil {
+		return 0, err
+	}
+	return d.Configuration()
+}
+
+// Status retrieves the power and remote wakeup status of the device.
+func (dev *ReconnectingDevice) Status() (DeviceStatus, error) {
+	d, err := dev.current()
+	if err != nil {
+		return DeviceStatus{}, err
+	}
+	return d.Status()
+}
+
+// InterfaceStatus retrieves the function remote wake status of an interface.
+func (dev *ReconnectingDevice) InterfaceStatus(iface int) (InterfaceStatus, error) {
+	d, err := dev.current()
+	if err != nil {
+		return InterfaceStatus{}, err
+	}
+	return d.InterfaceStatus(iface)
+}
+
+// SetRemoteWakeup arms or disarms the device to wake the host from suspend,
+// which is restored after reconnects too.
+func (dev *ReconnectingDevice) SetRemoteWakeup(enable bool) error {
+	d, err := dev.current()
+	if err != nil {
+		return err
+	}
+	if err := d.SetRemoteWakeup(enable); err != nil {
+		return err
+	}
+	dev.lock.Lock()
+	dev.wakeup = &enable
+	dev.lock.Unlock()
+	return nil
+}
+
+// autoSuspend is a recorded autosuspend setting of a ReconnectingDevice.
+type autoSuspend struct {
+	enable bool
+	delay  time.Duration
+}
+
+// SetAutoSuspend configures the kernel autosuspend of the device, which is
+// restored after reconnects too, the kernel resetting it on replug.
+func (dev *ReconnectingDevice) SetAutoSuspend(enable bool, delay time.Duration) error {
+	d, err := dev.current()
+	if err != nil {
+		return err
+	}
+	if err := d.SetAutoSuspend(enable, delay); err != nil {
+		return err
+	}
+	dev.lock.Lock()
+	dev.suspend = &autoSuspend{enable: enable, delay: delay}
+	dev.lock.Unlock()
+	return nil
+}
+
+// EndpointStatus retrieves the halt status of an endpoint.
+func (dev *ReconnectingDevice) EndpointStatus(endpoint uint8) (EndpointStatus, error) {
+	d, err := dev.current()
+	if err != nil {
+		return EndpointStatus{}, err
+	}
+	return d.EndpointStatus(endpoint)
+}
+
+// SetConfiguration activates a configuration of the device, which is restored
+// after reconnects too.
+func (dev *ReconnectingDevice) SetConfiguration(config int) error {
+	d, err := dev.current()
+	if err != nil {
+		return err
+	}
+	if err := d.SetConfiguration(config); err != nil {
+		return err
+	}
+	dev.lock.Lock()
+	dev.config = &config
+	dev.lock.Unlock()
+	return nil
+}
+
+// SetControlTimeout sets the timeout of control requests, zero for none,
+// including on future reconnections.
+func (dev *ReconnectingDevice) SetControlTimeout(timeout time.Duration) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.timeout = &timeout
+	if dev.dev != nil {
+		dev.dev.SetControlTimeout(timeout)
+	}
+}
+
+// SetRetryPolicy configures retries of transient Read and Write failures,
+// including on future reconnections.
+func (dev *ReconnectingDevice) SetRetryPolicy(policy *RetryPolicy) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.retry = policy
+	if dev.dev != nil {
+		dev.dev.SetRetryPolicy(policy)
+	}
+}
+
+// SetMetrics reports Read and Write transfers to m, including on future
+// reconnections.
+func (dev *ReconnectingDevice) SetMetrics(m Metrics) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.metrics = m
+	if dev.dev != nil {
+		dev.dev.SetMetrics(m)
+	}
+}
+
+// AcquireBuffer returns a transfer buffer of the given size. The buffer is not
+// tied to the current device, so it stays valid across reconnections, but it
+// can't avoid the copy into device memory.
+func (dev *ReconnectingDevice) AcquireBuffer(size int) (*Buffer, error) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.closed {
+		return nil, ErrDeviceClosed
+	}
+	return NewBuffer(size), nil
+}
+
+// AttachKernelDriver gives the interface back to its kernel driver.
+func (dev *ReconnectingDevice) AttachKernelDriver() error {
+	d, err := dev.current()
+	if err != nil {
+		return err
+	}
+	return d.AttachKernelDriver()
+}
+
+// SetReattachOnClose configures whether Close reattaches the kernel driver,
+// including on future reconnections.
+func (dev *ReconnectingDevice) SetReattachOnClose(reattach bool) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.reattach = &reattach
+	if dev.dev != nil {
+		dev.dev.SetReattachOnClose(reattach)
+	}
+}
+
+// ClaimInterface claims an additional interface and records it for replay.
+func (dev *ReconnectingDevice) ClaimInterface(iface int) error {
+	d, err := dev.current()
+	if err != nil {
+		return err
+	}
+	if err := d.ClaimInterface(iface); err != nil {
+		return err
+	}
+	dev.lock.Lock()
+	dev.claimed[iface] = true
+	dev.lock.Unlock()
+	return nil
+}
+
+// ReleaseInterface releases a claimed interface, forgetting it for replay.
+func (dev *ReconnectingDevice) ReleaseInterface(iface int) error {
+	d, err := dev.current()
+	if err != nil {
+		return err
+	}
+	dev.lock.Lock()
+	delete(dev.claimed, iface)
+	delete(dev.alts, iface)
+	dev.lock.Unlock()
+
+	return d.ReleaseInterface(iface)
+}
+
+// BOS retrieves and decodes the Binary Object Store descriptor.
+func (dev *ReconnectingDevice) BOS() (*BOSDescriptor, error) {
+	d, err := dev.current()
+	if err != nil {
+		return nil, err
+	}
+	return d.BOS()
+}
+
+// RawDescriptors retrieves the raw device and configuration descriptors.
+func (dev *ReconnectingDevice) RawDescriptors() (*RawDescriptors, error) {
+	d, err := dev.current()
+	if err != nil {
+		return nil, err
+	}
+	return d.RawDescriptors()
+}
@@ -0,0 +1,610 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/chay22/zerousb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// callOptions are the options of all calls to the server.
+var callOptions = []grpc.CallOption{
+	grpc.CallContentSubtype(codecName),
+	grpc.MaxCallRecvMsgSize(maxMessage),
+	grpc.MaxCallSendMsgSize(maxMessage),
+}
+
+var (
+	// sessionStream describes the Session method of the service.
+	sessionStream = grpc.StreamDesc{StreamName: "Session", ServerStreams: true}
+
+	// hotplugStream describes the Hotplug method of the service.
+	hotplugStream = grpc.StreamDesc{StreamName: "Hotplug", ServerStreams: true}
+)
+
+// Client is a session with a remote device server.
+type Client struct {
+	conn   grpc.ClientConnInterface
+	owned  io.Closer          // Connection created by Dial, closed along with the client
+	ctx    context.Context    // Context of the calls, carrying the session id
+	cancel context.CancelFunc // Ends the session, failing calls in flight
+
+	devices map[*device]struct{} // Devices opened in the session and not closed yet
+	err     error                // Reason the session ended, nil while established
+	lock    sync.Mutex
+}
+
+// Dial connects to a device server at the given gRPC target, e.g. a host:port
+// address. The connection is unencrypted unless transport credentials are
+// passed among the options.
+func Dial(target string, opts ...grpc.DialOption) (*Client, error) {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.owned = conn
+	return c, nil
+}
+
+// NewClient establishes a session with a device server over an existing gRPC
+// connection, e.g. one configured with TLS credentials or shared with other
+// services.
+func NewClient(conn grpc.ClientConnInterface) (*Client, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stream, err := conn.NewStream(ctx, &sessionStream, method("Session"), callOptions...)
+	if err == nil {
+		err = stream.SendMsg(&Call{})
+	}
+	if err == nil {
+		err = stream.CloseSend()
+	}
+	var id string
+	if err == nil {
+		err = stream.RecvMsg(&id)
+	}
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("remote: failed to establish session: %w", err)
+	}
+	c := &Client{
+		conn:    conn,
+		ctx:     metadata.AppendToOutgoingContext(ctx, sessionHeader, id),
+		cancel:  cancel,
+		devices: make(map[*device]struct{}),
+	}
+	go c.watch(stream)
+	return c, nil
+}
+
+// method returns the full name of a method of the service.
+func method(name string) string {
+	return "/" + serviceName + "/" + name
+}
+
+// watch ends the devices the server reports disconnected until the session
+// ends, either closed or lost along with the connection. The server closes the
+// devices of the session, so they're ended too.
+func (c *Client) watch(stream grpc.ClientStream) {
+	for {
+		var handle string
+		if err := stream.RecvMsg(&handle); err != nil {
+			break
+		}
+		c.lock.Lock()
+		var gone *device
+		for dev := range c.devices {
+			if dev.handle == handle {
+				gone = dev
+			}
+		}
+		c.lock.Unlock()
+
+		if gone != nil {
+			gone.end(zerousb.ErrDeviceGone)
+		}
+	}
+
+	c.lock.Lock()
+	if c.err == nil {
+		c.err = fmt.Errorf("remote: connection lost: %w", zerousb.ErrNoDevice)
+	}
+	devices := c.devices
+	c.devices = nil
+	c.lock.Unlock()
+
+	for dev := range devices {
+		dev.end(c.err)
+	}
+}
+
+// invoke calls a method of the service within the session. Failures the server
+// named after a zerousb error are converted back to match it.
+func (c *Client) invoke(name string, args, reply interface{}) error {
+	var trailer metadata.MD
+	err := c.conn.Invoke(c.ctx, method(name), args, reply, append(callOptions, grpc.Trailer(&trailer))...)
+	if err == nil {
+		return nil
+	}
+	if names := trailer.Get(errorHeader); len(names) == 1 {
+		return relayedError(status.Convert(err).Message(), names[0])
+	}
+	return err
+}
+
+// remoteError is a failure on the server matching a zerousb error.
+type remoteError struct {
+	msg string // Message of the error on the server
+	err error  // Sentinel the error matched on the server
+}
+
+// Error implements the error interface.
+func (e *remoteError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the sentinel the error matched on the server.
+func (e *remoteError) Unwrap() error {
+	return e.err
+}
+
+// relayedError recreates an error of the server from its message and the name
+// of the sentinel it matched, if any.
+func relayedError(msg string, name string) error {
+	if err := sentinel(name); err != nil {
+		return &remoteError{msg: msg, err: err}
+	}
+	return errors.New(msg)
+}
+
+// Close ends the session, which closes all devices opened through the client
+// on the server.
+func (c *Client) Close() error {
+	c.lock.Lock()
+	if c.err == nil {
+		c.err = zerousb.ErrDeviceClosed
+	}
+	c.lock.Unlock()
+
+	c.cancel()
+	if c.owned != nil {
+		return c.owned.Close()
+	}
+	return nil
+}
+
+// Enumerate returns the device interfaces attached to the server which are
+// accepted by the match function.
+func (c *Client) Enumerate(match func(zerousb.DeviceInfo) bool) ([]zerousb.DeviceInfo, error) {
+	return c.enumerate(match, false)
+}
+
+// EnumerateHID is like Enumerate, but also returns HID class devices and
+// interfaces.
+func (c *Client) EnumerateHID(match func(zerousb.DeviceInfo) bool) ([]zerousb.DeviceInfo, error) {
+	return c.enumerate(match, true)
+}
+
+// enumerate lists the remote devices, filtering them locally as the predicate
+// can't be shipped to the server.
+func (c *Client) enumerate(match func(zerousb.DeviceInfo) bool, hid bool) ([]zerousb.DeviceInfo, error) {
+	var all []zerousb.DeviceInfo
+	if err := c.invoke("Enumerate", &EnumerateArgs{HID: hid}, &all); err != nil {
+		return nil, err
+	}
+	var infos []zerousb.DeviceInfo
+	for _, info := range all {
+		if match(info) {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+// Open connects to a device interface previously enumerated on the server.
+func (c *Client) Open(info zerousb.DeviceInfo) (zerousb.Device, error) {
+	var handle string
+	if err := c.invoke("Open", &OpenArgs{Path: info.Path, Interface: info.Interface}, &handle); err != nil {
+		return nil, err
+	}
+	dev := &device{client: c, handle: handle, done: make(chan struct{})}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.err != nil {
+		// The session ended meanwhile, taking the device along
+		dev.end(c.err)
+		return nil, c.err
+	}
+	c.devices[dev] = struct{}{}
+	return dev, nil
+}
+
+// OnHotplug registers a handler to be notified when devices are attached to or
+// detached from the server. The returned function unregisters the handler.
+func (c *Client) OnHotplug(handler zerousb.HotplugHandler) (func(), error) {
+	ctx, cancel := context.WithCancel(c.ctx)
+
+	stream, err := c.conn.NewStream(ctx, &hotplugStream, method("Hotplug"), callOptions...)
+	if err == nil {
+		err = stream.SendMsg(&Call{})
+	}
+	if err == nil {
+		err = stream.CloseSend()
+	}
+	if err == nil {
+		// The server sends the headers once subscribed, a stream ending without
+		// them carries the reason it failed
+		var md metadata.MD
+		if md, err = stream.Header(); err == nil && md == nil {
+			if err = stream.RecvMsg(new(Event)); err == io.EOF {
+				err = errors.New("remote: hotplug stream ended")
+			}
+		}
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go func() {
+		for {
+			var event Event
+			if err := stream.RecvMsg(&event); err != nil {
+				return
+			}
+			handler(event.Kind, event.Info)
+		}
+	}()
+	return cancel, nil
+}
+
+// device is a zerousb.Device opened on a remote server.
+type device struct {
+	client *Client
+	handle string // Server side handle of the device
+
+	done    chan struct{}   // Closed once the device is closed, disconnected or the connection lost
+	err     error           // Reason done was closed
+	lost    []func()        // Callbacks to run once the device or the connection is lost
+	metrics zerousb.Metrics // Metrics of Read and Write, nil for none
+	lock    sync.Mutex
+}
+
+// call invokes a device method on the server.
+func (dev *device) call(method string, args Call, reply interface{}) error {
+	args.Handle = dev.handle
+	if reply == nil {
+		reply = &struct{}{}
+	}
+	return dev.client.invoke(method, &args, reply)
+}
+
+// err returns the in-band failure of a partial transfer.
+func (r *Reply) err() error {
+	if r.Err == "" {
+		return nil
+	}
+	return relayedError(r.Err, r.Sentinel)
+}
+
+// end closes the done channel for the given reason, unless already closed.
+func (dev *device) end(err error) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.err == nil {
+		dev.err = err
+		close(dev.done)
+
+		if errors.Is(err, zerousb.ErrNoDevice) && len(dev.lost) > 0 {
+			go func(lost []func()) {
+				for _, fn := range lost {
+					fn()
+				}
+			}(dev.lost)
+		}
+		dev.lost = nil
+	}
+}
+
+// Close releases the device on the server.
+func (dev *device) Close() error {
+	err := dev.call("Close", Call{}, nil)
+	dev.end(zerousb.ErrDeviceClosed)
+
+	dev.client.lock.Lock()
+	delete(dev.client.devices, dev)
+	dev.client.lock.Unlock()
+
+	return err
+}
+
+// Done returns a channel closed once the device is closed, disconnected from
+// the server or the connection to the server is lost.
+func (dev *device) Done() <-chan struct{} {
+	return dev.done
+}
+
+// Err returns nil until Done is closed, then the reason it was closed.
+func (dev *device) Err() error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	return dev.err
+}
+
+// OnDisconnect registers fn to be called once the device is disconnected from
+// the server, or the connection to the server is lost, the server closing the
+// device along with it.
+func (dev *device) OnDisconnect(fn func()) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	switch {
+	case dev.err == nil:
+		dev.lost = append(dev.lost, fn)
+	case errors.Is(dev.err, zerousb.ErrNoDevice):
+		go fn()
+	}
+}
+
+// transfer invokes a Read or Write method on the server, copying the data read
+// into b. The round trip is reported to the metrics, if any.
+func (dev *device) transfer(method string, dir zerousb.EndpointDirection, args Call, b []byte) (int, error) {
+	start := time.Now()
+
+	var (
+		reply Reply
+		n     int
+	)
+	err := dev.call(method, args, &reply)
+	if err == nil {
+		if n, err = reply.N, reply.err(); dir == zerousb.EndpointDirectionIn {
+			n = copy(b, reply.Data)
+		}
+	}
+	dev.lock.Lock()
+	metrics := dev.metrics
+	dev.lock.Unlock()
+
+	if metrics != nil {
+		metrics.ObserveTransfer(dir, n, time.Since(start), err)
+	}
+	return n, err
+}
+
+// Write sends a binary blob to the device.
+func (dev *device) Write(b []byte) (int, error) {
+	return dev.transfer("Write", zerousb.EndpointDirectionOut, Call{Data: b}, nil)
+}
+
+// WriteV sends the buffers to the device as if they were concatenated. They
+// are copied over the connection anyway, so they're joined into one Write.
+func (dev *device) WriteV(bufs net.Buffers) (int, error) {
+	return dev.Write(bytes.Join(bufs, nil))
+}
+
+// Read retrieves a binary blob from the device.
+func (dev *device) Read(b []byte) (int, error) {
+	return dev.transfer("Read", zerousb.EndpointDirectionIn, Call{Length: len(b)}, b)
+}
+
+// TryRead collects the data of a read queued on the server by an earlier call,
+// queuing one if there's none in flight. Each call is a round trip to the
+// server.
+func (dev *device) TryRead(b []byte) (int, error) {
+	var reply Reply
+	if err := dev.call("TryRead", Call{Length: len(b)}, &reply); err != nil {
+		return 0, err
+	}
+	if reply.WouldBlock {
+		return 0, zerousb.ErrWouldBlock
+	}
+	return copy(b, reply.Data), reply.err()
+}
+
+// TryWrite queues a write of b on the server, unless the previous one is in
+// flight. Each call is a round trip to the server.
+func (dev *device) TryWrite(b []byte) (int, error) {
+	var reply Reply
+	if err := dev.call("TryWrite", Call{Data: b}, &reply); err != nil {
+		return 0, err
+	}
+	if reply.WouldBlock {
+		return 0, zerousb.ErrWouldBlock
+	}
+	return reply.N, reply.err()
+}
+
+// SetTimeouts sets the timeouts of Read and Write.
+func (dev *device) SetTimeouts(read, write time.Duration) {
+	dev.call("SetTimeouts", Call{ReadTimeout: read, WriteTimeout: write}, nil)
+}
+
+// ReadWithTimeout retrieves a binary blob from the device, with a timeout for
+// this call only.
+func (dev *device) ReadWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	return dev.transfer("ReadWithTimeout", zerousb.EndpointDirectionIn, Call{Length: len(b), ReadTimeout: timeout}, b)
+}
+
+// WriteWithTimeout sends a binary blob to the device, with a timeout for this
+// call only.
+func (dev *device) WriteWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	return dev.transfer("WriteWithTimeout", zerousb.EndpointDirectionOut, Call{Data: b, WriteTimeout: timeout}, nil)
+}
+
+// Control sends a control request to the device.
+func (dev *device) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	args := Call{RequestType: rType, Request: request, Value: val, Index: idx}
+	if rType&zerousb.ControlIn != 0 {
+		args.Length = len(data)
+	} else {
+		args.Data = data
+	}
+	var reply Reply
+	if err := dev.call("Control", args, &reply); err != nil {
+		return 0, err
+	}
+	if rType&zerousb.ControlIn != 0 {
+		return copy(data, reply.Data), nil
+	}
+	return reply.N, nil
+}
+
+// SetControlTimeout sets the timeout of control requests, zero for none. It
+// defaults to 5 seconds on the server.
+func (dev *device) SetControlTimeout(timeout time.Duration) {
+	dev.call("SetControlTimeout", Call{ControlTimeout: timeout}, nil)
+}
+
+// SetRetryPolicy configures retries of transient Read and Write failures. The
+// retries are performed by the server, which can't run a custom Retryable
+// classifier, so the default transient errors of its backend are retried.
+func (dev *device) SetRetryPolicy(policy *zerousb.RetryPolicy) {
+	dev.call("SetRetryPolicy", Call{Retry: policy}, nil)
+}
+
+// SetMetrics reports Read and Write round trips to the server to m. Retries
+// happen on the server, so they aren't reported.
+func (dev *device) SetMetrics(m zerousb.Metrics) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.metrics = m
+}
+
+// AcquireBuffer returns a transfer buffer of the given size. Data is copied
+// over the connection anyway, so it's a plain Go buffer.
+func (dev *device) AcquireBuffer(size int) (*zerousb.Buffer, error) {
+	return zerousb.NewBuffer(size), nil
+}
+
+// AttachKernelDriver gives the interface back to its kernel driver.
+func (dev *device) AttachKernelDriver() error {
+	return dev.call("AttachKernelDriver", Call{}, nil)
+}
+
+// SetReattachOnClose configures whether Close reattaches the kernel driver.
+func (dev *device) SetReattachOnClose(reattach bool) {
+	dev.call("SetReattachOnClose", Call{Reattach: reattach}, nil)
+}
+
+// ClaimInterface claims an additional interface of a composite device.
+func (dev *device) ClaimInterface(iface int) error {
+	return dev.call("ClaimInterface", Call{Interface: iface}, nil)
+}
+
+// ReleaseInterface releases an interface claimed through ClaimInterface.
+func (dev *device) ReleaseInterface(iface int) error {
+	return dev.call("ReleaseInterface", Call{Interface: iface}, nil)
+}
+
+// SetAltSetting activates an alternate setting of a claimed interface.
+func (dev *device) SetAltSetting(iface int, alt int) error {
+	return dev.call("SetAltSetting", Call{Interface: iface, AltSetting: alt}, nil)
+}
+
+// ClearHalt clears the halt/stall condition of an endpoint.
+func (dev *device) ClearHalt(endpoint uint8) error {
+	return dev.call("ClearHalt", Call{Endpoint: endpoint}, nil)
+}
+
+// Reset performs a USB port reset of the device.
+func (dev *device) Reset() error {
+	return dev.call("Reset", Call{}, nil)
+}
+
+// Configuration returns the value of the active configuration.
+func (dev *device) Configuration() (int, error) {
+	var config int
+	if err := dev.call("Configuration", Call{}, &config); err != nil {
+		return 0, err
+	}
+	return config, nil
+}
+
+// Status retrieves the power and remote wakeup status of the device.
+func (dev *device) Status() (zerousb.DeviceStatus, error) {
+	var status zerousb.DeviceStatus
+	err := dev.call("Status", Call{}, &status)
+	return status, err
+}
+
+// InterfaceStatus retrieves the function remote wake status of an interface.
+func (dev *device) InterfaceStatus(iface int) (zerousb.InterfaceStatus, error) {
+	var status zerousb.InterfaceStatus
+	err := dev.call("InterfaceStatus", Call{Interface: iface}, &status)
+	return status, err
+}
+
+// EndpointStatus retrieves the halt status of an endpoint.
+func (dev *device) EndpointStatus(endpoint uint8) (zerousb.EndpointStatus, error) {
+	var status zerousb.EndpointStatus
+	err := dev.call("EndpointStatus", Call{Endpoint: endpoint}, &status)
+	return status, err
+}
+
+// SetRemoteWakeup arms or disarms the device to wake the host from suspend.
+func (dev *device) SetRemoteWakeup(enable bool) error {
+	return dev.call("SetRemoteWakeup", Call{Enable: enable}, nil)
+}
+
+// SetAutoSuspend configures the kernel autosuspend of the device on the server.
+func (dev *device) SetAutoSuspend(enable bool, delay time.Duration) error {
+	return dev.call("SetAutoSuspend", Call{Enable: enable, Delay: delay}, nil)
+}
+
+// AllocBulkStreams is unsupported, bulk streams aren't relayed by the server.
+func (dev *device) AllocBulkStreams(count int, endpoints ...uint8) (int, error) {
+	return 0, fmt.Errorf("remote: failed to allocate bulk streams: %w", zerousb.ErrNotSupported)
+}
+
+// FreeBulkStreams is unsupported, bulk streams aren't relayed by the server.
+func (dev *device) FreeBulkStreams(endpoints ...uint8) error {
+	return fmt.Errorf("remote: failed to free bulk streams: %w", zerousb.ErrNotSupported)
+}
+
+// OpenBulkStream is unsupported, bulk streams aren't relayed by the server.
+func (dev *device) OpenBulkStream(endpoint uint8, streamID uint32) (*zerousb.BulkStream, error) {
+	return nil, fmt.Errorf("remote: failed to open bulk stream: %w", zerousb.ErrNotSupported)
+}
+
+// SetConfiguration activates a configuration of the device.
+func (dev *device) SetConfiguration(config int) error {
+	return dev.call("SetConfiguration", Call{Config: config}, nil)
+}
+
+// BOS retrieves and decodes the Binary Object Store descriptor.
+func (dev *device) BOS() (*zerousb.BOSDescriptor, error) {
+	bos := new(zerousb.BOSDescriptor)
+	if err := dev.call("BOS", Call{}, bos); err != nil {
+		return nil, err
+	}
+	return bos, nil
+}
+
+// RawDescriptors retrieves the raw device and configuration descriptors.
+func (dev *device) RawDescriptors() (*zerousb.RawDescriptors, error) {
+	raw := new(zerousb.RawDescriptors)
+	if err := dev.call("RawDescriptors", Call{}, raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
@@ -0,0 +1,162 @@
+package remote
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"time"
+
+	"github.com/chay22/zerousb"
+	"google.golang.org/grpc/encoding"
+)
+
+// serviceName is the full name the device service is registered under.
+const serviceName = "zerousb.remote.USB"
+
+// sessionHeader is the metadata key carrying the session of device calls.
+const sessionHeader = "zerousb-session"
+
+// errorHeader is the trailer key naming the zerousb error a call failed with.
+const errorHeader = "zerousb-error"
+
+// codecName is the content subtype of the messages, gob encoded as the service
+// is only spoken between Go peers of this package.
+const codecName = "gob"
+
+// maxLength is the largest read or write relayed in a single call, control
+// requests being limited to their 16 bit wLength.
+const maxLength = 1 << 22
+
+// maxMessage is the size limit of the messages, fitting the largest transfer
+// along with the rest of its call.
+const maxMessage = maxLength + 1<<12
+
+// sentinels are the zerousb errors relayed to clients by name, so they can be
+// matched with errors.Is through the proxy too. Errors matching several are
+// named after the first, so more specific ones come first.
+var sentinels = []struct {
+	name string
+	err  error
+}{
+	{"gone", zerousb.ErrDeviceGone},
+	{"closed", zerousb.ErrDeviceClosed},
+	{"no-device", zerousb.ErrNoDevice},
+	{"timeout", zerousb.ErrTimeout},
+	{"pipe", zerousb.ErrPipe},
+	{"would-block", zerousb.ErrWouldBlock},
+	{"io", zerousb.ErrIO},
+	{"invalid-param", zerousb.ErrInvalidParam},
+	{"access", zerousb.ErrAccess},
+	{"not-found", zerousb.ErrNotFound},
+	{"busy", zerousb.ErrBusy},
+	{"overflow", zerousb.ErrOverflow},
+	{"interrupted", zerousb.ErrInterrupted},
+	{"no-mem", zerousb.ErrNoMem},
+	{"not-supported", zerousb.ErrNotSupported},
+	{"not-configured", zerousb.ErrNotConfigured},
+	{"wrong-driver", zerousb.ErrWrongDriver},
+	{"unsupported-platform", zerousb.ErrUnsupportedPlatform},
+}
+
+// sentinelName returns the name an error is relayed under, empty if it matches
+// none of the sentinels.
+func sentinelName(err error) string {
+	for _, s := range sentinels {
+		if errors.Is(err, s.err) {
+			return s.name
+		}
+	}
+	return ""
+}
+
+// sentinel returns the error relayed under a name, nil if unknown.
+func sentinel(name string) error {
+	for _, s := range sentinels {
+		if s.name == name {
+			return s.err
+		}
+	}
+	return nil
+}
+
+func init() {
+	encoding.RegisterCodec(gobCodec{})
+}
+
+// gobCodec encodes the messages of the service with encoding/gob.
+type gobCodec struct{}
+
+// Marshal encodes a message.
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes a message into v.
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// Name returns the content subtype of the codec.
+func (gobCodec) Name() string {
+	return codecName
+}
+
+// EnumerateArgs is the request of the enumeration call.
+type EnumerateArgs struct {
+	HID bool // Whether HID devices and interfaces should be listed too
+}
+
+// OpenArgs identifies a device interface to open on the server.
+type OpenArgs struct {
+	Path      string // Path of the device as enumerated by the server
+	Interface int    // Interface of the device to claim
+}
+
+// Call is the request of all calls on an opened device. Only the fields
+// relevant to the invoked method are set.
+type Call struct {
+	Handle string // Server side handle of the opened device
+
+	Data   []byte // Payload of writes and OUT control requests
+	Length int    // Buffer size of reads and IN control requests
+
+	RequestType uint8  // Control request bmRequestType
+	Request     uint8  // Control request bRequest
+	Value       uint16 // Control request wValue
+	Index       uint16 // Control request wIndex
+
+	Interface  int   // Interface number of interface level calls
+	AltSetting int   // Alternate setting of SetAltSetting
+	Endpoint   uint8 // Endpoint address of ClearHalt and EndpointStatus
+	Config     int   // Configuration of SetConfiguration
+	Reattach   bool  // Flag of SetReattachOnClose
+	Enable     bool  // Flag of SetRemoteWakeup and SetAutoSuspend
+
+	Delay        time.Duration // Idle delay of SetAutoSuspend
+	ReadTimeout  time.Duration // Read timeout of SetTimeouts and ReadWithTimeout
+	WriteTimeout time.Duration // Write timeout of SetTimeouts and WriteWithTimeout
+
+	ControlTimeout time.Duration // Timeout of SetControlTimeout
+
+	Retry *zerousb.RetryPolicy // Policy of SetRetryPolicy, its Retryable function isn't transmitted
+}
+
+// Reply is the response of data transferring device calls.
+type Reply struct {
+	N    int    // Number of bytes transferred
+	Data []byte // Data received from the device
+	Err  string // Failure of a transfer which moved part of the data before
+
+	Sentinel   string // Name of the zerousb error Err matches, if any
+	WouldBlock bool   // Whether TryRead or TryWrite failed with ErrWouldBlock
+}
+
+// Event is a hotplug notification streamed from the server.
+type Event struct {
+	Kind zerousb.HotplugEvent
+	Info zerousb.DeviceInfo
+}
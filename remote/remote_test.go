@@ -0,0 +1,386 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chay22/zerousb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeDevice is a loopback device returning whatever was last written to it.
+type fakeDevice struct {
+	zerousb.Device // Unimplemented methods panic
+
+	last   []byte
+	closed chan struct{}
+}
+
+func (f *fakeDevice) Write(b []byte) (int, error) {
+	f.last = append([]byte{}, b...)
+	return len(b), nil
+}
+
+func (f *fakeDevice) Read(b []byte) (int, error) {
+	return copy(b, f.last), nil
+}
+
+func (f *fakeDevice) Close() error {
+	close(f.closed)
+	return nil
+}
+
+func (f *fakeDevice) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	return copy(data, []byte{request, byte(val)}), nil
+}
+
+func (f *fakeDevice) Done() <-chan struct{} {
+	return f.closed
+}
+
+func (f *fakeDevice) Err() error {
+	select {
+	case <-f.closed:
+		return zerousb.ErrDeviceClosed
+	default:
+		return nil
+	}
+}
+
+// unpluggedDevice is a fake device whose lifetime ends once unplugged.
+type unpluggedDevice struct {
+	fakeDevice
+	unplugged chan struct{}
+}
+
+func (f *unpluggedDevice) Done() <-chan struct{} {
+	return f.unplugged
+}
+
+func (f *unpluggedDevice) Err() error {
+	select {
+	case <-f.unplugged:
+		return zerousb.ErrDeviceGone
+	default:
+		return nil
+	}
+}
+
+// serve starts a server on an in-memory listener, returning a client dialed to
+// it. The server is stopped when the test ends.
+func serve(t *testing.T, srv *Server) *Client {
+	return serveMany(t, srv, 1)[0]
+}
+
+// serveMany is like serve, but dials any number of clients to the server.
+func serveMany(t *testing.T, srv *Server, count int) []*Client {
+	l := bufconn.Listen(1 << 20)
+	go srv.Serve(l)
+	t.Cleanup(func() { l.Close() })
+
+	clients := make([]*Client, count)
+	for i := range clients {
+		client, err := Dial("passthrough:///bufconn",
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return l.Dial() }),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		clients[i] = client
+	}
+	return clients
+}
+
+// Tests that devices can be enumerated, opened and driven over the network.
+func TestRoundTrip(t *testing.T) {
+	dev := &fakeDevice{closed: make(chan struct{})}
+	client := serve(t, &Server{
+		Enumerate: func(hid bool) ([]zerousb.DeviceInfo, error) {
+			return []zerousb.DeviceInfo{{Path: "1-1:0", VendorID: 0x1234, ProductID: 0x5678}}, nil
+		},
+		Open: func(zerousb.DeviceInfo) (zerousb.Device, error) { return dev, nil },
+	})
+	defer client.Close()
+
+	infos, err := client.Enumerate(func(info zerousb.DeviceInfo) bool { return info.VendorID == 0x1234 })
+	if err != nil {
+		t.Fatalf("failed to enumerate: %v", err)
+	}
+	if len(infos) != 1 || infos[0].Path != "1-1:0" {
+		t.Fatalf("enumeration mismatch: have %v", infos)
+	}
+	remote, err := client.Open(infos[0])
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	if _, err := remote.Write([]byte("hello")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	buf := make([]byte, 16)
+	n, err := remote.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if !bytes.Equal(buf[:n], []byte("hello")) {
+		t.Errorf("read mismatch: have %q, want %q", buf[:n], "hello")
+	}
+	ctrl := make([]byte, 2)
+	if n, err := remote.Control(zerousb.ControlIn|zerousb.ControlVendor, 0x42, 0x07, 0, ctrl); err != nil || n != 2 {
+		t.Fatalf("failed to control: n %d, err %v", n, err)
+	}
+	if !bytes.Equal(ctrl, []byte{0x42, 0x07}) {
+		t.Errorf("control mismatch: have %x, want %x", ctrl, []byte{0x42, 0x07})
+	}
+	if err := remote.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	select {
+	case <-dev.closed:
+	default:
+		t.Errorf("device not closed on server")
+	}
+}
+
+// failingDevice fails reads outright and writes after moving part of the data,
+// both with a configurable error.
+type failingDevice struct {
+	fakeDevice
+	err error
+}
+
+func (f *failingDevice) Read(b []byte) (int, error) {
+	return 0, f.err
+}
+
+func (f *failingDevice) Write(b []byte) (int, error) {
+	return 1, f.err
+}
+
+// Tests that failures matching zerousb errors still match them on the client,
+// whether the call failed or reported a partial transfer.
+func TestErrors(t *testing.T) {
+	dev := &failingDevice{fakeDevice: fakeDevice{closed: make(chan struct{})}}
+	client := serve(t, &Server{
+		Enumerate: func(hid bool) ([]zerousb.DeviceInfo, error) {
+			return []zerousb.DeviceInfo{{Path: "1-1:0"}}, nil
+		},
+		Open: func(zerousb.DeviceInfo) (zerousb.Device, error) { return dev, nil },
+	})
+	defer client.Close()
+
+	remote, err := client.Open(zerousb.DeviceInfo{Path: "1-1:0"})
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	tests := []struct {
+		err  error
+		want []error
+	}{
+		{zerousb.ErrTimeout, []error{zerousb.ErrTimeout}},
+		{fmt.Errorf("failed to read: %w", zerousb.ErrPipe), []error{zerousb.ErrPipe}},
+		{zerousb.ErrDeviceGone, []error{zerousb.ErrDeviceGone, zerousb.ErrNoDevice}},
+		{zerousb.ErrNoDevice, []error{zerousb.ErrNoDevice}},
+	}
+	for i, tt := range tests {
+		dev.err = tt.err
+
+		_, rerr := remote.Read(make([]byte, 4))
+		_, werr := remote.Write([]byte("ping"))
+		for _, want := range tt.want {
+			if !errors.Is(rerr, want) {
+				t.Errorf("test %d: read error mismatch: have %v, want %v", i, rerr, want)
+			}
+			if !errors.Is(werr, want) {
+				t.Errorf("test %d: write error mismatch: have %v, want %v", i, werr, want)
+			}
+		}
+		if rerr == nil || !strings.Contains(rerr.Error(), tt.err.Error()) {
+			t.Errorf("test %d: read message mismatch: have %v, want %v", i, rerr, tt.err)
+		}
+	}
+}
+
+// Tests that transfer lengths outside the supported range are refused before
+// the server allocates them.
+func TestInvalidLength(t *testing.T) {
+	client := serve(t, &Server{
+		Enumerate: func(hid bool) ([]zerousb.DeviceInfo, error) {
+			return []zerousb.DeviceInfo{{Path: "1-1:0"}}, nil
+		},
+		Open: func(zerousb.DeviceInfo) (zerousb.Device, error) {
+			return &fakeDevice{closed: make(chan struct{})}, nil
+		},
+	})
+	defer client.Close()
+
+	remote, err := client.Open(zerousb.DeviceInfo{Path: "1-1:0"})
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	dev := remote.(*device)
+	for _, tt := range []struct {
+		method string
+		args   Call
+	}{
+		{"Read", Call{Length: -1}},
+		{"Read", Call{Length: maxLength + 1}},
+		{"TryRead", Call{Length: -1}},
+		{"ReadWithTimeout", Call{Length: -1}},
+		{"Control", Call{RequestType: zerousb.ControlIn, Length: -1}},
+		{"Control", Call{RequestType: zerousb.ControlIn, Length: 0x10000}},
+	} {
+		if err := dev.call(tt.method, tt.args, new(Reply)); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s of %d bytes: error mismatch: have %v, want %v", tt.method, tt.args.Length, err, codes.InvalidArgument)
+		}
+	}
+}
+
+// Tests that sessions and handles are random tokens, and that handles can't be
+// used from another session.
+func TestTokens(t *testing.T) {
+	srv := &Server{
+		Enumerate: func(hid bool) ([]zerousb.DeviceInfo, error) {
+			return []zerousb.DeviceInfo{{Path: "1-1:0"}}, nil
+		},
+		Open: func(zerousb.DeviceInfo) (zerousb.Device, error) {
+			return &fakeDevice{closed: make(chan struct{})}, nil
+		},
+	}
+	clients := serveMany(t, srv, 2)
+	owner, other := clients[0], clients[1]
+	defer owner.Close()
+	defer other.Close()
+
+	first, err := owner.Open(zerousb.DeviceInfo{Path: "1-1:0"})
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	second, err := owner.Open(zerousb.DeviceInfo{Path: "1-1:0"})
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	handle := first.(*device).handle
+	if len(handle) != 32 || handle == second.(*device).handle {
+		t.Errorf("handles not random 128 bit tokens: %s, %s", handle, second.(*device).handle)
+	}
+	stolen := &device{client: other, handle: handle, done: make(chan struct{})}
+	if _, err := stolen.Write([]byte("ping")); status.Code(err) != codes.NotFound {
+		t.Errorf("handle usable from another session: %v", err)
+	}
+}
+
+// Tests that the devices a client leaves open are closed on the server once
+// its session ends, and that its devices are ended along with it.
+func TestSessionEnd(t *testing.T) {
+	dev := &fakeDevice{closed: make(chan struct{})}
+	client := serve(t, &Server{
+		Enumerate: func(hid bool) ([]zerousb.DeviceInfo, error) {
+			return []zerousb.DeviceInfo{{Path: "1-1:0"}}, nil
+		},
+		Open: func(zerousb.DeviceInfo) (zerousb.Device, error) { return dev, nil },
+	})
+	remote, err := client.Open(zerousb.DeviceInfo{Path: "1-1:0"})
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	client.Close()
+
+	select {
+	case <-dev.closed:
+	case <-time.After(time.Second):
+		t.Fatalf("device not closed on server")
+	}
+	select {
+	case <-remote.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("device not ended on client")
+	}
+}
+
+// Tests that devices disconnected from the server are ended on the client, with
+// their disconnection callbacks run, while the session goes on.
+func TestDeviceGone(t *testing.T) {
+	dev := &unpluggedDevice{fakeDevice: fakeDevice{closed: make(chan struct{})}, unplugged: make(chan struct{})}
+	client := serve(t, &Server{
+		Enumerate: func(hid bool) ([]zerousb.DeviceInfo, error) {
+			return []zerousb.DeviceInfo{{Path: "1-1:0"}}, nil
+		},
+		Open: func(zerousb.DeviceInfo) (zerousb.Device, error) { return dev, nil },
+	})
+	defer client.Close()
+
+	remote, err := client.Open(zerousb.DeviceInfo{Path: "1-1:0"})
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	lost := make(chan struct{})
+	remote.OnDisconnect(func() { close(lost) })
+
+	close(dev.unplugged)
+	select {
+	case <-remote.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("device not ended on client")
+	}
+	if err := remote.Err(); !errors.Is(err, zerousb.ErrDeviceGone) {
+		t.Errorf("error mismatch: have %v, want %v", err, zerousb.ErrDeviceGone)
+	}
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Fatalf("disconnection callback not run")
+	}
+	if _, err := client.Enumerate(func(zerousb.DeviceInfo) bool { return true }); err != nil {
+		t.Errorf("session ended along with the device: %v", err)
+	}
+}
+
+// Tests that hotplug notifications are streamed to subscribed clients, and that
+// failures to subscribe on the server are reported up front.
+func TestHotplug(t *testing.T) {
+	handlers := make(chan zerousb.HotplugHandler, 1)
+	client := serve(t, &Server{
+		OnHotplug: func(handler zerousb.HotplugHandler) (func(), error) {
+			if handlers == nil {
+				return nil, zerousb.ErrNotSupported
+			}
+			handlers <- handler
+			return func() {}, nil
+		},
+	})
+	defer client.Close()
+
+	events := make(chan Event, 1)
+	cancel, err := client.OnHotplug(func(kind zerousb.HotplugEvent, info zerousb.DeviceInfo) {
+		events <- Event{Kind: kind, Info: info}
+	})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer cancel()
+
+	(<-handlers)(zerousb.DeviceArrived, zerousb.DeviceInfo{Path: "1-2"})
+	select {
+	case event := <-events:
+		if event.Kind != zerousb.DeviceArrived || event.Info.Path != "1-2" {
+			t.Errorf("event mismatch: have %v %s", event.Kind, event.Info.Path)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("event not delivered")
+	}
+	handlers = nil
+	if _, err := client.OnHotplug(func(zerousb.HotplugEvent, zerousb.DeviceInfo) {}); err == nil {
+		t.Errorf("failed subscription not reported")
+	}
+}
@@ -0,0 +1,765 @@
+// Package remote exposes the USB devices of one machine to clients on another,
+// for test farms where the hardware hangs off lab machines. The client side
+// implements zerousb.Device, so code written against local devices works
+// unmodified over the network.
+//
+// The transport is gRPC, so connections can be secured and authenticated with
+// the usual gRPC credentials and interceptors. The messages are gob encoded Go
+// structs rather than protocol buffers, the service is meant to be spoken
+// between peers using this package. The package is a module of its own, which
+// keeps gRPC out of the dependencies of zerousb itself.
+package remote
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/chay22/zerousb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// eventBacklog is the number of hotplug notifications queued per stream before
+// newer ones are dropped.
+const eventBacklog = 256
+
+var (
+	// errUnknownSession is returned when a call isn't made within a session
+	// established on the server.
+	errUnknownSession = status.Error(codes.FailedPrecondition, "remote: unknown session")
+
+	// errUnknownHandle is returned when a call references a device not opened
+	// within the session.
+	errUnknownHandle = status.Error(codes.NotFound, "remote: unknown handle")
+)
+
+// Server exports local devices to remote clients. The zero value serves the
+// devices zerousb can reach directly.
+type Server struct {
+	// Enumerate lists the local devices, nil for zerousb.Enumerate and
+	// zerousb.EnumerateHID.
+	Enumerate func(hid bool) ([]zerousb.DeviceInfo, error)
+
+	// Open connects to a local device, nil for zerousb.DeviceInfo.Open.
+	Open func(info zerousb.DeviceInfo) (zerousb.Device, error)
+
+	// OnHotplug subscribes to local device changes, nil for zerousb.OnHotplug.
+	OnHotplug func(handler zerousb.HotplugHandler) (func(), error)
+}
+
+// ListenAndServe initializes zerousb and exports all local devices on the given
+// TCP address, without transport security.
+func ListenAndServe(addr string) error {
+	if err := zerousb.Init(); err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return new(Server).Serve(l)
+}
+
+// Serve accepts clients on the listener until it fails, without transport
+// security. Use Register to serve through a configured gRPC server instead.
+func (s *Server) Serve(l net.Listener) error {
+	srv := grpc.NewServer(grpc.MaxRecvMsgSize(maxMessage))
+	s.Register(srv)
+	return srv.Serve(l)
+}
+
+// Register adds the device service to a gRPC server, e.g. one configured with
+// TLS credentials. The server should accept messages of a few MiB through
+// grpc.MaxRecvMsgSize, or large writes are refused.
+func (s *Server) Register(srv *grpc.Server) {
+	svc := &service{
+		server:   s,
+		sessions: make(map[string]*session),
+	}
+	srv.RegisterService(svc.desc(), svc)
+}
+
+// session is the state of a connected client. Devices left open by the client
+// are closed once its session ends.
+type session struct {
+	devices map[string]zerousb.Device // Opened devices by handle
+	gone    chan string               // Handles of devices disconnected from the server
+	ended   chan struct{}             // Closed once the session ended
+}
+
+// watch notifies the client of a session once an opened device is
+// disconnected, unless the session ends first.
+func (sess *session) watch(handle string, dev zerousb.Device) {
+	select {
+	case <-dev.Done():
+	case <-sess.ended:
+		return
+	}
+	if !errors.Is(dev.Err(), zerousb.ErrNoDevice) {
+		return
+	}
+	select {
+	case sess.gone <- handle:
+	case <-sess.ended:
+	}
+}
+
+// service implements the RPC methods for all clients of a server.
+type service struct {
+	server *Server
+
+	lock     sync.Mutex
+	sessions map[string]*session // Connected clients by session id
+}
+
+// newToken generates a random 128 bit session id or device handle, so clients
+// can't guess the ones of other clients.
+func newToken() (string, error) {
+	var token [16]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return "", status.Errorf(codes.Internal, "remote: failed to generate token: %v", err)
+	}
+	return hex.EncodeToString(token[:]), nil
+}
+
+// desc describes the methods of the service to gRPC.
+func (svc *service) desc() *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: serviceName,
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			unary("Enumerate", func() interface{} { return new(EnumerateArgs) }, func(ctx context.Context, args interface{}) (interface{}, error) {
+				return svc.Enumerate(ctx, args.(*EnumerateArgs))
+			}),
+			unary("Open", func() interface{} { return new(OpenArgs) }, func(ctx context.Context, args interface{}) (interface{}, error) {
+				return svc.Open(ctx, args.(*OpenArgs))
+			}),
+			call("Close", svc.Close),
+			call("Read", svc.Read),
+			call("Write", svc.Write),
+			call("TryRead", svc.TryRead),
+			call("TryWrite", svc.TryWrite),
+			call("SetTimeouts", svc.SetTimeouts),
+			call("ReadWithTimeout", svc.ReadWithTimeout),
+			call("WriteWithTimeout", svc.WriteWithTimeout),
+			call("Control", svc.Control),
+			call("SetControlTimeout", svc.SetControlTimeout),
+			call("SetRetryPolicy", svc.SetRetryPolicy),
+			call("AttachKernelDriver", svc.AttachKernelDriver),
+			call("SetReattachOnClose", svc.SetReattachOnClose),
+			call("ClaimInterface", svc.ClaimInterface),
+			call("ReleaseInterface", svc.ReleaseInterface),
+			call("SetAltSetting", svc.SetAltSetting),
+			call("ClearHalt", svc.ClearHalt),
+			call("Reset", svc.Reset),
+			call("Configuration", svc.Configuration),
+			call("Status", svc.Status),
+			call("InterfaceStatus", svc.InterfaceStatus),
+			call("EndpointStatus", svc.EndpointStatus),
+			call("SetRemoteWakeup", svc.SetRemoteWakeup),
+			call("SetAutoSuspend", svc.SetAutoSuspend),
+			call("SetConfiguration", svc.SetConfiguration),
+			call("BOS", svc.BOS),
+			call("RawDescriptors", svc.RawDescriptors),
+		},
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName:    "Session",
+				Handler:       func(_ interface{}, stream grpc.ServerStream) error { return svc.Session(stream) },
+				ServerStreams: true,
+			},
+			{
+				StreamName:    "Hotplug",
+				Handler:       func(_ interface{}, stream grpc.ServerStream) error { return svc.Hotplug(stream) },
+				ServerStreams: true,
+			},
+		},
+	}
+}
+
+// unary describes a method decoding its request into a fresh message created
+// by args, then running it through the interceptors of the server, if any.
+// Failures matching a zerousb error are named in the trailer of the call.
+func unary(name string, args func() interface{}, method grpc.UnaryHandler) grpc.MethodDesc {
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		reply, err := method(ctx, req)
+		if name := sentinelName(err); name != "" {
+			grpc.SetTrailer(ctx, metadata.Pairs(errorHeader, name))
+		}
+		return reply, err
+	}
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := args()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/" + name}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
+
+// call describes a method on an opened device, taking a Call as its request.
+func call(name string, method func(ctx context.Context, args *Call) (interface{}, error)) grpc.MethodDesc {
+	return unary(name, func() interface{} { return new(Call) }, func(ctx context.Context, args interface{}) (interface{}, error) {
+		return method(ctx, args.(*Call))
+	})
+}
+
+// session looks up the session a call was made in.
+func (svc *service) session(ctx context.Context) (*session, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ids := md.Get(sessionHeader)
+	if len(ids) != 1 {
+		return nil, errUnknownSession
+	}
+	svc.lock.Lock()
+	defer svc.lock.Unlock()
+
+	sess, ok := svc.sessions[ids[0]]
+	if !ok {
+		return nil, errUnknownSession
+	}
+	return sess, nil
+}
+
+// device looks up a device opened within the session of a call.
+func (svc *service) device(ctx context.Context, handle string) (zerousb.Device, error) {
+	sess, err := svc.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	svc.lock.Lock()
+	defer svc.lock.Unlock()
+
+	dev, ok := sess.devices[handle]
+	if !ok {
+		return nil, errUnknownHandle
+	}
+	return dev, nil
+}
+
+// checkLength validates the buffer size a client asks to be allocated for a
+// transfer, as a negative or huge one would crash or exhaust the server.
+func checkLength(length int, limit int) error {
+	if length < 0 || length > limit {
+		return status.Errorf(codes.InvalidArgument, "remote: transfer length %d out of range [0, %d]", length, limit)
+	}
+	return nil
+}
+
+// enumerate lists the local devices through the configured enumerator.
+func (svc *service) enumerate(hid bool) ([]zerousb.DeviceInfo, error) {
+	if svc.server.Enumerate != nil {
+		return svc.server.Enumerate(hid)
+	}
+	all := func(zerousb.DeviceInfo) bool { return true }
+	if hid {
+		return zerousb.EnumerateHID(all)
+	}
+	return zerousb.Enumerate(all)
+}
+
+// notify streams the handles of the devices disconnected from the server to
+// the client, until it disconnects.
+func (sess *session) notify(stream grpc.ServerStream) error {
+	for {
+		select {
+		case handle := <-sess.gone:
+			if err := stream.SendMsg(&handle); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// Session establishes the session of a client, sending its id. The stream is
+// held open until the client disconnects, streaming the handles of the devices
+// disconnected meanwhile, then the devices it left open are closed.
+func (svc *service) Session(stream grpc.ServerStream) error {
+	if err := stream.RecvMsg(new(Call)); err != nil {
+		return err
+	}
+	id, err := newToken()
+	if err != nil {
+		return err
+	}
+	sess := &session{
+		devices: make(map[string]zerousb.Device),
+		gone:    make(chan string),
+		ended:   make(chan struct{}),
+	}
+
+	svc.lock.Lock()
+	svc.sessions[id] = sess
+	svc.lock.Unlock()
+
+	if err = stream.SendMsg(&id); err == nil {
+		err = sess.notify(stream)
+	}
+	svc.lock.Lock()
+	delete(svc.sessions, id)
+	devices := sess.devices
+	sess.devices = nil
+	close(sess.ended)
+	svc.lock.Unlock()
+
+	for _, dev := range devices {
+		dev.Close()
+	}
+	return err
+}
+
+// Enumerate lists the device interfaces attached to the server.
+func (svc *service) Enumerate(ctx context.Context, args *EnumerateArgs) (interface{}, error) {
+	infos, err := svc.enumerate(args.HID)
+	if err != nil {
+		return nil, err
+	}
+	return &infos, nil
+}
+
+// Open opens a device interface on the server, returning its handle.
+func (svc *service) Open(ctx context.Context, args *OpenArgs) (interface{}, error) {
+	sess, err := svc.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := svc.enumerate(true)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if info.Path != args.Path || info.Interface != args.Interface {
+			continue
+		}
+		open := svc.server.Open
+		if open == nil {
+			open = zerousb.DeviceInfo.Open
+		}
+		handle, err := newToken()
+		if err != nil {
+			return nil, err
+		}
+		dev, err := open(info)
+		if err != nil {
+			return nil, err
+		}
+		svc.lock.Lock()
+		defer svc.lock.Unlock()
+
+		if sess.devices == nil {
+			// The session ended while opening the device
+			dev.Close()
+			return nil, errUnknownSession
+		}
+		sess.devices[handle] = dev
+		go sess.watch(handle, dev)
+
+		return &handle, nil
+	}
+	return nil, status.Errorf(codes.NotFound, "remote: device %s interface %d not found", args.Path, args.Interface)
+}
+
+// Close closes an opened device.
+func (svc *service) Close(ctx context.Context, args *Call) (interface{}, error) {
+	sess, err := svc.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	svc.lock.Lock()
+	dev, ok := sess.devices[args.Handle]
+	delete(sess.devices, args.Handle)
+	svc.lock.Unlock()
+
+	if !ok {
+		return nil, errUnknownHandle
+	}
+	return empty(dev.Close())
+}
+
+// Read retrieves a binary blob from an opened device.
+func (svc *service) Read(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLength(args.Length, maxLength); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, args.Length)
+	n, err := dev.Read(buf)
+	if err != nil && n == 0 {
+		return nil, err
+	}
+	// Failed calls carry no reply, report partial reads in-band
+	return &Reply{N: n, Data: buf[:n], Err: errString(err), Sentinel: sentinelName(err)}, nil
+}
+
+// Write sends a binary blob to an opened device.
+func (svc *service) Write(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	n, err := dev.Write(args.Data)
+	if err != nil && n == 0 {
+		return nil, err
+	}
+	return &Reply{N: n, Err: errString(err), Sentinel: sentinelName(err)}, nil
+}
+
+// TryRead collects the data of a read queued on an opened device without
+// blocking.
+func (svc *service) TryRead(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLength(args.Length, maxLength); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, args.Length)
+	n, err := dev.TryRead(buf)
+	if errors.Is(err, zerousb.ErrWouldBlock) {
+		return &Reply{WouldBlock: true}, nil
+	}
+	if err != nil && n == 0 {
+		return nil, err
+	}
+	return &Reply{N: n, Data: buf[:n], Err: errString(err), Sentinel: sentinelName(err)}, nil
+}
+
+// TryWrite queues a write on an opened device without blocking.
+func (svc *service) TryWrite(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	n, err := dev.TryWrite(args.Data)
+	if errors.Is(err, zerousb.ErrWouldBlock) {
+		return &Reply{WouldBlock: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Reply{N: n}, nil
+}
+
+// SetTimeouts sets the read and write timeouts of an opened device.
+func (svc *service) SetTimeouts(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	dev.SetTimeouts(args.ReadTimeout, args.WriteTimeout)
+	return empty(nil)
+}
+
+// ReadWithTimeout retrieves a binary blob from an opened device, with a
+// timeout for this call only.
+func (svc *service) ReadWithTimeout(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLength(args.Length, maxLength); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, args.Length)
+	n, err := dev.ReadWithTimeout(buf, args.ReadTimeout)
+	if err != nil && n == 0 {
+		return nil, err
+	}
+	return &Reply{N: n, Data: buf[:n], Err: errString(err), Sentinel: sentinelName(err)}, nil
+}
+
+// WriteWithTimeout sends a binary blob to an opened device, with a timeout for
+// this call only.
+func (svc *service) WriteWithTimeout(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	n, err := dev.WriteWithTimeout(args.Data, args.WriteTimeout)
+	if err != nil && n == 0 {
+		return nil, err
+	}
+	return &Reply{N: n, Err: errString(err), Sentinel: sentinelName(err)}, nil
+}
+
+// Control sends a control request to an opened device.
+func (svc *service) Control(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	data := args.Data
+	if args.RequestType&zerousb.ControlIn != 0 {
+		if err := checkLength(args.Length, 0xffff); err != nil {
+			return nil, err
+		}
+		data = make([]byte, args.Length)
+	} else if err := checkLength(len(data), 0xffff); err != nil {
+		return nil, err
+	}
+	n, err := dev.Control(args.RequestType, args.Request, args.Value, args.Index, data)
+	if err != nil {
+		return nil, err
+	}
+	reply := &Reply{N: n}
+	if args.RequestType&zerousb.ControlIn != 0 {
+		reply.Data = data[:n]
+	}
+	return reply, nil
+}
+
+// SetControlTimeout sets the control request timeout of an opened device.
+func (svc *service) SetControlTimeout(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	dev.SetControlTimeout(args.ControlTimeout)
+	return empty(nil)
+}
+
+// SetRetryPolicy configures the transfer retries of an opened device.
+func (svc *service) SetRetryPolicy(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	dev.SetRetryPolicy(args.Retry)
+	return empty(nil)
+}
+
+// AttachKernelDriver gives the interface back to its kernel driver.
+func (svc *service) AttachKernelDriver(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	return empty(dev.AttachKernelDriver())
+}
+
+// SetReattachOnClose configures whether closing reattaches the kernel driver.
+func (svc *service) SetReattachOnClose(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	dev.SetReattachOnClose(args.Reattach)
+	return empty(nil)
+}
+
+// ClaimInterface claims an additional interface of an opened device.
+func (svc *service) ClaimInterface(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	return empty(dev.ClaimInterface(args.Interface))
+}
+
+// ReleaseInterface releases an additionally claimed interface.
+func (svc *service) ReleaseInterface(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	return empty(dev.ReleaseInterface(args.Interface))
+}
+
+// SetAltSetting activates an alternate setting of a claimed interface.
+func (svc *service) SetAltSetting(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	return empty(dev.SetAltSetting(args.Interface, args.AltSetting))
+}
+
+// ClearHalt clears the halt/stall condition of an endpoint.
+func (svc *service) ClearHalt(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	return empty(dev.ClearHalt(args.Endpoint))
+}
+
+// Reset performs a USB port reset of an opened device.
+func (svc *service) Reset(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	return empty(dev.Reset())
+}
+
+// Configuration returns the active configuration of an opened device.
+func (svc *service) Configuration(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	config, err := dev.Configuration()
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// Status retrieves the device status of an opened device.
+func (svc *service) Status(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	st, err := dev.Status()
+	if err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// InterfaceStatus retrieves the status of an interface of an opened device.
+func (svc *service) InterfaceStatus(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	st, err := dev.InterfaceStatus(args.Interface)
+	if err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// EndpointStatus retrieves the status of an endpoint of an opened device.
+func (svc *service) EndpointStatus(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	st, err := dev.EndpointStatus(args.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// SetRemoteWakeup arms or disarms the remote wakeup of an opened device.
+func (svc *service) SetRemoteWakeup(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	return empty(dev.SetRemoteWakeup(args.Enable))
+}
+
+// SetAutoSuspend configures the kernel autosuspend of an opened device.
+func (svc *service) SetAutoSuspend(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	return empty(dev.SetAutoSuspend(args.Enable, args.Delay))
+}
+
+// SetConfiguration activates a configuration of an opened device.
+func (svc *service) SetConfiguration(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	return empty(dev.SetConfiguration(args.Config))
+}
+
+// BOS retrieves the Binary Object Store descriptor of an opened device.
+func (svc *service) BOS(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	return dev.BOS()
+}
+
+// RawDescriptors retrieves the raw descriptors of an opened device.
+func (svc *service) RawDescriptors(ctx context.Context, args *Call) (interface{}, error) {
+	dev, err := svc.device(ctx, args.Handle)
+	if err != nil {
+		return nil, err
+	}
+	return dev.RawDescriptors()
+}
+
+// Hotplug streams hotplug notifications to the client until it cancels the
+// stream. The response headers are sent once the local handler is registered,
+// so clients learn about failures to register it up front.
+func (svc *service) Hotplug(stream grpc.ServerStream) error {
+	if err := stream.RecvMsg(new(Call)); err != nil {
+		return err
+	}
+	events := make(chan Event, eventBacklog)
+
+	onHotplug := svc.server.OnHotplug
+	if onHotplug == nil {
+		onHotplug = zerousb.OnHotplug
+	}
+	stop, err := onHotplug(func(kind zerousb.HotplugEvent, info zerousb.DeviceInfo) {
+		// Handlers must not block, drop notifications of a stalled client
+		select {
+		case events <- Event{Kind: kind, Info: info}:
+		default:
+		}
+	})
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+	for {
+		select {
+		case event := <-events:
+			if err := stream.SendMsg(&event); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// empty returns the reply of calls returning nothing but an error.
+func empty(err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &struct{}{}, nil
+}
+
+// errString returns the message of an error, empty for nil.
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
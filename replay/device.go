@@ -0,0 +1,260 @@
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/chay22/zerousb"
+)
+
+// Device is a virtual device replaying a recorded session. Read, Write and
+// Control calls are matched against the recorded transfers in order, other
+// calls succeed without effect, reporting the recorded state where there's
+// one (e.g. the configuration and descriptors).
+type Device struct {
+	session *Session
+	next    int // Index of the next exchange to replay
+
+	done   chan struct{} // Closed once the device is closed
+	closed bool
+	lock   sync.Mutex
+}
+
+// NewDevice returns a virtual device replaying the session.
+func NewDevice(session *Session) *Device {
+	return &Device{session: session, done: make(chan struct{})}
+}
+
+// Remaining returns the number of recorded transfers not replayed yet, letting
+// tests check that the protocol code ran the whole session.
+func (dev *Device) Remaining() int {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	return len(dev.session.Exchanges) - dev.next
+}
+
+// replay matches a transfer against the next recorded exchange, returning the
+// exchange if the match function accepts it.
+func (dev *Device) replay(op string, match func(ex *Exchange) error) (*Exchange, error) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.closed {
+		return nil, zerousb.ErrDeviceClosed
+	}
+	if dev.next == len(dev.session.Exchanges) {
+		return nil, ErrSessionEnded
+	}
+	ex := &dev.session.Exchanges[dev.next]
+	if ex.Op != op {
+		return nil, fmt.Errorf("%w: exchange %d: have %s, recorded %s", ErrMismatch, dev.next, op, ex.Op)
+	}
+	if err := match(ex); err != nil {
+		return nil, fmt.Errorf("%w: exchange %d: %v", ErrMismatch, dev.next, err)
+	}
+	dev.next++
+	return ex, nil
+}
+
+// read replays a read into b.
+func (dev *Device) read(b []byte) (int, error) {
+	ex, err := dev.replay(OpRead, func(ex *Exchange) error {
+		if len(ex.Data) > len(b) {
+			return fmt.Errorf("read of %d bytes, recorded %d", len(b), len(ex.Data))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return copy(b, ex.Data), ex.err()
+}
+
+// write replays a write of b.
+func (dev *Device) write(b []byte) (int, error) {
+	ex, err := dev.replay(OpWrite, func(ex *Exchange) error {
+		if !bytes.Equal(ex.Data, b) {
+			return fmt.Errorf("write of %x, recorded %x", b, ex.Data)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return ex.N, ex.err()
+}
+
+// Close closes the virtual device.
+func (dev *Device) Close() error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if !dev.closed {
+		dev.closed = true
+		close(dev.done)
+	}
+	return nil
+}
+
+// Write replays a write.
+func (dev *Device) Write(b []byte) (int, error) { return dev.write(b) }
+
+// WriteV replays a write of the concatenated buffers.
+func (dev *Device) WriteV(bufs net.Buffers) (int, error) { return dev.write(bytes.Join(bufs, nil)) }
+
+// Read replays a read.
+func (dev *Device) Read(b []byte) (int, error) { return dev.read(b) }
+
+// SetTimeouts has no effect, replayed transfers complete immediately.
+func (dev *Device) SetTimeouts(read, write time.Duration) {}
+
+// ReadWithTimeout replays a read.
+func (dev *Device) ReadWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	return dev.read(b)
+}
+
+// WriteWithTimeout replays a write.
+func (dev *Device) WriteWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	return dev.write(b)
+}
+
+// TryRead replays a read, which never blocks.
+func (dev *Device) TryRead(b []byte) (int, error) { return dev.read(b) }
+
+// TryWrite replays a write, which never blocks.
+func (dev *Device) TryWrite(b []byte) (int, error) { return dev.write(b) }
+
+// Control replays a control request.
+func (dev *Device) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	in := rType&zerousb.ControlIn != 0
+	ex, err := dev.replay(OpControl, func(ex *Exchange) error {
+		if ex.RequestType != rType || ex.Request != request || ex.Value != val || ex.Index != idx || ex.Length != len(data) {
+			return fmt.Errorf("control request %#02x %#02x %#04x %#04x of %d bytes, recorded %#02x %#02x %#04x %#04x of %d bytes",
+				rType, request, val, idx, len(data), ex.RequestType, ex.Request, ex.Value, ex.Index, ex.Length)
+		}
+		if !in && !bytes.Equal(ex.Data, data) {
+			return fmt.Errorf("control data %x, recorded %x", data, ex.Data)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if in {
+		return copy(data, ex.Data), ex.err()
+	}
+	return ex.N, ex.err()
+}
+
+// AllocBulkStreams fails, bulk streams aren't recorded.
+func (dev *Device) AllocBulkStreams(count int, endpoints ...uint8) (int, error) {
+	return 0, fmt.Errorf("failed to allocate bulk streams: %w", zerousb.ErrNotSupported)
+}
+
+// FreeBulkStreams fails, bulk streams aren't recorded.
+func (dev *Device) FreeBulkStreams(endpoints ...uint8) error {
+	return fmt.Errorf("failed to free bulk streams: %w", zerousb.ErrNotSupported)
+}
+
+// OpenBulkStream fails, bulk streams aren't recorded.
+func (dev *Device) OpenBulkStream(endpoint uint8, streamID uint32) (*zerousb.BulkStream, error) {
+	return nil, fmt.Errorf("failed to open bulk stream: %w", zerousb.ErrNotSupported)
+}
+
+// SetAltSetting has no effect.
+func (dev *Device) SetAltSetting(iface int, alt int) error { return nil }
+
+// ClearHalt has no effect.
+func (dev *Device) ClearHalt(endpoint uint8) error { return nil }
+
+// Reset has no effect.
+func (dev *Device) Reset() error { return nil }
+
+// SetConfiguration has no effect.
+func (dev *Device) SetConfiguration(config int) error { return nil }
+
+// Configuration returns the configuration of the recorded interface.
+func (dev *Device) Configuration() (int, error) {
+	return int(dev.session.Info.Config.Value), nil
+}
+
+// Status reports a zero status.
+func (dev *Device) Status() (zerousb.DeviceStatus, error) { return zerousb.DeviceStatus{}, nil }
+
+// InterfaceStatus reports a zero status.
+func (dev *Device) InterfaceStatus(iface int) (zerousb.InterfaceStatus, error) {
+	return zerousb.InterfaceStatus{}, nil
+}
+
+// EndpointStatus reports a zero status.
+func (dev *Device) EndpointStatus(endpoint uint8) (zerousb.EndpointStatus, error) {
+	return zerousb.EndpointStatus{}, nil
+}
+
+// SetRemoteWakeup has no effect.
+func (dev *Device) SetRemoteWakeup(enable bool) error { return nil }
+
+// SetAutoSuspend has no effect.
+func (dev *Device) SetAutoSuspend(enable bool, delay time.Duration) error { return nil }
+
+// SetControlTimeout has no effect.
+func (dev *Device) SetControlTimeout(timeout time.Duration) {}
+
+// SetRetryPolicy has no effect, recorded failures are replayed as they were.
+func (dev *Device) SetRetryPolicy(policy *zerousb.RetryPolicy) {}
+
+// SetMetrics has no effect.
+func (dev *Device) SetMetrics(m zerousb.Metrics) {}
+
+// AcquireBuffer returns a plain Go buffer of the given size.
+func (dev *Device) AcquireBuffer(size int) (*zerousb.Buffer, error) {
+	return zerousb.NewBuffer(size), nil
+}
+
+// AttachKernelDriver has no effect.
+func (dev *Device) AttachKernelDriver() error { return nil }
+
+// SetReattachOnClose has no effect.
+func (dev *Device) SetReattachOnClose(reattach bool) {}
+
+// ClaimInterface has no effect.
+func (dev *Device) ClaimInterface(iface int) error { return nil }
+
+// ReleaseInterface has no effect.
+func (dev *Device) ReleaseInterface(iface int) error { return nil }
+
+// BOS fails, the BOS descriptor isn't recorded.
+func (dev *Device) BOS() (*zerousb.BOSDescriptor, error) {
+	return nil, fmt.Errorf("failed to read BOS descriptor: %w", zerousb.ErrNotSupported)
+}
+
+// RawDescriptors returns the recorded descriptors of the device.
+func (dev *Device) RawDescriptors() (*zerousb.RawDescriptors, error) {
+	if dev.session.Descriptors == nil {
+		return nil, fmt.Errorf("failed to read descriptors: not recorded: %w", zerousb.ErrNotSupported)
+	}
+	return dev.session.Descriptors, nil
+}
+
+// Done returns a channel closed once the device is closed.
+func (dev *Device) Done() <-chan struct{} {
+	return dev.done
+}
+
+// Err returns nil until Done is closed, then zerousb.ErrDeviceClosed.
+func (dev *Device) Err() error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.closed {
+		return zerousb.ErrDeviceClosed
+	}
+	return nil
+}
+
+// OnDisconnect does nothing, replayed devices can't be disconnected.
+func (dev *Device) OnDisconnect(fn func()) {}
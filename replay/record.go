@@ -0,0 +1,103 @@
+package replay
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/chay22/zerousb"
+)
+
+// Recorder is a device recording the transfers made through it into a session.
+// Methods other than Read, Write and Control are passed through unrecorded.
+type Recorder struct {
+	zerousb.Device
+
+	session Session
+	lock    sync.Mutex
+}
+
+// Record starts recording a session with a device opened with the info. The
+// descriptors of the device are read right away.
+func Record(dev zerousb.Device, info zerousb.DeviceInfo) *Recorder {
+	rec := &Recorder{Device: dev}
+	rec.session.Info = info
+	if raw, err := dev.RawDescriptors(); err == nil {
+		rec.session.Descriptors = raw
+	}
+	return rec
+}
+
+// Session returns a copy of the session recorded so far.
+func (rec *Recorder) Session() *Session {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	session := rec.session
+	session.Exchanges = append([]Exchange{}, rec.session.Exchanges...)
+	return &session
+}
+
+// record appends a finished exchange to the session.
+func (rec *Recorder) record(ex Exchange, err error) {
+	ex.setErr(err)
+
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	rec.session.Exchanges = append(rec.session.Exchanges, ex)
+}
+
+// Read retrieves a binary blob from the device, recording the data received.
+func (rec *Recorder) Read(b []byte) (int, error) {
+	n, err := rec.Device.Read(b)
+	rec.record(Exchange{Op: OpRead, Length: len(b), Data: append([]byte{}, b[:n]...), N: n}, err)
+	return n, err
+}
+
+// ReadWithTimeout retrieves a binary blob from the device with a timeout for
+// this call only, recording the data received.
+func (rec *Recorder) ReadWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	n, err := rec.Device.ReadWithTimeout(b, timeout)
+	rec.record(Exchange{Op: OpRead, Length: len(b), Data: append([]byte{}, b[:n]...), N: n}, err)
+	return n, err
+}
+
+// Write sends a binary blob to the device, recording the data sent.
+func (rec *Recorder) Write(b []byte) (int, error) {
+	n, err := rec.Device.Write(b)
+	rec.record(Exchange{Op: OpWrite, Length: len(b), Data: append([]byte{}, b...), N: n}, err)
+	return n, err
+}
+
+// WriteWithTimeout sends a binary blob to the device with a timeout for this
+// call only, recording the data sent.
+func (rec *Recorder) WriteWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	n, err := rec.Device.WriteWithTimeout(b, timeout)
+	rec.record(Exchange{Op: OpWrite, Length: len(b), Data: append([]byte{}, b...), N: n}, err)
+	return n, err
+}
+
+// WriteV sends the buffers to the device, recording them as a single write.
+func (rec *Recorder) WriteV(bufs net.Buffers) (int, error) {
+	data := bytes.Join(bufs, nil)
+	n, err := rec.Device.WriteV(bufs)
+	rec.record(Exchange{Op: OpWrite, Length: len(data), Data: data, N: n}, err)
+	return n, err
+}
+
+// Control sends a control request to the device, recording the data moved.
+func (rec *Recorder) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	ex := Exchange{Op: OpControl, RequestType: rType, Request: request, Value: val, Index: idx, Length: len(data)}
+	if rType&zerousb.ControlIn == 0 {
+		ex.Data = append([]byte{}, data...)
+	}
+	n, err := rec.Device.Control(rType, request, val, idx, data)
+	if rType&zerousb.ControlIn != 0 {
+		ex.Data = append([]byte{}, data[:n]...)
+	}
+	ex.N = n
+	rec.record(ex, err)
+	return n, err
+}
@@ -0,0 +1,88 @@
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/chay22/zerousb"
+)
+
+// Ensure the recorder and the virtual device implement the generic device
+// interface.
+var (
+	_ zerousb.Device = (*Recorder)(nil)
+	_ zerousb.Device = (*Device)(nil)
+)
+
+// echoDevice is a device answering reads with the last write, timing out if
+// there was none. Methods other than the transfers are not implemented.
+type echoDevice struct {
+	zerousb.Device
+	last []byte
+}
+
+func (dev *echoDevice) Write(b []byte) (int, error) {
+	dev.last = append([]byte{}, b...)
+	return len(b), nil
+}
+func (dev *echoDevice) Read(b []byte) (int, error) {
+	if dev.last == nil {
+		return 0, &zerousb.TransferError{Endpoint: 0x81, Err: zerousb.ErrTimeout}
+	}
+	n := copy(b, dev.last)
+	dev.last = nil
+	return n, nil
+}
+func (dev *echoDevice) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	return copy(data, "ok"), nil
+}
+func (dev *echoDevice) RawDescriptors() (*zerousb.RawDescriptors, error) {
+	return &zerousb.RawDescriptors{Device: []byte{18, 1}}, nil
+}
+
+// Tests that sessions recorded from a device and saved replay the same
+// transfers, failures included, and reject transfers deviating from them.
+func TestRecordReplay(t *testing.T) {
+	rec := Record(new(echoDevice), zerousb.DeviceInfo{VendorID: 0x1234})
+	buf := make([]byte, 8)
+
+	rec.Write([]byte("ping"))
+	rec.Read(buf)
+	rec.Read(buf)
+	rec.Control(zerousb.ControlIn|zerousb.ControlVendor, 1, 0, 0, buf[:2])
+
+	var saved bytes.Buffer
+	if err := rec.Session().Save(&saved); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	session, err := Load(&saved)
+	if err != nil {
+		t.Fatalf("failed to load session: %v", err)
+	}
+	if session.Info.VendorID != 0x1234 || session.Descriptors == nil || len(session.Exchanges) != 4 {
+		t.Fatalf("session mismatch: have %+v", session)
+	}
+	dev := NewDevice(session)
+	if _, err := dev.Write([]byte("pong")); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("deviating write mismatch: have %v, want %v", err, ErrMismatch)
+	}
+	if n, err := dev.Write([]byte("ping")); n != 4 || err != nil {
+		t.Fatalf("write mismatch: have %d, %v, want 4, nil", n, err)
+	}
+	if n, err := dev.Read(buf); n != 4 || err != nil || string(buf[:n]) != "ping" {
+		t.Fatalf("read mismatch: have %q, %v, want ping, nil", buf[:n], err)
+	}
+	if _, err := dev.Read(buf); !errors.Is(err, zerousb.ErrTimeout) {
+		t.Fatalf("failed read mismatch: have %v, want %v", err, zerousb.ErrTimeout)
+	}
+	if n, err := dev.Control(zerousb.ControlIn|zerousb.ControlVendor, 1, 0, 0, buf[:2]); n != 2 || err != nil || string(buf[:n]) != "ok" {
+		t.Fatalf("control mismatch: have %q, %v, want ok, nil", buf[:n], err)
+	}
+	if dev.Remaining() != 0 {
+		t.Errorf("remaining exchanges mismatch: have %d, want 0", dev.Remaining())
+	}
+	if _, err := dev.Read(buf); err != ErrSessionEnded {
+		t.Errorf("ended session mismatch: have %v, want %v", err, ErrSessionEnded)
+	}
+}
@@ -0,0 +1,126 @@
+// Package replay records sessions with USB devices, the descriptors of the
+// device along with the transfers exchanged with it, and replays them through
+// virtual devices. Protocol code can then be regression tested without any
+// hardware attached, e.g. in CI.
+//
+// Sessions are stored as JSON, so recordings can be inspected and trimmed by
+// hand. Replays are strict: each transfer must match the next one recorded,
+// or it fails with an error matching ErrMismatch.
+package replay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/chay22/zerousb"
+)
+
+// Operations exchanges are recorded for.
+const (
+	OpRead    = "Read"
+	OpWrite   = "Write"
+	OpControl = "Control"
+)
+
+// Exchange is a single transfer of a recorded session.
+type Exchange struct {
+	Op string // Operation, one of OpRead, OpWrite and OpControl
+
+	RequestType uint8  `json:",omitempty"` // Control request bmRequestType
+	Request     uint8  `json:",omitempty"` // Control request bRequest
+	Value       uint16 `json:",omitempty"` // Control request wValue
+	Index       uint16 `json:",omitempty"` // Control request wIndex
+
+	Length int    // Buffer size of reads and IN control requests, data size otherwise
+	Data   []byte // Data sent by writes and OUT control requests, or received otherwise
+	N      int    // Number of bytes transferred
+
+	Err  string `json:",omitempty"` // Message of the error the transfer failed with
+	Code string `json:",omitempty"` // Portable error the failure matched, as named by zerousb.ErrorCode
+}
+
+// Session is a recorded session with a device interface.
+type Session struct {
+	Info        zerousb.DeviceInfo      // Info the device was opened with
+	Descriptors *zerousb.RawDescriptors // Descriptors of the device, nil if they couldn't be read
+	Exchanges   []Exchange              // Transfers in the order they were made
+}
+
+// Load reads a session saved by Save.
+func Load(r io.Reader) (*Session, error) {
+	session := new(Session)
+	if err := json.NewDecoder(r).Decode(session); err != nil {
+		return nil, fmt.Errorf("failed to load session: %w", err)
+	}
+	return session, nil
+}
+
+// Save writes the session to w as indented JSON.
+func (s *Session) Save(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	return nil
+}
+
+// errorCodes maps the portable error names of zerousb.ErrorCode back to the
+// errors, so replayed failures match them via errors.Is.
+var errorCodes = map[string]error{
+	"timeout":       zerousb.ErrTimeout,
+	"pipe":          zerousb.ErrPipe,
+	"overflow":      zerousb.ErrOverflow,
+	"interrupted":   zerousb.ErrInterrupted,
+	"no_device":     zerousb.ErrNoDevice,
+	"closed":        zerousb.ErrDeviceClosed,
+	"access":        zerousb.ErrAccess,
+	"busy":          zerousb.ErrBusy,
+	"not_found":     zerousb.ErrNotFound,
+	"invalid_param": zerousb.ErrInvalidParam,
+	"no_mem":        zerousb.ErrNoMem,
+	"not_supported": zerousb.ErrNotSupported,
+	"io":            zerousb.ErrIO,
+}
+
+// recordedError is a failure replayed from a session, carrying the message it
+// was recorded with while matching the portable error it matched back then.
+type recordedError struct {
+	msg string
+	err error // Portable error, nil if it matched none
+}
+
+// Error implements the error interface.
+func (e *recordedError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the portable error the failure matched when recorded.
+func (e *recordedError) Unwrap() error {
+	return e.err
+}
+
+// err returns the failure of the exchange, nil if it succeeded.
+func (ex *Exchange) err() error {
+	if ex.Err == "" {
+		return nil
+	}
+	return &recordedError{msg: ex.Err, err: errorCodes[ex.Code]}
+}
+
+// setErr records the failure of the exchange, if any.
+func (ex *Exchange) setErr(err error) {
+	if err != nil {
+		ex.Err, ex.Code = err.Error(), zerousb.ErrorCode(err)
+	}
+}
+
+// ErrMismatch is matched by the errors of replayed transfers differing from the
+// next one recorded in the session.
+var ErrMismatch = errors.New("replay: transfer mismatch")
+
+// ErrSessionEnded is returned by replayed transfers once all the recorded ones
+// were replayed.
+var ErrSessionEnded = errors.New("replay: session ended")
@@ -0,0 +1,46 @@
+package zerousb
+
+import "time"
+
+// RetryPolicy configures how Read and Write retry transfers failing with
+// transient errors, such as timeouts and stalls on flaky links.
+type RetryPolicy struct {
+	MaxAttempts int           // Total attempts including the first one, below 2 disables retries
+	Backoff     time.Duration // Delay before the first retry, doubled after each attempt
+	MaxBackoff  time.Duration // Upper bound of the delay between retries, zero for none
+
+	// Retryable decides whether a failed transfer is retried, receiving the
+	// error reported by the backend. If nil, timeouts, stalls and interrupted
+	// transfers are retried.
+	Retryable func(err error) bool
+}
+
+// run executes a transfer, retrying it according to the policy. The transient
+// classifier of the backend is used if the policy doesn't specify one. A nil
+// policy executes the transfer once. Transfers which moved part of the data
+// before failing aren't retried, as that would duplicate or drop data. Each
+// retry is reported to the retried callback beforehand, unless it's nil.
+func (p *RetryPolicy) run(transient func(error) bool, retried func(error), transfer func() (int, error)) (int, error) {
+	if p == nil || p.MaxAttempts < 2 {
+		return transfer()
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = transient
+	}
+	delay := p.Backoff
+	for attempt := 1; ; attempt++ {
+		n, err := transfer()
+		if err == nil || n > 0 || attempt >= p.MaxAttempts || !retryable(err) {
+			return n, err
+		}
+		if retried != nil {
+			retried(err)
+		}
+		time.Sleep(delay)
+
+		if delay *= 2; p.MaxBackoff != 0 && delay > p.MaxBackoff {
+			delay = p.MaxBackoff
+		}
+	}
+}
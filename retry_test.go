@@ -0,0 +1,55 @@
+package zerousb
+
+import (
+	"errors"
+	"testing"
+)
+
+// Tests that transfers are retried only on retryable errors, and only up to
+// the configured number of attempts, reporting each retry.
+func TestRetryPolicy(t *testing.T) {
+	errTransient := errors.New("transient")
+	errFatal := errors.New("fatal")
+	transient := func(err error) bool { return err == errTransient }
+
+	tests := []struct {
+		policy   *RetryPolicy
+		failures []error
+		partial  bool
+		attempts int
+		fail     bool
+	}{
+		{nil, []error{errTransient}, false, 1, true},
+		{&RetryPolicy{MaxAttempts: 1}, []error{errTransient}, false, 1, true},
+		{&RetryPolicy{MaxAttempts: 3}, []error{errTransient, errTransient}, false, 3, false},
+		{&RetryPolicy{MaxAttempts: 3}, []error{errTransient, errTransient, errTransient}, false, 3, true},
+		{&RetryPolicy{MaxAttempts: 3}, []error{errFatal}, false, 1, true},
+		{&RetryPolicy{MaxAttempts: 3}, []error{errTransient}, true, 1, true},
+		{&RetryPolicy{MaxAttempts: 3, Retryable: func(err error) bool { return err == errFatal }}, []error{errFatal}, false, 2, false},
+	}
+	for i, tt := range tests {
+		attempts, retries := 0, 0
+		n, err := tt.policy.run(transient, func(error) { retries++ }, func() (int, error) {
+			attempts++
+			if attempts <= len(tt.failures) {
+				if tt.partial {
+					return 2, tt.failures[attempts-1]
+				}
+				return 0, tt.failures[attempts-1]
+			}
+			return 4, nil
+		})
+		if attempts != tt.attempts {
+			t.Errorf("test %d: attempt count mismatch: have %d, want %d", i, attempts, tt.attempts)
+		}
+		if retries != attempts-1 {
+			t.Errorf("test %d: retry count mismatch: have %d, want %d", i, retries, attempts-1)
+		}
+		if (err != nil) != tt.fail {
+			t.Errorf("test %d: failure mismatch: have %v, want failure %v", i, err, tt.fail)
+		}
+		if err == nil && n != 4 {
+			t.Errorf("test %d: transferred bytes mismatch: have %d, want 4", i, n)
+		}
+	}
+}
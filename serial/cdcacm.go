@@ -0,0 +1,76 @@
+package serial
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/chay22/zerousb"
+)
+
+// Class requests of the CDC Abstract Control Model, PSTN spec section 6.3.
+const (
+	acmSetLineCoding       = 0x20
+	acmSetControlLineState = 0x22
+)
+
+// CDCACM is a serial port speaking the USB CDC Abstract Control Model.
+type CDCACM struct {
+	dev   Transport
+	iface uint16 // Communication interface, receiver of the class requests
+
+	lock  sync.Mutex // Protects the line state below
+	lines uint16     // Current DTR (bit 0) and RTS (bit 1) state
+}
+
+// NewCDCACM wraps a device opened on the CDC data interface, with iface being
+// the number of the associated communication interface.
+func NewCDCACM(dev Transport, iface int) *CDCACM {
+	return &CDCACM{dev: dev, iface: uint16(iface)}
+}
+
+// Read receives data from the port.
+func (p *CDCACM) Read(b []byte) (int, error) { return p.dev.Read(b) }
+
+// Write sends data to the port.
+func (p *CDCACM) Write(b []byte) (int, error) { return p.dev.Write(b) }
+
+// SetMode configures the line coding of the port.
+func (p *CDCACM) SetMode(mode Mode) error {
+	bits, err := mode.dataBits()
+	if err != nil {
+		return err
+	}
+	coding := make([]byte, 7)
+	binary.LittleEndian.PutUint32(coding, uint32(mode.BaudRate))
+	coding[4] = uint8(mode.StopBits)
+	coding[5] = uint8(mode.Parity)
+	coding[6] = uint8(bits)
+
+	if _, err := p.dev.Control(zerousb.ControlOut|zerousb.ControlClass|zerousb.ControlInterface, acmSetLineCoding, 0, p.iface, coding); err != nil {
+		return fmt.Errorf("serial: failed to set line coding: %w", err)
+	}
+	return nil
+}
+
+// SetDTR drives the Data Terminal Ready line.
+func (p *CDCACM) SetDTR(on bool) error { return p.setLine(0x01, on) }
+
+// SetRTS drives the Request To Send line.
+func (p *CDCACM) SetRTS(on bool) error { return p.setLine(0x02, on) }
+
+// setLine updates a single bit of the control line state.
+func (p *CDCACM) setLine(bit uint16, on bool) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	lines := p.lines &^ bit
+	if on {
+		lines |= bit
+	}
+	if _, err := p.dev.Control(zerousb.ControlOut|zerousb.ControlClass|zerousb.ControlInterface, acmSetControlLineState, lines, p.iface, nil); err != nil {
+		return fmt.Errorf("serial: failed to set control lines: %w", err)
+	}
+	p.lines = lines
+	return nil
+}
@@ -0,0 +1,150 @@
+package serial
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/chay22/zerousb"
+)
+
+// Vendor requests of the WCH CH340/CH341 bridges, as used by the Linux driver.
+const (
+	ch34xWriteReg   = 0x9a
+	ch34xModemCtrl  = 0xa4
+	ch34xSerialInit = 0xa1
+)
+
+// Register pairs written by the baud rate and line control requests.
+const (
+	ch34xRegBaud = 0x1312
+	ch34xRegLCR  = 0x2518
+)
+
+// Line control register bits.
+const (
+	ch34xLCREnableRX  = 0x80
+	ch34xLCREnableTX  = 0x40
+	ch34xLCRMarkSpace = 0x20
+	ch34xLCRParEven   = 0x10
+	ch34xLCREnablePar = 0x08
+	ch34xLCRStopBits2 = 0x04
+)
+
+// Modem control bits, sent inverted.
+const (
+	ch34xDTR = 0x20
+	ch34xRTS = 0x40
+)
+
+// Constants of the baud rate generator.
+const (
+	ch34xBaudFactor = 1532620800
+	ch34xBaudDivMax = 3
+)
+
+// CH34x is a serial port on a WCH CH340 or CH341 bridge.
+type CH34x struct {
+	dev Transport
+
+	lock  sync.Mutex // Protects the modem control state below
+	lines uint16     // Current DTR and RTS state
+}
+
+// NewCH34x wraps a device opened on a CH340/CH341 bridge and initializes it.
+func NewCH34x(dev Transport) (*CH34x, error) {
+	p := &CH34x{dev: dev}
+	if err := p.control(ch34xSerialInit, 0, 0); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// control issues a vendor request towards the device.
+func (p *CH34x) control(request uint8, val uint16, idx uint16) error {
+	if _, err := p.dev.Control(zerousb.ControlOut|zerousb.ControlVendor|zerousb.ControlDevice, request, val, idx, nil); err != nil {
+		return fmt.Errorf("serial: ch34x request 0x%02x failed: %w", request, err)
+	}
+	return nil
+}
+
+// Read receives data from the port.
+func (p *CH34x) Read(b []byte) (int, error) { return p.dev.Read(b) }
+
+// Write sends data to the port.
+func (p *CH34x) Write(b []byte) (int, error) { return p.dev.Write(b) }
+
+// SetMode configures the baud rate and line control of the port.
+func (p *CH34x) SetMode(mode Mode) error {
+	bits, err := mode.dataBits()
+	if err != nil {
+		return err
+	}
+	divisor, err := ch34xDivisor(mode.BaudRate)
+	if err != nil {
+		return err
+	}
+	if err := p.control(ch34xWriteReg, ch34xRegBaud, divisor); err != nil {
+		return err
+	}
+	lcr := uint16(ch34xLCREnableRX | ch34xLCREnableTX | (bits - 5))
+	switch mode.Parity {
+	case ParityOdd:
+		lcr |= ch34xLCREnablePar
+	case ParityEven:
+		lcr |= ch34xLCREnablePar | ch34xLCRParEven
+	case ParityMark:
+		lcr |= ch34xLCREnablePar | ch34xLCRMarkSpace
+	case ParitySpace:
+		lcr |= ch34xLCREnablePar | ch34xLCRMarkSpace | ch34xLCRParEven
+	}
+	switch mode.StopBits {
+	case StopBits2:
+		lcr |= ch34xLCRStopBits2
+	case StopBits15:
+		return fmt.Errorf("serial: ch34x doesn't support 1.5 stop bits")
+	}
+	return p.control(ch34xWriteReg, ch34xRegLCR, lcr)
+}
+
+// ch34xDivisor calculates the prescaler and divisor register values of a baud
+// rate.
+func ch34xDivisor(baud int) (uint16, error) {
+	if baud <= 0 {
+		return 0, fmt.Errorf("serial: invalid baud rate %d", baud)
+	}
+	factor := uint32(ch34xBaudFactor / baud)
+	divisor := uint32(ch34xBaudDivMax)
+	for factor > 0xfff0 && divisor > 0 {
+		factor >>= 3
+		divisor--
+	}
+	if factor > 0xfff0 {
+		return 0, fmt.Errorf("serial: unsupported baud rate %d", baud)
+	}
+	factor = 0x10000 - factor
+
+	// Bit 7 disables buffering until a full packet was received
+	return uint16(factor&0xff00 | divisor | 0x80), nil
+}
+
+// SetDTR drives the Data Terminal Ready line.
+func (p *CH34x) SetDTR(on bool) error { return p.setLine(ch34xDTR, on) }
+
+// SetRTS drives the Request To Send line.
+func (p *CH34x) SetRTS(on bool) error { return p.setLine(ch34xRTS, on) }
+
+// setLine updates a single modem control line.
+func (p *CH34x) setLine(bit uint16, on bool) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	lines := p.lines &^ bit
+	if on {
+		lines |= bit
+	}
+	if err := p.control(ch34xModemCtrl, ^lines, 0); err != nil {
+		return err
+	}
+	p.lines = lines
+	return nil
+}
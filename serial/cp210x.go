@@ -0,0 +1,84 @@
+package serial
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/chay22/zerousb"
+)
+
+// Vendor requests of the Silicon Labs CP210x bridges, see AN571.
+const (
+	cp210xIfcEnable   = 0x00
+	cp210xSetLineCtl  = 0x03
+	cp210xSetMHS      = 0x07
+	cp210xSetBaudRate = 0x1e
+)
+
+// Modem handshake bits of the SET_MHS request, with their write masks.
+const (
+	cp210xDTR     = 0x0001
+	cp210xRTS     = 0x0002
+	cp210xDTRMask = 0x0100
+	cp210xRTSMask = 0x0200
+)
+
+// CP210x is a serial port on a Silicon Labs CP210x bridge.
+type CP210x struct {
+	dev   Transport
+	iface uint16 // Interface of the port, selecting it on multi-port chips
+}
+
+// NewCP210x wraps a device opened on a CP210x interface and enables its UART.
+func NewCP210x(dev Transport, iface int) (*CP210x, error) {
+	p := &CP210x{dev: dev, iface: uint16(iface)}
+	if err := p.control(cp210xIfcEnable, 1, nil); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// control issues a vendor request towards the interface of the port.
+func (p *CP210x) control(request uint8, val uint16, data []byte) error {
+	if _, err := p.dev.Control(zerousb.ControlOut|zerousb.ControlVendor|zerousb.ControlInterface, request, val, p.iface, data); err != nil {
+		return fmt.Errorf("serial: cp210x request 0x%02x failed: %w", request, err)
+	}
+	return nil
+}
+
+// Read receives data from the port.
+func (p *CP210x) Read(b []byte) (int, error) { return p.dev.Read(b) }
+
+// Write sends data to the port.
+func (p *CP210x) Write(b []byte) (int, error) { return p.dev.Write(b) }
+
+// SetMode configures the baud rate and line control of the port.
+func (p *CP210x) SetMode(mode Mode) error {
+	bits, err := mode.dataBits()
+	if err != nil {
+		return err
+	}
+	baud := make([]byte, 4)
+	binary.LittleEndian.PutUint32(baud, uint32(mode.BaudRate))
+	if err := p.control(cp210xSetBaudRate, 0, baud); err != nil {
+		return err
+	}
+	// Stop bits in bits 0-3, parity in bits 4-7 and word length in bits 8-15
+	return p.control(cp210xSetLineCtl, uint16(mode.StopBits)|uint16(mode.Parity)<<4|uint16(bits)<<8, nil)
+}
+
+// SetDTR drives the Data Terminal Ready line.
+func (p *CP210x) SetDTR(on bool) error {
+	if on {
+		return p.control(cp210xSetMHS, cp210xDTRMask|cp210xDTR, nil)
+	}
+	return p.control(cp210xSetMHS, cp210xDTRMask, nil)
+}
+
+// SetRTS drives the Request To Send line.
+func (p *CP210x) SetRTS(on bool) error {
+	if on {
+		return p.control(cp210xSetMHS, cp210xRTSMask|cp210xRTS, nil)
+	}
+	return p.control(cp210xSetMHS, cp210xRTSMask, nil)
+}
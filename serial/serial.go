@@ -0,0 +1,72 @@
+// Package serial drives common USB to UART bridges through zerousb: CDC-ACM
+// compliant devices, Silicon Labs CP210x and WCH CH340/CH341 chips. All of
+// them are exposed through the same Port interface.
+package serial
+
+import (
+	"fmt"
+	"io"
+)
+
+// Parity is the parity mode of a serial line.
+type Parity int
+
+// Parity modes supported by the bridges.
+const (
+	ParityNone Parity = iota
+	ParityOdd
+	ParityEven
+	ParityMark
+	ParitySpace
+)
+
+// StopBits is the number of stop bits of a serial line.
+type StopBits int
+
+// Stop bit modes supported by the bridges.
+const (
+	StopBits1 StopBits = iota
+	StopBits15
+	StopBits2
+)
+
+// Mode is the line configuration of a serial port.
+type Mode struct {
+	BaudRate int
+	DataBits int // 5 to 8, 0 defaults to 8
+	Parity   Parity
+	StopBits StopBits
+}
+
+// dataBits returns the configured data bits, validating the range.
+func (m Mode) dataBits() (int, error) {
+	switch {
+	case m.DataBits == 0:
+		return 8, nil
+	case m.DataBits < 5 || m.DataBits > 8:
+		return 0, fmt.Errorf("serial: unsupported data bits: %d", m.DataBits)
+	}
+	return m.DataBits, nil
+}
+
+// Port is a serial port on top of a USB bridge.
+type Port interface {
+	io.Reader
+	io.Writer
+
+	// SetMode configures the baud rate and framing of the line.
+	SetMode(mode Mode) error
+
+	// SetDTR drives the Data Terminal Ready line.
+	SetDTR(on bool) error
+
+	// SetRTS drives the Request To Send line.
+	SetRTS(on bool) error
+}
+
+// Transport is the subset of zerousb.Device needed to drive the bridges.
+type Transport interface {
+	Read(b []byte) (int, error)
+	Write(b []byte) (int, error)
+	Control(rType, request uint8, val, idx uint16, data []byte) (int, error)
+}
@@ -0,0 +1,32 @@
+package serial
+
+import "testing"
+
+// Ensure the bridges implement the common port interface.
+var (
+	_ Port = (*CDCACM)(nil)
+	_ Port = (*CP210x)(nil)
+	_ Port = (*CH34x)(nil)
+)
+
+// Tests the CH34x baud rate generator register values against the ones used
+// by the Linux driver.
+func TestCH34xDivisor(t *testing.T) {
+	tests := []struct {
+		baud int
+		want uint16
+	}{
+		{9600, 0xb282},
+		{115200, 0xcc83},
+	}
+	for _, tt := range tests {
+		have, err := ch34xDivisor(tt.baud)
+		if err != nil {
+			t.Errorf("baud %d: failed to encode: %v", tt.baud, err)
+			continue
+		}
+		if have != tt.want {
+			t.Errorf("baud %d: divisor mismatch: have %#04x, want %#04x", tt.baud, have, tt.want)
+		}
+	}
+}
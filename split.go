@@ -0,0 +1,75 @@
+package zerousb
+
+import (
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxPacketAlign is the largest max packet size of bulk endpoints. Splitting
+// reads at multiples of it keeps every chunk but the last on packet boundaries.
+const maxPacketAlign = 1024
+
+// usbfsMemoryPath is the usbcore parameter capping the memory of all transfers
+// submitted through usbfs at once, in megabytes.
+const usbfsMemoryPath = "/sys/module/usbcore/parameters/usbfs_memory_mb"
+
+// usbfsDefaultMemory is the usbfs cap of kernels not exposing the parameter.
+const usbfsDefaultMemory = 16 << 20
+
+var (
+	usbfsMemoryOnce  sync.Once
+	usbfsMemoryLimit uint64 // Cap of usbfs transfers in bytes, zero if unlimited
+)
+
+// usbfsMemory returns the cap of usbfs transfers in bytes, zero if unlimited.
+func usbfsMemory() uint64 {
+	usbfsMemoryOnce.Do(func() {
+		usbfsMemoryLimit = usbfsDefaultMemory
+
+		blob, err := os.ReadFile(usbfsMemoryPath)
+		if err != nil {
+			return
+		}
+		if mb, err := strconv.ParseUint(strings.TrimSpace(string(blob)), 10, 32); err == nil {
+			usbfsMemoryLimit = mb << 20
+		}
+	})
+	return usbfsMemoryLimit
+}
+
+// maxTransferSize returns the largest transfer a backend describing lengths up
+// to limit accepts at once, rounded down to whole packets. On Linux, usbfs caps
+// the transfers further, failing larger ones with ENOMEM.
+func maxTransferSize(limit uint64) int {
+	if runtime.GOOS == "linux" || runtime.GOOS == "android" {
+		if mem := usbfsMemory(); mem != 0 && mem < limit {
+			limit = mem
+		}
+	}
+	if limit > math.MaxInt {
+		limit = math.MaxInt
+	}
+	return int(limit &^ (maxPacketAlign - 1))
+}
+
+// splitTransfer moves b through consecutive transfers of at most limit bytes,
+// returning the combined byte count. It stops at the first failed or short
+// transfer, a short packet terminating reads early.
+func splitTransfer(b []byte, limit int, transfer func([]byte) (int, error)) (int, error) {
+	var done int
+	for {
+		chunk := b[done:]
+		if len(chunk) > limit {
+			chunk = chunk[:limit]
+		}
+		n, err := transfer(chunk)
+		done += n
+		if err != nil || n < len(chunk) || done == len(b) {
+			return done, err
+		}
+	}
+}
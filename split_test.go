@@ -0,0 +1,58 @@
+package zerousb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// Tests that transfers above the limit are split into consecutive chunks, and
+// that splitting stops at short or failed chunks with the combined count.
+func TestSplitTransfer(t *testing.T) {
+	errFail := errors.New("transfer failed")
+
+	tests := []struct {
+		size   int
+		limit  int
+		short  int // Index of the chunk coming back half full, -1 if none
+		fail   int // Index of the chunk failing, -1 if none
+		chunks []int
+		n      int
+	}{
+		{0, 4, -1, -1, []int{0}, 0},
+		{3, 4, -1, -1, []int{3}, 3},
+		{8, 4, -1, -1, []int{4, 4}, 8},
+		{10, 4, -1, -1, []int{4, 4, 2}, 10},
+		{10, 4, 1, -1, []int{4, 4}, 6},
+		{10, 4, -1, 1, []int{4, 4}, 6},
+	}
+	for i, tt := range tests {
+		var chunks []int
+		n, err := splitTransfer(make([]byte, tt.size), tt.limit, func(chunk []byte) (int, error) {
+			chunks = append(chunks, len(chunk))
+			switch len(chunks) - 1 {
+			case tt.short:
+				return len(chunk) / 2, nil
+			case tt.fail:
+				return len(chunk) / 2, errFail
+			}
+			return len(chunk), nil
+		})
+		if !reflect.DeepEqual(chunks, tt.chunks) {
+			t.Errorf("test %d: chunk mismatch: have %v, want %v", i, chunks, tt.chunks)
+		}
+		if n != tt.n {
+			t.Errorf("test %d: count mismatch: have %d, want %d", i, n, tt.n)
+		}
+		if (err != nil) != (tt.fail >= 0) {
+			t.Errorf("test %d: failure mismatch: have %v, want failure %v", i, err, tt.fail >= 0)
+		}
+	}
+}
+
+// Tests that transfer limits are rounded down to whole packets.
+func TestMaxTransferSize(t *testing.T) {
+	if limit := maxTransferSize(1<<20 + 100); limit%maxPacketAlign != 0 || limit > 1<<20 {
+		t.Errorf("limit not rounded to packets: %d", limit)
+	}
+}
@@ -0,0 +1,128 @@
+package zerousb
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Standard requests of the USB spec, issued as control requests by backends
+// without a native call for them.
+const (
+	requestGetStatus        = 0x00
+	requestClearFeature     = 0x01
+	requestSetFeature       = 0x03
+	requestGetConfiguration = 0x08
+)
+
+// Standard feature selectors of SET_FEATURE and CLEAR_FEATURE.
+const (
+	featureEndpointHalt       = 0x00
+	featureDeviceRemoteWakeup = 0x01
+)
+
+// Status bits of the GET_STATUS responses.
+const (
+	statusSelfPowered       = 0x01 // Device is self powered
+	statusRemoteWakeup      = 0x02 // Device remote wakeup is enabled
+	statusRemoteWakeCapable = 0x01 // Function supports remote wake (SuperSpeed)
+	statusFunctionWakeup    = 0x02 // Function remote wake is enabled (SuperSpeed)
+	statusHalted            = 0x01 // Endpoint is halted
+)
+
+// DeviceStatus is the decoded GET_STATUS response of a device.
+type DeviceStatus struct {
+	SelfPowered  bool // Whether the device currently runs from its own supply
+	RemoteWakeup bool // Whether the device is armed to wake the host
+}
+
+// InterfaceStatus is the decoded GET_STATUS response of an interface. Only
+// SuperSpeed devices report function remote wake, the others answer zeros.
+type InterfaceStatus struct {
+	RemoteWakeCapable bool // Whether the function supports remote wake
+	RemoteWakeup      bool // Whether the function is armed to wake the host
+}
+
+// EndpointStatus is the decoded GET_STATUS response of an endpoint.
+type EndpointStatus struct {
+	Halted bool // Whether the endpoint is halted, cleared by ClearHalt
+}
+
+// controlFunc issues a control request on a device.
+type controlFunc func(rType, request uint8, val, idx uint16, data []byte) (int, error)
+
+// getConfiguration issues a standard GET_CONFIGURATION request through a
+// control primitive.
+func getConfiguration(control controlFunc) (int, error) {
+	var buf [1]byte
+	n, err := control(ControlIn|ControlDevice, requestGetConfiguration, 0, 0, buf[:])
+	if err != nil {
+		return 0, fmt.Errorf("failed to get configuration: %w", err)
+	}
+	if n < len(buf) {
+		return 0, fmt.Errorf("failed to get configuration: short response of %d bytes", n)
+	}
+	if buf[0] == 0 {
+		return 0, ErrNotConfigured
+	}
+	return int(buf[0]), nil
+}
+
+// getStatus issues a standard GET_STATUS request to a recipient through a
+// control primitive.
+func getStatus(control controlFunc, recipient uint8, index uint16) (uint16, error) {
+	var buf [2]byte
+	n, err := control(ControlIn|recipient, requestGetStatus, 0, index, buf[:])
+	if err != nil {
+		return 0, fmt.Errorf("failed to get status: %w", err)
+	}
+	if n < len(buf) {
+		return 0, fmt.Errorf("failed to get status: short response of %d bytes", n)
+	}
+	return binary.LittleEndian.Uint16(buf[:]), nil
+}
+
+// setRemoteWakeup arms or disarms the remote wakeup of a device through a
+// control primitive.
+func setRemoteWakeup(control controlFunc, enable bool) error {
+	request := uint8(requestClearFeature)
+	if enable {
+		request = requestSetFeature
+	}
+	if _, err := control(ControlOut|ControlDevice, request, featureDeviceRemoteWakeup, 0, nil); err != nil {
+		return fmt.Errorf("failed to set remote wakeup: %w", err)
+	}
+	return nil
+}
+
+// readDeviceStatus retrieves and decodes the status of a device.
+func readDeviceStatus(control controlFunc) (DeviceStatus, error) {
+	status, err := getStatus(control, ControlDevice, 0)
+	if err != nil {
+		return DeviceStatus{}, err
+	}
+	return DeviceStatus{
+		SelfPowered:  status&statusSelfPowered != 0,
+		RemoteWakeup: status&statusRemoteWakeup != 0,
+	}, nil
+}
+
+// readInterfaceStatus retrieves and decodes the status of an interface.
+func readInterfaceStatus(control controlFunc, iface int) (InterfaceStatus, error) {
+	status, err := getStatus(control, ControlInterface, uint16(iface))
+	if err != nil {
+		return InterfaceStatus{}, err
+	}
+	return InterfaceStatus{
+		RemoteWakeCapable: status&statusRemoteWakeCapable != 0,
+		RemoteWakeup:      status&statusFunctionWakeup != 0,
+	}, nil
+}
+
+// readEndpointStatus retrieves and decodes the status of an endpoint.
+func readEndpointStatus(control controlFunc, endpoint uint8) (EndpointStatus, error) {
+	status, err := getStatus(control, ControlEndpoint, uint16(endpoint))
+	if err != nil {
+		return EndpointStatus{}, err
+	}
+	return EndpointStatus{Halted: status&statusHalted != 0}, nil
+}
@@ -0,0 +1,85 @@
+package zerousb
+
+import (
+	"errors"
+	"testing"
+)
+
+// Tests that GET_CONFIGURATION responses are decoded, reporting unconfigured
+// devices via a dedicated error.
+func TestGetConfiguration(t *testing.T) {
+	tests := []struct {
+		reply  []byte
+		config int
+		err    error
+	}{
+		{[]byte{1}, 1, nil},
+		{[]byte{0}, 0, ErrNotConfigured},
+		{nil, 0, errors.New("short response")},
+	}
+	for i, tt := range tests {
+		config, err := getConfiguration(func(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+			if rType != ControlIn|ControlDevice || request != requestGetConfiguration {
+				t.Fatalf("test %d: request mismatch: have %#x/%#x", i, rType, request)
+			}
+			return copy(data, tt.reply), nil
+		})
+		if config != tt.config {
+			t.Errorf("test %d: configuration mismatch: have %d, want %d", i, config, tt.config)
+		}
+		if (err != nil) != (tt.err != nil) || (tt.err == ErrNotConfigured && err != ErrNotConfigured) {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
+
+// Tests that GET_STATUS requests are addressed to the right recipient and their
+// status bits decoded.
+func TestGetStatus(t *testing.T) {
+	var (
+		rType uint8
+		index uint16
+	)
+	control := func(status uint16) controlFunc {
+		return func(r, request uint8, val, idx uint16, data []byte) (int, error) {
+			if request != requestGetStatus {
+				t.Fatalf("request mismatch: have %#x, want %#x", request, requestGetStatus)
+			}
+			rType, index = r, idx
+			data[0], data[1] = byte(status), byte(status>>8)
+			return 2, nil
+		}
+	}
+	dev, err := readDeviceStatus(control(0x0003))
+	if err != nil || rType != ControlIn|ControlDevice || dev != (DeviceStatus{SelfPowered: true, RemoteWakeup: true}) {
+		t.Errorf("device status mismatch: have %+v (type %#x), %v", dev, rType, err)
+	}
+	iface, err := readInterfaceStatus(control(0x0001), 2)
+	if err != nil || rType != ControlIn|ControlInterface || index != 2 || iface != (InterfaceStatus{RemoteWakeCapable: true}) {
+		t.Errorf("interface status mismatch: have %+v (type %#x, index %d), %v", iface, rType, index, err)
+	}
+	end, err := readEndpointStatus(control(0x0001), 0x81)
+	if err != nil || rType != ControlIn|ControlEndpoint || index != 0x81 || !end.Halted {
+		t.Errorf("endpoint status mismatch: have %+v (type %#x, index %#x), %v", end, rType, index, err)
+	}
+}
+
+// Tests that remote wakeup is armed via SET_FEATURE and disarmed via
+// CLEAR_FEATURE on the device.
+func TestSetRemoteWakeup(t *testing.T) {
+	for _, enable := range []bool{true, false} {
+		want := uint8(requestClearFeature)
+		if enable {
+			want = requestSetFeature
+		}
+		err := setRemoteWakeup(func(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+			if rType != ControlOut|ControlDevice || request != want || val != featureDeviceRemoteWakeup || idx != 0 {
+				t.Errorf("enable %v: request mismatch: have %#x/%#x/%d/%d", enable, rType, request, val, idx)
+			}
+			return 0, nil
+		}, enable)
+		if err != nil {
+			t.Errorf("enable %v: failed to set remote wakeup: %v", enable, err)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package zerousb
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrStreamClosed is returned by operations on a closed stream.
+var ErrStreamClosed = errors.New("usb: stream closed")
+
+// streamSource delivers the completed transfers of a read stream in order.
+type streamSource interface {
+	// next blocks until the oldest queued transfer completes and returns its
+	// data, which remains valid until done is called. Failed transfers return
+	// the data received before the failure along with the error.
+	next() ([]byte, error)
+
+	// done requeues the transfer last returned by next.
+	done() error
+
+	// close cancels the queued transfers and releases their buffers.
+	close()
+}
+
+// streamReader is implemented by devices whose backend can keep several
+// transfers queued on the IN endpoint at once.
+type streamReader interface {
+	newReadStream(size int, count int) (streamSource, error)
+}
+
+// streamSink queues the filled buffers of a write stream in order.
+type streamSink interface {
+	// buffer returns the buffer of the next transfer, blocking until the oldest
+	// queued one completes if all of them are in flight.
+	buffer() ([]byte, error)
+
+	// submit queues the transfer of the buffer last returned by buffer, filled
+	// with n bytes.
+	submit(n int) error
+
+	// flush waits for all queued transfers to complete.
+	flush() error
+
+	// close cancels the queued transfers and releases their buffers.
+	close()
+}
+
+// streamWriter is implemented by devices whose backend can keep several
+// transfers queued on the OUT endpoint at once.
+type streamWriter interface {
+	newWriteStream(size int, count int) (streamSink, error)
+}
+
+// ReadStream continuously reads from the IN endpoint of a device, keeping a
+// number of transfers queued so that no data is lost between calls to Read.
+// It is meant for high rate bulk devices (e.g. SDRs and logic analyzers).
+//
+// The stream owns the IN endpoint until closed, and must be closed before the
+// device itself. Once a transfer fails, the stream stops and keeps returning
+// the error, after delivering any data the failed transfer did receive (e.g.
+// a bulk read timing out midway).
+type ReadStream struct {
+	src     streamSource
+	pending []byte // Unconsumed data of the current transfer
+	started bool   // Whether the current transfer came from the source
+	err     error  // Sticky error of a failed transfer
+	closed  bool
+	lock    sync.Mutex
+}
+
+// NewReadStream starts a stream of count transfers of size bytes each on the
+// IN endpoint of the device. Backends without asynchronous transfers fall back
+// to a single read in flight, buffering up to count transfers ahead.
+func NewReadStream(dev Device, size int, count int) (*ReadStream, error) {
+	if size <= 0 || count <= 0 {
+		return nil, errors.New("usb: stream size and count must be positive")
+	}
+	var (
+		src streamSource
+		err error
+	)
+	if reader, ok := dev.(streamReader); ok {
+		src, err = reader.newReadStream(size, count)
+	} else {
+		src = newReadAhead(dev, size, count)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ReadStream{src: src}, nil
+}
+
+// Read copies the data of completed transfers into b, blocking until at least
+// one transfer is completed if none is buffered.
+func (s *ReadStream) Read(b []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return 0, ErrStreamClosed
+	}
+	for len(s.pending) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.started {
+			s.started = false
+			if s.err = s.src.done(); s.err != nil {
+				return 0, s.err
+			}
+		}
+		data, err := s.src.next()
+		if err != nil {
+			// Hand out the partial data of the failed transfer first, it isn't
+			// requeued as the stream stops
+			s.err, s.pending = err, data
+			if len(data) == 0 {
+				return 0, err
+			}
+			break
+		}
+		s.pending, s.started = data, true
+	}
+	n := copy(b, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// Close cancels the queued transfers and releases the IN endpoint. Data not
+// yet read is discarded.
+func (s *ReadStream) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.pending = nil
+	s.src.close()
+	return nil
+}
+
+// WriteStream buffers data for the OUT endpoint of a device into transfers of
+// a fixed size, keeping a number of them in flight to saturate high speed bulk
+// endpoints. Writes block while all transfers are queued.
+//
+// Like a bufio.Writer, errors of failed transfers are reported by subsequent
+// calls to Write, Flush or Close. The stream owns the OUT endpoint until closed,
+// and must be closed before the device itself.
+type WriteStream struct {
+	sink   streamSink
+	buf    []byte // Buffer of the transfer being filled, nil if none
+	filled int    // Number of bytes in buf
+	err    error  // Sticky error of a failed transfer
+	closed bool
+	lock   sync.Mutex
+}
+
+// NewWriteStream starts a stream of up to count queued transfers of size bytes
+// each on the OUT endpoint of the device. Backends without asynchronous
+// transfers fall back to a single write in flight, buffering up to count
+// transfers behind it.
+func NewWriteStream(dev Device, size int, count int) (*WriteStream, error) {
+	if size <= 0 || count <= 0 {
+		return nil, errors.New("usb: stream size and count must be positive")
+	}
+	var (
+		sink streamSink
+		err  error
+	)
+	if writer, ok := dev.(streamWriter); ok {
+		sink, err = writer.newWriteStream(size, count)
+	} else {
+		sink = newWriteBehind(dev, size, count)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &WriteStream{sink: sink}, nil
+}
+
+// Write copies b into the transfer buffers, queueing each one as it fills up.
+func (s *WriteStream) Write(b []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return 0, ErrStreamClosed
+	}
+	var n int
+	for len(b) > 0 {
+		if s.err != nil {
+			return n, s.err
+		}
+		if s.buf == nil {
+			if s.buf, s.err = s.sink.buffer(); s.err != nil {
+				return n, s.err
+			}
+		}
+		copied := copy(s.buf[s.filled:], b)
+		s.filled += copied
+		b, n = b[copied:], n+copied
+
+		if s.filled == len(s.buf) {
+			s.err = s.submit()
+		}
+	}
+	return n, s.err
+}
+
+// submit queues the transfer being filled.
+func (s *WriteStream) submit() error {
+	filled := s.filled
+	s.buf, s.filled = nil, 0
+	return s.sink.submit(filled)
+}
+
+// Flush queues the partially filled transfer, if any, and waits until all
+// queued transfers complete.
+func (s *WriteStream) Flush() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return ErrStreamClosed
+	}
+	return s.flush()
+}
+
+// flush is the lock-free variant of Flush.
+func (s *WriteStream) flush() error {
+	if s.err == nil && s.filled > 0 {
+		s.err = s.submit()
+	}
+	if err := s.sink.flush(); s.err == nil {
+		s.err = err
+	}
+	return s.err
+}
+
+// Close flushes the stream and releases the OUT endpoint, returning the error
+// of any failed transfer.
+func (s *WriteStream) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	err := s.flush()
+
+	s.closed = true
+	s.sink.close()
+	return err
+}
+
+// readResult is a transfer completed by the read ahead goroutine.
+type readResult struct {
+	data []byte
+	err  error
+}
+
+// readAhead is the stream source of backends without asynchronous transfers,
+// reading sequentially on a goroutine into a fixed set of buffers.
+type readAhead struct {
+	dev    Device
+	size   int
+	free   chan []byte     // Buffers ready to be read into
+	filled chan readResult // Completed reads in order
+	stop   chan struct{}   // Closed to terminate the goroutine
+	last   []byte          // Buffer last returned by next
+}
+
+// newReadAhead allocates the buffers of a read ahead source and starts reading.
+func newReadAhead(dev Device, size int, count int) *readAhead {
+	r := &readAhead{
+		dev:    dev,
+		size:   size,
+		free:   make(chan []byte, count),
+		filled: make(chan readResult, count),
+		stop:   make(chan struct{}),
+	}
+	for i := 0; i < count; i++ {
+		r.free <- make([]byte, size)
+	}
+	go r.loop()
+	return r
+}
+
+// loop reads into the free buffers until stopped or a read fails.
+func (r *readAhead) loop() {
+	for {
+		var buf []byte
+		select {
+		case buf = <-r.free:
+		case <-r.stop:
+			return
+		}
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+		n, err := r.dev.Read(buf)
+		r.filled <- readResult{data: buf[:n], err: err}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// next waits for the oldest read to complete.
+func (r *readAhead) next() ([]byte, error) {
+	res := <-r.filled
+	r.last = res.data
+	return res.data, res.err
+}
+
+// done hands the last returned buffer back to the reader goroutine.
+func (r *readAhead) done() error {
+	r.free <- r.last[:r.size]
+	return nil
+}
+
+// close stops the reader goroutine. A read in flight is not interrupted, its
+// result is discarded when it completes.
+func (r *readAhead) close() {
+	close(r.stop)
+}
+
+// writeBehind is the stream sink of backends without asynchronous transfers,
+// writing the queued buffers sequentially on a goroutine.
+type writeBehind struct {
+	dev    Device
+	size   int
+	free   chan []byte    // Buffers ready to be filled
+	queue  chan []byte    // Filled buffers in order, closed to terminate the goroutine
+	failed chan struct{}  // Closed when a write failed
+	err    error          // Error of the failed write, set before closing failed
+	last   []byte         // Buffer last returned by buffer
+	queued sync.WaitGroup // Buffers queued and not yet written
+}
+
+// newWriteBehind allocates the buffers of a write behind sink and starts the
+// writer goroutine.
+func newWriteBehind(dev Device, size int, count int) *writeBehind {
+	w := &writeBehind{
+		dev:    dev,
+		size:   size,
+		free:   make(chan []byte, count),
+		queue:  make(chan []byte, count),
+		failed: make(chan struct{}),
+	}
+	for i := 0; i < count; i++ {
+		w.free <- make([]byte, size)
+	}
+	go w.loop()
+	return w
+}
+
+// loop writes the queued buffers until the queue is closed. Once a write
+// failed, the remaining buffers are dropped.
+func (w *writeBehind) loop() {
+	for buf := range w.queue {
+		select {
+		case <-w.failed:
+		default:
+			if _, err := w.dev.Write(buf); err != nil {
+				w.err = err
+				close(w.failed)
+			}
+		}
+		w.free <- buf[:w.size]
+		w.queued.Done()
+	}
+}
+
+// buffer waits for a free buffer, or the failure of a write.
+func (w *writeBehind) buffer() ([]byte, error) {
+	select {
+	case w.last = <-w.free:
+		return w.last, nil
+	case <-w.failed:
+		return nil, w.err
+	}
+}
+
+// submit hands the last returned buffer to the writer goroutine.
+func (w *writeBehind) submit(n int) error {
+	w.queued.Add(1)
+	w.queue <- w.last[:n]
+	return nil
+}
+
+// flush waits for the writer goroutine to drain the queue.
+func (w *writeBehind) flush() error {
+	w.queued.Wait()
+	select {
+	case <-w.failed:
+		return w.err
+	default:
+		return nil
+	}
+}
+
+// close terminates the writer goroutine once the queue is drained.
+func (w *writeBehind) close() {
+	close(w.queue)
+}
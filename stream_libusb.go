@@ -0,0 +1,394 @@
+//go:build (freebsd && cgo) || (linux && cgo) || (openbsd && cgo) || (netbsd && cgo) || (darwin && !ios && cgo && !iokit) || (windows && cgo && !winusb)
+
+package zerousb
+
+/*
+	#include <libusb.h>
+
+	void fill_transfer(struct libusb_transfer* transfer, libusb_device_handle* handle, unsigned char endpoint,
+		unsigned char type, unsigned char* buffer, int length, intptr_t id, unsigned int timeout);
+*/
+import "C"
+
+import (
+	"sync"
+	"unsafe"
+)
+
+var (
+	transferLock   sync.Mutex                    // Protects the transfer registry
+	transferDone   = make(map[int]chan struct{}) // Completion channels by transfer id
+	transferNextID int                           // Next transfer id to hand out
+)
+
+// libusbTransfer is an asynchronous transfer with a pooled buffer outside of Go
+// memory, so it can stay queued while Go code runs.
+type libusbTransfer struct {
+	xfer     *C.struct_libusb_transfer
+	pool     *bufferPool
+	block    *poolBlock // Pooled buffer of the transfer
+	buf      *C.uchar
+	id       int           // Id the completion callback reports the transfer under
+	done     chan struct{} // Signalled by the completion callback
+	size     int
+	inflight bool // Whether the transfer is submitted and not yet reaped
+}
+
+// newTransfer allocates an asynchronous transfer on an endpoint of the device.
+func (dev *libusbDevice) newTransfer(endpoint uint8, kind uint8, size int, timeout int) (*libusbTransfer, error) {
+	t, err := dev.allocTransfer(0, size)
+	if err != nil {
+		return nil, err
+	}
+	C.fill_transfer(t.xfer, dev.handle, C.uchar(endpoint), C.uchar(kind), t.buf, C.int(size), C.intptr_t(t.id), C.uint(timeout))
+	return t, nil
+}
+
+// allocTransfer allocates an unfilled transfer with room for the given number
+// of isochronous packets and a pooled buffer of size bytes.
+func (dev *libusbDevice) allocTransfer(packets int, size int) (*libusbTransfer, error) {
+	xfer := C.libusb_alloc_transfer(C.int(packets))
+	if xfer == nil {
+		return nil, libusbErrNoMem
+	}
+	block := dev.pool.get(size)
+	t := &libusbTransfer{
+		xfer:  xfer,
+		pool:  dev.pool,
+		block: block,
+		buf:   block.ptr,
+		done:  make(chan struct{}, 1),
+		size:  size,
+	}
+	transferLock.Lock()
+	t.id = transferNextID
+	transferNextID++
+	transferDone[t.id] = t.done
+	transferLock.Unlock()
+
+	return t, nil
+}
+
+// submit queues the transfer with libusb.
+func (t *libusbTransfer) submit() error {
+	if err := fromLibusbErrno(C.libusb_submit_transfer(t.xfer)); err != nil {
+		return err
+	}
+	t.inflight = true
+	return nil
+}
+
+// wait blocks until the event loop of the context reports the completion of
+// the transfer, returning the data transferred. The data aliases the pooled
+// buffer and is only valid until the next submission.
+func (t *libusbTransfer) wait() ([]byte, error) {
+	<-t.done
+	return t.result()
+}
+
+// poll is the non-blocking variant of wait, reporting whether the transfer
+// completed.
+func (t *libusbTransfer) poll() ([]byte, bool, error) {
+	select {
+	case <-t.done:
+		data, err := t.result()
+		return data, true, err
+	default:
+		return nil, false, nil
+	}
+}
+
+// result reaps the completed transfer, returning the data transferred.
+func (t *libusbTransfer) result() ([]byte, error) {
+	t.inflight = false
+
+	n := int(t.xfer.actual_length)
+	data := unsafe.Slice((*byte)(unsafe.Pointer(t.buf)), t.size)[:n]
+
+	return data, transferStatus(t.xfer.status)
+}
+
+// transferStatus converts the status of a transfer or isochronous packet into
+// the error it represents, nil if completed.
+func transferStatus(status C.enum_libusb_transfer_status) error {
+	switch status {
+	case C.LIBUSB_TRANSFER_COMPLETED:
+		return nil
+	case C.LIBUSB_TRANSFER_TIMED_OUT:
+		return libusbErrTimeout
+	case C.LIBUSB_TRANSFER_STALL:
+		return libusbErrPipe
+	case C.LIBUSB_TRANSFER_NO_DEVICE:
+		return libusbErrNoDevice
+	case C.LIBUSB_TRANSFER_OVERFLOW:
+		return libusbErrOverflow
+	case C.LIBUSB_TRANSFER_CANCELLED:
+		return libusbErrInterrupted
+	default:
+		return libusbErrIO
+	}
+}
+
+// cancel aborts the transfer if it's queued, without waiting for it.
+func (t *libusbTransfer) cancel() {
+	if t.inflight {
+		C.libusb_cancel_transfer(t.xfer)
+	}
+}
+
+// free releases the transfer, which must not be queued.
+func (t *libusbTransfer) free() {
+	C.libusb_free_transfer(t.xfer)
+	t.pool.put(t.block)
+
+	transferLock.Lock()
+	delete(transferDone, t.id)
+	transferLock.Unlock()
+}
+
+// canceler is a stream of transfers queued on a device, whose transfers can be
+// canceled to unblock its user when the device is closed.
+type canceler interface {
+	cancel()
+}
+
+// startStream registers a stream whose transfers reference the handle, unless
+// the device is being closed.
+func (dev *libusbDevice) startStream(s canceler) error {
+	dev.streamLock.Lock()
+	defer dev.streamLock.Unlock()
+
+	if dev.closing {
+		return dev.closedErr()
+	}
+	if dev.streams == nil {
+		dev.streams = make(map[canceler]bool)
+	}
+	dev.streams[s] = true
+	return nil
+}
+
+// stopStream cancels the transfers of a stream being closed, after which Close
+// no longer touches them as they're about to be freed.
+func (dev *libusbDevice) stopStream(s canceler) {
+	dev.streamLock.Lock()
+	defer dev.streamLock.Unlock()
+
+	s.cancel()
+	dev.streams[s] = false
+}
+
+// endStream unregisters a stream once its transfers are reaped and freed,
+// completing a Close deferred until the last one.
+func (dev *libusbDevice) endStream(s canceler) {
+	dev.streamLock.Lock()
+	delete(dev.streams, s)
+	deferred := dev.closing && len(dev.streams) == 0
+	dev.streamLock.Unlock()
+
+	if deferred {
+		dev.Close()
+	}
+}
+
+// deferClose cancels the transfers of the open streams, reporting whether any
+// are open. Releasing the handle or stopping the event loop under them would
+// leave them waiting forever or completing into freed memory, so Close is
+// deferred until they're closed.
+func (dev *libusbDevice) deferClose() bool {
+	dev.streamLock.Lock()
+	defer dev.streamLock.Unlock()
+
+	if len(dev.streams) == 0 {
+		dev.closing = false
+		return false
+	}
+	for s, cancelable := range dev.streams {
+		if cancelable {
+			s.cancel()
+		}
+	}
+	dev.closing = true
+	return true
+}
+
+// cancelAll aborts the queued transfers of a stream, without waiting for them.
+// The queued state is tracked by the goroutine using the stream, so all are
+// canceled, libusb ignoring the ones not queued.
+func cancelAll(transfers []*libusbTransfer) {
+	for _, t := range transfers {
+		C.libusb_cancel_transfer(t.xfer)
+	}
+}
+
+// libusbReadStream keeps a ring of transfers queued on the IN endpoint.
+type libusbReadStream struct {
+	dev       *libusbDevice
+	transfers []*libusbTransfer // Ring in submission order
+	head      int               // Oldest queued transfer
+}
+
+// newReadStream queues count transfers on the IN endpoint. The endpoint is
+// locked for reads until the stream is closed.
+func (dev *libusbDevice) newReadStream(size int, count int) (streamSource, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+
+	if dev.handle == nil {
+		return nil, dev.closedErr()
+	}
+	s := &libusbReadStream{dev: dev}
+	if err := dev.startStream(s); err != nil {
+		return nil, err
+	}
+	dev.readLock.Lock()
+
+	for i := 0; i < count; i++ {
+		t, err := dev.newTransfer(*dev.libusbReader, *dev.readerTransferType, size, dev.readTimeout)
+		if err == nil {
+			s.transfers = append(s.transfers, t)
+			err = t.submit()
+		}
+		if err != nil {
+			s.close()
+			return nil, newTransferError(*dev.libusbReader, 0, err)
+		}
+	}
+	return s, nil
+}
+
+// next waits for the oldest queued transfer to complete.
+func (s *libusbReadStream) next() ([]byte, error) {
+	data, err := s.transfers[s.head].wait()
+	if err != nil {
+		return data, newTransferError(*s.dev.libusbReader, len(data), err)
+	}
+	return data, nil
+}
+
+// done requeues the oldest transfer, making the following one the oldest.
+func (s *libusbReadStream) done() error {
+	if err := s.transfers[s.head].submit(); err != nil {
+		return newTransferError(*s.dev.libusbReader, 0, err)
+	}
+	s.head = (s.head + 1) % len(s.transfers)
+	return nil
+}
+
+// cancel aborts the queued transfers, failing the pending next.
+func (s *libusbReadStream) cancel() {
+	cancelAll(s.transfers)
+}
+
+// close cancels and reaps all queued transfers, then unlocks the endpoint.
+func (s *libusbReadStream) close() {
+	s.dev.stopStream(s)
+	for _, t := range s.transfers {
+		if t.inflight {
+			t.wait()
+		}
+		t.free()
+	}
+	s.dev.readLock.Unlock()
+	s.dev.endStream(s)
+}
+
+// libusbWriteStream keeps a ring of transfers queued on the OUT endpoint.
+type libusbWriteStream struct {
+	dev       *libusbDevice
+	transfers []*libusbTransfer // Ring in submission order
+	next      int               // Transfer to fill next, the oldest one if all are queued
+}
+
+// newWriteStream allocates count transfers for the OUT endpoint. The endpoint
+// is locked for writes until the stream is closed.
+func (dev *libusbDevice) newWriteStream(size int, count int) (streamSink, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+
+	if dev.handle == nil {
+		return nil, dev.closedErr()
+	}
+	s := &libusbWriteStream{dev: dev}
+	if err := dev.startStream(s); err != nil {
+		return nil, err
+	}
+	dev.writeLock.Lock()
+
+	for i := 0; i < count; i++ {
+		t, err := dev.newTransfer(*dev.libusbWriter, *dev.writerTransferType, size, dev.writeTimeout)
+		if err != nil {
+			s.close()
+			return nil, newTransferError(*dev.libusbWriter, 0, err)
+		}
+		s.transfers = append(s.transfers, t)
+	}
+	return s, nil
+}
+
+// buffer returns the buffer of the next transfer, reaping it first if queued.
+func (s *libusbWriteStream) buffer() ([]byte, error) {
+	t := s.transfers[s.next]
+	if t.inflight {
+		if data, err := t.wait(); err != nil {
+			return nil, newTransferError(*s.dev.libusbWriter, len(data), err)
+		}
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(t.buf)), t.size), nil
+}
+
+// submit queues the next transfer with the first n bytes of its buffer.
+func (s *libusbWriteStream) submit(n int) error {
+	t := s.transfers[s.next]
+	t.xfer.length = C.int(n)
+	if err := t.submit(); err != nil {
+		return newTransferError(*s.dev.libusbWriter, 0, err)
+	}
+	s.next = (s.next + 1) % len(s.transfers)
+	return nil
+}
+
+// flush reaps all queued transfers in submission order, returning the first
+// error encountered.
+func (s *libusbWriteStream) flush() error {
+	var failure error
+	for i := range s.transfers {
+		t := s.transfers[(s.next+i)%len(s.transfers)]
+		if !t.inflight {
+			continue
+		}
+		if data, err := t.wait(); err != nil && failure == nil {
+			failure = newTransferError(*s.dev.libusbWriter, len(data), err)
+		}
+	}
+	return failure
+}
+
+// cancel aborts the queued transfers, failing the pending buffer or flush.
+func (s *libusbWriteStream) cancel() {
+	cancelAll(s.transfers)
+}
+
+// close cancels and reaps all queued transfers, then unlocks the endpoint.
+func (s *libusbWriteStream) close() {
+	s.dev.stopStream(s)
+	for _, t := range s.transfers {
+		if t.inflight {
+			t.wait()
+		}
+		t.free()
+	}
+	s.dev.writeLock.Unlock()
+	s.dev.endStream(s)
+}
+
+//export goTransferCallback
+func goTransferCallback(id C.intptr_t) {
+	transferLock.Lock()
+	done, ok := transferDone[int(id)]
+	transferLock.Unlock()
+
+	if ok {
+		done <- struct{}{}
+	}
+}
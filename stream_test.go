@@ -0,0 +1,127 @@
+package zerousb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// chunkDevice is a device whose reads return the given chunks in order, then
+// the tail along with the given error. Methods other than Read are not
+// implemented.
+type chunkDevice struct {
+	Device
+	chunks [][]byte
+	tail   []byte
+	err    error
+}
+
+func (dev *chunkDevice) Read(b []byte) (int, error) {
+	if len(dev.chunks) == 0 {
+		n := copy(b, dev.tail)
+		dev.tail = nil
+		return n, dev.err
+	}
+	n := copy(b, dev.chunks[0])
+	dev.chunks = dev.chunks[1:]
+	return n, nil
+}
+
+// Tests that the read ahead stream delivers transfers in order, and stops at
+// the first failed read after delivering its partial data.
+func TestReadStreamFallback(t *testing.T) {
+	errFailed := errors.New("failed")
+	dev := &chunkDevice{
+		chunks: [][]byte{[]byte("hello "), {}, []byte("stream"), []byte("ing")},
+		tail:   []byte("!"),
+		err:    errFailed,
+	}
+	stream, err := NewReadStream(dev, 8, 2)
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	defer stream.Close()
+
+	var out bytes.Buffer
+	buf := make([]byte, 4)
+	for {
+		n, err := stream.Read(buf)
+		out.Write(buf[:n])
+		if err != nil {
+			if err != errFailed {
+				t.Errorf("stream error mismatch: have %v, want %v", err, errFailed)
+			}
+			break
+		}
+	}
+	if out.String() != "hello streaming!" {
+		t.Errorf("stream data mismatch: have %q, want %q", out.String(), "hello streaming!")
+	}
+	if _, err := stream.Read(buf); err != errFailed {
+		t.Errorf("sticky error mismatch: have %v, want %v", err, errFailed)
+	}
+	stream.Close()
+	if _, err := stream.Read(buf); err != ErrStreamClosed {
+		t.Errorf("closed stream error mismatch: have %v, want %v", err, ErrStreamClosed)
+	}
+	var _ io.ReadCloser = stream
+}
+
+// recordDevice is a device recording its writes, failing once limit writes
+// were made. Methods other than Write are not implemented.
+type recordDevice struct {
+	Device
+	writes [][]byte
+	limit  int
+	err    error
+}
+
+func (dev *recordDevice) Write(b []byte) (int, error) {
+	if len(dev.writes) == dev.limit {
+		return 0, dev.err
+	}
+	dev.writes = append(dev.writes, append([]byte{}, b...))
+	return len(b), nil
+}
+
+// Tests that the write behind stream splits data into transfers of the stream
+// size in order, and reports failed writes on subsequent calls.
+func TestWriteStreamFallback(t *testing.T) {
+	dev := &recordDevice{limit: 10}
+	stream, err := NewWriteStream(dev, 4, 2)
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	if n, err := stream.Write([]byte("hello streaming")); n != 15 || err != nil {
+		t.Fatalf("write mismatch: have %d, %v, want 15, nil", n, err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatalf("failed to close stream: %v", err)
+	}
+	want := []string{"hell", "o st", "ream", "ing"}
+	if len(dev.writes) != len(want) {
+		t.Fatalf("transfer count mismatch: have %q, want %q", dev.writes, want)
+	}
+	for i, w := range want {
+		if string(dev.writes[i]) != w {
+			t.Errorf("transfer %d mismatch: have %q, want %q", i, dev.writes[i], w)
+		}
+	}
+	if _, err := stream.Write([]byte("x")); err != ErrStreamClosed {
+		t.Errorf("closed stream error mismatch: have %v, want %v", err, ErrStreamClosed)
+	}
+
+	errFailed := errors.New("failed")
+	dev = &recordDevice{limit: 1, err: errFailed}
+	if stream, err = NewWriteStream(dev, 4, 2); err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	stream.Write([]byte("hello streaming"))
+	if err := stream.Flush(); err != errFailed {
+		t.Errorf("flush error mismatch: have %v, want %v", err, errFailed)
+	}
+	if err := stream.Close(); err != errFailed {
+		t.Errorf("close error mismatch: have %v, want %v", err, errFailed)
+	}
+}
@@ -0,0 +1,74 @@
+//go:build !((freebsd && cgo) || (linux && cgo) || (openbsd && cgo) || (netbsd && cgo) || (darwin && !ios && (cgo || iokit)) || (windows && (cgo || winusb)) || (js && wasm) || (!cgo && (amd64 || arm64) && (linux || (darwin && !ios && !iokit))))
+
+package zerousb
+
+// getAllDevices is unsupported without a backend, causing enumeration to fail
+// at runtime instead of breaking the build.
+func getAllDevices(match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+// listDevices is unsupported without a backend.
+func listDevices(match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+// describeInterfaces is unsupported without a backend.
+func describeInterfaces(info DeviceInfo, hid bool) ([]DeviceInfo, error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+// configure ignores the options without a backend.
+func configure(opts contextOptions) error {
+	return nil
+}
+
+// initContext is unsupported without a backend.
+func initContext() error {
+	return ErrUnsupportedPlatform
+}
+
+// backendVersion reports the lack of a backend.
+func backendVersion() (string, string) {
+	return "none", ""
+}
+
+// exit has nothing to release without a backend.
+func exit() error {
+	return nil
+}
+
+// open is unsupported without a backend.
+func open(info DeviceInfo, opts *openOptions) (Device, error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+// openFD is unsupported without a backend.
+func openFD(fd int) (Device, error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+// openPath is unsupported without a backend.
+func openPath(path string, opts *openOptions) (Device, DeviceInfo, error) {
+	return nil, DeviceInfo{}, ErrUnsupportedPlatform
+}
+
+// openParentHub is unsupported without a backend.
+func openParentHub(info DeviceInfo) (*parentHub, error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+// topology is unsupported without a backend.
+func topology() ([]*TopologyNode, error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+// hasCapability reports no capabilities without a backend.
+func hasCapability(capability Capability) bool {
+	return false
+}
+
+// onHotplug is unsupported without a backend.
+func onHotplug(handler HotplugHandler) (func(), error) {
+	return nil, ErrUnsupportedPlatform
+}
@@ -0,0 +1,22 @@
+package zerousb
+
+import "time"
+
+// defaultControlTimeout is the timeout of control requests until configured
+// otherwise, the 5 seconds the USB 2.0 spec gives devices to complete requests
+// with a data stage (section 9.2.6.4).
+const defaultControlTimeout = 5 * time.Second
+
+// timeoutMillis converts a transfer timeout into the milliseconds the backends
+// take, where zero waits forever. Negative timeouts poll, waiting the shortest
+// time the backends can express instead of not at all, and positive ones are
+// rounded up so they never turn into waiting forever.
+func timeoutMillis(timeout time.Duration) int {
+	switch {
+	case timeout == 0:
+		return 0
+	case timeout < 0:
+		return 1
+	}
+	return int((timeout + time.Millisecond - 1) / time.Millisecond)
+}
@@ -0,0 +1,28 @@
+package zerousb
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that timeouts convert into backend milliseconds without turning short
+// or negative ones into infinite waits.
+func TestTimeoutMillis(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		millis  int
+	}{
+		{0, 0},
+		{-1, 1},
+		{-time.Second, 1},
+		{time.Nanosecond, 1},
+		{time.Millisecond, 1},
+		{1500 * time.Microsecond, 2},
+		{2 * time.Second, 2000},
+	}
+	for _, tt := range tests {
+		if have := timeoutMillis(tt.timeout); have != tt.millis {
+			t.Errorf("timeout %v: millis mismatch: have %d, want %d", tt.timeout, have, tt.millis)
+		}
+	}
+}
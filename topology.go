@@ -0,0 +1,109 @@
+package zerousb
+
+import (
+	"fmt"
+	"sort"
+)
+
+// descriptorTypeSuperSpeedHub is the hub descriptor of SuperSpeed hubs, from
+// the USB 3.0 spec, section 10.13.2.1.
+const descriptorTypeSuperSpeedHub = 0x2a
+
+// TopologyNode is a device in the tree of hubs and devices attached to the
+// system.
+type TopologyNode struct {
+	Info   DeviceInfo    // Device as listed by ListDevices, without interface fields
+	Depth  int           // Number of hub ports leading to the device, zero for root hubs
+	Parent *TopologyNode // Hub the device is attached to, nil for root hubs
+
+	// Ports holds the devices attached to the downstream ports of a hub, port
+	// N at index N-1, with nil entries for free ports. It's sized after the
+	// hub descriptor, or after the highest occupied port if that can't be
+	// read. Devices on backends not reporting ports are appended at the end.
+	Ports []*TopologyNode
+}
+
+// Hub reports whether the node is a hub.
+func (n *TopologyNode) Hub() bool {
+	return Class(n.Info.Class) == ClassHub
+}
+
+// Occupied returns the number of hub ports devices are attached to.
+func (n *TopologyNode) Occupied() int {
+	var count int
+	for _, child := range n.Ports {
+		if child != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// Walk calls fn for the node and all the devices below it, parents before
+// their children.
+func (n *TopologyNode) Walk(fn func(*TopologyNode)) {
+	fn(n)
+	for _, child := range n.Ports {
+		if child != nil {
+			child.Walk(fn)
+		}
+	}
+}
+
+// Topology returns the tree of hubs and devices attached to the system as its
+// root hubs, ordered by bus. Devices whose parent hub isn't listed by the
+// platform are returned as roots too. HID devices are included. Only
+// supported by the libusb backend.
+func Topology() ([]*TopologyNode, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := builtinOnly("list topology"); err != nil {
+		return nil, err
+	}
+	return topology()
+}
+
+// linkTopology attaches every node to the hub at the index given in parents,
+// -1 for devices without a listed parent, and returns the roots.
+func linkTopology(nodes []*TopologyNode, parents []int) []*TopologyNode {
+	var roots []*TopologyNode
+	for i, node := range nodes {
+		if parents[i] < 0 {
+			roots = append(roots, node)
+			continue
+		}
+		hub := nodes[parents[i]]
+		node.Parent = hub
+
+		port := int(node.Info.Port)
+		if port == 0 {
+			hub.Ports = append(hub.Ports, node)
+			continue
+		}
+		for len(hub.Ports) < port {
+			hub.Ports = append(hub.Ports, nil)
+		}
+		hub.Ports[port-1] = node
+	}
+	sort.SliceStable(roots, func(i, j int) bool { return roots[i].Info.Bus < roots[j].Info.Bus })
+	return roots
+}
+
+// hubPortCount reads the number of downstream ports from the hub descriptor,
+// which SuperSpeed hubs report in their own descriptor type.
+func hubPortCount(control controlFunc, super bool) (int, error) {
+	kind := uint16(DescriptorTypeHub)
+	if super {
+		kind = descriptorTypeSuperSpeedHub
+	}
+	buf := make([]byte, 12)
+	n, err := control(ControlIn|ControlClass|ControlDevice, requestGetDescriptor, kind<<8, 0, buf)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get hub descriptor: %w", err)
+	}
+	if n < 3 {
+		return 0, fmt.Errorf("failed to get hub descriptor: short descriptor of %d bytes", n)
+	}
+	return int(buf[2]), nil
+}
@@ -0,0 +1,105 @@
+//go:build (freebsd && cgo) || (linux && cgo) || (openbsd && cgo) || (netbsd && cgo) || (darwin && !ios && cgo && !iokit) || (windows && cgo && !winusb)
+
+package zerousb
+
+/*
+	#include <libusb.h>
+
+	extern libusb_context* ctx;
+*/
+import "C"
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// Topology returns the tree of hubs and devices attached through the context.
+func (c *Context) Topology() ([]*TopologyNode, error) {
+	return Topology()
+}
+
+// topology lists every device and links them to their parent hubs, which
+// libusb resolves from the same device list.
+func topology() ([]*TopologyNode, error) {
+	// Ensure Init set up a libusb context to interact through
+	if err := checkContext(); err != nil {
+		return nil, err
+	}
+	var deviceList **C.libusb_device
+
+	count := C.libusb_get_device_list(C.ctx, &deviceList)
+	if count < 0 {
+		return nil, libusbError(count)
+	}
+	defer C.libusb_free_device_list(deviceList, 1)
+
+	devices := unsafe.Slice(deviceList, int(count))
+	nodes := make([]*TopologyNode, len(devices))
+	index := make(map[*C.libusb_device]int, len(devices))
+
+	for devnum, dev := range devices {
+		var desc C.struct_libusb_device_descriptor
+		if err := fromLibusbErrno(C.libusb_get_device_descriptor(dev, &desc)); err != nil {
+			return nil, fmt.Errorf("failed to get device %d descriptor: %w", devnum, err)
+		}
+		info := deviceInfo(dev, &desc)
+		info.unresolved = true
+		info.libusbDevice = newLibusbRef(dev)
+
+		var ports [7]C.uint8_t // USB 3.0 limits the depth of hub chains to 7
+		depth := int(C.libusb_get_port_numbers(dev, &ports[0], C.int(len(ports))))
+		if depth < 0 {
+			depth = 0
+		}
+		node := &TopologyNode{Info: info, Depth: depth}
+		if node.Hub() {
+			node.Ports = make([]*TopologyNode, hubPorts(dev, info))
+		}
+		nodes[devnum], index[dev] = node, devnum
+	}
+	parents := make([]int, len(devices))
+	for devnum, dev := range devices {
+		parents[devnum] = -1
+		if parent, ok := index[C.libusb_get_parent(dev)]; ok {
+			parents[devnum] = parent
+		}
+	}
+	return linkTopology(nodes, parents), nil
+}
+
+// hubPorts returns the number of downstream ports of a hub, from sysfs on
+// Linux and from its hub descriptor elsewhere. Zero is returned if neither can
+// be read, e.g. for lack of access to the hub.
+func hubPorts(dev *C.libusb_device, info DeviceInfo) int {
+	if info.SysPath != "" {
+		if blob, err := os.ReadFile(filepath.Join(info.SysPath, "maxchild")); err == nil {
+			if n, err := strconv.Atoi(strings.TrimSpace(string(blob))); err == nil {
+				return n
+			}
+		}
+	}
+	var handle *C.struct_libusb_device_handle
+	if err := fromLibusbErrno(C.libusb_open(dev, &handle)); err != nil {
+		logger().Debug("usb: failed to open hub", "bus", info.Bus, "port", info.Port, "err", err)
+		return 0
+	}
+	defer C.libusb_close(handle)
+
+	n, err := hubPortCount(func(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+		n := C.libusb_control_transfer(handle, C.uint8_t(rType), C.uint8_t(request), C.uint16_t(val), C.uint16_t(idx), bufferPtr(data), C.uint16_t(len(data)), hubTimeout)
+		if n < 0 {
+			return 0, fromLibusbErrno(n)
+		}
+		return int(n), nil
+	}, info.USBVersion >= 0x0300)
+	if err != nil {
+		logger().Debug("usb: failed to read hub ports", "bus", info.Bus, "port", info.Port, "err", err)
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,58 @@
+package zerousb
+
+import "testing"
+
+// Tests that devices are linked below their hubs at the index of their port,
+// growing the port list of hubs whose port count is unknown.
+func TestLinkTopology(t *testing.T) {
+	nodes := []*TopologyNode{
+		{Info: DeviceInfo{Bus: 2, Class: uint8(ClassHub)}, Ports: make([]*TopologyNode, 4)},
+		{Info: DeviceInfo{Bus: 1, Class: uint8(ClassHub)}, Ports: make([]*TopologyNode, 2)},
+		{Info: DeviceInfo{Bus: 1, Port: 2, Class: uint8(ClassHub)}, Depth: 1},
+		{Info: DeviceInfo{Bus: 1, Port: 3}, Depth: 2},
+		{Info: DeviceInfo{Bus: 2, Port: 1}, Depth: 1},
+		{Info: DeviceInfo{Bus: 3}, Depth: 1},
+	}
+	roots := linkTopology(nodes, []int{-1, -1, 1, 2, 0, -1})
+
+	if len(roots) != 3 || roots[0] != nodes[1] || roots[1] != nodes[0] || roots[2] != nodes[5] {
+		t.Fatalf("roots mismatch: have %v", roots)
+	}
+	if hub := nodes[1]; len(hub.Ports) != 2 || hub.Ports[0] != nil || hub.Ports[1] != nodes[2] || hub.Occupied() != 1 {
+		t.Errorf("root hub ports mismatch: have %v", hub.Ports)
+	}
+	if hub := nodes[2]; len(hub.Ports) != 3 || hub.Ports[2] != nodes[3] || hub.Occupied() != 1 {
+		t.Errorf("unknown hub ports mismatch: have %v", hub.Ports)
+	}
+	if nodes[3].Parent != nodes[2] || nodes[2].Parent != nodes[1] || nodes[1].Parent != nil {
+		t.Errorf("parents mismatch")
+	}
+	var walked []*TopologyNode
+	roots[0].Walk(func(n *TopologyNode) { walked = append(walked, n) })
+	if len(walked) != 3 || walked[0] != nodes[1] || walked[2] != nodes[3] {
+		t.Errorf("walk mismatch: have %v", walked)
+	}
+}
+
+// Tests that the port count is read from the hub descriptor of the type
+// matching the hub speed.
+func TestHubPortCount(t *testing.T) {
+	for _, super := range []bool{false, true} {
+		want := uint16(DescriptorTypeHub)
+		if super {
+			want = descriptorTypeSuperSpeedHub
+		}
+		ports, err := hubPortCount(func(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+			if rType != ControlIn|ControlClass|ControlDevice || request != requestGetDescriptor || val != want<<8 {
+				t.Errorf("super %v: request mismatch: have %#x/%#x/%#x", super, rType, request, val)
+			}
+			return copy(data, []byte{9, byte(want), 7, 0x09, 0, 50, 100, 0, 0xff}), nil
+		}, super)
+		if err != nil {
+			t.Errorf("super %v: failed to read port count: %v", super, err)
+		}
+		if ports != 7 {
+			t.Errorf("super %v: port count mismatch: have %d, want 7", super, ports)
+		}
+	}
+}
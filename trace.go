@@ -0,0 +1,150 @@
+package zerousb
+
+import (
+	"net"
+	"time"
+)
+
+// SpanAttribute is an attribute of a traced device operation, such as the
+// vendor id of the device or the length of a transfer.
+type SpanAttribute struct {
+	Key   string // Attribute name, prefixed with "usb."
+	Value int64
+}
+
+// Span is a device operation being traced.
+type Span interface {
+	// End finishes the span with the attributes known once the operation
+	// completed, such as the number of bytes transferred, and the error it
+	// failed with, nil if it succeeded.
+	End(attrs []SpanAttribute, err error)
+}
+
+// Tracer starts spans around device operations, so USB latency shows up in the
+// distributed traces of an application. It's kept minimal so adapters to
+// tracing libraries (e.g. OpenTelemetry) take a few lines, without the package
+// depending on them. Spans are started on the calling goroutine, adapters
+// needing a parent context capture it themselves.
+type Tracer interface {
+	// Start begins a span of the named operation (e.g. "usb.Read").
+	Start(name string, attrs []SpanAttribute) Span
+}
+
+// Traced wraps a device opened with the info into one emitting a span around
+// each Read, Write and Control call, attributed with the ids of the device,
+// the endpoint and length of the transfer, the number of bytes transferred and
+// the native error code of failures. Streams started on the wrapper transfer
+// through the device directly, so their transfers aren't traced.
+func Traced(dev Device, info DeviceInfo, tracer Tracer) Device {
+	traced := &tracedDevice{
+		wrappedDevice: wrappedDevice{dev},
+		tracer:        tracer,
+		attrs:         deviceAttributes(info),
+	}
+	if end, ok := readEndpoint(info); ok {
+		traced.reader = end.Address
+	}
+	if end, ok := writeEndpoint(info); ok {
+		traced.writer = end.Address
+	}
+	return traced
+}
+
+// tracedDevice is a device whose transfers are traced. Methods other than the
+// transfers are passed through untraced.
+type tracedDevice struct {
+	wrappedDevice
+	tracer Tracer
+	attrs  []SpanAttribute // Attributes of the device, shared by all spans
+	reader uint8           // Endpoint Read transfers through, zero if unknown
+	writer uint8           // Endpoint Write transfers through, zero if unknown
+}
+
+// deviceAttributes returns the span attributes identifying a device interface.
+func deviceAttributes(info DeviceInfo) []SpanAttribute {
+	return []SpanAttribute{
+		{"usb.vendor_id", int64(info.VendorID)},
+		{"usb.product_id", int64(info.ProductID)},
+		{"usb.interface", int64(info.Interface)},
+	}
+}
+
+// trace runs a transfer within a span of the named operation, attributed with
+// the device and the given attributes.
+func (dev *tracedDevice) trace(name string, attrs []SpanAttribute, transfer func() (int, error)) (int, error) {
+	span := dev.tracer.Start(name, append(dev.attrs[:len(dev.attrs):len(dev.attrs)], attrs...))
+	n, err := transfer()
+	span.End(resultAttributes(n, err), err)
+	return n, err
+}
+
+// resultAttributes returns the span attributes describing the outcome of a
+// transfer.
+func resultAttributes(n int, err error) []SpanAttribute {
+	attrs := []SpanAttribute{{"usb.transferred", int64(n)}}
+	if code := errorCode(err); code != 0 {
+		attrs = append(attrs, SpanAttribute{"usb.error_code", int64(code)})
+	}
+	return attrs
+}
+
+// transferAttributes returns the span attributes of a transfer of the given
+// length on an endpoint.
+func transferAttributes(endpoint uint8, length int) []SpanAttribute {
+	return []SpanAttribute{{"usb.endpoint", int64(endpoint)}, {"usb.length", int64(length)}}
+}
+
+// Read retrieves a binary blob from the device within a "usb.Read" span.
+func (dev *tracedDevice) Read(b []byte) (int, error) {
+	return dev.trace("usb.Read", transferAttributes(dev.reader, len(b)), func() (int, error) {
+		return dev.Device.Read(b)
+	})
+}
+
+// ReadWithTimeout retrieves a binary blob from the device with a timeout for
+// this call only, within a "usb.Read" span.
+func (dev *tracedDevice) ReadWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	return dev.trace("usb.Read", transferAttributes(dev.reader, len(b)), func() (int, error) {
+		return dev.Device.ReadWithTimeout(b, timeout)
+	})
+}
+
+// Write sends a binary blob to the device within a "usb.Write" span.
+func (dev *tracedDevice) Write(b []byte) (int, error) {
+	return dev.trace("usb.Write", transferAttributes(dev.writer, len(b)), func() (int, error) {
+		return dev.Device.Write(b)
+	})
+}
+
+// WriteWithTimeout sends a binary blob to the device with a timeout for this
+// call only, within a "usb.Write" span.
+func (dev *tracedDevice) WriteWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	return dev.trace("usb.Write", transferAttributes(dev.writer, len(b)), func() (int, error) {
+		return dev.Device.WriteWithTimeout(b, timeout)
+	})
+}
+
+// WriteV sends the buffers to the device within a "usb.Write" span.
+func (dev *tracedDevice) WriteV(bufs net.Buffers) (int, error) {
+	length := 0
+	for _, b := range bufs {
+		length += len(b)
+	}
+	return dev.trace("usb.Write", transferAttributes(dev.writer, length), func() (int, error) {
+		return dev.Device.WriteV(bufs)
+	})
+}
+
+// Control sends a control request to the device within a "usb.Control" span.
+func (dev *tracedDevice) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	attrs := []SpanAttribute{
+		{"usb.request_type", int64(rType)},
+		{"usb.request", int64(request)},
+		{"usb.value", int64(val)},
+		{"usb.index", int64(idx)},
+		{"usb.length", int64(len(data))},
+	}
+	return dev.trace("usb.Control", attrs, func() (int, error) {
+		return dev.Device.Control(rType, request, val, idx, data)
+	})
+}
@@ -0,0 +1,66 @@
+package zerousb
+
+import (
+	"reflect"
+	"testing"
+)
+
+// recordTracer is a tracer recording the spans it started and ended.
+type recordTracer struct {
+	spans []*recordSpan
+}
+
+// recordSpan is a span recorded by a recordTracer.
+type recordSpan struct {
+	name  string
+	attrs []SpanAttribute
+	ended bool
+	err   error
+}
+
+func (t *recordTracer) Start(name string, attrs []SpanAttribute) Span {
+	span := &recordSpan{name: name, attrs: attrs}
+	t.spans = append(t.spans, span)
+	return span
+}
+
+func (s *recordSpan) End(attrs []SpanAttribute, err error) {
+	s.attrs, s.ended, s.err = append(s.attrs, attrs...), true, err
+}
+
+// codeError is a native error carrying a platform specific code.
+type codeError int
+
+func (e codeError) Error() string { return "native failure" }
+func (e codeError) code() int     { return int(e) }
+
+// Tests that traced devices emit a span per transfer, attributed with the
+// device, the transfer and its outcome.
+func TestTraced(t *testing.T) {
+	info := DeviceInfo{
+		VendorID:  0x1234,
+		ProductID: 0x5678,
+		Interface: 1,
+		Endpoints: []EndpointInfo{{Address: 0x81, Attributes: 0x02}, {Address: 0x02, Attributes: 0x02}},
+	}
+	tracer := new(recordTracer)
+	dev := Traced(&recordDevice{limit: 1, err: codeError(-7)}, info, tracer)
+
+	dev.Write([]byte("hello"))
+	if _, err := dev.Write([]byte("world!")); err != codeError(-7) {
+		t.Fatalf("failure mismatch: have %v, want %v", err, codeError(-7))
+	}
+	device := []SpanAttribute{{"usb.vendor_id", 0x1234}, {"usb.product_id", 0x5678}, {"usb.interface", 1}}
+	want := []recordSpan{
+		{"usb.Write", append(device, SpanAttribute{"usb.endpoint", 0x02}, SpanAttribute{"usb.length", 5}, SpanAttribute{"usb.transferred", 5}), true, nil},
+		{"usb.Write", append(device, SpanAttribute{"usb.endpoint", 0x02}, SpanAttribute{"usb.length", 6}, SpanAttribute{"usb.transferred", 0}, SpanAttribute{"usb.error_code", -7}), true, codeError(-7)},
+	}
+	if len(tracer.spans) != len(want) {
+		t.Fatalf("span count mismatch: have %d, want %d", len(tracer.spans), len(want))
+	}
+	for i, span := range tracer.spans {
+		if !reflect.DeepEqual(*span, want[i]) {
+			t.Errorf("span %d mismatch: have %+v, want %+v", i, *span, want[i])
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package zerousb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// TransferRecord is a transfer logged by a TransferLog, written as a single
+// line of JSON.
+type TransferRecord struct {
+	Time      time.Time `json:"time"`      // Completion time of the transfer
+	Bus       uint8     `json:"bus"`       // Bus the device is connected to
+	Port      uint8     `json:"port"`      // Port of the device on its parent hub
+	Interface int       `json:"interface"` // Interface the device was opened on
+	Endpoint  uint8     `json:"endpoint"`  // Endpoint address, direction bit included
+	Direction string    `json:"direction"` // IN or OUT
+	Type      string    `json:"type"`      // Transfer type: control, bulk, interrupt or isochronous
+
+	RequestType uint8  `json:"request_type,omitempty"` // Control request bmRequestType
+	Request     uint8  `json:"request,omitempty"`      // Control request bRequest
+	Value       uint16 `json:"value,omitempty"`        // Control request wValue
+	Index       uint16 `json:"index,omitempty"`        // Control request wIndex
+
+	Length      int    `json:"length"`          // Buffer size of IN transfers, data size of OUT ones
+	Transferred int    `json:"transferred"`     // Number of bytes transferred
+	Status      string `json:"status"`          // "ok", or the portable error the transfer failed with as named by ErrorCode
+	Err         string `json:"error,omitempty"` // Message of the error the transfer failed with
+	Data        []byte `json:"data,omitempty"`  // Data transferred, base64 encoded, if payloads are logged
+}
+
+// TransferLog writes the transfers of devices to a stream as JSON Lines, one
+// TransferRecord per Read, Write and Control call, so sessions can be fed into
+// analysis pipelines or diffed across firmware versions. Failures to write the
+// stream stop the log, they are reported by Err.
+type TransferLog struct {
+	enc     *json.Encoder
+	payload bool  // Whether the transferred data is logged
+	err     error // Sticky failure to write the stream
+	lock    sync.Mutex
+}
+
+// NewTransferLog starts logging transfers to w, including the data transferred
+// if payload is set.
+func NewTransferLog(w io.Writer, payload bool) *TransferLog {
+	return &TransferLog{enc: json.NewEncoder(w), payload: payload}
+}
+
+// Err returns the failure which stopped the log, nil if it's running.
+func (l *TransferLog) Err() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.err
+}
+
+// Wrap returns a device logging the Read, Write and Control calls of a device
+// opened with the info. Streams started on the wrapper transfer through the
+// device directly, so their transfers aren't logged.
+func (l *TransferLog) Wrap(dev Device, info DeviceInfo) Device {
+	logged := &loggedDevice{
+		wrappedDevice: wrappedDevice{dev},
+		log:           l,
+		info:          TransferRecord{Bus: info.Bus, Port: info.Port, Interface: info.Interface},
+		readerType:    TransferTypeBulk,
+		writerType:    TransferTypeBulk,
+	}
+	if end, ok := readEndpoint(info); ok {
+		logged.reader, logged.readerType = end.Address, end.TransferType()
+	}
+	if end, ok := writeEndpoint(info); ok {
+		logged.writer, logged.writerType = end.Address, end.TransferType()
+	}
+	return logged
+}
+
+// record writes a record into the log, unless it was stopped.
+func (l *TransferLog) record(rec *TransferRecord) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.err != nil {
+		return
+	}
+	if err := l.enc.Encode(rec); err != nil {
+		l.err = fmt.Errorf("failed to log transfer: %w", err)
+	}
+}
+
+// loggedDevice is a device whose transfers are logged. Methods other than the
+// transfers are passed through unlogged.
+type loggedDevice struct {
+	wrappedDevice
+	log        *TransferLog
+	info       TransferRecord // Fields identifying the device, shared by all records
+	reader     uint8          // Endpoint Read transfers through
+	readerType TransferType   // Transfer type of the reader endpoint, bulk if unknown
+	writer     uint8          // Endpoint Write transfers through
+	writerType TransferType   // Transfer type of the writer endpoint, bulk if unknown
+}
+
+// transfer runs a transfer of length bytes through buf and logs it once
+// completed, along with the data moved if payloads are logged. The buffer is
+// only needed for the payload, it may be nil if they aren't logged.
+func (dev *loggedDevice) transfer(rec TransferRecord, length int, buf []byte, transfer func() (int, error)) (int, error) {
+	n, err := transfer()
+
+	rec.Time, rec.Bus, rec.Port, rec.Interface = time.Now(), dev.info.Bus, dev.info.Port, dev.info.Interface
+	rec.Direction = EndpointDirection(rec.Endpoint&endpointDirectionMask != 0).String()
+	rec.Length, rec.Transferred, rec.Status = length, n, "ok"
+	if err != nil {
+		rec.Status, rec.Err = ErrorCode(err), err.Error()
+	}
+	if dev.log.payload && n > 0 {
+		// Transfers may fail partway, only the data moved is logged
+		rec.Data = buf[:n]
+	}
+	dev.log.record(&rec)
+	return n, err
+}
+
+// Read retrieves a binary blob from the device, logging the transfer.
+func (dev *loggedDevice) Read(b []byte) (int, error) {
+	return dev.transfer(TransferRecord{Endpoint: dev.reader, Type: dev.readerType.String()}, len(b), b, func() (int, error) {
+		return dev.Device.Read(b)
+	})
+}
+
+// ReadWithTimeout retrieves a binary blob from the device with a timeout for
+// this call only, logging the transfer.
+func (dev *loggedDevice) ReadWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	return dev.transfer(TransferRecord{Endpoint: dev.reader, Type: dev.readerType.String()}, len(b), b, func() (int, error) {
+		return dev.Device.ReadWithTimeout(b, timeout)
+	})
+}
+
+// Write sends a binary blob to the device, logging the transfer.
+func (dev *loggedDevice) Write(b []byte) (int, error) {
+	return dev.transfer(TransferRecord{Endpoint: dev.writer, Type: dev.writerType.String()}, len(b), b, func() (int, error) {
+		return dev.Device.Write(b)
+	})
+}
+
+// WriteWithTimeout sends a binary blob to the device with a timeout for this
+// call only, logging the transfer.
+func (dev *loggedDevice) WriteWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	return dev.transfer(TransferRecord{Endpoint: dev.writer, Type: dev.writerType.String()}, len(b), b, func() (int, error) {
+		return dev.Device.WriteWithTimeout(b, timeout)
+	})
+}
+
+// WriteV sends the buffers to the device, logging them as one transfer. The
+// buffers are only concatenated if payloads are logged.
+func (dev *loggedDevice) WriteV(bufs net.Buffers) (int, error) {
+	var (
+		length int
+		data   []byte
+	)
+	for _, b := range bufs {
+		length += len(b)
+	}
+	if dev.log.payload {
+		data = make([]byte, 0, length)
+		for _, b := range bufs {
+			data = append(data, b...)
+		}
+	}
+	return dev.transfer(TransferRecord{Endpoint: dev.writer, Type: dev.writerType.String()}, length, data, func() (int, error) {
+		return dev.Device.WriteV(bufs)
+	})
+}
+
+// Control sends a control request to the device, logging the transfer.
+func (dev *loggedDevice) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	rec := TransferRecord{
+		Type:        TransferTypeControl.String(),
+		RequestType: rType,
+		Request:     request,
+		Value:       val,
+		Index:       idx,
+	}
+	if rType&ControlIn != 0 {
+		rec.Endpoint = endpointDirectionMask
+	}
+	return dev.transfer(rec, len(data), data, func() (int, error) {
+		return dev.Device.Control(rType, request, val, idx, data)
+	})
+}
@@ -0,0 +1,91 @@
+package zerousb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+// Tests that transfers are logged one JSON object per line, carrying the data
+// moved and the portable error of failures.
+func TestTransferLog(t *testing.T) {
+	var out bytes.Buffer
+	log := NewTransferLog(&out, true)
+
+	info := DeviceInfo{Bus: 3, Port: 7, Interface: 1, Endpoints: []EndpointInfo{{Address: 0x02, Attributes: 0x03}}}
+	dev := log.Wrap(&recordDevice{limit: 1, err: ErrTimeout}, info)
+	if _, err := dev.Write([]byte("hello")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if _, err := dev.Write([]byte("world")); err != ErrTimeout {
+		t.Fatalf("write error mismatch: have %v, want %v", err, ErrTimeout)
+	}
+	if log.Err() != nil {
+		t.Fatalf("log failed: %v", log.Err())
+	}
+	tests := []struct {
+		transferred int
+		status      string
+		data        string
+	}{
+		{5, "ok", "hello"},
+		{0, "timeout", ""},
+	}
+	scanner := bufio.NewScanner(&out)
+	for i, tt := range tests {
+		if !scanner.Scan() {
+			t.Fatalf("record %d: missing", i)
+		}
+		var rec TransferRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("record %d: failed to decode %s: %v", i, scanner.Bytes(), err)
+		}
+		if rec.Bus != 3 || rec.Port != 7 || rec.Interface != 1 || rec.Endpoint != 0x02 || rec.Direction != "OUT" || rec.Type != "interrupt" {
+			t.Errorf("record %d: transfer mismatch: have %+v", i, rec)
+		}
+		if rec.Length != 5 || rec.Transferred != tt.transferred || rec.Status != tt.status || string(rec.Data) != tt.data || rec.Time.IsZero() {
+			t.Errorf("record %d: outcome mismatch: have %+v", i, rec)
+		}
+	}
+	if scanner.Scan() {
+		t.Errorf("unexpected record: %s", scanner.Bytes())
+	}
+}
+
+// vectorDevice is a record device accepting vectored writes.
+type vectorDevice struct {
+	recordDevice
+}
+
+func (dev *vectorDevice) WriteV(bufs net.Buffers) (int, error) {
+	var n int
+	for _, b := range bufs {
+		n += len(b)
+	}
+	return n, nil
+}
+
+// Tests that vectored writes are logged as one transfer of their total length,
+// carrying the concatenated buffers only if payloads are logged.
+func TestTransferLogWriteV(t *testing.T) {
+	for _, payload := range []bool{false, true} {
+		var out bytes.Buffer
+		dev := NewTransferLog(&out, payload).Wrap(new(vectorDevice), DeviceInfo{})
+		if _, err := dev.WriteV(net.Buffers{[]byte("hel"), []byte("lo")}); err != nil {
+			t.Fatalf("payload %v: failed to write: %v", payload, err)
+		}
+		var rec TransferRecord
+		if err := json.Unmarshal(out.Bytes(), &rec); err != nil {
+			t.Fatalf("payload %v: failed to decode %s: %v", payload, out.Bytes(), err)
+		}
+		want := ""
+		if payload {
+			want = "hello"
+		}
+		if rec.Length != 5 || rec.Transferred != 5 || string(rec.Data) != want {
+			t.Errorf("payload %v: record mismatch: have %+v", payload, rec)
+		}
+	}
+}
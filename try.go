@@ -0,0 +1,84 @@
+package zerousb
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrWouldBlock is returned by TryRead and TryWrite while the transfer they
+// queued is still in flight.
+var ErrWouldBlock = errors.New("usb: operation would block")
+
+// tryResult is the outcome of a transfer queued by TryRead or TryWrite.
+type tryResult struct {
+	buf  []byte
+	n    int
+	err  error
+	done chan struct{} // Closed when the transfer completes
+}
+
+// tryEmulation implements TryRead and TryWrite on backends without asynchronous
+// transfers, running a single blocking transfer per direction on a goroutine.
+type tryEmulation struct {
+	lock  sync.Mutex
+	read  *tryResult // Read in flight or awaiting collection, nil if none
+	write *tryResult // Write in flight or awaiting collection, nil if none
+}
+
+// tryRead collects the completed read, or queues one of len(b) bytes if there's
+// none in flight.
+func (e *tryEmulation) tryRead(b []byte, read func([]byte) (int, error)) (int, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.read == nil {
+		e.read = e.start(make([]byte, len(b)), read)
+		return 0, ErrWouldBlock
+	}
+	select {
+	case <-e.read.done:
+	default:
+		return 0, ErrWouldBlock
+	}
+	res := e.read
+	e.read = nil
+
+	n := copy(b, res.buf[:res.n])
+	if res.err == nil && n < res.n {
+		return n, ErrOverflow
+	}
+	return n, res.err
+}
+
+// tryWrite queues a write of b unless the previous one is in flight, returning
+// the failure of the previous one instead if it failed.
+func (e *tryEmulation) tryWrite(b []byte, write func([]byte) (int, error)) (int, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.write != nil {
+		select {
+		case <-e.write.done:
+		default:
+			return 0, ErrWouldBlock
+		}
+		res := e.write
+		e.write = nil
+
+		if res.err != nil {
+			return 0, res.err
+		}
+	}
+	e.write = e.start(append([]byte(nil), b...), write)
+	return len(b), nil
+}
+
+// start runs a transfer on buf in the background.
+func (e *tryEmulation) start(buf []byte, transfer func([]byte) (int, error)) *tryResult {
+	res := &tryResult{buf: buf, done: make(chan struct{})}
+	go func() {
+		res.n, res.err = transfer(res.buf)
+		close(res.done)
+	}()
+	return res
+}
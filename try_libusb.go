@@ -0,0 +1,98 @@
+//go:build (freebsd && cgo) || (linux && cgo) || (openbsd && cgo) || (netbsd && cgo) || (darwin && !ios && cgo && !iokit) || (windows && cgo && !winusb)
+
+package zerousb
+
+import "unsafe"
+
+// TryRead collects the data of a read queued by an earlier call without
+// blocking. If no read is in flight, an asynchronous one of len(b) bytes is
+// queued. Either way ErrWouldBlock is returned until the read completes.
+func (dev *libusbDevice) TryRead(b []byte) (int, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+	dev.readLock.Lock()
+	defer dev.readLock.Unlock()
+
+	if dev.handle == nil {
+		return 0, dev.closedErr()
+	}
+	if dev.tryIn == nil {
+		t, err := dev.newTransfer(*dev.libusbReader, *dev.readerTransferType, len(b), dev.readTimeout)
+		if err != nil {
+			return 0, newTransferError(*dev.libusbReader, 0, err)
+		}
+		if err := t.submit(); err != nil {
+			t.free()
+			return 0, dev.check(newTransferError(*dev.libusbReader, 0, err))
+		}
+		dev.tryIn = t
+		return 0, ErrWouldBlock
+	}
+	data, done, err := dev.tryIn.poll()
+	if !done {
+		return 0, ErrWouldBlock
+	}
+	n := copy(b, data)
+	if err == nil && n < len(data) {
+		err = libusbErrOverflow
+	}
+	dev.tryIn.free()
+	dev.tryIn = nil
+
+	if err != nil {
+		return n, dev.check(newTransferError(*dev.libusbReader, n, err))
+	}
+	return n, nil
+}
+
+// TryWrite queues an asynchronous write of b without blocking, failing with
+// ErrWouldBlock while the previous one is in flight. If the previous write
+// failed, its error is returned instead, without queuing b.
+func (dev *libusbDevice) TryWrite(b []byte) (int, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+	dev.writeLock.Lock()
+	defer dev.writeLock.Unlock()
+
+	if dev.handle == nil {
+		return 0, dev.closedErr()
+	}
+	if dev.tryOut != nil {
+		data, done, err := dev.tryOut.poll()
+		if !done {
+			return 0, ErrWouldBlock
+		}
+		dev.tryOut.free()
+		dev.tryOut = nil
+
+		if err != nil {
+			return 0, dev.check(newTransferError(*dev.libusbWriter, len(data), err))
+		}
+	}
+	t, err := dev.newTransfer(*dev.libusbWriter, *dev.writerTransferType, len(b), dev.writeTimeout)
+	if err != nil {
+		return 0, newTransferError(*dev.libusbWriter, 0, err)
+	}
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(t.buf)), t.size), b)
+	if err := t.submit(); err != nil {
+		t.free()
+		return 0, dev.check(newTransferError(*dev.libusbWriter, 0, err))
+	}
+	dev.tryOut = t
+	return len(b), nil
+}
+
+// closeTries cancels and reaps the transfers queued by TryRead and TryWrite.
+func (dev *libusbDevice) closeTries() {
+	for _, t := range []*libusbTransfer{dev.tryIn, dev.tryOut} {
+		if t == nil {
+			continue
+		}
+		t.cancel()
+		if t.inflight {
+			t.wait()
+		}
+		t.free()
+	}
+	dev.tryIn, dev.tryOut = nil, nil
+}
@@ -0,0 +1,71 @@
+package zerousb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Tests that emulated non-blocking reads queue a read on the first call, report
+// ErrWouldBlock while it's in flight and hand out its data once completed.
+func TestTryRead(t *testing.T) {
+	var (
+		emu     tryEmulation
+		release = make(chan struct{})
+	)
+	read := func(b []byte) (int, error) {
+		<-release
+		return copy(b, "data"), nil
+	}
+	buf := make([]byte, 8)
+	for i := 0; i < 2; i++ {
+		if n, err := emu.tryRead(buf, read); n != 0 || err != ErrWouldBlock {
+			t.Fatalf("call %d: in flight read mismatch: have %d, %v, want 0, %v", i, n, err, ErrWouldBlock)
+		}
+	}
+	close(release)
+	<-emu.read.done
+
+	n, err := emu.tryRead(buf, read)
+	if err != nil {
+		t.Fatalf("failed to collect read: %v", err)
+	}
+	if string(buf[:n]) != "data" {
+		t.Fatalf("data mismatch: have %q, want %q", buf[:n], "data")
+	}
+	if emu.read != nil {
+		t.Fatalf("collected read still pending")
+	}
+}
+
+// Tests that emulated non-blocking writes are accepted while none is in flight,
+// and that the failure of a write is reported by the following call.
+func TestTryWrite(t *testing.T) {
+	var (
+		emu      tryEmulation
+		errWrite = errors.New("write failed")
+		release  = make(chan struct{})
+	)
+	write := func(b []byte) (int, error) {
+		<-release
+		return 0, errWrite
+	}
+	if n, err := emu.tryWrite([]byte("data"), write); n != 4 || err != nil {
+		t.Fatalf("queued write mismatch: have %d, %v, want 4, nil", n, err)
+	}
+	if n, err := emu.tryWrite([]byte("more"), write); n != 0 || err != ErrWouldBlock {
+		t.Fatalf("in flight write mismatch: have %d, %v, want 0, %v", n, err, ErrWouldBlock)
+	}
+	close(release)
+	select {
+	case <-emu.write.done:
+	case <-time.After(time.Second):
+		t.Fatalf("write not completed")
+	}
+	if n, err := emu.tryWrite([]byte("more"), write); n != 0 || err != errWrite {
+		t.Fatalf("failed write mismatch: have %d, %v, want 0, %v", n, err, errWrite)
+	}
+	if emu.write != nil {
+		t.Fatalf("failed write queued data")
+	}
+}
@@ -0,0 +1,158 @@
+// Package uac implements the host side of the USB Audio Class 1.0 on top of
+// zerousb: parsing the audio streaming descriptors, picking the alternate
+// setting matching a PCM format, programming the sampling rate and pacing
+// sample streams according to the device's clock feedback.
+//
+// Audio is carried over isochronous endpoints; the streams in this package
+// are written against packet level interfaces so they can be plugged onto any
+// isochronous transport.
+package uac
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Descriptor constants of the USB Audio 1.0 spec, appendix A.
+const (
+	classAudio        = 0x01
+	subclassStreaming = 0x02
+	descInterface     = 0x04
+	descEndpoint      = 0x05
+	descCSInterface   = 0x24
+	asGeneral         = 0x01
+	asFormatType      = 0x02
+	formatTypeI       = 0x01
+	formatPCM         = 0x0001
+	endpointSync      = 0x0c // Synchronization type bits of bmAttributes
+	syncAsynchronous  = 0x04
+)
+
+// AltSetting is a single alternate setting of an audio streaming interface,
+// describing the PCM format it carries.
+type AltSetting struct {
+	Interface     uint8    // Interface number
+	Alternate     uint8    // Alternate setting number
+	Terminal      uint8    // Terminal the interface is connected to
+	FormatTag     uint16   // wFormatTag, 1 for PCM
+	Channels      uint8    // Number of channels
+	SubframeSize  uint8    // Bytes per sample per channel
+	BitResolution uint8    // Significant bits per sample
+	Rates         []uint32 // Discrete sample rates, or min and max if continuous
+	Continuous    bool     // Whether Rates is a min/max range
+	Endpoint      uint8    // Data endpoint address
+	MaxPacketSize uint16   // Max packet size of the data endpoint
+	Asynchronous  bool     // Whether the endpoint needs explicit feedback
+	SyncEndpoint  uint8    // Feedback endpoint address, 0 if none
+}
+
+// FrameSize returns the number of bytes of a single audio frame (one sample of
+// every channel).
+func (a *AltSetting) FrameSize() int {
+	return int(a.Channels) * int(a.SubframeSize)
+}
+
+// Supports reports whether the alternate setting can carry the given rate.
+func (a *AltSetting) Supports(rate uint32) bool {
+	if a.Continuous {
+		return len(a.Rates) == 2 && rate >= a.Rates[0] && rate <= a.Rates[1]
+	}
+	for _, r := range a.Rates {
+		if r == rate {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseDescriptors extracts the audio streaming alternate settings with an
+// endpoint from a raw configuration descriptor, as returned by
+// Device.RawDescriptors. Zero bandwidth alternate settings are skipped.
+func ParseDescriptors(config []byte) ([]AltSetting, error) {
+	var (
+		alts    []AltSetting
+		current *AltSetting
+	)
+	for len(config) >= 2 {
+		length := int(config[0])
+		if length < 2 || length > len(config) {
+			return nil, fmt.Errorf("uac: malformed descriptor of length %d", length)
+		}
+		desc := config[:length]
+		config = config[length:]
+
+		switch desc[1] {
+		case descInterface:
+			current = nil
+			if length >= 9 && desc[5] == classAudio && desc[6] == subclassStreaming && desc[4] > 0 {
+				alts = append(alts, AltSetting{Interface: desc[2], Alternate: desc[3]})
+				current = &alts[len(alts)-1]
+			}
+		case descCSInterface:
+			if current == nil || length < 3 {
+				continue
+			}
+			switch desc[2] {
+			case asGeneral:
+				if length >= 7 {
+					current.Terminal = desc[3]
+					current.FormatTag = binary.LittleEndian.Uint16(desc[5:])
+				}
+			case asFormatType:
+				if length < 8 || desc[3] != formatTypeI {
+					continue
+				}
+				current.Channels, current.SubframeSize, current.BitResolution = desc[4], desc[5], desc[6]
+				count := int(desc[7])
+				if count == 0 {
+					current.Continuous, count = true, 2
+				}
+				for i := 0; i < count && 8+3*i+3 <= length; i++ {
+					current.Rates = append(current.Rates, rate24(desc[8+3*i:]))
+				}
+			}
+		case descEndpoint:
+			if current == nil || length < 7 {
+				continue
+			}
+			// The data endpoint is the first, an optional feedback one follows
+			if current.Endpoint == 0 {
+				current.Endpoint = desc[2]
+				current.MaxPacketSize = binary.LittleEndian.Uint16(desc[4:])
+				current.Asynchronous = desc[3]&endpointSync == syncAsynchronous
+				if length >= 9 {
+					current.SyncEndpoint = desc[8]
+				}
+			}
+		}
+	}
+	return alts, nil
+}
+
+// rate24 decodes a 3 byte little endian sample rate.
+func rate24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+// ErrNoAltSetting is returned if no alternate setting carries a format.
+var ErrNoAltSetting = errors.New("uac: no alternate setting matches the format")
+
+// Select picks the alternate setting carrying PCM with the requested rate,
+// channel count and bit resolution in the given direction (input endpoints
+// for capture, output for playback).
+func Select(alts []AltSetting, capture bool, rate uint32, channels, bits uint8) (*AltSetting, error) {
+	for i := range alts {
+		alt := &alts[i]
+		if alt.FormatTag != formatPCM || alt.Channels != channels || alt.BitResolution != bits {
+			continue
+		}
+		if (alt.Endpoint&0x80 != 0) != capture {
+			continue
+		}
+		if alt.Supports(rate) {
+			return alt, nil
+		}
+	}
+	return nil, ErrNoAltSetting
+}
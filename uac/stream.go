@@ -0,0 +1,149 @@
+package uac
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/chay22/zerousb"
+)
+
+// Endpoint control requests of the USB Audio 1.0 spec.
+const (
+	requestSetCur       = 0x01
+	controlSamplingFreq = 0x01
+)
+
+// Controller is the subset of zerousb.Device needed to configure a stream.
+type Controller interface {
+	Control(rType, request uint8, val, idx uint16, data []byte) (int, error)
+	SetAltSetting(iface int, alt int) error
+}
+
+// Configure activates an alternate setting and programs its sampling rate.
+func Configure(dev Controller, alt *AltSetting, rate uint32) error {
+	if err := dev.SetAltSetting(int(alt.Interface), int(alt.Alternate)); err != nil {
+		return fmt.Errorf("uac: %w", err)
+	}
+	freq := []byte{uint8(rate), uint8(rate >> 8), uint8(rate >> 16)}
+	if _, err := dev.Control(zerousb.ControlOut|zerousb.ControlClass|zerousb.ControlEndpoint, requestSetCur, controlSamplingFreq<<8, uint16(alt.Endpoint), freq); err != nil {
+		return fmt.Errorf("uac: failed to set sampling rate: %w", err)
+	}
+	return nil
+}
+
+// ParseFeedback decodes an explicit feedback packet into the number of frames
+// per (micro)frame the device consumes: 10.14 fixed point on full speed (3
+// bytes) and 16.16 on high speed (4 bytes) devices.
+func ParseFeedback(packet []byte) (float64, error) {
+	switch len(packet) {
+	case 3:
+		return float64(rate24(packet)) / (1 << 14), nil
+	case 4:
+		v := uint32(packet[0]) | uint32(packet[1])<<8 | uint32(packet[2])<<16 | uint32(packet[3])<<24
+		return float64(v) / (1 << 16), nil
+	}
+	return 0, fmt.Errorf("uac: invalid feedback length %d", len(packet))
+}
+
+// PacketWriter is a sink of isochronous OUT packets, one packet per write.
+type PacketWriter interface {
+	Write(packet []byte) (int, error)
+}
+
+// Playback paces a PCM stream into isochronous packets. Without feedback it
+// assumes the nominal rate; asynchronous devices report their actual rate via
+// the feedback endpoint which should be fed into SetFeedback.
+type Playback struct {
+	out       PacketWriter
+	frameSize int // Bytes per audio frame
+	maxPacket int // Max bytes per packet
+
+	lock    sync.Mutex
+	rate    float64 // Audio frames per packet interval
+	accum   float64 // Fractional frames carried over between packets
+	pending []byte  // PCM data not yet packetized
+}
+
+// NewPlayback creates a paced playback stream for an alternate setting at the
+// given sample rate, with packets sent every interval (1000 for full speed
+// frames, 8000 for high speed microframes per second).
+func NewPlayback(out PacketWriter, alt *AltSetting, rate uint32, interval int) *Playback {
+	return &Playback{
+		out:       out,
+		frameSize: alt.FrameSize(),
+		maxPacket: int(alt.MaxPacketSize),
+		rate:      float64(rate) / float64(interval),
+	}
+}
+
+// SetFeedback updates the pacing with the frames per packet reported by the
+// device's feedback endpoint.
+func (p *Playback) SetFeedback(framesPerPacket float64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.rate = framesPerPacket
+}
+
+// Write queues interleaved PCM samples and sends as many complete packets as
+// the data allows. It returns the number of bytes accepted, which is always
+// all of them unless sending fails.
+func (p *Playback) Write(pcm []byte) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.pending = append(p.pending, pcm...)
+	for {
+		frames := int(p.rate + p.accum)
+		size := frames * p.frameSize
+		if size > p.maxPacket {
+			frames, size = p.maxPacket/p.frameSize, p.maxPacket/p.frameSize*p.frameSize
+		}
+		if size == 0 || len(p.pending) < size {
+			return len(pcm), nil
+		}
+		if _, err := p.out.Write(p.pending[:size]); err != nil {
+			return len(pcm), err
+		}
+		p.accum += p.rate - float64(frames)
+		p.pending = p.pending[size:]
+	}
+}
+
+// PacketReader is a source of isochronous IN packets, one packet per read.
+type PacketReader interface {
+	Read(packet []byte) (int, error)
+}
+
+// Capture turns isochronous IN packets into a continuous PCM byte stream.
+type Capture struct {
+	in        PacketReader
+	maxPacket int
+
+	lock    sync.Mutex
+	pending []byte // Samples received but not yet consumed
+}
+
+// NewCapture creates a capture stream for an alternate setting.
+func NewCapture(in PacketReader, alt *AltSetting) *Capture {
+	return &Capture{in: in, maxPacket: int(alt.MaxPacketSize)}
+}
+
+// Read fills b with interleaved PCM samples, reading packets from the device
+// until at least some data is available.
+func (c *Capture) Read(b []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for len(c.pending) == 0 {
+		packet := make([]byte, c.maxPacket)
+		n, err := c.in.Read(packet)
+		if err != nil {
+			return 0, err
+		}
+		c.pending = packet[:n]
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
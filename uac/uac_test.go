@@ -0,0 +1,48 @@
+package uac
+
+import "testing"
+
+// packets collects the sizes of the packets written.
+type packets []int
+
+func (p *packets) Write(b []byte) (int, error) {
+	*p = append(*p, len(b))
+	return len(b), nil
+}
+
+// Tests that 44.1kHz playback alternates between 44 and 45 frame packets.
+func TestPlaybackPacing(t *testing.T) {
+	alt := &AltSetting{Channels: 2, SubframeSize: 2, MaxPacketSize: 192}
+
+	var out packets
+	play := NewPlayback(&out, alt, 44100, 1000)
+	if _, err := play.Write(make([]byte, 44100*4)); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if len(out) != 1000 {
+		t.Fatalf("packet count mismatch: have %d, want 1000", len(out))
+	}
+	var long int
+	for _, size := range out {
+		switch size {
+		case 44 * 4:
+		case 45 * 4:
+			long++
+		default:
+			t.Fatalf("unexpected packet size %d", size)
+		}
+	}
+	if long != 100 {
+		t.Errorf("long packet count mismatch: have %d, want 100", long)
+	}
+}
+
+// Tests the decoding of full and high speed feedback values.
+func TestParseFeedback(t *testing.T) {
+	if rate, _ := ParseFeedback([]byte{0x66, 0x06, 0x0b}); rate < 44.09 || rate > 44.11 {
+		t.Errorf("full speed feedback mismatch: have %f, want 44.1", rate)
+	}
+	if rate, _ := ParseFeedback([]byte{0x00, 0x00, 0x06, 0x00}); rate != 6 {
+		t.Errorf("high speed feedback mismatch: have %f, want 6", rate)
+	}
+}
@@ -0,0 +1,71 @@
+package zerousb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UdevOptions customizes the rules generated by UdevRule. The zero value grants
+// read and write access to everyone.
+type UdevOptions struct {
+	Group      string // Group owning the device nodes, unchanged if empty
+	Owner      string // User owning the device nodes, unchanged if empty
+	Mode       string // Permissions of the device nodes, defaults to 0660 if a group, owner or uaccess is set, 0666 otherwise
+	Uaccess    bool   // Grant access to the user logged in at the local seat via systemd-logind
+	AnyProduct bool   // Match every product of the vendor, not just the product id of the device
+	HIDRaw     bool   // Also grant access to the hidraw nodes of the device, used by HID libraries
+}
+
+// UdevRule generates the udev rules granting access to the device nodes of a
+// USB device by its vendor and product ids, so it can be opened without root
+// on Linux. The rules are meant to be installed into /etc/udev/rules.d, e.g.
+// as 50-zerousb.rules, followed by `udevadm control --reload` and a replug.
+func UdevRule(info DeviceInfo, opts *UdevOptions) (string, error) {
+	if opts == nil {
+		opts = new(UdevOptions)
+	}
+	for _, value := range []string{opts.Group, opts.Owner, opts.Mode} {
+		if strings.ContainsAny(value, "\"\\\n") {
+			return "", fmt.Errorf("failed to generate udev rule: invalid value %q: %w", value, ErrInvalidParam)
+		}
+	}
+	match := fmt.Sprintf(`ATTR{idVendor}=="%04x"`, info.VendorID)
+	if !opts.AnyProduct {
+		match += fmt.Sprintf(`, ATTR{idProduct}=="%04x"`, info.ProductID)
+	}
+	mode := opts.Mode
+	if mode == "" {
+		mode = "0666"
+		if opts.Group != "" || opts.Owner != "" || opts.Uaccess {
+			mode = "0660"
+		}
+	}
+	access := fmt.Sprintf(`MODE="%s"`, mode)
+	if opts.Group != "" {
+		access += fmt.Sprintf(`, GROUP="%s"`, opts.Group)
+	}
+	if opts.Owner != "" {
+		access += fmt.Sprintf(`, OWNER="%s"`, opts.Owner)
+	}
+	if opts.Uaccess {
+		access += `, TAG+="uaccess"`
+	}
+	var rule strings.Builder
+
+	// Label the rule with the names of the device, flattened onto a single line
+	name := strings.Join(strings.Fields(info.Manufacturer+" "+info.Product), " ")
+	switch {
+	case opts.AnyProduct:
+		fmt.Fprintf(&rule, "# USB devices of vendor %04x\n", info.VendorID)
+	case name != "":
+		fmt.Fprintf(&rule, "# %s (%04x:%04x)\n", name, info.VendorID, info.ProductID)
+	default:
+		fmt.Fprintf(&rule, "# USB device %04x:%04x\n", info.VendorID, info.ProductID)
+	}
+	fmt.Fprintf(&rule, "SUBSYSTEM==\"usb\", %s, %s\n", match, access)
+	if opts.HIDRaw {
+		// hidraw nodes sit below the interfaces, match the ids on the ancestors
+		fmt.Fprintf(&rule, "KERNEL==\"hidraw*\", SUBSYSTEM==\"hidraw\", %s, %s\n", strings.ReplaceAll(match, "ATTR{", "ATTRS{"), access)
+	}
+	return rule.String(), nil
+}
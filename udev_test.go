@@ -0,0 +1,43 @@
+package zerousb
+
+import (
+	"strings"
+	"testing"
+)
+
+// Tests that udev rules match the ids of the device and grant the requested
+// access to its nodes.
+func TestUdevRule(t *testing.T) {
+	info := DeviceInfo{VendorID: 0x1d50, ProductID: 0x6089, Manufacturer: "Great Scott\nGadgets", Product: "HackRF One"}
+
+	tests := []struct {
+		opts *UdevOptions
+		rule string
+		fail bool
+	}{
+		{nil, "# Great Scott Gadgets HackRF One (1d50:6089)\n" +
+			`SUBSYSTEM=="usb", ATTR{idVendor}=="1d50", ATTR{idProduct}=="6089", MODE="0666"` + "\n", false},
+		{&UdevOptions{Group: "plugdev"}, "# Great Scott Gadgets HackRF One (1d50:6089)\n" +
+			`SUBSYSTEM=="usb", ATTR{idVendor}=="1d50", ATTR{idProduct}=="6089", MODE="0660", GROUP="plugdev"` + "\n", false},
+		{&UdevOptions{Owner: "pi", Mode: "0600", Uaccess: true, AnyProduct: true}, "# USB devices of vendor 1d50\n" +
+			`SUBSYSTEM=="usb", ATTR{idVendor}=="1d50", MODE="0600", OWNER="pi", TAG+="uaccess"` + "\n", false},
+		{&UdevOptions{Uaccess: true, HIDRaw: true}, "# Great Scott Gadgets HackRF One (1d50:6089)\n" +
+			`SUBSYSTEM=="usb", ATTR{idVendor}=="1d50", ATTR{idProduct}=="6089", MODE="0660", TAG+="uaccess"` + "\n" +
+			`KERNEL=="hidraw*", SUBSYSTEM=="hidraw", ATTRS{idVendor}=="1d50", ATTRS{idProduct}=="6089", MODE="0660", TAG+="uaccess"` + "\n", false},
+		{&UdevOptions{Group: `plugdev", RUN+="/bin/sh`}, "", true},
+	}
+	for i, tt := range tests {
+		rule, err := UdevRule(info, tt.opts)
+		if (err != nil) != tt.fail {
+			t.Errorf("test %d: failure mismatch: have %v, want failure %v", i, err, tt.fail)
+			continue
+		}
+		if rule != tt.rule {
+			t.Errorf("test %d: rule mismatch:\nhave:\n%s\nwant:\n%s", i, rule, tt.rule)
+		}
+	}
+	// Devices without names are labelled by their ids
+	if rule, _ := UdevRule(DeviceInfo{VendorID: 0x1234, ProductID: 0x5678}, nil); !strings.HasPrefix(rule, "# USB device 1234:5678\n") {
+		t.Errorf("unnamed label mismatch: have %q", rule)
+	}
+}
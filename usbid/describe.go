@@ -36,6 +36,9 @@ import (
 // The given val must be one of the following:
 //   - zerousb.DeviceInfo       "Product (Vendor)"
 func Describe(val interface{}) string {
+	lock.RLock()
+	defer lock.RUnlock()
+
 	switch val := val.(type) {
 	case zerousb.DeviceInfo:
 		if v, ok := Vendors[val.VendorID]; ok {
@@ -49,22 +52,76 @@ func Describe(val interface{}) string {
 	return fmt.Sprintf("Unknown (%T)", val)
 }
 
+// LookupVendor returns the name of the vendor with the given ID.
+func LookupVendor(vid uint16) (string, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	if v, ok := Vendors[vid]; ok {
+		return v.Name, true
+	}
+	return "", false
+}
+
+// LookupProduct returns the name of the product with the given vendor and
+// product ID.
+func LookupProduct(vid, pid uint16) (string, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	if v, ok := Vendors[vid]; ok {
+		if p, ok := v.Product[pid]; ok {
+			return p.Name, true
+		}
+	}
+	return "", false
+}
+
+// init registers the database as the names of zerousb.DeviceInfo.String.
+func init() {
+	zerousb.RegisterNames(func(vid, pid uint16) (string, string) {
+		vendor, _ := LookupVendor(vid)
+		product, _ := LookupProduct(vid, pid)
+		return vendor, product
+	})
+}
+
 // Classify returns a human-readable string describing the class, subclass,
 // and protocol associated with a device or interface.
 //
+// Composite devices usually declare their class per interface, leaving the
+// device level triple zeroed; in that case the interface class is used.
+//
 // The given val must be one of the following:
 //   - zerousb.DeviceInfo       "Class (SubClass) Protocol"
 func Classify(val interface{}) string {
-	var (
-		class, sub uint8
-		proto      uint8
-	)
 	switch val := val.(type) {
 	case zerousb.DeviceInfo:
-		class, sub, proto = val.Class, val.SubClass, val.Protocol
-	default:
-		return fmt.Sprintf("Unknown (%T)", val)
+		if zerousb.Class(val.Class) == zerousb.ClassPerInterface {
+			return classify(val.InterfaceClass, val.InterfaceSubClass, val.InterfaceProtocol)
+		}
+		return classify(val.Class, val.SubClass, val.Protocol)
 	}
+	return fmt.Sprintf("Unknown (%T)", val)
+}
+
+// ClassifyInterface returns a human-readable string describing the class,
+// subclass, and protocol of the interface a device was enumerated on.
+//
+// The given val must be one of the following:
+//   - zerousb.DeviceInfo       "Class (SubClass) Protocol"
+func ClassifyInterface(val interface{}) string {
+	switch val := val.(type) {
+	case zerousb.DeviceInfo:
+		return classify(val.InterfaceClass, val.InterfaceSubClass, val.InterfaceProtocol)
+	}
+	return fmt.Sprintf("Unknown (%T)", val)
+}
+
+// classify looks up the human-readable form of a class triple.
+func classify(class, sub, proto uint8) string {
+	lock.RLock()
+	defer lock.RUnlock()
 
 	if c, ok := Classes[class]; ok {
 		if s, ok := c.SubClass[sub]; ok {
@@ -0,0 +1,59 @@
+package usbid
+
+import (
+	"testing"
+
+	"github.com/chay22/zerousb"
+)
+
+// Tests that vendors and products can be looked up directly by their IDs.
+func TestLookup(t *testing.T) {
+	if name, ok := LookupVendor(0x03eb); !ok || name != "Atmel Corp." {
+		t.Errorf("vendor mismatch: have %q/%v, want %q/true", name, ok, "Atmel Corp.")
+	}
+	if name, ok := LookupProduct(0x03eb, 0x2002); !ok || name != "Mass Storage Device" {
+		t.Errorf("product mismatch: have %q/%v, want %q/true", name, ok, "Mass Storage Device")
+	}
+	if _, ok := LookupProduct(0x03eb, 0xfffe); ok {
+		t.Errorf("unknown product found")
+	}
+}
+
+// Tests that linking the package names the devices formatted by zerousb.
+func TestDeviceInfoString(t *testing.T) {
+	info := zerousb.DeviceInfo{VendorID: 0x03eb, ProductID: 0x2002, Bus: 1, Port: 4, InterfaceClass: uint8(zerousb.ClassMassStorage)}
+	if have, want := info.String(), "Bus 001 Port 4: 03eb:2002 Atmel Corp. Mass Storage Device (mass storage)"; have != want {
+		t.Errorf("string mismatch: have %q, want %q", have, want)
+	}
+}
+
+// Tests that vendors and products can be found by name fragments.
+func TestSearch(t *testing.T) {
+	matches := Search("ATMEL lufa mouse")
+	if len(matches) == 0 {
+		t.Fatalf("no matches found")
+	}
+	for _, m := range matches {
+		if m.VendorID != 0x03eb || m.Product == "" {
+			t.Errorf("unexpected match: %+v", m)
+		}
+	}
+	if matches := Search("fry's electronics"); len(matches) != 1 || matches[0].VendorID != 0x0001 || matches[0].Product != "" {
+		t.Errorf("vendor match mismatch: %+v", matches)
+	}
+	if matches := Search("  "); matches != nil {
+		t.Errorf("empty query matched: %+v", matches)
+	}
+}
+
+// Tests that per-interface classed devices are classified by their interface.
+func TestClassifyInterface(t *testing.T) {
+	info := zerousb.DeviceInfo{InterfaceClass: 0x02, InterfaceSubClass: 0x02, InterfaceProtocol: 0x00}
+
+	if have, want := Classify(info), "Communications (Abstract (modem)) None"; have != want {
+		t.Errorf("class mismatch: have %q, want %q", have, want)
+	}
+	if have, want := ClassifyInterface(info), Classify(info); have != want {
+		t.Errorf("interface class mismatch: have %q, want %q", have, want)
+	}
+}
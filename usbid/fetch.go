@@ -0,0 +1,160 @@
+package usbid
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Cache downloads usb.ids databases over HTTP, keeping a copy on disk so that
+// conditional requests (ETag / Last-Modified) can avoid refetching unchanged
+// data and so that a stale copy is available if the remote is unreachable.
+type Cache struct {
+	Dir    string       // Directory to store downloaded databases in, empty disables caching
+	Client *http.Client // HTTP client to fetch through, nil for http.DefaultClient
+}
+
+// cacheMeta is the validator metadata stored next to a cached database.
+type cacheMeta struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// DefaultCache is the cache used by LoadFromURL, storing its data in the user's
+// cache directory.
+var DefaultCache = &Cache{Dir: defaultCacheDir()}
+
+// defaultCacheDir returns the OS specific user cache folder for the package.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "zerousb", "usbid")
+}
+
+// LoadFromURL downloads a usb.ids formatted database from url through the
+// DefaultCache and replaces the currently loaded vendors and classes with it.
+func LoadFromURL(url string) error {
+	return DefaultCache.LoadFromURL(url)
+}
+
+// LoadFromURL downloads a usb.ids formatted database from url and replaces the
+// currently loaded vendors and classes with it. If the remote reports the data
+// unchanged or cannot be reached, the on-disk copy is loaded instead; an error
+// is only returned if no database could be loaded at all.
+func (c *Cache) LoadFromURL(url string) error {
+	path, meta := c.paths(url)
+
+	fetchErr := c.fetch(url, path, meta)
+	if path == "" {
+		return fetchErr
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if fetchErr != nil {
+			return fetchErr
+		}
+		return err
+	}
+	defer f.Close()
+
+	if err := Load(f); err != nil {
+		return fmt.Errorf("usbid: cached %s: %v", url, err)
+	}
+	if info, err := f.Stat(); err == nil {
+		setLastUpdate(info.ModTime())
+	}
+	return nil
+}
+
+// paths returns the on-disk location of the cached database and its metadata
+// for a given url, or empty strings if caching is disabled.
+func (c *Cache) paths(url string) (string, string) {
+	if c.Dir == "" {
+		return "", ""
+	}
+	hash := sha256.Sum256([]byte(url))
+	name := hex.EncodeToString(hash[:8])
+
+	return filepath.Join(c.Dir, name+".ids"), filepath.Join(c.Dir, name+".json")
+}
+
+// fetch retrieves the database from url. If caching is enabled, the response
+// is stored at path (unless unchanged), otherwise it's loaded directly.
+func (c *Cache) fetch(url string, path string, metaPath string) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	var meta cacheMeta
+	if path != "" {
+		if _, err := os.Stat(path); err == nil {
+			if blob, err := os.ReadFile(metaPath); err == nil {
+				json.Unmarshal(blob, &meta)
+			}
+			if meta.ETag != "" {
+				req.Header.Set("If-None-Match", meta.ETag)
+			}
+			if meta.LastModified != "" {
+				req.Header.Set("If-Modified-Since", meta.LastModified)
+			}
+		}
+	}
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("usbid: failed to fetch %s: %v", url, err)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && path != "":
+		return nil
+	case res.StatusCode != http.StatusOK:
+		return fmt.Errorf("usbid: failed to fetch %s: %s", url, res.Status)
+	}
+	if path == "" {
+		if err := Load(res.Body); err != nil {
+			return fmt.Errorf("usbid: %s: %v", url, err)
+		}
+		setLastUpdate(time.Now())
+		return nil
+	}
+	// Download into a temporary file and swap it in atomically, so a failed
+	// transfer never corrupts a previously good copy
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.Dir, "download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, res.Body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("usbid: failed to fetch %s: %v", url, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	meta = cacheMeta{
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+	}
+	blob, _ := json.Marshal(meta)
+	return os.WriteFile(metaPath, blob, 0o644)
+}
@@ -0,0 +1,49 @@
+package usbid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testIDs = "1234  Test Vendor\n\t5678  Test Product\n"
+
+// Tests that databases are cached on disk and revalidated via their ETag.
+func TestCacheLoadFromURL(t *testing.T) {
+	defer Load(strings.NewReader(usbIDListData))
+
+	var hits, revalidations int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			revalidations++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(testIDs))
+	}))
+	cache := &Cache{Dir: t.TempDir()}
+
+	for i := 0; i < 2; i++ {
+		if err := cache.LoadFromURL(srv.URL); err != nil {
+			t.Fatalf("load %d: failed to load: %v", i, err)
+		}
+		if v, ok := Vendors[0x1234]; !ok || v.Product[0x5678].Name != "Test Product" {
+			t.Fatalf("load %d: database not loaded", i)
+		}
+	}
+	if hits != 2 || revalidations != 1 {
+		t.Errorf("request mismatch: have %d hits / %d revalidations, want 2 / 1", hits, revalidations)
+	}
+	// Ensure the cached copy is used if the remote goes away
+	srv.Close()
+	Vendors = nil
+	if err := cache.LoadFromURL(srv.URL); err != nil {
+		t.Fatalf("failed to load stale copy: %v", err)
+	}
+	if _, ok := Vendors[0x1234]; !ok {
+		t.Fatalf("stale copy not loaded")
+	}
+}
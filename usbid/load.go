@@ -16,8 +16,11 @@
 package usbid
 
 import (
+	"io"
 	"log"
 	"strings"
+	"sync"
+	"time"
 )
 
 var (
@@ -26,6 +29,9 @@ var (
 
 	// Classes stores the class, subclass and protocol mappings.
 	Classes map[uint8]*Class
+
+	// lock protects the mappings from being swapped out mid-lookup.
+	lock sync.RWMutex
 )
 
 //go:generate go run regen/regen.go --template regen/load_data.go.tpl -o load_data.go
@@ -40,3 +46,95 @@ func init() {
 	Vendors = ids
 	Classes = cls
 }
+
+// Load parses a usb.ids formatted database from the reader and replaces the
+// currently loaded vendors and classes with it.
+func Load(r io.Reader) error {
+	ids, cls, err := ParseIDs(r)
+	if err != nil {
+		return err
+	}
+	lock.Lock()
+	defer lock.Unlock()
+
+	Vendors = ids
+	Classes = cls
+	return nil
+}
+
+// setLastUpdate updates the timestamp of the loaded database.
+func setLastUpdate(t time.Time) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	LastUpdate = t
+}
+
+// Merge parses a usb.ids formatted database from the reader and overlays it on
+// top of the currently loaded one. Entries in the overlay replace the names of
+// existing vendors, products and classes, and add any missing ones, which is
+// useful for in-house vendor IDs and prototype products.
+func Merge(r io.Reader) error {
+	ids, cls, err := ParseIDs(r)
+	if err != nil {
+		return err
+	}
+	lock.Lock()
+	defer lock.Unlock()
+
+	if Vendors == nil {
+		Vendors = make(map[uint16]*Vendor)
+	}
+	for vid, v := range ids {
+		old, ok := Vendors[vid]
+		if !ok {
+			Vendors[vid] = v
+			continue
+		}
+		old.Name = v.Name
+		for pid, p := range v.Product {
+			if old.Product == nil {
+				old.Product = make(map[uint16]*Product)
+			}
+			if oldp, ok := old.Product[pid]; ok {
+				oldp.Name = p.Name
+				for id, name := range p.Interface {
+					if oldp.Interface == nil {
+						oldp.Interface = make(map[uint16]string)
+					}
+					oldp.Interface[id] = name
+				}
+				continue
+			}
+			old.Product[pid] = p
+		}
+	}
+	if Classes == nil {
+		Classes = make(map[uint8]*Class)
+	}
+	for id, c := range cls {
+		old, ok := Classes[id]
+		if !ok {
+			Classes[id] = c
+			continue
+		}
+		old.Name = c.Name
+		for sid, s := range c.SubClass {
+			if old.SubClass == nil {
+				old.SubClass = make(map[uint8]*SubClass)
+			}
+			if olds, ok := old.SubClass[sid]; ok {
+				olds.Name = s.Name
+				for pid, name := range s.Protocol {
+					if olds.Protocol == nil {
+						olds.Protocol = make(map[uint8]string)
+					}
+					olds.Protocol[pid] = name
+				}
+				continue
+			}
+			old.SubClass[sid] = s
+		}
+	}
+	return nil
+}
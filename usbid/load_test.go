@@ -0,0 +1,29 @@
+package usbid
+
+import (
+	"strings"
+	"testing"
+)
+
+// Tests that overlays add new entries and rename existing ones without losing
+// the rest of the loaded database.
+func TestMerge(t *testing.T) {
+	defer Load(strings.NewReader(usbIDListData))
+
+	overlay := "03eb  Atmel (in-house)\n\tfff0  Prototype Board\n1d6c  Example Corp\n\t0001  Gizmo\n"
+	if err := Merge(strings.NewReader(overlay)); err != nil {
+		t.Fatalf("failed to merge overlay: %v", err)
+	}
+	if name, _ := LookupVendor(0x03eb); name != "Atmel (in-house)" {
+		t.Errorf("vendor rename mismatch: have %q", name)
+	}
+	if name, _ := LookupProduct(0x03eb, 0xfff0); name != "Prototype Board" {
+		t.Errorf("product addition mismatch: have %q", name)
+	}
+	if name, _ := LookupProduct(0x03eb, 0x2002); name != "Mass Storage Device" {
+		t.Errorf("existing product lost: have %q", name)
+	}
+	if name, _ := LookupProduct(0x1d6c, 0x0001); name != "Gizmo" {
+		t.Errorf("vendor addition mismatch: have %q", name)
+	}
+}
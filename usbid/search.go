@@ -0,0 +1,61 @@
+package usbid
+
+import (
+	"sort"
+	"strings"
+)
+
+// Match is a single search result, either a whole vendor (empty Product) or a
+// specific product of a vendor.
+type Match struct {
+	VendorID  uint16
+	ProductID uint16
+	Vendor    string
+	Product   string
+}
+
+// Search looks up vendors and products whose names contain every word of the
+// query, ignoring case. A product matches if the words are spread across its
+// own and its vendor's name, so "atmel storage" finds Atmel's mass storage
+// products. Results are ordered by vendor and product ID.
+func Search(query string) []Match {
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) == 0 {
+		return nil
+	}
+	lock.RLock()
+	defer lock.RUnlock()
+
+	var matches []Match
+	for vid, v := range Vendors {
+		vendor := strings.ToLower(v.Name)
+		if containsAll(vendor, words) {
+			matches = append(matches, Match{VendorID: vid, Vendor: v.Name})
+		}
+		for pid, p := range v.Product {
+			if containsAll(vendor+" "+strings.ToLower(p.Name), words) && !containsAll(vendor, words) {
+				matches = append(matches, Match{VendorID: vid, ProductID: pid, Vendor: v.Name, Product: p.Name})
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].VendorID != matches[j].VendorID {
+			return matches[i].VendorID < matches[j].VendorID
+		}
+		if matches[i].Product == "" || matches[j].Product == "" {
+			return matches[i].Product == ""
+		}
+		return matches[i].ProductID < matches[j].ProductID
+	})
+	return matches
+}
+
+// containsAll reports whether every word is a substring of s.
+func containsAll(s string, words []string) bool {
+	for _, word := range words {
+		if !strings.Contains(s, word) {
+			return false
+		}
+	}
+	return true
+}
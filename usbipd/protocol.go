@@ -0,0 +1,139 @@
+package usbipd
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Protocol version spoken by the Linux usbip tools.
+const protocolVersion = 0x0111
+
+// Operation codes of the device discovery and import phase.
+const (
+	opReqDevlist = 0x8005
+	opRepDevlist = 0x0005
+	opReqImport  = 0x8003
+	opRepImport  = 0x0003
+)
+
+// Commands of the transfer phase, after a device was imported.
+const (
+	cmdSubmit = 0x00000001
+	cmdUnlink = 0x00000002
+	retSubmit = 0x00000003
+	retUnlink = 0x00000004
+)
+
+// Linux errno values reported back as URB statuses.
+const (
+	errnoPipe       = -32
+	errnoInvalid    = -22
+	errnoConnReset  = -104
+	busIDLength     = 32
+	pathLength      = 256
+	submitHeaderLen = 48
+)
+
+// Directions of a submitted URB.
+const (
+	directionOut = 0
+	directionIn  = 1
+)
+
+// opHeader is the common header of the discovery and import operations.
+type opHeader struct {
+	Version uint16
+	Code    uint16
+	Status  uint32
+}
+
+// usbDevice is the device block of the devlist and import replies.
+type usbDevice struct {
+	Path               [pathLength]byte
+	BusID              [busIDLength]byte
+	BusNum             uint32
+	DevNum             uint32
+	Speed              uint32
+	IDVendor           uint16
+	IDProduct          uint16
+	BCDDevice          uint16
+	DeviceClass        uint8
+	DeviceSubClass     uint8
+	DeviceProtocol     uint8
+	ConfigurationValue uint8
+	NumConfigurations  uint8
+	NumInterfaces      uint8
+}
+
+// usbInterface is the interface block trailing each device of a devlist reply.
+type usbInterface struct {
+	InterfaceClass    uint8
+	InterfaceSubClass uint8
+	InterfaceProtocol uint8
+	Padding           uint8
+}
+
+// cmdHeader is the basic header shared by all transfer phase messages.
+type cmdHeader struct {
+	Command   uint32
+	SeqNum    uint32
+	DevID     uint32
+	Direction uint32
+	Endpoint  uint32
+}
+
+// submit is a CMD_SUBMIT request, without the trailing OUT payload.
+type submit struct {
+	cmdHeader
+	TransferFlags uint32
+	BufferLength  int32
+	StartFrame    int32
+	NumPackets    int32
+	Interval      int32
+	Setup         [8]byte
+}
+
+// submitReply is a RET_SUBMIT response, without the trailing IN payload.
+type submitReply struct {
+	cmdHeader
+	Status       int32
+	ActualLength int32
+	StartFrame   int32
+	NumPackets   int32
+	ErrorCount   int32
+	Padding      [8]byte
+}
+
+// unlink is a CMD_UNLINK request.
+type unlink struct {
+	cmdHeader
+	UnlinkSeqNum uint32
+	Padding      [24]byte
+}
+
+// unlinkReply is a RET_UNLINK response.
+type unlinkReply struct {
+	cmdHeader
+	Status  int32
+	Padding [24]byte
+}
+
+// readMsg decodes a fixed size big endian protocol message.
+func readMsg(r io.Reader, msg interface{}) error {
+	return binary.Read(r, binary.BigEndian, msg)
+}
+
+// putString copies a string into a fixed, zero padded protocol field.
+func putString(dst []byte, s string) {
+	copy(dst[:len(dst)-1], s)
+}
+
+// getString extracts a zero terminated string out of a fixed protocol field.
+func getString(src []byte) string {
+	for i, c := range src {
+		if c == 0 {
+			return string(src[:i])
+		}
+	}
+	return string(src)
+}
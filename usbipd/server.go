@@ -0,0 +1,523 @@
+// Package usbipd exports locally attached USB devices over the USB/IP protocol,
+// proxying the transfers of remote consumers through zerousb, so one machine
+// with the hardware can serve it to any host running the Linux vhci driver.
+//
+// A device is exported as the interface it was enumerated on: control requests
+// are forwarded as is, while IN and OUT transfers are mapped onto the interface
+// endpoints driven by the zerousb Read and Write methods.
+package usbipd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/chay22/zerousb"
+)
+
+// DefaultPort is the TCP port registered for USB/IP.
+const DefaultPort = 3240
+
+// Standard requests that need to be applied through libusb instead of being
+// forwarded, as the OS owns the device configuration.
+const (
+	requestClearFeature     = 0x01
+	requestSetConfiguration = 0x09
+	requestSetInterface     = 0x0b
+	featureEndpointHalt     = 0x00
+)
+
+// Largest buffers accepted for URBs, as the lengths come from the network. Data
+// and IN transfers beyond a megabyte are split by the client's drivers long
+// before, control transfers are bounded by wLength.
+const (
+	maxBufferLength  = 1 << 20
+	maxControlLength = 0xffff
+)
+
+// pollInterval is how long IN transfers wait on devices supporting timeouts
+// before checking whether the client is still connected.
+const pollInterval = time.Second
+
+// speedHigh is the Linux USB_SPEED_HIGH value, reported for every exported
+// device as the speed is not known without opening it.
+const speedHigh = 3
+
+// Transport is the subset of zerousb.Device needed to proxy a device.
+type Transport interface {
+	Read(b []byte) (int, error)
+	Write(b []byte) (int, error)
+	Control(rType, request uint8, val, idx uint16, data []byte) (int, error)
+	SetAltSetting(iface int, alt int) error
+	ClearHalt(endpoint uint8) error
+	Close() error
+}
+
+// Server exports devices to USB/IP clients. The zero value exports every
+// device zerousb can enumerate.
+type Server struct {
+	// Enumerate lists the devices to export, nil for all of them.
+	Enumerate func() ([]zerousb.DeviceInfo, error)
+
+	// Open connects to an exported device once a client imports it, nil to
+	// use zerousb directly.
+	Open func(info zerousb.DeviceInfo) (Transport, error)
+
+	lock sync.Mutex
+	busy map[string]bool // Bus ids currently imported by a client
+}
+
+// ListenAndServe initializes zerousb and exports all local devices on the given
+// TCP address.
+func ListenAndServe(addr string) error {
+	if err := zerousb.Init(); err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return new(Server).Serve(l)
+}
+
+// Serve accepts USB/IP clients on the listener until it fails.
+func (s *Server) Serve(l net.Listener) error {
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// exported is a device as seen by USB/IP clients.
+type exported struct {
+	busID  string
+	info   zerousb.DeviceInfo   // Interface proxied through Read and Write
+	ifaces []zerousb.DeviceInfo // All enumerated interfaces of the device
+}
+
+// busID derives the USB/IP bus id of a device, in the "bus-port.port" format of
+// the Linux usbip tools, so devices behind hubs get ids of their own. Backends
+// not reporting the port chain fall back to the port on the parent hub.
+func busID(info zerousb.DeviceInfo) string {
+	if path, err := zerousb.ParsePath(info.Path); err == nil && len(path.Ports) > 0 {
+		path.Interface = -1
+		return path.String()
+	}
+	return fmt.Sprintf("%d-%d", info.Bus, info.Port)
+}
+
+// devNum derives the device number advertised for a device from its port
+// chain, four bits per hub tier, so it's unique on the bus as long as hubs
+// have at most 15 ports, as USB 3 ones do. The address assigned by the host
+// isn't known to every backend.
+func devNum(info zerousb.DeviceInfo) uint32 {
+	path, err := zerousb.ParsePath(info.Path)
+	if err != nil || len(path.Ports) == 0 {
+		return uint32(info.Port)
+	}
+	var num uint32
+	for _, port := range path.Ports {
+		num = num<<4 | uint32(port&0x0f)
+	}
+	return num
+}
+
+// devices enumerates the exportable devices, grouping interfaces by device.
+func (s *Server) devices() ([]*exported, error) {
+	enumerate := s.Enumerate
+	if enumerate == nil {
+		enumerate = func() ([]zerousb.DeviceInfo, error) {
+			return zerousb.Enumerate(func(zerousb.DeviceInfo) bool { return true })
+		}
+	}
+	infos, err := enumerate()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		devs  []*exported
+		index = make(map[string]*exported)
+	)
+	for _, info := range infos {
+		id := busID(info)
+		if dev, ok := index[id]; ok {
+			dev.ifaces = append(dev.ifaces, info)
+			continue
+		}
+		dev := &exported{busID: id, info: info, ifaces: []zerousb.DeviceInfo{info}}
+		index[id] = dev
+		devs = append(devs, dev)
+	}
+	return devs, nil
+}
+
+// encode assembles the device block advertised for an exported device.
+func (dev *exported) encode() usbDevice {
+	var desc usbDevice
+	putString(desc.Path[:], dev.info.Path)
+	putString(desc.BusID[:], dev.busID)
+
+	desc.BusNum = uint32(dev.info.Bus)
+	desc.DevNum = devNum(dev.info)
+	desc.Speed = speedHigh
+	desc.IDVendor = dev.info.VendorID
+	desc.IDProduct = dev.info.ProductID
+	desc.BCDDevice = dev.info.Release
+	desc.DeviceClass = dev.info.Class
+	desc.DeviceSubClass = dev.info.SubClass
+	desc.DeviceProtocol = dev.info.Protocol
+	desc.ConfigurationValue = 1
+	desc.NumConfigurations = 1
+	// Alternate settings are enumerated as interfaces of their own
+	ifaces := make(map[int]bool)
+	for _, iface := range dev.ifaces {
+		ifaces[iface.Interface] = true
+	}
+	desc.NumInterfaces = uint8(len(ifaces))
+	return desc
+}
+
+// serveConn handles a single client connection, either answering a device list
+// request or proxying an imported device until the client disconnects.
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	var req opHeader
+	if err := readMsg(conn, &req); err != nil {
+		return
+	}
+	switch req.Code {
+	case opReqDevlist:
+		s.serveDevlist(conn)
+	case opReqImport:
+		var id [busIDLength]byte
+		if _, err := io.ReadFull(conn, id[:]); err != nil {
+			return
+		}
+		s.serveImport(conn, getString(id[:]))
+	}
+}
+
+// serveDevlist replies with all the exportable devices.
+func (s *Server) serveDevlist(conn net.Conn) error {
+	devs, err := s.devices()
+	if err != nil {
+		devs = nil
+	}
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, opHeader{Version: protocolVersion, Code: opRepDevlist})
+	binary.Write(buf, binary.BigEndian, uint32(len(devs)))
+	for _, dev := range devs {
+		binary.Write(buf, binary.BigEndian, dev.encode())
+		for _, iface := range dev.ifaces {
+			binary.Write(buf, binary.BigEndian, usbInterface{
+				InterfaceClass:    iface.InterfaceClass,
+				InterfaceSubClass: iface.InterfaceSubClass,
+				InterfaceProtocol: iface.InterfaceProtocol,
+			})
+		}
+	}
+	_, err = conn.Write(buf.Bytes())
+	return err
+}
+
+// serveImport attaches a client to the requested device and proxies its
+// transfers until the connection drops.
+func (s *Server) serveImport(conn net.Conn, id string) error {
+	reject := func() error {
+		return binary.Write(conn, binary.BigEndian, opHeader{Version: protocolVersion, Code: opRepImport, Status: 1})
+	}
+	devs, err := s.devices()
+	if err != nil {
+		reject()
+		return err
+	}
+	var dev *exported
+	for _, d := range devs {
+		if d.busID == id {
+			dev = d
+		}
+	}
+	if dev == nil || !s.acquire(id) {
+		return reject()
+	}
+	defer s.release(id)
+
+	open := s.Open
+	if open == nil {
+		open = func(info zerousb.DeviceInfo) (Transport, error) {
+			d, err := info.Open()
+			if err != nil {
+				return nil, err
+			}
+			return d, nil
+		}
+	}
+	t, err := open(dev.info)
+	if err != nil {
+		reject()
+		return err
+	}
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, opHeader{Version: protocolVersion, Code: opRepImport})
+	binary.Write(buf, binary.BigEndian, dev.encode())
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		t.Close()
+		return err
+	}
+	// The session closes the device once the client is gone
+	return newSession(conn, t, dev.info).run()
+}
+
+// acquire marks a bus id as imported, failing if a client already holds it.
+func (s *Server) acquire(id string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.busy == nil {
+		s.busy = make(map[string]bool)
+	}
+	if s.busy[id] {
+		return false
+	}
+	s.busy[id] = true
+	return true
+}
+
+// release makes a bus id available for import again.
+func (s *Server) release(id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.busy, id)
+}
+
+// session proxies the URBs of a single imported device.
+type session struct {
+	conn net.Conn
+	dev  Transport
+	in   uint8 // Endpoint number served by Read
+	out  uint8 // Endpoint number served by Write
+
+	lock    sync.Mutex      // Protects the pending set
+	pending map[uint32]bool // Submitted URBs not yet replied to or unlinked
+
+	writeLock sync.Mutex    // Serializes replies on the connection
+	done      chan struct{} // Closed when the client is gone
+}
+
+// timedReader is implemented by transports supporting per call read timeouts,
+// which lets queued IN transfers notice the client leaving.
+type timedReader interface {
+	ReadWithTimeout(b []byte, timeout time.Duration) (int, error)
+}
+
+// newSession creates a proxy session, mapping the endpoints zerousb picked for
+// reading and writing (the last bulk or interrupt one in each direction).
+func newSession(conn net.Conn, dev Transport, info zerousb.DeviceInfo) *session {
+	s := &session{conn: conn, dev: dev, pending: make(map[uint32]bool), done: make(chan struct{})}
+	for _, end := range info.Endpoints {
+		if tt := end.TransferType(); tt != zerousb.TransferTypeBulk && tt != zerousb.TransferTypeInterrupt {
+			continue
+		}
+		if end.Direction() == zerousb.EndpointDirectionIn {
+			s.in = end.Address & 0x0f
+		} else {
+			s.out = end.Address & 0x0f
+		}
+	}
+	return s
+}
+
+// run reads commands off the connection until it fails. Every submitted URB is
+// executed on its own goroutine, as clients routinely keep IN transfers queued
+// while issuing others. The device is closed when the client is gone, which
+// along with the polled reads unblocks the transfers still in flight.
+func (s *session) run() error {
+	var wg sync.WaitGroup
+	defer func() {
+		close(s.done)
+		s.conn.Close()
+		s.dev.Close()
+		wg.Wait()
+	}()
+
+	for {
+		raw := make([]byte, submitHeaderLen)
+		if _, err := io.ReadFull(s.conn, raw); err != nil {
+			return err
+		}
+		var hdr cmdHeader
+		readMsg(bytes.NewReader(raw), &hdr)
+
+		switch hdr.Command {
+		case cmdSubmit:
+			var req submit
+			readMsg(bytes.NewReader(raw), &req)
+
+			if req.BufferLength < 0 || req.BufferLength > maxBufferLength {
+				// Skip the payload to stay in sync, without buffering it
+				if req.Direction == directionOut && req.BufferLength > 0 {
+					if _, err := io.CopyN(io.Discard, s.conn, int64(req.BufferLength)); err != nil {
+						return err
+					}
+				}
+				if err := s.reply(submitReply{cmdHeader: cmdHeader{Command: retSubmit, SeqNum: req.SeqNum}, Status: errnoInvalid}, nil); err != nil {
+					return err
+				}
+				continue
+			}
+			var payload []byte
+			if req.Direction == directionOut && req.BufferLength > 0 {
+				payload = make([]byte, req.BufferLength)
+				if _, err := io.ReadFull(s.conn, payload); err != nil {
+					return err
+				}
+			}
+			s.lock.Lock()
+			s.pending[req.SeqNum] = true
+			s.lock.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.complete(req, payload)
+			}()
+
+		case cmdUnlink:
+			var req unlink
+			readMsg(bytes.NewReader(raw), &req)
+
+			// Synchronous transfers can't be cancelled, but an URB still in
+			// flight is dropped so the client never sees its completion
+			s.lock.Lock()
+			status := int32(0)
+			if s.pending[req.UnlinkSeqNum] {
+				delete(s.pending, req.UnlinkSeqNum)
+				status = errnoConnReset
+			}
+			s.lock.Unlock()
+
+			if err := s.reply(unlinkReply{cmdHeader: cmdHeader{Command: retUnlink, SeqNum: req.SeqNum}, Status: status}, nil); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("usbipd: unknown command %#x", hdr.Command)
+		}
+	}
+}
+
+// complete executes a submitted URB and replies with its outcome, unless the
+// client unlinked it in the meantime.
+func (s *session) complete(req submit, payload []byte) {
+	data, n, err := s.transfer(req, payload)
+
+	s.lock.Lock()
+	wanted := s.pending[req.SeqNum]
+	delete(s.pending, req.SeqNum)
+	s.lock.Unlock()
+
+	if !wanted {
+		return
+	}
+	res := submitReply{cmdHeader: cmdHeader{Command: retSubmit, SeqNum: req.SeqNum}, ActualLength: int32(n)}
+	if err != nil {
+		res.Status, res.ActualLength, data = errnoPipe, 0, nil
+	}
+	s.reply(res, data)
+}
+
+// transfer routes an URB to the device, returning any IN data along with the
+// number of bytes transferred.
+func (s *session) transfer(req submit, payload []byte) ([]byte, int, error) {
+	ep := uint8(req.Endpoint)
+
+	switch {
+	case ep == 0:
+		return s.control(req, payload)
+
+	case req.Direction == directionIn && ep == s.in:
+		buf := make([]byte, req.BufferLength)
+		n, err := s.read(buf)
+		return buf[:n], n, err
+
+	case req.Direction == directionOut && ep == s.out:
+		n, err := s.dev.Write(payload)
+		return nil, n, err
+	}
+	return nil, 0, fmt.Errorf("usbipd: endpoint %d not exported", ep)
+}
+
+// read runs an IN transfer. Devices supporting timeouts are polled, so that
+// transfers the client left queued end once it's gone.
+func (s *session) read(b []byte) (int, error) {
+	dev, ok := s.dev.(timedReader)
+	if !ok {
+		return s.dev.Read(b)
+	}
+	for {
+		n, err := dev.ReadWithTimeout(b, pollInterval)
+		if n > 0 || !errors.Is(err, zerousb.ErrTimeout) {
+			return n, err
+		}
+		select {
+		case <-s.done:
+			return 0, err
+		default:
+		}
+	}
+}
+
+// control executes a control URB, applying the standard requests that alter
+// the device state through libusb so its view stays consistent.
+func (s *session) control(req submit, payload []byte) ([]byte, int, error) {
+	var (
+		rType   = req.Setup[0]
+		request = req.Setup[1]
+		val     = binary.LittleEndian.Uint16(req.Setup[2:])
+		idx     = binary.LittleEndian.Uint16(req.Setup[4:])
+	)
+	switch {
+	case rType == zerousb.ControlOut|zerousb.ControlDevice && request == requestSetConfiguration:
+		// The host OS already configured the device for us to claim it
+		return nil, 0, nil
+
+	case rType == zerousb.ControlOut|zerousb.ControlInterface && request == requestSetInterface:
+		return nil, 0, s.dev.SetAltSetting(int(idx), int(val))
+
+	case rType == zerousb.ControlOut|zerousb.ControlEndpoint && request == requestClearFeature && val == featureEndpointHalt:
+		return nil, 0, s.dev.ClearHalt(uint8(idx))
+	}
+	if req.BufferLength > maxControlLength {
+		return nil, 0, fmt.Errorf("usbipd: control transfer of %d bytes exceeds wLength", req.BufferLength)
+	}
+	if rType&zerousb.ControlIn != 0 {
+		buf := make([]byte, req.BufferLength)
+		n, err := s.dev.Control(rType, request, val, idx, buf)
+		return buf[:n], n, err
+	}
+	n, err := s.dev.Control(rType, request, val, idx, payload)
+	return nil, n, err
+}
+
+// reply sends a response message and its payload to the client.
+func (s *session) reply(msg interface{}, payload []byte) error {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, msg)
+	buf.Write(payload)
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	_, err := s.conn.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,234 @@
+package usbipd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/chay22/zerousb"
+)
+
+// fakeDevice echoes control requests and serves a fixed IN payload.
+type fakeDevice struct {
+	written []byte
+}
+
+func (f *fakeDevice) Read(b []byte) (int, error) { return copy(b, "pong"), nil }
+func (f *fakeDevice) Write(b []byte) (int, error) {
+	f.written = append(f.written, b...)
+	return len(b), nil
+}
+func (f *fakeDevice) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	return copy(data, []byte{0x12, 0x01}), nil
+}
+func (f *fakeDevice) SetAltSetting(iface int, alt int) error { return nil }
+func (f *fakeDevice) ClearHalt(endpoint uint8) error         { return nil }
+func (f *fakeDevice) Close() error                           { return nil }
+
+// Tests that an imported device proxies control and bulk transfers.
+func TestImport(t *testing.T) {
+	info := zerousb.DeviceInfo{
+		Bus: 1, Port: 4, VendorID: 0x1234, ProductID: 0x5678,
+		Endpoints: []zerousb.EndpointInfo{
+			{Address: 0x81, Attributes: uint8(zerousb.TransferTypeBulk)},
+			{Address: 0x02, Attributes: uint8(zerousb.TransferTypeBulk)},
+		},
+	}
+	dev := new(fakeDevice)
+	srv := &Server{
+		Enumerate: func() ([]zerousb.DeviceInfo, error) { return []zerousb.DeviceInfo{info}, nil },
+		Open:      func(zerousb.DeviceInfo) (Transport, error) { return dev, nil },
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	go srv.serveConn(server)
+
+	// Import the device and check the advertised identity
+	var id [busIDLength]byte
+	putString(id[:], "1-4")
+	binary.Write(client, binary.BigEndian, opHeader{Version: protocolVersion, Code: opReqImport})
+	client.Write(id[:])
+
+	var hdr opHeader
+	var desc usbDevice
+	if err := readMsg(client, &hdr); err != nil {
+		t.Fatalf("failed to read import reply: %v", err)
+	}
+	if hdr.Status != 0 {
+		t.Fatalf("import rejected: status %d", hdr.Status)
+	}
+	readMsg(client, &desc)
+	if desc.IDVendor != 0x1234 || desc.IDProduct != 0x5678 {
+		t.Errorf("identity mismatch: have %04x:%04x, want 1234:5678", desc.IDVendor, desc.IDProduct)
+	}
+	// Submit a control IN and a bulk IN, checking both replies
+	tests := []struct {
+		ep    uint32
+		setup [8]byte
+		want  []byte
+	}{
+		{0, [8]byte{0x80, 0x06, 0x00, 0x01, 0, 0, 18, 0}, []byte{0x12, 0x01}},
+		{1, [8]byte{}, []byte("pong")},
+	}
+	for i, tt := range tests {
+		req := submit{
+			cmdHeader:    cmdHeader{Command: cmdSubmit, SeqNum: uint32(i + 1), Direction: directionIn, Endpoint: tt.ep},
+			BufferLength: 64,
+			Setup:        tt.setup,
+		}
+		binary.Write(client, binary.BigEndian, req)
+
+		var res submitReply
+		if err := readMsg(client, &res); err != nil {
+			t.Fatalf("urb %d: failed to read reply: %v", i, err)
+		}
+		if res.SeqNum != req.SeqNum || res.Status != 0 {
+			t.Fatalf("urb %d: reply mismatch: have seq %d status %d, want seq %d status 0", i, res.SeqNum, res.Status, req.SeqNum)
+		}
+		data := make([]byte, res.ActualLength)
+		io.ReadFull(client, data)
+		if !bytes.Equal(data, tt.want) {
+			t.Errorf("urb %d: data mismatch: have %x, want %x", i, data, tt.want)
+		}
+	}
+	// Submit a bulk OUT and ensure it reaches the device
+	req := submit{
+		cmdHeader:    cmdHeader{Command: cmdSubmit, SeqNum: 3, Direction: directionOut, Endpoint: 2},
+		BufferLength: 4,
+	}
+	binary.Write(client, binary.BigEndian, req)
+	client.Write([]byte("ping"))
+
+	var res submitReply
+	readMsg(client, &res)
+	if res.ActualLength != 4 || string(dev.written) != "ping" {
+		t.Errorf("write mismatch: have %q (%d), want %q", dev.written, res.ActualLength, "ping")
+	}
+}
+
+// Tests that URBs with negative or oversized buffer lengths are refused with an
+// error status instead of being allocated, and the session keeps serving.
+func TestInvalidLength(t *testing.T) {
+	info := zerousb.DeviceInfo{Endpoints: []zerousb.EndpointInfo{{Address: 0x81, Attributes: uint8(zerousb.TransferTypeBulk)}}}
+	client, server := net.Pipe()
+	defer client.Close()
+	go newSession(server, new(fakeDevice), info).run()
+
+	for i, length := range []int32{-1, maxBufferLength + 1, 4} {
+		req := submit{
+			cmdHeader:    cmdHeader{Command: cmdSubmit, SeqNum: uint32(i + 1), Direction: directionIn, Endpoint: 1},
+			BufferLength: length,
+		}
+		binary.Write(client, binary.BigEndian, req)
+
+		var res submitReply
+		if err := readMsg(client, &res); err != nil {
+			t.Fatalf("urb %d: failed to read reply: %v", i, err)
+		}
+		want := int32(errnoInvalid)
+		if length == 4 {
+			want = 0
+			io.ReadFull(client, make([]byte, res.ActualLength))
+		}
+		if res.SeqNum != req.SeqNum || res.Status != want {
+			t.Errorf("urb %d: reply mismatch: have seq %d status %d, want seq %d status %d", i, res.SeqNum, res.Status, req.SeqNum, want)
+		}
+	}
+}
+
+// blockingDevice holds reads until it's closed, like a device with nothing to
+// send and no read timeout.
+type blockingDevice struct {
+	fakeDevice
+	closed chan struct{}
+}
+
+func (f *blockingDevice) Read(b []byte) (int, error) {
+	<-f.closed
+	return 0, zerousb.ErrDeviceClosed
+}
+func (f *blockingDevice) Close() error {
+	close(f.closed)
+	return nil
+}
+
+// timedDevice times reads out without data, not being unblocked by Close like
+// zerousb devices with transfers in flight.
+type timedDevice struct {
+	fakeDevice
+	closed chan struct{}
+}
+
+func (f *timedDevice) ReadWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	time.Sleep(10 * time.Millisecond)
+	return 0, zerousb.ErrTimeout
+}
+func (f *timedDevice) Close() error {
+	close(f.closed)
+	return nil
+}
+
+// Tests that a client disconnecting with an IN URB still queued ends the
+// session, closing the device instead of waiting on the transfer forever.
+func TestDisconnectPending(t *testing.T) {
+	closed := make(chan struct{})
+	testDisconnectPending(t, &blockingDevice{closed: closed}, closed)
+
+	closed = make(chan struct{})
+	testDisconnectPending(t, &timedDevice{closed: closed}, closed)
+}
+
+func testDisconnectPending(t *testing.T, dev Transport, closed chan struct{}) {
+	info := zerousb.DeviceInfo{Endpoints: []zerousb.EndpointInfo{{Address: 0x81, Attributes: uint8(zerousb.TransferTypeBulk)}}}
+
+	client, server := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- newSession(server, dev, info).run() }()
+
+	req := submit{
+		cmdHeader:    cmdHeader{Command: cmdSubmit, SeqNum: 1, Direction: directionIn, Endpoint: 1},
+		BufferLength: 64,
+	}
+	binary.Write(client, binary.BigEndian, req)
+	client.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("session stuck on the pending transfer")
+	}
+	select {
+	case <-closed:
+	default:
+		t.Errorf("device not closed")
+	}
+}
+
+// Tests that devices behind hubs get bus ids and device numbers of their own.
+func TestBusID(t *testing.T) {
+	tests := []struct {
+		info  zerousb.DeviceInfo
+		busID string
+		num   uint32
+	}{
+		{zerousb.DeviceInfo{Path: "1-4:0", Bus: 1, Port: 4}, "1-4", 0x4},
+		{zerousb.DeviceInfo{Path: "1-4.2:1", Bus: 1, Port: 2}, "1-4.2", 0x42},
+		{zerousb.DeviceInfo{Path: "1-3.2:0", Bus: 1, Port: 2}, "1-3.2", 0x32},
+		{zerousb.DeviceInfo{Bus: 0, Port: 5}, "0-5", 5},
+	}
+	for i, tt := range tests {
+		if id := busID(tt.info); id != tt.busID {
+			t.Errorf("test %d: bus id mismatch: have %s, want %s", i, id, tt.busID)
+		}
+		if num := devNum(tt.info); num != tt.num {
+			t.Errorf("test %d: device number mismatch: have %#x, want %#x", i, num, tt.num)
+		}
+	}
+	dev := &exported{info: tests[0].info, ifaces: []zerousb.DeviceInfo{{Interface: 0}, {Interface: 0, InterfaceAlternate: 1}, {Interface: 1}}}
+	if n := dev.encode().NumInterfaces; n != 2 {
+		t.Errorf("interface count mismatch: have %d, want 2", n)
+	}
+}
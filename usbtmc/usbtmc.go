@@ -0,0 +1,290 @@
+// Package usbtmc implements the USB Test & Measurement Class on top of zerousb
+// bulk transfers, so oscilloscopes, power supplies and other instruments can
+// be scripted with SCPI strings without a VISA stack.
+package usbtmc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chay22/zerousb"
+)
+
+// Bulk message ids of the USBTMC 1.0 spec, section 3.2.
+const (
+	msgDevDepMsgOut       = 1
+	msgRequestDevDepMsgIn = 2
+	msgDevDepMsgIn        = 2
+	headerLength          = 12
+	attrEOM               = 0x01
+	attrTermCharEnabled   = 0x02
+	defaultTransferSize   = 1024 * 1024
+	abortPollInterval     = 10 * time.Millisecond
+)
+
+// Class requests of the USBTMC 1.0 spec, section 4.2.1.
+const (
+	requestInitiateAbortBulkOut    = 1
+	requestCheckAbortBulkOutStatus = 2
+	requestInitiateAbortBulkIn     = 3
+	requestCheckAbortBulkInStatus  = 4
+	requestInitiateClear           = 5
+	requestCheckClearStatus        = 6
+	requestGetCapabilities         = 7
+	requestIndicatorPulse          = 64
+)
+
+// Status values returned by the class requests.
+const (
+	statusSuccess               = 0x01
+	statusPending               = 0x02
+	statusFailed                = 0x80
+	statusTransferNotInProgress = 0x81
+)
+
+// ErrAborted is returned when a transfer failed and was aborted, leaving the
+// instrument ready for the next message.
+var ErrAborted = errors.New("usbtmc: transfer aborted")
+
+// Transport is the subset of zerousb.Device needed to speak USBTMC.
+type Transport interface {
+	Read(b []byte) (int, error)
+	Write(b []byte) (int, error)
+	Control(rType, request uint8, val, idx uint16, data []byte) (int, error)
+	ClearHalt(endpoint uint8) error
+}
+
+// Instrument is a handle to a USBTMC interface of an opened device.
+type Instrument struct {
+	dev   Transport
+	iface uint16
+	in    uint8 // Bulk IN endpoint address, recipient of the IN aborts
+	out   uint8 // Bulk OUT endpoint address, recipient of the OUT aborts
+
+	// TermChar, if non-zero, asks the instrument to end IN transfers on the
+	// given character, provided it advertises support for it.
+	TermChar byte
+
+	lock sync.Mutex // Serializes messages, USBTMC has no message interleaving
+	tag  uint8      // Tag of the last bulk message
+}
+
+// New wraps an opened device enumerated on a USBTMC interface.
+func New(dev Transport, info zerousb.DeviceInfo) (*Instrument, error) {
+	inst := &Instrument{dev: dev, iface: uint16(info.Interface)}
+
+	var in, out bool
+	for _, end := range info.Endpoints {
+		if end.TransferType() != zerousb.TransferTypeBulk {
+			continue
+		}
+		if end.Direction() == zerousb.EndpointDirectionIn && !in {
+			inst.in, in = end.Address, true
+		}
+		if end.Direction() == zerousb.EndpointDirectionOut && !out {
+			inst.out, out = end.Address, true
+		}
+	}
+	if !in || !out {
+		return nil, errors.New("usbtmc: interface lacks bulk endpoints")
+	}
+	return inst, nil
+}
+
+// nextTag advances the bulk message tag, skipping the reserved zero.
+func (inst *Instrument) nextTag() uint8 {
+	inst.tag++
+	if inst.tag == 0 {
+		inst.tag = 1
+	}
+	return inst.tag
+}
+
+// header assembles a bulk OUT message header.
+func (inst *Instrument) header(id uint8, size int, attrs uint8, term byte) []byte {
+	tag := inst.nextTag()
+
+	buf := make([]byte, headerLength)
+	buf[0] = id
+	buf[1] = tag
+	buf[2] = ^tag
+	binary.LittleEndian.PutUint32(buf[4:], uint32(size))
+	buf[8] = attrs
+	buf[9] = term
+	return buf
+}
+
+// Write sends a device dependent message (e.g. a SCPI command) to the
+// instrument. If the transfer fails, it is aborted before returning.
+func (inst *Instrument) Write(msg []byte) (int, error) {
+	inst.lock.Lock()
+	defer inst.lock.Unlock()
+
+	buf := append(inst.header(msgDevDepMsgOut, len(msg), attrEOM, 0), msg...)
+	// Messages are padded to a multiple of 4 bytes
+	for len(buf)%4 != 0 {
+		buf = append(buf, 0)
+	}
+	if _, err := inst.dev.Write(buf); err != nil {
+		inst.abortOut(inst.tag)
+		return 0, fmt.Errorf("%w: %v", ErrAborted, err)
+	}
+	return len(msg), nil
+}
+
+// Read solicits and reads a complete device dependent message from the
+// instrument, following it across transfers until the end of message bit.
+func (inst *Instrument) Read() ([]byte, error) {
+	inst.lock.Lock()
+	defer inst.lock.Unlock()
+
+	var msg []byte
+	for {
+		attrs, term := uint8(0), byte(0)
+		if inst.TermChar != 0 {
+			attrs, term = attrTermCharEnabled, inst.TermChar
+		}
+		req := inst.header(msgRequestDevDepMsgIn, defaultTransferSize, attrs, term)
+		if _, err := inst.dev.Write(req); err != nil {
+			inst.abortOut(inst.tag)
+			return nil, fmt.Errorf("%w: %v", ErrAborted, err)
+		}
+		buf := make([]byte, headerLength+defaultTransferSize)
+		n, err := inst.dev.Read(buf)
+		if err != nil {
+			inst.abortIn(inst.tag)
+			return nil, fmt.Errorf("%w: %v", ErrAborted, err)
+		}
+		if n < headerLength || buf[0] != msgDevDepMsgIn || buf[1] != inst.tag || buf[2] != ^inst.tag {
+			inst.abortIn(inst.tag)
+			return nil, fmt.Errorf("%w: malformed response header", ErrAborted)
+		}
+		size := int(binary.LittleEndian.Uint32(buf[4:]))
+		if size > n-headerLength {
+			size = n - headerLength
+		}
+		msg = append(msg, buf[headerLength:headerLength+size]...)
+
+		if buf[8]&attrEOM != 0 {
+			return msg, nil
+		}
+	}
+}
+
+// Query sends a SCPI command and returns the instrument's response, with any
+// trailing newline trimmed.
+func (inst *Instrument) Query(cmd string) (string, error) {
+	if !strings.HasSuffix(cmd, "\n") {
+		cmd += "\n"
+	}
+	if _, err := inst.Write([]byte(cmd)); err != nil {
+		return "", err
+	}
+	res, err := inst.Read()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(res), "\r\n"), nil
+}
+
+// Capabilities retrieves the raw GET_CAPABILITIES response.
+func (inst *Instrument) Capabilities() ([]byte, error) {
+	buf := make([]byte, 0x18)
+	n, err := inst.dev.Control(zerousb.ControlIn|zerousb.ControlClass|zerousb.ControlInterface, requestGetCapabilities, 0, inst.iface, buf)
+	if err != nil {
+		return nil, fmt.Errorf("usbtmc: failed to get capabilities: %w", err)
+	}
+	if n < 1 || buf[0] != statusSuccess {
+		return nil, errors.New("usbtmc: capabilities request failed")
+	}
+	return buf[:n], nil
+}
+
+// Pulse asks the instrument to flash its activity indicator, if supported.
+func (inst *Instrument) Pulse() error {
+	buf := make([]byte, 1)
+	if _, err := inst.dev.Control(zerousb.ControlIn|zerousb.ControlClass|zerousb.ControlInterface, requestIndicatorPulse, 0, inst.iface, buf); err != nil {
+		return fmt.Errorf("usbtmc: failed to pulse indicator: %w", err)
+	}
+	if buf[0] != statusSuccess {
+		return fmt.Errorf("usbtmc: indicator pulse failed with status 0x%02x", buf[0])
+	}
+	return nil
+}
+
+// Clear clears the instrument's input and output buffers, aborting any
+// message in flight.
+func (inst *Instrument) Clear() error {
+	inst.lock.Lock()
+	defer inst.lock.Unlock()
+
+	buf := make([]byte, 2)
+	if _, err := inst.dev.Control(zerousb.ControlIn|zerousb.ControlClass|zerousb.ControlInterface, requestInitiateClear, 0, inst.iface, buf[:1]); err != nil {
+		return fmt.Errorf("usbtmc: failed to initiate clear: %w", err)
+	}
+	if buf[0] != statusSuccess {
+		return fmt.Errorf("usbtmc: clear failed with status 0x%02x", buf[0])
+	}
+	for {
+		if _, err := inst.dev.Control(zerousb.ControlIn|zerousb.ControlClass|zerousb.ControlInterface, requestCheckClearStatus, 0, inst.iface, buf); err != nil {
+			return fmt.Errorf("usbtmc: failed to check clear status: %w", err)
+		}
+		if buf[0] != statusPending {
+			break
+		}
+		// Bit 0 of bmClear asks the host to drain the IN endpoint
+		if buf[1]&0x01 != 0 {
+			inst.dev.Read(make([]byte, 512))
+		}
+		time.Sleep(abortPollInterval)
+	}
+	return inst.dev.ClearHalt(inst.out)
+}
+
+// abortOut runs the INITIATE_ABORT_BULK_OUT sequence for a failed message.
+func (inst *Instrument) abortOut(tag uint8) {
+	if !inst.initiateAbort(requestInitiateAbortBulkOut, tag, inst.out) {
+		return
+	}
+	buf := make([]byte, 8)
+	for {
+		if _, err := inst.dev.Control(zerousb.ControlIn|zerousb.ControlClass|zerousb.ControlEndpoint, requestCheckAbortBulkOutStatus, 0, uint16(inst.out), buf); err != nil || buf[0] != statusPending {
+			break
+		}
+		time.Sleep(abortPollInterval)
+	}
+	inst.dev.ClearHalt(inst.out)
+}
+
+// abortIn runs the INITIATE_ABORT_BULK_IN sequence for a failed response,
+// draining the IN endpoint while the instrument is still sending.
+func (inst *Instrument) abortIn(tag uint8) {
+	if !inst.initiateAbort(requestInitiateAbortBulkIn, tag, inst.in) {
+		return
+	}
+	buf := make([]byte, 8)
+	for {
+		if _, err := inst.dev.Control(zerousb.ControlIn|zerousb.ControlClass|zerousb.ControlEndpoint, requestCheckAbortBulkInStatus, 0, uint16(inst.in), buf); err != nil || buf[0] != statusPending {
+			break
+		}
+		// Bit 0 of bmAbortBulkIn signals data still queued on the endpoint
+		if buf[1]&0x01 != 0 {
+			inst.dev.Read(make([]byte, 512))
+		}
+		time.Sleep(abortPollInterval)
+	}
+}
+
+// initiateAbort starts an abort of the transfer with the given tag, reporting
+// whether the instrument accepted it.
+func (inst *Instrument) initiateAbort(request uint8, tag uint8, endpoint uint8) bool {
+	buf := make([]byte, 2)
+	if _, err := inst.dev.Control(zerousb.ControlIn|zerousb.ControlClass|zerousb.ControlEndpoint, request, uint16(tag), uint16(endpoint), buf); err != nil {
+		return false
+	}
+	return buf[0] == statusSuccess
+}
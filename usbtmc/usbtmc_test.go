@@ -0,0 +1,61 @@
+package usbtmc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// fakeInstrument answers every solicitation with a fixed response, recording
+// the bulk OUT messages sent to it.
+type fakeInstrument struct {
+	writes   [][]byte
+	response []byte
+}
+
+func (f *fakeInstrument) Write(b []byte) (int, error) {
+	f.writes = append(f.writes, append([]byte{}, b...))
+	return len(b), nil
+}
+
+func (f *fakeInstrument) Read(b []byte) (int, error) {
+	req := f.writes[len(f.writes)-1]
+
+	msg := make([]byte, headerLength, headerLength+len(f.response))
+	msg[0], msg[1], msg[2] = msgDevDepMsgIn, req[1], req[2]
+	binary.LittleEndian.PutUint32(msg[4:], uint32(len(f.response)))
+	msg[8] = attrEOM
+	msg = append(msg, f.response...)
+
+	return copy(b, msg), nil
+}
+
+func (f *fakeInstrument) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	return 0, nil
+}
+
+func (f *fakeInstrument) ClearHalt(endpoint uint8) error { return nil }
+
+// Tests that a query frames the command and solicits the response correctly.
+func TestQuery(t *testing.T) {
+	dev := &fakeInstrument{response: []byte("RIGOL,DS1054Z\n")}
+	inst := &Instrument{dev: dev, in: 0x82, out: 0x01}
+
+	res, err := inst.Query("*IDN?")
+	if err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+	if res != "RIGOL,DS1054Z" {
+		t.Errorf("response mismatch: have %q, want %q", res, "RIGOL,DS1054Z")
+	}
+	if len(dev.writes) != 2 {
+		t.Fatalf("message count mismatch: have %d, want %d", len(dev.writes), 2)
+	}
+	want := []byte{msgDevDepMsgOut, 1, 0xfe, 0, 6, 0, 0, 0, attrEOM, 0, 0, 0, '*', 'I', 'D', 'N', '?', '\n', 0, 0}
+	if !bytes.Equal(dev.writes[0], want) {
+		t.Errorf("command mismatch: have %x, want %x", dev.writes[0], want)
+	}
+	if req := dev.writes[1]; req[0] != msgRequestDevDepMsgIn || req[1] != 2 || req[2] != 0xfd {
+		t.Errorf("solicitation header mismatch: have %x", req[:4])
+	}
+}
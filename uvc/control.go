@@ -0,0 +1,116 @@
+package uvc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/chay22/zerousb"
+)
+
+// Class requests and video streaming control selectors.
+const (
+	requestSetCur = 0x01
+	requestGetCur = 0x81
+	controlProbe  = 0x01
+	controlCommit = 0x02
+)
+
+// probeLength is the size of the UVC 1.1 probe/commit control. UVC 1.0 devices
+// answer with the 26 byte prefix, which is all this package uses.
+const probeLength = 34
+
+// probeLength10 is the size of the UVC 1.0 probe/commit control, which UVC 1.0
+// devices stall SET_CUR requests of any other length for.
+const probeLength10 = 26
+
+// Controller is the subset of zerousb.Device needed to negotiate a stream.
+type Controller interface {
+	Control(rType, request uint8, val, idx uint16, data []byte) (int, error)
+}
+
+// Probe is the stream configuration negotiated with the device.
+type Probe struct {
+	Hint           uint16 // bmHint, which fields the device should keep fixed
+	FormatIndex    uint8  // Selected format
+	FrameIndex     uint8  // Selected frame size
+	FrameInterval  uint32 // Frame interval in 100ns units
+	MaxFrameSize   uint32 // Largest frame the device will send
+	MaxPayloadSize uint32 // Largest payload the device will send in one transfer
+	ClockFrequency uint32 // Device clock frequency in Hz (UVC 1.1+)
+}
+
+// marshal encodes the probe into its wire format.
+func (p *Probe) marshal() []byte {
+	buf := make([]byte, probeLength)
+	binary.LittleEndian.PutUint16(buf[0:], p.Hint)
+	buf[2] = p.FormatIndex
+	buf[3] = p.FrameIndex
+	binary.LittleEndian.PutUint32(buf[4:], p.FrameInterval)
+	binary.LittleEndian.PutUint32(buf[18:], p.MaxFrameSize)
+	binary.LittleEndian.PutUint32(buf[22:], p.MaxPayloadSize)
+	binary.LittleEndian.PutUint32(buf[26:], p.ClockFrequency)
+	return buf
+}
+
+// unmarshal decodes the probe from its wire format.
+func (p *Probe) unmarshal(buf []byte) error {
+	if len(buf) < 26 {
+		return fmt.Errorf("uvc: short probe control: %d bytes", len(buf))
+	}
+	p.Hint = binary.LittleEndian.Uint16(buf[0:])
+	p.FormatIndex = buf[2]
+	p.FrameIndex = buf[3]
+	p.FrameInterval = binary.LittleEndian.Uint32(buf[4:])
+	p.MaxFrameSize = binary.LittleEndian.Uint32(buf[18:])
+	p.MaxPayloadSize = binary.LittleEndian.Uint32(buf[22:])
+	if len(buf) >= 30 {
+		p.ClockFrequency = binary.LittleEndian.Uint32(buf[26:])
+	}
+	return nil
+}
+
+// Negotiate runs the probe/commit sequence on a video streaming interface to
+// select a format, frame size and frame interval (in 100ns units, 0 for the
+// device default), returning the configuration the device agreed to.
+//
+// Devices streaming over isochronous endpoints additionally need an alternate
+// setting with enough bandwidth for MaxPayloadSize to be selected afterwards.
+func Negotiate(dev Controller, iface uint8, format, frame uint8, interval uint32) (*Probe, error) {
+	probe := &Probe{
+		Hint:          0x0001, // Keep the frame interval fixed
+		FormatIndex:   format,
+		FrameIndex:    frame,
+		FrameInterval: interval,
+	}
+	err := setControl(dev, iface, controlProbe, probe.marshal())
+	if errors.Is(err, zerousb.ErrPipe) {
+		// Likely a UVC 1.0 device, which only accepts the shorter control
+		err = setControl(dev, iface, controlProbe, probe.marshal()[:probeLength10])
+	}
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, probeLength)
+	n, err := dev.Control(zerousb.ControlIn|zerousb.ControlClass|zerousb.ControlInterface, requestGetCur, controlProbe<<8, uint16(iface), buf)
+	if err != nil {
+		return nil, fmt.Errorf("uvc: failed to read probe: %w", err)
+	}
+	if err := probe.unmarshal(buf[:n]); err != nil {
+		return nil, err
+	}
+	// Commit whatever the device settled on as is, including the fields this
+	// package doesn't model, in the length it understands
+	if err := setControl(dev, iface, controlCommit, buf[:n]); err != nil {
+		return nil, err
+	}
+	return probe, nil
+}
+
+// setControl issues a SET_CUR request for a video streaming control.
+func setControl(dev Controller, iface uint8, control uint16, data []byte) error {
+	if _, err := dev.Control(zerousb.ControlOut|zerousb.ControlClass|zerousb.ControlInterface, requestSetCur, control<<8, uint16(iface), data); err != nil {
+		return fmt.Errorf("uvc: failed to set control %d: %w", control, err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package uvc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/chay22/zerousb"
+)
+
+// probeDevice is a UVC 1.0 device stalling probe/commit controls longer than
+// 26 bytes, answering probes with a fixed configuration.
+type probeDevice struct {
+	probe  []byte // Probe control answered to GET_CUR
+	commit []byte // Last commit control received
+}
+
+func (d *probeDevice) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	if request == requestGetCur {
+		return copy(data, d.probe), nil
+	}
+	if len(data) != probeLength10 {
+		return 0, zerousb.ErrPipe
+	}
+	if val>>8 == controlCommit {
+		d.commit = append([]byte{}, data...)
+	}
+	return len(data), nil
+}
+
+// Tests that UVC 1.0 devices are probed with the short control, and that the
+// probe they answer is committed untouched.
+func TestNegotiate(t *testing.T) {
+	answer := make([]byte, probeLength10)
+	answer[2], answer[3] = 1, 2         // Format and frame index
+	answer[4] = 0x15                    // Frame interval
+	answer[8], answer[10] = 0x30, 0x40  // wKeyFrameRate and wPFrameRate, not modeled
+	answer[22], answer[23] = 0x00, 0x0c // Max payload size
+	dev := &probeDevice{probe: answer}
+
+	probe, err := Negotiate(dev, 1, 1, 2, 0)
+	if err != nil {
+		t.Fatalf("failed to negotiate: %v", err)
+	}
+	if probe.FormatIndex != 1 || probe.FrameIndex != 2 || probe.FrameInterval != 0x15 || probe.MaxPayloadSize != 0x0c00 {
+		t.Errorf("probe mismatch: have %+v", probe)
+	}
+	if !bytes.Equal(dev.commit, answer) {
+		t.Errorf("commit mismatch: have %x, want %x", dev.commit, answer)
+	}
+}
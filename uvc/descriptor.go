@@ -0,0 +1,133 @@
+// Package uvc implements the host side of the USB Video Class on top of
+// zerousb: parsing the video streaming descriptors, negotiating the stream
+// format through the probe/commit controls and reassembling payloads into
+// frames.
+//
+// Payloads are consumed from any source returning one payload per read, which
+// is what bulk endpoints deliver through zerousb.Device.Read.
+package uvc
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Descriptor and interface constants of the UVC 1.5 spec, appendix A.
+const (
+	classVideo           = 0x0e
+	subclassStreaming    = 0x02
+	descInterface        = 0x04
+	descCSInterface      = 0x24
+	vsFormatUncompressed = 0x04
+	vsFrameUncompressed  = 0x05
+	vsFormatMJPEG        = 0x06
+	vsFrameMJPEG         = 0x07
+)
+
+// FrameDescriptor describes a single resolution supported by a format.
+type FrameDescriptor struct {
+	Index           uint8    // bFrameIndex, used during negotiation
+	Width           uint16   // Width in pixels
+	Height          uint16   // Height in pixels
+	MaxFrameSize    uint32   // Largest frame size in bytes
+	DefaultInterval uint32   // Default frame interval in 100ns units
+	Intervals       []uint32 // Discrete intervals in 100ns units, or min, max and step for continuous ones
+	Continuous      bool     // Whether Intervals is a min/max/step range
+}
+
+// FormatDescriptor describes a video format supported by a streaming interface.
+type FormatDescriptor struct {
+	Index        uint8    // bFormatIndex, used during negotiation
+	MJPEG        bool     // Whether the format is motion JPEG, uncompressed otherwise
+	GUID         [16]byte // Pixel format GUID of uncompressed formats
+	BitsPerPixel uint8    // Bits per pixel of uncompressed formats
+	Frames       []FrameDescriptor
+}
+
+// StreamingInterface is a video streaming interface with its formats.
+type StreamingInterface struct {
+	Interface uint8 // Interface number, the recipient of the stream controls
+	Formats   []FormatDescriptor
+}
+
+// ParseDescriptors extracts the video streaming interfaces and their formats
+// from a raw configuration descriptor, as returned by Device.RawDescriptors.
+func ParseDescriptors(config []byte) ([]StreamingInterface, error) {
+	var (
+		ifaces    []StreamingInterface
+		streaming bool
+	)
+	for len(config) >= 2 {
+		length := int(config[0])
+		if length < 2 || length > len(config) {
+			return nil, fmt.Errorf("uvc: malformed descriptor of length %d", length)
+		}
+		desc := config[:length]
+		config = config[length:]
+
+		switch desc[1] {
+		case descInterface:
+			if length < 9 {
+				return nil, fmt.Errorf("uvc: short interface descriptor")
+			}
+			streaming = desc[5] == classVideo && desc[6] == subclassStreaming
+			// Alternate settings repeat the interface, only record it once
+			if streaming && (len(ifaces) == 0 || ifaces[len(ifaces)-1].Interface != desc[2]) {
+				ifaces = append(ifaces, StreamingInterface{Interface: desc[2]})
+			}
+		case descCSInterface:
+			if !streaming || length < 3 {
+				continue
+			}
+			if err := parseStreaming(&ifaces[len(ifaces)-1], desc); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ifaces, nil
+}
+
+// parseStreaming decodes a class specific video streaming descriptor into the
+// interface it belongs to.
+func parseStreaming(iface *StreamingInterface, desc []byte) error {
+	switch desc[2] {
+	case vsFormatUncompressed:
+		if len(desc) < 22 {
+			return fmt.Errorf("uvc: short uncompressed format descriptor")
+		}
+		format := FormatDescriptor{Index: desc[3], BitsPerPixel: desc[21]}
+		copy(format.GUID[:], desc[5:21])
+		iface.Formats = append(iface.Formats, format)
+
+	case vsFormatMJPEG:
+		if len(desc) < 5 {
+			return fmt.Errorf("uvc: short mjpeg format descriptor")
+		}
+		iface.Formats = append(iface.Formats, FormatDescriptor{Index: desc[3], MJPEG: true})
+
+	case vsFrameUncompressed, vsFrameMJPEG:
+		if len(iface.Formats) == 0 {
+			return fmt.Errorf("uvc: frame descriptor without format")
+		}
+		if len(desc) < 26 {
+			return fmt.Errorf("uvc: short frame descriptor")
+		}
+		frame := FrameDescriptor{
+			Index:           desc[3],
+			Width:           binary.LittleEndian.Uint16(desc[5:]),
+			Height:          binary.LittleEndian.Uint16(desc[7:]),
+			MaxFrameSize:    binary.LittleEndian.Uint32(desc[17:]),
+			DefaultInterval: binary.LittleEndian.Uint32(desc[21:]),
+		}
+		count := int(desc[25])
+		if count == 0 {
+			frame.Continuous, count = true, 3
+		}
+		for i := 0; i < count && 26+4*i+4 <= len(desc); i++ {
+			frame.Intervals = append(frame.Intervals, binary.LittleEndian.Uint32(desc[26+4*i:]))
+		}
+		format := &iface.Formats[len(iface.Formats)-1]
+		format.Frames = append(format.Frames, frame)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package uvc
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// Payload header bits, UVC 1.5 spec section 2.4.3.3.
+const (
+	headerFID = 0x01 // Frame identifier, toggles on every new frame
+	headerEOF = 0x02 // End of frame
+	headerPTS = 0x04 // Presentation time stamp present
+	headerERR = 0x40 // Error in the device streaming
+)
+
+// PayloadReader is a source of video payloads, returning exactly one payload,
+// header included, per read.
+type PayloadReader interface {
+	Read(b []byte) (int, error)
+}
+
+// Frame is a single reassembled video frame.
+type Frame struct {
+	Data  []byte // Raw frame contents in the negotiated format
+	PTS   uint32 // Presentation time stamp, if the device sent one
+	Error bool   // Whether the device flagged an error, data may be corrupt
+}
+
+// Capture reassembles payloads read from a source into frames, delivering them
+// on a channel until stopped or until reading fails.
+type Capture struct {
+	src  PayloadReader
+	size int
+
+	frames chan *Frame
+	quit   chan struct{}
+	once   sync.Once
+
+	lock sync.Mutex
+	err  error
+}
+
+// NewCapture starts reassembling frames out of payloads no larger than
+// maxPayload, the MaxPayloadSize negotiated with the device.
+func NewCapture(src PayloadReader, maxPayload int) *Capture {
+	c := &Capture{
+		src:    src,
+		size:   maxPayload,
+		frames: make(chan *Frame, 4),
+		quit:   make(chan struct{}),
+	}
+	go c.loop()
+	return c
+}
+
+// Frames returns the channel the reassembled frames are delivered on. It is
+// closed when the capture terminates.
+func (c *Capture) Frames() <-chan *Frame {
+	return c.frames
+}
+
+// Err returns the read error which terminated the capture, if any.
+func (c *Capture) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.err
+}
+
+// Stop terminates the capture. The payload read in flight, if any, still has
+// to complete or time out before the frame channel is closed.
+func (c *Capture) Stop() {
+	c.once.Do(func() { close(c.quit) })
+}
+
+// loop reads payloads and assembles them into frames.
+func (c *Capture) loop() {
+	defer close(c.frames)
+
+	var (
+		buf   = make([]byte, c.size)
+		frame = new(Frame)
+		fid   = -1
+	)
+	for {
+		select {
+		case <-c.quit:
+			return
+		default:
+		}
+		n, err := c.src.Read(buf)
+		if err != nil {
+			c.lock.Lock()
+			c.err = err
+			c.lock.Unlock()
+			return
+		}
+		if n < 2 || int(buf[0]) < 2 || int(buf[0]) > n {
+			continue // Empty or malformed payload, nothing to assemble
+		}
+		header, info := buf[:buf[0]], buf[1]
+
+		// A toggled frame id means the previous frame ended without EOF
+		if fid >= 0 && int(info&headerFID) != fid && len(frame.Data) > 0 {
+			if !c.deliver(frame) {
+				return
+			}
+			frame = new(Frame)
+		}
+		fid = int(info & headerFID)
+
+		if info&headerPTS != 0 && len(header) >= 6 {
+			frame.PTS = binary.LittleEndian.Uint32(header[2:])
+		}
+		if info&headerERR != 0 {
+			frame.Error = true
+		}
+		frame.Data = append(frame.Data, buf[len(header):n]...)
+
+		if info&headerEOF != 0 {
+			if !c.deliver(frame) {
+				return
+			}
+			frame, fid = new(Frame), -1
+		}
+	}
+}
+
+// deliver sends a frame to the consumer, reporting false if stopped meanwhile.
+func (c *Capture) deliver(frame *Frame) bool {
+	select {
+	case c.frames <- frame:
+		return true
+	case <-c.quit:
+		return false
+	}
+}
@@ -0,0 +1,51 @@
+package uvc
+
+import (
+	"errors"
+	"testing"
+)
+
+// payloads is a payload source replaying canned payloads, then failing.
+type payloads [][]byte
+
+var errDone = errors.New("done")
+
+func (p *payloads) Read(b []byte) (int, error) {
+	if len(*p) == 0 {
+		return 0, errDone
+	}
+	n := copy(b, (*p)[0])
+	*p = (*p)[1:]
+	return n, nil
+}
+
+// Tests that frames are split both on the EOF bit and on frame id toggles.
+func TestCapture(t *testing.T) {
+	src := &payloads{
+		{2, 0x00, 'a', 'b'},
+		{2, 0x02, 'c'},      // EOF terminates the first frame
+		{2, 0x01, 'd'},      // New frame id
+		{2, 0x40, 'e', 'f'}, // Toggled id without EOF, flagged erroneous
+		{2, 0x02},
+	}
+	capture := NewCapture(src, 16)
+
+	var frames []*Frame
+	for frame := range capture.Frames() {
+		frames = append(frames, frame)
+	}
+	if capture.Err() != errDone {
+		t.Fatalf("termination error mismatch: have %v, want %v", capture.Err(), errDone)
+	}
+	if len(frames) != 3 {
+		t.Fatalf("frame count mismatch: have %d, want 3", len(frames))
+	}
+	for i, want := range []string{"abc", "d", "ef"} {
+		if string(frames[i].Data) != want {
+			t.Errorf("frame %d: data mismatch: have %q, want %q", i, frames[i].Data, want)
+		}
+	}
+	if frames[0].Error || frames[1].Error || !frames[2].Error {
+		t.Errorf("error flags mismatch: %v %v %v", frames[0].Error, frames[1].Error, frames[2].Error)
+	}
+}
@@ -0,0 +1,81 @@
+package zerousb
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// VersionInfo describes the backend the package was built with and the build
+// itself, so bug reports and support tooling can capture the environment.
+type VersionInfo struct {
+	Backend string // Backend in use: libusb, iokit, winusb, webusb, none on unsupported platforms, or the name of a registered one
+	Libusb  string // Version of the linked libusb, e.g. 1.0.26.11724, empty for other backends
+
+	Go   string   // Version of the Go toolchain
+	OS   string   // Target operating system
+	Arch string   // Target architecture
+	Cgo  bool     // Whether cgo was enabled, if the build recorded it
+	Tags []string // Build tags, if the build recorded them
+
+	Capabilities []Capability // Optional features the backend supports
+}
+
+// Version reports the backend the package was built with, the version of
+// libusb if it's the one in use, and the build settings.
+func Version() VersionInfo {
+	lock.Lock()
+	defer lock.Unlock()
+
+	v := VersionInfo{
+		Go:   runtime.Version(),
+		OS:   runtime.GOOS,
+		Arch: runtime.GOARCH,
+	}
+	v.Backend, v.Libusb = backendVersion()
+	if backendName != "" {
+		v.Backend, v.Libusb = backendName, ""
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "CGO_ENABLED":
+				v.Cgo = setting.Value == "1"
+			case "-tags":
+				v.Tags = strings.Split(setting.Value, ",")
+			}
+		}
+	}
+	for _, c := range []Capability{CapabilityHotplug, CapabilityHIDAccess, CapabilityDetachKernelDriver} {
+		if backendName == "" && hasCapability(c) {
+			v.Capabilities = append(v.Capabilities, c)
+		}
+	}
+	return v
+}
+
+// String formats the version info on a single line, e.g. libusb 1.0.26.11724
+// (linux/amd64, go1.21.0, cgo, tags netgo,osusergo, capabilities hotplug).
+func (v VersionInfo) String() string {
+	backend := v.Backend
+	if v.Libusb != "" {
+		backend += " " + v.Libusb
+	}
+	details := []string{v.OS + "/" + v.Arch, v.Go}
+	if v.Cgo {
+		details = append(details, "cgo")
+	}
+	if len(v.Tags) > 0 {
+		details = append(details, "tags "+strings.Join(v.Tags, ","))
+	}
+	if len(v.Capabilities) > 0 {
+		caps := make([]string, len(v.Capabilities))
+		for i, c := range v.Capabilities {
+			caps[i] = c.String()
+		}
+		details = append(details, "capabilities "+strings.Join(caps, ","))
+	}
+	return fmt.Sprintf("%s (%s)", backend, strings.Join(details, ", "))
+}
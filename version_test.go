@@ -0,0 +1,30 @@
+package zerousb
+
+import (
+	"runtime"
+	"testing"
+)
+
+// Tests that the version info is formatted on a single line, leaving out the
+// details not known.
+func TestVersionString(t *testing.T) {
+	tests := []struct {
+		info VersionInfo
+		want string
+	}{
+		{VersionInfo{Backend: "winusb", Go: "go1.18", OS: "windows", Arch: "amd64"}, "winusb (windows/amd64, go1.18)"},
+		{
+			VersionInfo{Backend: "libusb", Libusb: "1.0.26.11724", Go: "go1.21.0", OS: "linux", Arch: "arm64", Cgo: true,
+				Tags: []string{"netgo", "osusergo"}, Capabilities: []Capability{CapabilityHotplug, CapabilityHIDAccess}},
+			"libusb 1.0.26.11724 (linux/arm64, go1.21.0, cgo, tags netgo,osusergo, capabilities hotplug,hid access)",
+		},
+	}
+	for i, tt := range tests {
+		if have := tt.info.String(); have != tt.want {
+			t.Errorf("test %d: string mismatch: have %q, want %q", i, have, tt.want)
+		}
+	}
+	if v := Version(); v.Backend == "" || v.OS != runtime.GOOS || v.Go != runtime.Version() {
+		t.Errorf("version mismatch: have %+v", v)
+	}
+}
@@ -0,0 +1,59 @@
+package zerousb
+
+import (
+	"context"
+	"time"
+)
+
+// waitPollInterval is the period devices are enumerated at by WaitForDevice on
+// backends without hotplug notifications.
+const waitPollInterval = 250 * time.Millisecond
+
+// WaitForDevice blocks until a device interface accepted by the filter is
+// attached, returning it right away if one already is. Devices are enumerated
+// again on every hotplug arrival, or periodically on backends without hotplug
+// support. It returns the error of the context if it's done first.
+func WaitForDevice(ctx context.Context, filter Filter) (DeviceInfo, error) {
+	// Subscribe before the first enumeration, so no arrival slips in between
+	arrived := make(chan struct{}, 1)
+	cancel, err := OnHotplug(func(event HotplugEvent, info DeviceInfo) {
+		if event != DeviceArrived {
+			return
+		}
+		select {
+		case arrived <- struct{}{}:
+		default:
+		}
+	})
+	var poll <-chan time.Time
+	if err != nil {
+		logger().Debug("usb: hotplug unavailable, polling for device", "err", err)
+
+		ticker := time.NewTicker(waitPollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	} else {
+		defer cancel()
+	}
+	return waitFor(ctx, func() ([]DeviceInfo, error) { return EnumerateAll(filter) }, arrived, poll)
+}
+
+// waitFor enumerates until an interface is found, again whenever a device
+// arrives or the poll ticks, until the context is done.
+func waitFor(ctx context.Context, enumerate func() ([]DeviceInfo, error), arrived <-chan struct{}, poll <-chan time.Time) (DeviceInfo, error) {
+	for {
+		infos, err := enumerate()
+		if err != nil {
+			return DeviceInfo{}, err
+		}
+		if len(infos) > 0 {
+			return infos[0], nil
+		}
+		select {
+		case <-ctx.Done():
+			return DeviceInfo{}, ctx.Err()
+		case <-arrived:
+		case <-poll:
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package zerousb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Tests that devices are enumerated again on arrivals and poll ticks until one
+// is found, and that waiting stops with the context.
+func TestWaitFor(t *testing.T) {
+	arrived := make(chan struct{}, 1)
+	poll := make(chan time.Time, 1)
+
+	var calls int
+	enumerate := func() ([]DeviceInfo, error) {
+		calls++
+		switch calls {
+		case 1:
+			arrived <- struct{}{}
+		case 2:
+			poll <- time.Now()
+		case 3:
+			return []DeviceInfo{{Path: "1-2:0"}, {Path: "1-2:1"}}, nil
+		}
+		return nil, nil
+	}
+	info, err := waitFor(context.Background(), enumerate, arrived, poll)
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	if info.Path != "1-2:0" || calls != 3 {
+		t.Errorf("wait mismatch: have %s after %d enumerations, want 1-2:0 after 3", info.Path, calls)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := waitFor(ctx, func() ([]DeviceInfo, error) { return nil, nil }, nil, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("timeout error mismatch: have %v, want %v", err, context.DeadlineExceeded)
+	}
+	failure := errors.New("enumeration failed")
+	if _, err := waitFor(context.Background(), func() ([]DeviceInfo, error) { return nil, failure }, nil, nil); err != failure {
+		t.Errorf("enumeration error mismatch: have %v, want %v", err, failure)
+	}
+}
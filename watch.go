@@ -0,0 +1,157 @@
+package zerousb
+
+import "sync"
+
+// watchBuffer is the number of events buffered by Watch for slow consumers.
+const watchBuffer = 16
+
+// DeviceEvent is the attachment or detachment of a device reported by Watch.
+type DeviceEvent struct {
+	Event HotplugEvent // DeviceArrived or DeviceLeft
+	Info  DeviceInfo   // First interface of the device accepted by the filter
+}
+
+// watcher turns hotplug notifications into events of the devices accepted by
+// a filter. Notifications are queued by the hotplug handler without blocking
+// and processed on a goroutine of their own, which may enumerate.
+type watcher struct {
+	interfaces func(DeviceInfo) ([]DeviceInfo, error) // Enumerates the accepted interfaces of an arrived device
+	known      map[string]DeviceInfo                  // Reported devices by path, to report their departure
+
+	queue  []DeviceEvent // Notifications not processed yet
+	notify chan struct{} // Signaled when a notification is queued
+	lock   sync.Mutex
+
+	events chan DeviceEvent // Delivers the events, closed once stopped
+	stop   chan struct{}    // Closed to terminate the goroutine
+	done   chan struct{}    // Closed when the goroutine terminated
+}
+
+// Watch reports the attachment and detachment of devices with an interface
+// accepted by the filter on the returned channel, until the returned function
+// is called, which closes the channel. Devices already attached are not
+// reported, though their detachment is.
+//
+// Up to 16 events are buffered, notifications are queued meanwhile, so none
+// are lost while the consumer catches up. Backends without hotplug
+// notifications are polled, see SetHotplugPollInterval.
+func Watch(filter Filter) (<-chan DeviceEvent, func(), error) {
+	w := newWatcher(func(info DeviceInfo) ([]DeviceInfo, error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return enumerate(func(iface DeviceInfo) bool { return sameDevice(info, iface) && filter.Match(iface) }, filter.HID)
+	})
+	// Subscribe before listing the attached devices, so none slip in between
+	cancel, err := OnHotplug(func(event HotplugEvent, info DeviceInfo) {
+		w.push(DeviceEvent{Event: event, Info: info})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	infos, err := EnumerateAll(filter)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	for _, info := range infos {
+		if _, ok := w.known[devicePath(info.Path)]; !ok {
+			w.known[devicePath(info.Path)] = info
+		}
+	}
+	go w.loop()
+
+	var once sync.Once
+	return w.events, func() {
+		once.Do(func() {
+			cancel()
+			close(w.stop)
+			<-w.done
+		})
+	}, nil
+}
+
+// newWatcher creates a watcher enumerating arrived devices through interfaces.
+func newWatcher(interfaces func(DeviceInfo) ([]DeviceInfo, error)) *watcher {
+	return &watcher{
+		interfaces: interfaces,
+		known:      make(map[string]DeviceInfo),
+		notify:     make(chan struct{}, 1),
+		events:     make(chan DeviceEvent, watchBuffer),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// push queues a hotplug notification without blocking.
+func (w *watcher) push(event DeviceEvent) {
+	w.lock.Lock()
+	w.queue = append(w.queue, event)
+	w.lock.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// loop processes the queued notifications into events until stopped.
+func (w *watcher) loop() {
+	defer close(w.done)
+	defer close(w.events)
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.notify:
+		}
+		w.lock.Lock()
+		queue := w.queue
+		w.queue = nil
+		w.lock.Unlock()
+
+		for _, notification := range queue {
+			event, ok := w.process(notification)
+			if !ok {
+				continue
+			}
+			select {
+			case w.events <- event:
+			case <-w.stop:
+				return
+			}
+		}
+	}
+}
+
+// process turns a hotplug notification into the event to report, if any.
+func (w *watcher) process(notification DeviceEvent) (DeviceEvent, bool) {
+	path := notification.Info.Path
+
+	switch notification.Event {
+	case DeviceArrived:
+		if _, ok := w.known[path]; ok {
+			return DeviceEvent{}, false
+		}
+		ifaces, err := w.interfaces(notification.Info)
+		if err != nil {
+			logger().Warn("usb: failed to enumerate arrived device", "device", path, "err", err)
+			return DeviceEvent{}, false
+		}
+		if len(ifaces) == 0 {
+			return DeviceEvent{}, false
+		}
+		w.known[path] = ifaces[0]
+		return DeviceEvent{Event: DeviceArrived, Info: ifaces[0]}, true
+
+	case DeviceLeft:
+		info, ok := w.known[path]
+		if !ok {
+			return DeviceEvent{}, false
+		}
+		delete(w.known, path)
+		return DeviceEvent{Event: DeviceLeft, Info: info}, true
+	}
+	return DeviceEvent{}, false
+}
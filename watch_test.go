@@ -0,0 +1,55 @@
+package zerousb
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that hotplug notifications are reported for accepted devices only, and
+// departures only for devices known to the watcher.
+func TestWatcher(t *testing.T) {
+	w := newWatcher(func(info DeviceInfo) ([]DeviceInfo, error) {
+		if info.VendorID != 0x1209 {
+			return nil, nil
+		}
+		iface := info
+		iface.Path = pathWithInterface(info.Path, 1)
+		return []DeviceInfo{iface}, nil
+	})
+	w.known["1-1"] = DeviceInfo{Path: "1-1:0", VendorID: 0x1209}
+	go w.loop()
+
+	for _, n := range []DeviceEvent{
+		{Event: DeviceArrived, Info: DeviceInfo{Path: "1-2", VendorID: 0x1234}}, // Rejected by the filter
+		{Event: DeviceArrived, Info: DeviceInfo{Path: "1-3", VendorID: 0x1209}},
+		{Event: DeviceArrived, Info: DeviceInfo{Path: "1-1", VendorID: 0x1209}}, // Known already
+		{Event: DeviceLeft, Info: DeviceInfo{Path: "1-2", VendorID: 0x1234}},
+		{Event: DeviceLeft, Info: DeviceInfo{Path: "1-1", VendorID: 0x1209}},
+		{Event: DeviceLeft, Info: DeviceInfo{Path: "1-3", VendorID: 0x1209}},
+	} {
+		w.push(n)
+	}
+	want := []DeviceEvent{
+		{Event: DeviceArrived, Info: DeviceInfo{Path: "1-3:1", VendorID: 0x1209}},
+		{Event: DeviceLeft, Info: DeviceInfo{Path: "1-1:0", VendorID: 0x1209}},
+		{Event: DeviceLeft, Info: DeviceInfo{Path: "1-3:1", VendorID: 0x1209}},
+	}
+	for i, want := range want {
+		select {
+		case have := <-w.events:
+			if have.Event != want.Event || have.Info.Path != want.Info.Path {
+				t.Errorf("event %d: mismatch: have %v %s, want %v %s", i, have.Event, have.Info.Path, want.Event, want.Info.Path)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d: not delivered", i)
+		}
+	}
+	close(w.stop)
+	<-w.done
+	if _, ok := <-w.events; ok {
+		t.Errorf("event delivered after stop")
+	}
+	if len(w.known) != 0 {
+		t.Errorf("known devices remaining: %v", w.known)
+	}
+}
@@ -0,0 +1,864 @@
+//go:build js && wasm
+
+package zerousb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"syscall/js"
+	"time"
+)
+
+// usb is the browser WebUSB entry point, undefined if the API is unavailable
+// (e.g. insecure origins or browsers without WebUSB support).
+var usb = js.Global().Get("navigator").Get("usb")
+
+// webusbDevice is a device opened through the browser WebUSB API.
+type webusbDevice struct {
+	DeviceInfo // The device info that was used to open the device
+	lifetime   // Signals the device being closed or disconnected
+
+	dev     js.Value     // USBDevice object of the browser
+	claimed map[int]bool // Interfaces claimed via ClaimInterface, released on Close
+	alts    map[int]int  // Alternate settings activated on the interfaces, restored after resets
+	closed  bool         // Whether the device was closed already
+	retry   *RetryPolicy // Retry policy of Read and Write, nil for none
+	metrics Metrics      // Metrics of Read and Write, nil for none
+	try     tryEmulation // Transfers of TryRead and TryWrite
+
+	lock        sync.RWMutex // Guards the device state, held shared by transfers
+	readLock    sync.Mutex   // Serializes transfers on the IN endpoint
+	writeLock   sync.Mutex   // Serializes transfers on the OUT endpoint
+	controlLock sync.Mutex   // Serializes control requests
+}
+
+// errStall is returned by transfers the device answered with a stall.
+var errStall = fmt.Errorf("endpoint stalled: %w", ErrPipe)
+
+// domError is a DOMException a WebUSB promise was rejected with.
+type domError struct {
+	name    string // Exception name, e.g. NetworkError
+	message string // Rendered exception
+}
+
+// Error implements the error interface.
+func (e *domError) Error() string {
+	return e.message
+}
+
+// Is reports whether the exception corresponds to a portable sentinel error.
+func (e *domError) Is(target error) bool {
+	switch e.name {
+	case "NotFoundError":
+		return target == ErrNoDevice
+	case "NetworkError":
+		return target == ErrIO
+	case "SecurityError":
+		return target == ErrAccess
+	case "AbortError":
+		return target == ErrInterrupted
+	case "TimeoutError":
+		return target == ErrTimeout
+	case "NotSupportedError":
+		return target == ErrNotSupported
+	}
+	return false
+}
+
+// await blocks until a JavaScript promise settles, returning its value or the
+// rejection reason as an error. It must not be called from the JavaScript
+// event loop itself (i.e. from within a js.FuncOf callback).
+func await(promise js.Value) (js.Value, error) {
+	var (
+		result = make(chan js.Value, 1)
+		failed = make(chan js.Value, 1)
+	)
+	onResolve := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		result <- args[0]
+		return nil
+	})
+	defer onResolve.Release()
+
+	onReject := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		failed <- args[0]
+		return nil
+	})
+	defer onReject.Release()
+
+	promise.Call("then", onResolve, onReject)
+	select {
+	case res := <-result:
+		return res, nil
+	case err := <-failed:
+		rejection := &domError{message: err.Call("toString").String()}
+		if err.Type() == js.TypeObject {
+			rejection.name = err.Get("name").String()
+		}
+		return js.Undefined(), rejection
+	}
+}
+
+// RequestDevice prompts the user to grant the page access to a device matching
+// the given vendor and product id (zero matching anything) and returns its
+// interfaces. It must be called in response to a user gesture; afterwards the
+// device is also reported by Enumerate and Find.
+func RequestDevice(vendorID ID, productID ID) ([]DeviceInfo, error) {
+	if usb.IsUndefined() {
+		return nil, ErrUnsupportedPlatform
+	}
+	filter := map[string]interface{}{}
+	if vendorID != 0 {
+		filter["vendorId"] = int(vendorID)
+	}
+	if productID != 0 {
+		filter["productId"] = int(productID)
+	}
+	dev, err := await(usb.Call("requestDevice", map[string]interface{}{
+		"filters": []interface{}{filter},
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to request device: %w", err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+
+	return describeDevice(dev, 0, func(DeviceInfo) bool { return true }, true), nil
+}
+
+// getAllDevices lists the interfaces of all devices the page was granted
+// access to and which are accepted by the match function.
+func getAllDevices(match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	if usb.IsUndefined() {
+		return nil, ErrUnsupportedPlatform
+	}
+	list, err := await(usb.Call("getDevices"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	var infos []DeviceInfo
+	for i := 0; i < list.Length(); i++ {
+		infos = append(infos, describeDevice(list.Index(i), i, match, hid)...)
+	}
+	return infos, nil
+}
+
+// describeDevice converts the interfaces of a WebUSB device into the infos
+// that can be opened, mirroring the rules of the libusb enumeration.
+func describeDevice(dev js.Value, index int, match func(DeviceInfo) bool, hid bool) []DeviceInfo {
+	// Skip HID devices, they are handled directly by OS libraries
+	if !hid && dev.Get("deviceClass").Int() == int(ClassHID) {
+		return nil
+	}
+	// Browsers only report the configuration after opening, fall back to the
+	// first one which is selected when opening otherwise
+	cfg := dev.Get("configuration")
+	if cfg.IsNull() || cfg.IsUndefined() {
+		if cfgs := dev.Get("configurations"); cfgs.Length() > 0 {
+			cfg = cfgs.Index(0)
+		} else {
+			return nil
+		}
+	}
+	port := uint8(index)
+
+	var infos []DeviceInfo
+	ifaces := cfg.Get("interfaces")
+	for i := 0; i < ifaces.Length(); i++ {
+		iface := ifaces.Index(i)
+
+		alts := iface.Get("alternates")
+		for j := 0; j < alts.Length(); j++ {
+			alt := alts.Index(j)
+
+			// Skip HID interfaces, they are handled directly by OS libraries
+			if !hid && alt.Get("interfaceClass").Int() == int(ClassHID) {
+				continue
+			}
+			var reader, writer *uint8
+			var readerTransferType, writerTransferType uint8
+			var endpoints []EndpointInfo
+
+			ends := alt.Get("endpoints")
+			for k := 0; k < ends.Length(); k++ {
+				end := ends.Index(k)
+
+				address := uint8(end.Get("endpointNumber").Int())
+				if end.Get("direction").String() == "in" {
+					address |= endpointDirectionMask
+				}
+				var kind TransferType
+				switch end.Get("type").String() {
+				case "bulk":
+					kind = TransferTypeBulk
+				case "interrupt":
+					kind = TransferTypeInterrupt
+				case "isochronous":
+					kind = TransferTypeIsochronous
+				}
+				endpoints = append(endpoints, EndpointInfo{
+					Address:       address,
+					Attributes:    uint8(kind),
+					MaxPacketSize: uint16(end.Get("packetSize").Int()),
+				})
+				// Skip any non-interrupt and bulk endpoints
+				if kind != TransferTypeInterrupt && kind != TransferTypeBulk {
+					continue
+				}
+				if address&endpointDirectionMask != 0 {
+					reader, readerTransferType = new(uint8), uint8(kind)
+					*reader = address
+				} else {
+					writer, writerTransferType = new(uint8), uint8(kind)
+					*writer = address
+				}
+			}
+			// If both in and out endpoints are available, match the device
+			if reader == nil || writer == nil {
+				continue
+			}
+			vid, pid := uint16(dev.Get("vendorId").Int()), uint16(dev.Get("productId").Int())
+			info := DeviceInfo{
+				Path:         DevicePath{Ports: []uint8{port + 1}, Interface: iface.Get("interfaceNumber").Int()}.String(),
+				VendorID:     vid,
+				ProductID:    pid,
+				Release:      uint16(dev.Get("deviceVersionMajor").Int()<<8 | dev.Get("deviceVersionMinor").Int()<<4 | dev.Get("deviceVersionSubminor").Int()),
+				USBVersion:   uint16(dev.Get("usbVersionMajor").Int()<<8 | dev.Get("usbVersionMinor").Int()<<4 | dev.Get("usbVersionSubminor").Int()),
+				Serial:       jsString(dev.Get("serialNumber")),
+				Manufacturer: jsString(dev.Get("manufacturerName")),
+				Product:      jsString(dev.Get("productName")),
+				Class:        uint8(dev.Get("deviceClass").Int()),
+				SubClass:     uint8(dev.Get("deviceSubclass").Int()),
+				Protocol:     uint8(dev.Get("deviceProtocol").Int()),
+				Port:         port,
+
+				Interface:          iface.Get("interfaceNumber").Int(),
+				InterfaceNumber:    iface.Get("interfaceNumber").Int(),
+				InterfaceAlternate: alt.Get("alternateSetting").Int(),
+				InterfaceClass:     uint8(alt.Get("interfaceClass").Int()),
+				InterfaceSubClass:  uint8(alt.Get("interfaceSubclass").Int()),
+				InterfaceProtocol:  uint8(alt.Get("interfaceProtocol").Int()),
+				Endpoints:          endpoints,
+				Config:             ConfigInfo{Value: uint8(cfg.Get("configurationValue").Int())},
+
+				libusbDevice:       dev,
+				libusbPort:         &port,
+				libusbReader:       reader,
+				libusbWriter:       writer,
+				readerTransferType: &readerTransferType,
+				writerTransferType: &writerTransferType,
+			}
+			if match(info) {
+				infos = append(infos, info)
+			}
+		}
+	}
+	return infos
+}
+
+// jsString converts an optional JavaScript string into Go, nulls being empty.
+func jsString(v js.Value) string {
+	if v.Type() != js.TypeString {
+		return ""
+	}
+	return v.String()
+}
+
+// listDevices lists the devices by collapsing their enumerated interfaces, as
+// the interfaces are what the backend discovers.
+func listDevices(match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	return listByInterface(match, hid)
+}
+
+// describeInterfaces enumerates the interfaces of a listed device.
+func describeInterfaces(info DeviceInfo, hid bool) ([]DeviceInfo, error) {
+	return interfacesOf(info, hid)
+}
+
+// configure ignores the options, which only apply to libusb.
+func configure(opts contextOptions) error {
+	return nil
+}
+
+// initContext has nothing to initialize, WebUSB is reached without a context.
+func initContext() error {
+	return nil
+}
+
+// backendVersion reports WebUSB as the backend, libusb isn't involved.
+func backendVersion() (string, string) {
+	return "webusb", ""
+}
+
+// exit has no global state to release, devices are closed individually.
+func exit() error {
+	return nil
+}
+
+// open connects to a WebUSB device, selecting its configuration and claiming
+// the interface the info was enumerated on.
+func open(info DeviceInfo, opts *openOptions) (*webusbDevice, error) {
+	dev, ok := info.libusbDevice.(js.Value)
+	if !ok {
+		return nil, fmt.Errorf("failed to open device: %w", ErrNoDevice)
+	}
+	if !dev.Get("opened").Bool() {
+		if _, err := await(dev.Call("open")); err != nil {
+			return nil, fmt.Errorf("failed to open device: %w", err)
+		}
+	}
+	if cfg := dev.Get("configuration"); cfg.IsNull() || cfg.IsUndefined() {
+		value := dev.Get("configurations").Index(0).Get("configurationValue")
+		if _, err := await(dev.Call("selectConfiguration", value)); err != nil {
+			dev.Call("close")
+			return nil, fmt.Errorf("failed to select configuration: %w", err)
+		}
+	}
+	if _, err := await(dev.Call("claimInterface", info.Interface)); err != nil {
+		dev.Call("close")
+		return nil, fmt.Errorf("failed to claim interface: %w", err)
+	}
+	if info.InterfaceAlternate != 0 {
+		if _, err := await(dev.Call("selectAlternateInterface", info.Interface, info.InterfaceAlternate)); err != nil {
+			dev.Call("close")
+			return nil, fmt.Errorf("failed to select alternate setting: %w", err)
+		}
+	}
+	wdev := &webusbDevice{DeviceInfo: info, dev: dev}
+
+	// Release the interface once a call finds the device gone
+	wdev.OnDisconnect(func() { wdev.Close() })
+	return wdev, nil
+}
+
+// openPath is unsupported, there are no usbfs nodes.
+func openPath(path string, opts *openOptions) (Device, DeviceInfo, error) {
+	return nil, DeviceInfo{}, fmt.Errorf("failed to open device: %w", ErrNotSupported)
+}
+
+// openParentHub is unsupported, browsers don't expose hubs.
+func openParentHub(info DeviceInfo) (*parentHub, error) {
+	return nil, fmt.Errorf("failed to open parent hub: %w", ErrNotSupported)
+}
+
+// topology is unsupported, browsers don't expose hubs.
+func topology() ([]*TopologyNode, error) {
+	return nil, fmt.Errorf("failed to list topology: %w", ErrNotSupported)
+}
+
+// openFD is unsupported, browsers don't expose file descriptors.
+func openFD(fd int) (*webusbDevice, error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+// hasCapability reports false, the WebUSB backend has none of the optional capabilities, browsers keep
+// HID devices and driver bindings to themselves.
+func hasCapability(capability Capability) bool {
+	return false
+}
+
+// onHotplug is unsupported, the WebUSB connect and disconnect events are not
+// wired up yet.
+func onHotplug(handler HotplugHandler) (func(), error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+// Close releases the claimed interfaces and the device.
+func (dev *webusbDevice) Close() error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.closed {
+		return nil
+	}
+	for iface := range dev.claimed {
+		await(dev.dev.Call("releaseInterface", iface))
+	}
+	await(dev.dev.Call("releaseInterface", dev.Interface))
+	dev.closed = true
+	dev.end(ErrDeviceClosed)
+
+	if _, err := await(dev.dev.Call("close")); err != nil {
+		return fmt.Errorf("failed to close device: %w", err)
+	}
+	return nil
+}
+
+// Write sends a binary blob to an USB device. Writes only exclude each other,
+// so they may run concurrently with reads and control requests.
+func (dev *webusbDevice) Write(b []byte) (int, error) {
+	return dev.write(net.Buffers{b})
+}
+
+// WriteV sends the buffers to an USB device as if they were concatenated,
+// transferring whole packets straight from the buffers.
+func (dev *webusbDevice) WriteV(bufs net.Buffers) (int, error) {
+	return dev.write(bufs)
+}
+
+// write sends the buffers through consecutive transfers.
+func (dev *webusbDevice) write(bufs net.Buffers) (int, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+	dev.writeLock.Lock()
+	defer dev.writeLock.Unlock()
+
+	if dev.closed {
+		return 0, dev.closedErr()
+	}
+	start := time.Now()
+	n, err := writeVectored(bufs, dev.writePacket(), func(b []byte) (int, error) {
+		return dev.retry.run(transientError, retryObserver(dev.metrics, EndpointDirectionOut), func() (int, error) {
+			res, err := await(dev.dev.Call("transferOut", int(*dev.libusbWriter&endpointNumMask), toUint8Array(b)))
+			if err != nil {
+				return 0, err
+			}
+			if err := dev.transferStatus(res, *dev.libusbWriter); err != nil {
+				return res.Get("bytesWritten").Int(), err
+			}
+			return res.Get("bytesWritten").Int(), nil
+		})
+	})
+	if err != nil {
+		err = dev.check(newTransferError(*dev.libusbWriter, n, err))
+		logger().Debug("usb: write failed", "endpoint", *dev.libusbWriter, "transferred", n, "err", err)
+	}
+	observeTransfer(dev.metrics, EndpointDirectionOut, start, n, err)
+	traceTransfer(*dev.libusbWriter, start, bufs, n, err)
+	return n, err
+}
+
+// Read retrieves a binary blob from an USB device. Reads only exclude each other,
+// so they may run concurrently with writes and control requests.
+func (dev *webusbDevice) Read(b []byte) (int, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+	dev.readLock.Lock()
+	defer dev.readLock.Unlock()
+
+	if dev.closed {
+		return 0, dev.closedErr()
+	}
+	start := time.Now()
+	n, err := dev.retry.run(transientError, retryObserver(dev.metrics, EndpointDirectionIn), func() (int, error) {
+		res, err := await(dev.dev.Call("transferIn", int(*dev.libusbReader&endpointNumMask), len(b)))
+		if err != nil {
+			return 0, err
+		}
+		if err := dev.transferStatus(res, *dev.libusbReader); err != nil {
+			return 0, err
+		}
+		return copyDataView(b, res.Get("data")), nil
+	})
+	if err != nil {
+		err = dev.check(newTransferError(*dev.libusbReader, n, err))
+		logger().Debug("usb: read failed", "endpoint", *dev.libusbReader, "transferred", n, "err", err)
+	}
+	observeTransfer(dev.metrics, EndpointDirectionIn, start, n, err)
+	traceTransfer(*dev.libusbReader, start, net.Buffers{b}, n, err)
+	return n, err
+}
+
+// TryRead collects the data of a read queued by an earlier call without
+// blocking, queuing one if there's none in flight. The backend has no
+// asynchronous transfers, so the read runs on a goroutine.
+func (dev *webusbDevice) TryRead(b []byte) (int, error) {
+	return dev.try.tryRead(b, dev.Read)
+}
+
+// TryWrite queues a write of b without blocking, unless the previous one is in
+// flight. The backend has no asynchronous transfers, so the write runs on a
+// goroutine.
+func (dev *webusbDevice) TryWrite(b []byte) (int, error) {
+	return dev.try.tryWrite(b, dev.Write)
+}
+
+// AcquireBuffer returns a transfer buffer of the given size. There's no device
+// memory to allocate from, so it's a plain Go buffer.
+func (dev *webusbDevice) AcquireBuffer(size int) (*Buffer, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+
+	if dev.closed {
+		return nil, dev.closedErr()
+	}
+	return NewBuffer(size), nil
+}
+
+// SetRetryPolicy configures retries of Read and Write transfers failing with
+// transient errors. A nil policy disables retries.
+func (dev *webusbDevice) SetRetryPolicy(policy *RetryPolicy) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.retry = policy
+}
+
+// SetMetrics reports the Read and Write transfers of the device to m. A nil m
+// disables the reporting.
+func (dev *webusbDevice) SetMetrics(m Metrics) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.metrics = m
+}
+
+// transferStatus converts the status of a bulk or interrupt transfer result
+// into an error. Stalled endpoints are cleared if the transfer may be retried.
+func (dev *webusbDevice) transferStatus(res js.Value, endpoint uint8) error {
+	err := transferStatus(res)
+	if err == errStall && dev.retry != nil {
+		direction := "out"
+		if endpoint&endpointDirectionMask != 0 {
+			direction = "in"
+		}
+		await(dev.dev.Call("clearHalt", direction, int(endpoint&endpointNumMask)))
+	}
+	return err
+}
+
+// transientError reports whether a transfer error is worth retrying under the
+// default retry policy.
+func transientError(err error) bool {
+	return errors.Is(err, ErrTimeout) || errors.Is(err, ErrPipe) || errors.Is(err, ErrInterrupted)
+}
+
+// Control sends a control request to the device. The direction of the data
+// stage is determined by the ControlIn bit of rType.
+func (dev *webusbDevice) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+	dev.controlLock.Lock()
+	defer dev.controlLock.Unlock()
+
+	if dev.closed {
+		return 0, dev.closedErr()
+	}
+	if len(data) > math.MaxUint16 {
+		return 0, fmt.Errorf("failed to send control request: %w", ErrInvalidParam)
+	}
+	start := time.Now()
+	n, err := dev.control(rType, request, val, idx, data)
+	traceControl(rType, request, val, idx, start, data, n, err)
+	return n, err
+}
+
+// control is the lock-free variant of Control.
+func (dev *webusbDevice) control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	setup := map[string]interface{}{
+		"requestType": [...]string{"standard", "class", "vendor", "reserved"}[rType>>5&0x03],
+		"recipient":   [...]string{"device", "interface", "endpoint", "other"}[rType&0x03],
+		"request":     int(request),
+		"value":       int(val),
+		"index":       int(idx),
+	}
+	var (
+		res js.Value
+		err error
+	)
+	if rType&ControlIn != 0 {
+		res, err = await(dev.dev.Call("controlTransferIn", setup, len(data)))
+	} else {
+		res, err = await(dev.dev.Call("controlTransferOut", setup, toUint8Array(data)))
+	}
+	if err != nil {
+		return 0, dev.check(fmt.Errorf("failed to send control request: %w", err))
+	}
+	if err := transferStatus(res); err != nil {
+		return 0, dev.check(fmt.Errorf("failed to send control request: %w", err))
+	}
+	if rType&ControlIn != 0 {
+		return copyDataView(data, res.Get("data")), nil
+	}
+	return res.Get("bytesWritten").Int(), nil
+}
+
+// SetTimeouts is a no-op, WebUSB transfers cannot time out.
+func (dev *webusbDevice) SetTimeouts(read, write time.Duration) {}
+
+// ReadWithTimeout reads like Read, WebUSB transfers cannot time out.
+func (dev *webusbDevice) ReadWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	return dev.Read(b)
+}
+
+// WriteWithTimeout writes like Write, WebUSB transfers cannot time out.
+func (dev *webusbDevice) WriteWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	return dev.Write(b)
+}
+
+// SetControlTimeout is a no-op, WebUSB transfers cannot time out.
+func (dev *webusbDevice) SetControlTimeout(timeout time.Duration) {}
+
+// AttachKernelDriver is unsupported, the browser owns the driver binding.
+func (dev *webusbDevice) AttachKernelDriver() error {
+	return ErrUnsupportedPlatform
+}
+
+// SetReattachOnClose is a no-op, the browser owns the driver binding.
+func (dev *webusbDevice) SetReattachOnClose(reattach bool) {}
+
+// ClaimInterface claims an additional interface of a composite device.
+func (dev *webusbDevice) ClaimInterface(iface int) error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.closed {
+		return dev.closedErr()
+	}
+	if iface == dev.Interface || dev.claimed[iface] {
+		return nil
+	}
+	if _, err := await(dev.dev.Call("claimInterface", iface)); err != nil {
+		return fmt.Errorf("failed to claim interface %d: %w", iface, err)
+	}
+	if dev.claimed == nil {
+		dev.claimed = make(map[int]bool)
+	}
+	dev.claimed[iface] = true
+	return nil
+}
+
+// ReleaseInterface releases an interface claimed through ClaimInterface.
+func (dev *webusbDevice) ReleaseInterface(iface int) error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.closed {
+		return dev.closedErr()
+	}
+	if !dev.claimed[iface] {
+		return fmt.Errorf("interface %d not claimed", iface)
+	}
+	delete(dev.claimed, iface)
+	delete(dev.alts, iface)
+	if _, err := await(dev.dev.Call("releaseInterface", iface)); err != nil {
+		return fmt.Errorf("failed to release interface %d: %w", iface, err)
+	}
+	return nil
+}
+
+// SetAltSetting activates an alternate setting of a claimed interface.
+func (dev *webusbDevice) SetAltSetting(iface int, alt int) error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.closed {
+		return dev.closedErr()
+	}
+	if _, err := await(dev.dev.Call("selectAlternateInterface", iface, alt)); err != nil {
+		return fmt.Errorf("failed to set alternate setting %d of interface %d: %w", alt, iface, err)
+	}
+	if dev.alts == nil {
+		dev.alts = make(map[int]int)
+	}
+	dev.alts[iface] = alt
+	return nil
+}
+
+// Reset performs a USB port reset of the device, then restores the interface
+// claims and alternate settings.
+func (dev *webusbDevice) Reset() error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.closed {
+		return dev.closedErr()
+	}
+	if _, err := await(dev.dev.Call("reset")); err != nil {
+		return dev.check(fmt.Errorf("failed to reset device: %w", err))
+	}
+	return dev.restore()
+}
+
+// SetConfiguration activates a configuration of the device, then restores the
+// interface claims and alternate settings.
+func (dev *webusbDevice) SetConfiguration(config int) error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.closed {
+		return dev.closedErr()
+	}
+	for _, iface := range dev.interfaces() {
+		await(dev.dev.Call("releaseInterface", iface))
+	}
+	if _, err := await(dev.dev.Call("selectConfiguration", config)); err != nil {
+		// Reclaim the interfaces of the configuration left active
+		dev.restore()
+		return dev.check(fmt.Errorf("failed to set configuration %d: %w", config, err))
+	}
+	return dev.restore()
+}
+
+// Status retrieves the power and remote wakeup status of the device.
+func (dev *webusbDevice) Status() (DeviceStatus, error) {
+	return readDeviceStatus(dev.Control)
+}
+
+// InterfaceStatus retrieves the function remote wake status of an interface.
+func (dev *webusbDevice) InterfaceStatus(iface int) (InterfaceStatus, error) {
+	return readInterfaceStatus(dev.Control, iface)
+}
+
+// EndpointStatus retrieves the halt status of an endpoint.
+func (dev *webusbDevice) EndpointStatus(endpoint uint8) (EndpointStatus, error) {
+	return readEndpointStatus(dev.Control, endpoint)
+}
+
+// SetRemoteWakeup arms or disarms the device to wake the host from suspend.
+func (dev *webusbDevice) SetRemoteWakeup(enable bool) error {
+	return setRemoteWakeup(dev.Control, enable)
+}
+
+// AllocBulkStreams is unsupported, WebUSB has no bulk stream API.
+func (dev *webusbDevice) AllocBulkStreams(count int, endpoints ...uint8) (int, error) {
+	return 0, fmt.Errorf("failed to allocate bulk streams: %w", ErrNotSupported)
+}
+
+// FreeBulkStreams is unsupported, WebUSB has no bulk stream API.
+func (dev *webusbDevice) FreeBulkStreams(endpoints ...uint8) error {
+	return fmt.Errorf("failed to free bulk streams: %w", ErrNotSupported)
+}
+
+// OpenBulkStream is unsupported, WebUSB has no bulk stream API.
+func (dev *webusbDevice) OpenBulkStream(endpoint uint8, streamID uint32) (*BulkStream, error) {
+	return nil, fmt.Errorf("failed to open bulk stream: %w", ErrNotSupported)
+}
+
+// SetAutoSuspend is unsupported, browsers manage the power of devices themselves.
+func (dev *webusbDevice) SetAutoSuspend(enable bool, delay time.Duration) error {
+	return fmt.Errorf("failed to set autosuspend: %w", ErrNotSupported)
+}
+
+// Configuration returns the value of the active configuration, as tracked by
+// the browser.
+func (dev *webusbDevice) Configuration() (int, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+
+	if dev.closed {
+		return 0, dev.closedErr()
+	}
+	config := dev.dev.Get("configuration")
+	if config.IsNull() || config.IsUndefined() {
+		return 0, ErrNotConfigured
+	}
+	return config.Get("configurationValue").Int(), nil
+}
+
+// interfaces returns the primary and additionally claimed interfaces in order.
+func (dev *webusbDevice) interfaces() []int {
+	ifaces := make([]int, 0, 1+len(dev.claimed))
+	for iface := range dev.claimed {
+		ifaces = append(ifaces, iface)
+	}
+	sort.Ints(ifaces)
+	return append([]int{dev.Interface}, ifaces...)
+}
+
+// restore reclaims the interfaces and reapplies their alternate settings.
+// Claiming interfaces which are still claimed is a no-op for the browser.
+func (dev *webusbDevice) restore() error {
+	ifaces := dev.interfaces()
+	for _, iface := range ifaces {
+		if _, err := await(dev.dev.Call("claimInterface", iface)); err != nil {
+			return &RestoreError{Interface: iface, Err: err}
+		}
+	}
+	for _, iface := range ifaces {
+		if alt := dev.alts[iface]; alt != 0 {
+			if _, err := await(dev.dev.Call("selectAlternateInterface", iface, alt)); err != nil {
+				return &RestoreError{Interface: iface, Alternate: alt, Err: err}
+			}
+		}
+	}
+	return nil
+}
+
+// ClearHalt clears the halt/stall condition of an endpoint.
+func (dev *webusbDevice) ClearHalt(endpoint uint8) error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.closed {
+		return dev.closedErr()
+	}
+	direction := "out"
+	if endpoint&endpointDirectionMask != 0 {
+		direction = "in"
+	}
+	if _, err := await(dev.dev.Call("clearHalt", direction, int(endpoint&endpointNumMask))); err != nil {
+		return fmt.Errorf("failed to clear halt on endpoint %#02x: %w", endpoint, err)
+	}
+	return nil
+}
+
+// BOS retrieves the Binary Object Store descriptor of the device, exposing its
+// USB 2.0 extension, SuperSpeed and container ID capabilities.
+func (dev *webusbDevice) BOS() (*BOSDescriptor, error) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.closed {
+		return nil, dev.closedErr()
+	}
+	// WebUSB has no descriptor access, fetch and split the BOS manually
+	return readBOS(dev.getDescriptor)
+}
+
+// RawDescriptors retrieves the raw device and configuration descriptors from
+// the device via GET_DESCRIPTOR requests, allowing applications to parse class
+// specific descriptors not modelled by this package.
+func (dev *webusbDevice) RawDescriptors() (*RawDescriptors, error) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.closed {
+		return nil, dev.closedErr()
+	}
+	return readRawDescriptors(dev.getDescriptor)
+}
+
+// getDescriptor issues a standard GET_DESCRIPTOR request on the device.
+func (dev *webusbDevice) getDescriptor(kind uint8, index uint8, length int) ([]byte, error) {
+	buf := make([]byte, length)
+	n, err := dev.control(ControlIn|ControlDevice, requestGetDescriptor, uint16(kind)<<8|uint16(index), 0, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// transferStatus converts the status of a WebUSB transfer result into an error.
+func transferStatus(res js.Value) error {
+	switch status := res.Get("status").String(); status {
+	case "ok":
+		return nil
+	case "stall":
+		return errStall
+	case "babble":
+		return fmt.Errorf("device sent more data than expected: %w", ErrOverflow)
+	default:
+		return fmt.Errorf("transfer status %s", status)
+	}
+}
+
+// toUint8Array copies a Go byte slice into a new JavaScript Uint8Array.
+func toUint8Array(b []byte) js.Value {
+	arr := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(arr, b)
+	return arr
+}
+
+// copyDataView copies the contents of a JavaScript DataView into a Go buffer,
+// returning the number of bytes copied.
+func copyDataView(dst []byte, view js.Value) int {
+	if view.IsUndefined() || view.IsNull() {
+		return 0
+	}
+	arr := js.Global().Get("Uint8Array").New(view.Get("buffer"), view.Get("byteOffset"), view.Get("byteLength"))
+	return js.CopyBytesToGo(dst, arr)
+}
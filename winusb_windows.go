@@ -0,0 +1,936 @@
+//go:build windows && winusb
+
+package zerousb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// WinUSB entry points, loaded from the system directory on first use.
+var (
+	winusb = windows.NewLazySystemDLL("winusb.dll")
+
+	procInitialize                 = winusb.NewProc("WinUsb_Initialize")
+	procFree                       = winusb.NewProc("WinUsb_Free")
+	procGetAssociatedInterface     = winusb.NewProc("WinUsb_GetAssociatedInterface")
+	procGetDescriptor              = winusb.NewProc("WinUsb_GetDescriptor")
+	procQueryInterfaceSettings     = winusb.NewProc("WinUsb_QueryInterfaceSettings")
+	procQueryPipe                  = winusb.NewProc("WinUsb_QueryPipe")
+	procSetPipePolicy              = winusb.NewProc("WinUsb_SetPipePolicy")
+	procReadPipe                   = winusb.NewProc("WinUsb_ReadPipe")
+	procWritePipe                  = winusb.NewProc("WinUsb_WritePipe")
+	procControlTransfer            = winusb.NewProc("WinUsb_ControlTransfer")
+	procResetPipe                  = winusb.NewProc("WinUsb_ResetPipe")
+	procSetCurrentAlternateSetting = winusb.NewProc("WinUsb_SetCurrentAlternateSetting")
+)
+
+// pipeTransferTimeout is the WinUSB pipe policy holding the transfer timeout.
+const pipeTransferTimeout = 0x03
+
+// langEnglishUS is the language id string descriptors are requested in.
+const langEnglishUS = 0x0409
+
+// pipeInformation mirrors WINUSB_PIPE_INFORMATION.
+type pipeInformation struct {
+	PipeType          uint32
+	PipeID            uint8
+	MaximumPacketSize uint16
+	Interval          uint8
+}
+
+// winusbDevice is a device interface opened through WinUSB.
+type winusbDevice struct {
+	DeviceInfo // The device info that was used to open the device
+	lifetime   // Signals the device being closed or disconnected
+
+	file           windows.Handle  // Device interface opened via CreateFile
+	handle         uintptr         // WinUSB handle of the first interface, zero when closed
+	claimed        map[int]uintptr // WinUSB handles of the additionally claimed interfaces
+	retry          *RetryPolicy    // Retry policy of Read and Write, nil for none
+	metrics        Metrics         // Metrics of Read and Write, nil for none
+	try            tryEmulation    // Transfers of TryRead and TryWrite
+	lock           sync.RWMutex    // Guards the handles and interface state, held shared by transfers
+	readLock       sync.Mutex      // Serializes transfers on the IN endpoint
+	writeLock      sync.Mutex      // Serializes transfers on the OUT endpoint
+	controlLock    sync.Mutex      // Serializes control requests
+	writeTimeout   int
+	readTimeout    int
+	controlTimeout int
+}
+
+// winusbError is a Windows error code reported by a WinUSB or device I/O call.
+type winusbError windows.Errno
+
+// Error implements the error interface.
+func (e winusbError) Error() string {
+	return windows.Errno(e).Error()
+}
+
+// Unwrap returns the raw Windows error code.
+func (e winusbError) Unwrap() error {
+	return windows.Errno(e)
+}
+
+// code returns the raw Windows error code.
+func (e winusbError) code() int {
+	return int(e)
+}
+
+// Is reports whether the error code corresponds to a portable sentinel error.
+// WinUSB reports timeouts as ERROR_SEM_TIMEOUT and stalls as ERROR_GEN_FAILURE.
+func (e winusbError) Is(target error) bool {
+	switch windows.Errno(e) {
+	case windows.ERROR_ACCESS_DENIED:
+		return target == ErrAccess
+	case windows.ERROR_NOT_ENOUGH_MEMORY:
+		return target == ErrNoMem
+	case windows.ERROR_BAD_COMMAND, windows.ERROR_DEVICE_NOT_CONNECTED, windows.ERROR_FILE_NOT_FOUND:
+		return target == ErrNoDevice
+	case windows.ERROR_GEN_FAILURE:
+		return target == ErrPipe
+	case windows.ERROR_NOT_SUPPORTED:
+		return target == ErrNotSupported
+	case windows.ERROR_INVALID_PARAMETER:
+		return target == ErrInvalidParam
+	case windows.ERROR_SEM_TIMEOUT:
+		return target == ErrTimeout
+	case windows.ERROR_BUSY:
+		return target == ErrBusy
+	case windows.ERROR_OPERATION_ABORTED:
+		return target == ErrInterrupted
+	case windows.ERROR_IO_DEVICE:
+		return target == ErrIO
+	}
+	return false
+}
+
+// fromErrno converts an error of a system call into a winusbError if it
+// carries a Windows error code.
+func fromErrno(err error) error {
+	if errno, ok := err.(windows.Errno); ok {
+		return winusbError(errno)
+	}
+	return err
+}
+
+// winusbCall invokes a WinUSB function, converting a FALSE result into an error.
+func winusbCall(proc *windows.LazyProc, args ...uintptr) error {
+	if r, _, err := proc.Call(args...); r == 0 {
+		return fromErrno(err)
+	}
+	return nil
+}
+
+// getAllDevices lists the interfaces bound to the WinUSB driver which are
+// accepted by the match function. Devices opened by another process can't be
+// queried and are skipped.
+func getAllDevices(match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	devs, err := windows.SetupDiGetClassDevsEx(nil, "USB", 0, windows.DIGCF_ALLCLASSES|windows.DIGCF_PRESENT, 0, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	defer devs.Close()
+
+	var infos []DeviceInfo
+	for i := 0; ; i++ {
+		data, err := devs.EnumDeviceInfo(i)
+		if err == windows.ERROR_NO_MORE_ITEMS {
+			break
+		}
+		if err != nil {
+			continue
+		}
+		// Only WinUSB bound devices can be driven by this backend
+		if service, err := devs.DeviceRegistryProperty(data, windows.SPDRP_SERVICE); err != nil || !strings.EqualFold(fmt.Sprint(service), "WinUSB") {
+			continue
+		}
+		id, err := devs.DeviceInstanceID(data)
+		if err != nil {
+			continue
+		}
+		path := interfacePath(devs, data, id)
+		if path == "" {
+			continue
+		}
+		port := locationPort(devs, data)
+		devInfos, err := describeDevice(path, id, port, match, hid)
+		if err != nil {
+			continue
+		}
+		infos = append(infos, devInfos...)
+	}
+	return infos, nil
+}
+
+// interfacePath resolves the device interface path of a WinUSB device from the
+// interface GUIDs its driver installation registered.
+func interfacePath(devs windows.DevInfo, data *windows.DevInfoData, id string) string {
+	handle, err := devs.OpenDevRegKey(data, windows.DICS_FLAG_GLOBAL, 0, windows.DIREG_DEV, windows.KEY_READ)
+	if err != nil {
+		return ""
+	}
+	key := registry.Key(handle)
+	defer key.Close()
+
+	guids, _, err := key.GetStringsValue("DeviceInterfaceGUIDs")
+	if err != nil {
+		guid, _, err := key.GetStringValue("DeviceInterfaceGUID")
+		if err != nil {
+			return ""
+		}
+		guids = []string{guid}
+	}
+	for _, str := range guids {
+		guid, err := windows.GUIDFromString(str)
+		if err != nil {
+			continue
+		}
+		paths, err := windows.CM_Get_Device_Interface_List(id, &guid, windows.CM_GET_DEVICE_INTERFACE_LIST_PRESENT)
+		if err == nil && len(paths) > 0 && paths[0] != "" {
+			return paths[0]
+		}
+	}
+	return ""
+}
+
+// openInterface opens a WinUSB device interface path.
+func openInterface(path string) (windows.Handle, uintptr, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	// WinUSB requires overlapped handles even for synchronous transfers
+	file, err := windows.CreateFile(name, windows.GENERIC_READ|windows.GENERIC_WRITE,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE, nil, windows.OPEN_EXISTING,
+		windows.FILE_ATTRIBUTE_NORMAL|windows.FILE_FLAG_OVERLAPPED, 0)
+	if err != nil {
+		return 0, 0, fromErrno(err)
+	}
+	var handle uintptr
+	if err := winusbCall(procInitialize, uintptr(file), uintptr(unsafe.Pointer(&handle))); err != nil {
+		windows.CloseHandle(file)
+		return 0, 0, err
+	}
+	return file, handle, nil
+}
+
+// describeDevice opens a WinUSB device interface and converts its alternate
+// settings accepted by the match predicate into device infos, tagged with the
+// instance id of the device.
+func describeDevice(path string, id string, port uint8, match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	file, handle, err := openInterface(path)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(file)
+	defer procFree.Call(handle)
+
+	desc, err := cachedDescriptor(handle, uint8(DescriptorTypeDevice), 0, 0, deviceDescriptorSize)
+	if err != nil || len(desc) < deviceDescriptorSize {
+		return nil, fmt.Errorf("failed to get device descriptor: %w", err)
+	}
+	// Skip HID devices, they are handled directly by OS libraries
+	if !hid && Class(desc[4]) == ClassHID {
+		return nil, nil
+	}
+	vid, pid := binary.LittleEndian.Uint16(desc[8:]), binary.LittleEndian.Uint16(desc[10:])
+
+	// WinUSB always runs the first configuration, the header is cached with it
+	var config ConfigInfo
+	if cfg, err := cachedDescriptor(handle, uint8(DescriptorTypeConfig), 0, 0, configDescriptorSize); err == nil {
+		config, _ = parseConfigInfo(cfg, binary.LittleEndian.Uint16(desc[2:]))
+	}
+
+	var infos []DeviceInfo
+	for alt := 0; ; alt++ {
+		var iface [9]byte
+		if err := winusbCall(procQueryInterfaceSettings, handle, uintptr(alt), uintptr(unsafe.Pointer(&iface[0]))); err != nil {
+			break
+		}
+		// Skip HID interfaces, they are handled directly by OS libraries
+		if !hid && Class(iface[5]) == ClassHID {
+			continue
+		}
+		var reader, writer *uint8
+		var readerTransferType, writerTransferType uint8
+		var endpoints []EndpointInfo
+		for i := 0; i < int(iface[4]); i++ {
+			var pipe pipeInformation
+			if err := winusbCall(procQueryPipe, handle, uintptr(alt), uintptr(i), uintptr(unsafe.Pointer(&pipe))); err != nil {
+				break
+			}
+			endpoints = append(endpoints, EndpointInfo{
+				Address:       pipe.PipeID,
+				Attributes:    uint8(pipe.PipeType),
+				MaxPacketSize: pipe.MaximumPacketSize,
+				Interval:      pipe.Interval,
+			})
+			// Skip any non-interrupt and bulk endpoints
+			if kind := TransferType(pipe.PipeType); kind != TransferTypeInterrupt && kind != TransferTypeBulk {
+				continue
+			}
+			if pipe.PipeID&endpointDirectionMask != 0 {
+				reader, readerTransferType = new(uint8), uint8(pipe.PipeType)
+				*reader = pipe.PipeID
+			} else {
+				writer, writerTransferType = new(uint8), uint8(pipe.PipeType)
+				*writer = pipe.PipeID
+			}
+		}
+		// If both in and out endpoints are available, match the device
+		if reader == nil || writer == nil {
+			continue
+		}
+		portnum := port
+		info := DeviceInfo{
+			Path:         DevicePath{Ports: []uint8{port}, Interface: int(iface[2])}.String(),
+			SysPath:      id,
+			VendorID:     vid,
+			ProductID:    pid,
+			Release:      binary.LittleEndian.Uint16(desc[12:]),
+			USBVersion:   binary.LittleEndian.Uint16(desc[2:]),
+			Manufacturer: cachedString(handle, desc[14]),
+			Product:      cachedString(handle, desc[15]),
+			Serial:       cachedString(handle, desc[16]),
+			Class:        desc[4],
+			SubClass:     desc[5],
+			Protocol:     desc[6],
+			Port:         port,
+
+			ManufacturerIndex: desc[14],
+			ProductIndex:      desc[15],
+			SerialIndex:       desc[16],
+
+			Interface:          int(iface[2]),
+			InterfaceNumber:    int(iface[2]),
+			InterfaceAlternate: int(iface[3]),
+			InterfaceClass:     iface[5],
+			InterfaceSubClass:  iface[6],
+			InterfaceProtocol:  iface[7],
+			Endpoints:          endpoints,
+			Config:             config,
+
+			libusbDevice:       path,
+			libusbPort:         &portnum,
+			libusbReader:       reader,
+			libusbWriter:       writer,
+			readerTransferType: &readerTransferType,
+			writerTransferType: &writerTransferType,
+		}
+		if match(info) {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+// cachedDescriptor retrieves a descriptor cached by the WinUSB driver, which
+// only holds the device, configuration and string descriptors.
+func cachedDescriptor(handle uintptr, kind uint8, index uint8, lang uint16, length int) ([]byte, error) {
+	buf := make([]byte, length)
+
+	var transferred uint32
+	if err := winusbCall(procGetDescriptor, handle, uintptr(kind), uintptr(index), uintptr(lang),
+		uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), uintptr(unsafe.Pointer(&transferred))); err != nil {
+		return nil, err
+	}
+	return buf[:transferred], nil
+}
+
+// cachedString retrieves a string descriptor, returning empty if the index is
+// unset or the retrieval fails.
+func cachedString(handle uintptr, index uint8) string {
+	if index == 0 {
+		return ""
+	}
+	buf, err := cachedDescriptor(handle, uint8(DescriptorTypeString), index, langEnglishUS, 255)
+	if err != nil || len(buf) < 2 {
+		return ""
+	}
+	chars := make([]uint16, (len(buf)-2)/2)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(buf[2+2*i:])
+	}
+	return windows.UTF16ToString(chars)
+}
+
+// listDevices lists the devices by collapsing their enumerated interfaces, as
+// the interfaces are what the backend discovers.
+func listDevices(match func(DeviceInfo) bool, hid bool) ([]DeviceInfo, error) {
+	return listByInterface(match, hid)
+}
+
+// describeInterfaces enumerates the interfaces of a listed device.
+func describeInterfaces(info DeviceInfo, hid bool) ([]DeviceInfo, error) {
+	return interfacesOf(info, hid)
+}
+
+// configure ignores the options, which only apply to libusb.
+func configure(opts contextOptions) error {
+	return nil
+}
+
+// initContext has nothing to initialize, WinUSB is reached without a context.
+func initContext() error {
+	return nil
+}
+
+// backendVersion reports WinUSB as the backend, libusb isn't involved.
+func backendVersion() (string, string) {
+	return "winusb", ""
+}
+
+// exit has no global state to release, devices are closed individually.
+func exit() error {
+	return nil
+}
+
+// open connects to a WinUSB device interface by its path.
+func open(info DeviceInfo, opts *openOptions) (*winusbDevice, error) {
+	path, ok := info.libusbDevice.(string)
+	if !ok {
+		return nil, fmt.Errorf("failed to open device: %w", ErrNoDevice)
+	}
+	file, handle, err := openInterface(path)
+	if errors.Is(err, ErrAccess) {
+		// WinUSB grants a single handle, another process holds the device
+		return nil, newAccessError("", "close other programs using the device", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open device: %w", err)
+	}
+	dev := &winusbDevice{DeviceInfo: info, file: file, handle: handle, controlTimeout: timeoutMillis(defaultControlTimeout)}
+	if info.InterfaceAlternate != 0 {
+		if err := winusbCall(procSetCurrentAlternateSetting, handle, uintptr(info.InterfaceAlternate)); err != nil {
+			dev.Close()
+			return nil, fmt.Errorf("failed to select alternate setting: %w", err)
+		}
+	}
+	// Release the handles once a call finds the device gone
+	dev.OnDisconnect(func() { dev.Close() })
+	return dev, nil
+}
+
+// openPath is unsupported, there are no usbfs nodes.
+func openPath(path string, opts *openOptions) (Device, DeviceInfo, error) {
+	return nil, DeviceInfo{}, fmt.Errorf("failed to open device: %w", ErrNotSupported)
+}
+
+// openParentHub is unsupported, hubs can't be opened through WinUSB.
+func openParentHub(info DeviceInfo) (*parentHub, error) {
+	return nil, fmt.Errorf("failed to open parent hub: %w", ErrNotSupported)
+}
+
+// topology is unsupported, hubs can't be opened through WinUSB.
+func topology() ([]*TopologyNode, error) {
+	return nil, fmt.Errorf("failed to list topology: %w", ErrNotSupported)
+}
+
+// openFD is unsupported, WinUSB devices can't be wrapped from file descriptors.
+func openFD(fd int) (*winusbDevice, error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+// hasCapability reports false, the WinUSB backend has none of the optional capabilities, devices are
+// bound to WinUSB up front.
+func hasCapability(capability Capability) bool {
+	return false
+}
+
+// onHotplug is unsupported by the WinUSB backend.
+func onHotplug(handler HotplugHandler) (func(), error) {
+	return nil, ErrUnsupportedPlatform
+}
+
+// Close releases the WinUSB handles and the device interface.
+func (dev *winusbDevice) Close() error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.handle == 0 {
+		return nil
+	}
+	for iface, handle := range dev.claimed {
+		procFree.Call(handle)
+		delete(dev.claimed, iface)
+	}
+	procFree.Call(dev.handle)
+	dev.handle = 0
+	dev.end(ErrDeviceClosed)
+
+	return windows.CloseHandle(dev.file)
+}
+
+// SetWriteTimeout sets the timeout of writes in milliseconds.
+//
+// Deprecated: use SetTimeouts.
+func (dev *winusbDevice) SetWriteTimeout(timeout int) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.writeTimeout = timeout
+	if dev.handle != 0 {
+		dev.setTimeout(*dev.libusbWriter, timeout)
+	}
+}
+
+// SetReadTimeout sets the timeout of reads in milliseconds.
+//
+// Deprecated: use SetTimeouts.
+func (dev *winusbDevice) SetReadTimeout(timeout int) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.readTimeout = timeout
+	if dev.handle != 0 {
+		dev.setTimeout(*dev.libusbReader, timeout)
+	}
+}
+
+// SetTimeouts sets the timeouts of Read and Write, applied to the pipe policies
+// of the endpoints.
+func (dev *winusbDevice) SetTimeouts(read, write time.Duration) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.readTimeout, dev.writeTimeout = timeoutMillis(read), timeoutMillis(write)
+	if dev.handle != 0 {
+		dev.setTimeout(*dev.libusbReader, dev.readTimeout)
+		dev.setTimeout(*dev.libusbWriter, dev.writeTimeout)
+	}
+}
+
+// SetControlTimeout sets the timeout of control requests, zero for none, applied
+// to the pipe policy of the default endpoint. It defaults to 5 seconds.
+func (dev *winusbDevice) SetControlTimeout(timeout time.Duration) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.controlTimeout = timeoutMillis(timeout)
+	if dev.handle != 0 {
+		dev.setTimeout(0, dev.controlTimeout)
+	}
+}
+
+// setTimeout applies a transfer timeout in milliseconds to a pipe, zero
+// meaning no timeout as with libusb.
+func (dev *winusbDevice) setTimeout(pipe uint8, timeout int) error {
+	value := uint32(timeout)
+	return winusbCall(procSetPipePolicy, dev.handle, uintptr(pipe), pipeTransferTimeout, unsafe.Sizeof(value), uintptr(unsafe.Pointer(&value)))
+}
+
+// Control sends a control request to the device. The direction of the data
+// stage is determined by the ControlIn bit of rType.
+func (dev *winusbDevice) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+	dev.controlLock.Lock()
+	defer dev.controlLock.Unlock()
+
+	if dev.handle == 0 {
+		return 0, dev.closedErr()
+	}
+	if len(data) > math.MaxUint16 {
+		return 0, fmt.Errorf("failed to send control request: %w", ErrInvalidParam)
+	}
+	start := time.Now()
+	n, err := dev.control(rType, request, val, idx, data)
+	if err != nil {
+		n, err = 0, dev.check(fmt.Errorf("failed to send control request: %w", err))
+	}
+	traceControl(rType, request, val, idx, start, data, n, err)
+	return n, err
+}
+
+// control is the lock-free variant of Control.
+func (dev *winusbDevice) control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	var setup [8]byte
+	setup[0], setup[1] = rType, request
+	binary.LittleEndian.PutUint16(setup[2:], val)
+	binary.LittleEndian.PutUint16(setup[4:], idx)
+	binary.LittleEndian.PutUint16(setup[6:], uint16(len(data)))
+
+	// WINUSB_SETUP_PACKET is passed by value, which is a single register on
+	// 64 bit platforms, but two stack slots on 32 bit ones
+	var args []uintptr
+	if unsafe.Sizeof(uintptr(0)) == 8 {
+		args = []uintptr{dev.handle, uintptr(binary.LittleEndian.Uint64(setup[:]))}
+	} else {
+		args = []uintptr{dev.handle, uintptr(binary.LittleEndian.Uint32(setup[:4])), uintptr(binary.LittleEndian.Uint32(setup[4:]))}
+	}
+	var transferred uint32
+	args = append(args, uintptr(unsafe.Pointer(bufferPtr(data))), uintptr(len(data)), uintptr(unsafe.Pointer(&transferred)), 0)
+
+	if err := winusbCall(procControlTransfer, args...); err != nil {
+		return 0, err
+	}
+	return int(transferred), nil
+}
+
+// Write sends a binary blob to an USB device. Writes only exclude each other,
+// so they may run concurrently with reads and control requests.
+func (dev *winusbDevice) Write(b []byte) (int, error) {
+	return dev.write(net.Buffers{b}, nil)
+}
+
+// WriteV sends the buffers to an USB device as if they were concatenated,
+// transferring whole packets straight from the buffers.
+func (dev *winusbDevice) WriteV(bufs net.Buffers) (int, error) {
+	return dev.write(bufs, nil)
+}
+
+// WriteWithTimeout sends a binary blob to an USB device, with a timeout for
+// this call only. The pipe policy is switched for the duration of the call.
+func (dev *winusbDevice) WriteWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	millis := timeoutMillis(timeout)
+	return dev.write(net.Buffers{b}, &millis)
+}
+
+// write sends the buffers with the given timeout in milliseconds, or the one of
+// the device if nil.
+func (dev *winusbDevice) write(bufs net.Buffers, override *int) (int, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+	dev.writeLock.Lock()
+	defer dev.writeLock.Unlock()
+
+	if dev.handle == 0 {
+		return 0, dev.closedErr()
+	}
+	if override != nil && *override != dev.writeTimeout {
+		if err := dev.setTimeout(*dev.libusbWriter, *override); err != nil {
+			return 0, fmt.Errorf("failed to set write timeout: %w", err)
+		}
+		defer dev.setTimeout(*dev.libusbWriter, dev.writeTimeout)
+	}
+	start := time.Now()
+	n, err := writeVectored(bufs, dev.writePacket(), func(b []byte) (int, error) {
+		// Lengths are described as 32 bit integers, split what can't be described
+		return splitTransfer(b, maxTransferSize(math.MaxUint32), func(chunk []byte) (int, error) {
+			return dev.retry.run(transientError, retryObserver(dev.metrics, EndpointDirectionOut), func() (int, error) {
+				var transferred uint32
+				err := winusbCall(procWritePipe, dev.handle, uintptr(*dev.libusbWriter), uintptr(unsafe.Pointer(bufferPtr(chunk))), uintptr(len(chunk)), uintptr(unsafe.Pointer(&transferred)), 0)
+				dev.recoverStall(*dev.libusbWriter, err)
+				return int(transferred), err
+			})
+		})
+	})
+	if err != nil {
+		err = dev.check(newTransferError(*dev.libusbWriter, n, err))
+		logger().Debug("usb: write failed", "endpoint", *dev.libusbWriter, "transferred", n, "err", err)
+	}
+	observeTransfer(dev.metrics, EndpointDirectionOut, start, n, err)
+	traceTransfer(*dev.libusbWriter, start, bufs, n, err)
+	return n, err
+}
+
+// Read retrieves a binary blob from an USB device. Reads only exclude each other,
+// so they may run concurrently with writes and control requests.
+func (dev *winusbDevice) Read(b []byte) (int, error) {
+	return dev.read(b, nil)
+}
+
+// ReadWithTimeout retrieves a binary blob from an USB device, with a timeout
+// for this call only. The pipe policy is switched for the duration of the call.
+func (dev *winusbDevice) ReadWithTimeout(b []byte, timeout time.Duration) (int, error) {
+	millis := timeoutMillis(timeout)
+	return dev.read(b, &millis)
+}
+
+// read retrieves a binary blob with the given timeout in milliseconds, or the
+// one of the device if nil.
+func (dev *winusbDevice) read(b []byte, override *int) (int, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+	dev.readLock.Lock()
+	defer dev.readLock.Unlock()
+
+	if dev.handle == 0 {
+		return 0, dev.closedErr()
+	}
+	if override != nil && *override != dev.readTimeout {
+		if err := dev.setTimeout(*dev.libusbReader, *override); err != nil {
+			return 0, fmt.Errorf("failed to set read timeout: %w", err)
+		}
+		defer dev.setTimeout(*dev.libusbReader, dev.readTimeout)
+	}
+	start := time.Now()
+	// Lengths are described as 32 bit integers, split what can't be described
+	n, err := splitTransfer(b, maxTransferSize(math.MaxUint32), func(chunk []byte) (int, error) {
+		return dev.retry.run(transientError, retryObserver(dev.metrics, EndpointDirectionIn), func() (int, error) {
+			var transferred uint32
+			err := winusbCall(procReadPipe, dev.handle, uintptr(*dev.libusbReader), uintptr(unsafe.Pointer(bufferPtr(chunk))), uintptr(len(chunk)), uintptr(unsafe.Pointer(&transferred)), 0)
+			dev.recoverStall(*dev.libusbReader, err)
+			return int(transferred), err
+		})
+	})
+	if err != nil {
+		err = dev.check(newTransferError(*dev.libusbReader, n, err))
+		logger().Debug("usb: read failed", "endpoint", *dev.libusbReader, "transferred", n, "err", err)
+	}
+	observeTransfer(dev.metrics, EndpointDirectionIn, start, n, err)
+	traceTransfer(*dev.libusbReader, start, net.Buffers{b}, n, err)
+	return n, err
+}
+
+// TryRead collects the data of a read queued by an earlier call without
+// blocking, queuing one if there's none in flight. The backend has no
+// asynchronous transfers, so the read runs on a goroutine.
+func (dev *winusbDevice) TryRead(b []byte) (int, error) {
+	return dev.try.tryRead(b, dev.Read)
+}
+
+// TryWrite queues a write of b without blocking, unless the previous one is in
+// flight. The backend has no asynchronous transfers, so the write runs on a
+// goroutine.
+func (dev *winusbDevice) TryWrite(b []byte) (int, error) {
+	return dev.try.tryWrite(b, dev.Write)
+}
+
+// AcquireBuffer returns a transfer buffer of the given size. There's no device
+// memory to allocate from, so it's a plain Go buffer.
+func (dev *winusbDevice) AcquireBuffer(size int) (*Buffer, error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+
+	if dev.handle == 0 {
+		return nil, dev.closedErr()
+	}
+	return NewBuffer(size), nil
+}
+
+// SetRetryPolicy configures retries of Read and Write transfers failing with
+// transient errors. A nil policy disables retries.
+func (dev *winusbDevice) SetRetryPolicy(policy *RetryPolicy) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.retry = policy
+}
+
+// SetMetrics reports the Read and Write transfers of the device to m. A nil m
+// disables the reporting.
+func (dev *winusbDevice) SetMetrics(m Metrics) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.metrics = m
+}
+
+// recoverStall resets a pipe which failed a transfer with a stall, so a retry
+// has a chance to succeed.
+func (dev *winusbDevice) recoverStall(pipe uint8, err error) {
+	if err == winusbError(windows.ERROR_GEN_FAILURE) && dev.retry != nil {
+		procResetPipe.Call(dev.handle, uintptr(pipe))
+	}
+}
+
+// transientError reports whether a transfer error is worth retrying under the
+// default retry policy.
+func transientError(err error) bool {
+	return errors.Is(err, ErrTimeout) || errors.Is(err, ErrPipe) || errors.Is(err, ErrInterrupted)
+}
+
+// AttachKernelDriver is a no-op, WinUSB is the driver bound to the device and
+// nothing was ever detached.
+func (dev *winusbDevice) AttachKernelDriver() error {
+	return nil
+}
+
+// SetReattachOnClose is a no-op, WinUSB is the driver bound to the device.
+func (dev *winusbDevice) SetReattachOnClose(reattach bool) {}
+
+// ClaimInterface claims an additional interface of a composite device. Only
+// interfaces following the opened one within the same WinUSB function can be
+// claimed.
+func (dev *winusbDevice) ClaimInterface(iface int) error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.handle == 0 {
+		return dev.closedErr()
+	}
+	if iface == dev.Interface {
+		return nil
+	}
+	if _, ok := dev.claimed[iface]; ok {
+		return nil
+	}
+	index := iface - dev.Interface - 1
+	if index < 0 {
+		return fmt.Errorf("failed to claim interface %d: precedes interface %d", iface, dev.Interface)
+	}
+	var handle uintptr
+	if err := winusbCall(procGetAssociatedInterface, dev.handle, uintptr(index), uintptr(unsafe.Pointer(&handle))); err != nil {
+		return fmt.Errorf("failed to claim interface %d: %w", iface, err)
+	}
+	if dev.claimed == nil {
+		dev.claimed = make(map[int]uintptr)
+	}
+	dev.claimed[iface] = handle
+	return nil
+}
+
+// ReleaseInterface releases an interface claimed through ClaimInterface.
+func (dev *winusbDevice) ReleaseInterface(iface int) error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.handle == 0 {
+		return dev.closedErr()
+	}
+	handle, ok := dev.claimed[iface]
+	if !ok {
+		return fmt.Errorf("interface %d not claimed", iface)
+	}
+	delete(dev.claimed, iface)
+	return winusbCall(procFree, handle)
+}
+
+// SetAltSetting activates an alternate setting of a claimed interface.
+func (dev *winusbDevice) SetAltSetting(iface int, alt int) error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.handle == 0 {
+		return dev.closedErr()
+	}
+	handle := dev.handle
+	if iface != dev.Interface {
+		var ok bool
+		if handle, ok = dev.claimed[iface]; !ok {
+			return fmt.Errorf("interface %d not claimed", iface)
+		}
+	}
+	if err := winusbCall(procSetCurrentAlternateSetting, handle, uintptr(alt)); err != nil {
+		return fmt.Errorf("failed to set alternate setting %d of interface %d: %w", alt, iface, err)
+	}
+	return nil
+}
+
+// Reset is unsupported, WinUSB only resets pipes but not the device itself.
+func (dev *winusbDevice) Reset() error {
+	return fmt.Errorf("failed to reset device: %w", ErrNotSupported)
+}
+
+// SetConfiguration is unsupported, WinUSB always selects the first
+// configuration of the device.
+func (dev *winusbDevice) SetConfiguration(config int) error {
+	return fmt.Errorf("failed to set configuration %d: %w", config, ErrNotSupported)
+}
+
+// Status retrieves the power and remote wakeup status of the device.
+func (dev *winusbDevice) Status() (DeviceStatus, error) {
+	return readDeviceStatus(dev.Control)
+}
+
+// InterfaceStatus retrieves the function remote wake status of an interface.
+func (dev *winusbDevice) InterfaceStatus(iface int) (InterfaceStatus, error) {
+	return readInterfaceStatus(dev.Control, iface)
+}
+
+// EndpointStatus retrieves the halt status of an endpoint.
+func (dev *winusbDevice) EndpointStatus(endpoint uint8) (EndpointStatus, error) {
+	return readEndpointStatus(dev.Control, endpoint)
+}
+
+// SetRemoteWakeup arms or disarms the device to wake the host from suspend.
+func (dev *winusbDevice) SetRemoteWakeup(enable bool) error {
+	return setRemoteWakeup(dev.Control, enable)
+}
+
+// AllocBulkStreams is unsupported, WinUSB has no bulk stream API.
+func (dev *winusbDevice) AllocBulkStreams(count int, endpoints ...uint8) (int, error) {
+	return 0, fmt.Errorf("failed to allocate bulk streams: %w", ErrNotSupported)
+}
+
+// FreeBulkStreams is unsupported, WinUSB has no bulk stream API.
+func (dev *winusbDevice) FreeBulkStreams(endpoints ...uint8) error {
+	return fmt.Errorf("failed to free bulk streams: %w", ErrNotSupported)
+}
+
+// OpenBulkStream is unsupported, WinUSB has no bulk stream API.
+func (dev *winusbDevice) OpenBulkStream(endpoint uint8, streamID uint32) (*BulkStream, error) {
+	return nil, fmt.Errorf("failed to open bulk stream: %w", ErrNotSupported)
+}
+
+// SetAutoSuspend is unsupported, selective suspend is a WinUSB power policy of the driver.
+func (dev *winusbDevice) SetAutoSuspend(enable bool, delay time.Duration) error {
+	return fmt.Errorf("failed to set autosuspend: %w", ErrNotSupported)
+}
+
+// Configuration returns the value of the active configuration via a standard
+// GET_CONFIGURATION request.
+func (dev *winusbDevice) Configuration() (int, error) {
+	return getConfiguration(dev.Control)
+}
+
+// ClearHalt clears the halt/stall condition of an endpoint.
+func (dev *winusbDevice) ClearHalt(endpoint uint8) error {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.handle == 0 {
+		return dev.closedErr()
+	}
+	// Resetting the pipe clears the stall on both the host and the device
+	if err := winusbCall(procResetPipe, dev.handle, uintptr(endpoint)); err != nil {
+		return fmt.Errorf("failed to clear halt on endpoint %#02x: %w", endpoint, err)
+	}
+	return nil
+}
+
+// BOS retrieves the Binary Object Store descriptor of the device, exposing its
+// USB 2.0 extension, SuperSpeed and container ID capabilities.
+func (dev *winusbDevice) BOS() (*BOSDescriptor, error) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.handle == 0 {
+		return nil, dev.closedErr()
+	}
+	return readBOS(dev.getDescriptor)
+}
+
+// RawDescriptors retrieves the raw device and configuration descriptors from
+// the device via GET_DESCRIPTOR requests, allowing applications to parse class
+// specific descriptors not modelled by this package.
+func (dev *winusbDevice) RawDescriptors() (*RawDescriptors, error) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.handle == 0 {
+		return nil, dev.closedErr()
+	}
+	return readRawDescriptors(dev.getDescriptor)
+}
+
+// getDescriptor issues a standard GET_DESCRIPTOR request on the device.
+func (dev *winusbDevice) getDescriptor(kind uint8, index uint8, length int) ([]byte, error) {
+	buf := make([]byte, length)
+	n, err := dev.control(ControlIn|ControlDevice, requestGetDescriptor, uint16(kind)<<8|uint16(index), 0, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// bufferPtr returns the pointer of a transfer buffer, which is nil for zero
+// length transfers (e.g. zero length packets terminating a bulk transfer).
+func bufferPtr(b []byte) *byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return &b[0]
+}
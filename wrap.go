@@ -0,0 +1,36 @@
+package zerousb
+
+// wrappedDevice is embedded by devices wrapping another one to intercept some
+// of its methods. Streams started on the wrapper are started on the wrapped
+// device, natively if its backend supports them.
+type wrappedDevice struct {
+	Device
+}
+
+// newReadStream starts a read stream on the wrapped device, natively if its
+// backend supports it.
+func (dev wrappedDevice) newReadStream(size int, count int) (streamSource, error) {
+	if reader, ok := dev.Device.(streamReader); ok {
+		return reader.newReadStream(size, count)
+	}
+	return newReadAhead(dev.Device, size, count), nil
+}
+
+// newWriteStream starts a write stream on the wrapped device, natively if its
+// backend supports it.
+func (dev wrappedDevice) newWriteStream(size int, count int) (streamSink, error) {
+	if writer, ok := dev.Device.(streamWriter); ok {
+		return writer.newWriteStream(size, count)
+	}
+	return newWriteBehind(dev.Device, size, count), nil
+}
+
+// newIsoStream starts an isochronous stream on the wrapped device, if its
+// backend supports them.
+func (dev wrappedDevice) newIsoStream(endpoint uint8, packets int, packetSize int, count int) (isoSource, error) {
+	reader, ok := dev.Device.(isoReader)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return reader.newIsoStream(endpoint, packets, packetSize, count)
+}
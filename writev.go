@@ -0,0 +1,101 @@
+package zerousb
+
+import "net"
+
+// writeEndpoint returns the endpoint Write transfers through, the last interrupt
+// or bulk OUT endpoint of the interface as picked by the backends.
+func writeEndpoint(info DeviceInfo) (EndpointInfo, bool) {
+	var (
+		found EndpointInfo
+		ok    bool
+	)
+	for _, end := range info.Endpoints {
+		if kind := end.TransferType(); kind != TransferTypeInterrupt && kind != TransferTypeBulk {
+			continue
+		}
+		if end.Direction() == EndpointDirectionOut {
+			found, ok = end, true
+		}
+	}
+	return found, ok
+}
+
+// writePacket returns the max packet size of the endpoint Write transfers
+// through, zero if unknown.
+func (info DeviceInfo) writePacket() int {
+	end, ok := writeEndpoint(info)
+	if !ok {
+		return 0
+	}
+	// High bandwidth endpoints carry the packets per microframe in bits 11-12
+	return int(end.MaxPacketSize & 0x7ff)
+}
+
+// writeVectored writes the buffers through consecutive transfers, as if they
+// were concatenated. A short packet ends the message for the device, so only
+// whole packets are written straight from the buffers, with the pieces
+// straddling buffer boundaries coalesced into a packet sized staging buffer.
+// Without a known packet size, the buffers are coalesced into a single write.
+// Nothing is written if the buffers are empty, unless a single empty buffer
+// asks for a zero length packet.
+func writeVectored(bufs net.Buffers, packet int, write func([]byte) (int, error)) (int, error) {
+	if len(bufs) == 1 {
+		return write(bufs[0])
+	}
+	if packet <= 0 {
+		var whole []byte
+		for _, b := range bufs {
+			whole = append(whole, b...)
+		}
+		if len(whole) == 0 {
+			return 0, nil
+		}
+		return write(whole)
+	}
+	var (
+		total   int
+		pending = make([]byte, 0, packet) // Unaligned data awaiting a full packet
+	)
+	flush := func(b []byte) error {
+		n, err := write(b)
+		total += n
+		return err
+	}
+	for i, b := range bufs {
+		// Complete the pending packet first, it can't be written short
+		if len(pending) > 0 {
+			fill := packet - len(pending)
+			if fill > len(b) {
+				fill = len(b)
+			}
+			pending, b = append(pending, b[:fill]...), b[fill:]
+			if len(pending) < packet {
+				continue
+			}
+			if err := flush(pending); err != nil {
+				return total, err
+			}
+			pending = pending[:0]
+		}
+		if len(b) == 0 {
+			continue
+		}
+		// The last buffer may end short, the others keep their tail pending
+		aligned := len(b)
+		if i < len(bufs)-1 {
+			aligned -= len(b) % packet
+		}
+		if aligned > 0 {
+			if err := flush(b[:aligned]); err != nil {
+				return total, err
+			}
+		}
+		pending = append(pending, b[aligned:]...)
+	}
+	if len(pending) > 0 {
+		if err := flush(pending); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,50 @@
+package zerousb
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Tests that vectored writes only transfer whole packets straight from the
+// buffers, coalescing the pieces straddling buffer boundaries, and that the
+// transferred data matches the concatenated buffers.
+func TestWriteVectored(t *testing.T) {
+	tests := []struct {
+		bufs   []string
+		packet int
+		writes []string
+	}{
+		{[]string{"hdr", "payload"}, 0, []string{"hdrpayload"}},
+		{[]string{""}, 4, []string{""}},
+		{[]string{"", ""}, 4, nil},
+		{[]string{"abcdefgh", "ij"}, 4, []string{"abcdefgh", "ij"}},
+		{[]string{"hdr", "payload"}, 4, []string{"hdrp", "ayload"}},
+		{[]string{"hd", "r", "payload12"}, 4, []string{"hdrp", "ayload12"}},
+		{[]string{"abcdef", "gh", "ijklm"}, 4, []string{"abcd", "efgh", "ijklm"}},
+		{[]string{"abcde", "f"}, 4, []string{"abcd", "ef"}},
+	}
+	for i, tt := range tests {
+		var bufs net.Buffers
+		for _, b := range tt.bufs {
+			bufs = append(bufs, []byte(b))
+		}
+		var writes []string
+		n, err := writeVectored(bufs, tt.packet, func(b []byte) (int, error) {
+			writes = append(writes, string(b))
+			return len(b), nil
+		})
+		if err != nil {
+			t.Errorf("test %d: failed to write: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(writes, tt.writes) {
+			t.Errorf("test %d: write mismatch: have %q, want %q", i, writes, tt.writes)
+		}
+		want := strings.Join(tt.bufs, "")
+		if have := strings.Join(writes, ""); n != len(want) || have != want {
+			t.Errorf("test %d: data mismatch: have %d bytes %q, want %q", i, n, have, want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Package zerousbtest provides helpers for testing code built on zerousb
+// without hardware attached.
+package zerousbtest
+
+import (
+	"encoding/binary"
+
+	"github.com/chay22/zerousb"
+)
+
+// DescriptorBuilder constructs the descriptors of a synthetic device, its
+// configurations, interfaces and endpoints, in the order they're added. The
+// descriptors feed the same enumeration logic as real devices through
+// zerousb.RawDescriptors.Interfaces, so matching code (class filters, endpoint
+// selection) can be tested against edge cases such as alternate settings
+// without endpoints.
+type DescriptorBuilder struct {
+	device  [18]byte
+	configs []*ConfigBuilder
+}
+
+// ConfigBuilder constructs a configuration of a synthetic device.
+type ConfigBuilder struct {
+	header [9]byte
+	descs  [][]byte // Interface, endpoint and class specific descriptors in order
+	ifaces map[uint8]bool
+}
+
+// InterfaceBuilder constructs an interface alternate setting of a synthetic
+// configuration.
+type InterfaceBuilder struct {
+	config *ConfigBuilder
+	header []byte // Interface descriptor, its endpoint count updated as they're added
+}
+
+// NewDescriptorBuilder starts a synthetic USB 2.0 device with the given ids,
+// with its class defined at the interface level.
+func NewDescriptorBuilder(vendorID, productID uint16) *DescriptorBuilder {
+	b := new(DescriptorBuilder)
+	b.device[0], b.device[1] = 18, byte(zerousb.DescriptorTypeDevice)
+	binary.LittleEndian.PutUint16(b.device[2:], 0x0200)
+	b.device[7] = 64
+	binary.LittleEndian.PutUint16(b.device[8:], vendorID)
+	binary.LittleEndian.PutUint16(b.device[10:], productID)
+	return b
+}
+
+// USBVersion sets the bcdUSB of the device, e.g. 0x0300 for SuperSpeed.
+func (b *DescriptorBuilder) USBVersion(version uint16) *DescriptorBuilder {
+	binary.LittleEndian.PutUint16(b.device[2:], version)
+	return b
+}
+
+// Release sets the bcdDevice of the device.
+func (b *DescriptorBuilder) Release(release uint16) *DescriptorBuilder {
+	binary.LittleEndian.PutUint16(b.device[12:], release)
+	return b
+}
+
+// Class sets the class, subclass and protocol of the device.
+func (b *DescriptorBuilder) Class(class, subClass, protocol uint8) *DescriptorBuilder {
+	b.device[4], b.device[5], b.device[6] = class, subClass, protocol
+	return b
+}
+
+// Strings sets the indexes of the manufacturer, product and serial number
+// string descriptors.
+func (b *DescriptorBuilder) Strings(manufacturer, product, serial uint8) *DescriptorBuilder {
+	b.device[14], b.device[15], b.device[16] = manufacturer, product, serial
+	return b
+}
+
+// Config adds a bus powered configuration drawing 100mA, identified by value.
+func (b *DescriptorBuilder) Config(value uint8) *ConfigBuilder {
+	c := &ConfigBuilder{ifaces: make(map[uint8]bool)}
+	c.header[0], c.header[1] = 9, byte(zerousb.DescriptorTypeConfig)
+	c.header[5], c.header[7], c.header[8] = value, 0x80, 50
+	b.configs = append(b.configs, c)
+	return c
+}
+
+// Power sets the power attributes of the configuration, with maxPower in the
+// raw units of bMaxPower.
+func (c *ConfigBuilder) Power(selfPowered, remoteWakeup bool, maxPower uint8) *ConfigBuilder {
+	c.header[7] = 0x80
+	if selfPowered {
+		c.header[7] |= 0x40
+	}
+	if remoteWakeup {
+		c.header[7] |= 0x20
+	}
+	c.header[8] = maxPower
+	return c
+}
+
+// Interface adds an alternate setting of an interface to the configuration,
+// with the given class, subclass and protocol. Endpoints are added to it
+// through the returned builder.
+func (c *ConfigBuilder) Interface(number, alternate uint8, class, subClass, protocol uint8) *InterfaceBuilder {
+	desc := []byte{9, byte(zerousb.DescriptorTypeInterface), number, alternate, 0, class, subClass, protocol, 0}
+	c.descs = append(c.descs, desc)
+	c.ifaces[number] = true
+	return &InterfaceBuilder{config: c, header: desc}
+}
+
+// Descriptor adds a raw descriptor (e.g. a class specific one) after the
+// descriptors added so far.
+func (c *ConfigBuilder) Descriptor(desc []byte) *ConfigBuilder {
+	c.descs = append(c.descs, append([]byte{}, desc...))
+	return c
+}
+
+// Endpoint adds an endpoint to the alternate setting. The address includes the
+// direction bit, the interval is the raw bInterval.
+func (i *InterfaceBuilder) Endpoint(address uint8, kind zerousb.TransferType, maxPacketSize uint16, interval uint8) *InterfaceBuilder {
+	desc := []byte{7, byte(zerousb.DescriptorTypeEndpoint), address, byte(kind), 0, 0, interval}
+	binary.LittleEndian.PutUint16(desc[4:], maxPacketSize)
+	i.config.descs = append(i.config.descs, desc)
+	i.header[4]++
+	return i
+}
+
+// Companion adds a SuperSpeed endpoint companion to the last endpoint added.
+func (i *InterfaceBuilder) Companion(maxBurst, attributes uint8, bytesPerInterval uint16) *InterfaceBuilder {
+	desc := []byte{6, byte(zerousb.DescriptorTypeSSEndpointCompanion), maxBurst, attributes, 0, 0}
+	binary.LittleEndian.PutUint16(desc[4:], bytesPerInterval)
+	i.config.descs = append(i.config.descs, desc)
+	return i
+}
+
+// Descriptor adds a raw descriptor (e.g. a class specific one) after the
+// descriptors added so far.
+func (i *InterfaceBuilder) Descriptor(desc []byte) *InterfaceBuilder {
+	i.config.Descriptor(desc)
+	return i
+}
+
+// bytes assembles the full configuration descriptor hierarchy.
+func (c *ConfigBuilder) bytes() []byte {
+	config := append([]byte{}, c.header[:]...)
+	for _, desc := range c.descs {
+		config = append(config, desc...)
+	}
+	binary.LittleEndian.PutUint16(config[2:], uint16(len(config)))
+	config[4] = byte(len(c.ifaces))
+	return config
+}
+
+// Build assembles the descriptors of the device.
+func (b *DescriptorBuilder) Build() *zerousb.RawDescriptors {
+	device := b.device
+	device[17] = byte(len(b.configs))
+
+	raw := &zerousb.RawDescriptors{Device: device[:]}
+	for _, c := range b.configs {
+		raw.Configs = append(raw.Configs, c.bytes())
+	}
+	return raw
+}
+
+// Interfaces describes the interfaces enumeration reports for the device, see
+// zerousb.RawDescriptors.Interfaces.
+func (b *DescriptorBuilder) Interfaces(hid bool) ([]zerousb.DeviceInfo, error) {
+	return b.Build().Interfaces(hid)
+}
@@ -0,0 +1,80 @@
+package zerousbtest
+
+import (
+	"testing"
+
+	"github.com/chay22/zerousb"
+)
+
+// Tests that synthetic descriptors are described like enumerated devices,
+// skipping interfaces without endpoints in both directions and HID ones.
+func TestDescriptorBuilder(t *testing.T) {
+	b := NewDescriptorBuilder(0x1209, 0x53c1).Release(0x0100).Strings(1, 2, 3)
+	cfg := b.Config(1).Power(true, false, 0)
+	cfg.Interface(0, 0, uint8(zerousb.ClassVendorSpec), 0, 0) // No endpoints
+	cfg.Interface(0, 1, uint8(zerousb.ClassVendorSpec), 0, 0).
+		Endpoint(0x81, zerousb.TransferTypeBulk, 512, 0).
+		Endpoint(0x01, zerousb.TransferTypeBulk, 512, 0)
+	cfg.Interface(1, 0, uint8(zerousb.ClassHID), 0, 0).
+		Descriptor([]byte{9, byte(zerousb.DescriptorTypeHID), 0x11, 0x01, 0, 1, 0x22, 34, 0}).
+		Endpoint(0x82, zerousb.TransferTypeInterrupt, 64, 1).
+		Endpoint(0x02, zerousb.TransferTypeInterrupt, 64, 1)
+
+	raw := b.Build()
+	if len(raw.Device) != 18 || raw.Device[17] != 1 {
+		t.Fatalf("device descriptor mismatch: have %x", raw.Device)
+	}
+	if have, want := int(raw.Configs[0][2]), len(raw.Configs[0]); have != want {
+		t.Errorf("total length mismatch: have %d, want %d", have, want)
+	}
+	if raw.Configs[0][4] != 2 {
+		t.Errorf("interface count mismatch: have %d, want 2", raw.Configs[0][4])
+	}
+
+	infos, err := b.Interfaces(false)
+	if err != nil {
+		t.Fatalf("failed to describe interfaces: %v", err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("interface count mismatch: have %d, want 1", len(infos))
+	}
+	info := infos[0]
+	if info.VendorID != 0x1209 || info.ProductID != 0x53c1 || info.Release != 0x0100 || info.SerialIndex != 3 {
+		t.Errorf("device fields mismatch: have %+v", info)
+	}
+	if info.InterfaceNumber != 0 || info.InterfaceAlternate != 1 || len(info.Endpoints) != 2 {
+		t.Errorf("interface fields mismatch: have %+v", info)
+	}
+	if !info.Config.SelfPowered || info.Config.Value != 1 {
+		t.Errorf("config fields mismatch: have %+v", info.Config)
+	}
+
+	infos, err = b.Interfaces(true)
+	if err != nil {
+		t.Fatalf("failed to describe interfaces: %v", err)
+	}
+	if len(infos) != 2 || infos[1].InterfaceClass != uint8(zerousb.ClassHID) {
+		t.Fatalf("HID interface not described: have %+v", infos)
+	}
+}
+
+// Tests that SuperSpeed companions attach to the endpoint they follow.
+func TestDescriptorBuilderCompanion(t *testing.T) {
+	b := NewDescriptorBuilder(0x1209, 0x0001).USBVersion(0x0300)
+	b.Config(1).Interface(0, 0, uint8(zerousb.ClassVendorSpec), 0, 0).
+		Endpoint(0x81, zerousb.TransferTypeBulk, 1024, 0).Companion(15, 4, 0).
+		Endpoint(0x01, zerousb.TransferTypeBulk, 1024, 0).Companion(15, 4, 0)
+
+	infos, err := b.Interfaces(false)
+	if err != nil {
+		t.Fatalf("failed to describe interfaces: %v", err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("interface count mismatch: have %d, want 1", len(infos))
+	}
+	for _, end := range infos[0].Endpoints {
+		if end.Companion == nil || end.Companion.MaxBurst != 15 || end.Companion.MaxStreams() != 16 {
+			t.Errorf("endpoint %#02x companion mismatch: have %+v", end.Address, end.Companion)
+		}
+	}
+}
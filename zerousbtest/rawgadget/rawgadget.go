@@ -0,0 +1,530 @@
+//go:build linux
+
+// Package rawgadget emulates USB devices through the Linux raw-gadget
+// interface, so the full zerousb stack can be tested end to end against a
+// virtual device: enumeration, claims and transfers run through the kernel and
+// libusb exactly like with hardware attached.
+//
+// Devices are bound to the dummy_hcd virtual host controller by default, which
+// loops them back to the host running the tests. Both modules need to be
+// loaded beforehand, e.g. with:
+//
+//	modprobe dummy_hcd
+//	modprobe raw_gadget
+//
+// The gadget answers the standard requests of the host from the descriptors
+// it's started with, typically built by zerousbtest.DescriptorBuilder. Other
+// control requests are handed to Options.Control.
+package rawgadget
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/chay22/zerousb"
+	"github.com/chay22/zerousb/gadget"
+)
+
+// Path is the raw-gadget device file.
+const Path = "/dev/raw-gadget"
+
+// ioctl requests of raw-gadget, from linux/usb/raw_gadget.h.
+const (
+	ioctlInit       = 0x41015500 // _IOW('U', 0, struct usb_raw_init)
+	ioctlRun        = 0x00005501 // _IO('U', 1)
+	ioctlEventFetch = 0x80085502 // _IOR('U', 2, struct usb_raw_event)
+	ioctlEP0Write   = 0x40085503 // _IOW('U', 3, struct usb_raw_ep_io)
+	ioctlEP0Read    = 0xc0085504 // _IOWR('U', 4, struct usb_raw_ep_io)
+	ioctlEPEnable   = 0x40095505 // _IOW('U', 5, struct usb_endpoint_descriptor)
+	ioctlEPDisable  = 0x40045506 // _IOW('U', 6, __u32)
+	ioctlEPWrite    = 0x40085507 // _IOW('U', 7, struct usb_raw_ep_io)
+	ioctlEPRead     = 0xc0085508 // _IOWR('U', 8, struct usb_raw_ep_io)
+	ioctlConfigure  = 0x00005509 // _IO('U', 9)
+	ioctlVbusDraw   = 0x4004550a // _IOW('U', 10, __u32)
+	ioctlEP0Stall   = 0x0000550c // _IO('U', 12)
+)
+
+// eventControl is the raw-gadget event type of control requests, from enum
+// usb_raw_event_type.
+const eventControl = 2
+
+// Standard requests and descriptor types answered by the gadget itself.
+const (
+	requestGetStatus        = 0x00
+	requestGetDescriptor    = 0x06
+	requestGetConfiguration = 0x08
+	requestSetConfiguration = 0x09
+	requestGetInterface     = 0x0a
+	requestSetInterface     = 0x0b
+
+	descriptorTypeDeviceQualifier = 0x06
+
+	controlTypeMask = 0x60 // Type bits of bmRequestType, zero for standard requests
+)
+
+// udcNameSize is the size of the driver and device names of usb_raw_init.
+const udcNameSize = 128
+
+// Options configures the virtual device controller a gadget binds to and the
+// requests it answers beyond the standard ones.
+type Options struct {
+	Driver string        // UDC driver name, dummy_udc by default
+	Device string        // UDC device name, dummy_udc.0 by default
+	Speed  zerousb.Speed // Speed to connect at, high speed by default
+
+	Strings []string // String descriptors, from index 1 in order
+
+	// Control answers non standard control requests, with the data stage of
+	// OUT requests in data. The returned data is sent back for IN requests.
+	// Requests are stalled if it's nil or fails.
+	Control func(setup gadget.Setup, data []byte) ([]byte, error)
+}
+
+// Gadget is a virtual device connected through raw-gadget.
+type Gadget struct {
+	file    *os.File
+	raw     *zerousb.RawDescriptors
+	options Options
+
+	config     int             // Value of the active configuration, zero if unconfigured
+	handles    map[uint8]int   // Raw-gadget handles of the enabled endpoints
+	ifaces     map[uint8]uint8 // Active alternate setting of each interface
+	configured chan struct{}   // Closed once the host configured the device
+	err        error           // Failure which stopped the event loop
+	lock       sync.Mutex
+}
+
+// Start connects a virtual device with the given descriptors and starts
+// answering the requests of the host in the background. It fails if the
+// raw-gadget module isn't loaded.
+func Start(raw *zerousb.RawDescriptors, options *Options) (*Gadget, error) {
+	if len(raw.Device) < 18 {
+		return nil, fmt.Errorf("short device descriptor: %d bytes", len(raw.Device))
+	}
+	g := &Gadget{
+		raw:        raw,
+		handles:    make(map[uint8]int),
+		ifaces:     make(map[uint8]uint8),
+		configured: make(chan struct{}),
+	}
+	if options != nil {
+		g.options = *options
+	}
+	if g.options.Driver == "" {
+		g.options.Driver = "dummy_udc"
+	}
+	if g.options.Device == "" {
+		g.options.Device = "dummy_udc.0"
+	}
+	if g.options.Speed == zerousb.SpeedUnknown {
+		g.options.Speed = zerousb.SpeedHigh
+	}
+	file, err := os.OpenFile(Path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open raw-gadget: %w", err)
+	}
+	g.file = file
+
+	init := make([]byte, 2*udcNameSize+1)
+	copy(init[:udcNameSize-1], g.options.Driver)
+	copy(init[udcNameSize:2*udcNameSize-1], g.options.Device)
+	init[2*udcNameSize] = kernelSpeed(g.options.Speed)
+	if _, err := g.ioctl(ioctlInit, init); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to init raw-gadget: %w", err)
+	}
+	if _, err := g.ioctl(ioctlRun, nil); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to run raw-gadget: %w", err)
+	}
+	go g.loop()
+	return g, nil
+}
+
+// kernelSpeed converts a speed into the one of enum usb_device_speed, which
+// counts wireless USB in between high and super speed.
+func kernelSpeed(speed zerousb.Speed) uint8 {
+	if speed >= zerousb.SpeedSuper {
+		return uint8(speed) + 1
+	}
+	return uint8(speed)
+}
+
+// Close disconnects the virtual device. Endpoint transfers blocked waiting for
+// the host are only released once it disconnected, so Close should be called
+// after the host side of a test is done.
+func (g *Gadget) Close() error {
+	return g.file.Close()
+}
+
+// Configured returns a channel closed once the host selected a configuration,
+// after which the endpoints of its interfaces can transfer.
+func (g *Gadget) Configured() <-chan struct{} {
+	return g.configured
+}
+
+// Err returns the failure which stopped answering the host, nil if none did.
+func (g *Gadget) Err() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	return g.err
+}
+
+// Read receives data the host sent to an OUT endpoint, blocking until it does.
+func (g *Gadget) Read(endpoint uint8, b []byte) (int, error) {
+	if endpoint&zerousb.ControlIn != 0 {
+		return 0, fmt.Errorf("endpoint %#02x is not an out endpoint", endpoint)
+	}
+	handle, err := g.handle(endpoint)
+	if err != nil {
+		return 0, err
+	}
+	io := encodeIO(handle, make([]byte, len(b)))
+	n, err := g.ioctl(ioctlEPRead, io)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read endpoint %#02x: %w", endpoint, err)
+	}
+	return copy(b, io[8:8+n]), nil
+}
+
+// Write sends data to the host through an IN endpoint, blocking until the host
+// reads it.
+func (g *Gadget) Write(endpoint uint8, b []byte) (int, error) {
+	if endpoint&zerousb.ControlIn == 0 {
+		return 0, fmt.Errorf("endpoint %#02x is not an in endpoint", endpoint)
+	}
+	handle, err := g.handle(endpoint)
+	if err != nil {
+		return 0, err
+	}
+	n, err := g.ioctl(ioctlEPWrite, encodeIO(handle, b))
+	if err != nil {
+		return 0, fmt.Errorf("failed to write endpoint %#02x: %w", endpoint, err)
+	}
+	return n, nil
+}
+
+// handle returns the raw-gadget handle of an enabled endpoint.
+func (g *Gadget) handle(endpoint uint8) (int, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	handle, ok := g.handles[endpoint]
+	if !ok {
+		return 0, fmt.Errorf("endpoint %#02x not enabled", endpoint)
+	}
+	return handle, nil
+}
+
+// ioctl issues a raw-gadget request with arg as its argument, returning the
+// non negative result of the call.
+func (g *Gadget) ioctl(request uintptr, arg []byte) (int, error) {
+	var ptr uintptr
+	if len(arg) > 0 {
+		ptr = uintptr(unsafe.Pointer(&arg[0]))
+	}
+	r, _, errno := syscall.Syscall(syscall.SYS_IOCTL, g.file.Fd(), request, ptr)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
+
+// ioctlValue issues a raw-gadget request with an integer argument.
+func (g *Gadget) ioctlValue(request uintptr, value uint32) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, g.file.Fd(), request, uintptr(value))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// encodeIO builds a struct usb_raw_ep_io carrying data, in the byte order of
+// the host, little endian on the platforms dummy_hcd is used on.
+func encodeIO(handle int, data []byte) []byte {
+	io := make([]byte, 8+len(data))
+	binary.LittleEndian.PutUint16(io, uint16(handle))
+	binary.LittleEndian.PutUint32(io[4:], uint32(len(data)))
+	copy(io[8:], data)
+	return io
+}
+
+// loop fetches and answers the events of the host until the gadget is closed.
+func (g *Gadget) loop() {
+	for {
+		event := make([]byte, 8+8)
+		binary.LittleEndian.PutUint32(event[4:], 8)
+		if _, err := g.ioctl(ioctlEventFetch, event); err != nil {
+			g.stop(fmt.Errorf("failed to fetch event: %w", err))
+			return
+		}
+		// Connections, resets and suspends need no answer, endpoints are
+		// enabled again by the next SET_CONFIGURATION
+		if binary.LittleEndian.Uint32(event) == eventControl {
+			setup := gadget.Setup{
+				RequestType: event[8],
+				Request:     event[9],
+				Value:       binary.LittleEndian.Uint16(event[10:]),
+				Index:       binary.LittleEndian.Uint16(event[12:]),
+				Length:      binary.LittleEndian.Uint16(event[14:]),
+			}
+			if err := g.control(setup); err != nil {
+				g.stop(err)
+				return
+			}
+		}
+	}
+}
+
+// stop records the failure which stopped the event loop, unless it's the
+// closing of the gadget.
+func (g *Gadget) stop(err error) {
+	if errors.Is(err, os.ErrClosed) || errors.Is(err, syscall.EBADF) {
+		return
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.err = err
+}
+
+// control answers a control request of the host, stalling the ones it can't.
+func (g *Gadget) control(setup gadget.Setup) error {
+	var data []byte
+	if !setup.In() && setup.Length > 0 {
+		io := encodeIO(0, make([]byte, setup.Length))
+		n, err := g.ioctl(ioctlEP0Read, io)
+		if err != nil {
+			return fmt.Errorf("failed to read setup data: %w", err)
+		}
+		data = io[8 : 8+n]
+	}
+	reply, err := g.answer(setup, data)
+	if err != nil {
+		// The data stage of OUT requests already completed, they can't be
+		// stalled anymore
+		if !setup.In() && setup.Length > 0 {
+			return nil
+		}
+		if err := g.ioctlValue(ioctlEP0Stall, 0); err != nil {
+			return fmt.Errorf("failed to stall setup: %w", err)
+		}
+		return nil
+	}
+	if setup.In() {
+		if len(reply) > int(setup.Length) {
+			reply = reply[:setup.Length]
+		}
+		if _, err := g.ioctl(ioctlEP0Write, encodeIO(0, reply)); err != nil {
+			return fmt.Errorf("failed to reply to setup: %w", err)
+		}
+		return nil
+	}
+	// Requests without a data stage are acknowledged by an empty read
+	if setup.Length == 0 {
+		if _, err := g.ioctl(ioctlEP0Read, encodeIO(0, nil)); err != nil {
+			return fmt.Errorf("failed to acknowledge setup: %w", err)
+		}
+	}
+	return nil
+}
+
+// answer handles a control request, returning the data to reply with.
+func (g *Gadget) answer(setup gadget.Setup, data []byte) ([]byte, error) {
+	if setup.RequestType&controlTypeMask != 0 {
+		if g.options.Control == nil {
+			return nil, errors.New("unhandled request")
+		}
+		return g.options.Control(setup, data)
+	}
+	switch setup.Request {
+	case requestGetDescriptor:
+		return g.descriptor(uint8(setup.Value>>8), uint8(setup.Value))
+
+	case requestGetStatus:
+		return []byte{0, 0}, nil
+
+	case requestGetConfiguration:
+		g.lock.Lock()
+		defer g.lock.Unlock()
+		return []byte{uint8(g.config)}, nil
+
+	case requestSetConfiguration:
+		return nil, g.configure(int(setup.Value))
+
+	case requestGetInterface:
+		g.lock.Lock()
+		defer g.lock.Unlock()
+		return []byte{g.ifaces[uint8(setup.Index)]}, nil
+
+	case requestSetInterface:
+		return nil, g.setInterface(uint8(setup.Index), uint8(setup.Value))
+	}
+	if g.options.Control == nil {
+		return nil, errors.New("unhandled request")
+	}
+	return g.options.Control(setup, data)
+}
+
+// descriptor returns a descriptor requested by the host.
+func (g *Gadget) descriptor(kind, index uint8) ([]byte, error) {
+	switch zerousb.DescriptorType(kind) {
+	case zerousb.DescriptorTypeDevice:
+		return g.raw.Device, nil
+
+	case zerousb.DescriptorTypeConfig:
+		if int(index) >= len(g.raw.Configs) {
+			return nil, fmt.Errorf("config %d not found", index)
+		}
+		return g.raw.Configs[index], nil
+
+	case zerousb.DescriptorTypeString:
+		if index == 0 {
+			return []byte{4, byte(zerousb.DescriptorTypeString), 0x09, 0x04}, nil // en-US
+		}
+		if int(index) > len(g.options.Strings) {
+			return nil, fmt.Errorf("string %d not found", index)
+		}
+		desc := []byte{0, byte(zerousb.DescriptorTypeString)}
+		for _, r := range g.options.Strings[index-1] {
+			desc = append(desc, uint8(r), uint8(r>>8))
+		}
+		desc[0] = uint8(len(desc))
+		return desc, nil
+
+	case descriptorTypeDeviceQualifier:
+		// Same device at the other speed: version, class, packet size and
+		// configuration count of the device descriptor
+		dev := g.raw.Device
+		return []byte{10, descriptorTypeDeviceQualifier, dev[2], dev[3], dev[4], dev[5], dev[6], dev[7], dev[17], 0}, nil
+	}
+	return nil, fmt.Errorf("descriptor type %#02x not supported", kind)
+}
+
+// configure selects a configuration, enabling the endpoints of the first
+// alternate setting of its interfaces.
+func (g *Gadget) configure(value int) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	for endpoint, handle := range g.handles {
+		g.ioctlValue(ioctlEPDisable, uint32(handle))
+		delete(g.handles, endpoint)
+	}
+	g.config, g.ifaces = 0, make(map[uint8]uint8)
+	if value == 0 {
+		return nil
+	}
+	config, err := g.findConfig(value)
+	if err != nil {
+		return err
+	}
+	for _, desc := range splitDescriptors(config) {
+		if desc[1] == byte(zerousb.DescriptorTypeInterface) && len(desc) >= 9 {
+			g.ifaces[desc[2]] = 0
+		}
+	}
+	for iface := range g.ifaces {
+		if err := g.enable(config, iface, 0); err != nil {
+			return err
+		}
+	}
+	// Draw the power the configuration asks for, in the units of bMaxPower
+	if err := g.ioctlValue(ioctlVbusDraw, uint32(config[8])); err != nil {
+		return fmt.Errorf("failed to set vbus draw: %w", err)
+	}
+	if _, err := g.ioctl(ioctlConfigure, nil); err != nil {
+		return fmt.Errorf("failed to configure: %w", err)
+	}
+	g.config = value
+	select {
+	case <-g.configured:
+	default:
+		close(g.configured)
+	}
+	return nil
+}
+
+// setInterface selects an alternate setting of an interface, swapping the
+// endpoints of the previous one for its own.
+func (g *Gadget) setInterface(iface, alt uint8) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	config, err := g.findConfig(g.config)
+	if err != nil {
+		return err
+	}
+	for _, endpoint := range endpoints(config, iface, g.ifaces[iface]) {
+		if handle, ok := g.handles[endpoint[2]]; ok {
+			g.ioctlValue(ioctlEPDisable, uint32(handle))
+			delete(g.handles, endpoint[2])
+		}
+	}
+	if err := g.enable(config, iface, alt); err != nil {
+		return err
+	}
+	g.ifaces[iface] = alt
+	return nil
+}
+
+// enable enables the endpoints of an interface alternate setting. The lock
+// must be held.
+func (g *Gadget) enable(config []byte, iface, alt uint8) error {
+	for _, endpoint := range endpoints(config, iface, alt) {
+		desc := make([]byte, 9) // struct usb_endpoint_descriptor, audio fields included
+		copy(desc, endpoint[:7])
+		handle, err := g.ioctl(ioctlEPEnable, desc)
+		if err != nil {
+			return fmt.Errorf("failed to enable endpoint %#02x: %w", endpoint[2], err)
+		}
+		g.handles[endpoint[2]] = handle
+	}
+	return nil
+}
+
+// findConfig returns the configuration descriptor hierarchy with a value.
+func (g *Gadget) findConfig(value int) ([]byte, error) {
+	for _, config := range g.raw.Configs {
+		if len(config) >= 9 && int(config[5]) == value {
+			return config, nil
+		}
+	}
+	return nil, fmt.Errorf("config %d not found", value)
+}
+
+// endpoints returns the endpoint descriptors of an interface alternate setting.
+func endpoints(config []byte, iface, alt uint8) [][]byte {
+	var (
+		descs   [][]byte
+		current bool
+	)
+	for _, desc := range splitDescriptors(config) {
+		switch zerousb.DescriptorType(desc[1]) {
+		case zerousb.DescriptorTypeInterface:
+			current = len(desc) >= 9 && desc[2] == iface && desc[3] == alt
+		case zerousb.DescriptorTypeEndpoint:
+			if current && len(desc) >= 7 {
+				descs = append(descs, desc)
+			}
+		}
+	}
+	return descs
+}
+
+// splitDescriptors splits a configuration descriptor hierarchy into its
+// descriptors, stopping at the first malformed one.
+func splitDescriptors(config []byte) [][]byte {
+	var descs [][]byte
+	for len(config) >= 2 && int(config[0]) >= 2 && int(config[0]) <= len(config) {
+		descs = append(descs, config[:config[0]])
+		config = config[config[0]:]
+	}
+	return descs
+}
@@ -0,0 +1,140 @@
+//go:build linux
+
+package rawgadget
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/chay22/zerousb"
+	"github.com/chay22/zerousb/zerousbtest"
+)
+
+// loopback builds the descriptors of a vendor specific device with a bulk
+// endpoint in each direction on an alternate setting, the default one having
+// none.
+func loopback() *zerousbtest.DescriptorBuilder {
+	b := zerousbtest.NewDescriptorBuilder(0x1209, 0x0001).Strings(1, 2, 0)
+	cfg := b.Config(1)
+	cfg.Interface(0, 0, uint8(zerousb.ClassVendorSpec), 0, 0)
+	cfg.Interface(0, 1, uint8(zerousb.ClassVendorSpec), 0, 0).
+		Endpoint(0x81, zerousb.TransferTypeBulk, 512, 0).
+		Endpoint(0x02, zerousb.TransferTypeBulk, 512, 0)
+	return b
+}
+
+// Tests that the standard descriptors are answered from the raw ones.
+func TestDescriptor(t *testing.T) {
+	raw := loopback().Build()
+	g := &Gadget{raw: raw, options: Options{Strings: []string{"zerousb", "loopback"}}}
+
+	tests := []struct {
+		kind  uint8
+		index uint8
+		want  []byte
+	}{
+		{uint8(zerousb.DescriptorTypeDevice), 0, raw.Device},
+		{uint8(zerousb.DescriptorTypeConfig), 0, raw.Configs[0]},
+		{uint8(zerousb.DescriptorTypeString), 0, []byte{4, 3, 0x09, 0x04}},
+		{uint8(zerousb.DescriptorTypeString), 2, []byte{18, 3, 'l', 0, 'o', 0, 'o', 0, 'p', 0, 'b', 0, 'a', 0, 'c', 0, 'k', 0}},
+	}
+	for i, tt := range tests {
+		have, err := g.descriptor(tt.kind, tt.index)
+		if err != nil {
+			t.Errorf("test %d: failed to get descriptor: %v", i, err)
+			continue
+		}
+		if !bytes.Equal(have, tt.want) {
+			t.Errorf("test %d: descriptor mismatch: have %x, want %x", i, have, tt.want)
+		}
+	}
+	if _, err := g.descriptor(uint8(zerousb.DescriptorTypeString), 3); err == nil {
+		t.Errorf("missing string descriptor found")
+	}
+	if _, err := g.descriptor(uint8(zerousb.DescriptorTypeConfig), 1); err == nil {
+		t.Errorf("missing config descriptor found")
+	}
+}
+
+// Tests that the endpoints of alternate settings are picked out of the
+// configuration.
+func TestEndpoints(t *testing.T) {
+	config := loopback().Build().Configs[0]
+
+	if have := endpoints(config, 0, 0); len(have) != 0 {
+		t.Errorf("default setting endpoints found: %x", have)
+	}
+	have := endpoints(config, 0, 1)
+	if len(have) != 2 || have[0][2] != 0x81 || have[1][2] != 0x02 {
+		t.Errorf("alternate setting endpoints mismatch: have %x", have)
+	}
+}
+
+// Tests enumeration, claims and transfers end to end against a loopback
+// gadget, skipped unless raw-gadget and dummy_hcd are loaded.
+func TestLoopback(t *testing.T) {
+	g, err := Start(loopback().Build(), &Options{Strings: []string{"zerousb", "loopback"}})
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		t.Skipf("raw-gadget not available: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("failed to start gadget: %v", err)
+	}
+	defer g.Close()
+
+	// Wait for the host to enumerate the gadget
+	var infos []zerousb.DeviceInfo
+	for deadline := time.Now().Add(5 * time.Second); len(infos) == 0; time.Sleep(100 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("gadget not enumerated, gadget error: %v", g.Err())
+		}
+		if infos, err = zerousb.Find(0x1209, 0x0001); err != nil {
+			t.Fatalf("failed to find gadget: %v", err)
+		}
+	}
+	if infos[0].InterfaceAlternate != 1 {
+		t.Errorf("alternate setting mismatch: have %d, want 1", infos[0].InterfaceAlternate)
+	}
+	dev, err := zerousb.Open(infos[0])
+	if err != nil {
+		t.Fatalf("failed to open gadget: %v", err)
+	}
+	defer dev.Close()
+
+	select {
+	case <-g.Configured():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("gadget not configured")
+	}
+	// Echo a single packet back through the gadget
+	go func() {
+		buf := make([]byte, 512)
+		n, err := g.Read(0x02, buf)
+		if err != nil {
+			return
+		}
+		g.Write(0x81, buf[:n])
+	}()
+	msg := []byte("hello zerousb")
+	if _, err := dev.WriteWithTimeout(msg, time.Second); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	buf := make([]byte, 512)
+	n, err := dev.ReadWithTimeout(buf, time.Second)
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if !bytes.Equal(buf[:n], msg) {
+		t.Errorf("echo mismatch: have %q, want %q", buf[:n], msg)
+	}
+	status, err := dev.Status()
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	if status.SelfPowered || status.RemoteWakeup {
+		t.Errorf("status mismatch: have %+v", status)
+	}
+}